@@ -0,0 +1,68 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"strings"
+)
+
+// An ArgsHook can change the arguments of every Bazel invocation right before
+// it runs, e.g. to add a --config, flags for stamping or a credential helper.
+type ArgsHook interface {
+	// BazelArgs is called with the Bazel command, e.g. "build", and all of the
+	// arguments Bazel is about to run with: the startup flags, the command,
+	// and its flags and targets. It returns the arguments to run Bazel with
+	// instead. args may be changed.
+	BazelArgs(command string, args []string) []string
+}
+
+// ArgsHookFunc turns a function into an ArgsHook.
+type ArgsHookFunc func(command string, args []string) []string
+
+func (f ArgsHookFunc) BazelArgs(command string, args []string) []string {
+	return f(command, args)
+}
+
+var argsHooks []ArgsHook
+
+// AddArgsHook passes the arguments of every Bazel invocation through hook,
+// after the hooks that were added before it. Hooks have to be added before
+// Bazel runs.
+func AddArgsHook(hook ArgsHook) {
+	argsHooks = append(argsHooks, hook)
+}
+
+var debugf = func(format string, args ...interface{}) {}
+
+// SetDebugLog makes the final command line of every Bazel invocation, after
+// the ArgsHooks changed it, go to f.
+func SetDebugLog(f func(format string, args ...interface{})) {
+	debugf = f
+}
+
+// hookArgs returns the arguments the ArgsHooks made of args.
+func hookArgs(command string, args []string) []string {
+	for _, hook := range argsHooks {
+		// Hooks get their own copy, since args may share its array with the
+		// startup flags.
+		args = hook.BazelArgs(command, append([]string(nil), args...))
+	}
+	return args
+}
+
+// logCommandLine logs the command line of cmd for debugging.
+func (b *bazel) logCommandLine() {
+	debugf("Running %s", strings.Join(b.cmd.Args, " "))
+}
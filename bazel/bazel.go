@@ -25,6 +25,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/analysis"
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
@@ -125,6 +127,38 @@ func findBazel() string {
 	return "bazel"
 }
 
+// A Lock serializes Bazel invocations, e.g. the ones of several processes that
+// share a workspace.
+type Lock interface {
+	// Acquire blocks until it is the caller's turn and returns the function
+	// that ends it. description is what is about to run.
+	Acquire(description string) (release func())
+}
+
+var lock Lock
+
+// SetLock makes every Bazel invocation hold l while it runs. nil, the
+// default, runs Bazel right away.
+func SetLock(l Lock) {
+	lock = l
+}
+
+var colorOutput = true
+
+// SetColor decides whether Bazel colors the output it writes to stdout and
+// stderr, unless it is given a --color flag. It does by default.
+func SetColor(enabled bool) {
+	colorOutput = enabled
+}
+
+var stderrWriter io.Writer = os.Stderr
+
+// SetStderr makes Bazel's stderr go to w, when it is written to stderr at
+// all, instead of to os.Stderr.
+func SetStderr(w io.Writer) {
+	stderrWriter = w
+}
+
 type Bazel interface {
 	SetArguments([]string)
 	SetStartupArgs([]string)
@@ -133,8 +167,10 @@ type Bazel interface {
 	Info() (map[string]string, error)
 	Query(args ...string) (*blaze_query.QueryResult, error)
 	CQuery(args ...string) (*analysis.CqueryResult, error)
+	CQueryFiles(args ...string) ([]string, error)
 	Build(args ...string) (*bytes.Buffer, error)
 	Test(args ...string) (*bytes.Buffer, error)
+	MobileInstall(args ...string) (*bytes.Buffer, error)
 	Run(args ...string) (*exec.Cmd, *bytes.Buffer, error)
 	Wait() error
 	Cancel()
@@ -149,8 +185,17 @@ type bazel struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	mu sync.Mutex // guards exited
+	// Closed once the running command exited, nil while none runs.
+	exited chan struct{}
+
 	writeToStderr bool
 	writeToStdout bool
+
+	// The writers handing the output of the command to outputLines, if any.
+	lineWriters []*lineWriter
+	// The Bazel command that runs, e.g. "build".
+	command string
 }
 
 func New() Bazel {
@@ -177,6 +222,7 @@ func (b *bazel) WriteToStdout(v bool) {
 
 func (b *bazel) newCommand(command string, args ...string) (*bytes.Buffer, *bytes.Buffer) {
 	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.command = command
 
 	args = append([]string{command}, args...)
 	args = append(b.startupArgs, args...)
@@ -189,28 +235,116 @@ func (b *bazel) newCommand(command string, args ...string) (*bytes.Buffer, *byte
 			}
 		}
 		if !containsColor {
-			args = append(args, "--color=yes")
+			if colorOutput {
+				args = append(args, "--color=yes")
+			} else {
+				args = append(args, "--color=no")
+			}
 		}
 	}
 
-	b.cmd = exec.CommandContext(b.ctx, findBazel(), args...)
+	args = hookArgs(command, args)
+	// Not exec.CommandContext, which kills the client and leaves the server
+	// running the command, see runInterruptible.
+	b.cmd = exec.Command(findBazel(), args...)
+	b.logCommandLine()
 
 	stdoutBuffer := new(bytes.Buffer)
 	stderrBuffer := new(bytes.Buffer)
+	var stdout, stderr []io.Writer
 	if b.writeToStdout {
-		b.cmd.Stdout = io.MultiWriter(os.Stdout, stdoutBuffer)
-	} else {
-		b.cmd.Stdout = stdoutBuffer
+		stdout = append(stdout, os.Stdout)
 	}
 	if b.writeToStderr {
-		b.cmd.Stderr = io.MultiWriter(os.Stderr, stderrBuffer)
-	} else {
-		b.cmd.Stderr = stderrBuffer
+		stderr = append(stderr, stderrWriter)
+	}
+	stdout = append(stdout, stdoutBuffer)
+	stderr = append(stderr, stderrBuffer)
+	b.lineWriters = nil
+	if streamsOutput(command) {
+		stdoutLines, stderrLines := newLineWriters(outputLines)
+		stdout = append(stdout, stdoutLines)
+		stderr = append(stderr, stderrLines)
+		b.lineWriters = []*lineWriter{stdoutLines, stderrLines}
 	}
+	b.cmd.Stdout = io.MultiWriter(stdout...)
+	b.cmd.Stderr = io.MultiWriter(stderr...)
 
 	return stdoutBuffer, stderrBuffer
 }
 
+// run runs the command created by newCommand, holding the lock while it does.
+func (b *bazel) run() error {
+	if lock != nil {
+		defer lock.Acquire(strings.Join(b.cmd.Args[1:], " "))()
+	}
+	timedOut := b.startTimeout()
+	err := b.runInterruptible()
+	for _, w := range b.lineWriters {
+		w.Flush()
+	}
+	return timedOut(err)
+}
+
+var cancelGracePeriod = 10 * time.Second
+
+// SetCancelGracePeriod sets how long a cancelled command may take to stop
+// before it is killed.
+func SetCancelGracePeriod(d time.Duration) {
+	cancelGracePeriod = d
+}
+
+// runInterruptible runs the command like b.cmd.Run, but interrupts it once
+// b.ctx is done. Like Ctrl-C, an interrupt makes the Bazel client ask the
+// server to cancel the command and wait until it did, so that the server
+// releases its lock for the next command. If the client doesn't exit within
+// the cancel grace period, it is killed. The server can then still be
+// cancelling the command, and the next command blocks on its lock until it
+// is done; there is no way to wait for that from the outside.
+func (b *bazel) runInterruptible() error {
+	if b.ctx == nil {
+		return b.cmd.Run()
+	}
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+	if err := b.cmd.Start(); err != nil {
+		return err
+	}
+
+	gracePeriod := cancelGracePeriod
+	exited := make(chan struct{})
+	b.mu.Lock()
+	b.exited = exited
+	b.mu.Unlock()
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-b.ctx.Done():
+		}
+		debugf("Interrupting %s", strings.Join(b.cmd.Args, " "))
+		if err := b.cmd.Process.Signal(os.Interrupt); err != nil {
+			// Windows can't send interrupts to other processes.
+			b.cmd.Process.Kill()
+			return
+		}
+		select {
+		case <-exited:
+		case <-time.After(gracePeriod):
+			debugf("`bazel %s` didn't stop within %s of being interrupted, killing it. The server may still be running it", b.command, gracePeriod)
+			b.cmd.Process.Kill()
+		}
+	}()
+
+	err := b.cmd.Wait()
+	b.mu.Lock()
+	close(exited)
+	b.exited = nil
+	b.mu.Unlock()
+	return err
+}
+
 // Displays information about the state of the bazel process in the
 // form of several "key: value" pairs.  This includes the locations of
 // several output directories.  Because some of the
@@ -230,7 +364,7 @@ func (b *bazel) Info() (map[string]string, error) {
 	b.WriteToStdout(false)
 	stdoutBuffer, _ := b.newCommand("info")
 
-	err := b.cmd.Run()
+	err := b.run()
 	if err != nil {
 		return nil, err
 	}
@@ -269,20 +403,33 @@ func (b *bazel) processInfo(info string) (map[string]string, error) {
 //   res, err := b.Query('somepath(//path/to/package:target, //dependency)')
 func (b *bazel) Query(args ...string) (*blaze_query.QueryResult, error) {
 	blazeArgs := append([]string(nil), "--output=proto", "--order_output=no", "--color=no")
+	blazeArgs = append(blazeArgs, b.args...)
 	blazeArgs = append(blazeArgs, args...)
 
 	b.WriteToStderr(true)
 	b.WriteToStdout(false)
-	stdoutBuffer, _ := b.newCommand("query", blazeArgs...)
+	stdoutBuffer, stderrBuffer := b.newCommand("query", blazeArgs...)
 
-	err := b.cmd.Run()
+	err := b.run()
 
 	if err != nil {
-		return nil, err
+		return nil, &QueryError{Err: err, Stderr: stderrBuffer.Bytes()}
 	}
 	return b.processQuery(stdoutBuffer.Bytes())
 }
 
+// QueryError is returned by Query when Bazel couldn't evaluate the query.
+type QueryError struct {
+	Err error
+	// What Bazel printed while evaluating the query, which explains why it
+	// failed.
+	Stderr []byte
+}
+
+func (e *QueryError) Error() string {
+	return e.Err.Error()
+}
+
 func (b *bazel) processQuery(out []byte) (*blaze_query.QueryResult, error) {
 	var qr blaze_query.QueryResult
 	if err := proto.Unmarshal(out, &qr); err != nil {
@@ -309,13 +456,14 @@ func (b *bazel) processQuery(out []byte) (*blaze_query.QueryResult, error) {
 //   res, err := b.CQuery('somepath(//path/to/package:target, //dependency)')
 func (b *bazel) CQuery(args ...string) (*analysis.CqueryResult, error) {
 	blazeArgs := append([]string(nil), "--output=proto", "--color=no")
+	blazeArgs = append(blazeArgs, b.args...)
 	blazeArgs = append(blazeArgs, args...)
 
 	b.WriteToStderr(true)
 	b.WriteToStdout(false)
 	stdoutBuffer, _ := b.newCommand("cquery", blazeArgs...)
 
-	err := b.cmd.Run()
+	err := b.run()
 
 	if err != nil {
 		return nil, err
@@ -333,9 +481,34 @@ func (b *bazel) processCQuery(out []byte) (*analysis.CqueryResult, error) {
 	return &qr, nil
 }
 
+// CQueryFiles returns the output files of the targets the cquery expression
+// matches, relative to the execution root, as printed by
+// `bazel cquery --output=files`. What Bazel prints while it runs the cquery
+// isn't written to stderr, a *QueryError holds it if the cquery fails.
+func (b *bazel) CQueryFiles(args ...string) ([]string, error) {
+	blazeArgs := append([]string(nil), "--output=files", "--color=no")
+	blazeArgs = append(blazeArgs, b.args...)
+	blazeArgs = append(blazeArgs, args...)
+
+	b.WriteToStderr(false)
+	b.WriteToStdout(false)
+	stdoutBuffer, stderrBuffer := b.newCommand("cquery", blazeArgs...)
+
+	if err := b.run(); err != nil {
+		return nil, &QueryError{Err: err, Stderr: stderrBuffer.Bytes()}
+	}
+	var files []string
+	for _, line := range strings.Split(stdoutBuffer.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
 func (b *bazel) Build(args ...string) (*bytes.Buffer, error) {
 	stdoutBuffer, stderrBuffer := b.newCommand("build", append(b.args, args...)...)
-	err := b.cmd.Run()
+	err := b.run()
 
 	_, _ = stdoutBuffer.Write(stderrBuffer.Bytes())
 	return stdoutBuffer, err
@@ -343,7 +516,17 @@ func (b *bazel) Build(args ...string) (*bytes.Buffer, error) {
 
 func (b *bazel) Test(args ...string) (*bytes.Buffer, error) {
 	stdoutBuffer, stderrBuffer := b.newCommand("test", append(b.args, args...)...)
-	err := b.cmd.Run()
+	err := b.run()
+
+	_, _ = stdoutBuffer.Write(stderrBuffer.Bytes())
+	return stdoutBuffer, err
+}
+
+// Build the specified targets and install them on the connected device or
+// emulator.
+func (b *bazel) MobileInstall(args ...string) (*bytes.Buffer, error) {
+	stdoutBuffer, stderrBuffer := b.newCommand("mobile-install", append(b.args, args...)...)
+	err := b.run()
 
 	_, _ = stdoutBuffer.Write(stderrBuffer.Bytes())
 	return stdoutBuffer, err
@@ -358,7 +541,7 @@ func (b *bazel) Run(args ...string) (*exec.Cmd, *bytes.Buffer, error) {
 
 	_, _ = stdoutBuffer.Write(stderrBuffer.Bytes())
 
-	err := b.cmd.Run()
+	err := b.run()
 	if err != nil {
 		return nil, stderrBuffer, err
 	}
@@ -377,11 +560,21 @@ func (b *bazel) Wait() error {
 }
 
 // Cancel the currently running operation. Useful if you call Run(target) and
-// would like to stop the action running in a goroutine.
+// would like to stop the action running in a goroutine. A running build or
+// test is interrupted, and Cancel returns once its client exited. That
+// normally means that the Bazel server is free for the next command, but not
+// when the client had to be killed after the grace period, see
+// SetCancelGracePeriod.
 func (b *bazel) Cancel() {
 	if b.cancel == nil {
 		return
 	}
 
+	b.mu.Lock()
+	exited := b.exited
+	b.mu.Unlock()
 	b.cancel()
+	if exited != nil {
+		<-exited
+	}
 }
@@ -16,11 +16,18 @@ package bazel
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -87,12 +94,191 @@ func TestWriteToStderrAndStdout(t *testing.T) {
 	}
 }
 
+func TestOutputLines(t *testing.T) {
+	var lines []string
+	stdout, stderr := newLineWriters(func(line string) { lines = append(lines, line) })
+
+	stdout.Write([]byte("Build \x1b[32mstarted\x1b[0m\nLoading: 0 packages\r"))
+	stderr.Write([]byte("ERROR: something broke\n"))
+	stdout.Write([]byte("\x1b[1A\x1b[KAnalyzing: 3 targets\n\n"))
+	stdout.Write([]byte("INFO: Build completed"))
+	stdout.Flush()
+	stderr.Flush()
+
+	want := []string{
+		"Build started",
+		"ERROR: something broke",
+		"Analyzing: 3 targets",
+		"INFO: Build completed",
+	}
+	if !reflect.DeepEqual(want, lines) {
+		t.Errorf("Wanted lines %q, got %q", want, lines)
+	}
+}
+
+func TestSetColor(t *testing.T) {
+	defer SetColor(true)
+
+	for _, color := range []bool{true, false} {
+		SetColor(color)
+		b := &bazel{}
+		b.WriteToStderr(true)
+		b.newCommand("build")
+		want := map[bool]string{true: "--color=yes", false: "--color=no"}[color]
+		if got := b.cmd.Args[len(b.cmd.Args)-1]; got != want {
+			t.Errorf("With SetColor(%t), the last flag is %q, want %q", color, got, want)
+		}
+	}
+
+	b := &bazel{}
+	b.WriteToStderr(true)
+	b.newCommand("build", "--color=auto")
+	if got := b.cmd.Args[len(b.cmd.Args)-1]; got != "--color=auto" {
+		t.Errorf("The --color flag given to Bazel was overridden with %q", got)
+	}
+}
+
+func TestArgsHook(t *testing.T) {
+	oldHooks, oldDebugf := argsHooks, debugf
+	defer func() { argsHooks, debugf = oldHooks, oldDebugf }()
+
+	var logged []string
+	SetDebugLog(func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	})
+	AddArgsHook(ArgsHookFunc(func(command string, args []string) []string {
+		if command != "build" {
+			return args
+		}
+		return append(args, "--config=ci")
+	}))
+	AddArgsHook(ArgsHookFunc(func(command string, args []string) []string {
+		args[0] = "--host_jvm_args=-Xmx1g"
+		return args
+	}))
+
+	b := &bazel{}
+	b.SetStartupArgs([]string{"--batch"})
+	b.newCommand("build", "//a")
+	want := []string{"--host_jvm_args=-Xmx1g", "build", "//a", "--config=ci"}
+	if got := b.cmd.Args[1:]; !reflect.DeepEqual(want, got) {
+		t.Errorf("Args = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual([]string{"--batch"}, b.startupArgs) {
+		t.Errorf("The hooks changed the startup flags to %q", b.startupArgs)
+	}
+	if len(logged) != 1 || !strings.HasSuffix(logged[0], "--host_jvm_args=-Xmx1g build //a --config=ci") {
+		t.Errorf("Logged %q, want the final command line", logged)
+	}
+
+	b.newCommand("query", "//a")
+	want = []string{"--host_jvm_args=-Xmx1g", "query", "//a"}
+	if got := b.cmd.Args[1:]; !reflect.DeepEqual(want, got) {
+		t.Errorf("Args = %q, want %q", got, want)
+	}
+}
+
+func TestStreamsOutput(t *testing.T) {
+	defer SetOutputLines(nil)
+
+	if streamsOutput("build") {
+		t.Errorf("Streamed the output without a function to hand it to")
+	}
+	SetOutputLines(func(string) {})
+	b := &bazel{}
+	b.newCommand("build")
+	if len(b.lineWriters) != 2 {
+		t.Errorf("Didn't stream the output of build")
+	}
+	b.newCommand("query")
+	if len(b.lineWriters) != 0 {
+		t.Errorf("Streamed the output of query")
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep isn't available on Windows")
+	}
+	SetCommandTimeout(50 * time.Millisecond)
+	defer SetCommandTimeout(0)
+
+	b := &bazel{command: "build"}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.cmd = exec.CommandContext(b.ctx, "sleep", "10")
+	err := b.run()
+	if !IsTimeout(err) {
+		t.Fatalf("Wanted a timeout, got %v", err)
+	}
+	if want := "`bazel build` didn't finish within 50ms and was interrupted"; err.Error() != want {
+		t.Errorf("Wanted %q, got %q", want, err.Error())
+	}
+
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.cmd = exec.CommandContext(b.ctx, "true")
+	if err := b.run(); err != nil {
+		t.Errorf("A command that finished in time failed: %v", err)
+	}
+}
+
 // Test that cancel doesn't NPE if there is no command running.
 func TestCancel(t *testing.T) {
 	b := New()
 	b.Cancel()
 }
 
+func TestCancelInterrupts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh isn't available on Windows")
+	}
+	SetCancelGracePeriod(5 * time.Second)
+	defer SetCancelGracePeriod(10 * time.Second)
+
+	for _, c := range []struct {
+		name   string
+		script string
+		output string
+	}{
+		{"stops when interrupted", `trap "echo interrupted; exit 8" INT; echo started; sleep 10 >/dev/null & wait`, "started\ninterrupted\n"},
+		// Like a Bazel client that hangs.
+		{"ignores interrupts", `trap "" INT; echo started; exec sleep 10`, "started\n"},
+	} {
+		if c.name == "ignores interrupts" {
+			SetCancelGracePeriod(50 * time.Millisecond)
+		}
+
+		b := &bazel{command: "build"}
+		b.ctx, b.cancel = context.WithCancel(context.Background())
+		b.cmd = exec.Command("sh", "-c", c.script)
+		r, w := io.Pipe()
+		stdout := &bytes.Buffer{}
+		b.cmd.Stdout = io.MultiWriter(w, stdout)
+
+		errs := make(chan error)
+		go func() { errs <- b.run() }()
+		// Wait for the traps to be set.
+		if _, err := r.Read(make([]byte, 8)); err != nil {
+			t.Fatal(err)
+		}
+		go io.Copy(ioutil.Discard, r)
+
+		start := time.Now()
+		b.Cancel()
+		if b.cmd.ProcessState == nil {
+			t.Errorf("%s: Cancel returned before the command stopped", c.name)
+		}
+		if err := <-errs; err == nil {
+			t.Errorf("%s: the cancelled command succeeded", c.name)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("%s: Cancel took %s", c.name, elapsed)
+		}
+		if stdout.String() != c.output {
+			t.Errorf("%s: output %q, want %q", c.name, stdout.String(), c.output)
+		}
+	}
+}
+
 var bazelNpmPathTests = []struct {
 	in string
 	out string
@@ -0,0 +1,90 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var outputLines func(line string)
+
+// SetOutputLines makes every Bazel build, test, mobile-install and run hand
+// each line of its output to f while it runs, without color codes. stdout and
+// stderr are interleaved, but f is never called concurrently. nil, the
+// default, only collects the output to return it.
+func SetOutputLines(f func(line string)) {
+	outputLines = f
+}
+
+// streamsOutput is whether the output of command is handed to outputLines.
+// The output of the others is meant for iBazel rather than the developer.
+func streamsOutput(command string) bool {
+	switch command {
+	case "info", "query", "cquery":
+		return false
+	}
+	return outputLines != nil
+}
+
+// lineWriter calls f with every complete line written to it. Progress that
+// Bazel redraws in place with carriage returns and escape sequences is reduced
+// to its last state, and lines left empty are dropped.
+type lineWriter struct {
+	mu      *sync.Mutex
+	f       func(line string)
+	partial []byte
+}
+
+func newLineWriters(f func(line string)) (*lineWriter, *lineWriter) {
+	mu := &sync.Mutex{}
+	return &lineWriter{mu: mu, f: f}, &lineWriter{mu: mu, f: f}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		end := bytes.IndexByte(w.partial, '\n')
+		if end < 0 {
+			break
+		}
+		w.emit(w.partial[:end])
+		w.partial = w.partial[end+1:]
+	}
+	return len(p), nil
+}
+
+// Flush hands the last line to f, even if it doesn't end in a newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.emit(w.partial)
+	w.partial = nil
+}
+
+func (w *lineWriter) emit(line []byte) {
+	plain := log.StripColor(string(line))
+	if i := strings.LastIndexByte(plain, '\r'); i >= 0 {
+		plain = plain[i+1:]
+	}
+	if strings.TrimSpace(plain) != "" {
+		w.f(plain)
+	}
+}
@@ -27,7 +27,9 @@ import (
 type MockBazel struct {
 	actions        [][]string
 	queryResponse  map[string]*blaze_query.QueryResult
+	queryError     map[string]error
 	cqueryResponse map[string]*analysis.CqueryResult
+	cqueryFiles    map[string][]string
 	args           []string
 	startupArgs    []string
 
@@ -59,9 +61,18 @@ func (b *MockBazel) AddQueryResponse(query string, res *blaze_query.QueryResult)
 	}
 	b.queryResponse[query] = res
 }
+func (b *MockBazel) AddQueryError(query string, err error) {
+	if b.queryError == nil {
+		b.queryError = map[string]error{}
+	}
+	b.queryError[query] = err
+}
 func (b *MockBazel) Query(args ...string) (*blaze_query.QueryResult, error) {
 	b.actions = append(b.actions, append([]string{"Query"}, args...))
 	query := args[0]
+	if err, ok := b.queryError[query]; ok {
+		return nil, err
+	}
 	res, ok := b.queryResponse[query]
 
 	if !ok || res == nil {
@@ -71,7 +82,7 @@ func (b *MockBazel) Query(args ...string) (*blaze_query.QueryResult, error) {
 	return res, nil
 }
 func (b *MockBazel) AddCQueryResponse(query string, res *analysis.CqueryResult) {
-	if b.queryResponse == nil {
+	if b.cqueryResponse == nil {
 		b.cqueryResponse = map[string]*analysis.CqueryResult{}
 	}
 	b.cqueryResponse[query] = res
@@ -87,6 +98,16 @@ func (b *MockBazel) CQuery(args ...string) (*analysis.CqueryResult, error) {
 
 	return res, nil
 }
+func (b *MockBazel) AddCQueryFilesResponse(query string, files []string) {
+	if b.cqueryFiles == nil {
+		b.cqueryFiles = map[string][]string{}
+	}
+	b.cqueryFiles[query] = files
+}
+func (b *MockBazel) CQueryFiles(args ...string) ([]string, error) {
+	b.actions = append(b.actions, append([]string{"CQueryFiles"}, args...))
+	return b.cqueryFiles[args[0]], nil
+}
 func (b *MockBazel) Build(args ...string) (*bytes.Buffer, error) {
 	b.actions = append(b.actions, append([]string{"Build"}, args...))
 	return nil, b.buildError
@@ -98,6 +119,10 @@ func (b *MockBazel) Test(args ...string) (*bytes.Buffer, error) {
 	b.actions = append(b.actions, append([]string{"Test"}, args...))
 	return nil, nil
 }
+func (b *MockBazel) MobileInstall(args ...string) (*bytes.Buffer, error) {
+	b.actions = append(b.actions, append([]string{"MobileInstall"}, args...))
+	return nil, nil
+}
 func (b *MockBazel) Run(args ...string) (*exec.Cmd, *bytes.Buffer, error) {
 	b.actions = append(b.actions, append([]string{"Run"}, args...))
 	return nil, nil, nil
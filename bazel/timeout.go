@@ -0,0 +1,74 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var commandTimeout time.Duration
+
+// SetCommandTimeout interrupts every Bazel command that runs for longer than
+// d, not counting the time spent waiting for the Lock. 0, the default, lets
+// commands run for as long as they take.
+func SetCommandTimeout(d time.Duration) {
+	commandTimeout = d
+}
+
+// A TimeoutError is returned by a command that was interrupted because it ran
+// for longer than the timeout given to SetCommandTimeout. The output it wrote
+// until then is returned as usual.
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("`bazel %s` didn't finish within %s and was interrupted", e.Command, e.Timeout)
+}
+
+// IsTimeout returns whether err is a TimeoutError, or a QueryError caused by
+// one.
+func IsTimeout(err error) bool {
+	if queryErr, ok := err.(*QueryError); ok {
+		err = queryErr.Err
+	}
+	_, ok := err.(*TimeoutError)
+	return ok
+}
+
+// startTimeout cancels the running command once it runs into the timeout. The
+// returned function stops the timer and returns the error the command should
+// fail with instead of err, if it timed out.
+func (b *bazel) startTimeout() func(err error) error {
+	if commandTimeout <= 0 || b.cancel == nil {
+		return func(err error) error { return err }
+	}
+	timeout, cancel := commandTimeout, b.cancel
+	var timedOut int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		cancel()
+	})
+	return func(err error) error {
+		timer.Stop()
+		if atomic.LoadInt32(&timedOut) == 0 {
+			return err
+		}
+		return &TimeoutError{Command: b.command, Timeout: timeout}
+	}
+}
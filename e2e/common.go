@@ -1,3 +1,7 @@
+// Package e2e starts ibazel against a scratch workspace created by
+// rules_go's bazel_testing and asserts on its output. It is used by iBazel's
+// own end-to-end tests and can be imported by rule authors to test their
+// ibazel_notify_changes and live reload integrations.
 package e2e
 
 import (
@@ -25,15 +29,36 @@ func GetPath(p string) string {
 	return path
 }
 
+// The ibazel binary built by this repository. Empty when it isn't in the
+// runfiles, e.g. in tests of other repositories that import this package.
 var ibazelPath = getiBazelPath()
 
+// SetIBazelPath overrides the ibazel binary that is started by the tester.
+// Tests outside of this repository can pass the rlocation of
+// @com_github_bazelbuild_bazel_watcher//ibazel here, or set $IBAZEL_PATH.
+func SetIBazelPath(path string) {
+	ibazelPath = path
+}
+
 func getiBazelPath() string {
+	if path := os.Getenv("IBAZEL_PATH"); path != "" {
+		return path
+	}
+
 	suffix := ""
 	// Windows expects executables to end in .exe
 	if runtime.GOOS == "windows" {
 		suffix = ".exe"
 	}
-	return GetPath(fmt.Sprintf("ibazel/%s_%s_pure_stripped/ibazel%s", runtime.GOOS, runtime.GOARCH, suffix))
+	path, err := bazel.Runfile(fmt.Sprintf("ibazel/%s_%s_pure_stripped/ibazel%s", runtime.GOOS, runtime.GOARCH, suffix))
+	if err != nil {
+		return ""
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return path
 }
 
 func Must(t *testing.T, e error) {
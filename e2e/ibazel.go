@@ -84,6 +84,26 @@ func (i *IBazelTester) RunWithBazelFixCommands(target string) {
 	})
 }
 
+// Start runs an arbitrary ibazel command, e.g. Start("test", []string{"//..."},
+// []string{"--run_output=false"}, nil). ibazel's own log is always written to
+// the file read by GetIBazelError.
+func (i *IBazelTester) Start(command string, targets []string, ibazelArgs []string, bazelArgs []string) {
+	i.t.Helper()
+	i.start(command, targets, append([]string{"--log_to_file=" + i.ibazelLogFile}, ibazelArgs...), bazelArgs)
+}
+
+func (i *IBazelTester) Test(bazelArgs []string, targets ...string) {
+	i.t.Helper()
+	i.Start("test", targets, []string{}, bazelArgs)
+}
+
+// ExpectStart waits for ibazel to (re)start the target of `ibazel run`. Call it
+// once per expected restart.
+func (i *IBazelTester) ExpectStart(delay ...time.Duration) {
+	i.t.Helper()
+	i.ExpectIBazelError("Starting\\.\\.\\.", delay...)
+}
+
 func (i *IBazelTester) GetOutput() string {
 	i.t.Helper()
 	return i.stdoutBuffer.String()
@@ -206,11 +226,23 @@ func (i *IBazelTester) Kill() {
 
 func (i *IBazelTester) build(target string, additionalArgs []string) {
 	i.t.Helper()
+	i.start("build", []string{target}, additionalArgs, []string{})
+}
+
+// start launches ibazel as `ibazel <ibazelArgs> <command> <targets> <bazelArgs>`.
+func (i *IBazelTester) start(command string, targets []string, ibazelArgs []string, bazelArgs []string) {
+	i.t.Helper()
+	if ibazelPath == "" {
+		i.t.Fatalf("Unable to find the ibazel binary, use e2e.SetIBazelPath or $IBAZEL_PATH")
+	}
+
 	args := []string{"--bazel_path=" + i.bazelPath()}
-	args = append(args, additionalArgs...)
-	args = append(args, "build")
-	args = append(args, target)
+	args = append(args, ibazelArgs...)
+	args = append(args, command)
+	args = append(args, targets...)
+	args = append(args, bazelArgs...)
 	i.cmd = exec.Command(ibazelPath, args...)
+	i.t.Logf("ibazel invoked as: %s", strings.Join(i.cmd.Args, " "))
 
 	i.stdoutBuffer = &Buffer{}
 	i.cmd.Stdout = i.stdoutBuffer
@@ -232,14 +264,6 @@ func (i *IBazelTester) checkExit() {
 func (i *IBazelTester) run(target string, bazelArgs []string, additionalArgs []string) {
 	i.t.Helper()
 
-	args := []string{"--bazel_path=" + i.bazelPath()}
-	args = append(args, additionalArgs...)
-	args = append(args, "run")
-	args = append(args, target)
-	args = append(args, bazelArgs...)
-	i.cmd = exec.Command(ibazelPath, args...)
-	i.t.Logf("ibazel invoked as: %s", strings.Join(i.cmd.Args, " "))
-
 	cmd := bazel_testing.BazelCmd("build", target)
 
 	var buildStdout, buildStderr bytes.Buffer
@@ -255,13 +279,5 @@ func (i *IBazelTester) run(target string, bazelArgs []string, additionalArgs []s
 		}
 	}
 
-	i.stdoutBuffer = &Buffer{}
-	i.cmd.Stdout = i.stdoutBuffer
-
-	i.stderrBuffer = &Buffer{}
-	i.cmd.Stderr = i.stderrBuffer
-
-	if err := i.cmd.Start(); err != nil {
-		i.t.Fatalf("Command: %s", i.cmd)
-	}
+	i.start("run", []string{target}, additionalArgs, bazelArgs)
 }
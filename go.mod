@@ -4,9 +4,9 @@ require (
 	github.com/bazelbuild/rules_go v0.22.1
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/protobuf v1.4.0
-	github.com/gorilla/websocket v1.4.1 // indirect
+	github.com/gorilla/websocket v1.4.1
 	github.com/jaschaephraim/lrserver v0.0.0-20171129202958-50d19f603f71
-	golang.org/x/sys v0.0.0-20191029155521-f43be2a4598c // indirect
+	golang.org/x/sys v0.0.0-20191029155521-f43be2a4598c
 )
 
 go 1.13
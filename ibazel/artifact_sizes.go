@@ -0,0 +1,83 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var (
+	reportArtifactSizes = flag.Bool("report_artifact_sizes", false, "After every successful command, look up the output files of its targets like --publish_artifacts does and report how their size changed, e.g. to keep an eye on the size of bundles")
+	artifactSizeWarning = flag.Float64("artifact_size_warning", 10, "With --report_artifact_sizes, warn when an output file grows by more than this many percent in a single build. 0 disables the warnings")
+)
+
+// sizeChange is how the size of an output file changed since the last command
+// that built it.
+type sizeChange struct {
+	path     string
+	old, new int64
+}
+
+// growth returns by how many percent the file grew, negative if it shrank.
+func (c sizeChange) growth() float64 {
+	if c.old == 0 {
+		return 0
+	}
+	return float64(c.new-c.old) * 100 / float64(c.old)
+}
+
+// reportSizes logs the output files whose size changed, with a warning for
+// the ones that grew by more than --artifact_size_warning.
+func (i *IBazel) reportSizes(changes []sizeChange) {
+	if !*reportArtifactSizes {
+		return
+	}
+	for _, c := range changes {
+		name := c.path
+		if rel, err := filepath.Rel(i.artifacts.execRoot, c.path); err == nil && i.artifacts.execRoot != "" {
+			name = filepath.ToSlash(rel)
+		}
+		if *artifactSizeWarning > 0 && c.growth() > *artifactSizeWarning {
+			log.Logf("Warning: %s grew by %.1f%%, from %s to %s", name, c.growth(), formatSize(c.old), formatSize(c.new))
+			continue
+		}
+		log.Logf("%s is %s (%s, %+.1f%%)", name, formatSize(c.new), formatSizeDelta(c.new-c.old), c.growth())
+	}
+}
+
+// formatSize returns a size in bytes in the largest unit it has a whole one
+// of.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20 || n <= -1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10 || n <= -1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// formatSizeDelta is formatSize with a sign.
+func formatSizeDelta(n int64) string {
+	if n >= 0 {
+		return "+" + formatSize(n)
+	}
+	return formatSize(n)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func TestIBazelReportArtifactSizes(t *testing.T) {
+	defer setFlag(t, "report_artifact_sizes", "true")()
+
+	execRoot, err := ioutil.TempDir("", "execroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(execRoot)
+	bin := filepath.Join(execRoot, "bazel-out", "bin", "app")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, size int) {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("bundle.js", 2000)
+	write("bundle.js.map", 100)
+
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddCQueryFilesResponse("//app:bundle", []string{"bazel-out/bin/app/bundle.js", "bazel-out/bin/app/bundle.js.map"})
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	out := &bytes.Buffer{}
+	log.SetWriter(out)
+	defer log.SetWriter(os.Stderr)
+	i.artifacts.execRoot = execRoot
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&artifactRecorder{phaseRecorder{&phases}, execRoot}}
+
+	targets := []string{"//app:bundle"}
+	i.afterCommand(targets, "build", true, nil)
+	if out.Len() != 0 {
+		t.Errorf("Reported sizes of the first build: %q", out.String())
+	}
+
+	write("bundle.js", 2100)
+	i.afterCommand(targets, "build", true, nil)
+	write("bundle.js", 4200)
+	write("bundle.js.map", 50)
+	i.afterCommand(targets, "build", true, nil)
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		// Drop the "iBazel [time]: " prefix, which may be colored.
+		line = strings.TrimPrefix(line[strings.Index(line, "]")+1:], "\x1b[0m")
+		lines = append(lines, strings.TrimPrefix(line, ": "))
+	}
+	assertEqual(t, []string{
+		"bazel-out/bin/app/bundle.js is 2.1 KiB (+100 B, +5.0%)",
+		"Warning: bazel-out/bin/app/bundle.js grew by 100.0%, from 2.1 KiB to 4.1 KiB",
+		"bazel-out/bin/app/bundle.js.map is 50 B (-50 B, -50.0%)",
+	}, lines, "Log lines")
+	assertEqual(t, []string{
+		"after build //app:bundle",
+		"after build //app:bundle",
+		"after build //app:bundle",
+	}, phases, "Phases without --publish_artifacts")
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, want := range map[int64]string{
+		0:         "0 B",
+		1023:      "1023 B",
+		1536:      "1.5 KiB",
+		-2048:     "-2.0 KiB",
+		3 << 20:   "3.0 MiB",
+		1<<20 - 1: "1024.0 KiB",
+	} {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var publishArtifacts = flag.Bool("publish_artifacts", false, "After every successful command, look up the output files of its targets in its build events, or with `bazel cquery --output=files` after a run, and report them, and which of them changed, in the --machine_output of the iteration")
+
+// artifactStamp is what tells a new version of an output file apart from the
+// one the last command left.
+type artifactStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// artifactTracker remembers the output files of the targets built so far, see
+// --publish_artifacts and --report_artifact_sizes.
+type artifactTracker struct {
+	execRoot string
+	stamps   map[string]artifactStamp
+}
+
+// publishArtifacts tells the ArtifactListeners about the output files of the
+// targets that were just built successfully, and reports how their size
+// changed with --report_artifact_sizes.
+func (i *IBazel) publishArtifacts(targets []string) {
+	if !i.wantsArtifacts() || len(targets) == 0 {
+		return
+	}
+	if i.artifacts.execRoot == "" {
+		info, err := i.getInfo()
+		if err != nil {
+			return
+		}
+		i.artifacts.execRoot = (*info)["execution_root"]
+	}
+	if i.artifacts.stamps == nil {
+		i.artifacts.stamps = map[string]artifactStamp{}
+	}
+
+	files, err := i.artifactFiles(targets)
+	if err != nil {
+		if queryErr, ok := err.(*bazel.QueryError); ok && len(queryErr.Stderr) > 0 {
+			log.Errorf("Error querying the output files of %s: %v\n%s", strings.Join(targets, " "), err, bytes.TrimSpace(queryErr.Stderr))
+		} else {
+			log.Errorf("Error querying the output files of %s: %v", strings.Join(targets, " "), err)
+		}
+		return
+	}
+
+	artifacts := make([]string, 0, len(files))
+	changed := []string{}
+	var sizes []sizeChange
+	for _, file := range files {
+		path := filepath.FromSlash(file)
+		if !filepath.IsAbs(path) && i.artifacts.execRoot != "" {
+			path = filepath.Join(i.artifacts.execRoot, path)
+		}
+		artifacts = append(artifacts, path)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		stamp := artifactStamp{size: info.Size(), modTime: info.ModTime()}
+		if old, ok := i.artifacts.stamps[path]; !ok || old != stamp {
+			changed = append(changed, path)
+			if ok && old.size != stamp.size {
+				sizes = append(sizes, sizeChange{path: path, old: old.size, new: stamp.size})
+			}
+		}
+		i.artifacts.stamps[path] = stamp
+	}
+	log.Debugf("%d output files, %d of them changed", len(artifacts), len(changed))
+	i.reportSizes(sizes)
+
+	if !*publishArtifacts {
+		return
+	}
+	for _, l := range i.lifecycleListeners {
+		if al, ok := l.(ArtifactListener); ok {
+			i.callListener(l, "ArtifactsBuilt", func() { al.ArtifactsBuilt(targets, artifacts, changed) })
+		}
+	}
+}
+
+// wantsArtifacts returns whether anything uses the output files of the
+// targets: the size report, or an ArtifactListener with --publish_artifacts.
+func (i *IBazel) wantsArtifacts() bool {
+	if *reportArtifactSizes {
+		return true
+	}
+	if !*publishArtifacts {
+		return false
+	}
+	for _, l := range i.lifecycleListeners {
+		if _, ok := l.(ArtifactListener); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// artifactFiles returns the output files of the targets the last command
+// built. They come from its build events, when it has them, and else from
+// `bazel cquery --output=files`, which has to analyze the targets again.
+func (i *IBazel) artifactFiles(targets []string) ([]string, error) {
+	if result := i.buildEvents; result != nil {
+		files := []string{}
+		for _, t := range result.Targets {
+			files = append(files, t.Outputs...)
+		}
+		return files, nil
+	}
+	return i.newBazel("cquery").CQueryFiles(strings.Join(targets, " + "))
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+)
+
+// artifactRecorder is a listener that also records the artifacts.
+type artifactRecorder struct {
+	phaseRecorder
+	root string
+}
+
+func (r *artifactRecorder) ArtifactsBuilt(targets []string, artifacts []string, changed []string) {
+	rel := func(paths []string) string {
+		var names []string
+		for _, path := range paths {
+			name, _ := filepath.Rel(r.root, path)
+			names = append(names, filepath.ToSlash(name))
+		}
+		return strings.Join(names, " ")
+	}
+	*r.phases = append(*r.phases, fmt.Sprintf("artifacts %s: [%s], changed: [%s]", strings.Join(targets, " "), rel(artifacts), rel(changed)))
+}
+
+func TestIBazelPublishArtifacts(t *testing.T) {
+	defer setFlag(t, "publish_artifacts", "true")()
+
+	execRoot, err := ioutil.TempDir("", "execroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(execRoot)
+	bin := filepath.Join(execRoot, "bazel-out", "bin", "app")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("server", "v1")
+	write("server.map", "map")
+
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddCQueryFilesResponse("//app:server", []string{"bazel-out/bin/app/server", "bazel-out/bin/app/server.map"})
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.artifacts.execRoot = execRoot
+
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&artifactRecorder{phaseRecorder{&phases}, execRoot}}
+
+	targets := []string{"//app:server"}
+	i.afterCommand(targets, "build", true, nil)
+	write("server", "v2 is longer")
+	i.afterCommand(targets, "build", true, nil)
+	i.afterCommand(targets, "build", false, nil)
+
+	assertEqual(t, []string{
+		"artifacts //app:server: [bazel-out/bin/app/server bazel-out/bin/app/server.map], changed: [bazel-out/bin/app/server bazel-out/bin/app/server.map]",
+		"after build //app:server",
+		"artifacts //app:server: [bazel-out/bin/app/server bazel-out/bin/app/server.map], changed: [bazel-out/bin/app/server]",
+		"after build //app:server",
+		"after build //app:server",
+	}, phases, "Phases")
+}
+
+func TestIBazelPublishArtifactsFromBuildEvents(t *testing.T) {
+	defer setFlag(t, "publish_artifacts", "true")()
+
+	var queried bool
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		queried = true
+		return &mock_bazel.MockBazel{}
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.artifacts.execRoot = "/execroot"
+	queried = false
+
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&artifactRecorder{phaseRecorder{&phases}, "/execroot"}}
+
+	i.buildEvents = &bep.Result{Success: true, Targets: []bep.Target{
+		{Label: "//app:server", Success: true, Outputs: []string{"/execroot/bazel-out/bin/app/server"}},
+	}}
+	i.afterCommand([]string{"//app:server"}, "build", true, nil)
+
+	assertEqual(t, []string{
+		"artifacts //app:server: [bazel-out/bin/app/server], changed: []",
+		"after build //app:server",
+	}, phases, "Phases")
+	if queried {
+		t.Errorf("The output files were queried even though the build events have them")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// atomicSaves is whether watches are lost when an editor saves a file
+// atomically, by writing a temporary file and renaming it over the original.
+// On macOS fsnotify watches each file of a watched directory by its inode,
+// so the watch stays with the replaced file and later saves go unnoticed.
+var atomicSaves = runtime.GOOS == "darwin"
+
+// rewatchReplaced watches the directory of a watched file again when it was
+// renamed or removed but state says it still exists, i.e. it was replaced by
+// an atomic save, so that the next save is seen too. normalizeEvent turns the
+// event into the Write it stands for.
+func (i *IBazel) rewatchReplaced(watcher fSNotifyWatcher, e fsnotify.Event, state fileState) {
+	if !atomicSaves || state != fileExists || e.Op&(fsnotify.Rename|fsnotify.Remove) == 0 {
+		return
+	}
+	if watcher == i.sourceFileWatcher {
+		if !i.watchingSource(e.Name) {
+			return
+		}
+	} else if _, ok := i.filesWatched[watcher][e.Name]; !ok {
+		return
+	}
+
+	// The directory is watched by the name of its files' parent directory,
+	// see watcherAdd.
+	dir, _ := filepath.Split(e.Name)
+	if err := rewatch(watcher, dir); err != nil {
+		log.Errorf("Error watching %q again after it was saved: %v", e.Name, err)
+	}
+}
+
+// rewatcher is a watcher that can watch a directory again even if it shares
+// the watch with another watcher, like the views of a watchDispatcher.
+type rewatcher interface {
+	Rewatch(name string) error
+}
+
+// rewatch watches dir again. Adding a directory that is already watched keeps
+// the stale watches of its files, so it has to be removed first.
+func rewatch(watcher fSNotifyWatcher, dir string) error {
+	if r, ok := watcher.(rewatcher); ok {
+		return r.Rewatch(dir)
+	}
+	watcher.Remove(dir)
+	return watcher.Add(dir)
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// rewatchingWatcher records the directories that are watched again.
+type rewatchingWatcher struct {
+	fakeFSNotifyWatcher
+	calls []string
+}
+
+func (w *rewatchingWatcher) Add(name string) error {
+	w.calls = append(w.calls, "Add "+name)
+	return nil
+}
+
+func (w *rewatchingWatcher) Remove(name string) error {
+	w.calls = append(w.calls, "Remove "+name)
+	return nil
+}
+
+func TestIBazelAtomicSaves(t *testing.T) {
+	oldAtomicSaves := atomicSaves
+	atomicSaves = true
+	defer func() { atomicSaves = oldAtomicSaves }()
+
+	dir, err := ioutil.TempDir("", "atomic_save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.go")
+	watchedDir := dir + string(filepath.Separator)
+	if err := ioutil.WriteFile(file, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	w := &rewatchingWatcher{}
+	i.sourceFileWatcher = w
+	i.setWatched(w, map[string]struct{}{file: struct{}{}})
+
+	// VS Code writes a temporary file and renames it over the original, which
+	// kqueue reports as the original being removed.
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []fsnotify.Event{
+		{Name: tmp, Op: fsnotify.Create},
+		{Name: tmp, Op: fsnotify.Write},
+		{Name: file, Op: fsnotify.Remove},
+		{Name: tmp, Op: fsnotify.Rename},
+	} {
+		got := i.watchedEvent(w, e)
+		if e.Name == file {
+			assertEqual(t, fsnotify.Event{Name: file, Op: fsnotify.Write}, got, "Replaced file")
+		} else {
+			assertEqual(t, e, got, "Temporary file")
+		}
+	}
+	assertEqual(t, []string{"Remove " + watchedDir, "Add " + watchedDir}, w.calls, "Watcher calls")
+
+	// TextEdit swaps the saved file in place, which is reported as a Rename.
+	w.calls = nil
+	got := i.watchedEvent(w, fsnotify.Event{Name: file, Op: fsnotify.Rename})
+	assertEqual(t, fsnotify.Event{Name: file, Op: fsnotify.Write}, got, "Swapped file")
+	assertEqual(t, []string{"Remove " + watchedDir, "Add " + watchedDir}, w.calls, "Watcher calls")
+
+	// A file that is really removed stays removed.
+	w.calls = nil
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	got = i.watchedEvent(w, fsnotify.Event{Name: file, Op: fsnotify.Remove})
+	assertEqual(t, fsnotify.Event{Name: file, Op: fsnotify.Remove}, got, "Removed file")
+	assertEqual(t, 0, len(w.calls), "Watcher calls for a removed file")
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"runtime"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/auto_tune"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// autoTune prints the Bazel flags recommended for the workspace, or adds them
+// to the Bazel flags with --auto_tune. It runs once, after the first query,
+// when the number of watched files is known.
+func (i *IBazel) autoTune() {
+	if i.tuned {
+		return
+	}
+	i.tuned = true
+
+	files := len(i.filesWatched[i.sourceFileWatcher])
+	if i.treeMode {
+		// Only the directories of the files are watched.
+		files = i.treeFiles
+	}
+	recs := auto_tune.Recommend(auto_tune.Workspace{
+		Release: i.bazelRelease,
+		Files:   files,
+		Args:    i.bazelArgs,
+		CPUs:    runtime.NumCPU(),
+	})
+	if len(recs) == 0 {
+		return
+	}
+	for _, r := range recs {
+		if auto_tune.Apply() {
+			log.Logf("Adding %s, %s.", r.Flag, r.Reason)
+		} else {
+			log.Logf("Consider passing %s, %s.", r.Flag, r.Reason)
+		}
+	}
+	if !auto_tune.Apply() {
+		log.Logf("Pass --auto_tune to have iBazel add these flags.")
+		return
+	}
+	i.bazelArgs = append(append([]string{}, i.bazelArgs...), auto_tune.Flags(recs)...)
+}
+
+var cgroupLimits = flag.Bool("cgroup_limits", true, "Pass --jobs, --local_cpu_resources and --local_ram_resources that fit the CPU and memory limits of the container iBazel runs in, and recommend a heap size for the Bazel server")
+var maxStarting = flag.Int("mrun_max_starting", 0, "How many targets `ibazel mrun` starts at once before it gives them time to come up. 0 is the number of cores the container is limited to, or all of them without a limit")
+
+var startSettle = flag.Duration("mrun_start_settle", 5*time.Second, "How long a batch of targets started by `ibazel mrun` is given to come up before the next one is started, see --mrun_max_starting")
+
+var readLimits = auto_tune.ReadLimits
+
+// tuneForLimits adds the Bazel flags that fit the cgroup limits iBazel runs
+// in, and lets iBazel itself use only the cores it may. Startup flags are only
+// recommended: the Bazel server would restart whenever the user switched
+// between bazel and ibazel.
+func (i *IBazel) tuneForLimits() {
+	if !*cgroupLimits {
+		return
+	}
+	i.limits = readLimits()
+	if cores := i.limits.Cores(); cores > 0 && cores < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(cores)
+	}
+
+	recs := auto_tune.RecommendForLimits(i.limits, runtime.NumCPU(), i.startupArgs, i.bazelArgs)
+	for _, r := range recs {
+		if r.Startup {
+			log.Logf("Consider passing the startup flag %s, %s.", r.Flag, r.Reason)
+		} else {
+			log.Logf("Adding %s, %s.", r.Flag, r.Reason)
+		}
+	}
+	i.bazelArgs = append(append([]string{}, i.bazelArgs...), auto_tune.Flags(recs)...)
+}
+
+// startBatch returns how many targets mrun starts at once, or 0 for all of
+// them.
+func (i *IBazel) startBatch() int {
+	if *maxStarting > 0 {
+		return *maxStarting
+	}
+	return i.limits.Cores()
+}
+
+// pendingStart is an mrun target waiting to be started, see startBatch.
+type pendingStart struct {
+	target     string
+	debugArg   []string
+	argsLength int
+}
+
+// schedulePendingStarts sets the timer that starts the next batch of the
+// targets waiting to start, if there are any, without holding up the main
+// loop in the meantime.
+func (i *IBazel) schedulePendingStarts() {
+	if len(i.pendingStarts) == 0 {
+		i.startTimer = nil
+		return
+	}
+	log.Logf("Giving the started targets %v to come up before starting %d more", *startSettle, len(i.pendingStarts))
+	i.startTimer = time.After(*startSettle)
+}
+
+// startPending starts the next batch of the targets waiting to start.
+func (i *IBazel) startPending() {
+	pending := i.pendingStarts
+	i.pendingStarts = nil
+	started := 0
+	for idx, p := range pending {
+		if n := i.startBatch(); n > 0 && started == n {
+			i.pendingStarts = pending[idx:]
+			break
+		}
+		if _, ok := i.cmds[p.target]; ok || !contains(i.targets, p.target) || i.memoryLimitReached(p.target) {
+			continue
+		}
+		started++
+		// startTarget logs the errors.
+		i.startTarget(p.target, p.debugArg, p.argsLength)
+	}
+	i.schedulePendingStarts()
+}
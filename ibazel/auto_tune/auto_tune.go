@@ -0,0 +1,135 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auto_tune recommends Bazel flags that make the watch loop faster,
+// based on the Bazel version, the size of the workspace and the flags iBazel
+// was given. Only flags that don't change what is built are recommended, so
+// applying them never invalidates Bazel's analysis cache.
+package auto_tune
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var apply = flag.Bool("auto_tune", false, "Add the Bazel flags iBazel recommends for the workspace to every build, instead of only printing them")
+
+// Apply reports whether the recommended flags should be added to the Bazel
+// flags rather than only suggested.
+func Apply() bool {
+	return *apply
+}
+
+// The number of watched files above which Bazel's own scan for changed files
+// before every build gets noticeable.
+const manyFiles = 2000
+
+// Workspace is what the recommendations are based on.
+type Workspace struct {
+	// Release is the "release" of `bazel info`, e.g. "release 6.4.0".
+	Release string
+	// Files is the number of source files watched.
+	Files int
+	// Args are the Bazel flags given to iBazel.
+	Args []string
+	// CPUs is the number of local cores.
+	CPUs int
+}
+
+// Recommendation is a Bazel flag and why it helps.
+type Recommendation struct {
+	Flag   string
+	Reason string
+	// Startup is whether Flag is a startup flag, which goes before the
+	// command.
+	Startup bool
+}
+
+var releaseRegex = regexp.MustCompile(`^release (\d+)\.(\d+)`)
+
+// version returns the major and minor version of a release, or 0, 0 for a
+// development version.
+func version(release string) (int, int) {
+	m := releaseRegex.FindStringSubmatch(release)
+	if m == nil {
+		return 0, 0
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return major, minor
+}
+
+// has reports whether one of the args sets the flag, in either polarity.
+func has(args []string, name string) bool {
+	for _, arg := range args {
+		arg = strings.SplitN(arg, "=", 2)[0]
+		if arg == "--"+name || arg == "--no"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// Recommend returns the flags recommended for w that aren't set already.
+func Recommend(w Workspace) []Recommendation {
+	var recs []Recommendation
+	if w.Files > manyFiles && !has(w.Args, "watchfs") {
+		recs = append(recs, Recommendation{
+			Flag:   "--watchfs",
+			Reason: fmt.Sprintf("Bazel checks all %d watched files for changes before every build, --watchfs makes it ask the operating system instead", w.Files),
+		})
+	}
+
+	remoteCache := has(w.Args, "remote_cache") || has(w.Args, "remote_executor")
+	// Asynchronous uploads are the default from Bazel 7 on.
+	if major, _ := version(w.Release); remoteCache && major >= 5 && major < 7 && !has(w.Args, "experimental_remote_cache_async") {
+		recs = append(recs, Recommendation{
+			Flag:   "--experimental_remote_cache_async",
+			Reason: "the build finishes without waiting for its outputs to be uploaded to the remote cache",
+		})
+	}
+
+	if has(w.Args, "remote_executor") && !has(w.Args, "jobs") && w.CPUs > 0 {
+		recs = append(recs, Recommendation{
+			Flag:   fmt.Sprintf("--jobs=%d", 4*w.CPUs),
+			Reason: fmt.Sprintf("remote execution runs more actions at once than the %d local cores Bazel plans for by default", w.CPUs),
+		})
+	}
+	return recs
+}
+
+// Flags returns the flags of the recommendations that aren't startup flags.
+func Flags(recs []Recommendation) []string {
+	flags := make([]string, 0, len(recs))
+	for _, r := range recs {
+		if !r.Startup {
+			flags = append(flags, r.Flag)
+		}
+	}
+	return flags
+}
+
+// StartupFlags returns the startup flags of the recommendations.
+func StartupFlags(recs []Recommendation) []string {
+	flags := make([]string, 0, len(recs))
+	for _, r := range recs {
+		if r.Startup {
+			flags = append(flags, r.Flag)
+		}
+	}
+	return flags
+}
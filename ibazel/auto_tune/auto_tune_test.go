@@ -0,0 +1,42 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auto_tune
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecommend(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		w    Workspace
+		want []string
+	}{
+		{"small workspace", Workspace{Release: "release 6.4.0", Files: 10, CPUs: 8}, []string{}},
+		{"large workspace", Workspace{Release: "release 6.4.0", Files: 5000, CPUs: 8}, []string{"--watchfs"}},
+		{"watchfs given", Workspace{Release: "release 6.4.0", Files: 5000, Args: []string{"--nowatchfs"}}, []string{}},
+		{"remote cache", Workspace{Release: "release 6.4.0", Args: []string{"--remote_cache=grpc://cache"}}, []string{"--experimental_remote_cache_async"}},
+		{"remote cache on Bazel 7", Workspace{Release: "release 7.1.0", Args: []string{"--remote_cache=grpc://cache"}}, []string{}},
+		{"development version", Workspace{Release: "development version", Args: []string{"--remote_cache=grpc://cache"}}, []string{}},
+		{"remote execution", Workspace{Release: "release 6.4.0", Args: []string{"--remote_executor=grpc://rbe"}, CPUs: 8}, []string{"--experimental_remote_cache_async", "--jobs=32"}},
+		{"jobs given", Workspace{Release: "release 7.0.0", Args: []string{"--remote_executor=grpc://rbe", "--jobs=100"}, CPUs: 8}, []string{}},
+	} {
+		got := Flags(Recommend(c.w))
+		if !reflect.DeepEqual(c.want, got) {
+			t.Errorf("%s: wanted %v, got %v", c.name, c.want, got)
+		}
+	}
+}
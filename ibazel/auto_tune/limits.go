@@ -0,0 +1,225 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auto_tune
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Limits are the CPU and memory limits of the cgroup iBazel runs in, e.g. in
+// a devcontainer or a CI preview environment. Zero is unlimited.
+type Limits struct {
+	// CPUs is the CPU quota in cores, e.g. 1.5.
+	CPUs float64
+	// Memory is the memory limit in bytes.
+	Memory int64
+}
+
+// Cores returns the CPU quota rounded up to whole cores, or 0 if unlimited.
+func (l Limits) Cores() int {
+	return int(math.Ceil(l.CPUs))
+}
+
+// Where cgroups are mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// Which cgroups the process is in, see cgroupDirs.
+var procCgroup = "/proc/self/cgroup"
+
+// Memory limits above this are how cgroup v1 says unlimited.
+const unlimitedMemory = 1 << 60
+
+// ReadLimits returns the limits of the cgroup of the process, either of
+// cgroup v2 or v1. The limits of its parents apply as well, so the tightest
+// of them is returned. Limits that can't be read, e.g. outside Linux, are
+// zero.
+func ReadLimits() Limits {
+	var l Limits
+	for _, dir := range cgroupDirs("", "") {
+		if quota, period, err := readPair(filepath.Join(dir, "cpu.max")); err == nil {
+			l.CPUs = tighterCPUs(l.CPUs, cpus(quota, period))
+		}
+		if memory, err := readValue(filepath.Join(dir, "memory.max")); err == nil {
+			l.Memory = tighterMemory(l.Memory, memory)
+		}
+	}
+	for _, dir := range cgroupDirs("cpu", "cpu") {
+		quota, _ := readValue(filepath.Join(dir, "cpu.cfs_quota_us"))
+		period, _ := readValue(filepath.Join(dir, "cpu.cfs_period_us"))
+		l.CPUs = tighterCPUs(l.CPUs, cpus(quota, period))
+	}
+	for _, dir := range cgroupDirs("memory", "memory") {
+		if memory, err := readValue(filepath.Join(dir, "memory.limit_in_bytes")); err == nil {
+			l.Memory = tighterMemory(l.Memory, memory)
+		}
+	}
+	return l
+}
+
+// cgroupDirs returns the directories of the cgroup of the process for a
+// cgroup v1 controller, or "" for cgroup v2, which is mounted in the
+// directory sub of cgroupRoot, followed by the ones of its parents. Without a
+// cgroup namespace, e.g. in older container runtimes, the cgroup of the
+// process isn't mounted and the root, the container's own cgroup, is used.
+func cgroupDirs(controller, sub string) []string {
+	root := filepath.Join(cgroupRoot, sub)
+	b, err := ioutil.ReadFile(procCgroup)
+	if err != nil {
+		return []string{root}
+	}
+	// Lines look like "0::/user.slice" for cgroup v2 and
+	// "4:cpu,cpuacct:/docker/abc" for v1.
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 || !hasController(fields[1], controller) {
+			continue
+		}
+		var dirs []string
+		for path := filepath.Clean("/" + fields[2]); ; path = filepath.Dir(path) {
+			dirs = append(dirs, filepath.Join(root, path))
+			if path == "/" {
+				return dirs
+			}
+		}
+	}
+	return []string{root}
+}
+
+func hasController(controllers, controller string) bool {
+	if controller == "" {
+		return controllers == ""
+	}
+	for _, c := range strings.Split(controllers, ",") {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// tighterCPUs returns the lower of two CPU limits, where zero is unlimited.
+func tighterCPUs(a, b float64) float64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// tighterMemory returns the lower of a memory limit and a value read from a
+// cgroup, which can be -1 or a huge number for unlimited.
+func tighterMemory(limit, value int64) int64 {
+	if value <= 0 || value >= unlimitedMemory {
+		return limit
+	}
+	if limit <= 0 || value < limit {
+		return value
+	}
+	return limit
+}
+
+func cpus(quota, period int64) float64 {
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+	return float64(quota) / float64(period)
+}
+
+// readValue reads a file with a single number, where "max" is unlimited and
+// read as -1.
+func readValue(path string) (int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return parseValue(strings.TrimSpace(string(b)))
+}
+
+// readPair reads a file with two numbers, like the "<quota> <period>" of
+// cpu.max.
+func readPair(path string) (int64, int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("%s: wanted two values, got %q", path, b)
+	}
+	first, err := parseValue(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	second, err := parseValue(fields[1])
+	return first, second, err
+}
+
+func parseValue(s string) (int64, error) {
+	if s == "max" {
+		return -1, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// hasHeapSize reports whether the startup args set the maximum heap size of
+// the Bazel server.
+func hasHeapSize(startupArgs []string) bool {
+	for _, arg := range startupArgs {
+		if strings.HasPrefix(arg, "--host_jvm_args") && strings.Contains(arg, "-Xmx") {
+			return true
+		}
+	}
+	return false
+}
+
+// RecommendForLimits returns the flags that keep Bazel within the limits l
+// of a machine with cpus cores, and that aren't set already. Bazel plans for
+// all of the machine's cores by default, so a container limited to 2 of them
+// thrashes. Startup flags are marked, since they restart the Bazel server.
+func RecommendForLimits(l Limits, cpus int, startupArgs, args []string) []Recommendation {
+	var recs []Recommendation
+	if cores := l.Cores(); cores > 0 && cores < cpus {
+		reason := fmt.Sprintf("the container is limited to %g of the %d cores Bazel plans for", l.CPUs, cpus)
+		if !has(args, "jobs") && !has(args, "remote_executor") {
+			recs = append(recs, Recommendation{Flag: fmt.Sprintf("--jobs=%d", cores), Reason: reason})
+		}
+		if !has(args, "local_cpu_resources") {
+			recs = append(recs, Recommendation{Flag: fmt.Sprintf("--local_cpu_resources=%d", cores), Reason: reason})
+		}
+	}
+	if l.Memory > 0 {
+		mb := l.Memory >> 20
+		// Half of the memory is left to actions and a quarter to the Bazel
+		// server, which leaves room for the targets iBazel runs.
+		if !has(args, "local_ram_resources") {
+			recs = append(recs, Recommendation{
+				Flag:   fmt.Sprintf("--local_ram_resources=%d", mb/2),
+				Reason: fmt.Sprintf("the container is limited to %dMiB of memory", mb),
+			})
+		}
+		if !hasHeapSize(startupArgs) {
+			recs = append(recs, Recommendation{
+				Flag:    fmt.Sprintf("--host_jvm_args=-Xmx%dm", mb/4),
+				Reason:  fmt.Sprintf("the Bazel server would otherwise size its heap for more than the %dMiB the container may use", mb),
+				Startup: true,
+			})
+		}
+	}
+	return recs
+}
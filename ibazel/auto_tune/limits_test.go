@@ -0,0 +1,119 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auto_tune
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func withCgroup(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldRoot, oldProcCgroup := cgroupRoot, procCgroup
+	cgroupRoot = dir
+	procCgroup = filepath.Join(dir, "proc_self_cgroup")
+	return func() {
+		cgroupRoot, procCgroup = oldRoot, oldProcCgroup
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReadLimits(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		files map[string]string
+		want  Limits
+	}{
+		{"none", nil, Limits{}},
+		{"v2", map[string]string{"cpu.max": "150000 100000\n", "memory.max": "4294967296\n"}, Limits{CPUs: 1.5, Memory: 4 << 30}},
+		{"v2 unlimited", map[string]string{"cpu.max": "max 100000\n", "memory.max": "max\n"}, Limits{}},
+		{"v1", map[string]string{
+			"cpu/cpu.cfs_quota_us":         "200000\n",
+			"cpu/cpu.cfs_period_us":        "100000\n",
+			"memory/memory.limit_in_bytes": "2147483648\n",
+		}, Limits{CPUs: 2, Memory: 2 << 30}},
+		{"v1 unlimited", map[string]string{
+			"cpu/cpu.cfs_quota_us":         "-1\n",
+			"cpu/cpu.cfs_period_us":        "100000\n",
+			"memory/memory.limit_in_bytes": "9223372036854771712\n",
+		}, Limits{}},
+		{"v2 own cgroup", map[string]string{
+			"proc_self_cgroup":                "0::/user.slice/app.scope\n",
+			"user.slice/app.scope/cpu.max":    "max 100000\n",
+			"user.slice/app.scope/memory.max": "2147483648\n",
+			"user.slice/cpu.max":              "200000 100000\n",
+			"memory.max":                      "4294967296\n",
+		}, Limits{CPUs: 2, Memory: 2 << 30}},
+		{"v1 own cgroup", map[string]string{
+			"proc_self_cgroup":                    "5:memory:/ci/job\n4:cpu,cpuacct:/ci/job\n",
+			"cpu/ci/job/cpu.cfs_quota_us":         "100000\n",
+			"cpu/ci/job/cpu.cfs_period_us":        "100000\n",
+			"memory/ci/memory.limit_in_bytes":     "1073741824\n",
+			"memory/ci/job/memory.limit_in_bytes": "9223372036854771712\n",
+		}, Limits{CPUs: 1, Memory: 1 << 30}},
+		{"own cgroup not mounted", map[string]string{
+			"proc_self_cgroup": "0::/docker/abc\n",
+			"cpu.max":          "150000 100000\n",
+		}, Limits{CPUs: 1.5}},
+	} {
+		restore := withCgroup(t, c.files)
+		if got := ReadLimits(); got != c.want {
+			t.Errorf("%s: wanted %+v, got %+v", c.name, c.want, got)
+		}
+		restore()
+	}
+}
+
+func TestRecommendForLimits(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		l           Limits
+		startupArgs []string
+		args        []string
+		want        []string
+		wantStartup []string
+	}{
+		{"unlimited", Limits{}, nil, nil, []string{}, []string{}},
+		{"all cores", Limits{CPUs: 8}, nil, nil, []string{}, []string{}},
+		{"two cores", Limits{CPUs: 1.5}, nil, nil, []string{"--jobs=2", "--local_cpu_resources=2"}, []string{}},
+		{"jobs given", Limits{CPUs: 2}, nil, []string{"--jobs=4"}, []string{"--local_cpu_resources=2"}, []string{}},
+		{"remote execution", Limits{CPUs: 2}, nil, []string{"--remote_executor=grpc://rbe"}, []string{"--local_cpu_resources=2"}, []string{}},
+		{"memory", Limits{Memory: 4 << 30}, nil, nil, []string{"--local_ram_resources=2048"}, []string{"--host_jvm_args=-Xmx1024m"}},
+		{"heap given", Limits{Memory: 4 << 30}, []string{"--host_jvm_args=-Xmx2g"}, []string{"--local_ram_resources=1000"}, []string{}, []string{}},
+	} {
+		recs := RecommendForLimits(c.l, 8, c.startupArgs, c.args)
+		if got := Flags(recs); !reflect.DeepEqual(c.want, got) {
+			t.Errorf("%s: wanted %v, got %v", c.name, c.want, got)
+		}
+		if got := StartupFlags(recs); !reflect.DeepEqual(c.wantStartup, got) {
+			t.Errorf("%s: wanted startup flags %v, got %v", c.name, c.wantStartup, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/auto_tune"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/analysis"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+func TestIBazelAutoTune(t *testing.T) {
+	defer setFlag(t, "auto_tune", "true")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.bazelRelease = "release 6.4.0"
+	i.SetBazelArgs([]string{"--remote_executor=grpc://rbe"})
+
+	i.autoTune()
+	want := []string{"--remote_executor=grpc://rbe", "--experimental_remote_cache_async", fmt.Sprintf("--jobs=%d", 4*runtime.NumCPU())}
+	assertEqual(t, want, i.bazelArgs, "Bazel flags after tuning")
+
+	// The flags are only recommended once.
+	i.autoTune()
+	assertEqual(t, want, i.bazelArgs, "Bazel flags after tuning again")
+}
+
+func TestIBazelTuneForLimits(t *testing.T) {
+	defer func(old func() auto_tune.Limits) { readLimits = old }(readLimits)
+	readLimits = func() auto_tune.Limits {
+		return auto_tune.Limits{Memory: 4 << 30}
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.SetStartupArgs([]string{"--bazelrc=ci.bazelrc"})
+	i.SetBazelArgs([]string{"--config=dev"})
+
+	i.tuneForLimits()
+	assertEqual(t, []string{"--bazelrc=ci.bazelrc"}, i.startupArgs, "Startup flags after tuning, which would restart the Bazel server")
+	assertEqual(t, []string{"--config=dev", "--local_ram_resources=2048"}, i.bazelArgs, "Bazel flags after tuning")
+
+	defer setFlag(t, "cgroup_limits", "false")()
+	i.SetBazelArgs(nil)
+	i.tuneForLimits()
+	assertEqual(t, []string(nil), i.bazelArgs, "Bazel flags with --cgroup_limits=false")
+}
+
+func TestIBazelStartBatch(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	assertEqual(t, 0, i.startBatch(), "Without a limit")
+
+	i.limits = auto_tune.Limits{CPUs: 1.5}
+	assertEqual(t, 2, i.startBatch(), "Limited to 1.5 cores")
+
+	defer setFlag(t, "mrun_max_starting", "4")()
+	assertEqual(t, 4, i.startBatch(), "With --mrun_max_starting")
+}
+
+func TestIBazelRunMultiple_startsInBatches(t *testing.T) {
+	defer setFlag(t, "mrun_max_starting", "1")()
+
+	targets := []string{"//path/to:a", "//path/to:b", "//path/to:c"}
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := oldBazelNew()
+		for _, target := range targets {
+			mockBazel.AddCQueryResponse(target, &analysis.CqueryResult{
+				Results: []*analysis.ConfiguredTarget{{
+					Target: &blaze_query.Target{
+						Type: blaze_query.Target_RULE.Enum(),
+						Rule: &blaze_query.Rule{},
+					},
+				}},
+			})
+		}
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.targets = targets
+
+	if _, err := i.runMultiple(targets, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(i.cmds), "Targets started with the first batch")
+	assertEqual(t, 2, len(i.pendingStarts), "Targets waiting to start")
+	if i.startTimer == nil {
+		t.Errorf("No timer to start the targets waiting to start")
+	}
+
+	i.startPending()
+	assertEqual(t, 2, len(i.cmds), "Targets started with the second batch")
+	i.startPending()
+	assertEqual(t, 3, len(i.cmds), "Targets started with the third batch")
+	if i.startTimer != nil {
+		t.Errorf("The timer is still set without targets waiting to start")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/progress"
+)
+
+// commandBazelArgs are flags for a single Bazel command, for the flags that
+// only some commands accept.
+var commandBazelArgs = map[string]*string{
+	"query": flag.String("query_bazel_args", "", "Space separated flags passed only to the queries for the files to watch, which don't get the Bazel flags given with the targets"),
+	"build": flag.String("build_bazel_args", "", "Space separated flags passed only to `bazel build`"),
+	"test":  flag.String("test_bazel_args", "", "Space separated flags passed only to `bazel test`, e.g. --test_output=streamed"),
+	"run":   flag.String("run_bazel_args", "", "Space separated flags passed only to `bazel run`"),
+}
+
+// bazelArgsFor returns the flags for a Bazel command: the ones given with the
+// targets followed by the ones for just that command. Queries only get the
+// latter, since most flags given with the targets are for building. The flags
+// of --progress and of building offline come first, so that both can override
+// them.
+func (i *IBazel) bazelArgsFor(command string) []string {
+	args := append(progress.BazelArgs(), i.offlineArgs()...)
+	if command != "query" {
+		args = append(args, i.bazelArgs...)
+	}
+	if extra, ok := commandBazelArgs[command]; ok {
+		args = append(args, strings.Fields(*extra)...)
+	}
+	return args
+}
@@ -0,0 +1,37 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestBazelArgsFor(t *testing.T) {
+	defer setFlag(t, "test_bazel_args", "--test_output=streamed  --test_arg=-v")()
+	defer setFlag(t, "query_bazel_args", "--override_repository=foo=/foo")()
+
+	i := &IBazel{bazelArgs: []string{"--config=dev"}}
+	for _, c := range []struct {
+		command string
+		args    []string
+	}{
+		{"build", []string{"--config=dev"}},
+		{"test", []string{"--config=dev", "--test_output=streamed", "--test_arg=-v"}},
+		{"query", []string{"--override_repository=foo=/foo"}},
+		{"mobile-install", []string{"--config=dev"}},
+	} {
+		assertEqual(t, c.args, i.bazelArgsFor(c.command), c.command)
+	}
+}
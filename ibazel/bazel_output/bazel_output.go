@@ -0,0 +1,227 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bazel_output decides how much of Bazel's own output an iteration
+// shows. Unless it is shown in full as it comes, the output is held back: a
+// command that succeeds is summed up in one line, and one that fails prints
+// its output, or just its errors, once it is done.
+package bazel_output
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var mode = flag.String(
+	"bazel_output",
+	Full,
+	"How much of the output of `bazel build`, `bazel test` and `bazel mobile-install` is shown: \"full\" prints it as it comes, \"errors\" prints it once a command fails and \"quiet\" only its errors then. Both print a single line when the command succeeds")
+
+const (
+	Full   = "full"
+	Errors = "errors"
+	Quiet  = "quiet"
+)
+
+var dedup = flag.Bool("bazel_output_dedup", false, "With --bazel_output=errors or quiet, only print what changed when a command fails with nearly the same errors as the last time")
+
+// Written to at the time of the call, so that redirections of os.Stderr are
+// honored.
+var stderr = func() io.Writer { return os.Stderr }
+
+// Matches the lines printed with --bazel_output=quiet, e.g.
+// "ERROR: /ws/BUILD:3:1: Compiling foo.cc failed" or
+// "//foo:bar_test   FAILED in 0.1s".
+var errorLineRegex = regexp.MustCompile(`^(ERROR|FAILED):|^@?[\w\-.]*//\S*\s+(\(cached\)\s+)?(FAILED TO BUILD|FAILED|TIMEOUT|NO STATUS|INCOMPLETE)\b`)
+
+var (
+	elapsedRegex = regexp.MustCompile(`^INFO: Elapsed time: ([\d.]+s)`)
+	actionsRegex = regexp.MustCompile(`^INFO: Build completed successfully, (.*)$`)
+	testsRegex   = regexp.MustCompile(`^Executed \d+ out of \d+ tests?: .*$`)
+)
+
+// Matches the lines that change from one run of a command to the next even
+// when its errors don't, e.g. "INFO: Elapsed time: 3.2s" or "[12 / 30] ...".
+var noiseLineRegex = regexp.MustCompile(`^(INFO:|Loading:|Analyzing:|\[[\d,]+ / [\d,]+\])`)
+
+// The errors of the last failed command, see --bazel_output_dedup.
+var last struct {
+	key     string
+	lines   []string
+	repeats int
+}
+
+var pastTense = map[string]string{
+	"build":          "Built",
+	"test":           "Tested",
+	"mobile-install": "Installed",
+}
+
+// Live reports whether Bazel's output is printed as it comes. Otherwise it
+// has to be printed with Print once the command is done.
+func Live() bool {
+	return *mode != Errors && *mode != Quiet
+}
+
+// Print prints what the mode shows of the held back output of a command.
+func Print(command string, targets []string, success bool, output *bytes.Buffer) {
+	if Live() || output == nil {
+		return
+	}
+	if success {
+		last.key, last.lines, last.repeats = "", nil, 0
+		log.Log(Summary(command, targets, output.String()))
+		return
+	}
+	w := stderr()
+	if *dedup && printRepeated(w, command, targets, errorLines(output)) {
+		return
+	}
+	if *mode == Errors {
+		w.Write(output.Bytes())
+		return
+	}
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		line := log.StripColor(string(scanner.Bytes()))
+		if errorLineRegex.MatchString(line) {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// Summary returns the line a successful command is summed up in, e.g.
+// "Built //foo:bar in 3.2s, 5 total actions".
+func Summary(command string, targets []string, output string) string {
+	verb, ok := pastTense[command]
+	if !ok {
+		verb = "Ran " + command + " for"
+	}
+	summary := fmt.Sprintf("%s %s", verb, strings.Join(targets, " "))
+	var details string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(log.StripColor(line))
+		if m := elapsedRegex.FindStringSubmatch(line); m != nil {
+			summary += " in " + m[1]
+		} else if m := actionsRegex.FindStringSubmatch(line); m != nil && details == "" {
+			details = m[1]
+		} else if testsRegex.MatchString(line) {
+			details = line
+		}
+	}
+	if details != "" {
+		summary += ", " + details
+	}
+	return summary
+}
+
+// errorLines returns the lines of the output of a failed command that tell
+// one failure apart from another, without color codes.
+func errorLines(output *bytes.Buffer) []string {
+	var lines []string
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		line := strings.TrimRight(log.StripColor(scanner.Text()), " \t")
+		if line == "" || noiseLineRegex.MatchString(line) {
+			continue
+		}
+		if *mode == Quiet && !errorLineRegex.MatchString(line) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// printRepeated prints a short note instead of the errors of a command that
+// failed with the same errors as the last time, or just the lines that
+// changed if only a few did. It returns false if the errors have to be
+// printed in full.
+func printRepeated(w io.Writer, command string, targets []string, lines []string) bool {
+	key := command + " " + strings.Join(targets, " ")
+	previousKey, previous, repeats := last.key, last.lines, last.repeats
+	last.key, last.lines, last.repeats = key, lines, 1
+	if key != previousKey || previous == nil {
+		return false
+	}
+	added, removed := diffLines(previous, lines)
+	if len(added)+len(removed) == 0 {
+		last.repeats = repeats + 1
+		log.Logf("Same errors as the last %s, %s time in a row", command, ordinal(last.repeats))
+		return true
+	}
+	size := len(lines)
+	if len(previous) > size {
+		size = len(previous)
+	}
+	if len(added)+len(removed) > size/2 {
+		return false
+	}
+	fmt.Fprintf(w, "Errors changed since the last %s:\n", command)
+	for _, line := range removed {
+		fmt.Fprintf(w, "- %s\n", line)
+	}
+	for _, line := range added {
+		fmt.Fprintf(w, "+ %s\n", line)
+	}
+	return true
+}
+
+// diffLines returns the lines of b that aren't in a and the ones of a that
+// aren't in b, each in the order they appear in.
+func diffLines(a, b []string) (added []string, removed []string) {
+	counts := map[string]int{}
+	for _, line := range a {
+		counts[line]++
+	}
+	for _, line := range b {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			added = append(added, line)
+		}
+	}
+	for _, line := range a {
+		if counts[line] > 0 {
+			counts[line]--
+			removed = append(removed, line)
+		}
+	}
+	return added, removed
+}
+
+// ordinal returns n as an English ordinal number, e.g. "3rd".
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
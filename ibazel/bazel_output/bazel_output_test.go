@@ -0,0 +1,134 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel_output
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+const buildOutput = `INFO: Analyzed target //foo:bar (1 packages loaded, 3 targets configured).
+INFO: Found 1 target...
+ERROR: /ws/foo/BUILD:3:1: Compiling foo/bar.cc failed: (Exit 1)
+foo/bar.cc:1:1: error: unknown type name 'intt'
+Target //foo:bar failed to build
+INFO: Elapsed time: 3.2s, Critical Path: 1.1s
+FAILED: Build did NOT complete successfully
+`
+
+func TestPrint(t *testing.T) {
+	oldMode := *mode
+	defer func() { *mode = oldMode }()
+	oldStderr := stderr
+	defer func() { stderr = oldStderr }()
+	var out bytes.Buffer
+	stderr = func() io.Writer { return &out }
+	log.SetWriter(&out)
+
+	for _, c := range []struct {
+		mode    string
+		success bool
+		want    string
+	}{
+		{Full, false, ""},
+		{Errors, false, buildOutput},
+		{Quiet, false, "ERROR: /ws/foo/BUILD:3:1: Compiling foo/bar.cc failed: (Exit 1)\nFAILED: Build did NOT complete successfully\n"},
+	} {
+		out.Reset()
+		*mode = c.mode
+		Print("build", []string{"//foo:bar"}, c.success, bytes.NewBufferString(buildOutput))
+		if out.String() != c.want {
+			t.Errorf("With --bazel_output=%s wanted %q, got %q", c.mode, c.want, out.String())
+		}
+	}
+
+	out.Reset()
+	*mode = Quiet
+	Print("build", []string{"//foo:bar"}, true, bytes.NewBufferString("INFO: Elapsed time: 0.2s\n"))
+	if !bytes.Contains(out.Bytes(), []byte("Built //foo:bar in 0.2s\n")) {
+		t.Errorf("Wanted a summary, got %q", out.String())
+	}
+}
+
+func TestSummary(t *testing.T) {
+	for _, c := range []struct {
+		command string
+		output  string
+		want    string
+	}{
+		{"build", "INFO: Elapsed time: 3.2s, Critical Path: 1.1s\nINFO: Build completed successfully, 5 total actions\n", "Built //foo:bar in 3.2s, 5 total actions"},
+		{"test", "INFO: Elapsed time: 3.2s\nINFO: Build completed successfully, 5 total actions\n\x1b[32mExecuted 2 out of 2 tests: 2 tests pass.\x1b[0m\n", "Tested //foo:bar in 3.2s, Executed 2 out of 2 tests: 2 tests pass."},
+		{"build", "", "Built //foo:bar"},
+	} {
+		if got := Summary(c.command, []string{"//foo:bar"}, c.output); got != c.want {
+			t.Errorf("Summary(%q) = %q, wanted %q", c.output, got, c.want)
+		}
+	}
+}
+
+func TestPrint_dedup(t *testing.T) {
+	oldMode, oldDedup := *mode, *dedup
+	defer func() { *mode, *dedup = oldMode, oldDedup }()
+	oldStderr := stderr
+	defer func() { stderr = oldStderr }()
+	var out bytes.Buffer
+	stderr = func() io.Writer { return &out }
+	log.SetWriter(&out)
+	*mode = Errors
+	*dedup = true
+
+	printOutput := func(success bool, output string) string {
+		out.Reset()
+		Print("build", []string{"//foo:bar"}, success, bytes.NewBufferString(output))
+		return out.String()
+	}
+	if got := printOutput(false, buildOutput); got != buildOutput {
+		t.Errorf("The first failure should be printed in full, got %q", got)
+	}
+	again := strings.Replace(buildOutput, "3.2s", "2.9s", 1)
+	if got := printOutput(false, again); !strings.Contains(got, "Same errors as the last build, 2nd time in a row") || strings.Contains(got, "intt") {
+		t.Errorf("Wanted a note about the same errors, got %q", got)
+	}
+	if got := printOutput(false, again); !strings.Contains(got, "3rd time in a row") {
+		t.Errorf("Wanted the errors to be counted, got %q", got)
+	}
+
+	changed := strings.Replace(buildOutput, "'intt'", "'inte'", 1)
+	want := "Errors changed since the last build:\n- foo/bar.cc:1:1: error: unknown type name 'intt'\n+ foo/bar.cc:1:1: error: unknown type name 'inte'\n"
+	if got := printOutput(false, changed); got != want {
+		t.Errorf("Wanted the changed lines %q, got %q", want, got)
+	}
+
+	printOutput(true, "INFO: Elapsed time: 0.2s\n")
+	if got := printOutput(false, changed); got != changed {
+		t.Errorf("A failure after a success should be printed in full, got %q", got)
+	}
+	other := "ERROR: /ws/baz/BUILD:1:1: no such package\nFAILED: Build did NOT complete successfully\n"
+	if got := printOutput(false, other); got != other {
+		t.Errorf("Different errors should be printed in full, got %q", got)
+	}
+}
+
+func TestOrdinal(t *testing.T) {
+	for n, want := range map[int]string{1: "1st", 2: "2nd", 3: "3rd", 4: "4th", 11: "11th", 12: "12th", 13: "13th", 21: "21st", 102: "102nd", 111: "111th"} {
+		if got := ordinal(n); got != want {
+			t.Errorf("ordinal(%d) = %q, wanted %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bazel_queue lets the iBazel processes that watch the same
+// workspace take turns running Bazel, in the order they asked for it, instead
+// of all of them blocking on Bazel's client lock without saying why.
+//
+// Every process that wants to run Bazel puts a ticket file into a directory
+// shared by the workspace and waits until its ticket is the oldest one. The
+// tickets are touched every heartbeat, so the ones of processes that died are
+// recognized by their age and removed.
+package bazel_queue
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+var coordinate = flag.Bool("coordinate", false, "Take turns running Bazel with the other iBazel processes of the workspace, and say which one is being waited for")
+
+var (
+	heartbeat    = time.Second
+	staleAfter   = 5 * time.Second
+	pollInterval = 100 * time.Millisecond
+)
+
+// Enabled reports whether --coordinate is on.
+func Enabled() bool {
+	return *coordinate
+}
+
+// Queue implements bazel.Lock for the processes of one workspace.
+type Queue struct {
+	dir string
+	pid int
+}
+
+// ticket is the content of a ticket file, shown to the processes waiting for
+// it.
+type ticket struct {
+	PID         int    `json:"pid"`
+	Description string `json:"description"`
+}
+
+// New creates the queue of the workspace at workspacePath.
+func New(workspacePath string) *Queue {
+	sum := sha1.Sum([]byte(workspacePath))
+	return &Queue{
+		dir: temp_dir.Path("queue", hex.EncodeToString(sum[:])),
+		pid: os.Getpid(),
+	}
+}
+
+// Acquire waits until every process that asked before is done running Bazel.
+// If the queue can't be used, it doesn't wait at all.
+func (q *Queue) Acquire(description string) (release func()) {
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		log.Errorf("Error creating the Bazel queue: %v", err)
+		return func() {}
+	}
+	// The names sort in the order the tickets were taken.
+	name := filepath.Join(q.dir, fmt.Sprintf("%020d-%d", time.Now().UnixNano(), q.pid))
+	data, _ := json.Marshal(ticket{PID: q.pid, Description: description})
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		log.Errorf("Error joining the Bazel queue: %v", err)
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go q.keepAlive(name, done)
+	release = func() {
+		close(done)
+		os.Remove(name)
+	}
+
+	start := time.Now()
+	waitingFor := ""
+	for {
+		first := q.first()
+		if first == "" || first == name {
+			break
+		}
+		if first != waitingFor {
+			waitingFor = first
+			if t, ok := readTicket(first); ok {
+				log.Logf("Waiting for the iBazel with PID %d to finish `bazel %s`...", t.PID, t.Description)
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+	if waitingFor != "" {
+		log.Logf("Waited %s for other iBazel processes", time.Since(start).Round(time.Millisecond))
+	}
+	return release
+}
+
+// first returns the oldest ticket that is still alive and removes the ones
+// that aren't.
+func (q *Queue) first() string {
+	f, err := os.Open(q.dir)
+	if err != nil {
+		return ""
+	}
+	names, err := f.Readdirnames(0)
+	f.Close()
+	if err != nil {
+		return ""
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		path := filepath.Join(q.dir, n)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > staleAfter {
+			os.Remove(path)
+			continue
+		}
+		return path
+	}
+	return ""
+}
+
+// keepAlive touches the ticket until done is closed.
+func (q *Queue) keepAlive(name string, done chan struct{}) {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			os.Chtimes(name, now, now)
+		}
+	}
+}
+
+func readTicket(path string) (ticket, bool) {
+	var t ticket
+	data, err := ioutil.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &t) != nil {
+		return t, false
+	}
+	return t, true
+}
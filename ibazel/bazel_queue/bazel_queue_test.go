@@ -0,0 +1,99 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel_queue
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := &bytes.Buffer{}
+	log.SetWriter(out)
+	defer log.SetWriter(os.Stderr)
+	pollInterval = time.Millisecond
+
+	first := &Queue{dir: dir, pid: 1}
+	second := &Queue{dir: dir, pid: 2}
+
+	release := first.Acquire("build //foo")
+	acquired := make(chan func())
+	go func() { acquired <- second.Acquire("test //bar") }()
+
+	select {
+	case <-acquired:
+		t.Fatal("The second process didn't wait for the first one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("The second process wasn't let through after the first one was done")
+	}
+
+	if !strings.Contains(out.String(), "Waiting for the iBazel with PID 1 to finish `bazel build //foo`...") {
+		t.Errorf("Expected a message about the process being waited for, got %q", out.String())
+	}
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 {
+		t.Errorf("Tickets were left behind: %v", names)
+	}
+}
+
+func TestAcquireSkipsStaleTickets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The ticket of a process that died a minute ago.
+	stale := filepath.Join(dir, "00000000000000000001-1")
+	if err := ioutil.WriteFile(stale, []byte(`{"pid":1,"description":"build //foo"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	q := &Queue{dir: dir, pid: 2}
+	done := make(chan struct{})
+	go func() {
+		q.Acquire("build //bar")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waited for a stale ticket")
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("The stale ticket wasn't removed")
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bep reads the Build Event Protocol stream of a Bazel command, so
+// that iBazel can tell which targets built and which files they produced
+// without scraping the output of the command.
+//
+// Bazel writes the stream as newline delimited JSON with
+// --build_event_json_file. The binary form of --build_event_binary_file would
+// need the build_event_stream protos, which aren't part of this repository.
+package bep
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/experiments"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+var experiment = experiments.Register("build_events", "Read the Build Event Protocol stream of every build and test, like --build_events")
+
+var buildEvents = flag.Bool("build_events", false, "Pass --build_event_json_file to every `bazel build` and `bazel test` and tell the lifecycle integrations which targets built, which tests passed and which files they produced")
+
+// Whether a lifecycle integration needs the build events, see Require.
+var required = false
+
+// Enabled reports whether --build_events was given or an integration requires
+// the build events.
+func Enabled() bool {
+	return *buildEvents || required || experiment.Enabled()
+}
+
+// Require turns on the build events for an integration that can't work
+// without them, as if --build_events was given.
+func Require() {
+	required = true
+}
+
+// Target is what the build events say about a single target.
+type Target struct {
+	Label   string
+	Success bool
+	// The paths of the files in the default output group of the target.
+	Outputs []string
+	// The overall status of a test target, e.g. "PASSED" or "FLAKY", empty
+	// for targets that aren't tests or weren't tested.
+	TestStatus string
+	// The paths of the test.xml files of a test target, one per shard and
+	// run, from the last attempt of each.
+	TestXML []string
+	// Whether every run of a test target was cached, locally or remotely.
+	TestCached bool
+}
+
+// Result is what the build events say about a whole command.
+type Result struct {
+	// Whether the command finished successfully, false if the stream ended
+	// before it finished.
+	Success bool
+	// The name of the exit code of the command, e.g. "SUCCESS" or
+	// "BUILD_FAILURE".
+	ExitCode string
+	// The targets the command built or tested, sorted by label.
+	Targets []Target
+	// The ID of the iBazel iteration that ran the command, from the build
+	// metadata that the stream adds to it.
+	Iteration string
+}
+
+// Target returns what the build events say about the target with the given
+// label, or nil if they don't mention it.
+func (r *Result) Target(label string) *Target {
+	for i := range r.Targets {
+		if r.Targets[i].Label == label {
+			return &r.Targets[i]
+		}
+	}
+	return nil
+}
+
+// The key of the build metadata that holds the ID of the iBazel iteration,
+// so that the build events, including the ones sent to a Build Event Service,
+// can be matched with the iteration.
+const iterationKey = "IBAZEL_ITERATION"
+
+// Stream is the file a single Bazel command writes its build events to.
+type Stream struct {
+	path      string
+	iteration string
+}
+
+// NewStream creates an empty file for the build events of the command that
+// iteration runs.
+func NewStream(iteration string) (*Stream, error) {
+	f, err := temp_dir.TempFile("bep*.json")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &Stream{path: f.Name(), iteration: iteration}, nil
+}
+
+// Args returns the Bazel flags that make the command write its build events
+// to the stream and tag them with the iteration. It returns no flags for a
+// nil stream.
+func (s *Stream) Args() []string {
+	if s == nil {
+		return nil
+	}
+	args := []string{"--build_event_json_file=" + s.path}
+	if s.iteration != "" {
+		args = append(args, "--build_metadata="+iterationKey+"="+s.iteration)
+	}
+	return args
+}
+
+// Result parses what the command wrote to the stream.
+func (s *Stream) Result() (*Result, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Close removes the file of the stream.
+func (s *Stream) Close() {
+	if s != nil {
+		os.Remove(s.path)
+	}
+}
+
+type file struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+type fileSetRef struct {
+	ID string `json:"id"`
+}
+
+// event holds the parts of a build event that iBazel looks at. Bazel's JSON
+// uses the camel case names of the fields of build_event_stream.proto.
+type event struct {
+	ID struct {
+		TargetCompleted *struct {
+			Label string `json:"label"`
+		} `json:"targetCompleted"`
+		TestSummary *struct {
+			Label string `json:"label"`
+		} `json:"testSummary"`
+		TestResult *struct {
+			Label   string `json:"label"`
+			Run     int    `json:"run"`
+			Shard   int    `json:"shard"`
+			Attempt int    `json:"attempt"`
+		} `json:"testResult"`
+		NamedSet *fileSetRef `json:"namedSet"`
+	} `json:"id"`
+
+	Completed *struct {
+		Success     bool `json:"success"`
+		OutputGroup []struct {
+			Name     string       `json:"name"`
+			FileSets []fileSetRef `json:"fileSets"`
+		} `json:"outputGroup"`
+		ImportantOutput []file `json:"importantOutput"`
+	} `json:"completed"`
+	TestSummary *struct {
+		OverallStatus string `json:"overallStatus"`
+	} `json:"testSummary"`
+	TestResult *struct {
+		TestActionOutput []file `json:"testActionOutput"`
+		CachedLocally    bool   `json:"cachedLocally"`
+		ExecutionInfo    struct {
+			CachedRemotely bool `json:"cachedRemotely"`
+		} `json:"executionInfo"`
+	} `json:"testResult"`
+	NamedSetOfFiles *struct {
+		Files    []file       `json:"files"`
+		FileSets []fileSetRef `json:"fileSets"`
+	} `json:"namedSetOfFiles"`
+	BuildMetadata *struct {
+		Metadata map[string]string `json:"metadata"`
+	} `json:"buildMetadata"`
+	Finished *struct {
+		OverallSuccess bool `json:"overallSuccess"`
+		ExitCode       *struct {
+			Name string `json:"name"`
+		} `json:"exitCode"`
+	} `json:"finished"`
+}
+
+// Parse reads a stream of build events in Bazel's JSON form. The last event
+// may be cut off, as it is when the command was cancelled while writing it,
+// and is then ignored.
+func Parse(r io.Reader) (*Result, error) {
+	result := &Result{}
+	targets := map[string]*Target{}
+	target := func(label string) *Target {
+		t, ok := targets[label]
+		if !ok {
+			t = &Target{Label: label, Success: true}
+			targets[label] = t
+		}
+		return t
+	}
+	namedSets := map[string][]file{}
+	nestedSets := map[string][]string{}
+	outputSets := map[string][]string{}
+	// The test.xml of the latest attempt of every run and shard of a test.
+	type testRun struct{ run, shard int }
+	type testXML struct {
+		attempt int
+		path    string
+	}
+	testXMLs := map[string]map[testRun]testXML{}
+	// Whether every run of a test so far was cached.
+	testCached := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	// Events that list many files can be long.
+	scanner.Buffer(nil, 64*1024*1024)
+	// An invalid event is only an error if another one follows it.
+	var invalid error
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if invalid != nil {
+			return nil, invalid
+		}
+		var e event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			invalid = fmt.Errorf("invalid build event: %v", err)
+			continue
+		}
+
+		switch {
+		case e.ID.TargetCompleted != nil:
+			// Targets that didn't build have an aborted event or a completed
+			// one without success.
+			t := target(e.ID.TargetCompleted.Label)
+			if e.Completed == nil || !e.Completed.Success {
+				t.Success = false
+			}
+			if e.Completed == nil {
+				continue
+			}
+			found := false
+			for _, group := range e.Completed.OutputGroup {
+				if group.Name != "default" {
+					continue
+				}
+				for _, set := range group.FileSets {
+					outputSets[t.Label] = append(outputSets[t.Label], set.ID)
+					found = true
+				}
+			}
+			if !found {
+				// Older versions of Bazel only list the outputs here.
+				for _, f := range e.Completed.ImportantOutput {
+					t.Outputs = append(t.Outputs, path(f))
+				}
+			}
+		case e.ID.TestSummary != nil && e.TestSummary != nil:
+			target(e.ID.TestSummary.Label).TestStatus = e.TestSummary.OverallStatus
+		case e.ID.TestResult != nil && e.TestResult != nil:
+			id := e.ID.TestResult
+			cached := e.TestResult.CachedLocally || e.TestResult.ExecutionInfo.CachedRemotely
+			if prev, ok := testCached[id.Label]; !ok || prev {
+				testCached[id.Label] = cached
+			}
+			for _, f := range e.TestResult.TestActionOutput {
+				if f.Name != "test.xml" {
+					continue
+				}
+				target(id.Label)
+				if testXMLs[id.Label] == nil {
+					testXMLs[id.Label] = map[testRun]testXML{}
+				}
+				run := testRun{id.Run, id.Shard}
+				if prev, ok := testXMLs[id.Label][run]; !ok || prev.attempt < id.Attempt {
+					testXMLs[id.Label][run] = testXML{id.Attempt, path(f)}
+				}
+			}
+		case e.ID.NamedSet != nil && e.NamedSetOfFiles != nil:
+			namedSets[e.ID.NamedSet.ID] = e.NamedSetOfFiles.Files
+			for _, set := range e.NamedSetOfFiles.FileSets {
+				nestedSets[e.ID.NamedSet.ID] = append(nestedSets[e.ID.NamedSet.ID], set.ID)
+			}
+		case e.BuildMetadata != nil:
+			result.Iteration = e.BuildMetadata.Metadata[iterationKey]
+		case e.Finished != nil:
+			if e.Finished.ExitCode != nil {
+				result.ExitCode = e.Finished.ExitCode.Name
+				result.Success = e.Finished.ExitCode.Name == "SUCCESS"
+			} else {
+				result.Success = e.Finished.OverallSuccess
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for label, sets := range outputSets {
+		t := targets[label]
+		seen := map[string]bool{}
+		var visit func(id string)
+		visit = func(id string) {
+			if seen[id] {
+				return
+			}
+			seen[id] = true
+			for _, f := range namedSets[id] {
+				t.Outputs = append(t.Outputs, path(f))
+			}
+			for _, nested := range nestedSets[id] {
+				visit(nested)
+			}
+		}
+		for _, id := range sets {
+			visit(id)
+		}
+	}
+
+	for label, cached := range testCached {
+		target(label).TestCached = cached
+	}
+
+	for label, runs := range testXMLs {
+		keys := make([]testRun, 0, len(runs))
+		for run := range runs {
+			keys = append(keys, run)
+		}
+		sort.Slice(keys, func(a, b int) bool {
+			if keys[a].shard != keys[b].shard {
+				return keys[a].shard < keys[b].shard
+			}
+			return keys[a].run < keys[b].run
+		})
+		for _, run := range keys {
+			targets[label].TestXML = append(targets[label].TestXML, runs[run].path)
+		}
+	}
+
+	for _, t := range targets {
+		result.Targets = append(result.Targets, *t)
+	}
+	sort.Slice(result.Targets, func(a, b int) bool {
+		return result.Targets[a].Label < result.Targets[b].Label
+	})
+	return result, nil
+}
+
+// path returns the local path of an output file, or its name relative to the
+// output directory if it isn't a local file, e.g. with remote execution.
+func path(f file) string {
+	u, err := url.Parse(f.URI)
+	if err != nil || u.Scheme != "file" {
+		return f.Name
+	}
+	return filepath.FromSlash(u.Path)
+}
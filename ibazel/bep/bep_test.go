@@ -0,0 +1,156 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bep
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const events = `{"id":{"started":{}},"started":{"command":"test"}}
+{"id":{"namedSet":{"id":"1"}},"namedSetOfFiles":{"files":[{"name":"lib/liblib.a","uri":"file:///out/bin/lib/liblib.a"}]}}
+{"id":{"namedSet":{"id":"0"}},"namedSetOfFiles":{"files":[{"name":"app/app","uri":"file:///out/bin/app/app"}],"fileSets":[{"id":"1"}]}}
+{"id":{"targetCompleted":{"label":"//app:app","configuration":{"id":"abc"}}},"completed":{"success":true,"outputGroup":[{"name":"default","fileSets":[{"id":"0"}]}]}}
+{"id":{"targetCompleted":{"label":"//old:old"}},"completed":{"success":true,"importantOutput":[{"name":"old/old","uri":"bytestream://remote/blobs/123"}]}}
+{"id":{"targetCompleted":{"label":"//broken:broken"}},"aborted":{"reason":"ANALYSIS_FAILURE"}}
+{"id":{"targetCompleted":{"label":"//app:test"}},"completed":{"success":true}}
+{"id":{"testResult":{"label":"//app:test","run":1,"shard":2,"attempt":1}},"testResult":{"testActionOutput":[{"name":"test.log","uri":"file:///out/testlogs/app/test/shard_2_of_2/test.log"},{"name":"test.xml","uri":"file:///out/testlogs/app/test/shard_2_of_2/test.xml"}],"status":"PASSED"}}
+{"id":{"testResult":{"label":"//app:test","run":1,"shard":1,"attempt":1}},"testResult":{"testActionOutput":[{"name":"test.xml","uri":"file:///out/testlogs/app/test/shard_1_of_2/test_attempts/attempt_1.xml"}],"status":"FAILED"}}
+{"id":{"testResult":{"label":"//app:test","run":1,"shard":1,"attempt":2}},"testResult":{"testActionOutput":[{"name":"test.xml","uri":"file:///out/testlogs/app/test/shard_1_of_2/test.xml"}],"status":"FAILED"}}
+{"id":{"testSummary":{"label":"//app:test"}},"testSummary":{"overallStatus":"FAILED","totalRunCount":1}}
+
+{"id":{"buildFinished":{}},"finished":{"overallSuccess":false,"exitCode":{"name":"TESTS_FAILED","code":3}}}
+`
+
+func TestParse(t *testing.T) {
+	result, err := Parse(strings.NewReader(events))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Result{
+		Success:  false,
+		ExitCode: "TESTS_FAILED",
+		Targets: []Target{
+			{Label: "//app:app", Success: true, Outputs: []string{"/out/bin/app/app", "/out/bin/lib/liblib.a"}},
+			{Label: "//app:test", Success: true, TestStatus: "FAILED", TestXML: []string{"/out/testlogs/app/test/shard_1_of_2/test.xml", "/out/testlogs/app/test/shard_2_of_2/test.xml"}},
+			{Label: "//broken:broken", Success: false},
+			{Label: "//old:old", Success: true, Outputs: []string{"old/old"}},
+		},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Parse() = %+v, want %+v", result, want)
+	}
+
+	if got := result.Target("//app:test"); got == nil || got.TestStatus != "FAILED" {
+		t.Errorf("Target(//app:test) = %+v", got)
+	}
+	if got := result.Target("//missing"); got != nil {
+		t.Errorf("Target(//missing) = %+v, want nil", got)
+	}
+}
+
+func TestParseSuccess(t *testing.T) {
+	for _, c := range []struct {
+		events string
+		want   bool
+	}{
+		{`{"id":{"buildFinished":{}},"finished":{"exitCode":{"name":"SUCCESS"}}}`, true},
+		// Before exit codes were added.
+		{`{"id":{"buildFinished":{}},"finished":{"overallSuccess":true}}`, true},
+		// The stream ended before the command finished.
+		{`{"id":{"started":{}},"started":{}}`, false},
+	} {
+		result, err := Parse(strings.NewReader(c.events))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Success != c.want {
+			t.Errorf("Parse(%s).Success = %v, want %v", c.events, result.Success, c.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader("{not json\n" + events)); err == nil {
+		t.Error("Parse() of invalid JSON returned no error")
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	truncated := events + `{"id":{"buildMetrics":{}},"buildMet`
+	result, err := Parse(strings.NewReader(truncated))
+	if err != nil {
+		t.Fatalf("Parse() of a stream with a truncated last event: %v", err)
+	}
+	if len(result.Targets) != 4 {
+		t.Errorf("Parse() of a stream with a truncated last event found %d targets, want 4", len(result.Targets))
+	}
+}
+
+func TestParseTestCached(t *testing.T) {
+	result, err := Parse(strings.NewReader(`{"id":{"testResult":{"label":"//a:test","run":1,"shard":1,"attempt":1}},"testResult":{"cachedLocally":true}}
+{"id":{"testResult":{"label":"//a:test","run":1,"shard":2,"attempt":1}},"testResult":{"executionInfo":{"cachedRemotely":true}}}
+{"id":{"testResult":{"label":"//b:test","run":1,"shard":1,"attempt":1}},"testResult":{"cachedLocally":true}}
+{"id":{"testResult":{"label":"//b:test","run":1,"shard":2,"attempt":1}},"testResult":{}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Target("//a:test").TestCached {
+		t.Error("//a:test should be cached, all of its shards were")
+	}
+	if result.Target("//b:test").TestCached {
+		t.Error("//b:test shouldn't be cached, one of its shards ran")
+	}
+}
+
+func TestStream(t *testing.T) {
+	var nilStream *Stream
+	if args := nilStream.Args(); args != nil {
+		t.Errorf("Args() of a nil stream = %v, want nil", args)
+	}
+	nilStream.Close()
+
+	s, err := NewStream("3f9c27a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	args := s.Args()
+	if len(args) != 2 || !strings.HasPrefix(args[0], "--build_event_json_file=") || args[1] != "--build_metadata=IBAZEL_ITERATION=3f9c27a1" {
+		t.Fatalf("Args() = %v", args)
+	}
+	path := strings.TrimPrefix(args[0], "--build_event_json_file=")
+	events := `{"id":{"buildMetadata":{}},"buildMetadata":{"metadata":{"IBAZEL_ITERATION":"3f9c27a1"}}}
+{"id":{"buildFinished":{}},"finished":{"exitCode":{"name":"SUCCESS"}}}
+`
+	if err := ioutil.WriteFile(path, []byte(events), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success {
+		t.Errorf("Result().Success = false, want true")
+	}
+	if result.Iteration != "3f9c27a1" {
+		t.Errorf("Result().Iteration = %q, want %q", result.Iteration, "3f9c27a1")
+	}
+}
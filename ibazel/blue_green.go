@@ -0,0 +1,285 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/blue_green"
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var blueGreenTimeout = flag.Duration("blue_green_timeout", 30*time.Second, "How long a new instance of an ibazel_blue_green target may take to answer before it is stopped and the previous instance keeps serving")
+
+// The tag that restarts a target blue/green behind a proxy on its port, e.g.
+// ibazel_blue_green=:8080 or ibazel_blue_green=:8080/healthz, see
+// blue_green.ParseSpec.
+const blueGreenTagPrefix = "ibazel_blue_green="
+
+// How often a new instance is asked whether it is ready.
+var blueGreenPollInterval = 100 * time.Millisecond
+
+// blueGreenTag returns the spec of the ibazel_blue_green tag, if any.
+func blueGreenTag(tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, blueGreenTagPrefix) {
+			return strings.TrimPrefix(tag, blueGreenTagPrefix)
+		}
+	}
+	return ""
+}
+
+// blueGreen returns the command that runs target behind a proxy on the port
+// of spec. Every instance gets a port of its own in $PORT, and the proxy only
+// switches to a new instance once it answers.
+func (i *IBazel) blueGreen(target, spec string, tags []string) (command.Command, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("blue/green restarts aren't supported on Windows")
+	}
+	if runnerSpec(tags) != "local" {
+		return nil, errors.New("it isn't run locally")
+	}
+	if len(listenTags(tags)) > 0 {
+		return nil, errors.New("its ibazel_listen tags already hold its port")
+	}
+	addr, path, err := blue_green.ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, ok := i.proxies[target]
+	if !ok {
+		if proxy, err = blue_green.Listen(addr); err != nil {
+			return nil, err
+		}
+		if i.proxies == nil {
+			i.proxies = map[string]*blue_green.Proxy{}
+		}
+		i.proxies[target] = proxy
+	}
+	log.Logf("Restarting %s blue/green behind %s", target, proxy.Addr())
+
+	args := append([]string{}, i.args...)
+	return &blueGreenCommand{
+		target:       target,
+		proxy:        proxy,
+		readyPath:    path,
+		readyResults: i.blueGreenReady,
+		newInstance: func(port int) command.Command {
+			cmd := commandDefaultCommand(i.startupArgs, i.bazelArgsFor("run"), target, args)
+			r := command.Env(nil, append(i.targetEnv(), fmt.Sprintf("PORT=%d", port)))
+			if prefix := strings.Fields(*runUnder); len(prefix) > 0 {
+				r = command.RunUnder(r, prefix)
+			}
+			command.SetRunner(cmd, r)
+			return cmd
+		},
+	}, nil
+}
+
+// closeProxies closes the proxy of target, or of every target if it is
+// empty.
+func (i *IBazel) closeProxies(target string) {
+	targets := []string{target}
+	if target == "" {
+		targets = make([]string, 0, len(i.proxies))
+		for t := range i.proxies {
+			targets = append(targets, t)
+		}
+		sort.Strings(targets)
+	}
+	for _, t := range targets {
+		if proxy, ok := i.proxies[t]; ok {
+			proxy.Close()
+			delete(i.proxies, t)
+		}
+	}
+}
+
+// blueGreenInstance is one start of a blue/green target.
+type blueGreenInstance struct {
+	cmd  command.Command
+	addr string
+	// Closed when the instance is given up on before it got ready.
+	stop chan struct{}
+}
+
+// blueGreenReady is what waiting for a new instance of a blue/green target
+// found. The main loop hands it back to the command, see
+// blueGreenCommand.ready.
+type blueGreenReady struct {
+	cmd  *blueGreenCommand
+	inst *blueGreenInstance
+	err  error
+}
+
+// blueGreenCommand keeps the live instance of a target serving while the
+// target is rebuilt and a new instance starts, and only stops it once the
+// proxy switched over to the new one.
+type blueGreenCommand struct {
+	target    string
+	proxy     *blue_green.Proxy
+	readyPath string
+	// Creates the command of an instance listening on port.
+	newInstance func(port int) command.Command
+	// Where the instances that got ready, or didn't in time, are sent.
+	readyResults chan<- blueGreenReady
+
+	live *blueGreenInstance
+	// The instance waiting to get ready, if any.
+	pending *blueGreenInstance
+}
+
+func (c *blueGreenCommand) startInstance(logFile *os.File) (*blueGreenInstance, *bytes.Buffer, error) {
+	port, err := blue_green.FreePort()
+	if err != nil {
+		return nil, nil, err
+	}
+	inst := &blueGreenInstance{
+		cmd:  c.newInstance(port),
+		addr: fmt.Sprintf("localhost:%d", port),
+		stop: make(chan struct{}),
+	}
+	outputBuffer, err := inst.cmd.Start(logFile)
+	if err != nil {
+		return nil, outputBuffer, err
+	}
+	return inst, outputBuffer, nil
+}
+
+// waitReady waits off the main loop until the ready path of inst answers,
+// and sends the result to readyResults. An instance that was still waiting
+// is given up on.
+func (c *blueGreenCommand) waitReady(inst *blueGreenInstance) {
+	c.abandonPending()
+	c.pending = inst
+	url := "http://" + inst.addr + c.readyPath
+	deadline := time.Now().Add(*blueGreenTimeout)
+	results, probe, interval := c.readyResults, probeHealth, blueGreenPollInterval
+	go func() {
+		for {
+			err := probe(url, time.Second)
+			if err == nil || time.Now().After(deadline) {
+				if err != nil {
+					err = fmt.Errorf("%s isn't ready after %v: %v", c.target, *blueGreenTimeout, err)
+				}
+				select {
+				case results <- blueGreenReady{cmd: c, inst: inst, err: err}:
+				case <-inst.stop:
+				}
+				return
+			}
+			select {
+			case <-time.After(interval):
+			case <-inst.stop:
+				return
+			}
+		}
+	}()
+}
+
+// abandonPending stops the instance waiting to get ready, if any.
+func (c *blueGreenCommand) abandonPending() {
+	if c.pending == nil {
+		return
+	}
+	close(c.pending.stop)
+	c.pending.cmd.Terminate()
+	c.pending = nil
+}
+
+// ready switches the proxy to inst, which got ready or, if err is set,
+// didn't in time. A new instance that didn't get ready is stopped, and the
+// live one keeps serving. The first instance is switched to either way, since
+// there is nothing else to serve.
+func (c *blueGreenCommand) ready(inst *blueGreenInstance, err error) {
+	if inst != c.pending {
+		// Given up on meanwhile.
+		return
+	}
+	c.pending = nil
+	if c.live == nil {
+		if err != nil {
+			log.Errorf("%v", err)
+		}
+		c.live = inst
+		c.proxy.Switch(inst.addr)
+		return
+	}
+	if err != nil {
+		log.Errorf("%v, the previous instance keeps serving", err)
+		inst.cmd.Terminate()
+		return
+	}
+	c.proxy.Switch(inst.addr)
+	log.Logf("Switched %s to the new instance on %s", c.target, inst.addr)
+	c.live.cmd.Terminate()
+	c.live = inst
+}
+
+// Start starts the first instance, or a new one after Terminate. The proxy
+// switches to it once it is ready, see ready.
+func (c *blueGreenCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
+	inst, outputBuffer, err := c.startInstance(logFile)
+	if err != nil {
+		return outputBuffer, err
+	}
+	c.waitReady(inst)
+	return outputBuffer, nil
+}
+
+func (c *blueGreenCommand) Terminate() {
+	c.abandonPending()
+	if c.live != nil {
+		c.live.cmd.Terminate()
+		c.live = nil
+	}
+	c.proxy.Switch("")
+}
+
+// BeforeRebuild leaves the live instance serving during the build.
+func (c *blueGreenCommand) BeforeRebuild() {}
+
+// AfterRebuild starts a new instance next to the live one, which keeps
+// serving until the new one is ready, see ready.
+func (c *blueGreenCommand) AfterRebuild(logFile *os.File) *bytes.Buffer {
+	if c.live == nil {
+		outputBuffer, _ := c.Start(logFile)
+		return outputBuffer
+	}
+	inst, outputBuffer, err := c.startInstance(logFile)
+	if err != nil {
+		log.Errorf("Error starting a new instance of %s, the previous one keeps serving: %v", c.target, err)
+		return outputBuffer
+	}
+	c.waitReady(inst)
+	return outputBuffer
+}
+
+func (c *blueGreenCommand) IsSubprocessRunning() bool {
+	if c.pending != nil && c.pending.cmd.IsSubprocessRunning() {
+		return true
+	}
+	return c.live != nil && c.live.cmd.IsSubprocessRunning()
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blue_green forwards the connections to a target's port to whichever
+// instance of the target is live, so that a new instance can be started on
+// another port and take over once it is ready, without a moment where the
+// port refuses connections.
+package blue_green
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long connecting to the live instance may take.
+const dialTimeout = 5 * time.Second
+
+// Proxy listens on the port of a target and forwards every connection to the
+// live instance of the target.
+type Proxy struct {
+	listener net.Listener
+
+	lock    sync.Mutex // guards backend
+	backend string
+}
+
+// Listen opens the port of a target at addr, e.g. ":8080". Connections are
+// refused until Switch sets the live instance.
+func Listen(addr string) (*Proxy, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{listener: l}
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy listens on.
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Switch sends the connections accepted from now on to backend. Connections
+// to the previous instance are left alone.
+func (p *Proxy) Switch(backend string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.backend = backend
+}
+
+// Backend returns the address of the live instance, "" if there is none.
+func (p *Proxy) Backend() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.backend
+}
+
+// Close stops listening. Forwarded connections stay open until either side
+// closes them.
+func (p *Proxy) Close() error {
+	return p.listener.Close()
+}
+
+func (p *Proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+func (p *Proxy) forward(conn net.Conn) {
+	defer conn.Close()
+	backend := p.Backend()
+	if backend == "" {
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", backend, dialTimeout)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// Pass on that one side is done sending, so that the other side can
+		// still answer.
+		if c, ok := dst.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(upstream, conn)
+	go pipe(conn, upstream)
+	<-done
+	<-done
+}
+
+// ParseSpec splits the spec of a blue/green target, "<addr>[/<path>]", into
+// the address of its port and the path that answers once an instance is
+// ready, "/" by default, e.g. ":8080/healthz".
+func ParseSpec(spec string) (addr string, path string, err error) {
+	addr, path = spec, "/"
+	if slash := strings.Index(spec, "/"); slash >= 0 {
+		addr, path = spec[:slash], spec[slash:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", fmt.Errorf("%q isn't a valid address, e.g. :8080 or :8080/healthz: %v", spec, err)
+	}
+	return addr, path, nil
+}
+
+// FreePort returns a port of localhost that nothing listens on, for the next
+// instance of a target.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
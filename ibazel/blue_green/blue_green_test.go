@@ -0,0 +1,116 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blue_green
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// instance answers every connection with its name.
+func instance(t *testing.T, name string) (string, func()) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, name)
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func get(t *testing.T, addr string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestProxy(t *testing.T) {
+	p, err := Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if got := get(t, p.Addr()); got != "" {
+		t.Errorf("Got %q before there was a live instance, want nothing", got)
+	}
+
+	blue, stopBlue := instance(t, "blue")
+	defer stopBlue()
+	p.Switch(blue)
+	if got := get(t, p.Addr()); got != "blue" {
+		t.Errorf("Got %q, want blue", got)
+	}
+
+	green, stopGreen := instance(t, "green")
+	defer stopGreen()
+	p.Switch(green)
+	stopBlue()
+	if got := get(t, p.Addr()); got != "green" {
+		t.Errorf("Got %q after switching, want green", got)
+	}
+	if p.Backend() != green {
+		t.Errorf("Backend() = %q, want %q", p.Backend(), green)
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		addr string
+		path string
+		err  bool
+	}{
+		{":8080", ":8080", "/", false},
+		{"localhost:8080/healthz", "localhost:8080", "/healthz", false},
+		{":8080/api/ready", ":8080", "/api/ready", false},
+		{"8080", "", "", true},
+		{"/healthz", "", "", true},
+	} {
+		addr, path, err := ParseSpec(tc.spec)
+		if addr != tc.addr || path != tc.path || (err != nil) != tc.err {
+			t.Errorf("ParseSpec(%q) = %q, %q, %v, want %q, %q and an error: %v", tc.spec, addr, path, err, tc.addr, tc.path, tc.err)
+		}
+	}
+}
+
+func TestFreePort(t *testing.T) {
+	port, err := FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("Can't listen on the free port %d: %v", port, err)
+	}
+	l.Close()
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/blue_green"
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/analysis"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+func TestBlueGreenCommand(t *testing.T) {
+	defer setFlag(t, "blue_green_timeout", "50ms")()
+	defer func(old time.Duration) { blueGreenPollInterval = old }(blueGreenPollInterval)
+	blueGreenPollInterval = time.Millisecond
+	ready := true
+	defer func(old func(string, time.Duration) error) { probeHealth = old }(probeHealth)
+	probeHealth = func(url string, timeout time.Duration) error {
+		if !strings.HasSuffix(url, "/healthz") {
+			t.Errorf("Probed %s, want the ready path", url)
+		}
+		if !ready {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	proxy, err := blue_green.Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	var instances []*mockCommand
+	results := make(chan blueGreenReady)
+	c := &blueGreenCommand{
+		target:    "//my:server",
+		proxy:     proxy,
+		readyPath: "/healthz",
+		newInstance: func(port int) command.Command {
+			cmd := &mockCommand{}
+			instances = append(instances, cmd)
+			return cmd
+		},
+		readyResults: results,
+	}
+	// What the main loop does.
+	waitReady := func() {
+		r := <-results
+		r.cmd.ready(r.inst, r.err)
+	}
+
+	c.Start(nil)
+	assertEqual(t, 1, len(instances), "Instances after Start")
+	assertEqual(t, "", proxy.Backend(), "Backend before the first instance is ready")
+	waitReady()
+	assertEqual(t, c.live.addr, proxy.Backend(), "Backend after Start")
+	first := c.live.addr
+
+	c.BeforeRebuild()
+	assertEqual(t, false, instances[0].terminated, "First instance terminated before the rebuild")
+	c.AfterRebuild(nil)
+	assertEqual(t, first, proxy.Backend(), "Backend before the new instance is ready")
+	waitReady()
+	assertEqual(t, 2, len(instances), "Instances after the first rebuild")
+	assertEqual(t, true, instances[0].terminated, "First instance terminated after the switch")
+	assertEqual(t, c.live.addr, proxy.Backend(), "Backend after the first rebuild")
+	if c.live.addr == first {
+		t.Errorf("The new instance got the port of the first one, %s", first)
+	}
+
+	ready = false
+	second := c.live.addr
+	c.AfterRebuild(nil)
+	waitReady()
+	assertEqual(t, 3, len(instances), "Instances after the second rebuild")
+	assertEqual(t, true, instances[2].terminated, "Instance that didn't get ready terminated")
+	assertEqual(t, false, instances[1].terminated, "Live instance terminated although the new one didn't get ready")
+	assertEqual(t, second, proxy.Backend(), "Backend after a new instance didn't get ready")
+
+	c.Terminate()
+	assertEqual(t, true, instances[1].terminated, "Live instance terminated by Terminate")
+	assertEqual(t, "", proxy.Backend(), "Backend after Terminate")
+}
+
+func TestBlueGreenCommand_superseded(t *testing.T) {
+	defer func(old time.Duration) { blueGreenPollInterval = old }(blueGreenPollInterval)
+	blueGreenPollInterval = time.Millisecond
+	defer func(old func(string, time.Duration) error) { probeHealth = old }(probeHealth)
+	probeHealth = func(url string, timeout time.Duration) error {
+		return errors.New("connection refused")
+	}
+
+	proxy, err := blue_green.Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	var instances []*mockCommand
+	c := &blueGreenCommand{
+		target: "//my:server",
+		proxy:  proxy,
+		newInstance: func(port int) command.Command {
+			cmd := &mockCommand{}
+			instances = append(instances, cmd)
+			return cmd
+		},
+		readyResults: make(chan blueGreenReady),
+	}
+
+	c.Start(nil)
+	stale := c.pending
+	c.AfterRebuild(nil)
+	assertEqual(t, 2, len(instances), "Instances after the rebuild")
+	assertEqual(t, true, instances[0].terminated, "Instance that was still waiting terminated")
+	c.ready(stale, nil)
+	assertEqual(t, "", proxy.Backend(), "Backend after the superseded instance got ready")
+
+	c.Terminate()
+	assertEqual(t, true, instances[1].terminated, "Waiting instance terminated by Terminate")
+	assertEqual(t, false, c.IsSubprocessRunning(), "Running after Terminate")
+}
+
+func TestIBazelSetupRun_closesProxy(t *testing.T) {
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := oldBazelNew()
+		mockBazel.AddCQueryResponse("//my:server", &analysis.CqueryResult{
+			Results: []*analysis.ConfiguredTarget{{
+				Target: &blaze_query.Target{
+					Type: blaze_query.Target_RULE.Enum(),
+					Rule: &blaze_query.Rule{},
+				},
+			}},
+		})
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	proxy, err := blue_green.Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.proxies = map[string]*blue_green.Proxy{"//my:server": proxy}
+
+	i.setupRun("//my:server", nil, -1)
+	assertEqual(t, 0, len(i.proxies), "Proxies after the ibazel_blue_green tag was removed")
+	if conn, err := net.Dial("tcp", proxy.Addr()); err == nil {
+		conn.Close()
+		t.Errorf("The proxy still listens on %s", proxy.Addr())
+	}
+}
+
+func TestBlueGreen_refused(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	for _, tags := range [][]string{
+		{"ibazel_blue_green=localhost:0", "ibazel_runner=docker:dev"},
+		{"ibazel_blue_green=localhost:0", "ibazel_listen=:8080"},
+		{"ibazel_blue_green=8080"},
+	} {
+		if _, err := i.blueGreen("//my:server", blueGreenTag(tags), tags); err == nil {
+			t.Errorf("Blue/green restarts with %q, want an error", tags)
+		}
+	}
+	assertEqual(t, 0, len(i.proxies), "Proxies opened")
+}
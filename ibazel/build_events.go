@@ -0,0 +1,71 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// startBuildEvents creates the file for the build events of the next build or
+// test, see --build_events, or for the output files of the targets, see
+// --publish_artifacts. needed asks for them regardless, e.g. to tell which of
+// the tests failed. It returns nil, whose Args are empty, when nothing needs
+// them or the file can't be created.
+func (i *IBazel) startBuildEvents(needed bool) *bep.Stream {
+	if !needed && !bep.Enabled() && !i.wantsArtifacts() {
+		return nil
+	}
+	events, err := bep.NewStream(i.IterationID())
+	if err != nil {
+		log.Errorf("Error creating the file for the build events: %v", err)
+		return nil
+	}
+	return events
+}
+
+// finishBuildEvents reads the build events of the command that just finished
+// and removes their file.
+func (i *IBazel) finishBuildEvents(events *bep.Stream) {
+	i.buildEvents = nil
+	if events == nil {
+		return
+	}
+	defer events.Close()
+
+	result, err := events.Result()
+	if err != nil {
+		log.Errorf("Error reading the build events: %v", err)
+		return
+	}
+	log.Debugf("Build events of %d targets, exit code %s", len(result.Targets), result.ExitCode)
+	i.buildEvents = result
+}
+
+// publishBuildEvents tells the BuildEventListeners what the build events of
+// the last command said.
+func (i *IBazel) publishBuildEvents(targets []string, command string) {
+	result := i.buildEvents
+	i.buildEvents = nil
+	if result == nil {
+		return
+	}
+
+	for _, l := range i.lifecycleListeners {
+		if bl, ok := l.(BuildEventListener); ok {
+			i.callListener(l, "BuildEventsReceived", func() { bl.BuildEventsReceived(targets, command, result) })
+		}
+	}
+}
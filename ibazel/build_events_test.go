@@ -0,0 +1,93 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+)
+
+// eventBazel is a Bazel that writes build events like the real one does.
+type eventBazel struct {
+	*mock_bazel.MockBazel
+	t      *testing.T
+	events string
+}
+
+func (b *eventBazel) Build(args ...string) (*bytes.Buffer, error) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--build_event_json_file=") {
+			if err := ioutil.WriteFile(strings.TrimPrefix(arg, "--build_event_json_file="), []byte(b.events), 0644); err != nil {
+				b.t.Fatal(err)
+			}
+		}
+	}
+	return b.MockBazel.Build(args...)
+}
+
+// buildEventRecorder is a listener that also records the build events.
+type buildEventRecorder struct {
+	phaseRecorder
+}
+
+func (r *buildEventRecorder) BuildEventsReceived(targets []string, command string, result *bep.Result) {
+	for _, target := range result.Targets {
+		*r.phases = append(*r.phases, fmt.Sprintf("events %s %s: success %v, outputs %v", command, target.Label, target.Success, target.Outputs))
+	}
+}
+
+func TestIBazelBuildEvents(t *testing.T) {
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		return &eventBazel{
+			MockBazel: &mock_bazel.MockBazel{},
+			t:         t,
+			events: `{"id":{"targetCompleted":{"label":"//app:server"}},"completed":{"success":true,"importantOutput":[{"name":"app/server","uri":"file:///out/app/server"}]}}
+{"id":{"buildFinished":{}},"finished":{"exitCode":{"name":"SUCCESS"}}}
+`,
+		}
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&buildEventRecorder{phaseRecorder{&phases}}}
+
+	targets := []string{"//app:server"}
+	i.build(targets...)
+	i.afterCommand(targets, "build", true, nil)
+	assertEqual(t, []string{"after build //app:server"}, phases, "Phases without --build_events")
+
+	defer setFlag(t, "build_events", "true")()
+	phases = nil
+	i.build(targets...)
+	i.afterCommand(targets, "build", true, nil)
+	// The events are only reported once.
+	i.afterCommand(targets, "build", true, nil)
+	assertEqual(t, []string{
+		"events build //app:server: success true, outputs [/out/app/server]",
+		"after build //app:server",
+		"after build //app:server",
+	}, phases, "Phases")
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache_stats reports how many of the actions of every iteration were
+// served from a cache, from the process summary Bazel prints at the end of a
+// command.
+package cache_stats
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var cacheStats = flag.Bool("cache_stats", true, "Report how many actions were cached in every iteration")
+
+// Matches e.g. "INFO: 12 processes: 3 remote cache hit, 4 internal, 5 linux-sandbox."
+var processesRegex = regexp.MustCompile(`^INFO: (\d+) process(?:es)?(?:: (.*?))?\.?$`)
+
+// Matches one "<count> <strategy>" part of the process summary.
+var strategyRegex = regexp.MustCompile(`^(\d+) (.+)$`)
+
+// Stats are the processes of a single command, by how they were handled.
+type Stats struct {
+	RemoteCacheHits int
+	DiskCacheHits   int
+	// Actions like symlinks and file writes that Bazel handles itself.
+	Internal int
+	// Everything that was actually executed, locally or remotely.
+	Executed int
+}
+
+// Cached returns the number of processes served from a cache.
+func (s Stats) Cached() int {
+	return s.RemoteCacheHits + s.DiskCacheHits
+}
+
+// HitRate returns the share of cacheable processes that were cache hits.
+func (s Stats) HitRate() float64 {
+	if s.Cached()+s.Executed == 0 {
+		return 0
+	}
+	return float64(s.Cached()) / float64(s.Cached()+s.Executed)
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d/%d actions cached (%.0f%%): %d remote cache hits, %d disk cache hits, %d executed",
+		s.Cached(), s.Cached()+s.Executed, 100*s.HitRate(), s.RemoteCacheHits, s.DiskCacheHits, s.Executed)
+}
+
+// Parse extracts the process summary from the output of a Bazel command.
+func Parse(output *bytes.Buffer) (Stats, bool) {
+	var stats Stats
+	found := false
+
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		line := strings.TrimSpace(log.StripColor(scanner.Text()))
+		m := processesRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		stats = Stats{}
+		found = true
+		for _, part := range strings.Split(m[2], ",") {
+			pm := strategyRegex.FindStringSubmatch(strings.TrimSpace(part))
+			if pm == nil {
+				continue
+			}
+			n, _ := strconv.Atoi(pm[1])
+			switch pm[2] {
+			case "remote cache hit":
+				stats.RemoteCacheHits += n
+			case "disk cache hit":
+				stats.DiskCacheHits += n
+			case "internal":
+				stats.Internal += n
+			default:
+				stats.Executed += n
+			}
+		}
+	}
+	return stats, found
+}
+
+type CacheStats struct {
+	// Whether any earlier iteration had remote cache hits.
+	sawRemoteHits bool
+}
+
+func New() *CacheStats {
+	return &CacheStats{}
+}
+
+func (c *CacheStats) Initialize(info *map[string]string) {}
+
+func (c *CacheStats) TargetDecider(rule *blaze_query.Rule) {}
+
+func (c *CacheStats) ChangeDetected(targets []string, changeType string, change string) {}
+
+func (c *CacheStats) BeforeCommand(targets []string, command string) {}
+
+func (c *CacheStats) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	if !*cacheStats || output == nil {
+		return
+	}
+
+	stats, ok := Parse(output)
+	if !ok || stats.Cached()+stats.Executed == 0 {
+		return
+	}
+	log.Logf("Cache: %s", stats)
+
+	if stats.RemoteCacheHits > 0 {
+		c.sawRemoteHits = true
+	} else if c.sawRemoteHits && stats.Executed > 0 {
+		log.Errorf("No remote cache hits this time although earlier iterations had some. A flag that changes the cache key (e.g. --config, --action_env or --define) may be defeating the remote cache.")
+	}
+}
+
+func (c *CacheStats) Cleanup() {}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache_stats
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func init() {
+	log.FakeExit()
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		output string
+		stats  Stats
+		found  bool
+	}{
+		{
+			output: "INFO: Build completed successfully, 12 total actions\n",
+		},
+		{
+			output: "INFO: Elapsed time: 1.2s\n\x1b[32mINFO: \x1b[0m12 processes: 3 remote cache hit, 4 internal, 5 linux-sandbox.\n",
+			stats:  Stats{RemoteCacheHits: 3, Internal: 4, Executed: 5},
+			found:  true,
+		},
+		{
+			output: "INFO: 12 processes: 3 remote cache hit, 2 disk cache hit, 4 internal, 2 linux-sandbox, 1 worker.\n",
+			stats:  Stats{RemoteCacheHits: 3, DiskCacheHits: 2, Internal: 4, Executed: 3},
+			found:  true,
+		},
+		{
+			output: "INFO: 1 process: 1 internal.\n",
+			stats:  Stats{Internal: 1},
+			found:  true,
+		},
+		{
+			output: "INFO: 0 processes.\n",
+			found:  true,
+		},
+	} {
+		stats, found := Parse(bytes.NewBufferString(c.output))
+		if stats != c.stats || found != c.found {
+			t.Errorf("Parse(%q) = %+v, %v, want %+v, %v", c.output, stats, found, c.stats, c.found)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := Stats{RemoteCacheHits: 3, DiskCacheHits: 1, Internal: 10, Executed: 4}
+	if s.HitRate() != 0.5 {
+		t.Errorf("HitRate() = %v, want 0.5", s.HitRate())
+	}
+	expected := "4/8 actions cached (50%): 3 remote cache hits, 1 disk cache hits, 4 executed"
+	if s.String() != expected {
+		t.Errorf("String() = %q, want %q", s.String(), expected)
+	}
+	if (Stats{Internal: 2}).HitRate() != 0 {
+		t.Errorf("HitRate() of internal actions only should be 0")
+	}
+}
+
+func TestAfterCommand_remoteCacheDefeated(t *testing.T) {
+	var out bytes.Buffer
+	log.SetWriter(&out)
+	defer log.SetWriter(os.Stderr)
+
+	c := New()
+	c.AfterCommand(nil, "build", true, bytes.NewBufferString("INFO: 5 processes: 5 remote cache hit.\n"))
+	if !c.sawRemoteHits {
+		t.Errorf("Remote cache hits weren't recorded")
+	}
+	c.AfterCommand(nil, "build", true, bytes.NewBufferString("INFO: 5 processes: 5 linux-sandbox.\n"))
+	if !bytes.Contains(out.Bytes(), []byte("No remote cache hits")) {
+		t.Errorf("Expected a warning about the remote cache, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package change describes the file changes that trigger an iteration in
+// more detail than Lifecycle.ChangeDetected does.
+package change
+
+// Type is what kind of file changed.
+type Type string
+
+const (
+	// A source file used by the targets.
+	Source Type = "source"
+	// A BUILD or .bzl file, which means the build graph has to be requeried.
+	Graph Type = "graph"
+)
+
+// Op is what happened to the file.
+type Op string
+
+const (
+	Create Op = "create"
+	Write  Op = "write"
+	Remove Op = "remove"
+	Rename Op = "rename"
+	Chmod  Op = "chmod"
+)
+
+// Event is a single file change.
+type Event struct {
+	Type Type
+	Op   Op
+	// Absolute path of the file that changed.
+	Path string
+	// The targets iBazel is building, testing or running that depend on the
+	// file. All of them when iBazel doesn't know, e.g. because the file was
+	// only found by querying every target at once.
+	Targets []string
+	// Identifies the iteration that will handle the change. Events with the
+	// same Batch are handled by a single command.
+	Batch int
+	// Position of the event within its batch, starting at 0.
+	Index int
+}
+
+// Listener is implemented by lifecycle listeners that want Events. They are
+// given those instead of calls to ChangeDetected.
+type Listener interface {
+	ChangeEventDetected(event Event)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var changedPaths = flag.String("changed_paths", "workspace", "How the log shows the files that changed: workspace (relative to the workspace root), package (as a label of their package) or absolute")
+
+// displayPath returns path the way --changed_paths shows it in the log. Files
+// outside of the workspace keep their absolute path.
+func (i *IBazel) displayPath(path string) string {
+	if *changedPaths == "absolute" {
+		return path
+	}
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(workspacePath, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	rel = filepath.ToSlash(rel)
+	if *changedPaths == "package" {
+		if pkg := i.fileIndex.Package(path); strings.HasPrefix(pkg, "//") {
+			dir := strings.TrimPrefix(pkg, "//")
+			if dir == "" {
+				return "//:" + rel
+			}
+			if strings.HasPrefix(rel, dir+"/") {
+				return pkg + ":" + strings.TrimPrefix(rel, dir+"/")
+			}
+		}
+	}
+	return rel
+}
+
+// describeChange returns what the file index knows about a changed file, to
+// be added to the log line about the change.
+func (i *IBazel) describeChange(path string) string {
+	if d := i.fileIndex.Describe(path); d != "" {
+		return " (" + d + ")"
+	}
+	return ""
+}
+
+// saveFileIndex persists the file index, see --persist_file_index.
+func (i *IBazel) saveFileIndex() {
+	if i.readOnly.cache {
+		return
+	}
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return
+	}
+	if err := i.fileIndex.Persist(workspacePath); err != nil {
+		log.Errorf("Error saving the file index: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+func TestIBazelDescribeChange(t *testing.T) {
+	workspace := filepath.FromSlash("/ws")
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryResponse(fmt.Sprintf(sourceQuery, "//app:server //app:test"), sourceFileResult("//lib:lib.go", "//app:main.go", "//app:main_test.go"))
+		b.AddQueryResponse(fmt.Sprintf(sourceQuery, "//app:server"), sourceFileResult("//lib:lib.go", "//app:main.go"))
+		b.AddQueryResponse(fmt.Sprintf(sourceQuery, "//app:test"), sourceFileResult("//lib:lib.go", "//app:main_test.go"))
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	i.outputDirs = []string{}
+	i.sourceFileWatcher = &fakeFSNotifyWatcher{}
+
+	targets := []string{"//app:server", "//app:test"}
+	if err := i.watchFiles(fmt.Sprintf(sourceQuery, "//app:server //app:test"), targets, i.sourceFileWatcher); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, " (in //lib, affects //app:server and //app:test)", i.describeChange(filepath.Join(workspace, "lib", "lib.go")), "Description of a watched file")
+	assertEqual(t, " (in //app, affects //app:server)", i.describeChange(filepath.Join(workspace, "app", "main.go")), "Description of a file of one target")
+	assertEqual(t, "", i.describeChange(filepath.Join(workspace, "other.go")), "Description of an unknown file")
+}
+
+func TestIBazelDisplayPath(t *testing.T) {
+	workspace := filepath.FromSlash("/ws")
+	defer setFlag(t, "changed_paths", "workspace")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	i.fileIndex.SetPackage(filepath.Join(workspace, "lib", "sub", "lib.go"), "//lib")
+	i.fileIndex.SetPackage(filepath.Join(workspace, "top.go"), "//")
+
+	lib := filepath.Join(workspace, "lib", "sub", "lib.go")
+	outside := filepath.FromSlash("/elsewhere/x.go")
+	assertEqual(t, "lib/sub/lib.go", i.displayPath(lib), "Workspace relative path")
+	assertEqual(t, outside, i.displayPath(outside), "Path outside of the workspace")
+
+	setFlag(t, "changed_paths", "package")
+	assertEqual(t, "//lib:sub/lib.go", i.displayPath(lib), "Package relative path")
+	assertEqual(t, "//:top.go", i.displayPath(filepath.Join(workspace, "top.go")), "Path in the root package")
+	assertEqual(t, "other/x.go", i.displayPath(filepath.Join(workspace, "other", "x.go")), "Path of an unknown package")
+
+	setFlag(t, "changed_paths", "absolute")
+	assertEqual(t, lib, i.displayPath(lib), "Absolute path")
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var checkAs = flag.String("check_as", "mrun", "What `ibazel check` checks the targets for: build, test, run or mrun")
+
+// notRunnable are the rule classes, besides the *_library ones, that are known
+// not to be runnable. Anything else may be, since rules only say whether they
+// are executable once analyzed.
+var notRunnable = []string{"config_setting", "filegroup", "genrule", "test_suite"}
+
+// check implements `ibazel check <targets>...` with the arguments args. It
+// only queries, so unlike New it sets up no file watchers, lifecycle
+// listeners or servers.
+func check(args []string) error {
+	targets, startupArgs, bazelArgs, _, _ := parseArgs(args)
+	i := &IBazel{workspaceFinder: mainWorkspaceFinder}
+	i.SetStartupArgs(startupArgs)
+	i.SetBazelArgs(bazelArgs)
+	if info, err := i.getInfo(); err == nil {
+		i.packagePath = (*info)["package_path"]
+	}
+	return i.Check(targets)
+}
+
+// Check validates the targets for --check_as and prints how many files would
+// be watched for each of them, so that wrapper scripts can fail fast before a
+// long session.
+func (i *IBazel) Check(targets []string) error {
+	return i.check(os.Stdout, *checkAs, targets)
+}
+
+func (i *IBazel) check(w io.Writer, verb string, targets []string) error {
+	switch verb {
+	case "build", "test", "mrun":
+	case "run":
+		if len(targets) != 1 {
+			return fmt.Errorf("run takes exactly one target, got %d", len(targets))
+		}
+	default:
+		return fmt.Errorf("can't check targets for %q, only for build, test, run or mrun", verb)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("check needs at least one target")
+	}
+	i.setTargetRepos(targets)
+
+	problems := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, target := range targets {
+		description, err := i.checkTarget(verb, target)
+		if err != nil {
+			problems++
+			fmt.Fprintf(tw, "FAIL\t%s\t%v\n", target, err)
+			continue
+		}
+		fmt.Fprintf(tw, "OK\t%s\t%s\n", target, description)
+	}
+	tw.Flush()
+
+	if problems > 0 {
+		return fmt.Errorf("%d of %d targets can't be used with %s", problems, len(targets), verb)
+	}
+	return nil
+}
+
+// checkTarget returns what target is and the size of its watch set, or why it
+// can't be used with verb.
+func (i *IBazel) checkTarget(verb string, target string) (string, error) {
+	res, err := i.newBazel("query").Query(target)
+	if err != nil {
+		return "", fmt.Errorf("doesn't exist: %v", err)
+	}
+	var rules []*blaze_query.Rule
+	for _, t := range res.Target {
+		if *t.Type == blaze_query.Target_RULE {
+			rules = append(rules, t.Rule)
+		}
+	}
+	if len(rules) == 0 {
+		return "", fmt.Errorf("matches no rules")
+	}
+
+	var kind string
+	switch verb {
+	case "build":
+		kind = ruleKind(rules)
+	case "test":
+		var tests []*blaze_query.Rule
+		for _, r := range rules {
+			if isTestRule(r) {
+				tests = append(tests, r)
+			}
+		}
+		if len(tests) == 0 {
+			return "", fmt.Errorf("has no tests")
+		}
+		kind = ruleKind(tests)
+	case "run", "mrun":
+		if len(rules) > 1 {
+			return "", fmt.Errorf("matches %d rules, %s needs a single target", len(rules), verb)
+		}
+		class := rules[0].GetRuleClass()
+		if strings.HasSuffix(class, "_library") || contains(notRunnable, class) {
+			return "", fmt.Errorf("%s isn't runnable", class)
+		}
+		kind = class
+	}
+
+	sources, err := i.queryFileSet(fmt.Sprintf(sourceQuery, target))
+	if err != nil {
+		return "", fmt.Errorf("can't query its source files: %v", err)
+	}
+	buildFiles, err := i.queryFileSet(fmt.Sprintf(buildQuery, target))
+	if err != nil {
+		return "", fmt.Errorf("can't query its BUILD files: %v", err)
+	}
+	return fmt.Sprintf("%s, watches %d source files and %d BUILD files", kind, len(sources), len(buildFiles)), nil
+}
+
+// ruleKind describes rules by their class, or their number when a pattern
+// matched more than one.
+func ruleKind(rules []*blaze_query.Rule) string {
+	if len(rules) == 1 {
+		return rules[0].GetRuleClass()
+	}
+	return fmt.Sprintf("%d rules", len(rules))
+}
+
+// isTestRule returns whether `bazel test` runs anything for r.
+func isTestRule(r *blaze_query.Rule) bool {
+	class := r.GetRuleClass()
+	return strings.HasSuffix(class, "_test") || class == "test_suite"
+}
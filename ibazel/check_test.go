@@ -0,0 +1,178 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+func ruleResult(classes ...string) *blaze_query.QueryResult {
+	res := &blaze_query.QueryResult{}
+	for n, class := range classes {
+		res.Target = append(res.Target, &blaze_query.Target{
+			Type: blaze_query.Target_RULE.Enum(),
+			Rule: &blaze_query.Rule{
+				Name:      proto.String(fmt.Sprintf("//app:rule%d", n)),
+				RuleClass: proto.String(class),
+			},
+		})
+	}
+	return res
+}
+
+func TestIBazelCheck(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryResponse("//app:server", ruleResult("go_binary"))
+		b.AddQueryResponse(fmt.Sprintf(sourceQuery, "//app:server"), sourceFileResult("//app:main.go", "//app:util.go"))
+		b.AddQueryResponse(fmt.Sprintf(buildQuery, "//app:server"), sourceFileResult("//app:BUILD"))
+		b.AddQueryResponse("//app:lib", ruleResult("go_library"))
+		b.AddQueryResponse("//app/...", ruleResult("go_binary", "go_library", "go_test"))
+		b.AddQueryResponse("//app:data", &blaze_query.QueryResult{})
+		b.AddQueryError("//app:gone", errors.New("no such target"))
+		return b
+	}
+
+	i := &IBazel{
+		workspaceFinder: dirWorkspaceFinder(workspace),
+		outputDirs:      []string{},
+	}
+
+	for _, c := range []struct {
+		verb     string
+		targets  []string
+		expected []string
+		err      string
+	}{
+		{
+			verb:     "mrun",
+			targets:  []string{"//app:server"},
+			expected: []string{"OK //app:server go_binary, watches 2 source files and 1 BUILD files\n"},
+		},
+		{
+			verb:    "mrun",
+			targets: []string{"//app:server", "//app:lib", "//app:gone", "//app/..."},
+			expected: []string{
+				"OK //app:server go_binary, watches 2 source files and 1 BUILD files\n",
+				"FAIL //app:lib go_library isn't runnable\n",
+				"FAIL //app:gone doesn't exist: no such target\n",
+				"FAIL //app/... matches 3 rules, mrun needs a single target\n",
+			},
+			err: "3 of 4 targets can't be used with mrun",
+		},
+		{
+			verb:     "test",
+			targets:  []string{"//app/...", "//app:server"},
+			expected: []string{"OK //app/... go_test, watches 0 source files and 0 BUILD files\n", "FAIL //app:server has no tests\n"},
+			err:      "1 of 2 targets can't be used with test",
+		},
+		{
+			verb:     "build",
+			targets:  []string{"//app/...", "//app:data"},
+			expected: []string{"OK //app/... 3 rules, watches 0 source files and 0 BUILD files\n", "FAIL //app:data matches no rules\n"},
+			err:      "1 of 2 targets can't be used with build",
+		},
+		{
+			verb:    "run",
+			targets: []string{"//app:server", "//app:lib"},
+			err:     "run takes exactly one target, got 2",
+		},
+		{
+			verb:    "coverage",
+			targets: []string{"//app:server"},
+			err:     `can't check targets for "coverage", only for build, test, run or mrun`,
+		},
+	} {
+		out := &bytes.Buffer{}
+		err := i.check(out, c.verb, c.targets)
+		if c.err == "" && err != nil {
+			t.Errorf("check %s %v: %v", c.verb, c.targets, err)
+		} else if c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Errorf("check %s %v: got error %v, want %q", c.verb, c.targets, err, c.err)
+		}
+		// Ignore how the columns are aligned.
+		var lines []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			lines = append(lines, strings.Join(strings.Fields(line), " "))
+		}
+		got := strings.Join(lines, "\n")
+		for _, expected := range c.expected {
+			if !strings.Contains(got, expected) {
+				t.Errorf("check %s %v: expected %q in:\n%s", c.verb, c.targets, expected, out.String())
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	oldWorkspaceFinder := mainWorkspaceFinder
+	defer func() { mainWorkspaceFinder = oldWorkspaceFinder }()
+	mainWorkspaceFinder = dirWorkspaceFinder(workspace)
+
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryResponse("//app:server", ruleResult("go_binary"))
+		return b
+	}
+
+	out, err := ioutil.TempFile("", "ibazel_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	oldStdout := os.Stdout
+	defer func() { os.Stdout = oldStdout }()
+	os.Stdout = out
+
+	// Only queries, without the file watchers, listeners and servers of New.
+	if err := check([]string{"//app:server"}); err != nil {
+		t.Fatal(err)
+	}
+	printed, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(printed), "OK  //app:server") {
+		t.Errorf("Unexpected output of the check: %q", printed)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ci_annotations wraps the output of every iteration in GitHub
+// Actions workflow commands so that iBazel's log is readable when it runs as a
+// long-lived CI job: every command becomes a collapsible group and errors are
+// annotated on the files they point at.
+package ci_annotations
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var ciAnnotations = flag.String("ci_annotations", "auto", "Emit GitHub Actions group markers and error annotations: auto (when $GITHUB_ACTIONS is set), github or off")
+
+var getenv = os.Getenv
+
+// Written to at the time of the call, so that redirections of os.Stdout are
+// honored.
+var stdout = func() io.Writer { return os.Stdout }
+
+// Matches "ERROR: /ws/foo/BUILD:1:3: message" and compiler diagnostics such
+// as "foo/bar.go:10:5: message".
+var locatedRegex = regexp.MustCompile(`^(?:(ERROR|WARNING): )?(\S+?):(\d+):(\d+): (.*)$`)
+
+// Matches Bazel errors and warnings that don't point at a file.
+var unlocatedRegex = regexp.MustCompile(`^(ERROR|WARNING): (.*)$`)
+
+func enabled() bool {
+	switch *ciAnnotations {
+	case "github":
+		return true
+	case "auto":
+		return getenv("GITHUB_ACTIONS") == "true"
+	default:
+		return false
+	}
+}
+
+type CIAnnotations struct {
+	workspace string
+}
+
+func New() *CIAnnotations {
+	return &CIAnnotations{}
+}
+
+func (c *CIAnnotations) Initialize(info *map[string]string) {
+	if info != nil {
+		c.workspace = (*info)["workspace"]
+	}
+}
+
+func (c *CIAnnotations) TargetDecider(rule *blaze_query.Rule) {}
+
+func (c *CIAnnotations) ChangeDetected(targets []string, changeType string, change string) {}
+
+func (c *CIAnnotations) BeforeCommand(targets []string, command string) {
+	if !enabled() {
+		return
+	}
+	fmt.Fprintf(stdout(), "::group::%s %s\n", command, escapeData(strings.Join(targets, " ")))
+}
+
+func (c *CIAnnotations) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	if !enabled() {
+		return
+	}
+	fmt.Fprintln(stdout(), "::endgroup::")
+
+	if output == nil {
+		return
+	}
+	for _, annotation := range c.annotations(output) {
+		fmt.Fprintln(stdout(), annotation)
+	}
+}
+
+// QueryFailed implements the QueryErrorListener interface of iBazel, so that
+// errors in BUILD files are annotated too.
+func (c *CIAnnotations) QueryFailed(targets []string, output *bytes.Buffer) {
+	if !enabled() {
+		return
+	}
+	for _, annotation := range c.annotations(output) {
+		fmt.Fprintln(stdout(), annotation)
+	}
+}
+
+func (c *CIAnnotations) Cleanup() {}
+
+// annotations converts the errors and warnings in output to workflow commands.
+func (c *CIAnnotations) annotations(output *bytes.Buffer) []string {
+	var annotations []string
+	seen := map[string]bool{}
+
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		line := log.StripColor(scanner.Text())
+
+		var annotation string
+		if m := locatedRegex.FindStringSubmatch(line); m != nil {
+			level := "error"
+			if m[1] == "WARNING" || (m[1] == "" && strings.HasPrefix(m[5], "warning")) {
+				level = "warning"
+			}
+			annotation = fmt.Sprintf("::%s file=%s,line=%s,col=%s::%s",
+				level, escapeProperty(c.relative(m[2])), m[3], m[4], escapeData(m[5]))
+		} else if m := unlocatedRegex.FindStringSubmatch(line); m != nil {
+			annotation = fmt.Sprintf("::%s::%s", strings.ToLower(m[1]), escapeData(m[2]))
+		} else {
+			continue
+		}
+
+		if !seen[annotation] {
+			seen[annotation] = true
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations
+}
+
+// relative makes paths inside the workspace relative to it, which is what
+// annotations expect.
+func (c *CIAnnotations) relative(path string) string {
+	if c.workspace == "" || !filepath.IsAbs(path) {
+		return path
+	}
+	if rel, err := filepath.Rel(c.workspace, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+func escapeData(s string) string {
+	s = strings.Replace(s, "%", "%25", -1)
+	s = strings.Replace(s, "\r", "%0D", -1)
+	return strings.Replace(s, "\n", "%0A", -1)
+}
+
+func escapeProperty(s string) string {
+	s = escapeData(s)
+	s = strings.Replace(s, ":", "%3A", -1)
+	return strings.Replace(s, ",", "%2C", -1)
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ci_annotations
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCIAnnotations(t *testing.T) {
+	out := &bytes.Buffer{}
+	oldStdout, oldGetenv := stdout, getenv
+	defer func() { stdout, getenv = oldStdout, oldGetenv }()
+	stdout = func() io.Writer { return out }
+	getenv = func(key string) string {
+		if key == "GITHUB_ACTIONS" {
+			return "true"
+		}
+		return ""
+	}
+
+	c := New()
+	c.Initialize(&map[string]string{"workspace": "/ws"})
+	c.BeforeCommand([]string{"//foo:bar", "//foo:baz"}, "build")
+	c.AfterCommand([]string{"//foo:bar", "//foo:baz"}, "build", false, bytes.NewBufferString(
+		"INFO: Analyzed 2 targets\n"+
+			"\x1b[31m\x1b[1mERROR: \x1b[0m/ws/foo/BUILD:3:10: Compiling foo/a.cc failed: (Exit 1)\n"+
+			"foo/a.cc:12:3: error: use of undeclared identifier 'x'\n"+
+			"foo/a.cc:12:3: error: use of undeclared identifier 'x'\n"+
+			"foo/b.cc:1:1: warning: unused variable, really: 100%\n"+
+			"ERROR: Build did NOT complete successfully\n"))
+
+	expected := "::group::build //foo:bar //foo:baz\n" +
+		"::endgroup::\n" +
+		"::error file=foo/BUILD,line=3,col=10::Compiling foo/a.cc failed: (Exit 1)\n" +
+		"::error file=foo/a.cc,line=12,col=3::error: use of undeclared identifier 'x'\n" +
+		"::warning file=foo/b.cc,line=1,col=1::warning: unused variable, really: 100%25\n" +
+		"::error::Build did NOT complete successfully\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected output.\nGot:\n%s\nWant:\n%s", out.String(), expected)
+	}
+}
+
+func TestCIAnnotations_disabled(t *testing.T) {
+	out := &bytes.Buffer{}
+	oldStdout, oldGetenv := stdout, getenv
+	defer func() { stdout, getenv = oldStdout, oldGetenv }()
+	stdout = func() io.Writer { return out }
+	getenv = func(string) string { return "" }
+
+	c := New()
+	c.BeforeCommand([]string{"//foo:bar"}, "build")
+	c.AfterCommand([]string{"//foo:bar"}, "build", false, bytes.NewBufferString("ERROR: oops\n"))
+	if out.Len() != 0 {
+		t.Errorf("Wrote %q outside of GitHub Actions", out.String())
+	}
+}
+
+func TestCIAnnotations_queryFailed(t *testing.T) {
+	out := &bytes.Buffer{}
+	oldStdout, oldGetenv := stdout, getenv
+	defer func() { stdout, getenv = oldStdout, oldGetenv }()
+	stdout = func() io.Writer { return out }
+	getenv = func(key string) string {
+		if key == "GITHUB_ACTIONS" {
+			return "true"
+		}
+		return ""
+	}
+
+	c := New()
+	c.Initialize(&map[string]string{"workspace": "/ws"})
+	c.QueryFailed([]string{"//foo:bar"}, bytes.NewBufferString(
+		"ERROR: /ws/foo/BUILD:3:5: syntax error at 'load'\n"))
+
+	expected := "::error file=foo/BUILD,line=3,col=5::syntax error at 'load'\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected output.\nGot:\n%s\nWant:\n%s", out.String(), expected)
+	}
+}
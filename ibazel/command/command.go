@@ -17,7 +17,6 @@ package command
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"runtime"
@@ -25,6 +24,7 @@ import (
 
 	"github.com/bazelbuild/bazel-watcher/bazel"
 	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
 )
 
 var execCommand = process_group.Command
@@ -41,15 +41,15 @@ type Command interface {
 }
 
 // start will be called by most implementations since this logic is extremely
-// common.
-func start(b bazel.Bazel, target string, args []string, logFile *os.File) (*bytes.Buffer, process_group.ProcessGroup) {
+// common. A nil runner runs the target on this machine.
+func start(b bazel.Bazel, target string, args []string, runner Runner, logFile *os.File) (*bytes.Buffer, process_group.ProcessGroup) {
 	var filePattern strings.Builder
 	filePattern.WriteString("bazel_script_path*")
 	if runtime.GOOS == "windows" {
 		filePattern.WriteString(".bat")
 	}
 
-	tmpfile, err := ioutil.TempFile("", filePattern.String())
+	tmpfile, err := temp_dir.TempFile(filePattern.String())
 	if err != nil {
 		fmt.Print(err)
 	}
@@ -65,7 +65,10 @@ func start(b bazel.Bazel, target string, args []string, logFile *os.File) (*byte
 
 	// Now that we have built the target, construct a executable form of it for
 	// execution in a go routine.
-	cmd := execCommand(runScriptPath, args...)
+	if runner == nil {
+		runner = localRunner{}
+	}
+	cmd := runner.Command(runScriptPath, args...)
 	if logFile != nil {
 		cmd.RootProcess().Stdout = logFile
 		cmd.RootProcess().Stderr = logFile
@@ -92,3 +95,23 @@ func subprocessRunning(cmd *exec.Cmd) bool {
 
 	return true
 }
+
+// Pid returns the process ID of the subprocess of cmd, which leads its
+// process group, or 0 if it isn't running.
+func Pid(cmd Command) int {
+	var pg process_group.ProcessGroup
+	switch c := cmd.(type) {
+	case *defaultCommand:
+		pg = c.pg
+	case *notifyCommand:
+		pg = c.pg
+	case *sdNotifyCommand:
+		pg = c.pg
+	case *shellCommand:
+		pg = c.pg
+	}
+	if pg == nil || !subprocessRunning(pg.RootProcess()) {
+		return 0
+	}
+	return pg.RootProcess().Process.Pid
+}
@@ -63,3 +63,19 @@ func TestSubprocessRunning(t *testing.T) {
 		t.Errorf("Subprocess finished with error: %v State: %v", err, cmd.ProcessState)
 	}
 }
+
+func TestPid(t *testing.T) {
+	c := &defaultCommand{}
+	if pid := Pid(c); pid != 0 {
+		t.Errorf("Pid() of a command that wasn't started = %d, want 0", pid)
+	}
+
+	c.pg = process_group.Command("sleep", "5")
+	if err := c.pg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Terminate()
+	if pid := Pid(c); pid != c.pg.RootProcess().Process.Pid {
+		t.Errorf("Pid() = %d, want %d", pid, c.pg.RootProcess().Process.Pid)
+	}
+}
@@ -27,6 +27,7 @@ type defaultCommand struct {
 	startupArgs []string
 	bazelArgs   []string
 	args        []string
+	runner      Runner
 	pg          process_group.ProcessGroup
 }
 
@@ -47,13 +48,10 @@ func (c *defaultCommand) Terminate() {
 		return
 	}
 
-	// Kill it with fire by sending SIGKILL to the process PID which should
-	// propagate down to any subprocesses in the PGID (Process Group ID). To
-	// send to the PGID, send the signal to the negative of the process PID.
-	// Normally I would do this by calling c.cmd.Process.Signal, but that
-	// only goes to the PID not the PGID.
-	c.pg.Kill()
-	c.pg.Wait()
+	// Signal the whole PGID (Process Group ID) rather than just the PID, so
+	// that the signals propagate down to any subprocesses: SIGTERM first when
+	// there is a grace period, then SIGKILL.
+	stop(c.pg)
 	c.pg.Close()
 	c.pg = nil
 }
@@ -67,7 +65,7 @@ func (c *defaultCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
 	b.WriteToStdout(true)
 
 	var outputBuffer *bytes.Buffer
-	outputBuffer, c.pg = start(b, c.target, c.args, logFile)
+	outputBuffer, c.pg = start(b, c.target, c.args, c.runner, logFile)
 
 	c.pg.RootProcess().Env = os.Environ()
 
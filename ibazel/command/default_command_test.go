@@ -78,7 +78,7 @@ func TestDefaultCommand_Start(t *testing.T) {
 
 	b := &mock_bazel.MockBazel{}
 
-	_, pg := start(b, "//path/to:target", []string{"moo"}, nil)
+	_, pg := start(b, "//path/to:target", []string{"moo"}, nil, nil)
 	pg.Start()
 
 	if pg.RootProcess().Stdout != os.Stdout {
@@ -0,0 +1,70 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// How long the target may take to answer a notification.
+var httpNotifyTimeout = 5 * time.Second
+
+// HTTPNotification is the JSON body of the notifications posted to the
+// targets of HTTPNotifyCommand.
+type HTTPNotification struct {
+	// "build_started" or "build_completed".
+	Event  string `json:"event"`
+	Target string `json:"target"`
+	// "success" or "failure", for "build_completed".
+	Result string `json:"result,omitempty"`
+}
+
+// HTTPNotifyCommand is like NotifyCommand, but the command is notified of
+// changes with an HTTP POST to url instead of on stdin, e.g. for notebooks and
+// REPL kernels that read their stdin for something else.
+func HTTPNotifyCommand(startupArgs []string, bazelArgs []string, target string, args []string, url string) Command {
+	return &notifyCommand{
+		target:      target,
+		startupArgs: startupArgs,
+		bazelArgs:   bazelArgs,
+		args:        args,
+		url:         url,
+	}
+}
+
+// post sends n to the command's URL. A command that doesn't answer only gets
+// an error logged, since it may still be starting.
+func (c *notifyCommand) post(n HTTPNotification) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Errorf("Error encoding the notification: %v", err)
+		return
+	}
+	client := http.Client{Timeout: httpNotifyTimeout}
+	res, err := client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Error notifying %s at %s: %v", c.target, c.url, err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		log.Errorf("Error notifying %s at %s: got %s", c.target, c.url, res.Status)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+func TestHTTPNotifyCommand(t *testing.T) {
+	var got []HTTPNotification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n HTTPNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("Error decoding the notification: %v", err)
+		}
+		got = append(got, n)
+	}))
+	defer server.Close()
+
+	b := &mock_bazel.MockBazel{}
+	bazelNew = func() bazel.Bazel { return b }
+	defer func() { bazelNew = oldBazelNew }()
+
+	c := HTTPNotifyCommand(nil, nil, "//path/to:kernel", nil, server.URL)
+	c.BeforeRebuild()
+	c.AfterRebuild(nil)
+	b.BuildError(errors.New("Demo error"))
+	c.BeforeRebuild()
+	c.AfterRebuild(nil)
+
+	want := []HTTPNotification{
+		{Event: "build_started", Target: "//path/to:kernel"},
+		{Event: "build_completed", Target: "//path/to:kernel", Result: "success"},
+		{Event: "build_started", Target: "//path/to:kernel"},
+		{Event: "build_completed", Target: "//path/to:kernel", Result: "failure"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Notifications:\nGot:  %v\nWant: %v", got, want)
+	}
+}
@@ -28,6 +28,10 @@ type notifyCommand struct {
 	startupArgs []string
 	bazelArgs   []string
 	args        []string
+	runner      Runner
+	// Where the command is notified with an HTTP POST, see HTTPNotifyCommand.
+	// It is notified on stdin when empty.
+	url string
 
 	pg    process_group.ProcessGroup
 	stdin io.WriteCloser
@@ -49,13 +53,10 @@ func (c *notifyCommand) Terminate() {
 		return
 	}
 
-	// Kill it with fire by sending SIGKILL to the process PID which should
-	// propagate down to any subprocesses in the PGID (Process Group ID). To
-	// send to the PGID, send the signal to the negative of the process PID.
-	// Normally I would do this by calling c.cmd.Process.Signal, but that
-	// only goes to the PID not the PGID.
-	c.pg.Kill()
-	c.pg.Wait()
+	// Signal the whole PGID (Process Group ID) rather than just the PID, so
+	// that the signals propagate down to any subprocesses: SIGTERM first when
+	// there is a grace period, then SIGKILL.
+	stop(c.pg)
 	c.pg.Close()
 	c.pg = nil
 }
@@ -69,17 +70,20 @@ func (c *notifyCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
 	b.WriteToStdout(true)
 
 	var outputBuffer *bytes.Buffer
-	outputBuffer, c.pg = start(b, c.target, c.args, logFile)
-	// Keep the writer around.
+	outputBuffer, c.pg = start(b, c.target, c.args, c.runner, logFile)
 	var err error
-	c.stdin, err = c.pg.RootProcess().StdinPipe()
-	if err != nil {
-		log.Errorf("Error getting stdin pipe: %v", err)
-		return outputBuffer, err
+	if c.url != "" {
+		c.pg.RootProcess().Env = append(os.Environ(), "IBAZEL_NOTIFY_URL="+c.url)
+	} else {
+		// Keep the writer around.
+		c.stdin, err = c.pg.RootProcess().StdinPipe()
+		if err != nil {
+			log.Errorf("Error getting stdin pipe: %v", err)
+			return outputBuffer, err
+		}
+		c.pg.RootProcess().Env = append(os.Environ(), "IBAZEL_NOTIFY_CHANGES=y")
 	}
 
-	c.pg.RootProcess().Env = append(os.Environ(), "IBAZEL_NOTIFY_CHANGES=y")
-
 	if err = c.pg.Start(); err != nil {
 		log.Errorf("Error starting process: %v", err)
 		return outputBuffer, err
@@ -89,6 +93,10 @@ func (c *notifyCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
 }
 
 func (c *notifyCommand) BeforeRebuild() {
+	if c.url != "" {
+		c.post(HTTPNotification{Event: "build_started", Target: c.target})
+		return
+	}
 	_, err := c.stdin.Write([]byte("IBAZEL_BUILD_STARTED\n"))
 	if err != nil {
 		log.Errorf("Error writing build to stdin: %s", err)
@@ -107,18 +115,34 @@ func (c *notifyCommand) AfterRebuild(logFile *os.File) *bytes.Buffer {
 	outputBuffer, res := b.Build(c.target)
 	if res != nil {
 		log.Errorf("IBAZEL BUILD FAILURE: %v", res)
-		_, err := c.stdin.Write([]byte("IBAZEL_BUILD_COMPLETED FAILURE\n"))
-		if err != nil {
-			log.Errorf("Error writing failure to stdin: %s", err)
-		}
 	} else {
 		log.Log("IBAZEL BUILD SUCCESS")
+	}
+	c.buildCompleted(res == nil)
+	return outputBuffer
+}
+
+// buildCompleted notifies the command of the result of the build.
+func (c *notifyCommand) buildCompleted(success bool) {
+	if c.url != "" {
+		result := "success"
+		if !success {
+			result = "failure"
+		}
+		c.post(HTTPNotification{Event: "build_completed", Target: c.target, Result: result})
+		return
+	}
+	if success {
 		_, err := c.stdin.Write([]byte("IBAZEL_BUILD_COMPLETED SUCCESS\n"))
 		if err != nil {
 			log.Errorf("Error writing success to stdin: %v", err)
 		}
+	} else {
+		_, err := c.stdin.Write([]byte("IBAZEL_BUILD_COMPLETED FAILURE\n"))
+		if err != nil {
+			log.Errorf("Error writing failure to stdin: %s", err)
+		}
 	}
-	return outputBuffer
 }
 
 func (c *notifyCommand) IsSubprocessRunning() bool {
@@ -0,0 +1,261 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+)
+
+// Runner launches the script that `bazel run --script_path` wrote for a
+// target, see ParseRunner.
+type Runner interface {
+	// Command returns the process group that runs script with args.
+	Command(script string, args ...string) process_group.ProcessGroup
+}
+
+// ParseRunner returns the runner described by spec:
+//
+//   - "local" runs the target on this machine, like `bazel run`.
+//   - "docker:<container>" runs it in a running container with `docker exec`.
+//   - "ssh:<host>" runs it on another machine with `ssh`.
+//   - "user:<user>[:<group>]" runs it as another user, and group, with
+//     `sudo`, which mustn't ask for a password.
+//
+// The remote runners run the script at the same path as it has here, so the
+// workspace and Bazel's output base have to be shared at the same paths, e.g.
+// through a bind mount or a network filesystem. Only the output and stdin of
+// the target are forwarded, not iBazel's environment, and sudo keeps only the
+// variables its configuration allows.
+func ParseRunner(spec string) (Runner, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	kind, where := parts[0], ""
+	if len(parts) == 2 {
+		where = parts[1]
+	}
+	switch {
+	case kind == "local" && where == "":
+		return localRunner{}, nil
+	case kind == "docker" && where != "":
+		return &wrappedRunner{prefix: []string{"docker", "exec", "-i", where}}, nil
+	case kind == "ssh" && where != "":
+		return &wrappedRunner{prefix: []string{"ssh", "-o", "BatchMode=yes", where, "--"}, shell: true}, nil
+	case kind == "user" && where != "":
+		prefix := []string{"sudo", "-n"}
+		if user, group := splitUser(where); group != "" {
+			prefix = append(prefix, "-u", user, "-g", group)
+		} else {
+			prefix = append(prefix, "-u", user)
+		}
+		return &wrappedRunner{prefix: append(prefix, "--")}, nil
+	}
+	return nil, fmt.Errorf("%q isn't one of local, docker:<container>, ssh:<host> or user:<user>[:<group>]", spec)
+}
+
+// SetRunner makes cmd launch its target with r instead of on this machine.
+// Commands that don't run a target, like the --command of `ibazel build`,
+// are left alone.
+func SetRunner(cmd Command, r Runner) {
+	switch c := cmd.(type) {
+	case *defaultCommand:
+		c.runner = r
+	case *notifyCommand:
+		c.runner = r
+	case *sdNotifyCommand:
+		c.runner = r
+	}
+}
+
+// RunUnder returns a runner that launches the script with r, or on this
+// machine if r is nil, behind the command line prefix, like Bazel's
+// --run_under, e.g. to run the target under strace, perf or valgrind.
+func RunUnder(r Runner, prefix []string) Runner {
+	if r == nil {
+		r = localRunner{}
+	}
+	return &underRunner{runner: r, prefix: prefix}
+}
+
+// SocketActivation returns a runner that launches the script with r, or on
+// this machine if r is nil, like systemd's socket activation: files are
+// passed from file descriptor 3 on, and env holds their LISTEN_FDS and
+// LISTEN_FDNAMES. A shell sets LISTEN_PID to its own PID and execs the script,
+// which has to exec the target in turn, as the scripts of `bazel run` do. The
+// shell has to run right before the script, so r may be RunUnder but not the
+// other way around, where LISTEN_PID would be the PID of the --run_under
+// command. Only local targets, see IsLocal, can be passed files.
+func SocketActivation(r Runner, files []*os.File, env []string) Runner {
+	if r == nil {
+		r = localRunner{}
+	}
+	return &activationRunner{runner: r, files: files, env: env}
+}
+
+// Env returns a runner that launches the script with r, or on this machine
+// if r is nil, with env added to its environment. A shell exports the
+// variables and execs the script, since the environment of the process group
+// is replaced when it starts.
+func Env(r Runner, env []string) Runner {
+	if r == nil {
+		r = localRunner{}
+	}
+	return &envRunner{runner: r, env: env}
+}
+
+// IsLocal returns whether r launches the script on this machine as the user
+// iBazel runs as, like a nil runner.
+func IsLocal(r Runner) bool {
+	switch r := r.(type) {
+	case nil, localRunner:
+		return true
+	case *envRunner:
+		return IsLocal(r.runner)
+	case *underRunner:
+		return IsLocal(r.runner)
+	case *activationRunner:
+		return IsLocal(r.runner)
+	}
+	return false
+}
+
+type localRunner struct{}
+
+func (localRunner) Command(script string, args ...string) process_group.ProcessGroup {
+	return execCommand(script, args...)
+}
+
+type underRunner struct {
+	runner Runner
+	prefix []string
+}
+
+func (r *underRunner) Command(script string, args ...string) process_group.ProcessGroup {
+	cmd := append(append(append([]string{}, r.prefix...), script), args...)
+	if w, ok := r.runner.(*wrappedRunner); ok {
+		// Stop the target by its script rather than by the prefix.
+		return w.run(script, cmd)
+	}
+	return r.runner.Command(cmd[0], cmd[1:]...)
+}
+
+type activationRunner struct {
+	runner Runner
+	files  []*os.File
+	env    []string
+}
+
+func (r *activationRunner) Command(script string, args ...string) process_group.ProcessGroup {
+	shell := fmt.Sprintf(`export %s LISTEN_PID=$$; exec "$0" "$@"`, exports(r.env))
+	pg := r.runner.Command("/bin/sh", append([]string{"-c", shell, script}, args...)...)
+	pg.RootProcess().ExtraFiles = r.files
+	return pg
+}
+
+type envRunner struct {
+	runner Runner
+	env    []string
+}
+
+func (r *envRunner) Command(script string, args ...string) process_group.ProcessGroup {
+	shell := fmt.Sprintf(`export %s; exec "$0" "$@"`, exports(r.env))
+	return r.runner.Command("/bin/sh", append([]string{"-c", shell, script}, args...)...)
+}
+
+// exports quotes the variables of env for the export builtin of a shell.
+func exports(env []string) string {
+	quoted := make([]string, len(env))
+	for n, v := range env {
+		quoted[n] = shellQuote(v)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// splitUser splits "<user>[:<group>]".
+func splitUser(s string) (user string, group string) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// wrappedRunner runs the script through a command that runs it elsewhere, or
+// as someone else.
+type wrappedRunner struct {
+	// The command line up to the script.
+	prefix []string
+	// Whether the script and its arguments end up in a shell command line, as
+	// with ssh, and have to be quoted.
+	shell bool
+}
+
+func (r *wrappedRunner) Command(script string, args ...string) process_group.ProcessGroup {
+	return r.run(script, append([]string{script}, args...))
+}
+
+// run returns the process group that runs cmd, which launches script.
+func (r *wrappedRunner) run(script string, cmd []string) process_group.ProcessGroup {
+	return &wrappedProcessGroup{
+		ProcessGroup: execCommand(r.prefix[0], r.command(cmd)...),
+		stop:         r.command([]string{"pkill", "-f", script}),
+		prefix:       r.prefix[0],
+	}
+}
+
+// command returns the arguments of the prefix command that run cmd.
+func (r *wrappedRunner) command(cmd []string) []string {
+	args := append([]string{}, r.prefix[1:]...)
+	for _, arg := range cmd {
+		if r.shell {
+			arg = shellQuote(arg)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// wrappedProcessGroup is the process group of a target run by a wrappedRunner.
+// Killing the local command doesn't reach the target, or isn't allowed to, so
+// it is also stopped by the path of its script, which is unique to every
+// start.
+type wrappedProcessGroup struct {
+	process_group.ProcessGroup
+	prefix string
+	stop   []string
+}
+
+func (pg *wrappedProcessGroup) Kill() error {
+	execCommand(pg.prefix, pg.stop...).CombinedOutput()
+	return pg.ProcessGroup.Kill()
+}
+
+func (pg *wrappedProcessGroup) Terminate() error {
+	execCommand(pg.prefix, pg.stop...).CombinedOutput()
+	return pg.ProcessGroup.Terminate()
+}
+
+var shellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
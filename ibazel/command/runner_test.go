@@ -0,0 +1,235 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+)
+
+func TestParseRunner(t *testing.T) {
+	for _, spec := range []string{"local", "docker:dev", "ssh:user@host", "user:www-data", "user:www-data:www"} {
+		if _, err := ParseRunner(spec); err != nil {
+			t.Errorf("ParseRunner(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "local:x", "docker", "docker:", "ssh:", "user:", "kubectl:pod"} {
+		if _, err := ParseRunner(spec); err == nil {
+			t.Errorf("ParseRunner(%q) should have failed", spec)
+		}
+	}
+}
+
+func TestWrappedRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Wrapped runners are tested with ls")
+	}
+	var commands []string
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return oldExecCommand("ls")
+	}
+	defer func() { execCommand = oldExecCommand }()
+
+	for _, c := range []struct {
+		spec string
+		want []string
+	}{
+		{"docker:dev", []string{
+			"docker exec -i dev /tmp/script it's",
+			"docker exec -i dev pkill -f /tmp/script",
+		}},
+		{"ssh:host", []string{
+			"ssh -o BatchMode=yes host -- /tmp/script 'it'\\''s'",
+			"ssh -o BatchMode=yes host -- pkill -f /tmp/script",
+		}},
+		{"user:www-data:www", []string{
+			"sudo -n -u www-data -g www -- /tmp/script it's",
+			"sudo -n -u www-data -g www -- pkill -f /tmp/script",
+		}},
+	} {
+		commands = nil
+		r, err := ParseRunner(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pg := r.Command("/tmp/script", "it's")
+		if err := pg.Start(); err != nil {
+			t.Fatal(err)
+		}
+		pg.Kill()
+		pg.Wait()
+		if !reflect.DeepEqual(c.want, commands) {
+			t.Errorf("%s ran %q, wanted %q", c.spec, commands, c.want)
+		}
+	}
+}
+
+func TestSetRunner(t *testing.T) {
+	var script string
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		script = name
+		return oldExecCommand("ls")
+	}
+	defer func() { execCommand = oldExecCommand }()
+
+	c := DefaultCommand(nil, nil, "//path/to:target", nil)
+	r, _ := ParseRunner("docker:dev")
+	SetRunner(c, r)
+
+	b := &mock_bazel.MockBazel{}
+	_, pg := start(b, "//path/to:target", nil, c.(*defaultCommand).runner, nil)
+	if script != "docker" {
+		t.Errorf("Wanted the target to be run with docker, got %q", script)
+	}
+	if _, ok := pg.(*wrappedProcessGroup); !ok {
+		t.Errorf("Wanted a remote process group, got %T", pg)
+	}
+}
+
+func TestRunUnder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Wrapped runners are tested with ls")
+	}
+	var commands []string
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return oldExecCommand("ls")
+	}
+	defer func() { execCommand = oldExecCommand }()
+
+	RunUnder(nil, []string{"strace", "-f"}).Command("/tmp/script", "arg")
+	docker, _ := ParseRunner("docker:dev")
+	pg := RunUnder(docker, []string{"perf", "record"}).Command("/tmp/script", "arg")
+	if err := pg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pg.Kill()
+	pg.Wait()
+
+	want := []string{
+		"strace -f /tmp/script arg",
+		"docker exec -i dev perf record /tmp/script arg",
+		// The target is still stopped by the path of its script.
+		"docker exec -i dev pkill -f /tmp/script",
+	}
+	if !reflect.DeepEqual(want, commands) {
+		t.Errorf("Ran %q, wanted %q", commands, want)
+	}
+}
+
+func TestSocketActivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Socket activation needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "socket_activation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "script")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\n[ -e /dev/fd/3 ] && echo \"$LISTEN_PID $$ $LISTEN_FDS $LISTEN_FDNAMES $1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	pg := SocketActivation(nil, []*os.File{file}, []string{"LISTEN_FDS=1", "LISTEN_FDNAMES=http"}).Command(script, "arg")
+	out, err := pg.RootProcess().Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 5 || fields[0] != fields[1] || fields[2] != "1" || fields[3] != "http" || fields[4] != "arg" {
+		t.Errorf("Expected the script to get fd 3 and its own PID in LISTEN_PID, got %q", out)
+	}
+}
+
+func TestSocketActivation_runUnder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Socket activation needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "socket_activation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "script")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\n[ -e /dev/fd/3 ] && echo \"$LISTEN_PID $$\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// A --run_under command that runs the target as its child, like strace.
+	under := RunUnder(nil, []string{"/bin/sh", "-c", `"$@"; exit $?`, "sh"})
+	pg := SocketActivation(under, []*os.File{file}, []string{"LISTEN_FDS=1"}).Command(script)
+	out, err := pg.RootProcess().Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 || fields[0] != fields[1] {
+		t.Errorf("Expected the script under the --run_under command to get fd 3 and its own PID in LISTEN_PID, got %q", out)
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	docker, _ := ParseRunner("docker:dev")
+	for _, c := range []struct {
+		name string
+		r    Runner
+		want bool
+	}{
+		{"nil", nil, true},
+		{"env and run_under", RunUnder(Env(nil, []string{"PORT=8080"}), []string{"strace"}), true},
+		{"docker", docker, false},
+		{"docker with env", Env(docker, []string{"PORT=8080"}), false},
+	} {
+		if got := IsLocal(c.r); got != c.want {
+			t.Errorf("IsLocal(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Env needs a POSIX shell")
+	}
+	pg := Env(nil, []string{"PORT=8081", "GREETING=hello world"}).Command("/bin/sh", "-c", `echo "$PORT $GREETING"`)
+	out, err := pg.RootProcess().Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "8081 hello world" {
+		t.Errorf("Expected the script to get the variables, got %q", got)
+	}
+}
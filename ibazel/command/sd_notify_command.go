@@ -0,0 +1,114 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+	"github.com/bazelbuild/bazel-watcher/ibazel/sd_notify"
+)
+
+var sdNotifyTimeout = flag.Duration("sd_notify_timeout", 30*time.Second, "How long to wait for a target tagged ibazel_sd_notify to report READY=1, 0 doesn't wait and only logs it once it arrives")
+
+type sdNotifyCommand struct {
+	target      string
+	startupArgs []string
+	bazelArgs   []string
+	args        []string
+	runner      Runner
+
+	pg     process_group.ProcessGroup
+	socket *sd_notify.Socket
+}
+
+// SdNotifyCommand is started with NOTIFY_SOCKET set and is not considered
+// started until it reports READY=1 over the socket.
+func SdNotifyCommand(startupArgs []string, bazelArgs []string, target string, args []string) Command {
+	return &sdNotifyCommand{
+		target:      target,
+		startupArgs: startupArgs,
+		bazelArgs:   bazelArgs,
+		args:        args,
+	}
+}
+
+func (c *sdNotifyCommand) Terminate() {
+	if c.socket != nil {
+		c.socket.Close()
+		c.socket = nil
+	}
+
+	if c.pg == nil || !subprocessRunning(c.pg.RootProcess()) {
+		return
+	}
+
+	stop(c.pg)
+	c.pg.Close()
+	c.pg = nil
+}
+
+func (c *sdNotifyCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
+	b := bazelNew()
+	b.SetStartupArgs(c.startupArgs)
+	b.SetArguments(c.bazelArgs)
+
+	b.WriteToStderr(true)
+	b.WriteToStdout(true)
+
+	var outputBuffer *bytes.Buffer
+	outputBuffer, c.pg = start(b, c.target, c.args, c.runner, logFile)
+
+	var err error
+	c.socket, err = sd_notify.Listen(c.target)
+	if err != nil {
+		log.Errorf("Error creating notify socket, readiness will not be tracked: %v", err)
+		c.pg.RootProcess().Env = os.Environ()
+	} else {
+		c.pg.RootProcess().Env = append(os.Environ(), c.socket.Env())
+	}
+
+	if err = c.pg.Start(); err != nil {
+		log.Errorf("Error starting process: %v", err)
+		return outputBuffer, err
+	}
+	log.Log("Starting...")
+
+	// Waiting holds up the main loop, which is what delays the live reload
+	// until the target is ready. Without a timeout readiness is only logged.
+	if c.socket != nil && *sdNotifyTimeout > 0 && !c.socket.WaitReady(*sdNotifyTimeout) {
+		log.Errorf("%s did not report READY=1 within %v", c.target, *sdNotifyTimeout)
+	}
+	return outputBuffer, nil
+}
+
+func (c *sdNotifyCommand) BeforeRebuild() {
+	if c.pg != nil {
+		c.Terminate()
+	}
+}
+
+func (c *sdNotifyCommand) AfterRebuild(logFile *os.File) *bytes.Buffer {
+	outputBuffer, _ := c.Start(logFile)
+	return outputBuffer
+}
+
+func (c *sdNotifyCommand) IsSubprocessRunning() bool {
+	return c.pg != nil && subprocessRunning(c.pg.RootProcess())
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+)
+
+// TestSdNotifyHelperProcess isn't a real test, it is the target started by
+// TestSdNotifyCommand. It sends READY=1 to $NOTIFY_SOCKET and then keeps
+// running until it is terminated.
+func TestSdNotifyHelperProcess(t *testing.T) {
+	if os.Getenv("IBAZEL_SD_NOTIFY_HELPER") != "1" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: os.Getenv("NOTIFY_SOCKET"), Net: "unixgram"})
+	if err != nil {
+		os.Exit(1)
+	}
+	conn.Write([]byte("STATUS=Listening\nREADY=1"))
+	conn.Close()
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func TestSdNotifyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on windows")
+	}
+
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		return oldExecCommand(os.Args[0], "-test.run=TestSdNotifyHelperProcess")
+	}
+	defer func() { execCommand = oldExecCommand }()
+	bazelNew = func() bazel.Bazel { return &mock_bazel.MockBazel{} }
+	defer func() { bazelNew = oldBazelNew }()
+
+	os.Setenv("IBAZEL_SD_NOTIFY_HELPER", "1")
+	defer os.Unsetenv("IBAZEL_SD_NOTIFY_HELPER")
+
+	oldTimeout := *sdNotifyTimeout
+	*sdNotifyTimeout = 10 * time.Second
+	defer func() { *sdNotifyTimeout = oldTimeout }()
+
+	c := SdNotifyCommand([]string{}, []string{}, "//path/to:target", []string{})
+	defer c.Terminate()
+
+	started := time.Now()
+	if _, err := c.Start(nil); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed >= *sdNotifyTimeout {
+		t.Errorf("Start returned after %v, READY=1 was never received", elapsed)
+	}
+	if !c.IsSubprocessRunning() {
+		t.Errorf("Target stopped after reporting READY=1")
+	}
+
+	c.Terminate()
+	if c.IsSubprocessRunning() {
+		t.Errorf("Target still running after Terminate")
+	}
+}
+
+func TestSdNotifyCommand_noTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on windows")
+	}
+
+	// Without IBAZEL_SD_NOTIFY_HELPER the helper never reports READY=1.
+	execCommand = func(name string, args ...string) process_group.ProcessGroup {
+		return oldExecCommand(os.Args[0], "-test.run=TestSdNotifyHelperProcess")
+	}
+	defer func() { execCommand = oldExecCommand }()
+	bazelNew = func() bazel.Bazel { return &mock_bazel.MockBazel{} }
+	defer func() { bazelNew = oldBazelNew }()
+
+	oldTimeout := *sdNotifyTimeout
+	*sdNotifyTimeout = 0
+	defer func() { *sdNotifyTimeout = oldTimeout }()
+
+	c := SdNotifyCommand([]string{}, []string{}, "//path/to:target", []string{})
+	defer c.Terminate()
+
+	done := make(chan struct{})
+	go func() {
+		c.Start(nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Start waited for READY=1 with --sd_notify_timeout=0")
+	}
+}
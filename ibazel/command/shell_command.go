@@ -0,0 +1,91 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+)
+
+type shellCommand struct {
+	command string
+	dir     string
+	env     []string
+	pg      process_group.ProcessGroup
+}
+
+// ShellCommand runs an arbitrary shell command, in dir and with env added to
+// iBazel's environment, instead of a Bazel target. It is restarted after every
+// successful build and left running when a build fails.
+func ShellCommand(command string, dir string, env []string) Command {
+	return &shellCommand{
+		command: command,
+		dir:     dir,
+		env:     env,
+	}
+}
+
+func (c *shellCommand) Terminate() {
+	if c.pg == nil {
+		return
+	}
+	if subprocessRunning(c.pg.RootProcess()) {
+		stop(c.pg)
+	}
+	c.pg.Close()
+	c.pg = nil
+}
+
+func (c *shellCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
+	if runtime.GOOS == "windows" {
+		c.pg = execCommand("cmd", "/C", c.command)
+	} else {
+		c.pg = execCommand("sh", "-c", c.command)
+	}
+
+	cmd := c.pg.RootProcess()
+	cmd.Dir = c.dir
+	cmd.Env = append(os.Environ(), c.env...)
+	if logFile != nil {
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+	} else {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := c.pg.Start(); err != nil {
+		log.Errorf("Error starting %q: %v", c.command, err)
+		return &bytes.Buffer{}, err
+	}
+	log.Log("Starting...")
+	return &bytes.Buffer{}, nil
+}
+
+func (c *shellCommand) BeforeRebuild() {}
+
+func (c *shellCommand) AfterRebuild(logFile *os.File) *bytes.Buffer {
+	c.Terminate()
+	outputBuffer, _ := c.Start(logFile)
+	return outputBuffer
+}
+
+func (c *shellCommand) IsSubprocessRunning() bool {
+	return c.pg != nil && subprocessRunning(c.pg.RootProcess())
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShellCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shell_command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := ShellCommand(`echo "$GREETING" >> out.txt; exec sleep 10`, dir, []string{"GREETING=hello"})
+	if c.IsSubprocessRunning() {
+		t.Errorf("Subprocess shouldn't have been started yet")
+	}
+
+	if _, err := c.Start(nil); err != nil {
+		t.Fatalf("Start(): %v", err)
+	}
+	first := c.(*shellCommand).pg.RootProcess()
+	if !c.IsSubprocessRunning() {
+		t.Errorf("Subprocess was never started")
+	}
+
+	// Failed builds leave the command running.
+	c.BeforeRebuild()
+	if !c.IsSubprocessRunning() {
+		t.Errorf("BeforeRebuild() stopped the subprocess")
+	}
+
+	waitForOutput(t, filepath.Join(dir, "out.txt"), "hello\n")
+	c.AfterRebuild(nil)
+	assertKilled(t, first)
+	if !c.IsSubprocessRunning() {
+		t.Errorf("Subprocess wasn't restarted")
+	}
+
+	waitForOutput(t, filepath.Join(dir, "out.txt"), "hello\nhello\n")
+
+	c.Terminate()
+	if c.IsSubprocessRunning() {
+		t.Errorf("Subprocess is still running after Terminate()")
+	}
+}
+
+// waitForOutput waits for the commands under test to write want to path.
+func waitForOutput(t *testing.T, path string, want string) {
+	t.Helper()
+
+	var out []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if out, _ = ioutil.ReadFile(path); string(out) == want {
+			return
+		}
+	}
+	t.Errorf("Expected %s to contain %q, got %q", path, want, out)
+}
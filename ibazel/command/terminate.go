@@ -0,0 +1,59 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+)
+
+var terminationGracePeriod time.Duration
+
+// SetTerminationGracePeriod sets how long a command's subprocess is given to
+// exit after SIGTERM before it's killed. Zero kills it immediately.
+func SetTerminationGracePeriod(d time.Duration) {
+	terminationGracePeriod = d
+}
+
+// stop ends the processes of pg and waits for them to exit. They're asked to
+// exit first when there is a grace period, and killed once it runs out.
+func stop(pg process_group.ProcessGroup) {
+	gracePeriod := terminationGracePeriod
+	if gracePeriod <= 0 || pg.Terminate() != nil {
+		pg.Kill()
+		pg.Wait()
+		return
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		pg.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+		// Only the root process is waited for, kill whatever else of the
+		// group still runs, e.g. children ignoring SIGTERM that hold a port.
+		// There's usually nothing left, so the error is ignored.
+		pg.Kill()
+	case <-time.After(gracePeriod):
+		log.Logf("Didn't exit within %v of SIGTERM, killing it.", gracePeriod)
+		pg.Kill()
+		<-exited
+	}
+}
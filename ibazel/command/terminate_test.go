@@ -0,0 +1,133 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+)
+
+func startShell(t *testing.T, script string) process_group.ProcessGroup {
+	pg := process_group.Command("sh", "-c", script)
+	if err := pg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Give the shell time to install its trap.
+	time.Sleep(100 * time.Millisecond)
+	return pg
+}
+
+func TestStop_terminatesWithinGracePeriod(t *testing.T) {
+	defer SetTerminationGracePeriod(0)
+	SetTerminationGracePeriod(5 * time.Second)
+
+	pg := startShell(t, "trap 'exit 3' TERM; while true; do sleep 0.01; done")
+	defer pg.Close()
+
+	start := time.Now()
+	stop(pg)
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Errorf("stop waited %v for a process that exits on SIGTERM", elapsed)
+	}
+	if code := pg.RootProcess().ProcessState.ExitCode(); code != 3 {
+		t.Errorf("Exit code = %d, want 3 from the SIGTERM trap", code)
+	}
+}
+
+func TestStop_terminatesSuspended(t *testing.T) {
+	defer SetTerminationGracePeriod(0)
+	SetTerminationGracePeriod(5 * time.Second)
+
+	pg := startShell(t, "trap 'exit 3' TERM; while true; do sleep 0.01; done")
+	defer pg.Close()
+	if err := syscall.Kill(-pg.RootProcess().Process.Pid, syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+
+	stop(pg)
+	if code := pg.RootProcess().ProcessState.ExitCode(); code != 3 {
+		t.Errorf("Exit code = %d, want 3 from the SIGTERM trap of the suspended process", code)
+	}
+}
+
+func TestStop_killsAfterGracePeriod(t *testing.T) {
+	defer SetTerminationGracePeriod(0)
+	SetTerminationGracePeriod(200 * time.Millisecond)
+
+	pg := startShell(t, "trap '' TERM; while true; do sleep 0.01; done")
+	defer pg.Close()
+
+	start := time.Now()
+	stop(pg)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("stop returned after %v, before the grace period ran out", elapsed)
+	}
+	if pg.RootProcess().ProcessState == nil {
+		t.Error("The process ignoring SIGTERM wasn't killed")
+	}
+}
+
+func TestStop_killsWithoutGracePeriod(t *testing.T) {
+	pg := startShell(t, "trap 'exit 3' TERM; while true; do sleep 0.01; done")
+	defer pg.Close()
+
+	stop(pg)
+	if code := pg.RootProcess().ProcessState.ExitCode(); code != -1 {
+		t.Errorf("Exit code = %d, want -1 for a killed process", code)
+	}
+}
+
+func TestStop_killsChildrenOfExitedRoot(t *testing.T) {
+	defer SetTerminationGracePeriod(0)
+	SetTerminationGracePeriod(5 * time.Second)
+
+	// Every process of the group holds the write end of the pipe, so reading
+	// it only ends once all of them exited.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	pg := process_group.Command("sh", "-c", "sh -c \"trap '' TERM; while true; do sleep 0.01; done\" & trap 'exit 3' TERM; while true; do sleep 0.01; done")
+	pg.RootProcess().Stdout = w
+	if err := pg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer pg.Close()
+	w.Close()
+	// Give the shells time to install their traps.
+	time.Sleep(100 * time.Millisecond)
+
+	stop(pg)
+
+	closed := make(chan struct{})
+	go func() {
+		ioutil.ReadAll(r)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		pg.Kill()
+		t.Error("The child ignoring SIGTERM still runs after its parent exited")
+	}
+}
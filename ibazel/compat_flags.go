@@ -0,0 +1,145 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The flags in this file follow the conventions of nodemon and watchexec, so
+// that scripts written for them keep working when iBazel takes over. They map
+// onto --watch_filter and --debounce.
+
+// extFlag holds the extensions given with --ext, which become a --watch_filter
+// for the whole workspace.
+type extFlag []string
+
+// ignoreFlag holds the patterns given with --ignore.
+type ignoreFlag []string
+
+// delayFlag is the duration given with --delay, 0 if it wasn't.
+type delayFlag time.Duration
+
+var (
+	extensions     = extFlag{}
+	ignorePatterns = ignoreFlag{}
+	delay          delayFlag
+)
+
+func init() {
+	flag.Var(&extensions, "ext", "Only rebuild on changes to source files with these extensions, e.g. `js,ts,json`, like nodemon's --ext and watchexec's --exts. A --watch_filter for a directory wins over it")
+	flag.Var(&ignorePatterns, "ignore", "Don't rebuild on changes to source files matching this pattern, e.g. `tests/*` or `*.snap`, like nodemon's and watchexec's --ignore. A pattern matches the path relative to the workspace, the name of the file or any directory the file is in. Can be repeated")
+	flag.Var(&delay, "delay", "Wait this long for more changes before rebuilding, like nodemon's --delay: in seconds, e.g. `2.5`, or with a unit, e.g. 500ms. Overrides --debounce")
+}
+
+func (f *extFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *extFlag) Set(value string) error {
+	filter := watchFilter{packagePattern: packagePattern{recursive: true}}
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+		if ext == "" {
+			continue
+		}
+		if strings.ContainsAny(ext, `/*?[\`) {
+			return fmt.Errorf("%q isn't an extension", ext)
+		}
+		*f = append(*f, ext)
+		filter.patterns = append(filter.patterns, "*."+ext)
+	}
+	if len(filter.patterns) == 0 {
+		return fmt.Errorf("no extensions in %q", value)
+	}
+	watchFilters = append(watchFilters, filter)
+	return nil
+}
+
+func (f *ignoreFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, " ")
+}
+
+func (f *ignoreFlag) Set(value string) error {
+	// Like nodemon, a trailing slash ignores a directory.
+	pattern := strings.TrimSuffix(filepath.ToSlash(strings.TrimSpace(value)), "/")
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%q isn't a valid pattern: %v", value, err)
+	}
+	*f = append(*f, pattern)
+	return nil
+}
+
+// ignores returns whether one of the patterns matches rel, a path relative to
+// the workspace with forward slashes, its name or one of its directories.
+func (f ignoreFlag) ignores(rel string) bool {
+	for _, pattern := range f {
+		// Matching every prefix of the path ignores the files in directories
+		// too, e.g. node_modules or tests/*.
+		for prefix := rel; prefix != "." && prefix != "/"; prefix = path.Dir(prefix) {
+			if ok, _ := path.Match(pattern, prefix); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(prefix)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *delayFlag) String() string {
+	if d == nil || *d == 0 {
+		return ""
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *delayFlag) Set(value string) error {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		*d = delayFlag(seconds * float64(time.Second))
+	} else if duration, err := time.ParseDuration(value); err == nil {
+		*d = delayFlag(duration)
+	} else {
+		return fmt.Errorf("%q is neither a number of seconds nor a duration", value)
+	}
+	if *d < 0 {
+		return fmt.Errorf("%q is negative", value)
+	}
+	return nil
+}
+
+// debounce returns the --delay if it was given and the --debounce otherwise.
+func debounce() time.Duration {
+	if delay != 0 {
+		return time.Duration(delay)
+	}
+	return *debounceDuration
+}
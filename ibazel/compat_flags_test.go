@@ -0,0 +1,102 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtFlag(t *testing.T) {
+	oldWatchFilters := watchFilters
+	watchFilters = watchFilterFlag{}
+	defer func() { watchFilters = oldWatchFilters }()
+
+	f := extFlag{}
+	if err := f.Set("js, .ts,json"); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "js,ts,json", f.String(), "Extensions")
+	assertEqual(t, "//...=*.js,*.ts,*.json", watchFilters.String(), "Watch filters")
+
+	for _, value := range []string{"", ",", "*.js", "src/js"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) should have failed", value)
+		}
+	}
+}
+
+func TestDelayFlag(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"2.5":   2500 * time.Millisecond,
+		"1":     time.Second,
+		"500ms": 500 * time.Millisecond,
+	} {
+		var d delayFlag
+		if err := d.Set(value); err != nil {
+			t.Errorf("Set(%q): %v", value, err)
+		}
+		assertEqual(t, want, time.Duration(d), value)
+	}
+
+	for _, value := range []string{"", "soon", "-1"} {
+		var d delayFlag
+		if err := d.Set(value); err == nil {
+			t.Errorf("Set(%q) should have failed", value)
+		}
+	}
+
+	defer setFlag(t, "debounce", "200ms")()
+	assertEqual(t, 200*time.Millisecond, debounce(), "Without --delay")
+	delay = delayFlag(3 * time.Second)
+	defer func() { delay = 0 }()
+	assertEqual(t, 3*time.Second, debounce(), "With --delay")
+}
+
+func TestIBazelIgnore(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	workspace := "/ws"
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+
+	oldWatchFilters, oldIgnorePatterns := watchFilters, ignorePatterns
+	watchFilters, ignorePatterns = watchFilterFlag{}, ignoreFlag{}
+	defer func() { watchFilters, ignorePatterns = oldWatchFilters, oldIgnorePatterns }()
+
+	for _, value := range []string{"*.snap", "tests/*", "node_modules/", "web/gen"} {
+		if err := ignorePatterns.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ignorePatterns.Set("["); err == nil {
+		t.Error("Set([) should have failed")
+	}
+	for _, c := range []struct {
+		path string
+		want bool
+	}{
+		{"main.go", true},
+		{"web/app.test.js.snap", false},
+		{"tests/app_test.go", false},
+		{"tests/unit/app_test.go", false},
+		{"web/tests/app_test.go", true},
+		{"web/node_modules/react/index.js", false},
+		{"web/gen/api.js", false},
+		{"gen/api.js", true},
+	} {
+		assertEqual(t, c.want, i.passesWatchFilter(filepath.Join(workspace, c.path)), c.path)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compile_commands regenerates compile_commands.json after BUILD files
+// or C and C++ sources changed, so that clangd and other language servers keep
+// up without a second watcher.
+package compile_commands
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var target = flag.String("compile_commands", "", "Target that writes compile_commands.json, run with the flags of the build after every successful iteration in which BUILD files or C and C++ sources changed, e.g. @hedron_compile_commands//:refresh_all. Disabled when empty")
+
+// The extensions of the sources that change the compile commands.
+var sourceExtensions = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cxx": true, ".c++": true,
+	".h": true, ".hh": true, ".hpp": true, ".hxx": true, ".h++": true,
+	".inc": true, ".ipp": true, ".m": true, ".mm": true,
+}
+
+// runScript runs the script `bazel run --script_path` wrote with args.
+var runScript = func(script string, args []string) ([]byte, error) {
+	return exec.Command(script, args...).CombinedOutput()
+}
+
+type CompileCommands struct {
+	newBazel  func() bazel.Bazel
+	buildArgs func() []string
+
+	// Whether the compile commands may be out of date. They are regenerated
+	// after the first successful iteration.
+	stale bool
+}
+
+// New returns the integration. newBazel returns the Bazel that builds the
+// target, and buildArgs the flags of the builds, which the target passes on
+// to its `bazel aquery`. Both are called on the main loop, like the target
+// runs, so that it doesn't wait for the Bazel server or change its options
+// while iBazel builds.
+func New(newBazel func() bazel.Bazel, buildArgs func() []string) *CompileCommands {
+	return &CompileCommands{
+		newBazel:  newBazel,
+		buildArgs: buildArgs,
+		stale:     true,
+	}
+}
+
+func (c *CompileCommands) Initialize(info *map[string]string) {}
+
+func (c *CompileCommands) TargetDecider(rule *blaze_query.Rule) {}
+
+func (c *CompileCommands) ChangeDetected(targets []string, changeType string, change string) {
+	if changeType == "graph" || sourceExtensions[strings.ToLower(filepath.Ext(change))] {
+		c.stale = true
+	}
+}
+
+func (c *CompileCommands) BeforeCommand(targets []string, command string) {}
+
+func (c *CompileCommands) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	if *target == "" || !success || !c.stale {
+		return
+	}
+	c.stale = false
+	c.run()
+}
+
+func (c *CompileCommands) run() {
+	pattern := "compile_commands*"
+	if runtime.GOOS == "windows" {
+		pattern += ".bat"
+	}
+	script, err := temp_dir.TempFile(pattern)
+	if err != nil {
+		log.Errorf("Error regenerating compile_commands.json: %v", err)
+		return
+	}
+	script.Close()
+	defer os.Remove(script.Name())
+
+	b := c.newBazel()
+	if _, output, err := b.Run("--script_path="+script.Name(), *target); err != nil {
+		log.Errorf("Error building %s: %v\n%s", *target, err, output)
+		return
+	}
+	if output, err := runScript(script.Name(), c.buildArgs()); err != nil {
+		log.Errorf("Error running %s: %v\n%s", *target, err, output)
+		return
+	}
+	log.Logf("Regenerated compile_commands.json with %s", *target)
+}
+
+func (c *CompileCommands) Cleanup() {}
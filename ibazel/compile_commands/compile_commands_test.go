@@ -0,0 +1,90 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compile_commands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+func TestCompileCommands(t *testing.T) {
+	*target = "@hedron_compile_commands//:refresh_all"
+	defer func() { *target = "" }()
+
+	runs := 0
+	oldRunScript := runScript
+	defer func() { runScript = oldRunScript }()
+	runScript = func(script string, args []string) ([]byte, error) {
+		runs++
+		if !reflect.DeepEqual([]string{"--config=dev"}, args) {
+			t.Errorf("Ran with %v instead of the flags of the build", args)
+		}
+		return nil, nil
+	}
+
+	c := New(func() bazel.Bazel { return &mock_bazel.MockBazel{} }, func() []string { return []string{"--config=dev"} })
+	iteration := func(changeType, change string, success bool) {
+		if change != "" {
+			c.ChangeDetected([]string{"//app:server"}, changeType, change)
+		}
+		c.BeforeCommand([]string{"//app:server"}, "build")
+		c.AfterCommand([]string{"//app:server"}, "build", success, nil)
+	}
+
+	iteration("", "", true)
+	if runs != 1 {
+		t.Errorf("Ran %d times after the first build, want 1", runs)
+	}
+
+	iteration("source", "/ws/app/main.go", true)
+	iteration("source", "/ws/app/README.md", true)
+	if runs != 1 {
+		t.Errorf("Ran %d times after other changes, want 1", runs)
+	}
+
+	iteration("source", "/ws/app/server.cc", false)
+	if runs != 1 {
+		t.Errorf("Ran %d times after a failed build, want 1", runs)
+	}
+	iteration("", "", true)
+	if runs != 2 {
+		t.Errorf("Ran %d times after a C++ source changed, want 2", runs)
+	}
+
+	iteration("graph", "/ws/app/BUILD", true)
+	if runs != 3 {
+		t.Errorf("Ran %d times after a BUILD file changed, want 3", runs)
+	}
+}
+
+func TestCompileCommands_disabled(t *testing.T) {
+	runs := 0
+	oldRunScript := runScript
+	defer func() { runScript = oldRunScript }()
+	runScript = func(script string, args []string) ([]byte, error) {
+		runs++
+		return nil, nil
+	}
+
+	c := New(func() bazel.Bazel { return &mock_bazel.MockBazel{} }, func() []string { return nil })
+	c.ChangeDetected([]string{"//app:server"}, "graph", "/ws/app/BUILD")
+	c.AfterCommand([]string{"//app:server"}, "build", true, nil)
+	if runs != 0 {
+		t.Errorf("Ran without --compile_commands")
+	}
+}
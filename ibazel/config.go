@@ -0,0 +1,58 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/config"
+	"github.com/bazelbuild/bazel-watcher/ibazel/workspace_finder"
+)
+
+var (
+	ibazelrc       = flag.String("ibazelrc", "", "Also read flags from this file, after the .ibazelrc of the user and of the workspace")
+	ignoreIBazelrc = flag.Bool("ignore_ibazelrc", false, "Don't read the .ibazelrc of the user and of the workspace")
+)
+
+// loadConfig gives the flags that weren't on the command line the values of
+// the .ibazelrc files, and returns the Bazel flags the files give. Those go
+// before the ones of the command line, so that Bazel lets the latter win.
+func loadConfig(workspaceFinder workspace_finder.WorkspaceFinder) ([]string, error) {
+	var paths []string
+	if !*ignoreIBazelrc {
+		// Outside of a workspace only the user's file is read.
+		workspacePath, _ := workspaceFinder.FindWorkspace()
+		paths = config.Paths(workspacePath)
+	}
+	if *ibazelrc != "" {
+		if _, err := os.Stat(*ibazelrc); err != nil {
+			return nil, fmt.Errorf("--ibazelrc: %v", err)
+		}
+		paths = append(paths, *ibazelrc)
+	}
+
+	c, err := config.Load(paths...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Apply(flag.CommandLine); err != nil {
+		return nil, err
+	}
+	return c.BazelArgs(func(arg string) bool {
+		return isOverrideableStartupFlag(arg) || isOverrideableBazelFlag(arg)
+	})
+}
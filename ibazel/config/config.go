@@ -0,0 +1,228 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config reads the .ibazelrc files of the user and of the workspace,
+// which give defaults for iBazel's flags and Bazel flags to add to every
+// command, so that teams can check their settings into the repository. Flags
+// given on the command line win over the files, and the workspace's file wins
+// over the user's.
+//
+// Every line that isn't empty or a comment holds flags, as they would be
+// given on the command line:
+//
+//	# Wait a bit longer for editors that save in several steps.
+//	--debounce=500ms --ignore=*.snap
+//	--run_output_interactive=false
+//	bazel --config=dev
+//
+// Lines starting with "bazel" hold Bazel startup and command flags instead.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the name of the configuration files, in the home directory of
+// the user and in the root of the workspace.
+const FileName = ".ibazelrc"
+
+// line is a line of flags and where it comes from, e.g. ".ibazelrc:3".
+type line struct {
+	source string
+	args   []string
+}
+
+// Config is what the configuration files say, in the order they were read.
+type Config struct {
+	// The files that were read.
+	Files []string
+
+	flags []line
+	bazel []line
+}
+
+// Paths returns the configuration files for workspace, the user's first. The
+// workspace is left out if it is empty.
+func Paths(workspace string) []string {
+	var paths []string
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, FileName))
+	}
+	if workspace != "" {
+		paths = append(paths, filepath.Join(workspace, FileName))
+	}
+	return paths
+}
+
+// Load reads the files at paths, in order. Files that don't exist are skipped.
+func Load(paths ...string) (*Config, error) {
+	c := &Config{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		err = c.parse(path, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.Files = append(c.Files, path)
+	}
+	return c, nil
+}
+
+// parse adds the lines of r, the file called name.
+func (c *Config) parse(name string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		source := fmt.Sprintf("%s:%d", name, n)
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		args, err := split(text)
+		if err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+		if args[0] == "bazel" {
+			c.bazel = append(c.bazel, line{source, args[1:]})
+			continue
+		}
+		c.flags = append(c.flags, line{source, args})
+	}
+	return scanner.Err()
+}
+
+// split splits a line into words like a shell does, without expanding
+// anything: quotes group words and a backslash escapes the next character
+// outside of single quotes.
+func split(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// boolFlag is implemented by the values of flags that don't need one, like in
+// the flag package.
+type boolFlag interface {
+	IsBoolFlag() bool
+}
+
+// Apply sets the flags of fs that the files give and that weren't given on
+// the command line, so it has to be called after fs was parsed. Flags that
+// can be repeated add up over the files.
+func (c *Config) Apply(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for _, l := range c.flags {
+		for n := 0; n < len(l.args); n++ {
+			if !strings.HasPrefix(l.args[n], "-") {
+				return fmt.Errorf("%s: %q isn't a flag, Bazel flags go on a line starting with bazel", l.source, l.args[n])
+			}
+			name := strings.TrimLeft(l.args[n], "-")
+			value, hasValue := "", false
+			if eq := strings.Index(name, "="); eq >= 0 {
+				name, value, hasValue = name[:eq], name[eq+1:], true
+			}
+			f := fs.Lookup(name)
+			if f == nil {
+				return fmt.Errorf("%s: unknown flag --%s", l.source, name)
+			}
+			if !hasValue {
+				if b, ok := f.Value.(boolFlag); ok && b.IsBoolFlag() {
+					value = "true"
+				} else if n+1 < len(l.args) {
+					n++
+					value = l.args[n]
+				} else {
+					return fmt.Errorf("%s: --%s needs a value", l.source, name)
+				}
+			}
+			if given[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: invalid value %q for --%s: %v", l.source, value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// BazelArgs returns the Bazel flags the files give, which supported has to
+// accept.
+func (c *Config) BazelArgs(supported func(arg string) bool) ([]string, error) {
+	var args []string
+	for _, l := range c.bazel {
+		for _, arg := range l.args {
+			if !supported(arg) {
+				return nil, fmt.Errorf("%s: %s isn't a Bazel flag iBazel passes on", l.source, arg)
+			}
+			args = append(args, arg)
+		}
+	}
+	return args, nil
+}
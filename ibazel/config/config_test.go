@@ -0,0 +1,150 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want []string
+	}{
+		{"--debounce=500ms  --ignore=*.snap", []string{"--debounce=500ms", "--ignore=*.snap"}},
+		{`--command="make assets" --x='a "b"'`, []string{"--command=make assets", `--x=a "b"`}},
+		{`--a=b\ c --d=""`, []string{"--a=b c", "--d="}},
+	} {
+		got, err := split(c.line)
+		if err != nil {
+			t.Errorf("split(%q): %v", c.line, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("split(%q) = %q, want %q", c.line, got, c.want)
+		}
+	}
+
+	for _, line := range []string{`--command="make`, `--a=b\`} {
+		if _, err := split(line); err == nil {
+			t.Errorf("split(%q) didn't fail", line)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	user := filepath.Join(dir, "user")
+	workspace := filepath.Join(dir, "workspace")
+	if err := ioutil.WriteFile(user, []byte("--debounce=1s\n--tags a --verbose\nbazel --config=mine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(workspace, []byte("# The team's settings.\n\n--debounce=500ms --tags=b\n--name team\nbazel --config=dev --output_base=/tmp/ob\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(user, filepath.Join(dir, "missing"), workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Files, []string{user, workspace}) {
+		t.Errorf("Files = %v", c.Files)
+	}
+
+	fs := flag.NewFlagSet("ibazel", flag.ContinueOnError)
+	debounce := fs.Duration("debounce", 100*time.Millisecond, "")
+	verbose := fs.Bool("verbose", false, "")
+	name := fs.String("name", "", "")
+	var tags stringsFlag
+	fs.Var(&tags, "tags", "")
+	if err := fs.Parse([]string{"--name=cli"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Apply(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *debounce != 500*time.Millisecond {
+		t.Errorf("--debounce = %v, want the workspace's 500ms", *debounce)
+	}
+	if !*verbose {
+		t.Errorf("--verbose wasn't set")
+	}
+	if *name != "cli" {
+		t.Errorf("--name = %q, want the command line's", *name)
+	}
+	if !reflect.DeepEqual([]string(tags), []string{"a", "b"}) {
+		t.Errorf("--tags = %v, want both files'", tags)
+	}
+
+	args, err := c.BazelArgs(func(arg string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"--config=mine", "--config=dev", "--output_base=/tmp/ob"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("BazelArgs() = %v, want %v", args, want)
+	}
+	_, err = c.BazelArgs(func(arg string) bool { return !strings.HasPrefix(arg, "--output_base") })
+	if err == nil || !strings.Contains(err.Error(), "workspace:5: --output_base=/tmp/ob") {
+		t.Errorf("BazelArgs() with an unsupported flag: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		file string
+		want string
+	}{
+		{"--debounce=1s\n//foo:bar\n", `.ibazelrc:2: "//foo:bar" isn't a flag`},
+		{"--nope\n", ".ibazelrc:1: unknown flag --nope"},
+		{"--debounce\n", ".ibazelrc:1: --debounce needs a value"},
+		{"--debounce=soon\n", `.ibazelrc:1: invalid value "soon" for --debounce`},
+	} {
+		fs := flag.NewFlagSet("ibazel", flag.ContinueOnError)
+		fs.Duration("debounce", 0, "")
+		cfg := &Config{}
+		err := cfg.parse(".ibazelrc", strings.NewReader(c.file))
+		if err == nil {
+			err = cfg.Apply(fs)
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("%q: got %v, want %s", c.file, err, c.want)
+		}
+	}
+}
+
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ibazel_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	home := filepath.Join(tmp, "home")
+	workspace := filepath.Join(tmp, "workspace")
+	for _, dir := range []string{home, workspace} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	os.Setenv("HOME", home)
+
+	if err := ioutil.WriteFile(filepath.Join(home, ".ibazelrc"), []byte("--cancel_grace_period=1s\nbazel --config=mine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workspace, ".ibazelrc"), []byte("--cancel_grace_period=250ms\nbazel --bazelrc=/tmp/team.bazelrc --config=dev\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Set directly, since a flag set with flag.Set counts as given on the
+	// command line.
+	defer func(old time.Duration) { *cancelGracePeriod = old }(*cancelGracePeriod)
+
+	args, err := loadConfig(dirWorkspaceFinder(workspace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 250*time.Millisecond, *cancelGracePeriod, "--cancel_grace_period")
+	assertEqual(t, []string{"--config=mine", "--bazelrc=/tmp/team.bazelrc", "--config=dev"}, args, "Bazel flags")
+
+	targets, startupArgs, bazelArgs, _, _ := parseArgs(append(args, "//my:target", "--config=cli"))
+	assertEqual(t, []string{"//my:target"}, targets, "Targets")
+	assertEqual(t, []string{"--bazelrc=/tmp/team.bazelrc"}, startupArgs, "Startup flags")
+	if !reflect.DeepEqual(bazelArgs, []string{"--config=mine", "--config=dev", "--config=cli"}) {
+		t.Errorf("The command line's Bazel flags have to come last: %v", bazelArgs)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(workspace, ".ibazelrc"), []byte("bazel //not:a_flag\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(dirWorkspaceFinder(workspace)); err == nil || !strings.Contains(err.Error(), "//not:a_flag isn't a Bazel flag") {
+		t.Errorf("Expected an error for a target in the configuration, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/control"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/watch_snapshot"
+)
+
+var controlAddr = flag.String("control", "", "Serve an HTTP API for editor plugins and scripts on unix:<path> or localhost:<port>, to query what iBazel is doing and rebuild, pause or change its targets")
+
+// controlStatus is what the main loop last published for the control API,
+// which is served from other goroutines, see publishStatus.
+type controlStatus struct {
+	command  string
+	state    State
+	targets  []string
+	paused   bool
+	snapshot *watch_snapshot.Snapshot
+}
+
+// startControl serves the control API if --control is set.
+func (i *IBazel) startControl(command string) {
+	if *controlAddr == "" {
+		return
+	}
+	i.publishStatus(command)
+	s, err := control.Listen(*controlAddr, controlAPI{i})
+	if err != nil {
+		log.Errorf("Not serving the control API: %v", err)
+		return
+	}
+	i.control = s
+	log.Logf("Serving the control API on %s", s.Addr())
+}
+
+func (i *IBazel) stopControl() {
+	if i.control != nil {
+		i.control.Close()
+		i.control = nil
+	}
+}
+
+// publishStatus is called by the main loop before every state to hand what
+// it is doing to the control API. The snapshot isn't changed anymore once a
+// new query replaced it.
+func (i *IBazel) publishStatus(command string) {
+	if *controlAddr == "" {
+		return
+	}
+	i.statusLock.Lock()
+	defer i.statusLock.Unlock()
+	i.status = controlStatus{
+		command:  command,
+		state:    i.state,
+		targets:  append([]string{}, i.targets...),
+		paused:   i.paused,
+		snapshot: i.snapshot,
+	}
+}
+
+// controlAPI implements control.Controller. The requests that change
+// something are handed to the main loop like key presses and target edits.
+type controlAPI struct {
+	i *IBazel
+}
+
+func (c controlAPI) Status() control.Status {
+	c.i.statusLock.Lock()
+	status := c.i.status
+	c.i.statusLock.Unlock()
+
+	return control.Status{
+		State:       string(status.state),
+		Command:     status.command,
+		Targets:     status.targets,
+		Paused:      status.paused,
+		Iteration:   c.i.IterationID(),
+		Trigger:     c.i.IterationTrigger(),
+		Quarantined: c.i.quarantine.Targets(),
+		JUnitReport: c.i.junit.Latest(),
+	}
+}
+
+func (c controlAPI) Files() []control.File {
+	c.i.statusLock.Lock()
+	snapshot := c.i.status.snapshot
+	c.i.statusLock.Unlock()
+
+	files := []control.File{}
+	if snapshot == nil {
+		return files
+	}
+	for path, f := range snapshot.Files {
+		if f.Error != "" {
+			continue
+		}
+		files = append(files, control.File{Path: path, Kind: f.Kind, Targets: f.Targets})
+	}
+	sort.Slice(files, func(a, b int) bool { return files[a].Path < files[b].Path })
+	return files
+}
+
+func (c controlAPI) Rebuild() error {
+	return c.send(rebuildAction)
+}
+
+func (c controlAPI) Pause(paused bool) error {
+	if paused {
+		return c.send(stopWatchingAction)
+	}
+	return c.send(resumeAction)
+}
+
+func (c controlAPI) send(action keyAction) error {
+	if !c.i.sendKeyAction(action) {
+		return control.ErrBusy
+	}
+	return nil
+}
+
+func (c controlAPI) Restart(target string) error {
+	c.i.RestartTarget(target)
+	return nil
+}
+
+func (c controlAPI) EditTargets(add, remove []string) error {
+	if len(add) > 0 {
+		if err := c.i.AddTarget(add...); err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		return c.i.RemoveTarget(remove...)
+	}
+	return nil
+}
+
+func (c controlAPI) Focus(path string) error {
+	return c.i.FocusFile(path)
+}
+
+func (c controlAPI) SwitchPreset(n int) error {
+	return c.i.SwitchPreset(n)
+}
+
+func (c controlAPI) SetTestFilter(filter string) error {
+	c.i.SetTestFilter(filter)
+	return c.send(rebuildAction)
+}
+
+func (c controlAPI) Quarantine(target string, quarantined bool) error {
+	if _, ok := parseLabel(target); !ok {
+		return fmt.Errorf("%q isn't a valid target", target)
+	}
+	if quarantined {
+		c.i.quarantine.Add(target)
+	} else {
+		c.i.quarantine.Remove(target)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control serves a small HTTP API on a Unix socket or a localhost
+// port, so that editor plugins and scripts can drive a running iBazel: ask
+// what it is doing and which files it watches, rebuild, pause watching and
+// change its targets without restarting it.
+//
+// Every endpoint answers with JSON. Requests that change something are POSTs
+// whose parameters are form values, e.g.
+//
+//	curl --unix-socket /tmp/ibazel.sock -d target=//app:server http://ibazel/targets/add
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrBusy is returned by a Controller that can't take the request right now,
+// e.g. because the same request is still waiting to be handled.
+var ErrBusy = errors.New("iBazel is busy, try again")
+
+// Status is what iBazel is doing.
+type Status struct {
+	// The state of the main loop, e.g. "WAIT" or "RUN".
+	State   string   `json:"state"`
+	Command string   `json:"command"`
+	Targets []string `json:"targets"`
+	Paused  bool     `json:"paused"`
+	// The current iteration and why it started.
+	Iteration string `json:"iteration"`
+	Trigger   string `json:"trigger"`
+	// The quarantined tests and the directory of the latest JUnit report.
+	Quarantined []string `json:"quarantined"`
+	JUnitReport string   `json:"junit_report,omitempty"`
+}
+
+// File is a watched file.
+type File struct {
+	Path string `json:"path"`
+	// "source" or "build".
+	Kind string `json:"kind"`
+	// The targets that depend on the file.
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Controller is implemented by iBazel. Its methods are called from the
+// goroutines serving the requests.
+type Controller interface {
+	Status() Status
+	Files() []File
+	Rebuild() error
+	Pause(paused bool) error
+	// Restart restarts a running target, or all of them if target is empty.
+	Restart(target string) error
+	EditTargets(add, remove []string) error
+	Focus(path string) error
+	SwitchPreset(n int) error
+	SetTestFilter(filter string) error
+	Quarantine(target string, quarantined bool) error
+}
+
+// Server serves the API of a Controller.
+type Server struct {
+	addr     string
+	socket   string
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen starts serving the API on addr, which is either "unix:<path>" for a
+// Unix socket or "[localhost]:port" for a TCP port. Ports are only opened on
+// the loopback interface, since the API lets anyone who can reach it run
+// builds. Any process on the machine can still connect to a port, so requests
+// sent by browsers, which carry an Origin header, and requests for a Host
+// other than localhost, which DNS rebinding would send, are refused.
+func Listen(addr string, c Controller) (*Server, error) {
+	s := &Server{addr: addr}
+	network, address := "tcp", addr
+	if strings.HasPrefix(addr, "unix:") {
+		network, address = "unix", strings.TrimPrefix(addr, "unix:")
+		// Left behind by an iBazel that was killed.
+		os.Remove(address)
+		s.socket = address
+	} else {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a valid address, use unix:<path> or localhost:<port>: %v", addr, err)
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		if !isLoopback(host) {
+			return nil, fmt.Errorf("the control API can only listen on localhost, not on %s", host)
+		}
+		address = net.JoinHostPort(host, port)
+	}
+
+	var l net.Listener
+	var err error
+	if s.socket != "" {
+		l, err = listenUnix(s.socket)
+	} else {
+		l, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.socket == "" {
+		s.addr = l.Addr().String()
+	}
+	s.listener = l
+	s.server = &http.Server{Handler: guard(Handler(c), s.socket == "")}
+	go s.server.Serve(l)
+	return s, nil
+}
+
+// Addr returns the address the API is served on, with the port that was
+// picked if it was listening on port 0.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Close stops serving the API and removes its Unix socket.
+func (s *Server) Close() error {
+	err := s.server.Close()
+	if s.socket != "" {
+		os.Remove(s.socket)
+	}
+	return err
+}
+
+// guard refuses the requests that didn't come from a local client of the
+// API: those sent by a browser and, if tcp, those that were meant for another
+// host.
+func guard(h http.Handler, tcp bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			reply(w, http.StatusForbidden, errorReply{"the control API doesn't answer requests from browsers"})
+			return
+		}
+		if tcp && !isLoopback(r.Host) {
+			reply(w, http.StatusForbidden, errorReply{fmt.Sprintf("the control API only answers requests for localhost, not for %s", r.Host)})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback returns whether host, which may have a port, is localhost.
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Handler returns the handler serving the API of c.
+func Handler(c Controller) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", get(func(r *http.Request) (interface{}, error) {
+		return c.Status(), nil
+	}))
+	mux.HandleFunc("/files", get(func(r *http.Request) (interface{}, error) {
+		return c.Files(), nil
+	}))
+	mux.HandleFunc("/rebuild", post(func(r *http.Request) error {
+		return c.Rebuild()
+	}))
+	mux.HandleFunc("/pause", post(func(r *http.Request) error {
+		return c.Pause(true)
+	}))
+	mux.HandleFunc("/resume", post(func(r *http.Request) error {
+		return c.Pause(false)
+	}))
+	mux.HandleFunc("/restart", post(func(r *http.Request) error {
+		return c.Restart(r.FormValue("target"))
+	}))
+	mux.HandleFunc("/targets/add", post(func(r *http.Request) error {
+		targets, err := required(r, "target")
+		if err != nil {
+			return err
+		}
+		return c.EditTargets(targets, nil)
+	}))
+	mux.HandleFunc("/targets/remove", post(func(r *http.Request) error {
+		targets, err := required(r, "target")
+		if err != nil {
+			return err
+		}
+		return c.EditTargets(nil, targets)
+	}))
+	mux.HandleFunc("/focus", post(func(r *http.Request) error {
+		files, err := required(r, "file")
+		if err != nil {
+			return err
+		}
+		return c.Focus(files[0])
+	}))
+	mux.HandleFunc("/preset", post(func(r *http.Request) error {
+		n, err := strconv.Atoi(r.FormValue("n"))
+		if err != nil {
+			return badRequest{fmt.Errorf("%q isn't the number of a preset", r.FormValue("n"))}
+		}
+		return c.SwitchPreset(n)
+	}))
+	mux.HandleFunc("/test_filter", post(func(r *http.Request) error {
+		return c.SetTestFilter(r.FormValue("filter"))
+	}))
+	mux.HandleFunc("/quarantine/add", post(func(r *http.Request) error {
+		return quarantine(c, r, true)
+	}))
+	mux.HandleFunc("/quarantine/remove", post(func(r *http.Request) error {
+		return quarantine(c, r, false)
+	}))
+	return mux
+}
+
+func quarantine(c Controller, r *http.Request, quarantined bool) error {
+	targets, err := required(r, "target")
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := c.Quarantine(target, quarantined); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// badRequest is an error in the request itself rather than one iBazel ran
+// into handling it.
+type badRequest struct {
+	error
+}
+
+// required returns the values of the form field name, which has to be given.
+func required(r *http.Request, name string) ([]string, error) {
+	r.ParseForm()
+	values := r.Form[name]
+	if len(values) == 0 || values[0] == "" {
+		return nil, badRequest{fmt.Errorf("%s is missing", name)}
+	}
+	return values, nil
+}
+
+func get(handle func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			reply(w, http.StatusMethodNotAllowed, errorReply{r.URL.Path + " only answers GET requests"})
+			return
+		}
+		v, err := handle(r)
+		if err != nil {
+			replyError(w, err)
+			return
+		}
+		reply(w, http.StatusOK, v)
+	}
+}
+
+func post(handle func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			reply(w, http.StatusMethodNotAllowed, errorReply{r.URL.Path + " only answers POST requests"})
+			return
+		}
+		if err := handle(r); err != nil {
+			replyError(w, err)
+			return
+		}
+		reply(w, http.StatusOK, struct{}{})
+	}
+}
+
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+func replyError(w http.ResponseWriter, err error) {
+	status := http.StatusConflict
+	if err == ErrBusy {
+		status = http.StatusServiceUnavailable
+	} else if _, ok := err.(badRequest); ok {
+		status = http.StatusBadRequest
+	}
+	reply(w, status, errorReply{err.Error()})
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
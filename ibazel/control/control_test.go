@@ -0,0 +1,245 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeController struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeController) call(format string, args ...interface{}) error {
+	f.calls = append(f.calls, strings.TrimSpace(fmt.Sprintf(format, args...)))
+	return f.err
+}
+
+func (f *fakeController) Status() Status {
+	return Status{State: "WAIT", Command: "test", Targets: []string{"//a:test"}, Iteration: "3", Trigger: "file_change"}
+}
+
+func (f *fakeController) Files() []File {
+	return []File{{Path: "a/BUILD", Kind: "build", Targets: []string{"//a:test"}}}
+}
+
+func (f *fakeController) Rebuild() error               { return f.call("rebuild") }
+func (f *fakeController) Pause(paused bool) error      { return f.call("pause %v", paused) }
+func (f *fakeController) Restart(target string) error  { return f.call("restart %s", target) }
+func (f *fakeController) Focus(path string) error      { return f.call("focus %s", path) }
+func (f *fakeController) SwitchPreset(n int) error     { return f.call("preset %d", n) }
+func (f *fakeController) SetTestFilter(s string) error { return f.call("test_filter %s", s) }
+
+func (f *fakeController) EditTargets(add, remove []string) error {
+	return f.call("targets add=%v remove=%v", add, remove)
+}
+
+func (f *fakeController) Quarantine(target string, quarantined bool) error {
+	return f.call("quarantine %s %v", target, quarantined)
+}
+
+func TestStatus(t *testing.T) {
+	c := &fakeController{}
+	w := httptest.NewRecorder()
+	Handler(c).ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d", w.Code, http.StatusOK)
+	}
+	var got Status
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c.Status()) {
+		t.Errorf("Status = %+v, want %+v", got, c.Status())
+	}
+}
+
+func TestRequests(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		form url.Values
+		want string
+	}{
+		{"/rebuild", nil, "rebuild"},
+		{"/pause", nil, "pause true"},
+		{"/resume", nil, "pause false"},
+		{"/restart", nil, "restart"},
+		{"/restart", url.Values{"target": {"//a:server"}}, "restart //a:server"},
+		{"/targets/add", url.Values{"target": {"//a:test", "//b:test"}}, "targets add=[//a:test //b:test] remove=[]"},
+		{"/targets/remove", url.Values{"target": {"//a:test"}}, "targets add=[] remove=[//a:test]"},
+		{"/focus", url.Values{"file": {"a/a.go"}}, "focus a/a.go"},
+		{"/preset", url.Values{"n": {"2"}}, "preset 2"},
+		{"/test_filter", url.Values{"filter": {"TestA"}}, "test_filter TestA"},
+		{"/quarantine/add", url.Values{"target": {"//a:test"}}, "quarantine //a:test true"},
+		{"/quarantine/remove", url.Values{"target": {"//a:test"}}, "quarantine //a:test false"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			c := &fakeController{}
+			r := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			Handler(c).ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			if want := []string{tc.want}; !reflect.DeepEqual(c.calls, want) {
+				t.Errorf("Calls = %q, want %q", c.calls, want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		err    error
+		want   int
+	}{
+		{"wrong method", "GET", "/rebuild", nil, http.StatusMethodNotAllowed},
+		{"missing target", "POST", "/targets/add", nil, http.StatusBadRequest},
+		{"not a preset", "POST", "/preset", nil, http.StatusBadRequest},
+		{"busy", "POST", "/rebuild", ErrBusy, http.StatusServiceUnavailable},
+		{"refused", "POST", "/focus?file=a.go", errors.New("not following the editor"), http.StatusConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeController{err: tc.err}
+			w := httptest.NewRecorder()
+			Handler(c).ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if w.Code != tc.want {
+				t.Errorf("Status code = %d, want %d", w.Code, tc.want)
+			}
+			var reply errorReply
+			if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || reply.Error == "" {
+				t.Errorf("Reply = %q, want an error", w.Body)
+			}
+		})
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "ibazel.sock")
+
+	s, err := Listen("unix:"+socket, &fakeController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("The socket's mode is %v, want 0600: %v", info.Mode().Perm(), err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", socket) },
+	}}
+	resp, err := client.Get("http://ibazel/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []File
+	err = json.NewDecoder(resp.Body).Decode(&files)
+	resp.Body.Close()
+	if err != nil || len(files) != 1 || files[0].Path != "a/BUILD" {
+		t.Errorf("Files = %+v, %v", files, err)
+	}
+
+	s.Close()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("The socket is still there after Close: %v", err)
+	}
+}
+
+func TestListenTCP(t *testing.T) {
+	s, err := Listen("localhost:0", &fakeController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	resp, err := http.Get("http://" + s.Addr() + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if _, err := Listen("0.0.0.0:0", &fakeController{}); err == nil {
+		t.Errorf("Listen on all interfaces succeeded, want an error")
+	}
+}
+
+func TestListenTCP_forbidden(t *testing.T) {
+	f := &fakeController{}
+	s, err := Listen("localhost:0", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Addr())
+
+	for _, test := range []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"origin", "Origin", "http://example.com"},
+		{"null origin", "Origin", "null"},
+		{"rebound host", "Host", "evil.example.com:" + port},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://"+s.Addr()+"/rebuild", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.header == "Host" {
+				req.Host = test.value
+			} else {
+				req.Header.Set(test.header, test.value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Status code = %d, want %d", resp.StatusCode, http.StatusForbidden)
+			}
+			if len(f.calls) != 0 {
+				t.Errorf("Calls = %v, want none", f.calls)
+			}
+		})
+	}
+}
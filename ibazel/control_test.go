@@ -0,0 +1,121 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/control"
+	"github.com/bazelbuild/bazel-watcher/ibazel/watch_snapshot"
+)
+
+func controlRequest(t *testing.T, i *IBazel, method, path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	control.Handler(controlAPI{i}).ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("Error decoding the reply to %s: %v", path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestControl_status(t *testing.T) {
+	defer setFlag(t, "control", "localhost:0")()
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.state = WAIT
+	i.targets = []string{"//a:test"}
+	i.snapshot = watch_snapshot.New("/ws", "test", i.targets)
+	i.snapshot.Watch("/ws/a/a_test.go", "source file", "q", i.targets)
+	i.snapshot.Watch("/ws/a/BUILD", "BUILD file", "q", i.targets)
+	i.snapshot.Filter("@ext//:a.go", "", "it is in an external repository", "q")
+	i.startIteration(triggerFileChange)
+	i.publishStatus("test")
+
+	// Changes after publishing aren't seen until the next state.
+	i.targets = []string{"//b:test"}
+
+	var status control.Status
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "GET", "/status", &status), "Status code of /status")
+	assertEqual(t, "WAIT", status.State, "State")
+	assertEqual(t, "test", status.Command, "Command")
+	assertEqual(t, []string{"//a:test"}, status.Targets, "Targets")
+	assertEqual(t, i.IterationID(), status.Iteration, "Iteration")
+	assertEqual(t, triggerFileChange, status.Trigger, "Trigger")
+
+	var files []control.File
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "GET", "/files", &files), "Status code of /files")
+	assertEqual(t, []control.File{
+		{Path: "/ws/a/BUILD", Kind: "BUILD file", Targets: []string{"//a:test"}},
+		{Path: "/ws/a/a_test.go", Kind: "source file", Targets: []string{"//a:test"}},
+	}, files, "Files")
+}
+
+func TestControl_rebuildAndPause(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.state = WAIT
+
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "POST", "/rebuild", nil), "Status code of /rebuild")
+	// The main loop hasn't taken the first rebuild yet.
+	assertEqual(t, http.StatusServiceUnavailable, controlRequest(t, i, "POST", "/rebuild", nil), "Status code of a second /rebuild")
+	i.applyKeyAction(<-i.keyActions)
+	assertEqual(t, RUN, i.state, "State after /rebuild")
+
+	// Pausing twice leaves watching paused, unlike pressing p twice.
+	for n := 0; n < 2; n++ {
+		assertEqual(t, http.StatusOK, controlRequest(t, i, "POST", "/pause", nil), "Status code of /pause")
+		i.applyKeyAction(<-i.keyActions)
+		assertEqual(t, true, i.paused, "Paused after /pause")
+	}
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "POST", "/resume", nil), "Status code of /resume")
+	i.applyKeyAction(<-i.keyActions)
+	assertEqual(t, false, i.paused, "Paused after /resume")
+}
+
+func TestControl_targets(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.targets = []string{"//a:test"}
+
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "POST", "/targets/add?target=//b:test", nil), "Status code of /targets/add")
+	assertEqual(t, true, i.applyTargetEdit("test", <-i.targetEdits), "Targets changed")
+	assertEqual(t, []string{"//a:test", "//b:test"}, i.targets, "Targets after /targets/add")
+
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "POST", "/targets/remove?target=//c:test", nil), "Status code of /targets/remove")
+	assertEqual(t, false, i.applyTargetEdit("test", <-i.targetEdits), "Targets changed by removing a target that isn't watched")
+	assertEqual(t, http.StatusConflict, controlRequest(t, i, "POST", "/targets/add?target=b:c:d", nil), "Status code of adding an invalid target")
+}
+
+func TestControl_quarantine(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "POST", "/quarantine/add?target=//a:flaky_test", nil), "Status code of /quarantine/add")
+	assertEqual(t, true, i.quarantine.Contains("//a:flaky_test"), "Quarantined after /quarantine/add")
+	assertEqual(t, http.StatusConflict, controlRequest(t, i, "POST", "/quarantine/add?target=a:b:c", nil), "Status code of quarantining an invalid target")
+
+	var status control.Status
+	controlRequest(t, i, "GET", "/status", &status)
+	assertEqual(t, "//a:flaky_test", strings.Join(status.Quarantined, " "), "Quarantined in /status")
+
+	assertEqual(t, http.StatusOK, controlRequest(t, i, "POST", "/quarantine/remove?target=//a:flaky_test", nil), "Status code of /quarantine/remove")
+	assertEqual(t, false, i.quarantine.Contains("//a:flaky_test"), "Quarantined after /quarantine/remove")
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+// How many of the most recent events are kept for crash reports.
+const recentEventsSize = 50
+
+// Where crash reports are written, temp_dir.Dir() if empty.
+var crashReportDir = ""
+
+// recordEvent remembers something that happened for the crash report.
+func (i *IBazel) recordEvent(format string, args ...interface{}) {
+	event := time.Now().Format("15:04:05.000 ") + fmt.Sprintf(format, args...)
+	i.recentEventsLock.Lock()
+	defer i.recentEventsLock.Unlock()
+	i.recentEvents = append(i.recentEvents, event)
+	if len(i.recentEvents) > recentEventsSize {
+		i.recentEvents = i.recentEvents[len(i.recentEvents)-recentEventsSize:]
+	}
+}
+
+// recoverCrash turns a panic in the main loop into a crash report. It has to
+// be deferred directly.
+func (i *IBazel) recoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	path := i.writeCrashReport(r, debug.Stack())
+	i.saveRecording()
+	// Exiting skips the deferred Cleanup, which would leave the targets and
+	// the temporary files behind.
+	i.cleanupAfterCrash()
+	log.Fatalf("iBazel crashed: %v\nPlease attach %s when reporting this at https://github.com/bazelbuild/bazel-watcher/issues", r, path)
+}
+
+// cleanupAfterCrash runs Cleanup, which may well panic too in whatever state
+// the crash left iBazel in.
+func (i *IBazel) cleanupAfterCrash() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Cleaning up after the crash failed: %v", r)
+		}
+	}()
+	i.Cleanup()
+}
+
+// callListener calls f, which calls into a lifecycle listener. A listener that
+// panics gets a crash report but doesn't take iBazel down with it.
+func (i *IBazel) callListener(l Lifecycle, method string, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			path := i.writeCrashReport(fmt.Sprintf("%T.%s: %v", l, method, r), debug.Stack())
+			log.Errorf("%T.%s panicked: %v. A crash report was written to %s", l, method, r, path)
+		}
+	}()
+	f()
+}
+
+// writeCrashReport writes everything needed to make sense of a panic to a
+// file and returns its path.
+func (i *IBazel) writeCrashReport(r interface{}, stack []byte) string {
+	var report strings.Builder
+	fmt.Fprintf(&report, "iBazel crash report\n\n")
+	fmt.Fprintf(&report, "Panic: %v\n", r)
+	fmt.Fprintf(&report, "Version: %s\n", Version)
+	fmt.Fprintf(&report, "Time: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&report, "Platform: %s/%s %s\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	fmt.Fprintf(&report, "Command line: %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(&report, "State: %s\n", i.state)
+	fmt.Fprintf(&report, "Iteration: %s\n", i.IterationID())
+	fmt.Fprintf(&report, "Trigger: %s\n", i.IterationTrigger())
+
+	fmt.Fprintf(&report, "\nFlags:\n")
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(&report, "  --%s=%s\n", f.Name, f.Value)
+	})
+
+	fmt.Fprintf(&report, "\nRecent events:\n")
+	i.recentEventsLock.Lock()
+	for _, event := range i.recentEvents {
+		fmt.Fprintf(&report, "  %s\n", event)
+	}
+	i.recentEventsLock.Unlock()
+
+	fmt.Fprintf(&report, "\nStack:\n%s", stack)
+
+	dir := crashReportDir
+	if dir == "" {
+		dir = temp_dir.Dir()
+	}
+	f, err := ioutil.TempFile(dir, "ibazel_crash_*.txt")
+	if err != nil {
+		log.Errorf("Error writing crash report: %v\n%s", err, report.String())
+		return "<none>"
+	}
+	defer f.Close()
+	if _, err := f.WriteString(report.String()); err != nil {
+		log.Errorf("Error writing crash report: %v\n%s", err, report.String())
+	}
+	return f.Name()
+}
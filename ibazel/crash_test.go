@@ -0,0 +1,145 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+type panickingListener struct{}
+
+func (p *panickingListener) Initialize(info *map[string]string)                                {}
+func (p *panickingListener) TargetDecider(rule *blaze_query.Rule)                              {}
+func (p *panickingListener) ChangeDetected(targets []string, changeType string, change string) {}
+func (p *panickingListener) Cleanup()                                                          {}
+func (p *panickingListener) BeforeCommand(targets []string, command string) {
+	panic("boom")
+}
+func (p *panickingListener) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+}
+
+func TestCrashReport_listener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_crash_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	crashReportDir = dir
+	defer func() { crashReportDir = "" }()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&panickingListener{}, &phaseRecorder{&phases}}
+	i.state = RUN
+
+	i.changeDetected([]string{"//path/to:target"}, change.Source, fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Write})
+	i.beforeCommand([]string{"//path/to:target"}, "build")
+
+	// The other listeners still got the event.
+	assertEqual(t, []string{"before build //path/to:target"}, phases, "Lifecycle events")
+
+	if reports, _ := ioutil.ReadDir(dir); len(reports) != 1 {
+		t.Errorf("Expected a crash report for the listener, got %d files", len(reports))
+	}
+
+	path := i.writeCrashReport("test", []byte("stack"))
+	report, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Panic: test",
+		"State: RUN",
+		"--debounce=",
+		"source write /ws/a.go",
+		"build //path/to:target",
+		"Stack:\nstack",
+	} {
+		if !strings.Contains(string(report), want) {
+			t.Errorf("Crash report is missing %q:\n%s", want, report)
+		}
+	}
+}
+
+type cleanupListener struct {
+	panickingListener
+	cleanedUp bool
+}
+
+func (c *cleanupListener) Cleanup() { c.cleanedUp = true }
+
+func TestRecoverCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_crash_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	crashReportDir = dir
+	defer func() { crashReportDir = "" }()
+
+	i := newIBazel(t)
+	l := &cleanupListener{}
+	i.lifecycleListeners = []Lifecycle{l}
+
+	func() {
+		defer i.recoverCrash()
+		panic("boom")
+	}()
+	assertEqual(t, true, l.cleanedUp, "Cleaned up after the crash")
+}
+
+func TestRecordEvent(t *testing.T) {
+	i := &IBazel{}
+	for n := 0; n < recentEventsSize+10; n++ {
+		i.recordEvent("event %d", n)
+	}
+	if len(i.recentEvents) != recentEventsSize {
+		t.Errorf("Kept %d events, want %d", len(i.recentEvents), recentEventsSize)
+	}
+	if !strings.HasSuffix(i.recentEvents[0], "event 10") {
+		t.Errorf("Oldest event is %q, want event 10", i.recentEvents[0])
+	}
+}
+
+func TestRecordEvent_concurrent(t *testing.T) {
+	i := &IBazel{}
+	// Shutdown records events from the goroutine that handles signals while
+	// the main loop records its own.
+	done := make(chan struct{})
+	go func() {
+		for n := 0; n < recentEventsSize; n++ {
+			i.recordEvent("signal %d", n)
+		}
+		close(done)
+	}()
+	for n := 0; n < recentEventsSize; n++ {
+		i.recordEvent("main loop %d", n)
+	}
+	<-done
+	if len(i.recentEvents) != recentEventsSize {
+		t.Errorf("Kept %d events, want %d", len(i.recentEvents), recentEventsSize)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// How long after a watched directory is renamed the Create of its new name
+// is expected.
+const dirMoveWindow = time.Second
+
+type dirMove struct {
+	from string
+	to   string
+}
+
+type pendingRename struct {
+	dir string
+	at  time.Time
+}
+
+// dirMoveDetector pairs the Rename of a watched directory with the Create of
+// the directory it was moved to. fsnotify reports the two separately, without
+// anything that ties them together, and only if the parent directories are
+// watched.
+type dirMoveDetector struct {
+	pending []pendingRename
+	now     func() time.Time
+}
+
+// observe returns the move that e completes, if any. watched tells whether
+// any watched file is inside of a directory, and moved whether the directory
+// created at m.to is the one renamed away from m.from.
+func (d *dirMoveDetector) observe(e fsnotify.Event, watched func(dir string) bool, moved func(m dirMove) bool) (dirMove, bool) {
+	if d.now == nil {
+		d.now = time.Now
+	}
+	now := d.now()
+
+	// Forget the renames whose Create never came, e.g. because the directory
+	// was moved out of the watched tree.
+	for len(d.pending) > 0 && now.Sub(d.pending[0].at) > dirMoveWindow {
+		d.pending = d.pending[1:]
+	}
+
+	switch {
+	case e.Op&fsnotify.Rename != 0:
+		if !watched(e.Name) {
+			break
+		}
+		// Both file watchers get the rename of a directory they share.
+		dir := filepath.Clean(e.Name)
+		if n := len(d.pending); n > 0 && d.pending[n-1].dir == dir {
+			d.pending[n-1].at = now
+			break
+		}
+		d.pending = append(d.pending, pendingRename{dir, now})
+	case e.Op&fsnotify.Create != 0:
+		if len(d.pending) == 0 {
+			break
+		}
+		if info, err := os.Stat(e.Name); err != nil || !info.IsDir() {
+			break
+		}
+		// Pair the Create with the latest rename of the same directory, and
+		// leave the other renames pending.
+		for n := len(d.pending) - 1; n >= 0; n-- {
+			m := dirMove{from: d.pending[n].dir, to: filepath.Clean(e.Name)}
+			if moved(m) {
+				d.pending = append(d.pending[:n], d.pending[n+1:]...)
+				return m, true
+			}
+		}
+	}
+	return dirMove{}, false
+}
+
+// remap returns where path is after the move, and whether it was inside of
+// the moved directory.
+func (m dirMove) remap(path string) (string, bool) {
+	if path == m.from {
+		return m.to, true
+	}
+	if rel := strings.TrimPrefix(path, m.from+string(filepath.Separator)); rel != path {
+		return filepath.Join(m.to, rel), true
+	}
+	return path, false
+}
+
+// isWatchedDir returns whether any watched file is inside of dir.
+func (i *IBazel) isWatchedDir(dir string) bool {
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	for _, files := range i.filesWatched {
+		for file := range files {
+			if strings.HasPrefix(file, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDirMove returns whether the directory created at m.to is the one that was
+// renamed away from m.from: the old one must be gone, and the new one must
+// have the same name, when it was moved to another directory, or hold the
+// watched files of the old one, when it was renamed in place. Any other
+// directory created in the meantime, e.g. with mkdir, isn't a move.
+func (i *IBazel) isDirMove(m dirMove) bool {
+	if _, err := os.Stat(m.from); !os.IsNotExist(err) {
+		return false
+	}
+	if filepath.Base(m.from) == filepath.Base(m.to) {
+		return true
+	}
+	prefix := m.from + string(filepath.Separator)
+	for _, files := range i.filesWatched {
+		for file := range files {
+			if !strings.HasPrefix(file, prefix) {
+				continue
+			}
+			moved, _ := m.remap(file)
+			if _, err := os.Stat(moved); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detectDirMove feeds e to the directory move detector and, when e completes
+// the move of a watched directory, moves the watches along with it and
+// returns true. The build graph needs to be requeried afterwards.
+func (i *IBazel) detectDirMove(targets []string, e fsnotify.Event) bool {
+	m, ok := i.dirMoves.observe(e, i.isWatchedDir, i.isDirMove)
+	if !ok {
+		return false
+	}
+
+	if affected := i.remapWatches(m); len(affected) > 0 {
+		log.Logf("Moved: %q to %q. Requerying %s...", m.from, m.to, strings.Join(affected, " "))
+	} else {
+		log.Logf("Moved: %q to %q. Requerying...", m.from, m.to)
+	}
+	i.changeDetected(targets, change.Graph, fsnotify.Event{Name: m.to, Op: fsnotify.Rename})
+	i.prevDir = m.to + string(filepath.Separator)
+	return true
+}
+
+// remapWatches replaces the watches inside of the moved directory with
+// watches of the same files at their new location, instead of dropping them
+// until the next query, and returns the mrun targets the move affects.
+func (i *IBazel) remapWatches(m dirMove) []string {
+	for watcher, files := range i.filesWatched {
+		remapped := make(map[string]struct{}, len(files))
+		dirs := map[string]string{}
+		for file := range files {
+			moved, ok := m.remap(file)
+			remapped[moved] = struct{}{}
+			if ok {
+				from, _ := filepath.Split(file)
+				to, _ := filepath.Split(moved)
+				dirs[from] = to
+			}
+		}
+		for from, to := range dirs {
+			// The old directory doesn't exist anymore, so fsnotify may have
+			// dropped its watch already.
+			watcher.Remove(from)
+			if err := watcher.Add(to); err != nil {
+				log.Errorf("Error watching %q: %v", to, err)
+			}
+		}
+		i.setWatched(watcher, remapped)
+	}
+
+	movedFiles := map[string][]string{}
+	for file, dependents := range i.srcFileTargets {
+		if moved, ok := m.remap(file); ok {
+			movedFiles[moved] = dependents
+			delete(i.srcFileTargets, file)
+		}
+	}
+	for file, dependents := range movedFiles {
+		i.srcFileTargets[file] = dependents
+	}
+
+	affected := map[string]struct{}{}
+	for _, dirStorage := range []map[string][]string{i.srcDirToWatch, i.bldDirToWatch} {
+		moved := map[string][]string{}
+		for dir, dirTargets := range dirStorage {
+			if to, ok := m.remap(filepath.Clean(dir)); ok {
+				moved[to+string(filepath.Separator)] = dirTargets
+				delete(dirStorage, dir)
+				for _, target := range dirTargets {
+					affected[target] = struct{}{}
+				}
+			}
+		}
+		for dir, dirTargets := range moved {
+			dirStorage[dir] = dirTargets
+		}
+	}
+
+	targets := make([]string, 0, len(affected))
+	for target := range affected {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestDirMoveDetector(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ibazel_dir_move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	from, to := filepath.Join(tmp, "old"), filepath.Join(tmp, "new")
+	other := filepath.Join(tmp, "other")
+	for _, dir := range []string{to, other} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Unix(100, 0)
+	d := &dirMoveDetector{now: func() time.Time { return now }}
+	watched := func(dir string) bool { return dir == from }
+	moved := func(m dirMove) bool { return m.to == to }
+
+	if _, ok := d.observe(fsnotify.Event{Name: to, Op: fsnotify.Create}, watched, moved); ok {
+		t.Errorf("A Create without a Rename isn't a move")
+	}
+	if _, ok := d.observe(fsnotify.Event{Name: filepath.Join(tmp, "unwatched"), Op: fsnotify.Rename}, watched, moved); ok {
+		t.Errorf("A Rename alone isn't a move")
+	}
+	d.observe(fsnotify.Event{Name: from, Op: fsnotify.Rename}, watched, moved)
+	if _, ok := d.observe(fsnotify.Event{Name: other, Op: fsnotify.Create}, watched, moved); ok {
+		t.Errorf("A directory created after the Rename was paired with it")
+	}
+	m, ok := d.observe(fsnotify.Event{Name: to, Op: fsnotify.Create}, watched, moved)
+	if !ok || m != (dirMove{from: from, to: to}) {
+		t.Errorf("Expected a move from %q to %q, got %+v, %v", from, to, m, ok)
+	}
+
+	// Both file watchers report the rename of a directory they share.
+	d.observe(fsnotify.Event{Name: from, Op: fsnotify.Rename}, watched, moved)
+	d.observe(fsnotify.Event{Name: from, Op: fsnotify.Rename}, watched, moved)
+	d.observe(fsnotify.Event{Name: to, Op: fsnotify.Create}, watched, moved)
+	if _, ok := d.observe(fsnotify.Event{Name: to, Op: fsnotify.Create}, watched, moved); ok {
+		t.Errorf("A rename reported twice was paired with two Creates")
+	}
+
+	d.observe(fsnotify.Event{Name: from, Op: fsnotify.Rename}, watched, moved)
+	now = now.Add(2 * dirMoveWindow)
+	if _, ok := d.observe(fsnotify.Event{Name: to, Op: fsnotify.Create}, watched, moved); ok {
+		t.Errorf("A Create long after the Rename isn't a move")
+	}
+}
+
+func TestIBazelIsDirMove(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ibazel_dir_move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, dir := range []string{"new", "sub/old", "other", "kept"} {
+		if err := os.MkdirAll(filepath.Join(tmp, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "new", "main.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := &countingWatcher{watches: map[string]int{}}
+	i := &IBazel{
+		filesWatched: map[fSNotifyWatcher]map[string]struct{}{
+			watcher: {
+				filepath.Join(tmp, "old", "main.go"):  {},
+				filepath.Join(tmp, "kept", "main.go"): {},
+			},
+		},
+	}
+
+	for _, c := range []struct {
+		from, to string
+		want     bool
+	}{
+		{"old", "new", true},
+		{"old", "sub/old", true},
+		{"old", "other", false},
+		{"kept", "sub/kept", false},
+	} {
+		m := dirMove{from: filepath.Join(tmp, c.from), to: filepath.Join(tmp, c.to)}
+		assertEqual(t, c.want, i.isDirMove(m), "Move from "+c.from+" to "+c.to)
+	}
+}
+
+func TestIBazelRemapWatches(t *testing.T) {
+	sep := string(filepath.Separator)
+	from, to := filepath.FromSlash("/ws/old"), filepath.FromSlash("/ws/new")
+	watcher := &countingWatcher{watches: map[string]int{from + sep: 1, filepath.FromSlash("/ws/lib/"): 1}}
+
+	i := &IBazel{
+		filesWatched: map[fSNotifyWatcher]map[string]struct{}{
+			watcher: {
+				filepath.Join(from, "main.go"):       {},
+				filepath.FromSlash("/ws/lib/lib.go"): {},
+			},
+		},
+		srcDirToWatch: map[string][]string{
+			from + sep:                     {"//old:server"},
+			filepath.FromSlash("/ws/lib/"): {"//old:server", "//lib:tool"},
+		},
+		bldDirToWatch: map[string][]string{},
+	}
+
+	affected := i.remapWatches(dirMove{from: from, to: to})
+
+	assertEqual(t, []string{"//old:server"}, affected, "Affected targets")
+	assertEqual(t, map[string]struct{}{
+		filepath.Join(to, "main.go"):         {},
+		filepath.FromSlash("/ws/lib/lib.go"): {},
+	}, i.filesWatched[watcher], "Watched files")
+	assertEqual(t, map[string]int{to + sep: 1, filepath.FromSlash("/ws/lib/"): 1}, watcher.watches, "Watched directories")
+	if !reflect.DeepEqual(i.srcDirToWatch[to+sep], []string{"//old:server"}) {
+		t.Errorf("The moved directory wasn't remapped: %v", i.srcDirToWatch)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+)
+
+// fileState is what is at the path of an event once it is handled.
+type fileState int
+
+const (
+	fileGone fileState = iota
+	fileExists
+	dirExists
+)
+
+// statFile returns what is at path.
+var statFile = func(path string) fileState {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return fileGone
+	case info.IsDir():
+		return dirExists
+	default:
+		return fileExists
+	}
+}
+
+// editorTempFile matches the names of the files editors write next to the
+// files they save: backups, swap files, lock files and the temporary files of
+// atomic saves.
+var editorTempFile = regexp.MustCompile(`(~$)|(^4913$)|(^\..*\.sw[a-px]$)|(^\.#)|(^#.*#$)|(___jb_(tmp|old)___$)|(^\.goutputstream-)`)
+
+// normalizeEvent turns the events that editors, git and the different
+// platforms produce for a change to a file into the same event everywhere,
+// so that whether a change triggers a rebuild doesn't depend on how it was
+// saved. watched is whether the file is one of the watched files, and state is
+// what is at its path now.
+//
+//   - The events of editors' temporary files are dropped.
+//   - Events for several operations at once are reduced to the most
+//     significant one.
+//   - A file that still exists after it was renamed or removed was replaced,
+//     e.g. by an atomic save, and a watched file that is created again was
+//     recreated, e.g. by vim or `git checkout`. Both are writes.
+//
+// Directories are left alone, their renames are how moves are detected, see
+// dirMoveDetector.
+func normalizeEvent(e fsnotify.Event, watched bool, state fileState) fsnotify.Event {
+	if editorTempFile.MatchString(filepath.Base(e.Name)) {
+		return fsnotify.Event{Name: e.Name}
+	}
+	switch changeOp(e.Op) {
+	case change.Remove:
+		e.Op = fsnotify.Remove
+	case change.Rename:
+		e.Op = fsnotify.Rename
+	case change.Create:
+		e.Op = fsnotify.Create
+	case change.Write:
+		e.Op = fsnotify.Write
+	default:
+		e.Op &= fsnotify.Chmod
+	}
+	if state != fileExists {
+		return e
+	}
+	switch {
+	case e.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+		e.Op = fsnotify.Write
+	case e.Op == fsnotify.Create && watched:
+		e.Op = fsnotify.Write
+	}
+	return e
+}
+
+// normalizedEvent normalizes an event of watcher, see normalizeEvent.
+func (i *IBazel) normalizedEvent(watcher fSNotifyWatcher, e fsnotify.Event) fsnotify.Event {
+	_, watched := i.filesWatched[watcher][e.Name]
+	state := fileGone
+	if e.Op&(fsnotify.Rename|fsnotify.Remove|fsnotify.Create) != 0 {
+		// Only these depend on what is at the path now.
+		state = statFile(e.Name)
+	}
+	i.rewatchReplaced(watcher, e, state)
+	return normalizeEvent(e, watched, state)
+}
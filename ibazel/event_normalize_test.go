@@ -0,0 +1,139 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestNormalizeEvent(t *testing.T) {
+	const file = "/ws/a.go"
+	type step struct {
+		name  string
+		op    fsnotify.Op
+		state fileState
+		want  fsnotify.Op
+	}
+	for _, c := range []struct {
+		name  string
+		steps []step
+	}{
+		{"vim on Linux", []step{
+			{"/ws/4913", fsnotify.Create, fileExists, 0},
+			{"/ws/4913", fsnotify.Remove, fileGone, 0},
+			{file, fsnotify.Rename, fileExists, fsnotify.Write},
+			{"/ws/a.go~", fsnotify.Create, fileExists, 0},
+			{file, fsnotify.Create, fileExists, fsnotify.Write},
+			{file, fsnotify.Write, fileExists, fsnotify.Write},
+			{file, fsnotify.Chmod, fileExists, fsnotify.Chmod},
+			{"/ws/a.go~", fsnotify.Remove, fileGone, 0},
+		}},
+		{"vim, handled before the file is written again", []step{
+			{file, fsnotify.Rename, fileGone, fsnotify.Rename},
+			{file, fsnotify.Create, fileExists, fsnotify.Write},
+		}},
+		{"vim swap files", []step{
+			{"/ws/.a.go.swp", fsnotify.Create, fileExists, 0},
+			{"/ws/.a.go.swx", fsnotify.Write, fileExists, 0},
+			{"/ws/.a.go.swp", fsnotify.Remove, fileGone, 0},
+		}},
+		{"vim on macOS", []step{
+			{file, fsnotify.Rename, fileExists, fsnotify.Write},
+			{file, fsnotify.Create, fileExists, fsnotify.Write},
+			{file, fsnotify.Chmod, fileExists, fsnotify.Chmod},
+		}},
+		{"JetBrains safe write", []step{
+			{"/ws/a.go___jb_tmp___", fsnotify.Create, fileExists, 0},
+			{"/ws/a.go___jb_tmp___", fsnotify.Write, fileExists, 0},
+			{file, fsnotify.Rename, fileExists, fsnotify.Write},
+			{"/ws/a.go___jb_old___", fsnotify.Create, fileExists, 0},
+			{"/ws/a.go___jb_tmp___", fsnotify.Rename, fileGone, 0},
+			{file, fsnotify.Create, fileExists, fsnotify.Write},
+			{"/ws/a.go___jb_old___", fsnotify.Remove, fileGone, 0},
+		}},
+		{"VS Code on Linux and Windows", []step{
+			{file, fsnotify.Write, fileExists, fsnotify.Write},
+			{file, fsnotify.Write | fsnotify.Chmod, fileExists, fsnotify.Write},
+		}},
+		{"VS Code on macOS", []step{
+			{"/ws/a.go.tmp", fsnotify.Create, fileExists, fsnotify.Create},
+			{file, fsnotify.Remove, fileExists, fsnotify.Write},
+			{"/ws/a.go.tmp", fsnotify.Rename, fileGone, fsnotify.Rename},
+		}},
+		{"Emacs", []step{
+			{"/ws/.#a.go", fsnotify.Create, fileExists, 0},
+			{"/ws/#a.go#", fsnotify.Write, fileExists, 0},
+			{file, fsnotify.Rename, fileExists, fsnotify.Write},
+			{"/ws/a.go~", fsnotify.Create, fileExists, 0},
+			{file, fsnotify.Create | fsnotify.Write, fileExists, fsnotify.Write},
+			{"/ws/.#a.go", fsnotify.Remove, fileGone, 0},
+		}},
+		{"gedit", []step{
+			{"/ws/.goutputstream-K2K6Z0", fsnotify.Create, fileExists, 0},
+			{"/ws/.goutputstream-K2K6Z0", fsnotify.Rename, fileGone, 0},
+			{file, fsnotify.Create, fileExists, fsnotify.Write},
+		}},
+		{"git checkout of a changed file", []step{
+			{file, fsnotify.Remove, fileExists, fsnotify.Write},
+			{file, fsnotify.Create, fileExists, fsnotify.Write},
+			{file, fsnotify.Write, fileExists, fsnotify.Write},
+		}},
+		{"git checkout of a branch without the file", []step{
+			{file, fsnotify.Remove, fileGone, fsnotify.Remove},
+		}},
+		{"new file", []step{
+			{"/ws/b.go", fsnotify.Create, fileExists, fsnotify.Create},
+			{"/ws/b.go", fsnotify.Create | fsnotify.Write, fileExists, fsnotify.Create},
+		}},
+		{"moved directory", []step{
+			{"/ws/pkg", fsnotify.Rename, fileGone, fsnotify.Rename},
+			{"/ws/lib", fsnotify.Create, dirExists, fsnotify.Create},
+			{"/ws/lib", fsnotify.Remove | fsnotify.Rename, dirExists, fsnotify.Remove},
+		}},
+	} {
+		for n, s := range c.steps {
+			got := normalizeEvent(fsnotify.Event{Name: s.name, Op: s.op}, s.name == file, s.state)
+			want := fsnotify.Event{Name: s.name, Op: s.want}
+			if got != want {
+				t.Errorf("%s, step %d: normalizeEvent(%s %s) = %s, want %s", c.name, n+1, s.op, s.name, got.Op, want.Op)
+			}
+		}
+	}
+}
+
+func TestIBazelNormalizedEvent(t *testing.T) {
+	oldStatFile := statFile
+	defer func() { statFile = oldStatFile }()
+	stats := 0
+	statFile = func(string) fileState {
+		stats++
+		return fileExists
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	w := &fakeFSNotifyWatcher{}
+	i.setWatched(w, map[string]struct{}{"/ws/a.go": struct{}{}})
+
+	got := i.watchedEvent(w, fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Create})
+	assertEqual(t, fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Write}, got, "Recreated file")
+	assertEqual(t, 1, stats, "Stats")
+
+	got = i.watchedEvent(w, fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Write})
+	assertEqual(t, fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Write}, got, "Written file")
+	assertEqual(t, 1, stats, "Writes shouldn't stat the file")
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/experiments"
+)
+
+// printExperiments lists the experiments for `ibazel experiments`, and
+// whether they are enabled.
+func printExperiments(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range experiments.All() {
+		state := "off"
+		if e.Enabled() {
+			state = "on"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Name, state, e.Description)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nEnable them with --experimental=name,name2 or $%s.\n", experiments.EnvVar)
+}
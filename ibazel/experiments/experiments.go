@@ -0,0 +1,128 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package experiments is a registry of features that aren't on by default
+// yet. Big new subsystems register an experiment and check it, so that they
+// can ship dark and be turned on with --experimental=name,name2 or the
+// IBAZEL_EXPERIMENTAL environment variable, e.g. per workspace through
+// direnv, instead of each adding a flag of its own.
+package experiments
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EnvVar holds experiments to enable in addition to --experimental.
+const EnvVar = "IBAZEL_EXPERIMENTAL"
+
+// Experiment is a feature that is off unless it is enabled.
+type Experiment struct {
+	Name        string
+	Description string
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]*Experiment{}
+	enabled  = map[string]bool{}
+)
+
+func init() {
+	flag.Var(&experimentalFlag{}, "experimental", "Comma separated experiments to enable, see `ibazel experiments`. Can be repeated")
+}
+
+// Register adds an experiment to the registry. It is meant to be called when
+// a package is initialized, and panics if the name is taken.
+func Register(name, description string) *Experiment {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("experiment %q is registered twice", name))
+	}
+	e := &Experiment{Name: name, Description: description}
+	registry[name] = e
+	return e
+}
+
+// Enabled reports whether the experiment is on.
+func (e *Experiment) Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled[e.Name]
+}
+
+// Enable turns on the experiments in the comma separated list. Unknown
+// experiments are an error, and none of the list is enabled then.
+func Enable(list string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := registry[name]; !ok {
+			return fmt.Errorf("unknown experiment %q, see `ibazel experiments`", name)
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		enabled[name] = true
+	}
+	return nil
+}
+
+// LoadEnv enables the experiments of the IBAZEL_EXPERIMENTAL environment
+// variable.
+func LoadEnv() error {
+	if err := Enable(os.Getenv(EnvVar)); err != nil {
+		return fmt.Errorf("$%s: %v", EnvVar, err)
+	}
+	return nil
+}
+
+// All returns the registered experiments, sorted by name.
+func All() []*Experiment {
+	mu.Lock()
+	defer mu.Unlock()
+	all := make([]*Experiment, 0, len(registry))
+	for _, e := range registry {
+		all = append(all, e)
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].Name < all[b].Name })
+	return all
+}
+
+// experimentalFlag is --experimental, which can be repeated.
+type experimentalFlag struct{}
+
+func (*experimentalFlag) String() string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (*experimentalFlag) Set(value string) error {
+	return Enable(value)
+}
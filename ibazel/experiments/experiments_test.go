@@ -0,0 +1,72 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package experiments
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+func reset() {
+	registry = map[string]*Experiment{}
+	enabled = map[string]bool{}
+}
+
+func TestEnable(t *testing.T) {
+	defer reset()
+	a := Register("a", "The first")
+	b := Register("b", "The second")
+	c := Register("c", "The third")
+
+	if err := Enable("a,nope"); err == nil {
+		t.Error("Enabling an unknown experiment should fail")
+	}
+	if a.Enabled() {
+		t.Error("a was enabled along with an unknown experiment")
+	}
+
+	if err := flag.Set("experimental", "a, b"); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Enabled() || !b.Enabled() || c.Enabled() {
+		t.Errorf("Wanted only a and b enabled, got %q", flag.Lookup("experimental").Value)
+	}
+
+	os.Setenv(EnvVar, "c")
+	defer os.Unsetenv(EnvVar)
+	if err := LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Enabled() {
+		t.Errorf("c wasn't enabled by $%s", EnvVar)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer reset()
+	Register("b", "")
+	Register("a", "")
+	if all := All(); len(all) != 2 || all[0].Name != "a" || all[1].Name != "b" {
+		t.Errorf("All() = %v, wanted a and b", all)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Registering a name twice should panic")
+		}
+	}()
+	Register("a", "")
+}
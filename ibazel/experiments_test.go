@@ -0,0 +1,36 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintExperiments(t *testing.T) {
+	var out bytes.Buffer
+	printExperiments(&out)
+
+	for _, want := range []string{
+		"build_events  off  Read the Build Event Protocol stream",
+		"watchman      off  Subscribe to Watchman",
+		"$IBAZEL_EXPERIMENTAL",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Wanted %q in:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/test_output"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var explain = flag.Bool("explain", false, "Print which files would be watched, which integrations would be enabled and what would be run on a change, then exit")
+
+// targetTags are the tags that change how iBazel treats a target.
+var targetTags = []struct {
+	tag         string
+	description string
+}{
+	{"ibazel_live_reload", "live reload: browsers are reloaded after every build"},
+	{"ibazel_notify_changes", "notify changes: the target is told about builds on stdin instead of being restarted"},
+	{"ibazel_sd_notify", "sd_notify: iBazel waits for READY=1 on $NOTIFY_SOCKET after (re)starting the target"},
+	{"ibazel_restage_data", "restage data: changes to data-only files don't restart the target"},
+	{"ibazel_hot_reload", "hot reload: recompiled classes are swapped into the running JVM"},
+}
+
+// integrationFlags are the flags that enable lifecycle integrations for every
+// target.
+var integrationFlags = []struct {
+	flag        string
+	description string
+}{
+	{"profile_dev", "profiler"},
+	{"junit_output_dir", "JUnit reports"},
+	{"machine_output", "machine readable output"},
+	{"ci_annotations", "CI annotations"},
+	{"cache_stats", "cache statistics"},
+	{"command", "shell command after every build"},
+	{"compile_commands", "compile_commands.json regeneration"},
+	{"prebuild", "warm-up build on startup"},
+	{"build_events", "Build Event Protocol results"},
+	{"report_artifact_sizes", "output file sizes"},
+}
+
+// Explain prints what iBazel would do for `ibazel <command> <targets>`
+// without doing it, to debug why a change doesn't trigger a rebuild.
+func (i *IBazel) Explain(command string, targets []string, args []string) error {
+	return i.explain(os.Stdout, command, targets, args)
+}
+
+func (i *IBazel) explain(w io.Writer, command string, targets []string, args []string) error {
+	verbs := []string{command}
+	var groups []targetGroup
+	if isComposite(command) {
+		var err error
+		if verbs, err = compositeVerbs(command, targets); err != nil {
+			return err
+		}
+	} else if command == "group" {
+		var err error
+		if groups, err = parseGroups(targets); err != nil {
+			return err
+		}
+		targets = groupTargets(groups)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("%s needs at least one target", command)
+	}
+
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return err
+	}
+	i.setTargetRepos(targets)
+
+	fmt.Fprintf(w, "Workspace: %s\n", workspacePath)
+	if i.watchBackendReason != "" {
+		fmt.Fprintf(w, "Watch backend: %s, because %s\n", i.watchBackend, i.watchBackendReason)
+	} else {
+		fmt.Fprintf(w, "Watch backend: %s\n", i.watchBackend)
+	}
+	// The files of every target, and the directories watched for all of them.
+	dirs := map[string]struct{}{}
+	for _, target := range targets {
+		for _, q := range []struct {
+			title string
+			query string
+		}{
+			{"BUILD files of %s (changes requery the build graph)", buildQuery},
+			{"Source files of %s (changes rerun the command)", sourceQuery},
+		} {
+			query := fmt.Sprintf(q.query, target)
+			files, err := i.queryFileSet(query)
+			if err != nil {
+				return fmt.Errorf("bazel query %q failed: %v", query, err)
+			}
+			i.explainFiles(w, fmt.Sprintf(q.title, target), workspacePath, files, dirs)
+		}
+	}
+	watchedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		watchedDirs = append(watchedDirs, dir)
+	}
+	sort.Strings(watchedDirs)
+	fmt.Fprintf(w, "\nWatched directories: %d\n", len(watchedDirs))
+	for _, dir := range watchedDirs {
+		fmt.Fprintf(w, "  %s\n", dir)
+	}
+
+	fmt.Fprintf(w, "\nIntegrations:\n")
+	for _, f := range integrationFlags {
+		if fl := flag.Lookup(f.flag); fl != nil {
+			if value := fl.Value.String(); value != "" && value != "false" && value != "off" {
+				fmt.Fprintf(w, "  %s (--%s=%s)\n", f.description, f.flag, value)
+			}
+		}
+	}
+	for _, target := range targets {
+		tags, err := i.targetTags(target)
+		if err != nil {
+			fmt.Fprintf(w, "  %s: can't query its tags: %v\n", target, err)
+			continue
+		}
+		for _, t := range targetTags {
+			if contains(tags, t.tag) {
+				fmt.Fprintf(w, "  %s: %s\n", target, t.description)
+			}
+		}
+		if u := notifyURLTag(tags); u != "" {
+			fmt.Fprintf(w, "  %s: notify changes over HTTP: builds are posted to %s instead of restarting the target\n", target, u)
+		}
+		if u := healthCheckTagURL(tags); u != "" {
+			fmt.Fprintf(w, "  %s: health check: %s is probed while waiting for changes, with --health_check_interval\n", target, u)
+		}
+		if specs := listenTags(tags); len(specs) > 0 {
+			fmt.Fprintf(w, "  %s: socket activation: %s held by iBazel and passed to every start of the target\n", target, strings.Join(specs, ", "))
+		}
+		if spec := blueGreenTag(tags); spec != "" {
+			fmt.Fprintf(w, "  %s: blue/green restarts: a proxy on %s switches to every new instance once it is ready\n", target, spec)
+		}
+		if spec := runnerSpec(tags); spec != "local" {
+			fmt.Fprintf(w, "  %s: runner: launched with %s\n", target, spec)
+		}
+	}
+
+	fmt.Fprintf(w, "\nOn every change:\n")
+	if groups != nil {
+		for n, g := range groups {
+			fmt.Fprintf(w, "  %d. %s\n", n+1, i.explainCommand(g.verb, g.targets, args))
+		}
+		return nil
+	}
+	for n, v := range verbs {
+		verbTargets := targets
+		if len(verbs) > 1 && verbs[len(verbs)-1] == "run" {
+			if v == "run" {
+				verbTargets = targets[len(targets)-1:]
+			} else {
+				verbTargets = targets[:len(targets)-1]
+			}
+		}
+		fmt.Fprintf(w, "  %d. %s\n", n+1, i.explainCommand(v, verbTargets, args))
+	}
+	return nil
+}
+
+// explainFiles prints the files in files and adds the directories that would
+// be watched for them to dirs.
+func (i *IBazel) explainFiles(w io.Writer, title, workspacePath string, files map[string]struct{}, dirs map[string]struct{}) {
+	var watched, excluded, missing []string
+	for file := range files {
+		if i.isBuildOutput(workspacePath, file) {
+			excluded = append(excluded, file)
+			continue
+		}
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			missing = append(missing, file)
+		}
+		watched = append(watched, file)
+		dirs[filepath.Dir(file)] = struct{}{}
+	}
+	sort.Strings(watched)
+	sort.Strings(excluded)
+
+	fmt.Fprintf(w, "\n%s: %d files\n", title, len(watched))
+	for _, file := range watched {
+		if contains(missing, file) {
+			fmt.Fprintf(w, "  %s (doesn't exist)\n", file)
+		} else {
+			fmt.Fprintf(w, "  %s\n", file)
+		}
+	}
+	for _, file := range excluded {
+		fmt.Fprintf(w, "  %s (not watched, it is a build output)\n", file)
+	}
+}
+
+// targetTags returns the tags of target.
+func (i *IBazel) targetTags(target string) ([]string, error) {
+	res, err := i.newBazel("query").Query(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range res.Target {
+		if *t.Type != blaze_query.Target_RULE {
+			continue
+		}
+		for _, attr := range t.Rule.Attribute {
+			if *attr.Name == "tags" && *attr.Type == blaze_query.Attribute_STRING_LIST {
+				return attr.StringListValue, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// explainCommand describes what iBazel runs for verb.
+func (i *IBazel) explainCommand(verb string, targets []string, args []string) string {
+	bazelVerb := verb
+	if verb == "mrun" {
+		bazelVerb = "build"
+	}
+	parts := append([]string{"bazel"}, i.startupArgs...)
+	parts = append(parts, bazelVerb)
+	parts = append(parts, i.bazelArgsFor(bazelVerb)...)
+	switch verb {
+	case "test":
+		parts = append(parts, test_output.BazelArgs()...)
+		parts = append(parts, i.getTestArgs()...)
+	case "run":
+		parts = append(parts, "--script_path=<temporary file>")
+	}
+	parts = append(parts, targets...)
+	description := strings.Join(parts, " ")
+
+	switch verb {
+	case "build":
+		if *shellCommand != "" {
+			description += fmt.Sprintf(", then restart `%s`", *shellCommand)
+		}
+	case "run", "mrun":
+		description += ", then restart " + strings.Join(targets, " ")
+		if len(args) > 0 {
+			description += " with " + strings.Join(args, " ")
+		}
+	}
+	return description
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+func sourceFileResult(labels ...string) *blaze_query.QueryResult {
+	res := &blaze_query.QueryResult{}
+	for _, label := range labels {
+		res.Target = append(res.Target, &blaze_query.Target{
+			Type:       blaze_query.Target_SOURCE_FILE.Enum(),
+			SourceFile: &blaze_query.SourceFile{Name: proto.String(label)},
+		})
+	}
+	return res
+}
+
+func TestIBazelExplain(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_explain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	if err := os.MkdirAll(filepath.Join(workspace, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"app/BUILD", "app/main.go"} {
+		if err := ioutil.WriteFile(filepath.Join(workspace, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryResponse(fmt.Sprintf(buildQuery, "//app:server"), sourceFileResult("//app:BUILD"))
+		b.AddQueryResponse(fmt.Sprintf(sourceQuery, "//app:server"), sourceFileResult("//app:main.go", "//app:gone.go"))
+		b.AddQueryResponse("//app:server", &blaze_query.QueryResult{
+			Target: []*blaze_query.Target{{
+				Type: blaze_query.Target_RULE.Enum(),
+				Rule: &blaze_query.Rule{
+					Name: proto.String("//app:server"),
+					Attribute: []*blaze_query.Attribute{{
+						Name:            proto.String("tags"),
+						Type:            blaze_query.Attribute_STRING_LIST.Enum(),
+						StringListValue: []string{"ibazel_notify_changes", "ibazel_blue_green=:8080/healthz"},
+					}},
+				},
+			}},
+		})
+		return b
+	}
+
+	i := &IBazel{
+		workspaceFinder: dirWorkspaceFinder(workspace),
+		outputDirs:      []string{},
+		bazelArgs:       []string{"--config=dev"},
+	}
+	out := &bytes.Buffer{}
+	if err := i.explain(out, "run", []string{"//app:server"}, []string{"--port=8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"BUILD files of //app:server (changes requery the build graph): 1 files\n  " + filepath.Join(workspace, "app", "BUILD") + "\n",
+		"Source files of //app:server (changes rerun the command): 2 files\n",
+		"Watched directories: 1\n  " + filepath.Join(workspace, "app") + "\n",
+		"  " + filepath.Join(workspace, "app", "gone.go") + " (doesn't exist)\n",
+		"  " + filepath.Join(workspace, "app", "main.go") + "\n",
+		"  //app:server: notify changes: ",
+		"  //app:server: blue/green restarts: a proxy on :8080/healthz switches to every new instance once it is ready\n",
+		"  1. bazel run --config=dev --script_path=<temporary file> //app:server, then restart //app:server with --port=8080\n",
+	} {
+		if !bytes.Contains(out.Bytes(), []byte(expected)) {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file_index keeps track of which package every watched file belongs
+// to and which targets depend on it, so that a change can be attributed to the
+// targets it affects before they are rebuilt.
+package file_index
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+var persist = flag.Bool("persist_file_index", false, "Keep the index of which targets depend on which files in the user's cache directory, so that changes are attributed to their targets before the first query is done")
+
+// Index is updated after every query. It isn't safe for concurrent use.
+type Index struct {
+	// The package of every file, e.g. "//foo" or "@repo//foo".
+	Packages map[string]string `json:"packages"`
+	// The files a query of a kind returned for a target, by kind and target.
+	Files map[string]map[string][]string `json:"files"`
+
+	// The targets of every file, derived from Files, with the number of kinds
+	// whose query returned the file for the target.
+	targets map[string]map[string]int
+}
+
+func New() *Index {
+	x := &Index{
+		Packages: map[string]string{},
+		Files:    map[string]map[string][]string{},
+	}
+	x.reindex()
+	return x
+}
+
+// SetPackage records the package of file. Like Update and Describe, it does
+// nothing on a nil Index.
+func (x *Index) SetPackage(file, pkg string) {
+	if x == nil {
+		return
+	}
+	x.Packages[file] = pkg
+}
+
+// Update replaces the files that a query of kind (e.g. "source file") returned
+// for each target in files. The files of other targets are left alone, so
+// that a requery of some of the targets only updates their part of the index.
+func (x *Index) Update(kind string, files map[string][]string) {
+	if x == nil {
+		return
+	}
+	if x.Files[kind] == nil {
+		x.Files[kind] = map[string][]string{}
+	}
+	for target, targetFiles := range files {
+		sorted := append([]string(nil), targetFiles...)
+		sort.Strings(sorted)
+		// Link the new files first so that the files the target still
+		// depends on keep their package.
+		x.link(target, sorted)
+		x.unlink(target, x.Files[kind][target])
+		x.Files[kind][target] = sorted
+	}
+}
+
+// reindex derives the targets of every file from Files.
+func (x *Index) reindex() {
+	x.targets = map[string]map[string]int{}
+	for _, byTarget := range x.Files {
+		for target, files := range byTarget {
+			x.link(target, files)
+		}
+	}
+	for file := range x.Packages {
+		if _, ok := x.targets[file]; !ok {
+			delete(x.Packages, file)
+		}
+	}
+}
+
+func (x *Index) link(target string, files []string) {
+	for _, file := range files {
+		if x.targets[file] == nil {
+			x.targets[file] = map[string]int{}
+		}
+		x.targets[file][target]++
+	}
+}
+
+// unlink undoes link, and forgets the package of the files that no target
+// depends on anymore.
+func (x *Index) unlink(target string, files []string) {
+	for _, file := range files {
+		byTarget := x.targets[file]
+		if byTarget[target]--; byTarget[target] > 0 {
+			continue
+		}
+		delete(byTarget, target)
+		if len(byTarget) == 0 {
+			delete(x.targets, file)
+			delete(x.Packages, file)
+		}
+	}
+}
+
+// Package returns the package of file, or "" if it isn't known.
+func (x *Index) Package(file string) string {
+	return x.Packages[file]
+}
+
+// Targets returns the targets that depend on file, sorted.
+func (x *Index) Targets(file string) []string {
+	targets := make([]string, 0, len(x.targets[file]))
+	for target := range x.targets[file] {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Describe summarizes what is known about file for a log line, e.g.
+// "in //foo, affects //foo:bar and //baz:qux".
+func (x *Index) Describe(file string) string {
+	if x == nil {
+		return ""
+	}
+	var parts []string
+	if pkg := x.Package(file); pkg != "" {
+		parts = append(parts, "in "+pkg)
+	}
+	targets := x.Targets(file)
+	switch {
+	case len(targets) == 0:
+	case len(targets) <= 3:
+		parts = append(parts, "affects "+joinAnd(targets))
+	default:
+		parts = append(parts, fmt.Sprintf("affects %s and %d more", strings.Join(targets[:2], ", "), len(targets)-2))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func joinAnd(s []string) string {
+	if len(s) == 1 {
+		return s[0]
+	}
+	return strings.Join(s[:len(s)-1], ", ") + " and " + s[len(s)-1]
+}
+
+// Persisted reports whether --persist_file_index was given.
+func Persisted() bool {
+	return *persist
+}
+
+// Open returns the index of workspace persisted with --persist_file_index, or
+// a new one.
+func Open(workspace string) *Index {
+	if *persist {
+		if x, err := Load(PathFor(workspace)); err == nil {
+			return x
+		}
+	}
+	return New()
+}
+
+// Persist saves the index of workspace with --persist_file_index.
+func (x *Index) Persist(workspace string) error {
+	if !*persist || x == nil {
+		return nil
+	}
+	return x.Save(PathFor(workspace))
+}
+
+// PathFor returns where the index of workspace is persisted.
+func PathFor(workspace string) string {
+	sum := sha1.Sum([]byte(workspace))
+	return filepath.Join(temp_dir.CacheDir(), "file_index", hex.EncodeToString(sum[:8])+".json")
+}
+
+// Save writes the index to path.
+func (x *Index) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that readers never see half of it.
+	if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Load reads an index written by Save.
+func Load(path string) (*Index, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	x := New()
+	if err := json.Unmarshal(b, x); err != nil {
+		return nil, err
+	}
+	if x.Packages == nil {
+		x.Packages = map[string]string{}
+	}
+	if x.Files == nil {
+		x.Files = map[string]map[string][]string{}
+	}
+	x.reindex()
+	return x, nil
+}
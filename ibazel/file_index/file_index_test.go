@@ -0,0 +1,110 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file_index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	x := New()
+	x.SetPackage("/ws/lib/lib.go", "//lib")
+	x.SetPackage("/ws/app/main.go", "//app")
+	x.Update("source file", map[string][]string{
+		"//app:server": {"/ws/lib/lib.go", "/ws/app/main.go"},
+		"//app:test":   {"/ws/lib/lib.go", "/ws/app/main_test.go"},
+	})
+	x.Update("BUILD file", map[string][]string{
+		"//app:server": {"/ws/app/BUILD"},
+		"//app:test":   {"/ws/app/BUILD"},
+	})
+
+	assertTargets := func(file string, expected []string) {
+		t.Helper()
+		if got := x.Targets(file); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Targets(%q) = %v, want %v", file, got, expected)
+		}
+	}
+	assertTargets("/ws/lib/lib.go", []string{"//app:server", "//app:test"})
+	assertTargets("/ws/app/BUILD", []string{"//app:server", "//app:test"})
+	assertTargets("/ws/app/main.go", []string{"//app:server"})
+	assertTargets("/ws/app/main_test.go", []string{"//app:test"})
+
+	// A requery of one target only changes its part of the index.
+	x.Update("source file", map[string][]string{"//app:server": {"/ws/app/main.go"}})
+	assertTargets("/ws/lib/lib.go", []string{"//app:test"})
+	assertTargets("/ws/app/main.go", []string{"//app:server"})
+	assertTargets("/ws/app/BUILD", []string{"//app:server", "//app:test"})
+
+	x.Update("source file", map[string][]string{"//app:test": {"/ws/app/main.go"}})
+	assertTargets("/ws/lib/lib.go", []string{})
+	if pkg := x.Package("/ws/lib/lib.go"); pkg != "" {
+		t.Errorf("The package of a file no target depends on anymore is still known: %s", pkg)
+	}
+	if pkg := x.Package("/ws/app/main.go"); pkg != "//app" {
+		t.Errorf("Package = %q, want //app", pkg)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	x := New()
+	x.SetPackage("/ws/a.go", "//a")
+	x.Update("source file", map[string][]string{
+		"//a:one": {"/ws/a.go"},
+		"//a:two": {"/ws/a.go"},
+		"//b:1":   {"/ws/b.go"},
+		"//b:2":   {"/ws/b.go"},
+		"//b:3":   {"/ws/b.go"},
+		"//b:4":   {"/ws/b.go"},
+	})
+
+	for file, expected := range map[string]string{
+		"/ws/a.go": "in //a, affects //a:one and //a:two",
+		"/ws/b.go": "affects //b:1, //b:2 and 2 more",
+		"/ws/c.go": "",
+	} {
+		if got := x.Describe(file); got != expected {
+			t.Errorf("Describe(%q) = %q, want %q", file, got, expected)
+		}
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_file_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index.json")
+
+	x := New()
+	x.SetPackage("/ws/a.go", "//a")
+	x.Update("source file", map[string][]string{"//a:one": {"/ws/a.go"}})
+	if err := x.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Describe("/ws/a.go"); got != "in //a, affects //a:one" {
+		t.Errorf("Describe after Load = %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var waitForFirstSuccess = flag.Bool("wait_for_first_success", false, "Don't tell the listeners with external effects, like live reload, about commands until the first one succeeds, so that fixing a broken build doesn't reload the browser every time")
+
+// firstSuccessGate holds back the ExternalListeners until the first command
+// of the session succeeds.
+type firstSuccessGate struct {
+	succeeded bool
+	logged    bool
+}
+
+// commandFinished records the result of a command, before the listeners are
+// told about it.
+func (g *firstSuccessGate) commandFinished(success bool) {
+	if success && !g.succeeded && g.logged {
+		log.Log("The build succeeded, no longer holding back reloads")
+	}
+	g.succeeded = g.succeeded || success
+}
+
+// holdsBack returns whether l isn't told about the command that just
+// finished.
+func (g *firstSuccessGate) holdsBack(l Lifecycle) bool {
+	if !*waitForFirstSuccess || g.succeeded {
+		return false
+	}
+	if el, ok := l.(ExternalListener); !ok || !el.HasExternalEffects() {
+		return false
+	}
+	if !g.logged {
+		log.Log("Holding back reloads until the first successful build")
+		g.logged = true
+	}
+	return true
+}
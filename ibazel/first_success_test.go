@@ -0,0 +1,60 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+// reloadRecorder is a listener with external effects, like live reload.
+type reloadRecorder struct {
+	phaseRecorder
+}
+
+func (r *reloadRecorder) HasExternalEffects() bool { return true }
+
+func TestIBazelWaitForFirstSuccess(t *testing.T) {
+	defer setFlag(t, "wait_for_first_success", "true")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	var phases, reloads []string
+	i.lifecycleListeners = []Lifecycle{
+		&phaseRecorder{&phases},
+		&reloadRecorder{phaseRecorder{&reloads}},
+	}
+
+	targets := []string{"//path/to:target"}
+	i.afterCommand(targets, "build", false, nil)
+	i.afterCommand(targets, "build", false, nil)
+	assertEqual(t, 2, len(phases), "Commands the other listeners were told about")
+	assertEqual(t, 0, len(reloads), "Commands before the first success")
+
+	i.afterCommand(targets, "build", true, nil)
+	i.afterCommand(targets, "build", false, nil)
+	assertEqual(t, []string{"after build //path/to:target", "after build //path/to:target"}, reloads, "Commands from the first success on")
+}
+
+func TestIBazelWaitForFirstSuccess_disabled(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	var reloads []string
+	i.lifecycleListeners = []Lifecycle{&reloadRecorder{phaseRecorder{&reloads}}}
+
+	i.afterCommand([]string{"//path/to:target"}, "build", false, nil)
+	assertEqual(t, 1, len(reloads), "Commands without --wait_for_first_success")
+}
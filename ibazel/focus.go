@@ -0,0 +1,96 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var followEditor = flag.Bool("follow_editor", false, "Switch the targets of `ibazel build` or `ibazel test` to the package of the file the editor has open whenever the editor reports a new one")
+
+// FocusFile tells iBazel which file the developer is editing. With
+// --follow_editor the targets are replaced by all targets of the file's
+// package, so the build or test follows the editor around the repository.
+// Like AddTarget, it is safe to call from any goroutine. Editors report every
+// file they switch to, so only the latest one is kept while the main loop is
+// busy.
+func (i *IBazel) FocusFile(path string) error {
+	if !*followEditor {
+		return errors.New("iBazel isn't following the editor, start it with --follow_editor")
+	}
+	i.focusLock.Lock()
+	queued := i.focus != ""
+	i.focus = path
+	i.focusLock.Unlock()
+	if queued {
+		// The edit that is already waiting for the main loop takes the new
+		// file instead.
+		return nil
+	}
+	err := i.editTargets(targetEdit{focus: true})
+	if err != nil {
+		i.takeFocus()
+	}
+	return err
+}
+
+// takeFocus returns the latest file given to FocusFile and forgets it.
+func (i *IBazel) takeFocus() string {
+	i.focusLock.Lock()
+	defer i.focusLock.Unlock()
+	path := i.focus
+	i.focus = ""
+	return path
+}
+
+// focusedPackage returns the pattern of all targets in the package of path.
+func (i *IBazel) focusedPackage(path string) (string, error) {
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspacePath, path)
+	}
+	if i.isBuildOutput(workspacePath, path) {
+		return "", fmt.Errorf("%s is a build output", path)
+	}
+	pkg, err := owningPackage(workspacePath, path)
+	if err != nil {
+		return "", err
+	}
+	return "//" + pkg + ":all", nil
+}
+
+// owningPackage returns the package of the closest BUILD file above path.
+func owningPackage(workspacePath, path string) (string, error) {
+	rel, err := filepath.Rel(workspacePath, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s isn't in the workspace %s", path, workspacePath)
+	}
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		for _, build := range []string{"BUILD.bazel", "BUILD"} {
+			if info, err := os.Stat(filepath.Join(workspacePath, dir, build)); err == nil && !info.IsDir() {
+				return filepath.ToSlash(dir), nil
+			}
+		}
+	}
+	return "", nil
+}
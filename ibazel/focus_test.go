@@ -0,0 +1,113 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOwningPackage(t *testing.T) {
+	ws, err := ioutil.TempDir("", "ibazel_focus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ws)
+	for _, f := range []string{"BUILD.bazel", "a/BUILD", "a/b/c/file.go", "d/BUILD.bazel/README"} {
+		path := filepath.Join(ws, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		path string
+		pkg  string
+	}{
+		{"README.md", ""},
+		{"a/file.go", "a"},
+		{"a/b/c/file.go", "a"},
+		// A directory named BUILD.bazel isn't a package.
+		{"d/BUILD.bazel/README", ""},
+	} {
+		pkg, err := owningPackage(ws, filepath.Join(ws, c.path))
+		if err != nil {
+			t.Errorf("owningPackage(%q): %v", c.path, err)
+		}
+		assertEqual(t, c.pkg, pkg, c.path)
+	}
+
+	if _, err := owningPackage(ws, filepath.Join(filepath.Dir(ws), "elsewhere.go")); err == nil {
+		t.Errorf("Expected an error for a file outside of the workspace")
+	}
+}
+
+func TestFocusFile(t *testing.T) {
+	ws, err := ioutil.TempDir("", "ibazel_focus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ws)
+	for _, pkg := range []string{"a", "d"} {
+		if err := os.MkdirAll(filepath.Join(ws, pkg), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(ws, pkg, "BUILD"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := *followEditor
+	defer func() { *followEditor = old }()
+	*followEditor = true
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(ws)
+	i.outputDirs = []string{}
+	i.targets = []string{"//b:all", "//c:lib"}
+	i.state = WAIT
+
+	if err := i.FocusFile("a/file.go"); err != nil {
+		t.Errorf("FocusFile: %v", err)
+	}
+	i.iteration("test", i.test, i.targets, "//b:all //c:lib")
+	assertEqual(t, []string{"//a:all"}, i.targets, "Targets")
+	assertEqual(t, QUERY, i.state, "State")
+
+	// Another file of the same package doesn't requery.
+	i.state = WAIT
+	if err := i.FocusFile(filepath.Join(ws, "a", "other.go")); err != nil {
+		t.Errorf("FocusFile: %v", err)
+	}
+	i.iteration("test", i.test, i.targets, "//a:all")
+	assertEqual(t, WAIT, i.state, "State")
+
+	// Only the latest of the files reported while the main loop is busy
+	// counts.
+	for _, file := range []string{"a/file.go", "d/file.go"} {
+		if err := i.FocusFile(file); err != nil {
+			t.Errorf("FocusFile: %v", err)
+		}
+	}
+	assertEqual(t, 1, len(i.targetEdits), "Queued edits")
+	i.iteration("test", i.test, i.targets, "//a:all")
+	assertEqual(t, []string{"//d:all"}, i.targets, "Targets")
+}
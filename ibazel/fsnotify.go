@@ -1,6 +1,10 @@
 package main
 
 import (
+	"os"
+	"strings"
+	"syscall"
+
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -29,3 +33,14 @@ func (w *realFSNotifyWatcher) Watcher() *fsnotify.Watcher  { return w.w }
 func wrapWatcher(w *fsnotify.Watcher, err error) (fSNotifyWatcher, error) {
 	return &realFSNotifyWatcher{w: w}, err
 }
+
+// alreadyUnwatched returns whether removing the watch of dir failed only
+// because it was gone already: the directory was deleted, which drops its
+// watch, or it was never watched.
+func alreadyUnwatched(dir string, err error) bool {
+	if err == syscall.EINVAL || strings.Contains(err.Error(), "can't remove non-existent") {
+		return true
+	}
+	_, statErr := os.Stat(dir)
+	return os.IsNotExist(statErr)
+}
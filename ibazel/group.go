@@ -0,0 +1,121 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// groupVerbs are the verbs of `ibazel group`, in the order they are run.
+var groupVerbs = []string{"build", "test", "run"}
+
+// A targetGroup is a verb and the targets it is applied to by `ibazel group`.
+type targetGroup struct {
+	verb    string
+	targets []string
+}
+
+// parseGroups parses the arguments of `ibazel group`, e.g.
+// `--build //lib/... --test //lib:tests --run //server:bin`. A verb that is
+// given more than once collects all of its targets. The groups are returned
+// in the order of groupVerbs.
+func parseGroups(args []string) ([]targetGroup, error) {
+	byVerb := map[string]*targetGroup{}
+	var current *targetGroup
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			verb := strings.TrimPrefix(arg, "--")
+			if !contains(groupVerbs, verb) {
+				return nil, fmt.Errorf("unknown group %s, expected one of --%s", arg, strings.Join(groupVerbs, ", --"))
+			}
+			if byVerb[verb] == nil {
+				byVerb[verb] = &targetGroup{verb: verb}
+			}
+			current = byVerb[verb]
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("%s has to follow --build, --test or --run", arg)
+		}
+		current.targets = append(current.targets, arg)
+	}
+
+	var groups []targetGroup
+	for _, verb := range groupVerbs {
+		g := byVerb[verb]
+		if g == nil {
+			continue
+		}
+		if len(g.targets) == 0 {
+			return nil, fmt.Errorf("--%s needs at least one target", verb)
+		}
+		if verb == "run" && len(g.targets) > 1 {
+			return nil, errors.New("--run takes a single target, use `ibazel mrun` to run several")
+		}
+		groups = append(groups, *g)
+	}
+	if len(groups) == 0 {
+		return nil, errors.New("no targets, use --build, --test or --run followed by targets")
+	}
+	return groups, nil
+}
+
+// groupTargets returns the targets of all groups, which share one watch set.
+func groupTargets(groups []targetGroup) []string {
+	var targets []string
+	for _, g := range groups {
+		targets = append(targets, g.targets...)
+	}
+	return targets
+}
+
+// Group builds, tests and runs different targets in one IBazel loop, e.g.
+// `ibazel group --build //lib/... --test //lib:tests --run //server:bin`.
+// Unlike Composite, the groups are independent: every group is run after
+// every change, even when another one failed.
+func (i *IBazel) Group(groups []targetGroup, args []string) error {
+	i.args = args
+	return i.loop("group", i.group(groups), groupTargets(groups))
+}
+
+func (i *IBazel) group(groups []targetGroup) runnableCommand {
+	phases := map[string]runnableCommand{
+		"build": i.build,
+		"test":  i.test,
+		"run":   i.run,
+	}
+
+	return func(targets ...string) (*bytes.Buffer, error) {
+		var outputBuffer *bytes.Buffer
+		var failed error
+		for _, g := range groups {
+			log.Logf("%s %s", strings.Title(verb(g.verb)), strings.Join(g.targets, " "))
+			i.beforeCommand(g.targets, g.verb)
+			var err error
+			outputBuffer, err = phases[g.verb](g.targets...)
+			i.afterCommand(g.targets, g.verb, err == nil, outputBuffer)
+			if err != nil {
+				log.Errorf("%s of %s failed", strings.Title(g.verb), strings.Join(g.targets, " "))
+				failed = err
+			}
+		}
+		return outputBuffer, failed
+	}
+}
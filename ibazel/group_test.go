@@ -0,0 +1,94 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+func TestParseGroups(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		groups []targetGroup
+	}{
+		{
+			[]string{"--run", "//server:bin", "--build", "//lib/...", "--test", "//lib:tests", "--build", "//tools/..."},
+			[]targetGroup{
+				{"build", []string{"//lib/...", "//tools/..."}},
+				{"test", []string{"//lib:tests"}},
+				{"run", []string{"//server:bin"}},
+			},
+		},
+		{[]string{"--test", "//a:test", "//b:test"}, []targetGroup{{"test", []string{"//a:test", "//b:test"}}}},
+		{[]string{"//a:bin"}, nil},
+		{[]string{"--build"}, nil},
+		{[]string{"--run", "//a:bin", "//b:bin"}, nil},
+		{[]string{"--mrun", "//a:bin"}, nil},
+		{[]string{}, nil},
+	} {
+		groups, err := parseGroups(c.args)
+		if c.groups == nil {
+			if err == nil {
+				t.Errorf("parseGroups(%v) should have failed", c.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGroups(%v): %v", c.args, err)
+		}
+		assertEqual(t, c.groups, groups, "Groups")
+	}
+}
+
+func TestIBazelGroup(t *testing.T) {
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.BuildError(errors.New("build failed"))
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	cmd := &mockCommand{}
+	i.cmd = cmd
+
+	var phases []string
+	i.lifecycleListeners = append(i.lifecycleListeners, &phaseRecorder{&phases})
+
+	groups := []targetGroup{
+		{"build", []string{"//lib/..."}},
+		{"test", []string{"//lib:tests"}},
+		{"run", []string{"//server:bin"}},
+	}
+	_, err := i.group(groups)(groupTargets(groups)...)
+	if err == nil {
+		t.Errorf("The failed build wasn't reported")
+	}
+	assertEqual(t, []string{
+		"before build //lib/...",
+		"after build //lib/...",
+		"before test //lib:tests",
+		"after test //lib:tests",
+		"before run //server:bin",
+		"after run //server:bin",
+	}, phases, "Lifecycle events")
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var (
+	healthCheckInterval = flag.Duration("health_check_interval", 0, "While waiting for changes, probe the health check of every running target this often, see --health_check_url and the ibazel_health_check=<url> tag. 0 disables")
+	healthCheckURL      = flag.String("health_check_url", "", "The http://, https:// or tcp://host:port health check of the targets of `ibazel run` and `ibazel mrun` that don't have an ibazel_health_check=<url> tag")
+	healthCheckRestart  = flag.Int("health_check_restart", 0, "Restart a target once this many health checks in a row failed. 0 only reports it")
+)
+
+// The tag that sets the health check of a target, e.g.
+// ibazel_health_check=http://localhost:8080/healthz.
+const healthCheckTag = "ibazel_health_check="
+
+// probeHealth returns why the health check at rawURL failed, or nil if it
+// passed.
+var probeHealth = func(rawURL string, timeout time.Duration) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "tcp" {
+		conn, err := net.DialTimeout("tcp", u.Host, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	client := http.Client{Timeout: timeout}
+	res, err := client.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %s", rawURL, res.Status)
+	}
+	return nil
+}
+
+// targetHealth is what the health checks of a target found so far.
+type targetHealth struct {
+	url       string
+	unhealthy bool
+	failures  int
+}
+
+// healthCheckTagURL returns the URL of the ibazel_health_check tag, if any.
+func healthCheckTagURL(tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, healthCheckTag) {
+			return strings.TrimPrefix(tag, healthCheckTag)
+		}
+	}
+	return ""
+}
+
+// registerHealthCheck sets up the health check of a target that is about to
+// run, given its tags.
+func (i *IBazel) registerHealthCheck(target string, tags []string) {
+	if *healthCheckInterval <= 0 {
+		return
+	}
+	u := healthCheckTagURL(tags)
+	if u == "" {
+		u = *healthCheckURL
+	}
+	if u == "" {
+		return
+	}
+	if i.health == nil {
+		i.health = map[string]*targetHealth{}
+	}
+	i.health[target] = &targetHealth{url: u}
+	if i.healthTimer == nil && i.healthResults == nil {
+		i.scheduleHealthChecks()
+	}
+}
+
+// scheduleHealthChecks starts waiting for the next round of health checks.
+func (i *IBazel) scheduleHealthChecks() {
+	if *healthCheckInterval <= 0 || len(i.health) == 0 {
+		i.healthTimer = nil
+		return
+	}
+	i.healthTimer = time.After(*healthCheckInterval)
+}
+
+// healthCheckTimeout is how long a health check may take. The checks of a
+// round run at the same time, so that is also how long the round takes.
+func healthCheckTimeout() time.Duration {
+	if *healthCheckInterval < 5*time.Second {
+		return *healthCheckInterval
+	}
+	return 5 * time.Second
+}
+
+// healthResult is what the health check of a target found.
+type healthResult struct {
+	target string
+	// The command that was probed, to tell whether the target was restarted
+	// in the meantime.
+	cmd command.Command
+	err error
+}
+
+// checkHealth probes the running targets off the main loop, which gets the
+// results from healthResults, see healthChecked.
+func (i *IBazel) checkHealth() {
+	i.healthTimer = nil
+
+	type probe struct {
+		target, url string
+		cmd         command.Command
+	}
+	var probes []probe
+	for target, h := range i.health {
+		cmd := i.targetCommand(target)
+		if cmd == nil || !cmd.IsSubprocessRunning() {
+			continue
+		}
+		probes = append(probes, probe{target, h.url, cmd})
+	}
+	if len(probes) == 0 {
+		i.scheduleHealthChecks()
+		return
+	}
+
+	// Buffered, so that the probes finish even if the main loop stopped
+	// listening, e.g. because iBazel went idle.
+	results := make(chan []healthResult, 1)
+	i.healthResults = results
+	timeout := healthCheckTimeout()
+	go func() {
+		found := make([]healthResult, len(probes))
+		var wg sync.WaitGroup
+		for n, p := range probes {
+			wg.Add(1)
+			go func(n int, p probe) {
+				defer wg.Done()
+				found[n] = healthResult{target: p.target, cmd: p.cmd, err: probeHealth(p.url, timeout)}
+			}(n, p)
+		}
+		wg.Wait()
+		results <- found
+	}()
+}
+
+// healthChecked reports the targets whose health changed and restarts the
+// ones that failed --health_check_restart checks in a row.
+func (i *IBazel) healthChecked(results []healthResult) {
+	i.healthResults = nil
+	defer i.scheduleHealthChecks()
+
+	sort.Slice(results, func(a, b int) bool { return results[a].target < results[b].target })
+	for _, r := range results {
+		h := i.health[r.target]
+		cmd := i.targetCommand(r.target)
+		if h == nil || cmd != r.cmd || !cmd.IsSubprocessRunning() {
+			// Restarted or stopped while it was probed.
+			continue
+		}
+		if r.err == nil {
+			h.failures = 0
+			if h.unhealthy {
+				h.unhealthy = false
+				log.Logf("%s is healthy again", r.target)
+				i.healthChanged(r.target, true, "")
+			}
+			continue
+		}
+
+		h.failures++
+		if !h.unhealthy {
+			h.unhealthy = true
+			log.Errorf("%s is unhealthy: %v", r.target, r.err)
+			i.healthChanged(r.target, false, r.err.Error())
+		}
+		if *healthCheckRestart > 0 && h.failures >= *healthCheckRestart {
+			log.Logf("%s failed %d health checks in a row", r.target, h.failures)
+			h.failures = 0
+			i.startIteration(triggerCrashRestart)
+			i.restartCommand(r.target, cmd, i.logFiles[r.target])
+		}
+	}
+}
+
+// targetCommand returns the command of target in `ibazel run` or `ibazel
+// mrun`, nil if there is none.
+func (i *IBazel) targetCommand(target string) command.Command {
+	if cmd, ok := i.cmds[target]; ok {
+		return cmd
+	}
+	return i.cmd
+}
+
+func (i *IBazel) healthChanged(target string, healthy bool, reason string) {
+	for _, l := range i.lifecycleListeners {
+		if hl, ok := l.(HealthListener); ok {
+			i.callListener(l, "HealthChanged", func() { hl.HealthChanged(target, healthy, reason) })
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+)
+
+// healthRecorder is a listener that also records health changes.
+type healthRecorder struct {
+	phaseRecorder
+}
+
+func (r *healthRecorder) HealthChanged(target string, healthy bool, reason string) {
+	*r.phases = append(*r.phases, fmt.Sprintf("health %s: %t %s", target, healthy, reason))
+}
+
+func TestIBazelCheckHealth(t *testing.T) {
+	defer setFlag(t, "health_check_interval", "1h")()
+	defer setFlag(t, "health_check_restart", "2")()
+	defer setFlag(t, "health_check_url", "http://localhost:8080/healthz")()
+
+	var probes []error
+	oldProbeHealth := probeHealth
+	defer func() { probeHealth = oldProbeHealth }()
+	probeHealth = func(url string, timeout time.Duration) error {
+		if url != "http://localhost:9090/ready" {
+			t.Errorf("Probed %s instead of the URL of the tag", url)
+		}
+		err := probes[0]
+		probes = probes[1:]
+		return err
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&healthRecorder{phaseRecorder{&phases}}}
+
+	server := &mockCommand{}
+	server.Start(nil)
+	i.cmds = map[string]command.Command{"//app:server": server}
+	i.registerHealthCheck("//app:server", []string{"manual", "ibazel_health_check=http://localhost:9090/ready"})
+	if i.healthTimer == nil {
+		t.Fatal("No health check was scheduled")
+	}
+
+	refused := errors.New("connection refused")
+	probes = []error{nil, refused, refused, nil}
+	for range probes {
+		i.checkHealth()
+		if i.healthTimer != nil {
+			t.Errorf("The next health checks were scheduled before the results came in")
+		}
+		i.healthChecked(<-i.healthResults)
+	}
+
+	assertEqual(t, []string{
+		"health //app:server: false connection refused",
+		"health //app:server: true ",
+	}, phases, "Health changes")
+	server.assertTerminated(t)
+}
+
+func TestIBazelRegisterHealthCheck_disabled(t *testing.T) {
+	defer setFlag(t, "health_check_url", "http://localhost:8080/healthz")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.registerHealthCheck("//app:server", nil)
+	assertEqual(t, 0, len(i.health), "Health checks without --health_check_interval")
+}
+
+func TestProbeHealth(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	if err := probeHealth(server.URL, time.Second); err != nil {
+		t.Errorf("Healthy server: %v", err)
+	}
+	if err := probeHealth("tcp://"+server.Listener.Addr().String(), time.Second); err != nil {
+		t.Errorf("TCP health check: %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := probeHealth(server.URL, time.Second); err == nil {
+		t.Errorf("Unhealthy server passed")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/hotswap"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+// setupHotswap prepares applying recompiled classes to target while it runs
+// instead of restarting it. The directory the classes are written to is passed
+// to the target as $IBAZEL_HOTSWAP_DIR, see targetEnv.
+func (i *IBazel) setupHotswap(target string) {
+	// The target is set up again after changes to the build graph.
+	i.cleanupHotswap()
+
+	info, err := i.getInfo()
+	if err != nil {
+		return
+	}
+	l, ok := parseLabel(target)
+	if !ok {
+		log.Errorf("Hot reloading isn't supported for %s", target)
+		return
+	}
+	bin := l.outputPath((*info)["bazel-bin"])
+
+	dir, err := temp_dir.TempDir("hotswap")
+	if err != nil {
+		log.Errorf("Error creating the hot swap directory: %v", err)
+		return
+	}
+	i.hotswap = hotswap.New(dir, hotswap.JarsIn(bin+".jar", bin+".runfiles"))
+	log.Logf("Hot swapping changed classes into %s", dir)
+}
+
+// hotReload rebuilds the run target and applies the changed classes to the
+// running process. It reports false if the process has to be restarted
+// instead, and the error of the build if it failed.
+func (i *IBazel) hotReload(targets ...string) (*bytes.Buffer, bool, error) {
+	if i.graphChanged || !i.cmd.IsSubprocessRunning() {
+		return nil, false, nil
+	}
+
+	outputBuffer, err := i.build(targets...)
+	if err != nil {
+		// Keep the old version running until the code compiles again.
+		return outputBuffer, true, err
+	}
+
+	n, err := i.hotswap.Apply()
+	if err != nil {
+		log.Errorf("Hot swap failed, restarting: %v", err)
+		return outputBuffer, false, nil
+	}
+	log.Logf("Hot swapped %d changed classes", n)
+	return outputBuffer, true, nil
+}
+
+// resetHotswap is called whenever the run target was (re)started.
+func (i *IBazel) resetHotswap() {
+	if i.hotswap == nil {
+		return
+	}
+	if err := i.hotswap.Reset(); err != nil {
+		log.Errorf("Error reading the classes of the run target: %v", err)
+	}
+}
+
+func (i *IBazel) cleanupHotswap() {
+	if i.hotswap != nil {
+		os.RemoveAll(i.hotswap.Dir())
+		i.hotswap = nil
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hotswap applies recompiled classes to a running JVM instead of
+// restarting it. After every build the classes that changed in the target's
+// jars are extracted into a directory that a hot swap agent in the JVM (e.g.
+// HotswapAgent on DCEVM, with extraClasspath and autoHotswap) watches and
+// reloads from.
+package hotswap
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Jars returns the jars that make up the running program.
+type Jars func() ([]string, error)
+
+type Hotswap struct {
+	dir  string
+	jars Jars
+
+	// CRC of every class in the jars, by path inside the jar.
+	crcs map[string]uint32
+}
+
+// New creates a Hotswap that writes changed classes to dir.
+func New(dir string, jars Jars) *Hotswap {
+	return &Hotswap{
+		dir:  dir,
+		jars: jars,
+		crcs: map[string]uint32{},
+	}
+}
+
+// Dir is where changed classes are written.
+func (h *Hotswap) Dir() string {
+	return h.dir
+}
+
+// Reset empties the directory and records the classes a freshly started
+// program was started with.
+func (h *Hotswap) Reset() error {
+	if err := os.RemoveAll(h.dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return err
+	}
+	h.crcs = map[string]uint32{}
+	return h.Snapshot()
+}
+
+// Snapshot records the classes the program was started with.
+func (h *Hotswap) Snapshot() error {
+	_, err := h.walk(func(*zip.File) error { return nil })
+	return err
+}
+
+// Apply extracts every class that was added or changed since the last
+// Snapshot or Apply and returns how many there were.
+func (h *Hotswap) Apply() (int, error) {
+	return h.walk(h.extract)
+}
+
+// walk calls changed for every class whose CRC differs from the recorded one
+// and records the new CRCs.
+func (h *Hotswap) walk(changed func(*zip.File) error) (int, error) {
+	jars, err := h.jars()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, jar := range jars {
+		r, err := zip.OpenReader(jar)
+		if err != nil {
+			return n, err
+		}
+		for _, f := range r.File {
+			if !strings.HasSuffix(f.Name, ".class") {
+				continue
+			}
+			if crc, ok := h.crcs[f.Name]; ok && crc == f.CRC32 {
+				continue
+			}
+			if err := changed(f); err != nil {
+				r.Close()
+				return n, err
+			}
+			h.crcs[f.Name] = f.CRC32
+			n++
+		}
+		r.Close()
+	}
+	return n, nil
+}
+
+func (h *Hotswap) extract(f *zip.File) error {
+	path := filepath.Join(h.dir, filepath.FromSlash(f.Name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Write to a temporary file first so the agent never sees a partial class.
+	out, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// JarsIn finds the jars in the given files and directories. Runfiles trees
+// are made of symlinks, so links are followed.
+func JarsIn(paths ...string) Jars {
+	return func() ([]string, error) {
+		var jars []string
+		for _, root := range paths {
+			err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					if os.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				if !strings.HasSuffix(path, ".jar") {
+					return nil
+				}
+				if info, err = os.Stat(path); err != nil || info.IsDir() {
+					return nil
+				}
+				jars = append(jars, path)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return jars, nil
+	}
+}
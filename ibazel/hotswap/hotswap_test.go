@@ -0,0 +1,105 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hotswap
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeJar(t *testing.T, path string, classes map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range classes {
+		cw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cw.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHotswap(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "hotswap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	runfiles := filepath.Join(tmp, "bin.runfiles")
+	os.MkdirAll(runfiles, 0755)
+	lib := filepath.Join(tmp, "liblib.jar")
+	bin := filepath.Join(tmp, "bin.jar")
+	writeJar(t, bin, map[string]string{
+		"com/example/Main.class": "main v1",
+		"META-INF/MANIFEST.MF":   "Main-Class: com.example.Main",
+	})
+	writeJar(t, lib, map[string]string{"com/example/Lib.class": "lib v1"})
+	if err := os.Symlink(lib, filepath.Join(runfiles, "liblib.jar")); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(tmp, "classes")
+	h := New(dir, JarsIn(bin, runfiles, filepath.Join(tmp, "missing")))
+	if err := h.Snapshot(); err != nil {
+		t.Fatalf("Snapshot(): %v", err)
+	}
+	if n, err := h.Apply(); n != 0 || err != nil {
+		t.Errorf("Apply() without changes = %d, %v, want 0, nil", n, err)
+	}
+
+	writeJar(t, lib, map[string]string{
+		"com/example/Lib.class": "lib v2",
+		"com/example/New.class": "new",
+	})
+	if n, err := h.Apply(); n != 2 || err != nil {
+		t.Fatalf("Apply() = %d, %v, want 2, nil", n, err)
+	}
+	for name, want := range map[string]string{
+		"com/example/Lib.class": "lib v2",
+		"com/example/New.class": "new",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "com", "example", "Main.class")); !os.IsNotExist(err) {
+		t.Errorf("Unchanged Main.class was extracted")
+	}
+
+	// After a restart the program has the latest classes already.
+	if err := h.Reset(); err != nil {
+		t.Fatalf("Reset(): %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Reset() left %d files in %s", len(files), dir)
+	}
+	if n, err := h.Apply(); n != 0 || err != nil {
+		t.Errorf("Apply() after Reset() = %d, %v, want 0, nil", n, err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var maxWatchedFiles = flag.Int("max_watched_files", 50000, "Above this number of source files, e.g. when watching //..., watch the directories containing them instead of each file, and only build or test the targets that depend on the changed files")
+
+// The rules among the targets that depend on the changed files.
+const affectedQuery = "kind(rule, rdeps(set(%s), set(%s)))"
+
+// watchTree watches the directories of the source files when there are too
+// many of them to keep track of every file. Any change to a file in them,
+// other than to a BUILD file, counts, and affectedTargets narrows the targets
+// down to the ones depending on the changed files. The watched directories
+// take the place of the files in filesWatched, so that watcherRemove unwatches
+// them like the directories of files, and of the files in the watch snapshot.
+// The file index still gets every file.
+func (i *IBazel) watchTree(query string, targets []string, watcher fSNotifyWatcher, toWatch []string) {
+	if !i.treeMode {
+		log.Logf("The targets depend on %d source files, more than --max_watched_files=%d. Watching the directories of the files instead, and only rebuilding the targets that depend on the changed files.", len(toWatch), *maxWatchedFiles)
+	}
+	i.treeMode = true
+	i.treeFiles = len(toWatch)
+	i.fileIndex.Update(i.watcherKind(watcher), i.filesByTarget(targets, watcher, toWatch))
+
+	dirs := map[string]struct{}{}
+	uniqueDirectories := map[string][]string{}
+	failed := 0
+	for _, file := range toWatch {
+		dir, _ := filepath.Split(file)
+		if _, ok := uniqueDirectories[dir]; ok {
+			continue
+		}
+		uniqueDirectories[dir] = []string{}
+		if err := watcher.Add(dir); err != nil {
+			i.querySnapshot.WatchFailed(dir, "source directory", query, targets, err)
+			failed++
+			continue
+		}
+		i.querySnapshot.Watch(dir, "source directory", query, targets)
+		dirs[dir] = struct{}{}
+	}
+	if failed > 0 {
+		log.Errorf("Error watching %d of %d directories", failed, len(uniqueDirectories))
+	}
+	i.watcherRemove(uniqueDirectories, watcher, dirs)
+}
+
+// watchingSource returns whether a change to the file may affect the targets.
+func (i *IBazel) watchingSource(name string) bool {
+	if !i.treeMode {
+		_, ok := i.filesWatched[i.sourceFileWatcher][name]
+		return ok && i.passesWatchFilter(name)
+	}
+	dir, _ := filepath.Split(name)
+	_, ok := i.filesWatched[i.sourceFileWatcher][dir]
+	return ok && !isGraphFile(name) && i.passesWatchFilter(name)
+}
+
+// affectedTargets returns the targets to build or test for the changed source
+// files, and false if no target depends on them. Unless only the directories
+// of the files are watched, that's all targets.
+func (i *IBazel) affectedTargets(command string, targets []string) ([]string, bool) {
+	if !i.treeMode || len(i.changes) == 0 || i.graphChanged || (command != "build" && command != "test") {
+		return targets, true
+	}
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return targets, true
+	}
+
+	var labels []string
+	for path := range i.changes {
+		pkg, err := owningPackage(workspacePath, path)
+		if err != nil {
+			return targets, true
+		}
+		rel, err := filepath.Rel(filepath.Join(workspacePath, filepath.FromSlash(pkg)), path)
+		if err != nil {
+			return targets, true
+		}
+		labels = append(labels, fmt.Sprintf("//%s:%s", pkg, filepath.ToSlash(rel)))
+	}
+	sort.Strings(labels)
+
+	b := i.newBazel("query")
+	args, cleanup := queryArgs(fmt.Sprintf(affectedQuery, strings.Join(targets, " "), strings.Join(labels, " ")))
+	defer cleanup()
+	res, err := b.Query(args...)
+	if err != nil {
+		// A new file isn't a target yet, so the query fails.
+		return targets, true
+	}
+	var affected []string
+	for _, t := range res.Target {
+		if t.Rule != nil {
+			affected = append(affected, t.Rule.GetName())
+		}
+	}
+	if len(affected) == 0 {
+		log.Logf("No target depends on %s", strings.Join(labels, ", "))
+		return nil, false
+	}
+	sort.Strings(affected)
+	return affected, true
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+func TestIBazelWatchTree(t *testing.T) {
+	defer setFlag(t, "max_watched_files", "1")()
+
+	workspace, err := ioutil.TempDir("", "ibazel_huge_targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	if err := os.MkdirAll(filepath.Join(workspace, "app", "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workspace, "app", "BUILD"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryResponse(fmt.Sprintf(sourceQuery, "//..."), sourceFileResult("//app:main.go", "//app:lib/util.go"))
+		b.AddQueryResponse(fmt.Sprintf(affectedQuery, "//...", "//app:lib/util.go"), &blaze_query.QueryResult{
+			Target: []*blaze_query.Target{{
+				Type: blaze_query.Target_RULE.Enum(),
+				Rule: &blaze_query.Rule{Name: proto.String("//app:server"), RuleClass: proto.String("go_binary")},
+			}},
+		})
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	i.outputDirs = []string{}
+	i.sourceFileWatcher = &fakeFSNotifyWatcher{}
+
+	i.startSnapshot("build", []string{"//..."})
+	targets := []string{"//..."}
+	if err := i.watchFiles(fmt.Sprintf(sourceQuery, "//..."), targets, i.sourceFileWatcher); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, i.treeMode, "Watching directories")
+	assertEqual(t, 2, i.treeFiles, "Source files")
+	if f := i.querySnapshot.Files[filepath.Join(workspace, "app")+string(filepath.Separator)]; f == nil || f.Kind != "source directory" {
+		t.Errorf("The watched directory isn't in the snapshot: %v", i.querySnapshot.Files)
+	}
+	assertEqual(t, []string{"//..."}, i.fileIndex.Targets(filepath.Join(workspace, "app", "lib", "util.go")), "Targets of a file")
+	assertEqual(t, true, i.watchingSource(filepath.Join(workspace, "app", "new.go")), "A new file in a watched directory")
+	assertEqual(t, false, i.watchingSource(filepath.Join(workspace, "app", "BUILD")), "A BUILD file")
+	assertEqual(t, false, i.watchingSource(filepath.Join(workspace, "docs", "README.md")), "A file in another directory")
+
+	affected, ok := i.affectedTargets("test", targets)
+	assertEqual(t, true, ok, "Affected targets without changes")
+	assertEqual(t, targets, affected, "Affected targets without changes")
+
+	i.changes = map[string]struct{}{filepath.Join(workspace, "app", "lib", "util.go"): struct{}{}}
+	affected, ok = i.affectedTargets("test", targets)
+	assertEqual(t, true, ok, "Affected targets")
+	assertEqual(t, []string{"//app:server"}, affected, "Affected targets")
+}
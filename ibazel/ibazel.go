@@ -22,19 +22,47 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 
 	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/auto_tune"
+	"github.com/bazelbuild/bazel-watcher/ibazel/bazel_output"
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/blue_green"
+	"github.com/bazelbuild/bazel-watcher/ibazel/cache_stats"
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+	"github.com/bazelbuild/bazel-watcher/ibazel/ci_annotations"
 	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/compile_commands"
+	"github.com/bazelbuild/bazel-watcher/ibazel/control"
+	"github.com/bazelbuild/bazel-watcher/ibazel/file_index"
+	"github.com/bazelbuild/bazel-watcher/ibazel/hotswap"
+	"github.com/bazelbuild/bazel-watcher/ibazel/ignore"
+	"github.com/bazelbuild/bazel-watcher/ibazel/junit"
+	"github.com/bazelbuild/bazel-watcher/ibazel/keyboard"
 	"github.com/bazelbuild/bazel-watcher/ibazel/live_reload"
 	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/machine_output"
+	"github.com/bazelbuild/bazel-watcher/ibazel/mrun_log"
 	"github.com/bazelbuild/bazel-watcher/ibazel/output_runner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/priority"
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
 	"github.com/bazelbuild/bazel-watcher/ibazel/profiler"
+	"github.com/bazelbuild/bazel-watcher/ibazel/progress"
+	"github.com/bazelbuild/bazel-watcher/ibazel/quarantine"
+	"github.com/bazelbuild/bazel-watcher/ibazel/session"
+	"github.com/bazelbuild/bazel-watcher/ibazel/socket_activation"
+	"github.com/bazelbuild/bazel-watcher/ibazel/status_line"
+	"github.com/bazelbuild/bazel-watcher/ibazel/terminal"
+	"github.com/bazelbuild/bazel-watcher/ibazel/test_history"
+	"github.com/bazelbuild/bazel-watcher/ibazel/test_output"
+	"github.com/bazelbuild/bazel-watcher/ibazel/watch_snapshot"
 	"github.com/bazelbuild/bazel-watcher/ibazel/workspace_finder"
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
 )
@@ -43,7 +71,12 @@ var osExit = os.Exit
 var bazelNew = bazel.New
 var commandDefaultCommand = command.DefaultCommand
 var commandNotifyCommand = command.NotifyCommand
+var commandHTTPNotifyCommand = command.HTTPNotifyCommand
+var commandSdNotifyCommand = command.SdNotifyCommand
+var commandShellCommand = command.ShellCommand
+var mainWorkspaceFinder workspace_finder.WorkspaceFinder = &workspace_finder.MainWorkspaceFinder{}
 var mrunToFiles = flag.Bool("mrunToFiles", false, "Log mrun to file for simpler log reading")
+var shellCommand = flag.String("command", "", "Shell command to (re)start from the workspace root after every successful `ibazel build`")
 
 type State string
 type runnableCommand func(...string) (*bytes.Buffer, error)
@@ -61,6 +94,10 @@ const (
 const sourceQuery = "kind('source file', deps(set(%s)))"
 const buildQuery = "buildfiles(deps(set(%s)))"
 
+// Source files that only reach a target through its data attribute, and so
+// don't need to be compiled into it.
+const dataQuery = "kind('source file', deps(labels(data, %[1]s))) except kind('source file', deps(labels(srcs, %[1]s) + labels(deps, %[1]s)))"
+
 type IBazel struct {
 	debounceDuration time.Duration
 
@@ -69,12 +106,16 @@ type IBazel struct {
 	logFiles         map[string]*os.File
 	srcDirToWatch    map[string][]string
 	bldDirToWatch    map[string][]string
+	srcFileTargets   map[string][]string // the mrun targets of each source file, see mapDependents
 	prevDir          string
 	firstBuildPassed bool
 	args             []string
 	bazelArgs        []string
 	startupArgs      []string
 
+	testArgsLock sync.Mutex // guards testArgs
+	testArgs     []string
+
 	sigs           chan os.Signal // Signals channel for the current process
 	interruptCount int
 
@@ -82,17 +123,198 @@ type IBazel struct {
 
 	buildFileWatcher  fSNotifyWatcher
 	sourceFileWatcher fSNotifyWatcher
+	// The watcher implementation and why it was picked, see watchBackend.
+	watchBackend       string
+	watchBackendReason string
 
 	filesWatched map[fSNotifyWatcher]map[string]struct{} // Inner map is a surrogate for a set
+	// The watched files by pathKey, see watchedEvent.
+	watchedKeys map[fSNotifyWatcher]map[string]string
+	// The directories resolved by pathKey since the last query.
+	resolvedDirs map[string]string
 
 	sourceEventHandler *SourceEventHandler
 	lifecycleListeners []Lifecycle
 
+	// Whether the run target is tagged ibazel_restage_data.
+	restageData bool
+	// Set when the run target is tagged ibazel_hot_reload.
+	hotswap *hotswap.Hotswap
+	// Data-only source files of the run target, nil until queried.
+	dataFiles map[string]struct{}
+	// Source files changed since the last command, and whether a BUILD file
+	// changed as well.
+	changes      map[string]struct{}
+	graphChanged bool
+	// The batch and index of the next change.Event.
+	changeBatch int
+	changeIndex int
+
+	// Pairs the two halves of directory renames.
+	dirMoves dirMoveDetector
+
+	// Which source file changes are rebuilt right away, see prioritize. The
+	// timer is nil while no low priority change is held back.
+	priorities         *priority.Classifier
+	lowPriorityChanges []fsnotify.Event
+	lowPriorityTimer   <-chan time.Time
+
+	// Fires when the files to watch are due to be queried again, see
+	// --requery_interval. Nil when disabled.
+	requeryTimer <-chan time.Time
+
+	// The health of the running targets, the timer of the next health checks
+	// and their results, see --health_check_interval. The timer is nil while
+	// there is nothing to check or the checks are running.
+	health        map[string]*targetHealth
+	healthTimer   <-chan time.Time
+	healthResults <-chan []healthResult
+
+	// The goroutines forwarding file changes and when the main loop started
+	// waiting for changes, which the watchdog checks from its own goroutine,
+	// see --watchdog_timeout. It hands the reason to restart the file
+	// watchers to the main loop on watchdogAlarms. watchdogMu also guards
+	// replacing the file watchers, which Cleanup closes from the signal
+	// goroutine.
+	watchdogMu     sync.Mutex
+	forwarders     []*forwarder
+	waitingSince   time.Time
+	watchdogAlarms chan string
+	watchdogStop   chan struct{}
+
+	// Fires after --idle_after without changes, and whether iBazel went idle
+	// then.
+	idleTimer <-chan time.Time
+	idle      idleState
+
+	// The mrun targets waiting for the ones started before them to come up,
+	// and the timer that starts the next batch of them, see startBatch. The
+	// timer is nil while none are waiting.
+	pendingStarts []pendingStart
+	startTimer    <-chan time.Time
+
+	// The last Bazel command, to tell its own changes apart, see ownChange.
+	lastCommand commandSpan
+	// When the queries for the files to watch started, see afterQuery.
+	queryStart time.Time
+	// The command whose output goes to the OutputListeners, see outputLine.
+	running runningCommand
+	// Draws the progress of commands whose output is held back, nil without
+	// --progress.
+	progress *progress.Writer
+	// Whether fetching is off because the network is down, see goOffline.
+	offline offlineState
+	// Whether restarts are paused by --max_total_rss, see memoryLimitReached.
+	memory memoryGuard
+
+	// The Bazel release, and whether the flags for the workspace were
+	// recommended yet, see autoTune.
+	bazelRelease string
+	tuned        bool
+	// Whether the watched directories were checked against the inotify limit
+	// yet, see checkWatchLimit.
+	watchLimitChecked bool
+	// The cgroup limits iBazel runs in, see tuneForLimits.
+	limits auto_tune.Limits
+	// The .bazelignore and .ibazelignore of the workspace, see loadIgnores.
+	ignores *ignore.Matcher
+
+	// The `bazel info package_path`, where the packages of each query were
+	// found in it, and the workspace with its symlinks resolved.
+	packagePath        string
+	packageRootsFound  map[string]string
+	canonicalWorkspace map[string]string
+
+	// Whether only the directories of the source files are watched, because
+	// there are too many of them, see watchTree, and the number of the files.
+	treeMode  bool
+	treeFiles int
+
+	// What the last successful query decided to watch, for `ibazel why-not`.
+	snapshot *watch_snapshot.Snapshot
+	// What the running query decided to watch so far, see startSnapshot.
+	querySnapshot *watch_snapshot.Snapshot
+	// The packages of the watched files and the targets that depend on them.
+	fileIndex *file_index.Index
+
+	// Bazel's output tree, nil until looked up by outputTree.
+	outputDirs []string
+	outputBase string
+
+	// The output files of the targets built so far, see --publish_artifacts.
+	artifacts artifactTracker
+	// The directories that can't be written to, see checkWritable.
+	readOnly readOnlyDirs
+	// Whether a command succeeded yet, and whether the listeners held back
+	// until then were told so, see --wait_for_first_success.
+	firstSuccess firstSuccessGate
+	// What the build events of the last build or test said, until
+	// afterCommand hands it to the listeners, see --build_events.
+	buildEvents *bep.Result
+	// The build or test that runs, which is cancelled on shutdown.
+	inflight inflightBazel
+
+	// The external repositories the targets are in, whose files are watched
+	// like the ones of the main repository.
+	targetRepos map[string]struct{}
+
+	// Targets to restart without rebuilding them, see RestartTarget. restarts
+	// wakes up the main loop when pendingRestarts isn't empty.
+	restarts        chan struct{}
+	restartLock     sync.Mutex // guards pendingRestarts
+	pendingRestarts map[string]struct{}
+	// Keys pressed in the terminal, see startKeys, and whether watching is
+	// paused with them, and files changed meanwhile.
+	keyboard      *keyboard.Keyboard
+	keyActions    chan keyAction
+	paused        bool
+	missedChanges bool
+	// The targets of `ibazel build` and `ibazel test`, which can be changed
+	// while running, see AddTarget.
+	targets     []string
+	targetEdits chan targetEdit
+	// The latest file given to FocusFile that the main loop hasn't taken yet.
+	focus     string
+	focusLock sync.Mutex
+
+	// The listening sockets of the targets with ibazel_listen tags, see
+	// socketActivation.
+	sockets map[string]socket_activation.Sockets
+	// The proxies of the targets with ibazel_blue_green tags, see blueGreen,
+	// and their new instances once they got ready, see
+	// blueGreenCommand.waitReady.
+	proxies        map[string]*blue_green.Proxy
+	blueGreenReady chan blueGreenReady
+
+	// The control API, see --control, and what the main loop last told it.
+	control    *control.Server
+	statusLock sync.Mutex // guards status
+	status     controlStatus
+
+	iterationLock    sync.Mutex // guards iterationID and iterationTrigger
+	iterationID      string
+	iterationTrigger string
+	// Why the iteration after the changes being debounced starts, see
+	// changeTrigger.
+	pendingTrigger string
+
+	// The last few things that happened, for crash reports. Shutdown adds to
+	// them from the goroutine that handles signals.
+	recentEventsLock sync.Mutex // guards recentEvents
+	recentEvents     []string
+	// Records the session for --record, nil if it isn't set.
+	recorder *session.Recorder
+
+	deviceLogs process_group.ProcessGroup
+	quarantine *quarantine.Quarantine
+	junit      *junit.JUnit
+
 	state State
 }
 
 func New() (*IBazel, error) {
 	i := &IBazel{}
+	i.workspaceFinder = mainWorkspaceFinder
 	err := i.setup()
 	if err != nil {
 		return nil, err
@@ -101,10 +323,21 @@ func New() (*IBazel, error) {
 	i.firstBuildPassed = false
 	i.debounceDuration = 100 * time.Millisecond
 	i.filesWatched = map[fSNotifyWatcher]map[string]struct{}{}
-	i.workspaceFinder = &workspace_finder.MainWorkspaceFinder{}
+	i.watchedKeys = map[fSNotifyWatcher]map[string]string{}
 
 	i.srcDirToWatch = map[string][]string{}
 	i.bldDirToWatch = map[string][]string{}
+	i.srcFileTargets = map[string][]string{}
+
+	workspacePath, _ := i.workspaceFinder.FindWorkspace()
+	i.recorder = startRecording(workspacePath)
+	i.quarantine = quarantine.New(workspacePath)
+	i.priorities = priority.New(workspacePath)
+	i.fileIndex = file_index.Open(workspacePath)
+	i.restarts = make(chan struct{}, 1)
+	i.keyActions = make(chan keyAction, 1)
+	i.targetEdits = make(chan targetEdit, targetEditBuffer)
+	i.blueGreenReady = make(chan blueGreenReady)
 
 	i.sigs = make(chan os.Signal, 1)
 	signal.Notify(i.sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
@@ -112,6 +345,13 @@ func New() (*IBazel, error) {
 	liveReload := live_reload.New()
 	profiler := profiler.New(Version)
 	outputRunner := output_runner.New()
+	testHistory := test_history.New(i.sourceFiles)
+	i.junit = junit.New()
+	machineOutput := machine_output.New()
+	ciAnnotations := ci_annotations.New()
+	cacheStats := cache_stats.New()
+	statusLine := status_line.New()
+	compileCommands := compile_commands.New(func() bazel.Bazel { return i.newBazel("build") }, func() []string { return i.bazelArgsFor("build") })
 
 	liveReload.AddEventsListener(profiler)
 
@@ -119,12 +359,25 @@ func New() (*IBazel, error) {
 		liveReload,
 		profiler,
 		outputRunner,
+		testHistory,
+		i.junit,
+		machineOutput,
+		ciAnnotations,
+		cacheStats,
+		statusLine,
+		compileCommands,
 	}
 
 	info, _ := i.getInfo()
+	if info != nil {
+		i.bazelRelease = (*info)["release"]
+		i.packagePath = (*info)["package_path"]
+	}
 	for _, l := range i.lifecycleListeners {
-		l.Initialize(info)
+		i.callListener(l, "Initialize", func() { l.Initialize(info) })
 	}
+	i.startWatchdog()
+	i.scheduleIdle()
 
 	go func() {
 		for {
@@ -151,6 +404,7 @@ func (i *IBazel) handleSignals() {
 			log.Log("Subprocess killed from getting SIGINT (trigger SIGINT again to stop ibazel)")
 			i.cmd.Terminate()
 		} else {
+			i.shutdown("SIGINT")
 			osExit(3)
 		}
 		break
@@ -165,7 +419,14 @@ func (i *IBazel) handleSignals() {
 			log.Log("Subprocess killed from getting SIGTERM")
 			i.cmd.Terminate()
 		}
-		osExit(3)
+		i.shutdown("SIGTERM")
+		if terminal.NoTTY() {
+			// Process supervisors stop iBazel with SIGTERM and take any
+			// other exit code than 0 for a crash.
+			osExit(0)
+		} else {
+			osExit(3)
+		}
 		return
 	case syscall.SIGHUP:
 		for _, cmd := range i.cmds {
@@ -178,6 +439,7 @@ func (i *IBazel) handleSignals() {
 			log.Log("Subprocess killed from getting SIGHUP")
 			i.cmd.Terminate()
 		}
+		i.shutdown("SIGHUP")
 		osExit(3)
 		return
 	default:
@@ -187,16 +449,35 @@ func (i *IBazel) handleSignals() {
 	i.interruptCount += 1
 	if i.interruptCount > 2 {
 		log.NewLine()
+		i.shutdown("SIGINT")
 		log.Fatal("Exiting from getting SIGINT 3 times")
 		osExit(3)
 	}
 }
 
-func (i *IBazel) newBazel() bazel.Bazel {
+// shutdown tells the lifecycle listeners that iBazel is about to exit, once
+// the build or test that runs, if any, was cancelled. It is called from the
+// goroutine that handles signals while the main loop keeps running, so
+// everything it touches has to be safe for concurrent use.
+func (i *IBazel) shutdown(reason string) {
+	i.recordEvent("shutting down, reason: %s", reason)
+	i.stopKeys()
+	i.stopControl()
+	i.inflight.cancel()
+	for _, l := range i.lifecycleListeners {
+		if sl, ok := l.(ShutdownListener); ok {
+			i.callListener(l, "Shutdown", func() { sl.Shutdown(reason) })
+		}
+	}
+	i.saveRecording()
+}
+
+// newBazel creates the client for a Bazel command, e.g. "build" or "query".
+func (i *IBazel) newBazel(command string) bazel.Bazel {
 	b := bazelNew()
 	b.SetStartupArgs(i.startupArgs)
-	b.SetArguments(i.bazelArgs)
-	return b
+	b.SetArguments(i.bazelArgsFor(command))
+	return i.recorder.Bazel(command, b)
 }
 
 func (i *IBazel) SetBazelArgs(args []string) {
@@ -207,16 +488,59 @@ func (i *IBazel) SetStartupArgs(args []string) {
 	i.startupArgs = args
 }
 
+// SetTestArgs sets flags that are only passed to `bazel test`, e.g. the
+// --test_filter and --test_arg flags given after the -- of `ibazel test`.
+func (i *IBazel) SetTestArgs(args []string) {
+	i.testArgsLock.Lock()
+	defer i.testArgsLock.Unlock()
+	i.testArgs = args
+}
+
+// SetTestFilter replaces the --test_filter used by future iterations. An empty
+// filter runs all test cases again.
+func (i *IBazel) SetTestFilter(filter string) {
+	i.testArgsLock.Lock()
+	defer i.testArgsLock.Unlock()
+
+	args := []string{}
+	for _, arg := range i.testArgs {
+		if !strings.HasPrefix(arg, "--test_filter=") {
+			args = append(args, arg)
+		}
+	}
+	if filter != "" {
+		args = append(args, "--test_filter="+filter)
+	}
+	i.testArgs = args
+}
+
+func (i *IBazel) getTestArgs() []string {
+	i.testArgsLock.Lock()
+	defer i.testArgsLock.Unlock()
+	return append([]string{}, i.testArgs...)
+}
+
 func (i *IBazel) SetDebounceDuration(debounceDuration time.Duration) {
 	i.debounceDuration = debounceDuration
 }
 
 func (i *IBazel) Cleanup() {
+	i.stopKeys()
+	i.stopControl()
+	i.stopWatchdog()
+	i.watchdogMu.Lock()
 	i.buildFileWatcher.Close()
 	i.sourceFileWatcher.Close()
+	i.watchdogMu.Unlock()
+	i.stopDeviceLogs()
+	i.cleanupHotswap()
+	i.closeSockets("")
+	i.closeProxies("")
+	i.closeLogFiles()
 	for _, l := range i.lifecycleListeners {
-		l.Cleanup()
+		i.callListener(l, "Cleanup", func() { l.Cleanup() })
 	}
+	i.saveRecording()
 }
 
 func (i *IBazel) targetDecider(target string, rule *blaze_query.Rule) {
@@ -233,44 +557,116 @@ func (i *IBazel) targetDecider(target string, rule *blaze_query.Rule) {
 		// about on screen, I'm not sure that it is wise to do this in the first
 		// pass. It might be worth triggering the user action, launching their thing
 		// and then running a background thread to access the data.
-		l.TargetDecider(rule)
+		i.callListener(l, "TargetDecider", func() { l.TargetDecider(rule) })
 	}
 }
 
-func (i *IBazel) changeDetected(targets []string, changeType string, change string) {
+func (i *IBazel) changeDetected(targets []string, changeType change.Type, e fsnotify.Event) {
+	i.wake()
+	switch changeType {
+	case change.Source:
+		if i.changes == nil {
+			i.changes = map[string]struct{}{}
+		}
+		i.changes[e.Name] = struct{}{}
+	case change.Graph:
+		i.graphChanged = true
+		i.dataFiles = nil
+	}
+
+	event := change.Event{
+		Type:    changeType,
+		Op:      changeOp(e.Op),
+		Path:    e.Name,
+		Targets: i.changedTargets(targets, changeType, e.Name),
+		Batch:   i.changeBatch,
+		Index:   i.changeIndex,
+	}
+	i.changeIndex++
+	if i.pendingTrigger == "" {
+		i.pendingTrigger = changeTrigger(changeType, e)
+	}
+
+	i.recordEvent("%s %s %s", event.Type, event.Op, event.Path)
+
 	for _, l := range i.lifecycleListeners {
-		l.ChangeDetected(targets, changeType, change)
+		if cl, ok := l.(change.Listener); ok {
+			i.callListener(l, "ChangeEventDetected", func() { cl.ChangeEventDetected(event) })
+		} else {
+			i.callListener(l, "ChangeDetected", func() { l.ChangeDetected(targets, string(changeType), e.Name) })
+		}
+	}
+}
+
+// changeOp picks the most significant of the operations fsnotify reports.
+func changeOp(op fsnotify.Op) change.Op {
+	switch {
+	case op&fsnotify.Remove != 0:
+		return change.Remove
+	case op&fsnotify.Rename != 0:
+		return change.Rename
+	case op&fsnotify.Create != 0:
+		return change.Create
+	case op&fsnotify.Write != 0:
+		return change.Write
+	default:
+		return change.Chmod
 	}
 }
 
 func (i *IBazel) beforeCommand(targets []string, command string) {
+	i.recordEvent("%s %s", command, strings.Join(targets, " "))
+	i.lastCommand.started()
+	i.running.set(targets, command)
 	for _, l := range i.lifecycleListeners {
-		l.BeforeCommand(targets, command)
+		i.callListener(l, "BeforeCommand", func() { l.BeforeCommand(targets, command) })
 	}
 }
 
 func (i *IBazel) afterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	i.progress.Clear()
+	i.lastCommand.finished()
+	i.running.set(nil, "")
+	i.changes = nil
+	i.graphChanged = false
+	if i.changeIndex > 0 {
+		i.changeBatch++
+		i.changeIndex = 0
+	}
+	i.recordEvent("%s finished, success: %v", command, success)
+	i.recorder.Command(command, targets, success)
+	if success {
+		i.publishArtifacts(targets)
+	}
+	i.publishBuildEvents(targets, command)
+	i.firstSuccess.commandFinished(success)
 	for _, l := range i.lifecycleListeners {
-		l.AfterCommand(targets, command, success, output)
+		if i.firstSuccess.holdsBack(l) {
+			continue
+		}
+		i.callListener(l, "AfterCommand", func() { l.AfterCommand(targets, command, success, output) })
 	}
 }
 
 func (i *IBazel) setup() error {
-	var err error
+	workspacePath := ""
+	if i.workspaceFinder != nil {
+		workspacePath, _ = i.workspaceFinder.FindWorkspace()
+	}
+	i.watchBackend, i.watchBackendReason = watchBackend(workspacePath)
 
 	// Even though we are going to recreate this when the query happens, create
 	// the pointer we will use to refer to the watchers right now.
-	i.buildFileWatcher, err = wrapWatcher(fsnotify.NewWatcher())
+	watcher, err := newWatcher(i.watchBackend, workspacePath)
 	if err != nil {
 		return err
 	}
+	dispatcher := newWatchDispatcher(watcher)
+	i.buildFileWatcher = dispatcher.Graph()
+	i.sourceFileWatcher = dispatcher.Source()
 
-	i.sourceFileWatcher, err = wrapWatcher(fsnotify.NewWatcher())
-	if err != nil {
-		return err
-	}
-
-	i.sourceEventHandler = NewSourceEventHandler(i.sourceFileWatcher.Watcher())
+	i.sourceEventHandler = NewSourceEventHandler(i.sourceFileWatcher)
+	i.forwarders = []*forwarder{dispatcher.alive, i.sourceEventHandler.alive}
 
 	return nil
 }
@@ -290,6 +686,9 @@ func (i *IBazel) RunMultiple(args, target []string, debugArgs [][]string) error
 
 // Build the specified targets in the IBazel loop.
 func (i *IBazel) Build(targets ...string) error {
+	if *shellCommand != "" {
+		return i.loop("build", i.buildThenCommand, targets)
+	}
 	return i.loop("build", i.build, targets)
 }
 
@@ -298,21 +697,113 @@ func (i *IBazel) Test(targets ...string) error {
 	return i.loop("test", i.test, targets)
 }
 
+// Composite runs several verbs one after the other in the IBazel loop, e.g.
+// `ibazel test+run //server:tests //server:bin`. A phase only runs if the
+// previous one succeeded. When the last verb is run, the last target is the
+// one that is run and the other targets are used by the preceding phases.
+func (i *IBazel) Composite(verbs []string, targets []string, args []string) error {
+	i.args = args
+	return i.loop(strings.Join(verbs, "+"), i.composite(verbs), targets)
+}
+
+func (i *IBazel) composite(verbs []string) runnableCommand {
+	phases := map[string]runnableCommand{
+		"build": i.build,
+		"test":  i.test,
+		"run":   i.run,
+	}
+	endsWithRun := verbs[len(verbs)-1] == "run"
+
+	return func(targets ...string) (*bytes.Buffer, error) {
+		var outputBuffer *bytes.Buffer
+		for _, phase := range verbs {
+			phaseTargets := targets
+			if endsWithRun && phase == "run" {
+				phaseTargets = targets[len(targets)-1:]
+			} else if endsWithRun {
+				phaseTargets = targets[:len(targets)-1]
+			}
+
+			log.Logf("%s %s", strings.Title(verb(phase)), strings.Join(phaseTargets, " "))
+			i.beforeCommand(phaseTargets, phase)
+			var err error
+			outputBuffer, err = phases[phase](phaseTargets...)
+			i.afterCommand(phaseTargets, phase, err == nil, outputBuffer)
+			if err != nil {
+				log.Errorf("%s failed, skipping the rest of %s", strings.Title(phase), strings.Join(verbs, "+"))
+				return outputBuffer, err
+			}
+		}
+		return outputBuffer, nil
+	}
+}
+
+// isComposite reports whether command chains several verbs, e.g. "test+run".
+func isComposite(command string) bool {
+	return strings.Contains(command, "+")
+}
+
+// compositeVerbs splits and validates a command like "build+test" or
+// "test+run". Only build, test and run can be chained, and run has to come
+// last.
+func compositeVerbs(command string, targets []string) ([]string, error) {
+	verbs := strings.Split(command, "+")
+	for n, v := range verbs {
+		switch v {
+		case "build", "test":
+		case "run":
+			if n != len(verbs)-1 {
+				return nil, fmt.Errorf("run has to be the last verb of %s", command)
+			}
+			if len(targets) < 2 {
+				return nil, fmt.Errorf("%s needs a target for %s and the target to run", command, strings.Join(verbs[:n], "+"))
+			}
+		default:
+			return nil, fmt.Errorf("%s can't be chained", v)
+		}
+	}
+	return verbs, nil
+}
+
 func (i *IBazel) loop(command string, commandToRun runnableCommand, targets []string) error {
-	joinedTargets := strings.Join(targets, " ")
+	i.targets = targets
 
+	i.setTargetRepos(targets)
 	i.state = QUERY
+	i.startIteration(triggerStartup)
 	for {
-		i.iteration(command, commandToRun, targets, joinedTargets)
+		i.recorder.State(string(i.state))
+		i.watchdogState(i.state)
+		i.publishStatus(command)
+		i.iteration(command, commandToRun, i.targets, strings.Join(i.targets, " "))
 	}
 
 	return nil
 }
 
 func (i *IBazel) loopMultiple(command string, commandToRun runnableCommands, targets []string, debugArgs [][]string, argsLength int) error {
+	i.targets = targets
+	// The targets can change at runtime, so keep each target's debug args with
+	// the target rather than its position.
+	targetDebugArgs := map[string][]string{}
+	for idx, target := range targets {
+		if idx < len(debugArgs) {
+			targetDebugArgs[target] = debugArgs[idx]
+		}
+	}
+
+	i.setTargetRepos(targets)
 	i.state = QUERY
+	i.startIteration(triggerStartup)
 	for {
-		i.iterationMultiple(command, commandToRun, targets, debugArgs, argsLength)
+		debugArgs := make([][]string, len(i.targets))
+		for idx, target := range i.targets {
+			debugArgs[idx] = targetDebugArgs[target]
+		}
+		i.recorder.State(string(i.state))
+		i.watchdogState(i.state)
+		i.publishStatus(command)
+		i.iterationMultiple(command, commandToRun, i.targets, debugArgs, argsLength)
 	}
 
 	return nil
@@ -326,50 +817,119 @@ func (i *IBazel) iteration(command string, commandToRun runnableCommand, targets
 	switch i.state {
 	case WAIT:
 		select {
+		case <-i.restarts:
+			i.restartPending()
+		case action := <-i.keyActions:
+			i.applyKeyAction(action)
+		case <-i.lowPriorityTimer:
+			log.Logf("Rebuilding low priority changes...")
+			i.flushLowPriority(targets)
+			i.state = DEBOUNCE_RUN
+		case <-i.requeryTimer:
+			i.requery(command, targets, false)
+		case <-i.healthTimer:
+			i.checkHealth()
+		case results := <-i.healthResults:
+			i.healthChecked(results)
+		case reason := <-i.watchdogAlarms:
+			i.restartWatchers(reason)
+		case r := <-i.blueGreenReady:
+			r.cmd.ready(r.inst, r.err)
+		case <-i.idleTimer:
+			i.goIdle()
+		case edit := <-i.targetEdits:
+			if i.applyTargetEdit(command, edit) {
+				i.startIteration(triggerManual)
+				i.state = QUERY
+			}
 		case e := <-i.sourceEventHandler.SourceFileEvents:
-			if _, ok := i.filesWatched[i.sourceFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				log.Logf("Changed: %q. Rebuilding...", e.Name)
-				i.changeDetected(targets, "source", e.Name)
+			e = i.watchedEvent(i.sourceFileWatcher, e)
+			if i.pausedEvent(e) {
+				break
+			}
+			if i.detectDirMove(targets, e) {
+				i.state = DEBOUNCE_QUERY
+			} else if i.watchingSource(e.Name) && e.Op&modifyingEvents != 0 && !i.ownChange(e) && i.prioritize(targets, e) {
+				log.Logf("Changed: %q%s. Rebuilding...", i.displayPath(e.Name), i.describeChange(e.Name))
+				i.changeDetected(targets, change.Source, e)
 				i.state = DEBOUNCE_RUN
 			}
 		case e := <-i.buildFileWatcher.Events():
-			if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				log.Logf("Build graph changed: %q. Requerying...", e.Name)
-				i.changeDetected(targets, "graph", e.Name)
+			e = i.watchedEvent(i.buildFileWatcher, e)
+			if i.pausedEvent(e) {
+				break
+			}
+			if i.detectDirMove(targets, e) {
+				i.state = DEBOUNCE_QUERY
+			} else if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 && !i.ownChange(e) {
+				log.Logf("Build graph changed: %q%s. Requerying...", i.displayPath(e.Name), i.describeChange(e.Name))
+				i.changeDetected(targets, change.Graph, e)
 				i.state = DEBOUNCE_QUERY
 			}
 		}
 	case DEBOUNCE_QUERY:
 		select {
 		case e := <-i.buildFileWatcher.Events():
+			e = i.watchedEvent(i.buildFileWatcher, e)
 			if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				i.changeDetected(targets, "graph", e.Name)
+				i.changeDetected(targets, change.Graph, e)
 			}
 			i.state = DEBOUNCE_QUERY
 		case <-time.After(i.debounceDuration):
+			i.startIteration(i.debouncedTrigger(triggerGraphChange))
 			i.state = QUERY
 		}
 	case QUERY:
 		// Query for which files to watch.
 		log.Logf("Querying for files to watch...")
-		i.watchFiles(fmt.Sprintf(buildQuery, joinedTargets), i.buildFileWatcher)
-		i.watchFiles(fmt.Sprintf(sourceQuery, joinedTargets), i.sourceFileWatcher)
+		i.startSnapshot(command, targets)
+		i.beforeQuery(targets)
+		if err := i.watchFiles(fmt.Sprintf(buildQuery, joinedTargets), targets, i.buildFileWatcher); err != nil {
+			i.afterQuery(targets, err)
+			i.state = WAIT
+			return
+		}
+		if err := i.watchFiles(fmt.Sprintf(sourceQuery, joinedTargets), targets, i.sourceFileWatcher); err != nil {
+			i.afterQuery(targets, err)
+			i.state = WAIT
+			return
+		}
+		i.afterQuery(targets, nil)
+		i.saveSnapshot()
+		i.saveFileIndex()
+		i.autoTune()
+		i.checkWatchLimit()
+		i.scheduleRequery()
 		i.state = RUN
 	case DEBOUNCE_RUN:
 		select {
 		case e := <-i.sourceEventHandler.SourceFileEvents:
-			if _, ok := i.filesWatched[i.sourceFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				i.changeDetected(targets, "source", e.Name)
+			e = i.watchedEvent(i.sourceFileWatcher, e)
+			if i.watchingSource(e.Name) && e.Op&modifyingEvents != 0 {
+				i.changeDetected(targets, change.Source, e)
 			}
 			i.state = DEBOUNCE_RUN
 		case <-time.After(i.debounceDuration):
+			i.startIteration(i.debouncedTrigger(triggerFileChange))
 			i.state = RUN
 		}
 	case RUN:
-		log.Logf("%s %s", strings.Title(verb(command)), joinedTargets)
-		i.beforeCommand(targets, command)
-		outputBuffer, err := commandToRun(targets...)
-		i.afterCommand(targets, command, err == nil, outputBuffer)
+		if isComposite(command) || command == "group" {
+			// Every phase reports to the lifecycle listeners on its own.
+			commandToRun(targets...)
+			i.state = WAIT
+			return
+		}
+		runTargets, ok := i.affectedTargets(command, targets)
+		if !ok {
+			i.changes = nil
+			i.state = WAIT
+			return
+		}
+		log.Logf("%s %s", strings.Title(verb(command)), strings.Join(runTargets, " "))
+		i.beforeCommand(runTargets, command)
+		outputBuffer, err := commandToRun(runTargets...)
+		i.afterCommand(runTargets, command, err == nil, outputBuffer)
 		i.state = WAIT
 	}
 }
@@ -379,28 +939,71 @@ func (i *IBazel) iterationMultiple(command string, commandToRun runnableCommands
 	switch i.state {
 	case WAIT:
 		select {
+		case <-i.restarts:
+			i.restartPending()
+		case action := <-i.keyActions:
+			i.applyKeyAction(action)
+		case <-i.lowPriorityTimer:
+			log.Logf("Rebuilding low priority changes...")
+			i.flushLowPriority(targets)
+			i.state = DEBOUNCE_RUN
+		case <-i.requeryTimer:
+			i.requery(command, targets, true)
+		case <-i.healthTimer:
+			i.checkHealth()
+		case results := <-i.healthResults:
+			i.healthChecked(results)
+		case reason := <-i.watchdogAlarms:
+			i.restartWatchers(reason)
+		case r := <-i.blueGreenReady:
+			r.cmd.ready(r.inst, r.err)
+		case <-i.idleTimer:
+			i.goIdle()
+		case <-i.startTimer:
+			i.startPending()
+		case edit := <-i.targetEdits:
+			// iterationMultiple only runs `ibazel mrun`, whose command is "run".
+			if i.applyTargetEdit("mrun", edit) {
+				i.stopRemovedTargets()
+				i.startIteration(triggerManual)
+				i.state = QUERY
+			}
 		case e := <-i.sourceEventHandler.SourceFileEvents:
-			if _, ok := i.filesWatched[i.sourceFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				log.Logf("\nChanged: %q. Rebuilding...", e.Name)
-				i.changeDetected(targets, "source", e.Name)
+			e = i.watchedEvent(i.sourceFileWatcher, e)
+			if i.pausedEvent(e) {
+				break
+			}
+			if i.detectDirMove(targets, e) {
+				i.state = DEBOUNCE_QUERY
+			} else if i.watchingSource(e.Name) && e.Op&modifyingEvents != 0 && !i.ownChange(e) && i.prioritize(targets, e) {
+				log.Logf("\nChanged: %q%s. Rebuilding...", i.displayPath(e.Name), i.describeChange(e.Name))
+				i.changeDetected(targets, change.Source, e)
 				i.state = DEBOUNCE_RUN
 			}
 		case e := <-i.buildFileWatcher.Events():
-			if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				log.Logf("\nBuild graph changed: %q. Requerying...", e.Name)
-				i.changeDetected(targets, "graph", e.Name)
+			e = i.watchedEvent(i.buildFileWatcher, e)
+			if i.pausedEvent(e) {
+				break
+			}
+			if i.detectDirMove(targets, e) {
+				i.state = DEBOUNCE_QUERY
+			} else if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 && !i.ownChange(e) {
+				log.Logf("\nBuild graph changed: %q%s. Requerying...", i.displayPath(e.Name), i.describeChange(e.Name))
+				i.changeDetected(targets, change.Graph, e)
 				i.state = DEBOUNCE_QUERY
 			}
 		}
 	case DEBOUNCE_QUERY:
 		select {
 		case e := <-i.buildFileWatcher.Events():
+			e = i.watchedEvent(i.buildFileWatcher, e)
 			if _, ok := i.filesWatched[i.buildFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				i.changeDetected(targets, "graph", e.Name)
+				i.changeDetected(targets, change.Graph, e)
 			}
 			i.prevDir, _ = filepath.Split(e.Name)
 			i.state = DEBOUNCE_QUERY
 		case <-time.After(i.debounceDuration):
+			i.startIteration(i.debouncedTrigger(triggerGraphChange))
 			i.state = QUERY
 		}
 	case QUERY:
@@ -415,36 +1018,65 @@ func (i *IBazel) iterationMultiple(command string, commandToRun runnableCommands
 		if len(toQuery) == 0 {
 			toQuery = targets
 		}
-		i.watchManyFiles(buildQuery, toQuery, i.buildFileWatcher, &i.bldDirToWatch)
+		i.startSnapshot(command, targets)
+		i.beforeQuery(toQuery)
+		if err := i.watchManyFiles(buildQuery, toQuery, i.buildFileWatcher, &i.bldDirToWatch); err != nil {
+			i.afterQuery(toQuery, err)
+			i.state = WAIT
+			return
+		}
 		log.Logf("Querying for source files...")
-		i.watchManyFiles(sourceQuery, toQuery, i.sourceFileWatcher, &i.srcDirToWatch)
+		if err := i.watchManyFiles(sourceQuery, toQuery, i.sourceFileWatcher, &i.srcDirToWatch); err != nil {
+			i.afterQuery(toQuery, err)
+			i.state = WAIT
+			return
+		}
+		i.afterQuery(toQuery, nil)
+		i.saveSnapshot()
+		i.saveFileIndex()
+		i.autoTune()
+		i.checkWatchLimit()
+		i.scheduleRequery()
 		i.prevDir = ""
 		i.state = RUN
 	case DEBOUNCE_RUN:
 		select {
 		case e := <-i.sourceEventHandler.SourceFileEvents:
-			if _, ok := i.filesWatched[i.sourceFileWatcher][e.Name]; ok && e.Op&modifyingEvents != 0 {
-				i.changeDetected(targets, "source", e.Name)
+			e = i.watchedEvent(i.sourceFileWatcher, e)
+			if i.watchingSource(e.Name) && e.Op&modifyingEvents != 0 {
+				i.changeDetected(targets, change.Source, e)
 			}
 			i.prevDir, _ = filepath.Split(e.Name)
 			i.state = DEBOUNCE_RUN
 		case <-time.After(i.debounceDuration):
+			i.startIteration(i.debouncedTrigger(triggerFileChange))
 			i.state = RUN
 		}
 	case RUN:
-		if i.cmds != nil {
-			for _, target := range targets {
-				i.cmds[target].BeforeRebuild()
-			}
-		}
-
 		var torun []string
-		if i.prevDir != "" && i.firstBuildPassed {
-			torun = i.srcDirToWatch[i.prevDir]
+		if len(i.changes) > 0 && !i.graphChanged && i.firstBuildPassed {
+			torun = i.affectedRunTargets(targets)
+			if len(torun) == 0 {
+				log.Logf("None of the targets depend on the changed files")
+				i.changes = nil
+				i.prevDir = ""
+				i.state = WAIT
+				return
+			}
 		} else {
 			torun = targets
 		}
-		
+
+		// Only the targets that are restarted are stopped, the others keep
+		// running.
+		if i.cmds != nil {
+			for _, target := range torun {
+				if cmd, ok := i.cmds[target]; ok {
+					cmd.BeforeRebuild()
+				}
+			}
+		}
+
 		log.Logf("%s %s", strings.Title(verb(command)), strings.Join(torun, " "))
 		i.beforeCommand(torun, command)
 		outputBuffers, err := commandToRun(torun, debugArgs, argsLength)
@@ -462,39 +1094,162 @@ func verb(s string) string {
 		return "running"
 	case "Run":
 		return "Running"
+	case "mobile-install":
+		return "installing"
 	default:
 		return fmt.Sprintf("%sing", s)
 	}
 }
 
 func (i *IBazel) build(targets ...string) (*bytes.Buffer, error) {
-	b := i.newBazel()
-
-	b.Cancel()
-	b.WriteToStderr(true)
-	b.WriteToStdout(true)
-	outputBuffer, err := b.Build(targets...)
+	b := i.newBazel("build")
+
+	defer i.inflight.track(b)()
+	b.WriteToStderr(bazel_output.Live())
+	b.WriteToStdout(bazel_output.Live())
+	events := i.startBuildEvents(false)
+	patterns, cleanup := targetPatternArgs(targets)
+	defer cleanup()
+	outputBuffer, err := b.Build(append(events.Args(), patterns...)...)
+	i.finishBuildEvents(events)
+	i.progress.Clear()
+	bazel_output.Print("build", targets, err == nil, outputBuffer)
 	if err != nil {
+		if i.goOffline(outputBuffer) {
+			return i.build(targets...)
+		}
 		log.Errorf("Build error: %v", err)
 		return outputBuffer, err
 	}
 	return outputBuffer, nil
 }
 
-func (i *IBazel) test(targets ...string) (*bytes.Buffer, error) {
-	b := i.newBazel()
+// buildThenCommand builds the targets and (re)starts the --command once they
+// built successfully.
+func (i *IBazel) buildThenCommand(targets ...string) (*bytes.Buffer, error) {
+	outputBuffer, err := i.build(targets...)
+	if err != nil {
+		return outputBuffer, err
+	}
+
+	if i.cmd == nil {
+		workspacePath, err := i.workspaceFinder.FindWorkspace()
+		if err != nil {
+			log.Errorf("Error finding workspace: %v", err)
+			return outputBuffer, err
+		}
+		i.cmd = commandShellCommand(*shellCommand, workspacePath, []string{
+			"BUILD_WORKSPACE_DIRECTORY=" + workspacePath,
+			"IBAZEL_TARGETS=" + strings.Join(targets, " "),
+		})
+		if _, err := i.cmd.Start(nil); err != nil {
+			log.Errorf("Command start failed %v", err)
+			return outputBuffer, err
+		}
+		return outputBuffer, nil
+	}
+
+	if i.memoryLimitReached(*shellCommand) {
+		return outputBuffer, nil
+	}
+	log.Logf("Restarting %s", *shellCommand)
+	i.cmd.AfterRebuild(nil)
+	return outputBuffer, nil
+}
 
-	b.Cancel()
-	b.WriteToStderr(true)
-	b.WriteToStdout(true)
-	outputBuffer, err := b.Test(targets...)
+func (i *IBazel) test(targets ...string) (*bytes.Buffer, error) {
+	b := i.newBazel("test")
+
+	i.quarantine.Reload()
+	quarantined := i.quarantine.Targets()
+
+	// Explicit test arguments win over the ones of --test_output_mode. The
+	// build events tell which tests failed when quarantined ones may be among
+	// them.
+	events := i.startBuildEvents(len(quarantined) > 0 && quarantine.Mode() != quarantine.Skip)
+	testArgs := append(append(test_output.BazelArgs(), i.getTestArgs()...), events.Args()...)
+	patterns := targets
+	if len(quarantined) > 0 && quarantine.Mode() == quarantine.Skip {
+		patterns = append([]string(nil), targets...)
+		for _, target := range quarantined {
+			patterns = append(patterns, "-"+target)
+		}
+	}
+	patternArgs, cleanup := targetPatternArgs(patterns)
+	defer cleanup()
+	args := append(testArgs, patternArgs...)
+
+	defer i.inflight.track(b)()
+	// Printed condensed by test_output.Print unless the tests are streamed.
+	b.WriteToStderr(bazel_output.Live() && test_output.Live())
+	b.WriteToStdout(bazel_output.Live() && test_output.Live())
+	outputBuffer, err := b.Test(args...)
+	i.finishBuildEvents(events)
+	i.progress.Clear()
+	if bazel_output.Live() {
+		test_output.Print(outputBuffer, i.buildEvents)
+	} else {
+		bazel_output.Print("test", targets, err == nil, outputBuffer)
+	}
+	if err != nil && len(quarantined) > 0 && i.onlyQuarantinedFailed(outputBuffer) {
+		log.Logf("Only quarantined tests failed, ignoring the failure")
+		return outputBuffer, nil
+	}
 	if err != nil {
+		if i.goOffline(outputBuffer) {
+			return i.test(targets...)
+		}
 		log.Errorf("Build error: %v", err)
 		return outputBuffer, err
 	}
 	return outputBuffer, err
 }
 
+// onlyQuarantinedFailed reports whether the last test command had failures
+// and all of them are quarantined tests, printing a warning for each. Every
+// status other than PASSED and FLAKY is a failure, including the tests that
+// didn't build or didn't run. The failures come from the build events of the
+// command, when it has them, and else from the test summary in its output.
+func (i *IBazel) onlyQuarantinedFailed(output *bytes.Buffer) bool {
+	var failed []string
+	for _, target := range failedTests(i.buildEvents, output) {
+		if !i.quarantine.Contains(target) {
+			return false
+		}
+		failed = append(failed, target)
+	}
+
+	for _, target := range failed {
+		log.Logf("Warning: quarantined test %s failed", target)
+	}
+	return len(failed) > 0
+}
+
+// failedTests returns the targets that failed to build or whose tests didn't
+// pass, according to the build events if there are any and else to the test
+// summary in output.
+func failedTests(events *bep.Result, output *bytes.Buffer) []string {
+	var failed []string
+	if events != nil {
+		for _, t := range events.Targets {
+			status := test_history.Status(t.TestStatus)
+			if !t.Success || (status != "" && status != test_history.Passed && status != test_history.Flaky) {
+				failed = append(failed, t.Label)
+			}
+		}
+		return failed
+	}
+	if output == nil {
+		return nil
+	}
+	for _, r := range test_history.ParseSummary(output) {
+		if r.Status != test_history.Passed && r.Status != test_history.Flaky {
+			failed = append(failed, r.Target)
+		}
+	}
+	return failed
+}
+
 func contains(l []string, e string) bool {
 	for _, i := range l {
 		if i == e {
@@ -504,26 +1259,43 @@ func contains(l []string, e string) bool {
 	return false
 }
 
+// The tag that makes a target be notified of changes with an HTTP POST, e.g.
+// ibazel_notify_url=http://localhost:8888/ibazel.
+const notifyURLTagPrefix = "ibazel_notify_url="
+
+// notifyURLTag returns the URL of the ibazel_notify_url tag, if any.
+func notifyURLTag(tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, notifyURLTagPrefix) {
+			return strings.TrimPrefix(tag, notifyURLTagPrefix)
+		}
+	}
+	return ""
+}
+
 func openFileForLogs(fileToOpen string) *os.File {
 	if !*mrunToFiles {
 		return nil
 	}
 
-	reg, err1 := regexp.Compile("[^a-zA-Z0-9-]+")
-	if err1 != nil {
-		println(err1)
-	}
-	processedString := reg.ReplaceAllString(fileToOpen, "")
-	os.MkdirAll("/tmp/running/", os.ModePerm)
-	filename := fmt.Sprintf("/tmp/running/%s.txt", processedString)
-	file, err2 := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-	if err2 != nil {
-		println(err2)
+	file, err := mrun_log.Open(fileToOpen)
+	if err != nil {
+		log.Errorf("Error opening the log file of %s: %v", fileToOpen, err)
 		return nil
 	}
 	return file
 }
 
+// closeLogFiles closes iBazel's end of the mrun log files, see mrun_log.Open.
+func (i *IBazel) closeLogFiles() {
+	for target, file := range i.logFiles {
+		if file != nil {
+			file.Close()
+		}
+		delete(i.logFiles, target)
+	}
+}
+
 func (i *IBazel) setupRun(target string, debugArg []string, argsLength int) command.Command {
 	rule, err := i.queryRule(target)
 	if err != nil {
@@ -533,17 +1305,35 @@ func (i *IBazel) setupRun(target string, debugArg []string, argsLength int) comm
 	i.targetDecider(target, rule)
 
 	commandNotify := false
+	commandSdNotify := false
+	var tags []string
 	for _, attr := range rule.Attribute {
 		if *attr.Name == "tags" && *attr.Type == blaze_query.Attribute_STRING_LIST {
 			if contains(attr.StringListValue, "ibazel_notify_changes") {
 				commandNotify = true
 			}
+			if contains(attr.StringListValue, "ibazel_sd_notify") {
+				commandSdNotify = true
+			}
+			i.restageData = contains(attr.StringListValue, "ibazel_restage_data")
+			if contains(attr.StringListValue, "ibazel_hot_reload") {
+				i.setupHotswap(target)
+			}
+			tags = attr.StringListValue
 		}
 	}
+	i.registerHealthCheck(target, tags)
 
-	if commandNotify {
+	var cmd command.Command
+	if notifyURL := notifyURLTag(tags); notifyURL != "" {
+		log.Logf("Launching with notifications to %s", notifyURL)
+		cmd = commandHTTPNotifyCommand(i.startupArgs, i.bazelArgsFor("run"), target, i.args, notifyURL)
+	} else if commandNotify {
 		log.Logf("Launching with notifications")
-		return commandNotifyCommand(i.startupArgs, i.bazelArgs, target, i.args)
+		cmd = commandNotifyCommand(i.startupArgs, i.bazelArgsFor("run"), target, i.args)
+	} else if commandSdNotify {
+		log.Logf("Launching with NOTIFY_SOCKET")
+		cmd = commandSdNotifyCommand(i.startupArgs, i.bazelArgsFor("run"), target, i.args)
 	} else {
 		// argsLength == -1 when the command is `run`
 		// no need to modify i.args
@@ -552,8 +1342,20 @@ func (i *IBazel) setupRun(target string, debugArg []string, argsLength int) comm
 		} else if argsLength > -1 {
 			i.args = i.args[len(i.args)-argsLength:len(i.args)]
 		}
-		return commandDefaultCommand(i.startupArgs, i.bazelArgs, target, i.args)
+		if spec := blueGreenTag(tags); spec != "" {
+			cmd, err := i.blueGreen(target, spec, tags)
+			if err == nil {
+				return cmd
+			}
+			log.Errorf("Restarting %s in place: %v", target, err)
+		}
+		cmd = commandDefaultCommand(i.startupArgs, i.bazelArgsFor("run"), target, i.args)
 	}
+	// The target runs in place now, on the port its proxy held if the
+	// ibazel_blue_green tag was removed.
+	i.closeProxies(target)
+	i.setRunner(cmd, target, tags)
+	return cmd
 }
 
 func (i *IBazel) run(targets ...string) (*bytes.Buffer, error) {
@@ -565,50 +1367,140 @@ func (i *IBazel) run(targets ...string) (*bytes.Buffer, error) {
 		if err != nil {
 			log.Errorf("Run start failed %v", err)
 		}
+		i.resetHotswap()
 		return outputBuffer, err
 	}
 
+	if i.restageData && i.onlyDataChanged(targets[0]) {
+		log.Logf("Only data files changed, updating the runfiles without restarting")
+		return i.build(targets...)
+	}
+
+	if i.hotswap != nil {
+		if outputBuffer, ok, err := i.hotReload(targets...); ok {
+			return outputBuffer, err
+		}
+	}
+
+	if i.memoryLimitReached(targets[0]) {
+		return nil, nil
+	}
 	log.Logf("Notifying of changes")
 	outputBuffer := i.cmd.AfterRebuild(nil)
+	i.resetHotswap()
 	return outputBuffer, nil
 }
 
+// onlyDataChanged reports whether every file changed since the last command
+// only reaches target through its data attribute, in which case rebuilding
+// the runfiles is enough and the running process can be left alone.
+func (i *IBazel) onlyDataChanged(target string) bool {
+	if len(i.changes) == 0 || i.graphChanged {
+		return false
+	}
+	if i.dataFiles == nil {
+		files, err := i.queryFileSet(fmt.Sprintf(dataQuery, target))
+		if err != nil {
+			log.Errorf("Error finding the data files of %s: %v", target, err)
+			return false
+		}
+		i.dataFiles = files
+	}
+
+	for change := range i.changes {
+		if _, ok := i.dataFiles[change]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func (i *IBazel) runMultiple(targets []string, debugArgs [][]string, argsLength int) ([]*bytes.Buffer, error) {
 	var outputBuffers []*bytes.Buffer
+	results := map[string]*mrunResult{}
+	for target := range i.cmds {
+		results[target] = &mrunResult{}
+	}
+	for _, target := range targets {
+		results[target] = &mrunResult{rebuilt: true}
+	}
+	defer func() {
+		log.Logf("Summary:\n%s", mrunSummary(results))
+	}()
+
 	log.Logf("Rebuilding changed targets")
+	i.lastCommand.started()
+	i.running.set(targets, "build")
 	outputBufferBuild, errBuild := i.build(targets...)
 	i.afterCommand(targets, "build", errBuild == nil, outputBufferBuild)
 	if errBuild != nil {
+		for _, target := range targets {
+			results[target].err = firstError(outputBufferBuild, target)
+			if results[target].err == "" {
+				results[target].err = errBuild.Error()
+			}
+		}
 		return append(outputBuffers, outputBufferBuild), errBuild
 	}
 	i.firstBuildPassed = true
 	if i.cmds == nil {
 		i.cmds = make(map[string]command.Command)
 		i.logFiles = make(map[string]*os.File)
-		// If the commands are empty, we are in our first pass through the state
-		// machine and we need to make command objects.
-		for idx, target := range targets {
-			i.logFiles[target] = openFileForLogs(target)
-			newcommand := i.setupRun(targets[idx], debugArgs[idx], argsLength)
-			i.cmds[target] = newcommand
-			outputBuffer, err := newcommand.Start(i.logFiles[target])
-			outputBuffers = append(outputBuffers, outputBuffer)
-			if err != nil {
-				log.Logf("Run start failed %v", err)
-				return outputBuffers, err
-			}
-		}
-		return outputBuffers, nil
+	} else {
+		log.Logf("Notifying of changes")
 	}
-	log.Logf("Notifying of changes")
-	for _, target := range targets {
-		outputBuffers = append(outputBuffers, i.cmds[target].AfterRebuild(i.logFiles[target]))
+	// The targets that were waiting to start are started with the others.
+	i.pendingStarts = nil
+	i.startTimer = nil
+	started := 0
+	for idx, target := range targets {
+		if i.memoryLimitReached(target) {
+			results[target].err = "not started, over --max_total_rss"
+			continue
+		}
+		if cmd, ok := i.cmds[target]; ok {
+			outputBuffers = append(outputBuffers, cmd.AfterRebuild(i.logFiles[target]))
+			results[target].restarted = true
+			continue
+		}
+		// Targets without a command are run for the first time, either on the
+		// first pass through the state machine or after they were added.
+		var debugArg []string
+		if idx < len(debugArgs) {
+			debugArg = debugArgs[idx]
+		}
+		if n := i.startBatch(); n > 0 && started == n {
+			i.pendingStarts = append(i.pendingStarts, pendingStart{target, debugArg, argsLength})
+			results[target].pending = true
+			continue
+		}
+		started++
+		outputBuffer, err := i.startTarget(target, debugArg, argsLength)
+		outputBuffers = append(outputBuffers, outputBuffer)
+		if err != nil {
+			results[target].err = err.Error()
+			return outputBuffers, err
+		}
+		results[target].restarted = true
 	}
+	i.schedulePendingStarts()
 	return outputBuffers, nil
 }
 
+// startTarget runs target for the first time.
+func (i *IBazel) startTarget(target string, debugArg []string, argsLength int) (*bytes.Buffer, error) {
+	i.logFiles[target] = openFileForLogs(target)
+	newcommand := i.setupRun(target, debugArg, argsLength)
+	i.cmds[target] = newcommand
+	outputBuffer, err := newcommand.Start(i.logFiles[target])
+	if err != nil {
+		log.Logf("Run start failed %v", err)
+	}
+	return outputBuffer, err
+}
+
 func (i *IBazel) queryRule(rule string) (*blaze_query.Rule, error) {
-	b := i.newBazel()
+	b := i.newBazel("query")
 
 	res, err := b.CQuery(rule)
 	if err != nil {
@@ -628,7 +1520,7 @@ func (i *IBazel) queryRule(rule string) (*blaze_query.Rule, error) {
 }
 
 func (i *IBazel) getInfo() (*map[string]string, error) {
-	b := i.newBazel()
+	b := i.newBazel("info")
 
 	res, err := b.Info()
 	if err != nil {
@@ -640,10 +1532,20 @@ func (i *IBazel) getInfo() (*map[string]string, error) {
 }
 
 func (i *IBazel) queryForSourceFiles(query string) ([]string, error) {
-	b := i.newBazel()
+	b := i.newBazel("query")
 
-	res, err := b.Query(query)
+	args, cleanup := queryArgs(query)
+	defer cleanup()
+	res, err := b.Query(args...)
 	if err != nil {
+		if len(buildFileErrors(err)) > 0 {
+			// Fixing the BUILD file makes the query work again.
+			return nil, err
+		}
+		if bazel.IsTimeout(err) {
+			log.Errorf("Bazel query failed: %v. Waiting for the next change...", err)
+			return nil, err
+		}
 		log.Errorf("Bazel query failed: %v", err)
 		i.sigs <- syscall.SIGTERM
 		time.Sleep(10 * time.Second)
@@ -656,20 +1558,26 @@ func (i *IBazel) queryForSourceFiles(query string) ([]string, error) {
 		time.Sleep(10 * time.Second)
 	}
 
+	i.loadIgnores(workspacePath)
+	i.packageRootsFound = map[string]string{}
 	toWatch := make([]string, 0, 10000)
 	for _, target := range res.Target {
 		switch *target.Type {
 		case blaze_query.Target_SOURCE_FILE:
 			label := *target.SourceFile.Name
-			if strings.HasPrefix(label, "@") {
-				continue
-			}
-			if strings.HasPrefix(label, "//external") {
-				continue
+			path, ok := i.sourcePath(workspacePath, label)
+			if !ok {
+				i.querySnapshot.Filter(label, "", "it is in an external repository", query)
+			} else if i.isBuildOutput(workspacePath, path) {
+				i.querySnapshot.Filter(label, path, "it is a build output", query)
+			} else if source, ok := i.ignoredPath(workspacePath, path); ok {
+				i.querySnapshot.Filter(label, path, "it is ignored by "+source, query)
+			} else {
+				if l, ok := parseLabel(label); ok {
+					i.fileIndex.SetPackage(path, l.packageLabel())
+				}
+				toWatch = append(toWatch, path)
 			}
-
-			label = strings.Replace(strings.TrimPrefix(label, "//"), ":", string(filepath.Separator), 1)
-			toWatch = append(toWatch, filepath.Join(workspacePath, label))
 			break
 		default:
 			log.Errorf("%v\n", target)
@@ -679,23 +1587,125 @@ func (i *IBazel) queryForSourceFiles(query string) ([]string, error) {
 	return toWatch, nil
 }
 
-func (i *IBazel) watchFiles(query string, watcher fSNotifyWatcher) {
+// labelToPath converts the label of a source file in the main workspace into
+// its path on disk. Files in external repositories are not converted.
+func labelToPath(workspacePath string, s string) (string, bool) {
+	l, ok := parseLabel(s)
+	if !ok || l.repo != "" {
+		return "", false
+	}
+	if l.pkg == "external" || strings.HasPrefix(l.pkg, "external/") {
+		return "", false
+	}
+	return filepath.Join(workspacePath, l.relPath()), true
+}
+
+// sourceFiles returns the set of source files that target depends on. Unlike
+// queryForSourceFiles, a failing query is not fatal.
+func (i *IBazel) sourceFiles(target string) (map[string]struct{}, error) {
+	return i.queryFileSet(fmt.Sprintf(sourceQuery, target))
+}
+
+// queryFileSet returns the paths of the source files matched by query.
+func (i *IBazel) queryFileSet(query string) (map[string]struct{}, error) {
+	b := i.newBazel("query")
+
+	args, cleanup := queryArgs(query)
+	defer cleanup()
+	res, err := b.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]struct{}{}
+	for _, t := range res.Target {
+		if *t.Type != blaze_query.Target_SOURCE_FILE {
+			continue
+		}
+		if path, ok := i.sourcePath(workspacePath, *t.SourceFile.Name); ok {
+			files[path] = struct{}{}
+		}
+	}
+	return files, nil
+}
+
+func (i *IBazel) watchFiles(query string, targets []string, watcher fSNotifyWatcher) error {
 	toWatch, err := i.queryForSourceFiles(query)
 	if err != nil {
 		// If the query fails, just keep watching the same files as before
-		return
+		i.queryFailed(targets, err)
+		return err
+	}
+	if watcher == i.buildFileWatcher {
+		toWatch = i.withWorkspaceFiles(toWatch)
+	} else if len(toWatch) > *maxWatchedFiles {
+		i.watchTree(query, targets, watcher, toWatch)
+		return nil
+	} else {
+		i.treeMode = false
 	}
 
 	filesFound := map[string]struct{}{}
 	filesWatched := map[string]struct{}{}
 	uniqueDirectories := map[string][]string{}
 
-	i.watcherAdd(query, watcher, toWatch, filesFound, filesWatched, uniqueDirectories)
+	i.fileIndex.Update(i.watcherKind(watcher), i.filesByTarget(targets, watcher, toWatch))
+	i.watcherAdd(query, targets, watcher, toWatch, filesFound, filesWatched, uniqueDirectories)
 
 	i.watcherRemove(uniqueDirectories, watcher, filesWatched)
+	return nil
+}
+
+// filesByTarget splits the files that the query of all of targets returned by
+// target. With several targets each of them is queried on its own, which is
+// quick once Bazel has loaded their packages. The files that no single query
+// returned, e.g. the WORKSPACE file, affect all of the targets, and so do all
+// files if a query fails.
+func (i *IBazel) filesByTarget(targets []string, watcher fSNotifyWatcher, toWatch []string) map[string][]string {
+	byTarget := map[string][]string{}
+	if len(targets) == 1 {
+		byTarget[targets[0]] = toWatch
+		return byTarget
+	}
+
+	query := sourceQuery
+	if watcher == i.buildFileWatcher {
+		query = buildQuery
+	}
+	attributed := map[string]struct{}{}
+	for _, target := range targets {
+		files, err := i.queryFileSet(fmt.Sprintf(query, target))
+		if err != nil {
+			log.Debugf("Error querying the files of %s on its own: %v", target, err)
+			for _, target := range targets {
+				byTarget[target] = toWatch
+			}
+			return byTarget
+		}
+		byTarget[target] = []string{}
+		for _, file := range toWatch {
+			if _, ok := files[file]; ok {
+				byTarget[target] = append(byTarget[target], file)
+				attributed[file] = struct{}{}
+			}
+		}
+	}
+	for _, file := range toWatch {
+		if _, ok := attributed[file]; !ok {
+			for _, target := range targets {
+				byTarget[target] = append(byTarget[target], file)
+			}
+		}
+	}
+	return byTarget
 }
 
-func (i *IBazel) watchManyFiles(query string, targets []string, watcher fSNotifyWatcher, dirStorage *map[string][]string) {
+func (i *IBazel) watchManyFiles(query string, targets []string, watcher fSNotifyWatcher, dirStorage *map[string][]string) error {
 	toWatchByTarget := map[string][]string{}
 	filesFound := map[string]struct{}{}
 	filesWatched := map[string]struct{}{}
@@ -703,23 +1713,43 @@ func (i *IBazel) watchManyFiles(query string, targets []string, watcher fSNotify
 
 	for _, target := range targets {
 		toWatch, err := i.queryForSourceFiles(fmt.Sprintf(query, target))
-		toWatchByTarget[target] = toWatch
 		if err != nil {
 			// If the query fails, just keep watching the same files as before
-			return
+			i.queryFailed(targets, err)
+			return err
+		}
+		if watcher == i.buildFileWatcher {
+			toWatch = i.withWorkspaceFiles(toWatch)
 		}
+		toWatchByTarget[target] = toWatch
 	}
 
 	dirWatchedByTarget(toWatchByTarget, targets, *dirStorage)
+	if watcher == i.sourceFileWatcher {
+		i.mapDependents(toWatchByTarget, targets)
+	}
+	i.fileIndex.Update(i.watcherKind(watcher), toWatchByTarget)
 
 	for _, target := range targets {
-		i.watcherAdd(query, watcher, toWatchByTarget[target], filesFound, filesWatched, uniqueDirectories)
+		i.watcherAdd(fmt.Sprintf(query, target), []string{target}, watcher, toWatchByTarget[target], filesFound, filesWatched, uniqueDirectories)
 	}
 
 	i.watcherRemove(*dirStorage, watcher, filesWatched)
+	return nil
 }
 
-func (i *IBazel) watcherAdd(query string, watcher fSNotifyWatcher, toWatch []string, filesFound map[string]struct{}, filesWatched map[string]struct{}, uniqueDirectories map[string][]string) {
+// watcherKind returns the kind of the files watched by watcher, as recorded in
+// the watch snapshot and the file index.
+func (i *IBazel) watcherKind(watcher fSNotifyWatcher) string {
+	if watcher == i.buildFileWatcher {
+		return "BUILD file"
+	}
+	return "source file"
+}
+
+func (i *IBazel) watcherAdd(query string, targets []string, watcher fSNotifyWatcher, toWatch []string, filesFound map[string]struct{}, filesWatched map[string]struct{}, uniqueDirectories map[string][]string) {
+	kind := i.watcherKind(watcher)
+
 	for _, file := range toWatch {
 		if _, err := os.Stat(file); !os.IsNotExist(err) {
 			filesFound[file] = struct{}{}
@@ -730,6 +1760,7 @@ func (i *IBazel) watcherAdd(query string, watcher fSNotifyWatcher, toWatch []str
 		// Add a watch to the file's parent directory, unless it's one we've already watched
 		if _, ok := uniqueDirectories[parentDirectory]; ok {
 			filesWatched[file] = struct{}{}
+			i.querySnapshot.Watch(file, kind, query, targets)
 		} else {
 			err := watcher.Add(parentDirectory)
 			if err != nil {
@@ -737,9 +1768,11 @@ func (i *IBazel) watcherAdd(query string, watcher fSNotifyWatcher, toWatch []str
 				if !strings.HasSuffix(filepath.ToSlash(file), "/tools/defaults/BUILD") {
 					log.Errorf("Error watching file %q error: %v", file, err)
 				}
+				i.querySnapshot.WatchFailed(file, kind, query, targets, err)
 				continue
 			} else {
 				filesWatched[file] = struct{}{}
+				i.querySnapshot.Watch(file, kind, query, targets)
 				uniqueDirectories[parentDirectory] = []string{}
 			}
 		}
@@ -751,19 +1784,32 @@ func (i *IBazel) watcherAdd(query string, watcher fSNotifyWatcher, toWatch []str
 }
 
 func (i *IBazel) watcherRemove(dirWatched map[string][]string, watcher fSNotifyWatcher, filesWatched map[string]struct{}) {
+	// Remove the watch from the parent directories that no longer contain any
+	// files returned by the latest query, once per directory.
+	toRemove := map[string]struct{}{}
 	for file, _ := range i.filesWatched[watcher] {
 		parentDirectory, _ := filepath.Split(file)
-
-		// Remove the watch from the parent directory if it no longer contains any files returned by the latest query
 		if _, ok := dirWatched[parentDirectory]; !ok {
-			err := watcher.Remove(parentDirectory)
-			if err != nil {
-				log.Errorf("Error unwatching file %q error: %v\n", file, err)
-			}
+			toRemove[parentDirectory] = struct{}{}
+		}
+	}
+
+	failed := map[string]error{}
+	for dir := range toRemove {
+		if err := watcher.Remove(dir); err != nil && !alreadyUnwatched(dir, err) {
+			failed[dir] = err
+		}
+	}
+	if len(failed) > 0 {
+		dirs := make([]string, 0, len(failed))
+		for dir := range failed {
+			dirs = append(dirs, dir)
 		}
+		sort.Strings(dirs)
+		log.Errorf("Error unwatching %d of %d directories, the first is %q: %v", len(failed), len(toRemove), dirs[0], failed[dirs[0]])
 	}
 
-	i.filesWatched[watcher] = filesWatched
+	i.setWatched(watcher, filesWatched)
 }
 
 func dirWatchedByTarget(toWatchByTarget map[string][]string, targets []string, dirStorage map[string][]string) {
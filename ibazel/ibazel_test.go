@@ -16,19 +16,28 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/golang/protobuf/proto"
 
 	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
 	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/control"
+	"github.com/bazelbuild/bazel-watcher/ibazel/hotswap"
 	"github.com/bazelbuild/bazel-watcher/ibazel/log"
 	"github.com/bazelbuild/bazel-watcher/ibazel/workspace_finder"
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
@@ -38,6 +47,10 @@ import (
 
 func init() {
 	log.FakeExit()
+	// Don't write probes into the workspace the tests run in.
+	probeWatchBackend = func(backend, workspacePath string) (time.Duration, error) {
+		return time.Millisecond, nil
+	}
 }
 
 type fakeFSNotifyWatcher struct {
@@ -75,12 +88,13 @@ type mockCommand struct {
 }
 
 func (m *mockCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
-	if m.started {
+	if m.started && !m.terminated {
 		panic("Can't run command twice")
 	}
 	m.started = true
 	return nil, nil
 }
+func (m *mockCommand) BeforeRebuild() {}
 func (m *mockCommand) AfterRebuild(logFile *os.File) *bytes.Buffer {
 	m.notifiedOfChanges = true
 	return nil
@@ -320,7 +334,6 @@ func TestIBazelBuild(t *testing.T) {
 
 	i.build("//path/to:target")
 	expected := [][]string{
-		[]string{"Cancel"},
 		[]string{"WriteToStderr"},
 		[]string{"WriteToStdout"},
 		[]string{"Build", "//path/to:target"},
@@ -329,13 +342,212 @@ func TestIBazelBuild(t *testing.T) {
 	mockBazel.AssertActions(t, expected)
 }
 
+func TestIBazelBuild_command(t *testing.T) {
+	var cmd *mockCommand
+	commandShellCommand = func(shellCommand string, dir string, env []string) command.Command {
+		assertEqual(t, "./serve.sh", shellCommand, "Command")
+		assertEqual(t, []string{"IBAZEL_TARGETS=//path/to:target"}, env[1:], "Env")
+		cmd = &mockCommand{}
+		return cmd
+	}
+	defer func() { commandShellCommand = command.ShellCommand }()
+	flag.Set("command", "./serve.sh")
+	defer flag.Set("command", "")
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	i.buildThenCommand("//path/to:target")
+	mockBazel.AssertActions(t, [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"Build", "//path/to:target"},
+	})
+	if cmd == nil || !cmd.started {
+		t.Fatalf("The command wasn't started after the first build")
+	}
+
+	i.buildThenCommand("//path/to:target")
+	if !cmd.notifiedOfChanges {
+		t.Errorf("The command wasn't restarted after a rebuild")
+	}
+}
+
+func TestIBazelComposite(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	cmd := &mockCommand{}
+	i.cmd = cmd
+
+	var phases []string
+	i.lifecycleListeners = append(i.lifecycleListeners, &phaseRecorder{&phases})
+
+	verbs, err := compositeVerbs("test+run", []string{"//path/to:test", "//path/to:target"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.composite(verbs)("//path/to:test", "//path/to:target")
+	assertEqual(t, []string{
+		"before test //path/to:test",
+		"after test //path/to:test",
+		"before run //path/to:target",
+		"after run //path/to:target",
+	}, phases, "Lifecycle events")
+	if !cmd.notifiedOfChanges {
+		t.Errorf("The run target wasn't notified after the tests passed")
+	}
+}
+
+// phaseRecorder records the commands lifecycle listeners are told about.
+type phaseRecorder struct {
+	phases *[]string
+}
+
+func (p *phaseRecorder) Initialize(info *map[string]string)                                {}
+func (p *phaseRecorder) TargetDecider(rule *blaze_query.Rule)                              {}
+func (p *phaseRecorder) ChangeDetected(targets []string, changeType string, change string) {}
+func (p *phaseRecorder) Cleanup()                                                          {}
+func (p *phaseRecorder) Shutdown(reason string) {
+	*p.phases = append(*p.phases, "shutdown "+reason)
+}
+func (p *phaseRecorder) BeforeCommand(targets []string, command string) {
+	*p.phases = append(*p.phases, fmt.Sprintf("before %s %s", command, strings.Join(targets, " ")))
+}
+func (p *phaseRecorder) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	*p.phases = append(*p.phases, fmt.Sprintf("after %s %s", command, strings.Join(targets, " ")))
+}
+
+// eventRecorder is a listener that wants structured change events.
+type eventRecorder struct {
+	phaseRecorder
+	events []change.Event
+}
+
+func (e *eventRecorder) ChangeDetected(targets []string, changeType string, change string) {
+	panic("ChangeDetected called on a change.Listener")
+}
+func (e *eventRecorder) ChangeEventDetected(event change.Event) {
+	e.events = append(e.events, event)
+}
+
+// legacyRecorder only implements Lifecycle.
+type legacyRecorder struct {
+	phaseRecorder
+	changes []string
+}
+
+func (l *legacyRecorder) ChangeDetected(targets []string, changeType string, change string) {
+	l.changes = append(l.changes, changeType+" "+change)
+}
+
+func TestIBazelChangeDetected(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	var phases []string
+	structured := &eventRecorder{phaseRecorder: phaseRecorder{&phases}}
+	legacy := &legacyRecorder{phaseRecorder: phaseRecorder{&phases}}
+	i.lifecycleListeners = []Lifecycle{structured, legacy}
+
+	targets := []string{"//path/to:target"}
+	i.changeDetected(targets, change.Source, fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Write | fsnotify.Chmod})
+	i.changeDetected(targets, change.Graph, fsnotify.Event{Name: "/ws/BUILD", Op: fsnotify.Create})
+	i.afterCommand(targets, "build", true, nil)
+	i.afterCommand(targets, "build", true, nil)
+	i.changeDetected(targets, change.Source, fsnotify.Event{Name: "/ws/b.go", Op: fsnotify.Remove})
+
+	assertEqual(t, []change.Event{
+		{Type: change.Source, Op: change.Write, Path: "/ws/a.go", Targets: targets, Batch: 0, Index: 0},
+		{Type: change.Graph, Op: change.Create, Path: "/ws/BUILD", Targets: targets, Batch: 0, Index: 1},
+		{Type: change.Source, Op: change.Remove, Path: "/ws/b.go", Targets: targets, Batch: 1, Index: 0},
+	}, structured.events, "Structured events")
+	assertEqual(t, []string{"source /ws/a.go", "graph /ws/BUILD", "source /ws/b.go"}, legacy.changes, "Legacy changes")
+}
+
+// iterationRecorder is a listener that wants iteration IDs.
+type iterationRecorder struct {
+	phaseRecorder
+	ids      []string
+	triggers []string
+}
+
+func (r *iterationRecorder) IterationStarted(id string, trigger string) {
+	r.ids = append(r.ids, id)
+	r.triggers = append(r.triggers, trigger)
+}
+
+func TestIBazelStartIteration(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	defer log.SetIteration("")
+
+	var phases []string
+	recorder := &iterationRecorder{phaseRecorder: phaseRecorder{&phases}}
+	i.lifecycleListeners = []Lifecycle{recorder}
+
+	i.startIteration(triggerStartup)
+	i.startIteration(triggerManual)
+
+	if len(recorder.ids) != 2 || recorder.ids[0] == recorder.ids[1] {
+		t.Errorf("Expected two different iteration IDs, got %v", recorder.ids)
+	}
+	assertEqual(t, recorder.ids[1], i.IterationID(), "Current iteration ID")
+	assertEqual(t, []string{"startup", "manual"}, recorder.triggers, "Triggers")
+	assertEqual(t, "manual", i.IterationTrigger(), "Current trigger")
+}
+
+func TestIBazelIterationTrigger(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	defer log.SetIteration("")
+
+	targets := []string{"//path/to:target"}
+	assertEqual(t, triggerGraphChange, i.debouncedTrigger(triggerGraphChange), "Trigger without changes")
+
+	// The first change of the ones debounced together decides.
+	i.changeDetected(targets, change.Source, fsnotify.Event{Name: "/path/to/foo.go", Op: fsnotify.Write})
+	i.changeDetected(targets, change.Graph, fsnotify.Event{Name: "/path/to/BUILD", Op: fsnotify.Write})
+	assertEqual(t, triggerFileChange, i.debouncedTrigger(triggerGraphChange), "Trigger of a source change")
+	i.startIteration(i.debouncedTrigger(triggerGraphChange))
+	assertEqual(t, triggerFileChange, i.IterationTrigger(), "Current trigger")
+
+	i.changeDetected(targets, change.Graph, fsnotify.Event{Name: "/path/to/BUILD", Op: fsnotify.Write})
+	assertEqual(t, triggerGraphChange, i.debouncedTrigger(triggerFileChange), "Trigger of a graph change")
+}
+
+func TestCompositeVerbs(t *testing.T) {
+	for _, c := range []struct {
+		command string
+		targets []string
+		verbs   []string
+	}{
+		{"build+test", []string{"//..."}, []string{"build", "test"}},
+		{"test+run", []string{"//a:test", "//a:bin"}, []string{"test", "run"}},
+		{"test+run", []string{"//a:bin"}, nil},
+		{"run+test", []string{"//a:bin", "//a:test"}, nil},
+		{"test+mrun", []string{"//a:test", "//a:bin"}, nil},
+	} {
+		verbs, err := compositeVerbs(c.command, c.targets)
+		if c.verbs == nil {
+			if err == nil {
+				t.Errorf("compositeVerbs(%q, %v) should have failed", c.command, c.targets)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("compositeVerbs(%q, %v): %v", c.command, c.targets, err)
+		}
+		assertEqual(t, c.verbs, verbs, c.command)
+	}
+}
+
 func TestIBazelTest(t *testing.T) {
 	i := newIBazel(t)
 	defer i.Cleanup()
 
 	i.test("//path/to:target")
 	expected := [][]string{
-		[]string{"Cancel"},
 		[]string{"WriteToStderr"},
 		[]string{"WriteToStdout"},
 		[]string{"Test", "//path/to:target"},
@@ -344,6 +556,129 @@ func TestIBazelTest(t *testing.T) {
 	mockBazel.AssertActions(t, expected)
 }
 
+func TestIBazelTest_testArgs(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	i.SetTestArgs([]string{"--test_arg=-v", "--test_filter=Foo"})
+	i.SetTestFilter("Bar")
+	i.test("//path/to:target")
+	expected := [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"Test", "--test_arg=-v", "--test_filter=Bar", "//path/to:target"},
+	}
+	mockBazel.AssertActions(t, expected)
+
+	i.SetTestFilter("")
+	i.test("//path/to:target")
+	expected = [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"Test", "--test_arg=-v", "//path/to:target"},
+	}
+	mockBazel.AssertActions(t, expected)
+}
+
+func TestIBazelTest_quarantineSkip(t *testing.T) {
+	flag.Set("quarantine", "//path/to:broken")
+	flag.Set("quarantine_mode", "skip")
+	defer func() {
+		flag.Set("quarantine", "")
+		flag.Set("quarantine_mode", "warn")
+	}()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	i.test("//path/to/...")
+	expected := [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"Test", "--", "//path/to/...", "-//path/to:broken"},
+	}
+
+	mockBazel.AssertActions(t, expected)
+}
+
+func TestIBazelOnlyQuarantinedFailed(t *testing.T) {
+	flag.Set("quarantine", "//path/to:flaky_test")
+	defer flag.Set("quarantine", "")
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	output := bytes.NewBufferString(`//path/to:flaky_test                                       FAILED in 0.3s
+`)
+	assertEqual(t, true, i.onlyQuarantinedFailed(output), "Only the quarantined test failed")
+
+	output = bytes.NewBufferString(`//path/to:flaky_test                                       FAILED in 0.3s
+//path/to:other_test                                       FAILED TO BUILD
+`)
+	assertEqual(t, false, i.onlyQuarantinedFailed(output), "A test that isn't quarantined failed to build")
+
+	// The build events win over the test summary.
+	i.buildEvents = &bep.Result{Targets: []bep.Target{
+		{Label: "//path/to:flaky_test", Success: true, TestStatus: "FAILED"},
+		{Label: "//path/to:lib", Success: true},
+		{Label: "//path/to:other_test", Success: true, TestStatus: "PASSED"},
+	}}
+	assertEqual(t, true, i.onlyQuarantinedFailed(output), "Only the quarantined test failed according to the build events")
+
+	i.buildEvents.Targets[1].Success = false
+	assertEqual(t, false, i.onlyQuarantinedFailed(output), "A target that isn't quarantined failed to build according to the build events")
+}
+
+func TestIBazelCleanup_closesLogFiles(t *testing.T) {
+	i := newIBazel(t)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	i.logFiles = map[string]*os.File{"//path/to:target": w}
+
+	i.Cleanup()
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Errorf("Reading the log pipe: %v", err)
+	}
+	assertEqual(t, 0, len(i.logFiles), "Log files after Cleanup")
+}
+
+func TestIBazelMobileInstall(t *testing.T) {
+	oldDeviceLogs := *deviceLogs
+	*deviceLogs = false
+	defer func() { *deviceLogs = oldDeviceLogs }()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	i.mobileInstall("//path/to:target")
+	mockBazel.AssertActions(t, [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"MobileInstall", "--start_app", "//path/to:target"},
+	})
+
+	i.SetBazelArgs([]string{"--start=WARM"})
+	i.mobileInstall("//path/to:target")
+	mockBazel.AssertActions(t, [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"MobileInstall", "//path/to:target"},
+	})
+
+	// Other flags that start with --start don't pick the start mode.
+	i.SetBazelArgs([]string{"--start_timeout=10"})
+	i.mobileInstall("//path/to:target")
+	mockBazel.AssertActions(t, [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"MobileInstall", "--start_app", "//path/to:target"},
+	})
+}
+
 func TestIBazelRun_notifyPreexistiingJobWhenStarting(t *testing.T) {
 	commandDefaultCommand = func(startupArgs []string, bazelArgs []string, target string, args []string) command.Command {
 		assertEqual(t, startupArgs, []string{}, "Startup args")
@@ -372,6 +707,221 @@ func TestIBazelRun_notifyPreexistiingJobWhenStarting(t *testing.T) {
 	}
 }
 
+func TestIBazelRun_restageData(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	cmd := &mockCommand{}
+	i.cmd = cmd
+	i.restageData = true
+	i.dataFiles = map[string]struct{}{"/ws/static/index.html": struct{}{}}
+
+	i.changeDetected([]string{"//path/to:target"}, change.Source, fsnotify.Event{Name: "/ws/static/index.html", Op: fsnotify.Write})
+	i.run("//path/to:target")
+	mockBazel.AssertActions(t, [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"Build", "//path/to:target"},
+	})
+	if cmd.notifiedOfChanges {
+		t.Errorf("The command was restarted although only data files changed")
+	}
+	i.afterCommand([]string{"//path/to:target"}, "run", true, nil)
+
+	i.changeDetected([]string{"//path/to:target"}, change.Source, fsnotify.Event{Name: "/ws/static/index.html", Op: fsnotify.Write})
+	i.changeDetected([]string{"//path/to:target"}, change.Source, fsnotify.Event{Name: "/ws/main.go", Op: fsnotify.Write})
+	i.run("//path/to:target")
+	if !cmd.notifiedOfChanges {
+		t.Errorf("The command wasn't restarted after a compiled source changed")
+	}
+}
+
+func TestIBazelRun_hotReload(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	dir, err := ioutil.TempDir("", "ibazel_hotswap_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := &mockCommand{started: true}
+	i.cmd = cmd
+	i.hotswap = hotswap.New(dir, func() ([]string, error) { return nil, nil })
+
+	i.run("//path/to:target")
+	mockBazel.AssertActions(t, [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"Build", "//path/to:target"},
+	})
+	if cmd.notifiedOfChanges {
+		t.Errorf("The command was restarted instead of hot reloaded")
+	}
+
+	// A failed build is reported and keeps the old process running.
+	oldBazelNew := bazelNew
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.BuildError(errors.New("compile error"))
+		return b
+	}
+	_, err = i.run("//path/to:target")
+	bazelNew = oldBazelNew
+	if err == nil {
+		t.Errorf("The failed build of a hot reload wasn't reported")
+	}
+	if cmd.notifiedOfChanges {
+		t.Errorf("The command was restarted after a failed build")
+	}
+
+	// Changes to the build graph always restart.
+	i.changeDetected([]string{"//path/to:target"}, change.Graph, fsnotify.Event{Name: "/ws/BUILD", Op: fsnotify.Write})
+	i.run("//path/to:target")
+	if !cmd.notifiedOfChanges {
+		t.Errorf("The command wasn't restarted after the build graph changed")
+	}
+}
+
+func TestNotifyURLTag(t *testing.T) {
+	for _, c := range []struct {
+		tags []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"ibazel_notify_changes"}, ""},
+		{[]string{"manual", "ibazel_notify_url=http://localhost:8888/ibazel"}, "http://localhost:8888/ibazel"},
+	} {
+		assertEqual(t, c.want, notifyURLTag(c.tags), fmt.Sprintf("notifyURLTag(%v)", c.tags))
+	}
+}
+
+func TestIBazelRestartTarget(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	a := &mockCommand{started: true}
+	b := &mockCommand{}
+	i.cmds = map[string]command.Command{
+		"//path/to:a": a,
+		"//path/to:b": b,
+	}
+	i.logFiles = map[string]*os.File{}
+	i.state = WAIT
+
+	go i.RestartTarget("//path/to:a")
+	i.iterationMultiple("run", i.runMultiple, []string{"//path/to:a", "//path/to:b"}, nil, 0)
+	if !a.terminated || !a.started {
+		t.Errorf("//path/to:a wasn't restarted")
+	}
+	if b.started {
+		t.Errorf("//path/to:b was started although only //path/to:a was restarted")
+	}
+	assertEqual(t, WAIT, i.state, "State")
+
+	a.terminated = false
+	go i.RestartTarget("")
+	i.iterationMultiple("run", i.runMultiple, []string{"//path/to:a", "//path/to:b"}, nil, 0)
+	if !a.terminated || !b.started {
+		t.Errorf("Not every target was restarted")
+	}
+
+	// Requests made while the main loop is busy don't block and are merged.
+	a.terminated, b.terminated = false, false
+	i.RestartTarget("//path/to:a")
+	i.RestartTarget("//path/to:b")
+	i.RestartTarget("//path/to:a")
+	i.iterationMultiple("run", i.runMultiple, []string{"//path/to:a", "//path/to:b"}, nil, 0)
+	if !a.terminated || !b.terminated {
+		t.Errorf("Not every requested target was restarted")
+	}
+	assertEqual(t, 0, len(i.restarts), "Pending wake ups")
+}
+
+func TestIBazelEditTargets(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	i.targets = []string{"//path/to:a"}
+	i.state = WAIT
+
+	// The edits don't wait for the main loop.
+	if err := i.AddTarget("//path/to:b", "//path/to:a"); err != nil {
+		t.Errorf("AddTarget: %v", err)
+	}
+	i.iteration("build", i.build, i.targets, "//path/to:a")
+	assertEqual(t, []string{"//path/to:a", "//path/to:b"}, i.targets, "Targets after AddTarget")
+	assertEqual(t, QUERY, i.state, "State")
+
+	i.state = WAIT
+	if err := i.RemoveTarget("//path/to:a"); err != nil {
+		t.Errorf("RemoveTarget: %v", err)
+	}
+	i.iteration("build", i.build, i.targets, "//path/to:a //path/to:b")
+	assertEqual(t, []string{"//path/to:b"}, i.targets, "Targets after RemoveTarget")
+
+	for _, target := range []string{"//path/to:b", "//path/to:c"} {
+		i.state = WAIT
+		if err := i.RemoveTarget(target); err != nil {
+			t.Errorf("RemoveTarget: %v", err)
+		}
+		i.iteration("build", i.build, i.targets, "//path/to:b")
+		assertEqual(t, WAIT, i.state, "State after a failed edit")
+	}
+	assertEqual(t, []string{"//path/to:b"}, i.targets, "Targets after failed edits")
+
+	if err := i.AddTarget("//path/to:c"); err != nil {
+		t.Errorf("AddTarget: %v", err)
+	}
+	i.iteration("run", i.run, i.targets, "//path/to:b")
+	assertEqual(t, []string{"//path/to:b"}, i.targets, "Targets after changing the targets of `ibazel run`")
+
+	if err := i.AddTarget("path/to:c"); err == nil {
+		t.Errorf("Expected an error adding an invalid target")
+	}
+	// Checking the targets of an edit doesn't write into the slices of it.
+	add := make([]string, 1, 2)
+	add[0] = "//path/to:d"
+	i.editTargets(targetEdit{add: add, remove: []string{"//path/to:b"}})
+	assertEqual(t, "", add[:2][1], "Spare capacity of the added targets")
+	for n := 0; n < targetEditBuffer; n++ {
+		i.AddTarget("//path/to:c")
+	}
+	if err := i.AddTarget("//path/to:c"); err != control.ErrBusy {
+		t.Errorf("AddTarget while the main loop is busy = %v, want %v", err, control.ErrBusy)
+	}
+}
+
+// removingWatcher fails to remove the directories that were never watched.
+type removingWatcher struct {
+	fakeFSNotifyWatcher
+	removed []string
+}
+
+func (w *removingWatcher) Remove(name string) error {
+	w.removed = append(w.removed, name)
+	return fmt.Errorf("can't remove non-existent inotify watch for: %s", name)
+}
+
+func TestIBazelWatcherRemove(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	w := &removingWatcher{}
+	i.filesWatched[w] = map[string]struct{}{
+		"/path/a/one.go": struct{}{},
+		"/path/a/two.go": struct{}{},
+		"/path/b/one.go": struct{}{},
+	}
+	filesWatched := map[string]struct{}{"/path/b/one.go": struct{}{}}
+	i.watcherRemove(map[string][]string{"/path/b/": nil}, w, filesWatched)
+
+	assertEqual(t, []string{"/path/a/"}, w.removed, "Removed directories")
+	assertEqual(t, filesWatched, i.filesWatched[w], "Watched files")
+	assertEqual(t, true, alreadyUnwatched("/path/a/", syscall.EINVAL), "EINVAL")
+}
+
 func TestHandleSignals_SIGINTWithoutRunningCommand(t *testing.T) {
 	i := &IBazel{}
 	err := i.setup()
@@ -452,6 +1002,9 @@ func TestHandleSignals_SIGTERM(t *testing.T) {
 	}
 	attemptedExit = false
 
+	phases := []string{}
+	i.lifecycleListeners = []Lifecycle{&phaseRecorder{&phases}}
+
 	cmd := &mockCommand{}
 	cmd.Start(nil)
 	i.cmd = cmd
@@ -461,4 +1014,32 @@ func TestHandleSignals_SIGTERM(t *testing.T) {
 	cmd.assertTerminated(t)
 
 	assertEqual(t, attemptedExit, true, "Should have exited ibazel")
+	assertEqual(t, []string{"shutdown SIGTERM"}, phases, "Lifecycle events")
+}
+
+func TestHandleSignals_SIGTERM_noTTY(t *testing.T) {
+	defer setFlag(t, "no_tty", "true")()
+
+	i := &IBazel{}
+	err := i.setup()
+	if err != nil {
+		t.Errorf("Error creating IBazel: %s", err)
+	}
+	i.sigs = make(chan os.Signal, 1)
+	defer i.Cleanup()
+
+	exitCode := -1
+	osExit = func(i int) {
+		exitCode = i
+	}
+
+	cmd := &mockCommand{}
+	cmd.Start(nil)
+	i.cmd = cmd
+
+	i.sigs <- syscall.SIGTERM
+	i.handleSignals()
+	cmd.assertTerminated(t)
+
+	assertEqual(t, 0, exitCode, "Exit code")
 }
@@ -0,0 +1,119 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"sort"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var (
+	idleAfter   = flag.Duration("idle_after", 0, "Go idle after this long without changes, e.g. 30m: stop the health checks, requeries and other periodic work until the next change. 0 disables")
+	idleSuspend = flag.Bool("idle_suspend", false, "Also suspend the targets of `ibazel run` and `ibazel mrun` while idle, see --idle_after, and resume them on the next change. Not supported on Windows")
+)
+
+// suspendCommand stops cmd and its subprocesses, or lets them continue.
+var suspendCommand = func(cmd command.Command, suspend bool) error {
+	pid := command.Pid(cmd)
+	if pid == 0 {
+		return nil
+	}
+	return suspendProcessGroup(pid, suspend)
+}
+
+// idleState is whether iBazel went idle, see --idle_after, since when, and
+// the targets it suspended.
+type idleState struct {
+	active    bool
+	since     time.Time
+	suspended []string
+}
+
+// scheduleIdle starts waiting for --idle_after without changes.
+func (i *IBazel) scheduleIdle() {
+	if *idleAfter <= 0 {
+		i.idleTimer = nil
+		return
+	}
+	i.idleTimer = time.After(*idleAfter)
+}
+
+// goIdle stops the periodic work of the main loop, and suspends the running
+// targets with --idle_suspend, until wake is called for the next change.
+func (i *IBazel) goIdle() {
+	log.Logf("No changes for %s, going idle until the next one", *idleAfter)
+	i.idle = idleState{active: true, since: time.Now()}
+	i.idleTimer = nil
+	i.healthTimer = nil
+	i.healthResults = nil
+	i.requeryTimer = nil
+	if !*idleSuspend {
+		return
+	}
+	commands := i.runningCommands()
+	targets := make([]string, 0, len(commands))
+	for target := range commands {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		if !commands[target].IsSubprocessRunning() {
+			continue
+		}
+		if err := suspendCommand(commands[target], true); err != nil {
+			log.Errorf("Error suspending %s: %v", i.targetName(target), err)
+			continue
+		}
+		i.idle.suspended = append(i.idle.suspended, target)
+	}
+	if len(i.idle.suspended) > 0 {
+		log.Logf("Suspended %d running targets", len(i.idle.suspended))
+	}
+}
+
+// wake ends the idle mode, if iBazel went idle, and restarts the wait for
+// the next one. It is called for every change, so that iBazel goes idle
+// --idle_after the last one.
+func (i *IBazel) wake() {
+	defer i.scheduleIdle()
+	if !i.idle.active {
+		return
+	}
+	commands := i.runningCommands()
+	for _, target := range i.idle.suspended {
+		if cmd, ok := commands[target]; ok {
+			if err := suspendCommand(cmd, false); err != nil {
+				log.Errorf("Error resuming %s: %v", i.targetName(target), err)
+			}
+		}
+	}
+	log.Logf("Waking up after being idle for %s", time.Since(i.idle.since).Round(time.Second))
+	i.idle = idleState{}
+	i.scheduleHealthChecks()
+	i.scheduleRequery()
+}
+
+// targetName returns target, or the target of `ibazel run`, which
+// runningCommands lists as "".
+func (i *IBazel) targetName(target string) string {
+	if target == "" && len(i.targets) > 0 {
+		return i.targets[0]
+	}
+	return target
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+)
+
+func TestIBazelIdle(t *testing.T) {
+	defer setFlag(t, "idle_after", "30m")()
+	defer setFlag(t, "idle_suspend", "true")()
+
+	var calls []string
+	a := &mockCommand{started: true}
+	b := &mockCommand{started: false}
+	oldSuspendCommand := suspendCommand
+	defer func() { suspendCommand = oldSuspendCommand }()
+	suspendCommand = func(cmd command.Command, suspend bool) error {
+		calls = append(calls, fmt.Sprintf("%v %v", cmd == a, suspend))
+		return nil
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.cmds = map[string]command.Command{"//path/to:a": a, "//path/to:b": b}
+	i.healthTimer = make(chan time.Time)
+	i.requeryTimer = make(chan time.Time)
+	if i.idleTimer == nil {
+		t.Errorf("Not waiting to go idle")
+	}
+
+	i.goIdle()
+	assertEqual(t, true, i.idle.active, "Idle")
+	assertEqual(t, []string{"//path/to:a"}, i.idle.suspended, "Suspended targets")
+	assertEqual(t, []string{"true true"}, calls, "Suspended commands")
+	if i.healthTimer != nil || i.requeryTimer != nil || i.idleTimer != nil {
+		t.Errorf("Timers are still running while idle")
+	}
+
+	i.startIteration(triggerFileChange)
+	assertEqual(t, false, i.idle.active, "Idle after a change")
+	assertEqual(t, []string{"true true", "true false"}, calls, "Resumed commands")
+	if i.idleTimer == nil {
+		t.Errorf("Not waiting to go idle again")
+	}
+}
+
+func TestIBazelIdleAfterLastChange(t *testing.T) {
+	defer setFlag(t, "idle_after", "30m")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	timer := i.idleTimer
+	i.startIteration(triggerRevalidation)
+	if i.idleTimer != timer {
+		t.Errorf("An iteration that no change started restarted the wait to go idle")
+	}
+	i.changeDetected(nil, change.Source, fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Write})
+	if i.idleTimer == timer {
+		t.Errorf("A change didn't restart the wait to go idle")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ignore matches paths in the workspace against the directories of
+// its .bazelignore and the gitignore style patterns of its .ibazelignore, so
+// that iBazel neither watches them nor rebuilds when they change.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// BazelIgnore lists directories Bazel doesn't look into, one per line.
+	BazelIgnore = ".bazelignore"
+	// IBazelIgnore lists gitignore style patterns of files iBazel ignores.
+	IBazelIgnore = ".ibazelignore"
+)
+
+type rule struct {
+	// Where the rule comes from, e.g. ".ibazelignore:3".
+	source string
+	// The slash separated segments of the pattern.
+	segments []string
+	// Whether the pattern is matched against the whole path rather than
+	// against every name in it, because it contains a slash.
+	anchored bool
+	// Whether the pattern only matches directories, because it ends in one.
+	dirOnly bool
+	// Whether the pattern re-includes what earlier patterns ignored.
+	negate bool
+}
+
+// Matcher matches paths against the rules of the ignore files. A nil Matcher
+// ignores nothing.
+type Matcher struct {
+	rules []rule
+}
+
+// Load reads the ignore files of the workspace. It returns nil if neither
+// exists.
+func Load(workspace string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, f := range []struct {
+		name  string
+		parse func(string, io.Reader) ([]rule, error)
+	}{
+		{BazelIgnore, parseBazelIgnore},
+		{IBazelIgnore, parseIBazelIgnore},
+	} {
+		file, err := os.Open(filepath.Join(workspace, f.name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rules, err := f.parse(f.name, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, rules...)
+	}
+	if len(m.rules) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// lines calls fn with every line of r that isn't empty or a comment.
+func lines(r io.Reader, fn func(n int, line string) error) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseBazelIgnore parses the directories of a .bazelignore, which are
+// relative to the workspace and not patterns.
+func parseBazelIgnore(name string, r io.Reader) ([]rule, error) {
+	var rules []rule
+	err := lines(r, func(n int, line string) error {
+		dir := strings.Trim(path.Clean(strings.TrimSpace(line)), "/")
+		if dir == "" || dir == "." {
+			return nil
+		}
+		segments := strings.Split(dir, "/")
+		for i, s := range segments {
+			// Escape the characters a pattern would treat specially.
+			for _, c := range []string{`\`, "*", "?", "["} {
+				s = strings.Replace(s, c, `\`+c, -1)
+			}
+			segments[i] = s
+		}
+		rules = append(rules, rule{source: fmt.Sprintf("%s:%d", name, n), segments: segments, anchored: true})
+		return nil
+	})
+	return rules, err
+}
+
+// parseIBazelIgnore parses the patterns of an .ibazelignore, which work like
+// those of a .gitignore at the root of the workspace.
+func parseIBazelIgnore(name string, r io.Reader) ([]rule, error) {
+	var rules []rule
+	err := lines(r, func(n int, line string) error {
+		rl := rule{source: fmt.Sprintf("%s:%d", name, n)}
+		if strings.HasPrefix(line, "!") {
+			rl.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rl.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rl.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			return nil
+		}
+		rl.segments = strings.Split(line, "/")
+		for _, s := range rl.segments {
+			if _, err := path.Match(s, ""); err != nil {
+				return fmt.Errorf("%s: %q isn't a valid pattern: %v", rl.source, line, err)
+			}
+		}
+		rules = append(rules, rl)
+		return nil
+	})
+	return rules, err
+}
+
+// matches reports whether the rule matches the slash separated path.
+func (r rule) matches(segments []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.segments[0], segments[len(segments)-1])
+		return ok
+	}
+	return matchSegments(r.segments, segments)
+}
+
+// matchSegments matches a pattern against a path segment by segment, where
+// "**" matches any number of segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// match returns the last rule matching the path, which decides whether it is
+// ignored.
+func (m *Matcher) match(segments []string, isDir bool) (rule, bool) {
+	var last rule
+	found := false
+	for _, r := range m.rules {
+		if r.matches(segments, isDir) {
+			last, found = r, true
+		}
+	}
+	return last, found
+}
+
+// Ignores reports whether the file at rel, relative to the workspace and
+// slash separated, is ignored, and by which line of which file. Like with
+// git, a file in an ignored directory can't be re-included.
+func (m *Matcher) Ignores(rel string) (string, bool) {
+	if m == nil || rel == "" {
+		return "", false
+	}
+	segments := strings.Split(rel, "/")
+	for i := 1; i <= len(segments); i++ {
+		isDir := i < len(segments)
+		if r, ok := m.match(segments[:i], isDir); ok && !r.negate {
+			return r.source, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func matcher(t *testing.T, bazelIgnore, ibazelIgnore string) *Matcher {
+	m := &Matcher{}
+	rules, err := parseBazelIgnore(BazelIgnore, strings.NewReader(bazelIgnore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.rules = append(m.rules, rules...)
+	if rules, err = parseIBazelIgnore(IBazelIgnore, strings.NewReader(ibazelIgnore)); err != nil {
+		t.Fatal(err)
+	}
+	m.rules = append(m.rules, rules...)
+	return m
+}
+
+func TestIgnores(t *testing.T) {
+	m := matcher(t, "# Generated\nnode_modules\n/third_party/big/\n", `
+*.swp
+*~
+.#*
+build/
+/dist
+docs/**/*.png
+**/testdata/golden
+*.log
+!keep.log
+vendor/
+!vendor/keep.go
+`)
+	for _, c := range []struct {
+		path   string
+		source string
+	}{
+		{"main.go", ""},
+		{"node_modules/react/index.js", ".bazelignore:2"},
+		{"web/node_modules/x.js", ""},
+		{"third_party/big/a.c", ".bazelignore:3"},
+		{"third_party/bigger/a.c", ""},
+		{"pkg/.main.go.swp", ".ibazelignore:2"},
+		{"pkg/main.go~", ".ibazelignore:3"},
+		{"pkg/.#main.go", ".ibazelignore:4"},
+		{"pkg/build/out.js", ".ibazelignore:5"},
+		{"pkg/build", ""},
+		{"dist/app.js", ".ibazelignore:6"},
+		{"pkg/dist/app.js", ""},
+		{"docs/a/b/c.png", ".ibazelignore:7"},
+		{"docs/c.png", ".ibazelignore:7"},
+		{"docs/c.md", ""},
+		{"a/b/testdata/golden/out.txt", ".ibazelignore:8"},
+		{"x/debug.log", ".ibazelignore:9"},
+		{"x/keep.log", ""},
+		// A file in an ignored directory can't be re-included.
+		{"vendor/keep.go", ".ibazelignore:11"},
+	} {
+		source, ok := m.Ignores(c.path)
+		if ok != (c.source != "") || source != c.source {
+			t.Errorf("Ignores(%q) = %q, %v, wanted %q", c.path, source, ok, c.source)
+		}
+	}
+}
+
+func TestParseIBazelIgnore_invalid(t *testing.T) {
+	if _, err := parseIBazelIgnore(IBazelIgnore, strings.NewReader("ok\n[\n")); err == nil {
+		t.Error("An invalid pattern should fail")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Load(dir)
+	if err != nil || m != nil {
+		t.Errorf("Load without ignore files = %v, %v, wanted nil", m, err)
+	}
+	if _, ok := m.Ignores("a.go"); ok {
+		t.Error("A nil Matcher shouldn't ignore anything")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, IBazelIgnore), []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Ignores("a/b.tmp"); !ok {
+		t.Error("a/b.tmp should be ignored")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/bazelbuild/bazel-watcher/ibazel/ignore"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// loadIgnores reads the .bazelignore and .ibazelignore of the workspace. It
+// runs before every query, and since both are watched like the WORKSPACE
+// file, a change to them requeries. Ignore files that can't be read leave the
+// previous ones in place.
+func (i *IBazel) loadIgnores(workspacePath string) {
+	m, err := ignore.Load(workspacePath)
+	if err != nil {
+		log.Errorf("Error reading the ignore files: %v", err)
+		return
+	}
+	i.ignores = m
+}
+
+// ignoredPath returns the line of the ignore file that ignores path, and
+// false if it isn't ignored.
+func (i *IBazel) ignoredPath(workspacePath string, path string) (string, bool) {
+	rel, ok := workspaceRel(workspacePath, path)
+	if !ok {
+		return "", false
+	}
+	return i.ignores.Ignores(rel)
+}
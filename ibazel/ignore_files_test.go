@@ -0,0 +1,50 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIBazelIgnoreFiles(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_ignore_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	if err := ioutil.WriteFile(filepath.Join(workspace, ".bazelignore"), []byte("node_modules\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workspace, ".ibazelignore"), []byte("*.swp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	i.loadIgnores(workspace)
+
+	source, ok := i.ignoredPath(workspace, filepath.Join(workspace, "node_modules", "x", "index.js"))
+	assertEqual(t, true, ok, "node_modules is ignored")
+	assertEqual(t, ".bazelignore:1", source, "What ignores node_modules")
+	_, ok = i.ignoredPath(workspace, filepath.Join(workspace, "main.go"))
+	assertEqual(t, false, ok, "main.go is ignored")
+
+	assertEqual(t, false, i.passesWatchFilter(filepath.Join(workspace, "pkg", ".main.go.swp")), "Editor swap file passes")
+	assertEqual(t, true, i.passesWatchFilter(filepath.Join(workspace, "pkg", "main.go")), "Source file passes")
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// inflightBazel is the Bazel command that runs in the loop, for cancelling it
+// from another goroutine.
+type inflightBazel struct {
+	mu sync.Mutex
+	b  bazel.Bazel
+}
+
+// track makes b the command that runs until the returned function is called.
+func (f *inflightBazel) track(b bazel.Bazel) func() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.b = b
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.b == b {
+			f.b = nil
+		}
+	}
+}
+
+// cancel interrupts the command that runs, if any, and waits until the Bazel
+// server is done with it, so that it doesn't keep the server busy once iBazel
+// exited.
+func (f *inflightBazel) cancel() {
+	f.mu.Lock()
+	b := f.b
+	f.mu.Unlock()
+	if b == nil {
+		return
+	}
+	log.Logf("Cancelling the running Bazel command")
+	b.Cancel()
+}
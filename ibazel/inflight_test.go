@@ -0,0 +1,35 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+func TestIBazelShutdownCancelsInflight(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	b := &mock_bazel.MockBazel{}
+	done := i.inflight.track(b)
+	i.shutdown("SIGTERM")
+	b.AssertActions(t, [][]string{{"Cancel"}})
+
+	done()
+	i.shutdown("SIGTERM")
+	b.AssertActions(t, [][]string{{"Cancel"}})
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/output_runner"
+	"github.com/fsnotify/fsnotify"
+)
+
+// newIterationID returns a short ID that is unique enough to tell the
+// iterations of a session, and of concurrent sessions, apart.
+func newIterationID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", uint32(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Why an iteration started. They are given to lifecycle listeners and printed
+// by --machine_output and --status_line, so they can't change.
+const (
+	// iBazel just started.
+	triggerStartup = "startup"
+	// A watched source file changed.
+	triggerFileChange = "file_change"
+	// A BUILD file, or a directory of the packages, changed.
+	triggerGraphChange = "graph_change"
+	// Someone asked for it, e.g. with a key or by restarting a target.
+	triggerManual = "manual"
+	// A command of .bazel_fix_commands.json changed a watched file.
+	triggerFixCommand = "fix_command"
+	// --requery_interval queried for the files to watch again.
+	triggerRevalidation = "revalidation"
+	// Something iBazel watches over stopped working and was restarted, e.g. a
+	// target failing --health_check_restart checks or the file watchers.
+	triggerCrashRestart = "crash_restart"
+)
+
+// IterationID returns the ID of the current iteration. It is printed on every
+// log line and given to lifecycle listeners that implement
+// IterationListener, so that external tools can match a change with its
+// build, its diagnostics and its restart.
+func (i *IBazel) IterationID() string {
+	i.iterationLock.Lock()
+	defer i.iterationLock.Unlock()
+	return i.iterationID
+}
+
+// IterationTrigger returns why the current iteration started, e.g.
+// "file_change".
+func (i *IBazel) IterationTrigger() string {
+	i.iterationLock.Lock()
+	defer i.iterationLock.Unlock()
+	return i.iterationTrigger
+}
+
+// startIteration is called whenever the main loop is done collecting changes
+// and is about to act on them, or acts without any change, with why it does.
+func (i *IBazel) startIteration(trigger string) {
+	// Changes restart the wait to go idle, see changeDetected, other
+	// triggers only end it, e.g. a rebuild asked for while idle.
+	if i.idle.active {
+		i.wake()
+	}
+	id := newIterationID()
+	i.iterationLock.Lock()
+	i.iterationID = id
+	i.iterationTrigger = trigger
+	i.iterationLock.Unlock()
+	i.pendingTrigger = ""
+
+	log.SetIteration(id)
+	log.Debugf("Iteration started by %s", trigger)
+	i.recordEvent("iteration %s started by %s", id, trigger)
+	for _, l := range i.lifecycleListeners {
+		if il, ok := l.(IterationListener); ok {
+			i.callListener(l, "IterationStarted", func() { il.IterationStarted(id, trigger) })
+		}
+	}
+}
+
+// debouncedTrigger returns why the changes that were just debounced start an
+// iteration, fallback if none of them said.
+func (i *IBazel) debouncedTrigger(fallback string) string {
+	if i.pendingTrigger == "" {
+		return fallback
+	}
+	return i.pendingTrigger
+}
+
+// changeTrigger returns why a change to a watched file starts an iteration.
+// Changes made while a fix command ran are most likely its own.
+func changeTrigger(changeType change.Type, e fsnotify.Event) string {
+	if info, err := os.Stat(e.Name); err == nil && output_runner.FixedAt(info.ModTime()) {
+		return triggerFixCommand
+	}
+	if changeType == change.Graph {
+		return triggerGraphChange
+	}
+	return triggerFileChange
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package junit collects the test.xml files written by `bazel test` into a
+// single JUnit report per iteration for IDEs and dashboards. The build events
+// of the test command say where the files are, see the bep package.
+package junit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/test_history"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var outputDir = flag.String("junit_output_dir", "", "Directory to collect the JUnit XML reports of every test iteration into. Disabled when empty")
+
+// The name of the merged report in every iteration's directory.
+const mergedReport = "merged.xml"
+
+var unsafeChars = regexp.MustCompile(`[^\w\-.]+`)
+
+type JUnit struct {
+	// Where Bazel writes test logs, from `bazel info bazel-testlogs`.
+	testlogs string
+	// The test.xml files of every test of the last test command by label,
+	// from its build events. nil if there were none.
+	reports map[string][]string
+
+	mu     sync.Mutex
+	latest string
+}
+
+// OutputDir returns the directory given with --junit_output_dir, or "".
+func OutputDir() string {
+	return *outputDir
+}
+
+func New() *JUnit {
+	return &JUnit{}
+}
+
+func (j *JUnit) Initialize(info *map[string]string) {
+	if info != nil {
+		j.testlogs = (*info)["bazel-testlogs"]
+	}
+	if *outputDir != "" {
+		bep.Require()
+	}
+}
+
+func (j *JUnit) TargetDecider(rule *blaze_query.Rule) {}
+
+func (j *JUnit) ChangeDetected(targets []string, changeType string, change string) {}
+
+func (j *JUnit) BeforeCommand(targets []string, command string) {}
+
+// BuildEventsReceived implements the BuildEventListener interface of iBazel.
+func (j *JUnit) BuildEventsReceived(targets []string, command string, result *bep.Result) {
+	if command != "test" {
+		return
+	}
+	j.reports = map[string][]string{}
+	for _, t := range result.Targets {
+		if len(t.TestXML) > 0 {
+			j.reports[t.Label] = t.TestXML
+		}
+	}
+}
+
+func (j *JUnit) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	reports := j.reports
+	j.reports = nil
+	if *outputDir == "" || command != "test" {
+		return
+	}
+	if reports == nil {
+		// The build events couldn't be read, fall back to the test summary.
+		var err error
+		if reports, err = j.findReports(output); err != nil {
+			log.Errorf("Not collecting JUnit reports: %v", err)
+			return
+		}
+	}
+
+	dir := filepath.Join(*outputDir, time.Now().Format("20060102-150405.000"))
+	if err := j.collect(dir, reports); err != nil {
+		log.Errorf("Error collecting JUnit reports: %v", err)
+		return
+	}
+
+	j.mu.Lock()
+	j.latest = dir
+	j.mu.Unlock()
+	log.Logf("JUnit report written to %s", filepath.Join(dir, mergedReport))
+}
+
+func (j *JUnit) Cleanup() {}
+
+// Latest returns the directory of the most recent report, or "" if none has
+// been written yet.
+func (j *JUnit) Latest() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.latest
+}
+
+// findReports finds the test.xml files of the tests in the test summary of
+// output in bazel-testlogs.
+func (j *JUnit) findReports(output *bytes.Buffer) (map[string][]string, error) {
+	if output == nil {
+		return nil, fmt.Errorf("the command has no output")
+	}
+	if j.testlogs == "" {
+		return nil, fmt.Errorf("the bazel-testlogs directory is unknown")
+	}
+	reports := map[string][]string{}
+	for _, r := range test_history.ParseResults(output) {
+		files, err := testXMLFiles(j.testlogs, r.Target)
+		if err != nil {
+			return nil, err
+		}
+		reports[r.Target] = files
+	}
+	return reports, nil
+}
+
+// collect copies the test.xml files of every test into dir and merges them
+// into a single report.
+func (j *JUnit) collect(dir string, reports map[string][]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tests := make([]string, 0, len(reports))
+	for test := range reports {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+
+	merged := testSuites{}
+	for _, test := range tests {
+		files := reports[test]
+		for n, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			name := unsafeChars.ReplaceAllString(strings.TrimLeft(test, "@/"), "_")
+			if len(files) > 1 {
+				name = fmt.Sprintf("%s.%d", name, n)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, name+".xml"), data, 0644); err != nil {
+				return err
+			}
+
+			suites, err := parse(data)
+			if err != nil {
+				log.Errorf("Skipping malformed %s: %v", file, err)
+				continue
+			}
+			merged.Suites = append(merged.Suites, suites...)
+		}
+	}
+
+	out, err := xml.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, mergedReport), append([]byte(xml.Header), out...), 0644)
+}
+
+// testXMLFiles returns the test.xml files of a test, one per shard.
+func testXMLFiles(testlogs, label string) ([]string, error) {
+	dir := filepath.Join(testlogs, filepath.FromSlash(labelPath(label)))
+	if _, err := os.Stat(filepath.Join(dir, "test.xml")); err == nil {
+		return []string{filepath.Join(dir, "test.xml")}, nil
+	}
+	return filepath.Glob(filepath.Join(dir, "shard_*_of_*", "test.xml"))
+}
+
+// labelPath converts a label to the path of its outputs relative to an output
+// directory, e.g. "@repo//foo:bar" becomes "external/repo/foo/bar".
+func labelPath(label string) string {
+	prefix := ""
+	if strings.HasPrefix(label, "@") {
+		parts := strings.SplitN(label[1:], "//", 2)
+		if len(parts) == 2 {
+			if parts[0] != "" {
+				prefix = "external/" + parts[0] + "/"
+			}
+			label = "//" + parts[1]
+		}
+	}
+	label = strings.TrimPrefix(label, "//")
+
+	pkg, name := label, ""
+	if idx := strings.Index(label, ":"); idx >= 0 {
+		pkg, name = label[:idx], label[idx+1:]
+	} else {
+		name = label[strings.LastIndex(label, "/")+1:]
+	}
+	if pkg == "" {
+		return prefix + name
+	}
+	return prefix + pkg + "/" + name
+}
+
+type testSuites struct {
+	XMLName xml.Name    `xml:"testsuites"`
+	Suites  []testSuite `xml:"testsuite"`
+}
+
+// testSuite is kept as-is apart from its attributes, so that nothing test
+// runners put in their reports is lost in the merge.
+type testSuite struct {
+	XMLName xml.Name   `xml:"testsuite"`
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+// parse returns the test suites of a report whose root element is either
+// <testsuites> or a single <testsuite>.
+func parse(data []byte) ([]testSuite, error) {
+	suites := testSuites{}
+	if err := xml.Unmarshal(data, &suites); err == nil {
+		return suites.Suites, nil
+	}
+
+	suite := testSuite{}
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		return nil, err
+	}
+	return []testSuite{suite}, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package junit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func init() {
+	log.FakeExit()
+}
+
+func TestLabelPath(t *testing.T) {
+	for label, expected := range map[string]string{
+		"//foo/bar:baz_test":  "foo/bar/baz_test",
+		"//foo/bar":           "foo/bar/bar",
+		"//:test":             "test",
+		"@repo//foo:test":     "external/repo/foo/test",
+		"@//foo:test":         "foo/test",
+		"//foo:dir/name_test": "foo/dir/name_test",
+	} {
+		if actual := labelPath(label); actual != expected {
+			t.Errorf("labelPath(%q) = %q, want %q", label, actual, expected)
+		}
+	}
+}
+
+func TestAfterCommand(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	testlogs := filepath.Join(tmp, "testlogs")
+	write := func(path, content string) {
+		path = filepath.Join(testlogs, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("foo/a_test/test.xml", `<?xml version="1.0"?><testsuites><testsuite name="a" tests="1"><testcase name="one"/></testsuite></testsuites>`)
+	write("foo/b_test/shard_1_of_2/test.xml", `<testsuite name="b1" tests="1"><testcase name="two"><failure message="boom"/></testcase></testsuite>`)
+	write("foo/b_test/shard_2_of_2/test.xml", `<testsuites><testsuite name="b2"></testsuite></testsuites>`)
+
+	*outputDir = filepath.Join(tmp, "reports")
+	defer func() { *outputDir = "" }()
+
+	j := New()
+	j.Initialize(&map[string]string{"bazel-testlogs": testlogs})
+	if j.Latest() != "" {
+		t.Errorf("Latest() = %q before any test ran", j.Latest())
+	}
+
+	output := bytes.NewBufferString("//foo:a_test    PASSED in 0.1s\n//foo:b_test    FAILED in 2 out of 2 in 0.2s\n")
+	j.AfterCommand([]string{"//foo/..."}, "test", false, output)
+
+	latest := j.Latest()
+	if filepath.Dir(latest) != *outputDir {
+		t.Fatalf("Latest() = %q, want a directory in %q", latest, *outputDir)
+	}
+	for _, name := range []string{"foo_a_test.xml", "foo_b_test.0.xml", "foo_b_test.1.xml"} {
+		if _, err := os.Stat(filepath.Join(latest, name)); err != nil {
+			t.Errorf("Report %s was not copied: %v", name, err)
+		}
+	}
+
+	merged, err := ioutil.ReadFile(filepath.Join(latest, mergedReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := parse(merged)
+	if err != nil {
+		t.Fatalf("Merged report is invalid: %v\n%s", err, merged)
+	}
+	if len(suites) != 3 {
+		t.Errorf("Merged report has %d suites, want 3:\n%s", len(suites), merged)
+	}
+	for _, s := range []string{`name="a"`, `<failure message="boom"/>`, `name="b2"`} {
+		if !strings.Contains(string(merged), s) {
+			t.Errorf("Merged report is missing %s:\n%s", s, merged)
+		}
+	}
+}
+
+func TestAfterCommand_buildEvents(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Not where the test summary would point to.
+	report := filepath.Join(tmp, "remote", "test.xml")
+	if err := os.MkdirAll(filepath.Dir(report), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(report, []byte(`<testsuite name="a" tests="1"><testcase name="one"/></testsuite>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	*outputDir = filepath.Join(tmp, "reports")
+	defer func() { *outputDir = "" }()
+
+	j := New()
+	j.Initialize(&map[string]string{})
+	if !bep.Enabled() {
+		t.Errorf("Build events aren't enabled with --junit_output_dir")
+	}
+
+	j.BuildEventsReceived([]string{"//foo/..."}, "test", &bep.Result{
+		Targets: []bep.Target{
+			{Label: "//foo:a_test", Success: true, TestStatus: "PASSED", TestXML: []string{report}},
+			{Label: "//foo:lib", Success: true},
+		},
+	})
+	j.AfterCommand([]string{"//foo/..."}, "test", true, bytes.NewBufferString(""))
+
+	latest := j.Latest()
+	if latest == "" {
+		t.Fatalf("No report was written")
+	}
+	if _, err := os.Stat(filepath.Join(latest, "foo_a_test.xml")); err != nil {
+		t.Errorf("Report of //foo:a_test was not copied: %v", err)
+	}
+}
+
+func TestAfterCommand_disabled(t *testing.T) {
+	j := New()
+	j.Initialize(&map[string]string{"bazel-testlogs": "/nonexistent"})
+	j.AfterCommand(nil, "test", true, bytes.NewBufferString("//foo:a_test    PASSED in 0.1s\n"))
+	if j.Latest() != "" {
+		t.Errorf("Latest() = %q, want no report when --junit_output_dir is unset", j.Latest())
+	}
+}
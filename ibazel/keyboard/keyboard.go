@@ -0,0 +1,130 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keyboard reads single key presses from the terminal, for watch mode
+// shortcuts like the ones of jest and vite. The terminal is switched out of
+// line mode while it is open, but keeps turning Ctrl-C into SIGINT.
+package keyboard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	Enter     = '\r'
+	Escape    = 0x1b
+	Backspace = 0x7f
+)
+
+// Keyboard hands out the keys read from a terminal. It is the only reader of
+// the terminal, so anything else that asks for input goes through NextKey.
+type Keyboard struct {
+	r       *bufio.Reader
+	restore func() error
+
+	mu       sync.Mutex // guards captured
+	captured chan rune
+}
+
+// New reads keys from r, which is already in the mode to read them in.
+func New(r io.Reader) *Keyboard {
+	return &Keyboard{r: bufio.NewReader(r), restore: func() error { return nil }}
+}
+
+// Open switches the terminal f out of line mode and echo, and reads keys
+// from it. It fails where that isn't supported, e.g. on Windows.
+func Open(f *os.File) (*Keyboard, error) {
+	restore, err := makeRaw(f)
+	if err != nil {
+		return nil, err
+	}
+	k := New(f)
+	k.restore = restore
+	return k, nil
+}
+
+// Close puts the terminal back into the mode it was in. It is safe to call
+// more than once.
+func (k *Keyboard) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	restore := k.restore
+	k.restore = func() error { return nil }
+	return restore()
+}
+
+// Run reads keys until the input ends, and calls handle with every key that
+// NextKey doesn't take.
+func (k *Keyboard) Run(handle func(key rune)) {
+	for {
+		key, _, err := k.r.ReadRune()
+		if err != nil {
+			return
+		}
+		k.mu.Lock()
+		captured := k.captured
+		k.captured = nil
+		k.mu.Unlock()
+		if captured != nil {
+			captured <- key
+			continue
+		}
+		handle(key)
+	}
+}
+
+// NextKey waits for the next key and keeps it from the handler of Run, e.g.
+// to answer a yes or no question. It must not be called from the handler.
+func (k *Keyboard) NextKey() rune {
+	captured := make(chan rune, 1)
+	k.mu.Lock()
+	k.captured = captured
+	k.mu.Unlock()
+	return <-captured
+}
+
+// ReadLine reads a line, echoing it after the prompt on w. Backspace deletes
+// the last character and Escape cancels, which returns false. It must be
+// called from the handler of Run.
+func (k *Keyboard) ReadLine(w io.Writer, prompt string) (string, bool) {
+	var line []rune
+	fmt.Fprint(w, prompt)
+	for {
+		key, _, err := k.r.ReadRune()
+		if err != nil {
+			fmt.Fprintln(w)
+			return "", false
+		}
+		switch {
+		case key == Enter || key == '\n':
+			fmt.Fprintln(w)
+			return string(line), true
+		case key == Escape:
+			fmt.Fprintln(w)
+			return "", false
+		case key == Backspace || key == '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(w, "\b \b")
+			}
+		case key >= ' ':
+			line = append(line, key)
+			fmt.Fprint(w, string(key))
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyboard
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var keys []rune
+	New(strings.NewReader("rpé")).Run(func(key rune) { keys = append(keys, key) })
+	if string(keys) != "rpé" {
+		t.Errorf("Handled %q, wanted %q", string(keys), "rpé")
+	}
+}
+
+func TestNextKey(t *testing.T) {
+	r, w := io.Pipe()
+	k := New(r)
+	handled := make(chan rune)
+	go k.Run(func(key rune) { handled <- key })
+
+	w.Write([]byte("a"))
+	if key := <-handled; key != 'a' {
+		t.Errorf("Handled %q, wanted a", key)
+	}
+
+	answer := make(chan rune)
+	go func() { answer <- k.NextKey() }()
+	// Wait until NextKey took the next key before typing it.
+	for {
+		k.mu.Lock()
+		waiting := k.captured != nil
+		k.mu.Unlock()
+		if waiting {
+			break
+		}
+	}
+	w.Write([]byte("y"))
+	if key := <-answer; key != 'y' {
+		t.Errorf("NextKey = %q, wanted y", key)
+	}
+
+	w.Write([]byte("b"))
+	if key := <-handled; key != 'b' {
+		t.Errorf("Handled %q after NextKey, wanted b", key)
+	}
+	w.Close()
+}
+
+func TestReadLine(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		line  string
+		ok    bool
+	}{
+		{"Foo\r", "Foo", true},
+		{"Fooo\x7f.Bar\n", "Foo.Bar", true},
+		{"\x7f\r", "", true},
+		{"Foo\x1b", "", false},
+		{"Foo", "", false},
+	} {
+		var out bytes.Buffer
+		line, ok := New(strings.NewReader(c.input)).ReadLine(&out, "Filter: ")
+		if line != c.line || ok != c.ok {
+			t.Errorf("ReadLine of %q = %q, %v, wanted %q, %v", c.input, line, ok, c.line, c.ok)
+		}
+		if !strings.HasPrefix(out.String(), "Filter: ") {
+			t.Errorf("ReadLine of %q printed %q without the prompt", c.input, out.String())
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/keyboard"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/output_runner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/terminal"
+)
+
+var keys = flag.Bool("keys", true, "Read single key presses when stdin is a terminal, like the watch modes of jest and vite: r rebuilds, p pauses and resumes watching, c clears the screen, q quits and h lists the others")
+
+const keysHelp = `Keys:
+  r    rebuild now
+  R    restart the running targets without rebuilding
+  p    pause or resume watching
+  t    change the --test_filter of ibazel test
+  0-9  switch to a --preset
+  c    clear the screen
+  q    quit
+  h    show this help`
+
+// Clears the screen and the scrollback, and moves the cursor to the top.
+const clearScreen = "\x1b[H\x1b[2J\x1b[3J"
+
+// keyAction is a key press that the main loop handles like a file change,
+// once it is waiting for changes.
+type keyAction int
+
+const (
+	rebuildAction keyAction = iota
+	pauseAction
+	clearAction
+	// Pause and resume watching rather than toggling it, see the control API.
+	stopWatchingAction
+	resumeAction
+)
+
+var openKeyboard = keyboard.Open
+
+// startKeys reads key presses from the terminal, unless stdin isn't one or
+// it runs with --no_tty or --keys=false.
+func (i *IBazel) startKeys(command string) {
+	if !*keys || terminal.NoTTY() || !terminal.Interactive(os.Stdin) {
+		return
+	}
+	k, err := openKeyboard(os.Stdin)
+	if err != nil {
+		log.Debugf("Not reading keys: %v", err)
+		return
+	}
+	i.keyboard = k
+	// The prompt of --run_output_interactive can't read stdin anymore.
+	output_runner.SetConfirm(func(question string) bool {
+		fmt.Fprintf(os.Stderr, "%s[y/N]", question)
+		key := k.NextKey()
+		fmt.Fprintln(os.Stderr, string(key))
+		return key == 'y' || key == 'Y'
+	})
+	log.Logf("Press h to list the keys iBazel understands.")
+	go k.Run(func(key rune) { i.handleKey(command, key) })
+}
+
+// stopKeys puts the terminal back into line mode.
+func (i *IBazel) stopKeys() {
+	if i.keyboard != nil {
+		i.keyboard.Close()
+	}
+}
+
+// handleKey is called with every key press, from the goroutine reading them.
+func (i *IBazel) handleKey(command string, key rune) {
+	switch {
+	case key == 'r':
+		i.sendKeyAction(rebuildAction)
+	case key == 'p':
+		i.sendKeyAction(pauseAction)
+	case key == 'c':
+		i.sendKeyAction(clearAction)
+	case key == 'q':
+		i.quit()
+	case key == 'R':
+		i.RestartTarget("")
+	case key == 't' && command == "test":
+		if filter, ok := i.keyboard.ReadLine(os.Stderr, "Test filter, empty for all tests: "); ok {
+			i.SetTestFilter(filter)
+			i.sendKeyAction(rebuildAction)
+		}
+	case key >= '0' && key <= '9':
+		if err := i.SwitchPreset(int(key - '0')); err != nil {
+			log.Errorf("Error switching presets: %v", err)
+		}
+	case key == 'h' || key == '?':
+		fmt.Fprintln(os.Stderr, keysHelp)
+	}
+}
+
+// sendKeyAction hands the action to the main loop. A key pressed again
+// before the main loop got to the first press is dropped, so that the keys
+// after it are still read. It returns whether the action was taken.
+func (i *IBazel) sendKeyAction(action keyAction) bool {
+	select {
+	case i.keyActions <- action:
+		return true
+	default:
+		return false
+	}
+}
+
+// applyKeyAction is called from the main loop to handle a key press.
+func (i *IBazel) applyKeyAction(action keyAction) {
+	switch action {
+	case rebuildAction:
+		log.Logf("Rebuilding...")
+		i.startIteration(triggerManual)
+		i.state = RUN
+	case pauseAction:
+		i.setPaused(!i.paused)
+	case stopWatchingAction:
+		i.setPaused(true)
+	case resumeAction:
+		i.setPaused(false)
+	case clearAction:
+		fmt.Fprint(os.Stderr, clearScreen)
+	}
+}
+
+func (i *IBazel) setPaused(paused bool) {
+	if paused == i.paused {
+		return
+	}
+	i.paused = paused
+	if i.paused {
+		log.Logf("Paused watching, press p to resume.")
+	} else if i.missedChanges {
+		log.Logf("Resumed watching. Requerying for the changes made while paused...")
+		i.missedChanges = false
+		i.startIteration(triggerManual)
+		i.state = QUERY
+	} else {
+		log.Logf("Resumed watching.")
+	}
+}
+
+// pausedEvent reports whether watching is paused, in which case the event is
+// dropped. Watching resumes with a query if files were changed meanwhile.
+func (i *IBazel) pausedEvent(e fsnotify.Event) bool {
+	if !i.paused {
+		return false
+	}
+	if e.Op&modifyingEvents != 0 {
+		i.missedChanges = true
+	}
+	return true
+}
+
+// quit stops the targets and exits, like SIGTERM does under --no_tty.
+func (i *IBazel) quit() {
+	for _, cmd := range i.cmds {
+		if cmd.IsSubprocessRunning() {
+			cmd.Terminate()
+		}
+	}
+	if i.cmd != nil && i.cmd.IsSubprocessRunning() {
+		i.cmd.Terminate()
+	}
+	i.shutdown("q")
+	osExit(0)
+}
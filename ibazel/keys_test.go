@@ -0,0 +1,88 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/keyboard"
+)
+
+func TestIBazelKeys_rebuild(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.state = WAIT
+
+	i.handleKey("build", 'r')
+	// Pressing it again before the main loop got to it doesn't block.
+	i.handleKey("build", 'r')
+	i.applyKeyAction(<-i.keyActions)
+	assertEqual(t, RUN, i.state, "State after r")
+	assertEqual(t, 0, len(i.keyActions), "Key actions left")
+}
+
+func TestIBazelKeys_pause(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.state = WAIT
+	e := fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Write}
+
+	assertEqual(t, false, i.pausedEvent(e), "Event before pausing dropped")
+	i.applyKeyAction(pauseAction)
+	assertEqual(t, true, i.pausedEvent(fsnotify.Event{Name: "/ws/a.go", Op: fsnotify.Chmod}), "Event while paused dropped")
+	i.applyKeyAction(pauseAction)
+	assertEqual(t, WAIT, i.state, "State after resuming without changes")
+
+	i.applyKeyAction(pauseAction)
+	assertEqual(t, true, i.pausedEvent(e), "Event while paused dropped")
+	i.applyKeyAction(pauseAction)
+	assertEqual(t, QUERY, i.state, "State after resuming with changes")
+	assertEqual(t, false, i.pausedEvent(e), "Event after resuming dropped")
+}
+
+func TestIBazelKeys_testFilter(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.keyboard = keyboard.New(strings.NewReader("Foo\r"))
+	i.SetTestArgs([]string{"--test_arg=-v"})
+
+	// Only `ibazel test` has a filter.
+	i.handleKey("build", 't')
+	assertEqual(t, 0, len(i.keyActions), "Key actions after t in build")
+
+	i.handleKey("test", 't')
+	assertEqual(t, []string{"--test_arg=-v", "--test_filter=Foo"}, i.testArgs, "Test args after t")
+	assertEqual(t, rebuildAction, <-i.keyActions, "Key action after t")
+}
+
+func TestIBazelStartKeys_noTTY(t *testing.T) {
+	defer func(old func(*os.File) (*keyboard.Keyboard, error)) { openKeyboard = old }(openKeyboard)
+	openKeyboard = func(*os.File) (*keyboard.Keyboard, error) {
+		t.Error("The keyboard was opened with --no_tty")
+		return nil, nil
+	}
+	defer setFlag(t, "no_tty", "true")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.startKeys("build")
+	if i.keyboard != nil {
+		t.Error("Keys are read with --no_tty")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// label is a parsed Bazel label, e.g. @repo//pkg:name.
+type label struct {
+	// The external repository, empty for the main repository.
+	repo string
+	pkg  string
+	name string
+}
+
+// parseLabel parses an absolute label. @//pkg:name and @@//pkg:name are in
+// the main repository, //pkg is short for //pkg:pkg and @repo for
+// @repo//:repo.
+func parseLabel(s string) (label, bool) {
+	var l label
+	if strings.HasPrefix(s, "@") {
+		s = strings.TrimLeft(s, "@")
+		n := strings.Index(s, "//")
+		if n < 0 {
+			if s == "" {
+				return l, false
+			}
+			return label{repo: s, name: s}, true
+		}
+		l.repo, s = s[:n], s[n:]
+	}
+	if !strings.HasPrefix(s, "//") {
+		return l, false
+	}
+	s = s[2:]
+	if n := strings.Index(s, ":"); n >= 0 {
+		l.pkg, l.name = s[:n], s[n+1:]
+	} else {
+		l.pkg, l.name = s, path.Base(s)
+	}
+	return l, true
+}
+
+// packageLabel returns the label of the package, e.g. //pkg or @repo//pkg.
+func (l label) packageLabel() string {
+	if l.repo == "" {
+		return "//" + l.pkg
+	}
+	return "@" + l.repo + "//" + l.pkg
+}
+
+// relPath returns the path of the file or output named by the label, relative
+// to the root of its repository.
+func (l label) relPath() string {
+	return filepath.FromSlash(path.Join(l.pkg, l.name))
+}
+
+// outputPath returns where the output named by the label is in binDir (e.g.
+// `bazel info bazel-bin`).
+func (l label) outputPath(binDir string) string {
+	if l.repo == "" {
+		return filepath.Join(binDir, l.relPath())
+	}
+	return filepath.Join(binDir, "external", l.repo, l.relPath())
+}
+
+// setTargetRepos remembers the external repositories of targets. Their files
+// are watched, the files of all other external repositories aren't.
+func (i *IBazel) setTargetRepos(targets []string) {
+	i.targetRepos = map[string]struct{}{}
+	for _, target := range targets {
+		if l, ok := parseLabel(target); ok && l.repo != "" {
+			i.targetRepos[l.repo] = struct{}{}
+		}
+	}
+}
+
+// sourcePath is labelToPath for the labels returned by iBazel's queries. It
+// also converts the labels of files in the external repositories of the
+// targets.
+func (i *IBazel) sourcePath(workspacePath string, s string) (string, bool) {
+	l, ok := parseLabel(s)
+	if !ok || l.repo == "" {
+		return i.mainRepoPath(workspacePath, s)
+	}
+	if _, ok := i.targetRepos[l.repo]; !ok {
+		return "", false
+	}
+	root, ok := i.repoRoot(workspacePath, l.repo)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(root, l.relPath()), true
+}
+
+// repoRoot returns the directory of an external repository: the one given with
+// --override_repository, or the one Bazel fetched it to.
+func (i *IBazel) repoRoot(workspacePath string, repo string) (string, bool) {
+	for _, arg := range i.bazelArgs {
+		override := strings.TrimPrefix(arg, "--override_repository=")
+		if override == arg {
+			continue
+		}
+		if n := strings.Index(override, "="); n >= 0 && override[:n] == repo {
+			dir := strings.Replace(override[n+1:], "%workspace%", workspacePath, 1)
+			return filepath.Clean(dir), true
+		}
+	}
+
+	i.outputTree()
+	if i.outputBase == "" {
+		return "", false
+	}
+	return filepath.Join(i.outputBase, "external", repo), true
+}
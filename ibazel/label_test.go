@@ -0,0 +1,85 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLabel(t *testing.T) {
+	for _, c := range []struct {
+		s     string
+		label label
+		ok    bool
+	}{
+		{"//foo/bar:baz.go", label{"", "foo/bar", "baz.go"}, true},
+		{"//foo/bar", label{"", "foo/bar", "bar"}, true},
+		{"//:BUILD", label{"", "", "BUILD"}, true},
+		{"@//foo:bar", label{"", "foo", "bar"}, true},
+		{"@@//foo:bar", label{"", "foo", "bar"}, true},
+		{"@repo//foo:bar", label{"repo", "foo", "bar"}, true},
+		{"@@repo~1.0//foo:bar", label{"repo~1.0", "foo", "bar"}, true},
+		{"@repo", label{"repo", "", "repo"}, true},
+		{"foo:bar", label{}, false},
+		{"@", label{}, false},
+	} {
+		l, ok := parseLabel(c.s)
+		if ok != c.ok || (ok && l != c.label) {
+			t.Errorf("parseLabel(%q) = %+v, %v, want %+v, %v", c.s, l, ok, c.label, c.ok)
+		}
+	}
+}
+
+func TestSourcePath(t *testing.T) {
+	ws := filepath.FromSlash("/ws")
+	i := &IBazel{
+		outputDirs: []string{},
+		outputBase: filepath.FromSlash("/cache/output_base"),
+		bazelArgs:  []string{"--override_repository=local=%workspace%/third_party/local"},
+	}
+	i.setTargetRepos([]string{"//app:server", "@lib//:lib", "@local//pkg:bin"})
+
+	for _, c := range []struct {
+		label string
+		path  string
+		ok    bool
+	}{
+		{"//app:main.go", "/ws/app/main.go", true},
+		{"@//app:main.go", "/ws/app/main.go", true},
+		{"//external:java", "", false},
+		{"@lib//src:lib.go", "/cache/output_base/external/lib/src/lib.go", true},
+		{"@local//pkg:BUILD", "/ws/third_party/local/pkg/BUILD", true},
+		{"@other//src:other.go", "", false},
+	} {
+		path, ok := i.sourcePath(ws, c.label)
+		if ok != c.ok || path != filepath.FromSlash(c.path) {
+			t.Errorf("sourcePath(%q) = %q, %v, want %q, %v", c.label, path, ok, c.path, c.ok)
+		}
+	}
+}
+
+func TestLabelOutputPath(t *testing.T) {
+	for s, expected := range map[string]string{
+		"//app:server":      "/bin/app/server",
+		"//app/server":      "/bin/app/server/server",
+		"@lib//tools:javac": "/bin/external/lib/tools/javac",
+	} {
+		l, _ := parseLabel(s)
+		if path := l.outputPath(filepath.FromSlash("/bin")); path != filepath.FromSlash(expected) {
+			t.Errorf("outputPath(%q) = %q, want %q", s, path, expected)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"time"
 
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
 )
 
@@ -19,6 +21,8 @@ type Lifecycle interface {
 
 	// ChangeDetected is called when a change is detected
 	// changeType: "source"|"graph"
+	// Listeners that also implement change.Listener get a structured
+	// change.Event through ChangeEventDetected instead.
 	ChangeDetected(targets []string, changeType string, change string)
 
 	// Cleanup is your opportunity to clean up open sockets or connections.
@@ -33,3 +37,109 @@ type Lifecycle interface {
 	// command: "build"|"test"|"run"
 	AfterCommand(targets []string, command string, success bool, output *bytes.Buffer)
 }
+
+// ShutdownListener can be implemented by a Lifecycle listener that has to do
+// something before iBazel exits because of a signal, in which case Cleanup
+// isn't called.
+type ShutdownListener interface {
+	// Shutdown is called from the goroutine that handles the signal, possibly
+	// while another method of the listener is running, so it has to guard any
+	// state it shares with them.
+	// reason: "SIGINT"|"SIGTERM"|"SIGHUP"
+	Shutdown(reason string)
+}
+
+// IterationListener can be implemented by a Lifecycle listener that wants to
+// tag what it reports with the ID of the iteration it belongs to, and why it
+// started.
+type IterationListener interface {
+	// IterationStarted is called with a new ID once iBazel is done waiting for
+	// changes and before it runs any query or command for them. trigger is
+	// machine readable, e.g. "file_change" or "manual".
+	IterationStarted(id string, trigger string)
+}
+
+// QueryErrorListener can be implemented by a Lifecycle listener that reports
+// errors, to also report the ones in BUILD files that make the query for the
+// files to watch fail. No command is run until they are fixed.
+type QueryErrorListener interface {
+	// QueryFailed is called with the output of the failed query.
+	QueryFailed(targets []string, output *bytes.Buffer)
+}
+
+// QueryListener can be implemented by a Lifecycle listener that wants to tell
+// the time spent querying for the files to watch apart from the time spent in
+// commands.
+type QueryListener interface {
+	// BeforeQuery is called before iBazel queries for the BUILD and source
+	// files of the targets.
+	BeforeQuery(targets []string)
+
+	// AfterQuery is called once the queries are done with how long they took,
+	// the number of files watched after them and the error that made them
+	// fail, if any. Files that are watched through their directory, see
+	// --max_watched_files, count as their directory.
+	AfterQuery(targets []string, duration time.Duration, fileCount int, err error)
+}
+
+// OutputListener can be implemented by a Lifecycle listener that wants to
+// follow the output of a command while it runs, e.g. to report problems before
+// a long build is done.
+type OutputListener interface {
+	// OutputLine is called with every line of output of the command between
+	// BeforeCommand and AfterCommand, without color codes. It is called from
+	// another goroutine than the other methods.
+	OutputLine(targets []string, command string, line string)
+}
+
+// ArtifactListener can be implemented by a Lifecycle listener that wants to
+// know which files the targets built, see --publish_artifacts.
+type ArtifactListener interface {
+	// ArtifactsBuilt is called before AfterCommand of a successful command
+	// with the paths of the output files of its targets and the ones among
+	// them that changed since the last command that built them.
+	ArtifactsBuilt(targets []string, artifacts []string, changed []string)
+}
+
+// BuildEventListener can be implemented by a Lifecycle listener that wants
+// structured results of builds and tests, see --build_events.
+type BuildEventListener interface {
+	// BuildEventsReceived is called before AfterCommand of every build and
+	// test with what the Build Event Protocol stream of the command said about
+	// it and its targets.
+	BuildEventsReceived(targets []string, command string, result *bep.Result)
+}
+
+// HealthListener can be implemented by a Lifecycle listener that reports the
+// health of the running targets, see --health_check_interval.
+type HealthListener interface {
+	// HealthChanged is called when the health check of a running target
+	// starts failing, with the reason, or passes again.
+	HealthChanged(target string, healthy bool, reason string)
+}
+
+// ExternalListener can be implemented by a Lifecycle listener whose
+// AfterCommand has effects outside of iBazel, e.g. reloading a browser, see
+// --wait_for_first_success.
+type ExternalListener interface {
+	// HasExternalEffects returns whether the listener currently has effects
+	// outside of iBazel.
+	HasExternalEffects() bool
+}
+
+// EnvListener can be implemented by a Lifecycle listener that passes
+// variables to the targets iBazel runs, e.g. the address of a server it
+// started for them in TargetDecider.
+type EnvListener interface {
+	// TargetEnv returns the variables to add to the environment of a target
+	// that is about to be started, as NAME=value.
+	TargetEnv() []string
+}
+
+// WatchdogListener can be implemented by a Lifecycle listener that alerts
+// about problems with iBazel itself, see --watchdog_timeout.
+type WatchdogListener interface {
+	// WatchersRestarted is called when the file watchers were restarted
+	// because they stopped forwarding changes, with the reason.
+	WatchersRestarted(reason string)
+}
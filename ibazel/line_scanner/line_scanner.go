@@ -0,0 +1,73 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package line_scanner splits the output of Bazel and of the commands iBazel
+// runs into lines. Unlike a plain bufio.Scanner, it doesn't stop at the first
+// line longer than 64KiB, which Java stack traces and the output of bundlers
+// routinely are, and it doesn't trip over output that isn't UTF-8.
+package line_scanner
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the length of the longest line returned whole. Longer lines
+// are returned in pieces of this length.
+var maxLineLength = 16 << 20
+
+// Scanner returns the lines of its input like a bufio.Scanner that splits
+// with bufio.ScanLines.
+type Scanner struct {
+	*bufio.Scanner
+}
+
+// New returns a Scanner for the lines of r.
+func New(r io.Reader) *Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxLineLength)
+	s.Split(scanLines)
+	return &Scanner{s}
+}
+
+// Text returns the current line with the bytes that aren't valid UTF-8, e.g.
+// output in another encoding, replaced by U+FFFD. Bytes returns the line as
+// it is.
+func (s *Scanner) Text() string {
+	return strings.ToValidUTF8(s.Scanner.Text(), "\uFFFD")
+}
+
+// scanLines is bufio.ScanLines, except that a line that doesn't fit into the
+// buffer is returned in pieces instead of failing with bufio.ErrTooLong, which
+// would drop the rest of the input.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance > 0 || token != nil || err != nil || len(data) < maxLineLength {
+		return advance, token, err
+	}
+
+	// Don't cut a character in two.
+	n := len(data)
+	for i := 1; i < utf8.UTFMax && i <= n; i++ {
+		if utf8.RuneStart(data[n-i]) {
+			if !utf8.FullRune(data[n-i:]) {
+				n -= i
+			}
+			break
+		}
+	}
+	return n, data[:n], nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package line_scanner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	oldMaxLineLength := maxLineLength
+	maxLineLength = 8
+	defer func() { maxLineLength = oldMaxLineLength }()
+
+	input := "short\r\n" +
+		"0123456789abcdefgh\n" +
+		"abc\xe2\x82\xac\xe2\x82\xacx\n" +
+		"latin-1 caf\xe9\n" +
+		"last"
+	s := New(strings.NewReader(input))
+	var lines, raw []string
+	for s.Scan() {
+		lines = append(lines, s.Text())
+		raw = append(raw, string(s.Bytes()))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"short",
+		"01234567", "89abcdef", "gh",
+		// The euros aren't cut in two.
+		"abc€", "€x",
+		"latin-1 ", "caf\uFFFD",
+		"last",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("Text() = %q, want %q", lines, want)
+	}
+	if raw[7] != "caf\xe9" {
+		t.Errorf("Bytes() = %q, want the line unchanged", raw[7])
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package live_reload
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// clientPath is where the client is served. It names the version of the
+// livereload protocol the client speaks, so that a page keeps getting a client
+// the server understands when either of them is upgraded.
+const clientPath = "/ibazel/livereload/official-7.js"
+
+// clientJS is the livereload client served by iBazel itself, so that rules
+// don't have to embed a snippet of their own. %d is the port of the livereload
+// server.
+const clientJS = `(function() {
+  if (window.__ibazelLiveReload) {
+    return;
+  }
+  window.__ibazelLiveReload = true;
+  var url = 'ws://' + (location.hostname || 'localhost') + ':%d/livereload';
+  var delay = 1000;
+  function connect() {
+    var socket = new WebSocket(url);
+    socket.onopen = function() {
+      delay = 1000;
+      socket.send(JSON.stringify({
+        command: 'hello',
+        protocols: ['http://livereload.com/protocols/official-7']
+      }));
+    };
+    socket.onmessage = function(event) {
+      var message = JSON.parse(event.data);
+      if (message.command === 'reload') {
+        location.reload();
+      } else if (message.command === 'alert') {
+        alert(message.message);
+      }
+    };
+    socket.onclose = function() {
+      setTimeout(connect, delay);
+      delay = Math.min(delay * 2, 30000);
+    };
+  }
+  connect();
+})();
+`
+
+// clientScript returns the client for the livereload server on port.
+func clientScript(port uint16) []byte {
+	return []byte(fmt.Sprintf(clientJS, port))
+}
+
+// integrity returns the subresource integrity of script, for the integrity
+// attribute of the <script> tag that loads it.
+func integrity(script []byte) string {
+	sum := sha512.Sum384(script)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func clientHandler(script []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		// The integrity attribute needs a CORS request.
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(script)
+	}
+}
+
+// serveClient serves the client on ln, which listens on the port of the
+// livereload server the pages connect to, and passes every other request on to
+// the livereload server itself, listening on backend. Sharing the port keeps
+// the URL of the client the same on every run.
+func serveClient(ln net.Listener, script []byte, backend uint16) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(clientPath, clientHandler(script))
+	// The live reload connections are WebSockets, which the proxy passes on.
+	mux.Handle("/", httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("localhost:%d", backend),
+	}))
+	server := &http.Server{Handler: mux}
+	go server.Serve(ln)
+	return server
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package live_reload
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestClientServer(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("live reload server"))
+	}))
+	defer backend.Close()
+	backendPort, err := strconv.Atoi(backend.URL[strings.LastIndex(backend.URL, ":")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := serveClient(ln, clientScript(35729), uint16(backendPort))
+	defer server.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		res, err := http.Get("http://" + ln.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	res, script := get(clientPath)
+	if !strings.Contains(string(script), "':35729/livereload'") {
+		t.Errorf("The client doesn't connect to the live reload server:\n%s", script)
+	}
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin: %q", got)
+	}
+	sum := sha512.Sum384(script)
+	want := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	if got := integrity(script); got != want {
+		t.Errorf("The integrity is %q, the script hashes to %q", got, want)
+	}
+
+	if _, body := get("/livereload.js"); string(body) != "live reload server" {
+		t.Errorf("Other requests aren't passed on to the live reload server: %q", body)
+	}
+}
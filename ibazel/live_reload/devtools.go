@@ -0,0 +1,147 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package live_reload
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	devtoolsEndpoint = flag.String(
+		"devtools_endpoint",
+		"",
+		"Reload browser tabs through the Chrome DevTools Protocol endpoint at this address (e.g. http://localhost:9222) instead of serving the live reload script")
+	devtoolsTabRegex = flag.String(
+		"devtools_tab_regex",
+		`^https?://(localhost|127\.0\.0\.1|\[::1\])(:\d+)?(/|$)`,
+		"Only tabs whose URL matches this regex are reloaded through -devtools_endpoint")
+)
+
+const devtoolsTimeout = 5 * time.Second
+
+// devtoolsTab is a single entry of the DevTools /json/list response.
+type devtoolsTab struct {
+	Type                 string `json:"type"`
+	URL                  string `json:"url"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// devtoolsReloader reloads the pages open in a browser that was started with
+// --remote-debugging-port. This works without modifying the served HTML,
+// which isn't always possible.
+type devtoolsReloader struct {
+	endpoint string
+	tabRegex *regexp.Regexp
+	client   *http.Client
+}
+
+func newDevtoolsReloader(endpoint string, tabRegex string) (*devtoolsReloader, error) {
+	re, err := regexp.Compile(tabRegex)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	return &devtoolsReloader{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		tabRegex: re,
+		client:   &http.Client{Timeout: devtoolsTimeout},
+	}, nil
+}
+
+func (d *devtoolsReloader) tabs() ([]devtoolsTab, error) {
+	res, err := d.client.Get(d.endpoint + "/json/list")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DevTools endpoint returned %s", res.Status)
+	}
+
+	var tabs []devtoolsTab
+	if err := json.NewDecoder(res.Body).Decode(&tabs); err != nil {
+		return nil, err
+	}
+	return tabs, nil
+}
+
+// reload reloads every page whose URL matches the tab regex and returns how
+// many were reloaded.
+func (d *devtoolsReloader) reload() (int, error) {
+	tabs, err := d.tabs()
+	if err != nil {
+		return 0, err
+	}
+
+	reloaded := 0
+	for _, tab := range tabs {
+		if tab.Type != "page" || tab.WebSocketDebuggerURL == "" || !d.tabRegex.MatchString(tab.URL) {
+			continue
+		}
+		if err := reloadTab(tab.WebSocketDebuggerURL); err != nil {
+			return reloaded, fmt.Errorf("reloading %s: %v", tab.URL, err)
+		}
+		reloaded++
+	}
+	return reloaded, nil
+}
+
+func reloadTab(debuggerURL string) error {
+	dialer := websocket.Dialer{HandshakeTimeout: devtoolsTimeout}
+	conn, _, err := dialer.Dial(debuggerURL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(devtoolsTimeout))
+	err = conn.WriteJSON(map[string]interface{}{
+		"id":     1,
+		"method": "Page.reload",
+	})
+	if err != nil {
+		return err
+	}
+
+	// Wait for the response so the connection isn't torn down before the
+	// browser has processed the command.
+	var res struct {
+		ID    int `json:"id"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	conn.SetReadDeadline(time.Now().Add(devtoolsTimeout))
+	for res.ID != 1 {
+		if err := conn.ReadJSON(&res); err != nil {
+			return err
+		}
+	}
+	if res.Error != nil {
+		return fmt.Errorf("%s", res.Error.Message)
+	}
+	return nil
+}
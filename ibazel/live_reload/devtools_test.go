@@ -0,0 +1,114 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package live_reload
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeBrowser serves the subset of the DevTools protocol used by
+// devtoolsReloader and records which tabs were reloaded.
+type fakeBrowser struct {
+	server *httptest.Server
+	tabs   map[string]string // id -> url
+
+	lock     sync.Mutex
+	reloaded []string
+}
+
+func newFakeBrowser(tabs map[string]string) *fakeBrowser {
+	b := &fakeBrowser{tabs: tabs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/list", b.list)
+	mux.HandleFunc("/devtools/page/", b.page)
+	b.server = httptest.NewServer(mux)
+	return b
+}
+
+func (b *fakeBrowser) list(rw http.ResponseWriter, req *http.Request) {
+	wsURL := "ws" + strings.TrimPrefix(b.server.URL, "http")
+	var tabs []devtoolsTab
+	for id, url := range b.tabs {
+		tabs = append(tabs, devtoolsTab{
+			Type:                 "page",
+			URL:                  url,
+			WebSocketDebuggerURL: fmt.Sprintf("%s/devtools/page/%s", wsURL, id),
+		})
+	}
+	json.NewEncoder(rw).Encode(tabs)
+}
+
+func (b *fakeBrowser) page(rw http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var cmd struct {
+		ID     int    `json:"id"`
+		Method string `json:"method"`
+	}
+	if err := conn.ReadJSON(&cmd); err != nil {
+		return
+	}
+	if cmd.Method == "Page.reload" {
+		b.lock.Lock()
+		b.reloaded = append(b.reloaded, b.tabs[strings.TrimPrefix(req.URL.Path, "/devtools/page/")])
+		b.lock.Unlock()
+	}
+	// Browsers interleave events with command responses.
+	conn.WriteJSON(map[string]interface{}{"method": "Page.frameStartedLoading"})
+	conn.WriteJSON(map[string]interface{}{"id": cmd.ID, "result": map[string]interface{}{}})
+}
+
+func TestDevtoolsReloader(t *testing.T) {
+	b := newFakeBrowser(map[string]string{
+		"1": "http://localhost:8080/index.html",
+		"2": "https://github.com/bazelbuild/bazel-watcher",
+	})
+	defer b.server.Close()
+
+	d, err := newDevtoolsReloader(b.server.URL, *devtoolsTabRegex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := d.reload()
+	if err != nil {
+		t.Errorf("reload(): %v", err)
+	}
+	if n != 1 {
+		t.Errorf("reload() reloaded %d tabs, want 1", n)
+	}
+	if len(b.reloaded) != 1 || b.reloaded[0] != "http://localhost:8080/index.html" {
+		t.Errorf("Reloaded the wrong tabs: %v", b.reloaded)
+	}
+}
+
+func TestDevtoolsReloader_invalidRegex(t *testing.T) {
+	if _, err := newDevtoolsReloader("localhost:9222", "("); err == nil {
+		t.Errorf("Expected an error for an invalid regex")
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"strconv"
 
@@ -34,7 +35,11 @@ var noLiveReload = flag.Bool("nolive_reload", false, "Disable JavaScript live re
 
 type LiveReloadServer struct {
 	lrserver       *lrserver.Server
+	clientServer   *http.Server
+	devtools       *devtoolsReloader
 	eventListeners []Events
+	// The variables passed to the run targets, see TargetEnv.
+	env []string
 }
 
 func New() *LiveReloadServer {
@@ -53,6 +58,15 @@ func (l *LiveReloadServer) Cleanup() {
 	if l.lrserver != nil {
 		l.lrserver.Close()
 	}
+	if l.clientServer != nil {
+		l.clientServer.Close()
+	}
+}
+
+// Shutdown closes the connections of the pages that are waiting for a reload,
+// so that they notice iBazel went away.
+func (l *LiveReloadServer) Shutdown(reason string) {
+	l.Cleanup()
 }
 
 func (l *LiveReloadServer) TargetDecider(rule *blaze_query.Rule) {
@@ -63,6 +77,10 @@ func (l *LiveReloadServer) TargetDecider(rule *blaze_query.Rule) {
 					log.Log("Target requests live_reload but liveReload has been disabled with the -nolive_reload flag.")
 					return
 				}
+				if *devtoolsEndpoint != "" {
+					l.startDevtoolsReloader()
+					return
+				}
 				l.startLiveReloadServer()
 				return
 			}
@@ -81,6 +99,11 @@ func (l *LiveReloadServer) AfterCommand(targets []string, command string, succes
 
 func (l *LiveReloadServer) ReloadTriggered(targets []string) {}
 
+// HasExternalEffects returns whether there is anything to reload.
+func (l *LiveReloadServer) HasExternalEffects() bool {
+	return l.lrserver != nil || l.devtools != nil
+}
+
 func (l *LiveReloadServer) startLiveReloadServer() {
 	if l.lrserver != nil {
 		return
@@ -88,24 +111,61 @@ func (l *LiveReloadServer) startLiveReloadServer() {
 
 	port := lrserver.DefaultPort
 	for ; port < lrserver.DefaultPort+100; port++ {
-		if testPort(port) {
-			l.lrserver = lrserver.New("live reload", port)
-			// Live reload server shouldn't log.
-			l.lrserver.SetStatusLog(golog.New(os.Stderr, "", 0))
-			go func() {
-				err := l.lrserver.ListenAndServe()
-				if err != nil {
-					log.Errorf("Live reload server failed to start: %v", err)
-				}
-			}()
-			url := fmt.Sprintf("http://localhost:%d/livereload.js?snipver=1", port)
-			os.Setenv("IBAZEL_LIVERELOAD_URL", url)
+		ln, err := net.Listen("tcp", ":"+strconv.FormatInt(int64(port), 10))
+		if err != nil {
+			log.Logf("Port %d: %v", port, err)
+			continue
+		}
+		// The pages connect to port, where the client is served too, and
+		// the live reload server listens behind it.
+		backend, err := freePort()
+		if err != nil {
+			ln.Close()
+			log.Errorf("Could not find open port for live reload server: %v", err)
 			return
 		}
+		l.lrserver = lrserver.New("live reload", backend)
+		// Live reload server shouldn't log.
+		l.lrserver.SetStatusLog(golog.New(os.Stderr, "", 0))
+		go func() {
+			err := l.lrserver.ListenAndServe()
+			if err != nil {
+				log.Errorf("Live reload server failed to start: %v", err)
+			}
+		}()
+		script := clientScript(port)
+		l.clientServer = serveClient(ln, script, backend)
+		l.env = []string{
+			fmt.Sprintf("IBAZEL_LIVERELOAD_URL=http://localhost:%d/livereload.js?snipver=1", port),
+			fmt.Sprintf("IBAZEL_LIVERELOAD_SCRIPT_URL=http://localhost:%d%s", port, clientPath),
+			"IBAZEL_LIVERELOAD_SCRIPT_INTEGRITY=" + integrity(script),
+		}
+		return
 	}
 	log.Errorf("Could not find open port for live reload server")
 }
 
+// TargetEnv passes the URLs of the live reload scripts to the run targets as
+// IBAZEL_LIVERELOAD_URL, IBAZEL_LIVERELOAD_SCRIPT_URL and
+// IBAZEL_LIVERELOAD_SCRIPT_INTEGRITY, once the live reload server is started.
+func (l *LiveReloadServer) TargetEnv() []string {
+	return l.env
+}
+
+func (l *LiveReloadServer) startDevtoolsReloader() {
+	if l.devtools != nil {
+		return
+	}
+
+	var err error
+	l.devtools, err = newDevtoolsReloader(*devtoolsEndpoint, *devtoolsTabRegex)
+	if err != nil {
+		log.Errorf("Invalid -devtools_tab_regex: %v", err)
+		return
+	}
+	log.Logf("Reloading tabs matching %q through DevTools at %s", *devtoolsTabRegex, l.devtools.endpoint)
+}
+
 func (l *LiveReloadServer) triggerReload(targets []string) {
 	if l.lrserver != nil {
 		log.Log("Triggering live reload")
@@ -114,18 +174,29 @@ func (l *LiveReloadServer) triggerReload(targets []string) {
 			e.ReloadTriggered(targets)
 		}
 	}
+	if l.devtools != nil {
+		n, err := l.devtools.reload()
+		if err != nil {
+			log.Errorf("DevTools reload failed: %v", err)
+		}
+		if n == 0 {
+			return
+		}
+		log.Logf("Reloaded %d tab(s) through DevTools", n)
+		for _, e := range l.eventListeners {
+			e.ReloadTriggered(targets)
+		}
+	}
 }
 
-func testPort(port uint16) bool {
-	ln, err := net.Listen("tcp", ":"+strconv.FormatInt(int64(port), 10))
-
+// freePort returns a port on localhost that nothing listens on right now.
+func freePort() (uint16, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
-		log.Logf("Port %d: %v", port, err)
-		return false
+		return 0, err
 	}
-
-	ln.Close()
-	return true
+	defer ln.Close()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), nil
 }
 
 func contains(l []string, e string) bool {
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,42 +16,81 @@ var writer io.Writer = os.Stderr
 var osExit = os.Exit
 var timeNow = time.Now
 
+// Guards the settings below, which other goroutines change while logging,
+// and keeps the lines of different goroutines from being interleaved.
+var lock sync.Mutex
+
+// The ID of the current iteration, added to every line when set.
+var iteration = ""
+
+// Whether the output is colored, see SetColor.
+var colored = true
+
+// Whether Debugf prints anything, see SetDebug.
+var debug = false
+
+// A status that lasts for more than one iteration, e.g. "offline", added to
+// every line when set.
+var status = ""
+
 const (
 	resetColor  color = "\033[0m"
 	bannerColor color = "\033[33m"
 	errorColor  color = "\033[31m"
 	fatalColor  color = "\033[41m"
 	logColor    color = "\033[96m"
+	debugColor  color = "\033[90m"
 )
 
+// paint returns c, or nothing if the output isn't colored. It must be called
+// with lock held.
+func paint(c color) color {
+	if !colored {
+		return ""
+	}
+	return c
+}
+
 func log(c color, msg string, args ...interface{}) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	stamp := timeNow().Local().Format(time.Kitchen)
+	if iteration != "" {
+		stamp += " " + iteration
+	}
+	if status != "" {
+		stamp += " " + status
+	}
 	fmt.Fprintf(writer, "%siBazel [%s]%s: ",
-		c,
-		timeNow().Local().Format(time.Kitchen),
-		resetColor)
+		paint(c),
+		stamp,
+		paint(resetColor))
 	fmt.Fprintf(writer, msg, args...)
 	fmt.Fprintf(writer, "\n")
 }
 
 // NewLine prints a new line to the screen without any preamble.
 func NewLine() {
+	lock.Lock()
+	defer lock.Unlock()
 	fmt.Fprintf(writer, "\n")
 }
 
 // Print out a banner surrounded by # to draw attention to the eye.
 func Banner(lines ...string) {
-	NewLine()
-	fmt.Fprintf(writer, "%s%s%s", bannerColor, strings.Repeat("#", 80), resetColor)
-	NewLine()
+	lock.Lock()
+	defer lock.Unlock()
+
+	fmt.Fprintf(writer, "\n")
+	fmt.Fprintf(writer, "%s%s%s\n", paint(bannerColor), strings.Repeat("#", 80), paint(resetColor))
 
 	for _, line := range lines {
-		fmt.Fprintf(writer, "%s#%s %-76s %s#%s", bannerColor, resetColor, line, bannerColor, resetColor)
-		NewLine()
+		fmt.Fprintf(writer, "%s#%s %-76s %s#%s\n", paint(bannerColor), paint(resetColor), line, paint(bannerColor), paint(resetColor))
 	}
 
-	fmt.Fprintf(writer, "%s%s%s", bannerColor, strings.Repeat("#", 80), resetColor)
-	NewLine()
-	NewLine()
+	fmt.Fprintf(writer, "%s%s%s\n", paint(bannerColor), strings.Repeat("#", 80), paint(resetColor))
+	fmt.Fprintf(writer, "\n")
 }
 
 // Error prints an error to the screen with a preamble.
@@ -73,6 +114,17 @@ func Fatalf(msg string, args ...interface{}) {
 	osExit(1)
 }
 
+// Debugf prints a message to the screen with a preamble, if debug messages
+// are on.
+func Debugf(msg string, args ...interface{}) {
+	lock.Lock()
+	enabled := debug
+	lock.Unlock()
+	if enabled {
+		log(debugColor, msg, args...)
+	}
+}
+
 // Log prints a message to the screen with a preamble.
 func Log(msg string) {
 	Logf(msg)
@@ -85,10 +137,51 @@ func Logf(msg string, args ...interface{}) {
 
 // SetWriter decides which io.Writer to write logs to.
 func SetWriter(w io.Writer) {
+	lock.Lock()
+	defer lock.Unlock()
 	writer = w
 }
 
+// SetIteration sets the iteration ID that is printed on every line, so that
+// the lines can be matched with the output of other tools.
+func SetIteration(id string) {
+	lock.Lock()
+	defer lock.Unlock()
+	iteration = id
+}
+
+// SetColor decides whether the output is colored. It is by default.
+func SetColor(enabled bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	colored = enabled
+}
+
+// SetDebug decides whether Debugf prints anything. It doesn't by default.
+func SetDebug(enabled bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	debug = enabled
+}
+
+// SetStatus sets a status that is printed on every line until it is cleared
+// with an empty status.
+func SetStatus(s string) {
+	lock.Lock()
+	defer lock.Unlock()
+	status = s
+}
+
 // FakeExit makes the Fatal log methods not exit.
 func FakeExit() {
 	osExit = func(int) {}
 }
+
+// This RegExp will match ANSI escape codes.
+var escapeCodeCleanerRegex = regexp.MustCompile("\\x1B\\[[\\x30-\\x3F]*[\\x20-\\x2F]*[\\x40-\\x7E]")
+
+// StripColor removes the ANSI escape codes, such as the colors of Bazel's
+// output, from s.
+func StripColor(s string) string {
+	return escapeCodeCleanerRegex.ReplaceAllLiteralString(s, "")
+}
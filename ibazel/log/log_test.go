@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -113,6 +114,110 @@ func TestNonfLoggers(t *testing.T) {
 	}
 }
 
+func TestIteration(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2019, 11, 13, 0, 5, 7, 0, time.Local)
+	}
+	buf := &bytes.Buffer{}
+	SetWriter(buf)
+
+	SetIteration("0a1b2c3d")
+	defer SetIteration("")
+	Log("log")
+
+	got := buf.String()
+	want := fmt.Sprintf("%siBazel [12:05AM 0a1b2c3d]\x1b[0m: log\n", logColor)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("\nGot:  %q\nWant: %q\nDiff:\n%s", got, want, diff)
+	}
+}
+
+func TestIterationWhileLogging(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetWriter(buf)
+	defer SetIteration("")
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			SetIteration(fmt.Sprintf("%08d", n))
+		}(n)
+		go func() {
+			defer wg.Done()
+			Log("log")
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("Got %d lines, want 10:\n%s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, ": log") {
+			t.Errorf("Interleaved line %q", line)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2019, 11, 13, 0, 5, 7, 0, time.Local)
+	}
+	buf := &bytes.Buffer{}
+	SetWriter(buf)
+
+	SetStatus("offline")
+	Log("log")
+	SetStatus("")
+	Log("log")
+
+	got := buf.String()
+	want := fmt.Sprintf("%siBazel [12:05AM offline]\x1b[0m: log\n%siBazel [12:05AM]\x1b[0m: log\n", logColor, logColor)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("\nGot:  %q\nWant: %q\nDiff:\n%s", got, want, diff)
+	}
+}
+
+func TestSetColor(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2019, 11, 13, 0, 5, 7, 0, time.Local)
+	}
+	buf := &bytes.Buffer{}
+	SetWriter(buf)
+
+	SetColor(false)
+	defer SetColor(true)
+	Errorf("error")
+
+	got := buf.String()
+	want := "iBazel [12:05AM]: error\n"
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("\nGot:  %q\nWant: %q\nDiff:\n%s", got, want, diff)
+	}
+}
+
+func TestDebugf(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2019, 11, 13, 0, 5, 7, 0, time.Local)
+	}
+	buf := &bytes.Buffer{}
+	SetWriter(buf)
+
+	Debugf("hidden %d", 1)
+	SetDebug(true)
+	defer SetDebug(false)
+	Debugf("shown %d", 2)
+
+	got := buf.String()
+	want := fmt.Sprintf("%siBazel [12:05AM]\x1b[0m: shown 2\n", debugColor)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("\nGot:  %q\nWant: %q\nDiff:\n%s", got, want, diff)
+	}
+}
+
 func TestBanner(t *testing.T) {
 	buf := &bytes.Buffer{}
 	SetWriter(buf)
@@ -132,3 +237,11 @@ func TestBanner(t *testing.T) {
 		t.Errorf("\nGot:  %q\nWant: %q\nDiff:\n%s", got, want, diff)
 	}
 }
+
+func TestStripColor(t *testing.T) {
+	got := StripColor("\x1b[31mERROR:\x1b[0m build failed\x1b[K")
+	want := "ERROR: build failed"
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("\nGot:  %q\nWant: %q\nDiff:\n%s", got, want, diff)
+	}
+}
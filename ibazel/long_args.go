@@ -0,0 +1,87 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+// maxArgsLength is how long the target patterns or the query of a Bazel
+// command may get before they are passed in a file instead. Linux limits a
+// single argument to 128 KiB and Windows the whole command line to 32 KiB, and
+// both fail with E2BIG or its equivalent beyond that, e.g. when `ibazel mrun`
+// rebuilds hundreds of targets at once.
+var maxArgsLength = 16 << 10
+
+// argsFile writes content to a temporary file for a Bazel flag that reads its
+// value from a file, and returns the flag and a function that removes the
+// file. It returns an empty flag if the file can't be written.
+func argsFile(flag string, pattern string, content string) (string, func()) {
+	f, err := temp_dir.TempFile(pattern)
+	if err != nil {
+		log.Errorf("Error creating the file for %s: %v", flag, err)
+		return "", func() {}
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		log.Errorf("Error writing the file for %s: %v", flag, err)
+		os.Remove(f.Name())
+		return "", func() {}
+	}
+	return flag + "=" + f.Name(), func() { os.Remove(f.Name()) }
+}
+
+// targetPatternArgs returns the arguments that pass the target patterns to
+// `bazel build` or `bazel test`. When the patterns would make the command
+// line too long, they are passed with --target_pattern_file instead, which
+// also takes negative patterns. The returned function removes the file.
+func targetPatternArgs(patterns []string) ([]string, func()) {
+	negative := false
+	length := 0
+	for _, pattern := range patterns {
+		length += len(pattern) + 1
+		negative = negative || strings.HasPrefix(pattern, "-")
+	}
+
+	if length > maxArgsLength {
+		flag, cleanup := argsFile("--target_pattern_file", "targets*.txt", strings.Join(patterns, "\n")+"\n")
+		if flag != "" {
+			log.Debugf("Passing %d target patterns with %s", len(patterns), flag)
+			return []string{flag}, cleanup
+		}
+	}
+	if negative {
+		// Negative target patterns have to come after a --.
+		return append([]string{"--"}, patterns...), func() {}
+	}
+	return patterns, func() {}
+}
+
+// queryArgs returns the arguments that pass the query expression to `bazel
+// query`, with --query_file when it would make the command line too long. The
+// returned function removes the file.
+func queryArgs(query string) ([]string, func()) {
+	if len(query) > maxArgsLength {
+		flag, cleanup := argsFile("--query_file", "query*.txt", query)
+		if flag != "" {
+			return []string{flag}, cleanup
+		}
+	}
+	return []string{query}, func() {}
+}
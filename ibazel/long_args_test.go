@@ -0,0 +1,73 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTargetPatternArgs(t *testing.T) {
+	args, cleanup := targetPatternArgs([]string{"//a", "//b"})
+	cleanup()
+	assertEqual(t, []string{"//a", "//b"}, args, "Short patterns")
+
+	args, cleanup = targetPatternArgs([]string{"//...", "-//flaky:test"})
+	cleanup()
+	assertEqual(t, []string{"--", "//...", "-//flaky:test"}, args, "Negative patterns")
+
+	oldMaxArgsLength := maxArgsLength
+	maxArgsLength = 10
+	defer func() { maxArgsLength = oldMaxArgsLength }()
+
+	args, cleanup = targetPatternArgs([]string{"//service:one", "//service:two", "-//service:three"})
+	if len(args) != 1 || !strings.HasPrefix(args[0], "--target_pattern_file=") {
+		t.Fatalf("Long patterns were passed as %v", args)
+	}
+	file := strings.TrimPrefix(args[0], "--target_pattern_file=")
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "//service:one\n//service:two\n-//service:three\n", string(content), "Target pattern file")
+	cleanup()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't removed: %v", file, err)
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	query := "buildfiles(deps(//a + //b))"
+	args, cleanup := queryArgs(query)
+	cleanup()
+	assertEqual(t, []string{query}, args, "Short query")
+
+	oldMaxArgsLength := maxArgsLength
+	maxArgsLength = 10
+	defer func() { maxArgsLength = oldMaxArgsLength }()
+
+	args, cleanup = queryArgs(query)
+	defer cleanup()
+	if len(args) != 1 || !strings.HasPrefix(args[0], "--query_file=") {
+		t.Fatalf("Long query was passed as %v", args)
+	}
+	content, err := ioutil.ReadFile(strings.TrimPrefix(args[0], "--query_file="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, query, string(content), "Query file")
+}
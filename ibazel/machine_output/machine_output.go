@@ -0,0 +1,222 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package machine_output prints a JSON summary of every iteration to stdout
+// for scripts that wrap iBazel.
+package machine_output
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var machineOutput = flag.Bool("machine_output", false, "Print one JSON object per iteration to stdout and send all other output to stderr")
+
+// Captured before main redirects os.Stdout, see Enabled.
+var stdout io.Writer = os.Stdout
+
+var timeNow = time.Now
+
+// Matches Bazel's own errors and warnings as well as compiler diagnostics in
+// the file:line:column: form.
+var diagnosticRegex = regexp.MustCompile(`^(ERROR|WARNING): |^\S+:\d+:\d+: `)
+
+// Enabled reports whether --machine_output was given. When it is, everything
+// that would normally go to stdout must be sent to stderr instead.
+func Enabled() bool {
+	return *machineOutput
+}
+
+// Iteration is the summary printed after every command.
+type Iteration struct {
+	ID           string   `json:"id"`
+	Trigger      string   `json:"trigger"`
+	Command      string   `json:"command"`
+	Targets      []string `json:"targets"`
+	Result       string   `json:"result"`
+	DurationMs   int64    `json:"duration_ms"`
+	ChangedFiles []string `json:"changed_files"`
+	Diagnostics  int      `json:"diagnostics"`
+	// How long the queries for the files to watch took, when the iteration
+	// ran them.
+	QueryDurationMs int64 `json:"query_duration_ms,omitempty"`
+	// The output files of the targets and the ones among them that changed,
+	// with --publish_artifacts.
+	Artifacts        []string `json:"artifacts,omitempty"`
+	ChangedArtifacts []string `json:"changed_artifacts,omitempty"`
+	// What the build events said about every target, with --build_events.
+	TargetResults []TargetResult `json:"target_results,omitempty"`
+}
+
+// TargetResult is the result of a single target of an iteration.
+type TargetResult struct {
+	Label      string   `json:"label"`
+	Result     string   `json:"result"`
+	TestStatus string   `json:"test_status,omitempty"`
+	Outputs    []string `json:"outputs,omitempty"`
+}
+
+type MachineOutput struct {
+	iteration string
+	trigger   string
+	changes   map[string]struct{}
+	start     time.Time
+	query     time.Duration
+
+	artifacts        []string
+	changedArtifacts []string
+	targetResults    []TargetResult
+}
+
+func New() *MachineOutput {
+	return &MachineOutput{
+		changes: map[string]struct{}{},
+	}
+}
+
+func (m *MachineOutput) Initialize(info *map[string]string) {}
+
+func (m *MachineOutput) TargetDecider(rule *blaze_query.Rule) {}
+
+func (m *MachineOutput) ChangeDetected(targets []string, changeType string, change string) {
+	m.changes[change] = struct{}{}
+}
+
+// IterationStarted implements the IterationListener interface of iBazel.
+func (m *MachineOutput) IterationStarted(id string, trigger string) {
+	m.iteration = id
+	m.trigger = trigger
+	m.start = timeNow()
+}
+
+// BeforeQuery implements the QueryListener interface of iBazel.
+func (m *MachineOutput) BeforeQuery(targets []string) {}
+
+// AfterQuery implements the QueryListener interface of iBazel.
+func (m *MachineOutput) AfterQuery(targets []string, duration time.Duration, fileCount int, err error) {
+	if err != nil {
+		// QueryFailed reported the iteration already.
+		m.query = 0
+		return
+	}
+	m.query = duration
+}
+
+// ArtifactsBuilt implements the ArtifactListener interface of iBazel.
+func (m *MachineOutput) ArtifactsBuilt(targets []string, artifacts []string, changed []string) {
+	m.artifacts = artifacts
+	m.changedArtifacts = changed
+}
+
+// BuildEventsReceived implements the BuildEventListener interface of iBazel.
+func (m *MachineOutput) BuildEventsReceived(targets []string, command string, result *bep.Result) {
+	m.targetResults = make([]TargetResult, 0, len(result.Targets))
+	for _, t := range result.Targets {
+		r := TargetResult{
+			Label:      t.Label,
+			Result:     "success",
+			TestStatus: t.TestStatus,
+			Outputs:    t.Outputs,
+		}
+		if !t.Success {
+			r.Result = "failure"
+		}
+		m.targetResults = append(m.targetResults, r)
+	}
+}
+
+func (m *MachineOutput) BeforeCommand(targets []string, command string) {
+	m.start = timeNow()
+}
+
+func (m *MachineOutput) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	m.print(targets, command, success, output)
+}
+
+// QueryFailed implements the QueryErrorListener interface of iBazel. The
+// iteration is reported with the "query" command.
+func (m *MachineOutput) QueryFailed(targets []string, output *bytes.Buffer) {
+	m.print(targets, "query", false, output)
+}
+
+func (m *MachineOutput) print(targets []string, command string, success bool, output *bytes.Buffer) {
+	if !*machineOutput {
+		return
+	}
+
+	changed := make([]string, 0, len(m.changes))
+	for change := range m.changes {
+		changed = append(changed, change)
+	}
+	sort.Strings(changed)
+	m.changes = map[string]struct{}{}
+
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+
+	iteration := Iteration{
+		ID:           m.iteration,
+		Trigger:      m.trigger,
+		Command:      command,
+		Targets:      targets,
+		Result:       result,
+		DurationMs:   int64(timeNow().Sub(m.start) / time.Millisecond),
+		ChangedFiles: changed,
+		Diagnostics:  countDiagnostics(output),
+
+		QueryDurationMs: int64(m.query / time.Millisecond),
+
+		Artifacts:        m.artifacts,
+		ChangedArtifacts: m.changedArtifacts,
+		TargetResults:    m.targetResults,
+	}
+	m.query = 0
+	m.artifacts = nil
+	m.changedArtifacts = nil
+	m.targetResults = nil
+	if err := json.NewEncoder(stdout).Encode(iteration); err != nil {
+		log.Errorf("Error writing machine output: %v", err)
+	}
+}
+
+func (m *MachineOutput) Cleanup() {}
+
+func countDiagnostics(output *bytes.Buffer) int {
+	if output == nil {
+		return 0
+	}
+
+	n := 0
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		line := log.StripColor(scanner.Text())
+		if diagnosticRegex.MatchString(line) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine_output
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+)
+
+func TestAfterCommand(t *testing.T) {
+	oldMachineOutput := *machineOutput
+	*machineOutput = true
+	defer func() { *machineOutput = oldMachineOutput }()
+
+	out := &bytes.Buffer{}
+	oldStdout := stdout
+	stdout = out
+	defer func() { stdout = oldStdout }()
+	now := time.Unix(100, 0)
+	oldTimeNow := timeNow
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = oldTimeNow }()
+
+	m := New()
+	m.IterationStarted("0a1b2c3d", "file_change")
+	m.ChangeDetected([]string{"//foo:bar"}, "source", "/ws/foo/b.go")
+	m.ChangeDetected([]string{"//foo:bar"}, "source", "/ws/foo/a.go")
+	m.ChangeDetected([]string{"//foo:bar"}, "source", "/ws/foo/b.go")
+	m.BeforeQuery([]string{"//foo:bar"})
+	m.AfterQuery([]string{"//foo:bar"}, 700*time.Millisecond, 12, nil)
+	m.BeforeCommand([]string{"//foo:bar"}, "build")
+	now = now.Add(1500 * time.Millisecond)
+	m.AfterCommand([]string{"//foo:bar"}, "build", false, bytes.NewBufferString(
+		"\x1b[31mERROR: \x1b[0m/ws/foo/BUILD:1:1: Compiling foo/a.go failed\n"+
+			"foo/a.go:3:2: undefined: x\n"+
+			"foo/b.go:10:5: error: expected ';'\n"+
+			"INFO: Elapsed time: 1.5s\n"))
+
+	m.BeforeCommand([]string{"//foo:bar"}, "build")
+	m.ArtifactsBuilt([]string{"//foo:bar"}, []string{"/out/foo/bar", "/out/foo/bar.map"}, []string{"/out/foo/bar"})
+	m.BuildEventsReceived([]string{"//foo:bar"}, "build", &bep.Result{
+		Success: true,
+		Targets: []bep.Target{{Label: "//foo:bar", Success: true, Outputs: []string{"/out/foo/bar"}}},
+	})
+	m.AfterCommand([]string{"//foo:bar"}, "build", true, nil)
+
+	decoder := json.NewDecoder(out)
+	var first, second Iteration
+	if err := decoder.Decode(&first); err != nil {
+		t.Fatalf("Error decoding %q: %v", out.String(), err)
+	}
+	if err := decoder.Decode(&second); err != nil {
+		t.Fatalf("Error decoding %q: %v", out.String(), err)
+	}
+
+	expected := Iteration{
+		ID:           "0a1b2c3d",
+		Trigger:      "file_change",
+		Command:      "build",
+		Targets:      []string{"//foo:bar"},
+		Result:       "failure",
+		DurationMs:   1500,
+		ChangedFiles: []string{"/ws/foo/a.go", "/ws/foo/b.go"},
+		Diagnostics:  3,
+
+		QueryDurationMs: 700,
+	}
+	if !reflect.DeepEqual(first, expected) {
+		t.Errorf("First iteration:\nGot:  %#v\nWant: %#v", first, expected)
+	}
+
+	expected = Iteration{
+		ID:           "0a1b2c3d",
+		Trigger:      "file_change",
+		Command:      "build",
+		Targets:      []string{"//foo:bar"},
+		Result:       "success",
+		ChangedFiles: []string{},
+
+		Artifacts:        []string{"/out/foo/bar", "/out/foo/bar.map"},
+		ChangedArtifacts: []string{"/out/foo/bar"},
+		TargetResults:    []TargetResult{{Label: "//foo:bar", Result: "success", Outputs: []string{"/out/foo/bar"}}},
+	}
+	if !reflect.DeepEqual(second, expected) {
+		t.Errorf("Second iteration:\nGot:  %#v\nWant: %#v", second, expected)
+	}
+}
+
+func TestAfterCommand_disabled(t *testing.T) {
+	out := &bytes.Buffer{}
+	oldStdout := stdout
+	stdout = out
+	defer func() { stdout = oldStdout }()
+
+	m := New()
+	m.BeforeCommand([]string{"//foo:bar"}, "build")
+	m.AfterCommand([]string{"//foo:bar"}, "build", true, nil)
+	if out.Len() != 0 {
+		t.Errorf("Wrote %q without --machine_output", out.String())
+	}
+}
+
+func TestQueryFailed(t *testing.T) {
+	oldMachineOutput := *machineOutput
+	*machineOutput = true
+	defer func() { *machineOutput = oldMachineOutput }()
+
+	out := &bytes.Buffer{}
+	oldStdout := stdout
+	stdout = out
+	defer func() { stdout = oldStdout }()
+	now := time.Unix(100, 0)
+	oldTimeNow := timeNow
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = oldTimeNow }()
+
+	m := New()
+	m.IterationStarted("0a1b2c3d", "graph_change")
+	m.ChangeDetected([]string{"//foo:bar"}, "graph", "/ws/foo/BUILD")
+	now = now.Add(200 * time.Millisecond)
+	m.QueryFailed([]string{"//foo:bar"}, bytes.NewBufferString(
+		"ERROR: /ws/foo/BUILD:3:5: syntax error at 'load'\n"))
+
+	var got Iteration
+	if err := json.NewDecoder(out).Decode(&got); err != nil {
+		t.Fatalf("Error decoding %q: %v", out.String(), err)
+	}
+	expected := Iteration{
+		ID:           "0a1b2c3d",
+		Trigger:      "graph_change",
+		Command:      "query",
+		Targets:      []string{"//foo:bar"},
+		Result:       "failure",
+		DurationMs:   200,
+		ChangedFiles: []string{"/ws/foo/BUILD"},
+		Diagnostics:  1,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got:  %#v\nWant: %#v", got, expected)
+	}
+}
@@ -21,7 +21,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/bazel_queue"
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/experiments"
 	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/machine_output"
+	"github.com/bazelbuild/bazel-watcher/ibazel/mrun_log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/progress"
+	"github.com/bazelbuild/bazel-watcher/ibazel/terminal"
+	"github.com/bazelbuild/bazel-watcher/ibazel/workspace_finder"
 )
 
 var Version = "Development"
@@ -32,6 +41,8 @@ var overrideableStartupFlags []string = []string{
 
 var overrideableBazelFlags []string = []string{
 	"--action_env",
+	"--adb=",
+	"--adb_arg=",
 	"--announce_rc",
 	"--compilation_mode",
 	"--config=",
@@ -39,6 +50,7 @@ var overrideableBazelFlags []string = []string{
 	"--curses=no",
 	"-c",
 	"--define=",
+	"--device=",
 	"--features=",
 	"--keep_going",
 	"-k",
@@ -49,7 +61,10 @@ var overrideableBazelFlags []string = []string{
 	"--repo_env",
 	"--runs_per_test=",
 	"--stamp",
+	"--start=",
+	"--start_app",
 	"--strategy=",
+	"--symlink_prefix=",
 	"--test_arg=",
 	"--test_env=",
 	"--test_filter=",
@@ -60,6 +75,10 @@ var overrideableBazelFlags []string = []string{
 
 var debounceDuration = flag.Duration("debounce", 100*time.Millisecond, "Debounce duration")
 var logToFile = flag.String("log_to_file", "-", "Log iBazel stderr to a file instead of os.Stderr")
+var debugLog = flag.Bool("debug", false, "Log debug messages, e.g. the full command line of every Bazel invocation")
+var commandTimeout = flag.Duration("command_timeout", 0, "Interrupt Bazel commands that run for longer than this, e.g. because a repository fetch hangs, and wait for the next change. 0 never interrupts them")
+var cancelGracePeriod = flag.Duration("cancel_grace_period", 10*time.Second, "How long an interrupted Bazel command may take to stop, see --command_timeout, before it is killed. A killed command may keep the Bazel server busy")
+var terminationGracePeriod = flag.Duration("termination_grace_period", 0, "How long the target of run may take to exit after SIGTERM before it is killed with SIGKILL. By default it is killed right away")
 
 func usage() {
 	fmt.Fprintf(os.Stderr, `iBazel - Version %s
@@ -69,14 +88,28 @@ target, run, build, or test the specified targets.
 
 Usage:
 
-ibazel build|test|run [flags] targets...
+ibazel build|test|run|mobile-install [flags] targets...
+ibazel build+test|test+run|build+run [flags] targets...
+ibazel group [flags] [--build targets...] [--test targets...] [--run target]
+ibazel why-not files...
+ibazel check [--check_as=build|test|run|mrun] targets...
+ibazel replay session.tar
 
 Example:
 
 ibazel test //path/to/my/testing:target
 ibazel test //path/to/my/testing/targets/...
+ibazel test //path/to/my/testing:target -- --test_filter=MyTest
 ibazel run //path/to/my/runnable:target -- --arguments --for_your=binary
 ibazel build //path/to/my/buildable:target
+ibazel test+run //path/to/my/testing:target //path/to/my/runnable:target
+ibazel group --build //path/to/my/... --test //path/to/my/testing:target --run //path/to/my/runnable:target
+ibazel --explain test //path/to/my/testing:target
+ibazel why-not path/to/my/source.go
+ibazel check //path/to/my/runnable:target //path/to/my/other:target
+ibazel experiments
+ibazel --record=session.tar build //path/to/my/buildable:target
+ibazel replay session.tar
 
 Supported Bazel startup flags:
   %s
@@ -149,6 +182,16 @@ func parseArgs(in []string) (targets, startupArgs, bazelArgs, args []string, deb
 func main() {
 	flag.Usage = usage
 	flag.Parse()
+	configArgs, err := loadConfig(&workspace_finder.MainWorkspaceFinder{})
+	if err != nil {
+		log.Fatalf("Error reading the configuration: %v", err)
+	}
+
+	if machine_output.Enabled() {
+		// Keep stdout clean for the JSON summaries. Commands started later pick
+		// this up as well, so their output ends up on stderr too.
+		os.Stdout = os.Stderr
+	}
 
 	if *logToFile != "-" {
 		var err error
@@ -157,6 +200,21 @@ func main() {
 			panic(err)
 		}
 		log.SetWriter(logFile)
+		log.SetColor(terminal.Color(logFile))
+	} else {
+		log.SetColor(terminal.Color(os.Stderr))
+	}
+	bazel.SetColor(terminal.Color(os.Stderr))
+	log.SetDebug(*debugLog)
+	bazel.SetDebugLog(log.Debugf)
+	command.SetTerminationGracePeriod(*terminationGracePeriod)
+	if err := experiments.LoadEnv(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if len(flag.Args()) == 1 && flag.Args()[0] == "experiments" {
+		printExperiments(os.Stdout)
+		return
 	}
 
 	if len(flag.Args()) < 2 {
@@ -168,12 +226,52 @@ func main() {
 	args := flag.Args()[1:]
 	os.Setenv("IBAZEL", "true")
 
+	if command == "replay" {
+		if err := replay(os.Stdout, args); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if command == "why-not" {
+		if err := whyNot(os.Stdout, &workspace_finder.MainWorkspaceFinder{}, args); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	if command == "check" {
+		if err := check(append(configArgs, args...)); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
 	i, err := New()
 	if err != nil {
 		log.Fatalf("Error creating iBazel: %s", err)
 	}
-	i.SetDebounceDuration(*debounceDuration)
+	i.SetDebounceDuration(debounce())
+	bazel.SetOutputLines(i.outputLine)
+	bazel.SetCommandTimeout(*commandTimeout)
+	bazel.SetCancelGracePeriod(*cancelGracePeriod)
+	if progress.Enabled() && terminal.Interactive(os.Stderr) {
+		i.progress = progress.New(os.Stderr)
+		bazel.SetStderr(i.progress)
+	}
+	if workspacePath, err := i.workspaceFinder.FindWorkspace(); err == nil {
+		mrun_log.SetWorkspace(workspacePath)
+		i.checkWritable(workspacePath)
+		if bazel_queue.Enabled() && !i.readOnly.temp {
+			bazel.SetLock(bazel_queue.New(workspacePath))
+		}
+		if !i.readOnly.temp {
+			mrun_log.CleanUp()
+		}
+	}
 	defer i.Cleanup()
+	defer i.recoverCrash()
+	i.restartOnSignal()
 
 	// increase the number of files that this process can
 	// have open.
@@ -182,25 +280,61 @@ func main() {
 		log.Errorf("error setting higher file descriptor limit for this process: %v", err)
 	}
 
-	handle(i, command, args)
+	handle(i, command, append(configArgs, args...))
 }
 
 func handle(i *IBazel, command string, args []string) {
 	targets, startupArgs, bazelArgs, args, debugArgs := parseArgs(args)
 	i.SetStartupArgs(startupArgs)
 	i.SetBazelArgs(bazelArgs)
+	i.tuneForLimits()
+
+	if *explain {
+		if command == "test" {
+			i.SetTestArgs(args)
+			args = nil
+		}
+		if err := i.Explain(command, targets, args); err != nil {
+			log.Fatalf("Error explaining %s: %v", command, err)
+		}
+		return
+	}
+
+	i.prebuild(command)
+	i.startKeys(command)
+	i.startControl(command)
 
 	switch command {
 	case "build":
 		i.Build(targets...)
 	case "test":
+		// Everything after the -- is passed to `bazel test`, e.g. --test_filter.
+		i.SetTestArgs(args)
 		i.Test(targets...)
 	case "run":
 		// Run only takes one argument
 		i.Run(targets[0], args)
 	case "mrun":
 		i.RunMultiple(args, targets, debugArgs)
+	case "mobile-install":
+		i.MobileInstall(targets...)
+	case "group":
+		groups, err := parseGroups(targets)
+		if err != nil {
+			log.Fatalf("Invalid group: %v", err)
+			return
+		}
+		i.Group(groups, args)
 	default:
+		if isComposite(command) {
+			verbs, err := compositeVerbs(command, targets)
+			if err != nil {
+				log.Fatalf("Invalid command %s: %v", command, err)
+				return
+			}
+			i.Composite(verbs, targets, args)
+			return
+		}
 		fmt.Fprintf(os.Stderr, "Asked me to perform %s. I don't know how to do that.", command)
 		usage()
 		return
@@ -17,6 +17,8 @@
 package main
 
 import (
+	"os"
+	"os/signal"
 	"runtime"
 	"syscall"
 )
@@ -50,3 +52,22 @@ func setUlimit() error {
 
 	return nil
 }
+
+// restartOnSignal restarts every running target when iBazel receives SIGUSR1.
+func (i *IBazel) restartOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			i.RestartTarget("")
+		}
+	}()
+}
+
+// suspendProcessGroup stops the process group led by pid, or lets it continue.
+func suspendProcessGroup(pid int, suspend bool) error {
+	if suspend {
+		return syscall.Kill(-pid, syscall.SIGSTOP)
+	}
+	return syscall.Kill(-pid, syscall.SIGCONT)
+}
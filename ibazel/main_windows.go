@@ -14,6 +14,16 @@
 
 package main
 
+import (
+	"errors"
+)
+
 func setUlimit() error {
 	return nil
 }
+
+func (i *IBazel) restartOnSignal() {}
+
+func suspendProcessGroup(pid int, suspend bool) error {
+	return errors.New("suspending targets isn't supported on Windows")
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/rss"
+)
+
+var (
+	maxTotalRSS     = flag.Int("max_total_rss", 0, "Don't (re)start the commands of `ibazel run` and `ibazel mrun` while the ones already running keep more than this many MiB of memory resident. 0 means no limit")
+	maxTotalRSSStop = flag.Bool("max_total_rss_stop", false, "When a target of `ibazel mrun` can't start because of --max_total_rss, stop the targets listed after it on the command line to make room for it")
+)
+
+// commandRSS returns the resident memory, in bytes, of cmd and its
+// subprocesses.
+var commandRSS = func(cmd command.Command) (uint64, error) {
+	pid := command.Pid(cmd)
+	if pid == 0 {
+		return 0, nil
+	}
+	return rss.Group(pid)
+}
+
+// memoryGuard is whether restarts are paused because of --max_total_rss, and
+// whether measuring the memory failed, which is only reported once.
+type memoryGuard struct {
+	paused bool
+	failed bool
+}
+
+// runningCommands returns the commands of `ibazel run` or `ibazel mrun` by
+// their target.
+func (i *IBazel) runningCommands() map[string]command.Command {
+	if i.cmd != nil {
+		return map[string]command.Command{"": i.cmd}
+	}
+	return i.cmds
+}
+
+// totalRSS returns the resident memory of all commands in bytes.
+func (i *IBazel) totalRSS() (uint64, error) {
+	var total uint64
+	for _, cmd := range i.runningCommands() {
+		used, err := commandRSS(cmd)
+		if err != nil {
+			return 0, err
+		}
+		total += used
+	}
+	return total, nil
+}
+
+// memoryLimitReached returns whether target can't be (re)started because the
+// commands already running use more than --max_total_rss. It is checked every
+// time a command is about to start, so that a rebuild can't pile another
+// process onto a machine that is already swapping. Targets that didn't start
+// are started again by their next change or by RestartTarget.
+func (i *IBazel) memoryLimitReached(target string) bool {
+	if *maxTotalRSS <= 0 {
+		return false
+	}
+	limit := uint64(*maxTotalRSS) << 20
+	total, err := i.totalRSS()
+	if err != nil {
+		if !i.memory.failed {
+			log.Errorf("Not enforcing --max_total_rss: %v", err)
+			i.memory.failed = true
+		}
+		return false
+	}
+	if total > limit && *maxTotalRSSStop {
+		total = i.stopAfter(target, total, limit)
+	}
+
+	if total <= limit {
+		if i.memory.paused {
+			log.Logf("The commands use %d MiB of memory now, restarting them again", total>>20)
+			i.memory.paused = false
+		}
+		return false
+	}
+	i.memory.paused = true
+	log.Banner(
+		fmt.Sprintf("The commands iBazel runs use %d MiB of memory, more than --max_total_rss=%d.", total>>20, *maxTotalRSS),
+		fmt.Sprintf("Not starting %s until they use less.", target),
+		"Stop some of them or raise --max_total_rss.")
+	return true
+}
+
+// stopAfter stops the running targets of `ibazel mrun` listed after target,
+// starting with the last one, until the commands use no more than limit. It
+// returns how much memory they use then.
+func (i *IBazel) stopAfter(target string, total, limit uint64) uint64 {
+	for idx := len(i.targets) - 1; idx >= 0 && total > limit; idx-- {
+		other := i.targets[idx]
+		if other == target {
+			break
+		}
+		cmd, ok := i.cmds[other]
+		if !ok || !cmd.IsSubprocessRunning() {
+			continue
+		}
+		used, err := commandRSS(cmd)
+		if err != nil {
+			continue
+		}
+		log.Logf("Stopping %s, which uses %d MiB of memory, to make room for %s", other, used>>20, target)
+		cmd.Terminate()
+		total -= used
+	}
+	return total
+}
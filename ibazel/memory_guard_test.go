@@ -0,0 +1,102 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+)
+
+// fakeRSS makes every running mock command use the given MiB of memory.
+func fakeRSS(used map[command.Command]uint64) func() {
+	oldCommandRSS := commandRSS
+	commandRSS = func(cmd command.Command) (uint64, error) {
+		if m, ok := cmd.(*mockCommand); ok && m.terminated {
+			return 0, nil
+		}
+		return used[cmd] << 20, nil
+	}
+	return func() { commandRSS = oldCommandRSS }
+}
+
+func TestIBazelMaxTotalRSS(t *testing.T) {
+	defer setFlag(t, "max_total_rss", "100")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	a := &mockCommand{started: true}
+	b := &mockCommand{started: true}
+	i.cmds = map[string]command.Command{"//path/to:a": a, "//path/to:b": b}
+	i.logFiles = map[string]*os.File{}
+	i.targets = []string{"//path/to:a", "//path/to:b"}
+	defer fakeRSS(map[command.Command]uint64{a: 60, b: 60})()
+
+	if !i.memoryLimitReached("//path/to:a") {
+		t.Errorf("120 MiB should be over the limit of 100 MiB")
+	}
+	assertEqual(t, true, i.memory.paused, "Paused")
+	if a.terminated || b.terminated {
+		t.Errorf("Nothing should be stopped without --max_total_rss_stop")
+	}
+
+	defer setFlag(t, "max_total_rss_stop", "true")()
+	if !i.memoryLimitReached("//path/to:b") {
+		t.Errorf("//path/to:b is the last target, there is nothing to stop for it")
+	}
+	if i.memoryLimitReached("//path/to:a") {
+		t.Errorf("Stopping //path/to:b should have made room for //path/to:a")
+	}
+	b.assertTerminated(t)
+	assertEqual(t, false, i.memory.paused, "Paused")
+}
+
+func TestIBazelMaxTotalRSSRun(t *testing.T) {
+	defer setFlag(t, "max_total_rss", "100")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	cmd := &mockCommand{started: true}
+	i.cmd = cmd
+	defer fakeRSS(map[command.Command]uint64{cmd: 200})()
+
+	i.run("//path/to:target")
+	if cmd.notifiedOfChanges {
+		t.Errorf("The command shouldn't be restarted while it's over --max_total_rss")
+	}
+}
+
+func TestIBazelMaxTotalRSSUnsupported(t *testing.T) {
+	defer setFlag(t, "max_total_rss", "100")()
+
+	oldCommandRSS := commandRSS
+	commandRSS = func(command.Command) (uint64, error) {
+		return 0, errors.New("unsupported")
+	}
+	defer func() { commandRSS = oldCommandRSS }()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.cmd = &mockCommand{started: true}
+
+	if i.memoryLimitReached("//path/to:target") {
+		t.Errorf("The limit can't be reached if the memory can't be measured")
+	}
+	assertEqual(t, true, i.memory.failed, "Failed")
+}
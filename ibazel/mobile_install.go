@@ -0,0 +1,132 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bazel_output"
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/process_group"
+)
+
+var deviceLogs = flag.Bool("device_logs", true, "Stream the Android device log (adb logcat) into the iBazel output during mobile-install")
+var deviceLogFilter = flag.String("device_log_filter", "", "Space separated logcat filterspecs to apply to the device log, e.g. 'MyApp:V *:S'")
+
+var execDeviceLogCommand = process_group.Command
+
+// MobileInstall the specified targets in the IBazel loop, relaunching the app
+// on the connected device or emulator after every install. Like `bazel
+// mobile-install` itself this only supports Android apps, iOS apps are built
+// and run with the regular verbs.
+func (i *IBazel) MobileInstall(targets ...string) error {
+	return i.loop("mobile-install", i.mobileInstall, targets)
+}
+
+func (i *IBazel) mobileInstall(targets ...string) (*bytes.Buffer, error) {
+	b := i.newBazel("mobile-install")
+
+	defer i.inflight.track(b)()
+	b.WriteToStderr(bazel_output.Live())
+	b.WriteToStdout(bazel_output.Live())
+
+	args := targets
+	if !startFlagSet(i.bazelArgs) {
+		// Relaunch the app after it is installed unless the user asked for a
+		// specific start behavior.
+		args = append([]string{"--start_app"}, targets...)
+	}
+
+	outputBuffer, err := b.MobileInstall(args...)
+	i.progress.Clear()
+	bazel_output.Print("mobile-install", targets, err == nil, outputBuffer)
+	if err != nil {
+		if i.goOffline(outputBuffer) {
+			return i.mobileInstall(targets...)
+		}
+		log.Errorf("Mobile install error: %v", err)
+		return outputBuffer, err
+	}
+
+	i.streamDeviceLogs()
+	return outputBuffer, nil
+}
+
+// streamDeviceLogs starts copying the device log into the iBazel output. It
+// is started after the first successful install so that a device is known to
+// be connected, and lives until iBazel exits.
+func (i *IBazel) streamDeviceLogs() {
+	if !*deviceLogs || i.deviceLogs != nil {
+		return
+	}
+
+	adb, err := exec.LookPath("adb")
+	if err != nil {
+		log.Errorf("Not streaming device logs, adb was not found in $PATH")
+		// Don't try again on every iteration.
+		*deviceLogs = false
+		return
+	}
+
+	// Only show messages logged from now on, not the device's entire history.
+	args := []string{"logcat", "-T", "1"}
+	args = append(args, strings.Fields(*deviceLogFilter)...)
+	pg := execDeviceLogCommand(adb, args...)
+
+	stdout, err := pg.RootProcess().StdoutPipe()
+	if err != nil {
+		log.Errorf("Error streaming device logs: %v", err)
+		return
+	}
+	if err := pg.Start(); err != nil {
+		log.Errorf("Error streaming device logs: %v", err)
+		return
+	}
+	i.deviceLogs = pg
+	go copyDeviceLogs(stdout)
+}
+
+func copyDeviceLogs(r io.Reader) {
+	scanner := line_scanner.New(r)
+	for scanner.Scan() {
+		log.Logf("[device] %s", scanner.Text())
+	}
+}
+
+func (i *IBazel) stopDeviceLogs() {
+	if i.deviceLogs == nil {
+		return
+	}
+	i.deviceLogs.Kill()
+	i.deviceLogs.Wait()
+	i.deviceLogs.Close()
+	i.deviceLogs = nil
+}
+
+// startFlagSet returns whether args already pick how mobile-install starts
+// the app, with --start=<mode> or one of the forms of --start_app.
+func startFlagSet(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--start=") || arg == "--start_app" || arg == "--nostart_app" || strings.HasPrefix(arg, "--start_app=") {
+			return true
+		}
+	}
+	return false
+}
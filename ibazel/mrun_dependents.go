@@ -0,0 +1,118 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+)
+
+// mapDependents records which of the targets of `ibazel mrun` depend on each
+// source file, from the files the query found for every target. Only the
+// given targets were queried, so the files of the others are kept.
+func (i *IBazel) mapDependents(toWatchByTarget map[string][]string, targets []string) {
+	if i.srcFileTargets == nil {
+		i.srcFileTargets = map[string][]string{}
+	}
+	for file, dependents := range i.srcFileTargets {
+		kept := dependents[:0]
+		for _, target := range dependents {
+			if !contains(targets, target) {
+				kept = append(kept, target)
+			}
+		}
+		if len(kept) == 0 {
+			delete(i.srcFileTargets, file)
+		} else {
+			i.srcFileTargets[file] = kept
+		}
+	}
+
+	for _, target := range targets {
+		for _, file := range toWatchByTarget[target] {
+			if !contains(i.srcFileTargets[file], target) {
+				i.srcFileTargets[file] = append(i.srcFileTargets[file], target)
+			}
+		}
+	}
+}
+
+// affectedRunTargets returns the targets to restart for the source files
+// changed since the last command, in the order of targets: the ones that
+// depend on a changed file, and the ones the .ibazel_mrun_routes file routes
+// its directory to. A target that only depends on other files in the same
+// directory keeps running. When only directories are watched, see watchTree,
+// the targets depending on any file in the directory of a change count.
+func (i *IBazel) affectedRunTargets(targets []string) []string {
+	dependents := map[string][]string{}
+	for file := range i.changes {
+		dir, _ := filepath.Split(file)
+		fileDependents, ok := i.srcFileTargets[file]
+		if !ok {
+			fileDependents = i.srcDirToWatch[dir]
+		}
+		dependents[dir] = append(dependents[dir], fileDependents...)
+	}
+
+	dirs := make([]string, 0, len(dependents))
+	for dir := range dependents {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	restart := map[string]bool{}
+	for _, dir := range dirs {
+		for _, target := range i.routedTargets(dir, dependents[dir], targets) {
+			restart[target] = true
+		}
+	}
+
+	torun := []string{}
+	for _, target := range targets {
+		if restart[target] {
+			torun = append(torun, target)
+		}
+	}
+	return torun
+}
+
+// changedTargets returns the targets a changed file affects, in the order of
+// targets. Only `ibazel mrun` queries the files of every target separately,
+// everywhere else, and for files it doesn't know, all the targets count.
+func (i *IBazel) changedTargets(targets []string, changeType change.Type, path string) []string {
+	dir, _ := filepath.Split(path)
+	var dependents []string
+	var ok bool
+	switch changeType {
+	case change.Source:
+		if dependents, ok = i.srcFileTargets[path]; !ok {
+			dependents, ok = i.srcDirToWatch[dir]
+		}
+	case change.Graph:
+		dependents, ok = i.bldDirToWatch[dir]
+	}
+	if !ok {
+		return targets
+	}
+
+	affected := []string{}
+	for _, target := range targets {
+		if contains(dependents, target) {
+			affected = append(affected, target)
+		}
+	}
+	return affected
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+)
+
+func TestIBazelAffectedRunTargets(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_mrun_dependents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	file := func(rel string) string { return filepath.Join(workspace, rel) }
+
+	a, b, c := "//svc/a:server", "//svc/b:server", "//svc/c:server"
+	targets := []string{a, b, c}
+	// a and b share a library, and the directory of a also has a file only
+	// c depends on.
+	i.mapDependents(map[string][]string{
+		a: {file("lib/util.go"), file("svc/a/main.go")},
+		b: {file("lib/util.go"), file("svc/b/main.go")},
+		c: {file("svc/a/testing.go"), file("svc/c/main.go")},
+	}, targets)
+
+	for _, tc := range []struct {
+		name    string
+		changes []string
+		want    []string
+	}{
+		{"A file of one target", []string{"svc/b/main.go"}, []string{b}},
+		{"A file shared by two targets", []string{"lib/util.go"}, []string{a, b}},
+		{"A file next to the files of another target", []string{"svc/a/testing.go"}, []string{c}},
+		{"Files of several targets", []string{"svc/c/main.go", "svc/a/main.go"}, []string{a, c}},
+	} {
+		i.changes = map[string]struct{}{}
+		for _, change := range tc.changes {
+			i.changes[file(change)] = struct{}{}
+		}
+		assertEqual(t, tc.want, i.affectedRunTargets(targets), tc.name)
+	}
+
+	// Requerying b drops the library from its files.
+	i.mapDependents(map[string][]string{b: {file("svc/b/main.go")}}, []string{b})
+	i.changes = map[string]struct{}{file("lib/util.go"): {}}
+	assertEqual(t, []string{a}, i.affectedRunTargets(targets), "A file b no longer depends on")
+
+	// Targets that aren't running anymore aren't restarted.
+	assertEqual(t, []string{}, i.affectedRunTargets([]string{b, c}), "A file of a stopped target")
+}
+
+func TestIBazelAffectedRunTargets_treeMode(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder("/ws")
+	dir := filepath.Join("/ws", "svc") + string(filepath.Separator)
+	i.srcDirToWatch = map[string][]string{dir: {"//svc:a"}}
+
+	// A file the query didn't return falls back to its directory.
+	i.changes = map[string]struct{}{filepath.Join(dir, "new.go"): {}}
+	assertEqual(t, []string{"//svc:a"}, i.affectedRunTargets([]string{"//svc:a", "//svc:b"}), "A change in a watched directory")
+}
+
+func TestIBazelChangedTargets(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	a, b := "//svc/a:server", "//svc/b:server"
+	targets := []string{a, b}
+	svcA := filepath.Join("/ws", "svc", "a") + string(filepath.Separator)
+	i.mapDependents(map[string][]string{
+		a: {filepath.Join(svcA, "main.go"), filepath.Join("/ws", "lib", "util.go")},
+		b: {filepath.Join("/ws", "lib", "util.go")},
+	}, targets)
+	i.bldDirToWatch = map[string][]string{svcA: {a}}
+
+	for _, tc := range []struct {
+		name       string
+		changeType change.Type
+		path       string
+		want       []string
+	}{
+		{"A source file of one target", change.Source, filepath.Join(svcA, "main.go"), []string{a}},
+		{"A shared source file", change.Source, filepath.Join("/ws", "lib", "util.go"), []string{a, b}},
+		{"A BUILD file of one target", change.Graph, filepath.Join(svcA, "BUILD"), []string{a}},
+		{"An unknown file", change.Source, filepath.Join("/ws", "README.md"), targets},
+	} {
+		assertEqual(t, tc.want, i.changedTargets(targets, tc.changeType, tc.path), tc.name)
+	}
+}
+
+// stoppingCommand stops in BeforeRebuild, like the commands of run targets.
+type stoppingCommand struct {
+	mockCommand
+}
+
+func (c *stoppingCommand) BeforeRebuild() { c.Terminate() }
+func (c *stoppingCommand) IsSubprocessRunning() bool {
+	return c.started && !c.terminated
+}
+
+func TestIBazelRunMultiple_onlyStopsAffectedTargets(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	a, b := "//svc/a:server", "//svc/b:server"
+	targets := []string{a, b}
+	cmdA, cmdB := &stoppingCommand{mockCommand{started: true}}, &stoppingCommand{mockCommand{started: true}}
+	i.cmds = map[string]command.Command{a: cmdA, b: cmdB}
+	i.logFiles = map[string]*os.File{}
+	i.firstBuildPassed = true
+	i.mapDependents(map[string][]string{
+		a: {filepath.Join("/ws", "svc", "a", "main.go")},
+		b: {filepath.Join("/ws", "svc", "b", "main.go")},
+	}, targets)
+
+	i.changes = map[string]struct{}{filepath.Join("/ws", "docs", "README.md"): {}}
+	i.state = RUN
+	i.iterationMultiple("run", i.runMultiple, targets, nil, 0)
+	if !cmdA.IsSubprocessRunning() || !cmdB.IsSubprocessRunning() {
+		t.Errorf("A change no target depends on stopped a target")
+	}
+
+	i.changes = map[string]struct{}{filepath.Join("/ws", "svc", "a", "main.go"): {}}
+	i.state = RUN
+	i.iterationMultiple("run", i.runMultiple, targets, nil, 0)
+	if !cmdA.terminated || !cmdA.notifiedOfChanges {
+		t.Errorf("%s wasn't restarted after its file changed", a)
+	}
+	if !cmdB.IsSubprocessRunning() || cmdB.notifiedOfChanges {
+		t.Errorf("%s was stopped although it doesn't depend on the changed file", b)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mrun_log writes the output of every `ibazel mrun` target to its own
+// size and age capped log file.
+package mrun_log
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logDir    = flag.String("mrun_log_dir", "", "Directory for the log files of mrun targets. Defaults to a directory for the workspace and the day in $TMPDIR/ibazel-$USER/running")
+	maxSize   = flag.Int64("mrun_log_max_size", 10*1024*1024, "Rotate an mrun log file once it is bigger than this many bytes. 0 disables")
+	maxAge    = flag.Duration("mrun_log_max_age", 24*time.Hour, "Rotate an mrun log file once it is older than this. 0 disables")
+	logStdout = flag.Bool("mrun_log_stdout", false, "Also print the output of mrun targets, prefixed with their label, to the console")
+)
+
+// How many rotated log files are kept per target.
+const maxBackups = 3
+
+var timeNow = time.Now
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// FileName returns the name of target's log file. Characters that aren't safe
+// in file names are replaced and a hash of the label is added, so that
+// //a/b:c and //a:b_c don't share a file.
+func FileName(target string) string {
+	sum := sha1.Sum([]byte(target))
+	name := strings.Trim(unsafeChars.ReplaceAllString(target, "_"), "_")
+	return fmt.Sprintf("%s-%s.txt", name, hex.EncodeToString(sum[:4]))
+}
+
+// Open returns a file to give to target as its stdout and stderr. Everything
+// written to it ends up in target's rotated log file and, with
+// --mrun_log_stdout, on the console. The caller has to close the file once
+// the target is gone, which closes the log file once the target's processes
+// have exited too.
+func Open(target string) (*os.File, error) {
+	dir := Dir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	rf, err := newRotatingFile(filepath.Join(dir, FileName(target)), *maxSize, *maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		rf.Close()
+		return nil, err
+	}
+	go func() {
+		defer r.Close()
+		defer rf.Close()
+
+		var out io.Writer = rf
+		if *logStdout {
+			p := &prefixWriter{w: os.Stdout, prefix: "[" + target + "] "}
+			defer p.Flush()
+			out = io.MultiWriter(rf, p)
+		}
+		io.Copy(out, r)
+	}()
+	return w, nil
+}
+
+// rotatingFile is a log file that is moved to path.1 (and path.1 to path.2,
+// ...) once it gets too big or too old. Its creation time is kept in a hidden
+// file next to it, since file systems don't reliably record it.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	if rf.size == 0 {
+		rf.setCreated(timeNow())
+	} else if created, err := rf.readCreated(); err == nil {
+		rf.created = created
+	} else {
+		// Written before the creation time was kept, the last write is the
+		// closest guess.
+		rf.created = info.ModTime()
+	}
+	return nil
+}
+
+// createdPath is the file that keeps the creation time of the log file.
+func (rf *rotatingFile) createdPath() string {
+	dir, name := filepath.Split(rf.path)
+	return filepath.Join(dir, "."+name+".created")
+}
+
+func (rf *rotatingFile) setCreated(t time.Time) {
+	rf.created = t
+	// Without the file the age is guessed from the last write instead.
+	ioutil.WriteFile(rf.createdPath(), []byte(t.Format(time.RFC3339Nano)), 0644)
+}
+
+func (rf *rotatingFile) readCreated() (time.Time, error) {
+	data, err := ioutil.ReadFile(rf.createdPath())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	tooBig := rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize
+	tooOld := rf.maxAge > 0 && timeNow().Sub(rf.created) > rf.maxAge
+	if (tooBig || tooOld) && rf.file != nil {
+		// Losing the output is worse than a log that is too big or too old,
+		// so a failed rotation keeps appending to the current file.
+		rf.rotate()
+	}
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the log file away and opens a new one. If the file can't be
+// moved it is opened again and only rotated after it has grown by another
+// maxSize or aged by another maxAge. rf.file is nil if it can't be opened.
+func (rf *rotatingFile) rotate() error {
+	rf.file.Close()
+	rf.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, maxBackups))
+	for n := maxBackups - 1; n > 0; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, n), fmt.Sprintf("%s.%d", rf.path, n+1))
+	}
+	renameErr := os.Rename(rf.path, rf.path+".1")
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		rf.size = 0
+		rf.created = timeNow()
+	}
+	return renameErr
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	return rf.file.Close()
+}
+
+// Serializes the lines of all targets written to the console, so that they
+// don't end up in the middle of each other.
+var consoleLock sync.Mutex
+
+// prefixWriter prefixes every line written to it. Only complete lines are
+// written, the start of a line is held back until its end arrives or Flush is
+// called.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	// The start of a line that hasn't ended yet.
+	partial []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	end := bytes.LastIndexByte(p.partial, '\n')
+	if end < 0 {
+		return len(b), nil
+	}
+	err := p.writeLines(p.partial[:end+1])
+	p.partial = append(p.partial[:0], p.partial[end+1:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes the start of a line that never ended.
+func (p *prefixWriter) Flush() error {
+	if len(p.partial) == 0 {
+		return nil
+	}
+	err := p.writeLines(append(p.partial, '\n'))
+	p.partial = nil
+	return err
+}
+
+func (p *prefixWriter) writeLines(lines []byte) error {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		buf.WriteString(p.prefix)
+		buf.Write(line)
+	}
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+	_, err := p.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mrun_log
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileName(t *testing.T) {
+	a := FileName("//a/b:c")
+	b := FileName("//ab:c")
+	if a == b {
+		t.Errorf("//a/b:c and //ab:c share the log file %s", a)
+	}
+	if !strings.HasPrefix(a, "a_b_c-") || !strings.HasSuffix(a, ".txt") {
+		t.Errorf("FileName(//a/b:c) = %q", a)
+	}
+	if FileName("//a/b:c") != a {
+		t.Errorf("FileName isn't stable")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mrun_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	path := filepath.Join(dir, "log.txt")
+	rf, err := newRotatingFile(path, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	write := func(s string) {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(path string) string {
+		b, _ := ioutil.ReadFile(path)
+		return string(b)
+	}
+
+	write("12345")
+	write("67890")
+	// Over the size cap.
+	write("abc")
+	if read(path+".1") != "1234567890" || read(path) != "abc" {
+		t.Errorf("Size rotation: %q, %q", read(path+".1"), read(path))
+	}
+
+	// Over the age cap.
+	now = now.Add(2 * time.Hour)
+	write("def")
+	if read(path+".2") != "1234567890" || read(path+".1") != "abc" || read(path) != "def" {
+		t.Errorf("Age rotation: %q, %q, %q", read(path+".2"), read(path+".1"), read(path))
+	}
+
+	for n := 0; n < maxBackups+2; n++ {
+		write("0123456789")
+	}
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("Kept more than %d backups", maxBackups)
+	}
+}
+
+func TestRotatingFile_created(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mrun_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	path := filepath.Join(dir, "log.txt")
+	rf, err := newRotatingFile(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("old"))
+	rf.Close()
+
+	// The file was written to just now, but it was created two hours ago.
+	now = now.Add(2 * time.Hour)
+	rf, err = newRotatingFile(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("new"))
+	if b, _ := ioutil.ReadFile(path + ".1"); string(b) != "old" {
+		t.Errorf("A log file older than --mrun_log_max_age wasn't rotated when it was opened again: %q", b)
+	}
+}
+
+func TestRotatingFile_rotateError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mrun_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.txt")
+	// The log file can't be moved onto the backups, they are directories that
+	// aren't empty.
+	for n := 1; n <= maxBackups; n++ {
+		if err := os.MkdirAll(filepath.Join(fmt.Sprintf("%s.%d", path, n), "busy"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf, err := newRotatingFile(path, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, s := range []string{"12345", "67890", "abc"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatalf("Write(%q) failed after a rotation failed: %v", s, err)
+		}
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "1234567890abc" {
+		t.Errorf("%s = %q, want everything that was written", path, b)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	p := &prefixWriter{w: &out, prefix: "[//a:b] "}
+	p.Write([]byte("one\ntw"))
+	p.Write([]byte("o\nthree\n"))
+	expected := "[//a:b] one\n[//a:b] two\n[//a:b] three\n"
+	if out.String() != expected {
+		t.Errorf("Got %q, want %q", out.String(), expected)
+	}
+}
+
+func TestPrefixWriter_interleaved(t *testing.T) {
+	var out bytes.Buffer
+	a := &prefixWriter{w: &out, prefix: "[a] "}
+	b := &prefixWriter{w: &out, prefix: "[b] "}
+	a.Write([]byte("one, "))
+	b.Write([]byte("two\n"))
+	a.Write([]byte("three\nfour"))
+	a.Flush()
+	expected := "[b] two\n[a] one, three\n[a] four\n"
+	if out.String() != expected {
+		t.Errorf("Got %q, want %q", out.String(), expected)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mrun_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	*logDir = dir
+
+	w, err := Open("//a:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("hello\n")
+	w.Close()
+
+	path := filepath.Join(dir, FileName("//a:b"))
+	var got []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got, _ = ioutil.ReadFile(path); string(got) == "hello\n" {
+			return
+		}
+	}
+	t.Errorf("%s = %q, want %q", path, got, "hello\n")
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mrun_log
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+var staleLogs = flag.String("stale_logs", "keep", "What to do on startup with the mrun logs that earlier days left in the workspace's log directory: keep, clean or archive them")
+
+// The layout of the names of the directories for one day's logs.
+const dayLayout = "2006-01-02"
+
+var workspace string
+
+// SetWorkspace namespaces the log files by the workspace, so that sessions in
+// different workspaces don't write to each other's logs.
+func SetWorkspace(path string) {
+	workspace = path
+}
+
+// workspaceDir is where the logs of every day in the workspace go.
+func workspaceDir() string {
+	sum := sha1.Sum([]byte(workspace))
+	name := fmt.Sprintf("%s-%s", unsafeChars.ReplaceAllString(filepath.Base(workspace), "_"), hex.EncodeToString(sum[:4]))
+	return temp_dir.Path("running", name)
+}
+
+// Dir returns the directory the log files are written to: --mrun_log_dir if
+// given, or else a directory for today in the workspace's log directory.
+func Dir() string {
+	if *logDir != "" {
+		return *logDir
+	}
+	return filepath.Join(workspaceDir(), timeNow().Format(dayLayout))
+}
+
+// staleDirs returns the log directories of earlier days in the workspace.
+func staleDirs() []string {
+	if *logDir != "" || workspace == "" {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(workspaceDir())
+	if err != nil {
+		return nil
+	}
+	today := timeNow().Format(dayLayout)
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == today {
+			continue
+		}
+		if _, err := time.Parse(dayLayout, entry.Name()); err == nil {
+			dirs = append(dirs, filepath.Join(workspaceDir(), entry.Name()))
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// CleanUp deals with the logs of earlier days in the workspace as
+// --stale_logs says. By default it only points them out.
+func CleanUp() {
+	dirs := staleDirs()
+	if len(dirs) == 0 {
+		return
+	}
+
+	switch *staleLogs {
+	case "clean":
+		for _, dir := range dirs {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Errorf("Error removing old mrun logs: %v", err)
+				return
+			}
+		}
+		log.Logf("Removed the mrun logs of %d earlier days", len(dirs))
+	case "archive":
+		archive := filepath.Join(workspaceDir(), fmt.Sprintf("archive-%s.tar.gz", timeNow().Format("2006-01-02-150405")))
+		if err := archiveDirs(archive, dirs); err != nil {
+			os.Remove(archive)
+			log.Errorf("Error archiving old mrun logs: %v", err)
+			return
+		}
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+		log.Logf("Archived the mrun logs of %d earlier days to %s", len(dirs), archive)
+	case "keep":
+		log.Logf("The mrun logs of %d earlier days are in %s. Pass --stale_logs=clean or --stale_logs=archive to remove or archive them", len(dirs), workspaceDir())
+	default:
+		log.Errorf("Unknown --stale_logs=%s, it has to be keep, clean or archive", *staleLogs)
+	}
+}
+
+// archiveDirs writes the files of dirs to a gzipped tarball, each in a
+// directory named after its day.
+func archiveDirs(archive string, dirs []string) error {
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !file.Mode().IsRegular() {
+				continue
+			}
+			header, err := tar.FileInfoHeader(file, "")
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(filepath.Join(filepath.Base(dir), file.Name()))
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if err := copyFile(tw, filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mrun_log
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleLogs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "mrun_log_stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	oldTmpDir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", tmp)
+	defer os.Setenv("TMPDIR", oldTmpDir)
+	*logDir = ""
+	SetWorkspace("/home/me/ws")
+	defer SetWorkspace("")
+	timeNow = func() time.Time { return time.Date(2020, 5, 3, 10, 0, 0, 0, time.Local) }
+	defer func() { timeNow = time.Now }()
+
+	today := Dir()
+	if !strings.HasPrefix(today, tmp) || filepath.Base(today) != "2020-05-03" || !strings.HasPrefix(filepath.Base(filepath.Dir(today)), "ws-") {
+		t.Errorf("Logs go to %s", today)
+	}
+	SetWorkspace("/home/me/other/ws")
+	if Dir() == today {
+		t.Errorf("Two workspaces share %s", today)
+	}
+	SetWorkspace("/home/me/ws")
+
+	for _, day := range []string{"2020-05-01", "2020-05-02", "2020-05-03"} {
+		dir := filepath.Join(workspaceDir(), day)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte(day), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	*staleLogs = "archive"
+	defer func() { *staleLogs = "keep" }()
+	CleanUp()
+
+	archives, _ := filepath.Glob(filepath.Join(workspaceDir(), "archive-*.tar.gz"))
+	if len(archives) != 1 {
+		t.Fatalf("Wanted one archive, got %v", archives)
+	}
+	f, err := os.Open(archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	if want := "2020-05-01/a.txt 2020-05-02/a.txt"; strings.Join(names, " ") != want {
+		t.Errorf("Archived %v, want %s", names, want)
+	}
+	if len(staleDirs()) != 0 {
+		t.Errorf("The archived logs weren't removed: %v", staleDirs())
+	}
+	if _, err := os.Stat(filepath.Join(today, "a.txt")); err != nil {
+		t.Errorf("Today's logs were touched: %v", err)
+	}
+}
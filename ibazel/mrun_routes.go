@@ -0,0 +1,104 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// The file in the root of the workspace that overrides which targets of
+// `ibazel mrun` are restarted for changes in a directory.
+const mrunRoutesFile = ".ibazel_mrun_routes"
+
+// mrunRoute restarts targets for changes in the directories matching a
+// pattern, in addition to the targets that depend on the changed files.
+type mrunRoute struct {
+	packagePattern
+	// The targets to restart, nil for all of them.
+	targets []string
+}
+
+// readMrunRoutes reads the routes in the .ibazel_mrun_routes file of the
+// workspace. Every line is a directory pattern followed by the targets to
+// restart for changes under it, or `*` for all targets, e.g.
+//
+//	//proto/... *
+//	//api/... //services/gateway:server //services/users:server
+//
+// Lines starting with # are ignored. The file is read on every change, so
+// that edits take effect without restarting iBazel.
+func readMrunRoutes(workspacePath string) []mrunRoute {
+	path := filepath.Join(workspacePath, mrunRoutesFile)
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Error reading %s: %v", path, err)
+		}
+		return nil
+	}
+	defer f.Close()
+
+	var routes []mrunRoute
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		pkg, ok := parsePackagePattern(fields[0])
+		if !ok || len(fields) < 2 {
+			log.Errorf("%s:%d: expected a directory like //proto/... followed by targets or *", path, n)
+			continue
+		}
+		route := mrunRoute{packagePattern: pkg}
+		if !contains(fields[1:], "*") {
+			route.targets = fields[1:]
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// routedTargets returns the targets to restart for changes in dir: the
+// dependents of the changed files, and the ones routed to it by the
+// .ibazel_mrun_routes file, among the targets being run.
+func (i *IBazel) routedTargets(dir string, dependents []string, targets []string) []string {
+	torun := append([]string{}, dependents...)
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return torun
+	}
+	rel, ok := workspaceRel(workspacePath, dir)
+	if !ok {
+		return torun
+	}
+	for _, route := range readMrunRoutes(workspacePath) {
+		if !route.matches(rel) {
+			continue
+		}
+		for _, target := range targets {
+			if (route.targets == nil || contains(route.targets, target)) && !contains(torun, target) {
+				log.Logf("Restarting %s for changes in %s, see %s", target, route.packagePattern, mrunRoutesFile)
+				torun = append(torun, target)
+			}
+		}
+	}
+	return torun
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIBazelRoutedTargets(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_mrun_routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	routes := `# Generated APIs need every service restarted.
+//proto/... *
+//api //svc/a:server //svc/c:server
+not a route
+`
+	if err := ioutil.WriteFile(filepath.Join(workspace, mrunRoutesFile), []byte(routes), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	dir := func(rel string) string { return filepath.Join(workspace, rel) + string(filepath.Separator) }
+	i.srcDirToWatch = map[string][]string{
+		dir("svc/a"):      {"//svc/a:server"},
+		dir("proto/user"): {"//svc/b:server"},
+	}
+	targets := []string{"//svc/a:server", "//svc/b:server"}
+
+	assertEqual(t, []string{"//svc/a:server"}, i.routedTargets(dir("svc/a"), i.srcDirToWatch[dir("svc/a")], targets), "A directory without routes")
+	assertEqual(t, []string{"//svc/b:server", "//svc/a:server"}, i.routedTargets(dir("proto/user"), i.srcDirToWatch[dir("proto/user")], targets), "A directory routed to all targets")
+	assertEqual(t, []string{"//svc/a:server"}, i.routedTargets(dir("api"), nil, targets), "A directory routed to some targets")
+	assertEqual(t, []string{}, i.routedTargets(dir("api/v1"), nil, targets), "A subdirectory of a route without /...")
+}
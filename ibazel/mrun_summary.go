@@ -0,0 +1,97 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// mrunResult is what happened to a single target in an mrun iteration.
+type mrunResult struct {
+	rebuilt   bool
+	restarted bool
+	// Whether the target waits for the ones started before it, see
+	// startBatch.
+	pending bool
+	err     string
+}
+
+// mrunSummary renders the results of an mrun iteration as a table, one row per
+// target.
+func mrunSummary(results map[string]*mrunResult) string {
+	targets := make([]string, 0, len(results))
+	for target := range results {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "-"
+	}
+
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tREBUILT\tRESTARTED\tSTATUS")
+	for _, target := range targets {
+		r := results[target]
+		status := "ok"
+		if r.err != "" {
+			status = "FAILED: " + r.err
+		} else if r.pending {
+			status = "starting later"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", target, yesNo(r.rebuilt), yesNo(r.restarted), status)
+	}
+	w.Flush()
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// firstError returns the first Bazel error in output that is about target's
+// package, or else the first error.
+func firstError(output *bytes.Buffer, target string) string {
+	if output == nil {
+		return ""
+	}
+	pkg := strings.TrimPrefix(target, "//")
+	if idx := strings.Index(pkg, ":"); idx >= 0 {
+		pkg = pkg[:idx]
+	}
+
+	first := ""
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		line := strings.TrimSpace(log.StripColor(scanner.Text()))
+		if !strings.HasPrefix(line, "ERROR: ") {
+			continue
+		}
+		if strings.Contains(line, target) || (pkg != "" && strings.Contains(line, "/"+pkg+"/")) {
+			return line
+		}
+		if first == "" {
+			first = line
+		}
+	}
+	return first
+}
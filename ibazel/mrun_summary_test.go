@@ -0,0 +1,68 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMrunSummary(t *testing.T) {
+	summary := mrunSummary(map[string]*mrunResult{
+		"//b:server": &mrunResult{rebuilt: true, err: "ERROR: /ws/b/BUILD:1:1: oops"},
+		"//a:server": &mrunResult{rebuilt: true, restarted: true},
+		"//c:server": &mrunResult{},
+	})
+	expected := "" +
+		"TARGET      REBUILT  RESTARTED  STATUS\n" +
+		"//a:server  yes      yes        ok\n" +
+		"//b:server  yes      -          FAILED: ERROR: /ws/b/BUILD:1:1: oops\n" +
+		"//c:server  -        -          ok"
+	if summary != expected {
+		t.Errorf("Unexpected summary.\nGot:\n%s\nWant:\n%s", summary, expected)
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	output := bytes.NewBufferString("" +
+		"INFO: Analyzed 2 targets\n" +
+		"\x1b[31mERROR: \x1b[0m/ws/a/BUILD:3:1: Compiling a/main.go failed\n" +
+		"ERROR: /ws/b/server/BUILD:3:1: Compiling b/server/main.go failed\n" +
+		"ERROR: Build did NOT complete successfully\n")
+
+	for target, expected := range map[string]string{
+		"//a:server":        "ERROR: /ws/a/BUILD:3:1: Compiling a/main.go failed",
+		"//b/server:server": "ERROR: /ws/b/server/BUILD:3:1: Compiling b/server/main.go failed",
+		"//c:server":        "ERROR: /ws/a/BUILD:3:1: Compiling a/main.go failed",
+	} {
+		if actual := firstError(output, target); actual != expected {
+			t.Errorf("firstError(%s) = %q, want %q", target, actual, expected)
+		}
+	}
+	if firstError(nil, "//a:server") != "" {
+		t.Errorf("firstError(nil) should be empty")
+	}
+}
+
+func TestFirstError_longLines(t *testing.T) {
+	// A stack trace on a single line used to end the scan before the error.
+	output := bytes.NewBufferString("INFO: " + strings.Repeat("at com.example.Foo.bar(Foo.java:1) ", 5000) + "\n" +
+		"ERROR: /ws/a/BUILD:3:1: Compiling a/Main.java failed\n")
+	want := "ERROR: /ws/a/BUILD:3:1: Compiling a/Main.java failed"
+	if actual := firstError(output, "//a:server"); actual != want {
+		t.Errorf("firstError() = %q, want %q", actual, want)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var offlineRetry = flag.Bool("offline_retry", true, "When a build or test fails because a repository can't be downloaded without network, retry it with --nofetch and keep using the repositories that were already fetched until the network is back")
+
+// networkError matches what Bazel and the JVM print when a download fails
+// because there is no network, as opposed to e.g. a missing file.
+var networkError = regexp.MustCompile(`UnknownHostException|Unknown host|no such host|Network is unreachable|Temporary failure in name resolution|nodename nor servname provided|Could not resolve host|No route to host`)
+
+// fetchError matches the errors Bazel prints about a repository that couldn't
+// be fetched or a file that couldn't be downloaded.
+var fetchError = regexp.MustCompile(`(?i)fetch|download`)
+
+// bazelMessage matches the first line of a message Bazel prints, e.g.
+// "ERROR: ...". The lines up to the next message are part of it.
+var bazelMessage = regexp.MustCompile(`^(INFO|WARNING|ERROR|DEBUG|FAIL):`)
+
+var downloadHost = regexp.MustCompile(`https?://([^/:\s'"\]]+)`)
+
+// How often to check whether the network is back, while offline.
+const offlineRecheck = 30 * time.Second
+
+// hostReachable tells whether a connection to host can be opened. It is a
+// variable so that tests don't depend on the network.
+var hostReachable = func(host string) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "443"), 2*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// offlineState is whether iBazel builds with --nofetch because the network
+// is down.
+type offlineState struct {
+	offline bool
+	// The host a download failed for, which is checked to tell when the
+	// network is back. Empty if Bazel didn't print it.
+	host string
+	// When the network was last checked.
+	checked time.Time
+	// Receives whether host is reachable, while a check runs in the
+	// background. Nil when no check is running.
+	reachable chan bool
+}
+
+// fetchFailedOffline returns whether the output of a failed Bazel command
+// shows that a repository couldn't be fetched because there is no network,
+// and the host that couldn't be reached, if Bazel printed one.
+func fetchFailedOffline(output string) (string, bool) {
+	for _, message := range bazelErrors(output) {
+		if !fetchError.MatchString(message) || !networkError.MatchString(message) {
+			continue
+		}
+		host := ""
+		if match := downloadHost.FindStringSubmatch(message); match != nil {
+			host = match[1]
+		}
+		return host, true
+	}
+	return "", false
+}
+
+// bazelErrors returns the ERROR messages in the output of a Bazel command,
+// each with the lines that follow it, leaving out e.g. the output of tests.
+func bazelErrors(output string) []string {
+	var errors []string
+	var message *strings.Builder
+	for _, line := range strings.Split(output, "\n") {
+		line = log.StripColor(strings.TrimRight(line, "\r"))
+		if match := bazelMessage.FindStringSubmatch(line); match != nil {
+			if message != nil {
+				errors = append(errors, message.String())
+				message = nil
+			}
+			if match[1] == "ERROR" {
+				message = &strings.Builder{}
+			}
+		}
+		if message != nil {
+			message.WriteString(line)
+			message.WriteString("\n")
+		}
+	}
+	if message != nil {
+		errors = append(errors, message.String())
+	}
+	return errors
+}
+
+// goOffline is called with the output of a failed build, test or
+// mobile-install. It returns whether the command failed because the network
+// is down and should be retried with the repositories that were already
+// fetched.
+func (i *IBazel) goOffline(output *bytes.Buffer) bool {
+	if !*offlineRetry || i.offline.offline || output == nil {
+		return false
+	}
+	host, ok := fetchFailedOffline(output.String())
+	if !ok {
+		return false
+	}
+	i.offline = offlineState{offline: true, host: host, checked: time.Now()}
+	log.SetStatus("offline")
+	log.Banner(
+		"Fetching a repository failed because the network is down.",
+		"Retrying with --nofetch, using the repositories that were already fetched.",
+		"iBazel keeps building offline until the network is back.")
+	return true
+}
+
+// offlineArgs returns the flags that keep Bazel from fetching while the
+// network is down. Every so often it checks in the background whether the
+// network is back, and fetches again from the first command after it is.
+func (i *IBazel) offlineArgs() []string {
+	if !i.offline.offline {
+		return nil
+	}
+	select {
+	case reachable := <-i.offline.reachable:
+		i.offline.reachable = nil
+		if reachable {
+			i.goOnline()
+			return nil
+		}
+	default:
+	}
+	if i.offline.reachable == nil && time.Since(i.offline.checked) >= offlineRecheck {
+		i.offline.checked = time.Now()
+		// Without a host to check, the next build tries to fetch again and
+		// goes back offline if that still fails.
+		if i.offline.host == "" {
+			i.goOnline()
+			return nil
+		}
+		reachable := make(chan bool, 1)
+		i.offline.reachable = reachable
+		go func(host string) {
+			reachable <- hostReachable(host)
+		}(i.offline.host)
+	}
+	return []string{"--nofetch"}
+}
+
+func (i *IBazel) goOnline() {
+	i.offline = offlineState{}
+	log.SetStatus("")
+	log.Logf("The network is back, fetching repositories again")
+}
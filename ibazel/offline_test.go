@@ -0,0 +1,86 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+const offlineOutput = `ERROR: An error occurred during the fetch of repository 'rules_go':
+Error downloading [https://github.com/bazelbuild/rules_go/releases/download/v0.41.0/rules_go-v0.41.0.zip] to /cache/rules_go/temp.zip: Unknown host: github.com
+`
+
+func TestFetchFailedOffline(t *testing.T) {
+	host, ok := fetchFailedOffline(offlineOutput)
+	assertEqual(t, true, ok, "Failed offline")
+	assertEqual(t, "github.com", host, "Host")
+
+	_, ok = fetchFailedOffline("ERROR: /ws/foo/BUILD:3:5: no such target '//foo:bar'\n")
+	assertEqual(t, false, ok, "Failed offline without a network error")
+
+	_, ok = fetchFailedOffline(`INFO: From Testing //foo:client_test:
+Error downloading https://example.com/data: Unknown host: example.com
+ERROR: //foo:client_test failed
+`)
+	assertEqual(t, false, ok, "Failed offline with a network error printed by a test")
+}
+
+func TestIBazelOffline(t *testing.T) {
+	defer log.SetStatus("")
+	reachable := false
+	oldHostReachable := hostReachable
+	hostReachable = func(host string) bool {
+		assertEqual(t, "github.com", host, "Checked host")
+		return reachable
+	}
+	defer func() { hostReachable = oldHostReachable }()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	if i.goOffline(bytes.NewBufferString("ERROR: something else broke\n")) {
+		t.Errorf("Went offline without a network error")
+	}
+	if !i.goOffline(bytes.NewBufferString(offlineOutput)) {
+		t.Fatalf("Didn't go offline")
+	}
+	if i.goOffline(bytes.NewBufferString(offlineOutput)) {
+		t.Errorf("Retried a build that already ran offline")
+	}
+	assertEqual(t, []string{"--nofetch"}, i.bazelArgsFor("build"), "Flags while offline")
+
+	// The network is checked in the background, and the flags stay until
+	// the check says it's back.
+	waitForCheck := func() {
+		for len(i.offline.reachable) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	i.offline.checked = time.Now().Add(-offlineRecheck)
+	assertEqual(t, []string{"--nofetch"}, i.bazelArgsFor("query"), "Flags while checking the network")
+	waitForCheck()
+	assertEqual(t, []string{"--nofetch"}, i.bazelArgsFor("query"), "Flags while the network is still down")
+
+	reachable = true
+	i.offline.checked = time.Now().Add(-offlineRecheck)
+	assertEqual(t, []string{"--nofetch"}, i.bazelArgsFor("build"), "Flags while checking the network again")
+	waitForCheck()
+	assertEqual(t, 0, len(i.bazelArgsFor("build")), "Flags once the network is back")
+	assertEqual(t, false, i.offline.offline, "Offline once the network is back")
+}
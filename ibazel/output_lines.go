@@ -0,0 +1,62 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bazel_output"
+	"github.com/bazelbuild/bazel-watcher/ibazel/test_output"
+)
+
+// runningCommand is the command whose output is handed to the
+// OutputListeners. It is set by the main loop and read by the goroutines
+// copying Bazel's output.
+type runningCommand struct {
+	mu      sync.Mutex
+	targets []string
+	command string
+}
+
+func (r *runningCommand) set(targets []string, command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.targets = targets
+	r.command = command
+}
+
+func (r *runningCommand) get() ([]string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.targets, r.command
+}
+
+// outputLine hands a line of Bazel's output to the OutputListeners, and to
+// the progress line while the output is held back. Output of no command, as of
+// a query, is dropped.
+func (i *IBazel) outputLine(line string) {
+	targets, command := i.running.get()
+	if command == "" {
+		return
+	}
+	if !bazel_output.Live() || (command == "test" && !test_output.Live()) {
+		i.progress.Draw(line)
+	}
+	for _, l := range i.lifecycleListeners {
+		if ol, ok := l.(OutputListener); ok {
+			i.callListener(l, "OutputLine", func() { ol.OutputLine(targets, command, line) })
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lineRecorder is a listener that follows the output of commands.
+type lineRecorder struct {
+	phaseRecorder
+}
+
+func (r *lineRecorder) OutputLine(targets []string, command string, line string) {
+	*r.phases = append(*r.phases, fmt.Sprintf("%s %s: %s", command, strings.Join(targets, " "), line))
+}
+
+func TestIBazelOutputLine(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&lineRecorder{phaseRecorder{&phases}}}
+
+	i.outputLine("Loading")
+	i.beforeCommand([]string{"//path/to:target"}, "build")
+	i.outputLine("INFO: Build completed successfully")
+	i.afterCommand([]string{"//path/to:target"}, "build", true, nil)
+	i.outputLine("Querying")
+
+	assertEqual(t, []string{
+		"before build //path/to:target",
+		"build //path/to:target: INFO: Build completed successfully",
+		"after build //path/to:target",
+	}, phases, "Phases")
+}
@@ -28,8 +28,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
 	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/terminal"
 	"github.com/bazelbuild/bazel-watcher/ibazel/workspace_finder"
 	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
 )
@@ -46,8 +49,18 @@ var (
 	notifiedUser = false
 )
 
-// This RegExp will match ANSI escape codes.
-var escapeCodeCleanerRegex = regexp.MustCompile("\\x1B\\[[\\x30-\\x3F]*[\\x20-\\x2F]*[\\x40-\\x7E]")
+// When the last fix command started and finished, see FixedAt.
+var fixStart, fixEnd time.Time
+
+// FixedAt returns whether t falls while the last fix command ran, e.g. to tell
+// that a file was changed by it. t is compared at the second, the precision
+// of the modification time of some file systems.
+func FixedAt(t time.Time) bool {
+	if fixStart.IsZero() {
+		return false
+	}
+	return !t.Before(fixStart.Truncate(time.Second)) && !t.After(fixEnd)
+}
 
 type OutputRunner struct {
 	wf workspace_finder.WorkspaceFinder
@@ -93,7 +106,10 @@ func (i *OutputRunner) AfterCommand(targets []string, command string, success bo
 	}
 	commandLines, commands, args := matchRegex(optcmd, output)
 	for idx, _ := range commandLines {
-		if *runOutputInteractive {
+		if *runOutputInteractive && terminal.NoTTY() {
+			// Nobody could answer the prompt.
+			log.Logf("Not executing %q: it needs --run_output_interactive=false with --no_tty", commandLines[idx])
+		} else if *runOutputInteractive {
 			if i.promptCommand(commandLines[idx]) {
 				i.executeCommand(commands[idx], args[idx])
 			}
@@ -141,9 +157,9 @@ func (o *OutputRunner) readConfigs(configPath string) []Optcmd {
 func matchRegex(optcmd []Optcmd, output *bytes.Buffer) ([]string, []string, [][]string) {
 	var commandLines, commands []string
 	var args [][]string
-	scanner := bufio.NewScanner(output)
+	scanner := line_scanner.New(output)
 	for scanner.Scan() {
-		line := escapeCodeCleanerRegex.ReplaceAllLiteralString(scanner.Text(), "")
+		line := log.StripColor(scanner.Text())
 		for _, oc := range optcmd {
 			re := regexp.MustCompile(oc.Regex)
 			matches := re.FindStringSubmatch(line)
@@ -178,9 +194,11 @@ func convertArgs(matches []string, args []string) []string {
 	return rst
 }
 
-func (_ *OutputRunner) promptCommand(command string) bool {
+// confirm asks question on stderr and reports whether it was answered with
+// y on stdin, unless SetConfirm replaced it.
+var confirm = func(question string) bool {
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Fprintf(os.Stderr, "Do you want to execute this command?\n%s\n[y/N]", command)
+	fmt.Fprintf(os.Stderr, "%s[y/N]", question)
 	text, _ := reader.ReadString('\n')
 	text = strings.ToLower(text)
 	text = strings.TrimSpace(text)
@@ -192,6 +210,16 @@ func (_ *OutputRunner) promptCommand(command string) bool {
 	}
 }
 
+// SetConfirm replaces how the interactive prompt is answered, e.g. when
+// something else reads stdin already.
+func SetConfirm(f func(question string) bool) {
+	confirm = f
+}
+
+func (_ *OutputRunner) promptCommand(command string) bool {
+	return confirm(fmt.Sprintf("Do you want to execute this command?\n%s\n", command))
+}
+
 func (o *OutputRunner) executeCommand(command string, args []string) {
 	for i, arg := range args {
 		args[i] = strings.TrimSpace(arg)
@@ -211,7 +239,9 @@ func (o *OutputRunner) executeCommand(command string, args []string) {
 	cmd.Stderr = os.Stderr
 	cmd.Dir = workspacePath
 
+	fixStart = time.Now()
 	err = cmd.Run()
+	fixEnd = time.Now()
 	if err != nil {
 		log.Errorf("Command failed: %s %s. Error: %s", command, args, err)
 	}
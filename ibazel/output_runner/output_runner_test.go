@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/bazel-watcher/ibazel/workspace_finder"
 )
@@ -165,3 +166,28 @@ func TestMatchCleanRegex(t *testing.T) {
 	}
 
 }
+
+func TestFixedAt(t *testing.T) {
+	defer func() { fixStart, fixEnd = time.Time{}, time.Time{} }()
+
+	now := time.Date(2020, 1, 1, 10, 0, 30, 500000000, time.UTC)
+	if FixedAt(now) {
+		t.Errorf("FixedAt before any fix command ran")
+	}
+
+	fixStart, fixEnd = now, now.Add(2*time.Second)
+	for _, c := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{now.Add(time.Second), true},
+		// Some file systems only keep the second.
+		{now.Truncate(time.Second), true},
+		{now.Add(-time.Second), false},
+		{now.Add(3 * time.Second), false},
+	} {
+		if got := FixedAt(c.t); got != c.want {
+			t.Errorf("FixedAt(%v) = %v, want %v", c.t, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultSymlinkPrefix = "bazel-"
+
+// symlinkPrefix returns the --symlink_prefix Bazel was told to use for the
+// convenience symlinks in the workspace, "/" meaning it doesn't create any.
+func symlinkPrefix(bazelArgs []string) string {
+	prefix := defaultSymlinkPrefix
+	for n, arg := range bazelArgs {
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "--symlink_prefix=") {
+			prefix = strings.TrimPrefix(arg, "--symlink_prefix=")
+		} else if arg == "--symlink_prefix" && n+1 < len(bazelArgs) {
+			prefix = bazelArgs[n+1]
+		}
+	}
+	return prefix
+}
+
+// outputTree returns the directories Bazel writes build outputs to. They are
+// looked up once, the first time they are needed.
+func (i *IBazel) outputTree() []string {
+	if i.outputDirs != nil {
+		return i.outputDirs
+	}
+	i.outputDirs = []string{}
+	info, err := i.getInfo()
+	if err != nil {
+		return i.outputDirs
+	}
+	i.outputBase = (*info)["output_base"]
+	if dir, ok := (*info)["output_path"]; ok && dir != "" {
+		i.outputDirs = append(i.outputDirs, dir)
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil && resolved != dir {
+			i.outputDirs = append(i.outputDirs, resolved)
+		}
+	}
+	return i.outputDirs
+}
+
+// isBuildOutput returns whether path is inside one of the convenience
+// symlinks in the workspace or inside Bazel's output tree. Watching those
+// turns every build into a change that triggers the next one.
+func (i *IBazel) isBuildOutput(workspacePath, path string) bool {
+	for _, dir := range i.outputTree() {
+		if isUnder(path, dir) {
+			return true
+		}
+	}
+
+	prefix := symlinkPrefix(i.bazelArgs)
+	if prefix == "/" {
+		return false
+	}
+	rel, err := filepath.Rel(workspacePath, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, prefix) {
+		return false
+	}
+	// Only the symlink itself is matched, a source directory that happens to
+	// start with the prefix is not.
+	name := prefix + strings.SplitN(strings.TrimPrefix(rel, prefix), "/", 2)[0]
+	info, err := os.Lstat(filepath.Join(workspacePath, filepath.FromSlash(name)))
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkPrefix(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		prefix string
+	}{
+		{nil, "bazel-"},
+		{[]string{"--symlink_prefix=out/"}, "out/"},
+		{[]string{"--config=ci", "--symlink_prefix", "/"}, "/"},
+		{[]string{"--", "--symlink_prefix=out/"}, "bazel-"},
+	} {
+		if prefix := symlinkPrefix(c.args); prefix != c.prefix {
+			t.Errorf("symlinkPrefix(%v) = %q, want %q", c.args, prefix, c.prefix)
+		}
+	}
+}
+
+func TestIsBuildOutput(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_output_tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	outputPath := filepath.Join(workspace, "output_base", "execroot", "ws", "bazel-out")
+	for _, dir := range []string{outputPath, filepath.Join(workspace, "bazel-lib")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outputPath, filepath.Join(workspace, "bazel-out")); err != nil {
+		t.Skipf("Can't create symlinks: %v", err)
+	}
+
+	i := &IBazel{outputDirs: []string{outputPath}}
+	for _, c := range []struct {
+		path   string
+		output bool
+	}{
+		{filepath.Join(workspace, "bazel-out", "k8-fastbuild", "bin", "main"), true},
+		{filepath.Join(outputPath, "k8-fastbuild", "bin", "main"), true},
+		{filepath.Join(workspace, "bazel-lib", "lib.go"), false},
+		{filepath.Join(workspace, "src", "main.go"), false},
+	} {
+		if output := i.isBuildOutput(workspace, c.path); output != c.output {
+			t.Errorf("isBuildOutput(%q) = %v, want %v", c.path, output, c.output)
+		}
+	}
+
+	i.bazelArgs = []string{"--symlink_prefix=/"}
+	if i.isBuildOutput(workspace, filepath.Join(workspace, "bazel-out", "main")) {
+		t.Errorf("--symlink_prefix=/ doesn't create convenience symlinks")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/junit"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/mrun_log"
+	"github.com/fsnotify/fsnotify"
+)
+
+var ignoreOwnChanges = flag.Duration("ignore_own_changes", 0, "Ignore changes to watched files that iBazel itself wrote, such as JUnit reports or mrun logs in the workspace, while Bazel ran or for this long after it finished. 0 watches every change")
+
+// commandSpan is when the last Bazel command started and finished.
+type commandSpan struct {
+	start time.Time
+	end   time.Time
+}
+
+func (s *commandSpan) started() {
+	s.start = time.Now()
+	s.end = time.Time{}
+}
+
+func (s *commandSpan) finished() {
+	if s.start.IsZero() || !s.end.IsZero() {
+		// The start of the command wasn't reported.
+		s.start = time.Now()
+	}
+	s.end = time.Now()
+}
+
+// contains returns whether t falls in the span, widened by window after the
+// command finished.
+func (s *commandSpan) contains(t time.Time, window time.Duration) bool {
+	if s.start.IsZero() || s.end.IsZero() {
+		return false
+	}
+	return !t.Before(s.start) && !t.After(s.end.Add(window))
+}
+
+// ownOutputDirs returns the directories iBazel itself writes files to while
+// or right after a command runs, which may be inside of the workspace.
+func ownOutputDirs() []string {
+	var dirs []string
+	for _, dir := range []string{junit.OutputDir(), mrun_log.Dir()} {
+		if dir == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			dirs = append(dirs, abs)
+		}
+	}
+	return dirs
+}
+
+// ownChange returns whether the change to a watched file was made by iBazel
+// itself, e.g. a JUnit report or an mrun log written into a watched directory.
+// Rebuilding on those changes writes them again and never stops. Only the
+// paths iBazel writes to are matched, so that a developer's save during a
+// build is never dropped; the modification time tells an old file apart from
+// one written by the last command.
+func (i *IBazel) ownChange(e fsnotify.Event) bool {
+	if *ignoreOwnChanges <= 0 || e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		return false
+	}
+	own := false
+	for _, dir := range ownOutputDirs() {
+		own = own || isUnder(e.Name, dir)
+	}
+	if !own {
+		return false
+	}
+	info, err := os.Stat(e.Name)
+	if err != nil || !i.lastCommand.contains(info.ModTime(), *ignoreOwnChanges) {
+		return false
+	}
+	log.Logf("Changed: %q, but iBazel wrote it. Ignoring it.", i.displayPath(e.Name))
+	return true
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestIBazelOwnChanges(t *testing.T) {
+	defer setFlag(t, "ignore_own_changes", "1s")()
+
+	dir, err := ioutil.TempDir("", "own_changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reports := filepath.Join(dir, "reports")
+	if err := os.Mkdir(reports, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer setFlag(t, "junit_output_dir", reports)()
+	touch := func(name string, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.lastCommand.started()
+	i.lastCommand.finished()
+	now := time.Now()
+
+	for _, c := range []struct {
+		name  string
+		mtime time.Time
+		own   bool
+	}{
+		{"reports/before.xml", now.Add(-time.Hour), false},
+		{"reports/right_after.xml", now, true},
+		{"reports/after.xml", now.Add(time.Hour), false},
+		// A developer's save during the build.
+		{"right_after.go", now, false},
+	} {
+		e := fsnotify.Event{Op: fsnotify.Write, Name: touch(c.name, c.mtime)}
+		assertEqual(t, c.own, i.ownChange(e), c.name)
+	}
+	assertEqual(t, false, i.ownChange(fsnotify.Event{Op: fsnotify.Remove, Name: filepath.Join(reports, "right_after.xml")}), "Removed file")
+
+	defer setFlag(t, "ignore_own_changes", "0")()
+	assertEqual(t, false, i.ownChange(fsnotify.Event{Op: fsnotify.Write, Name: filepath.Join(reports, "right_after.xml")}), "Disabled")
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// packageRoots returns the directories Bazel looks for the packages of the
+// main repository in, in order: the `bazel info package_path`, which is just
+// the workspace unless --package_path says otherwise.
+func (i *IBazel) packageRoots(workspacePath string) []string {
+	if i.packagePath == "" {
+		return []string{workspacePath}
+	}
+	var roots []string
+	for _, root := range filepath.SplitList(i.packagePath) {
+		root = strings.Replace(root, "%workspace%", workspacePath, 1)
+		roots = append(roots, i.userPath(workspacePath, filepath.Clean(root)))
+	}
+	return roots
+}
+
+// mainRepoPath is labelToPath for a package path with several roots: the file
+// is in the first root with a BUILD file for its package.
+func (i *IBazel) mainRepoPath(workspacePath string, s string) (string, bool) {
+	path, ok := labelToPath(workspacePath, s)
+	roots := i.packageRoots(workspacePath)
+	if !ok || len(roots) == 1 {
+		return path, ok
+	}
+	l, _ := parseLabel(s)
+	if i.packageRootsFound == nil {
+		i.packageRootsFound = map[string]string{}
+	}
+	root, found := i.packageRootsFound[l.pkg]
+	if !found {
+		root = workspacePath
+		for _, r := range roots {
+			if hasBuildFile(filepath.Join(r, filepath.FromSlash(l.pkg))) {
+				root = r
+				break
+			}
+		}
+		i.packageRootsFound[l.pkg] = root
+	}
+	return filepath.Join(root, l.relPath()), true
+}
+
+func hasBuildFile(dir string) bool {
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// userPath converts a path Bazel reported into one below the workspace as the
+// user reached it. Bazel resolves the symlinks in the path of the workspace,
+// while iBazel watches the path it was started in, so that it matches the
+// paths editors report.
+func (i *IBazel) userPath(workspacePath string, path string) string {
+	if i.canonicalWorkspace == nil {
+		i.canonicalWorkspace = map[string]string{}
+	}
+	canonical, ok := i.canonicalWorkspace[workspacePath]
+	if !ok {
+		canonical = workspacePath
+		if resolved, err := filepath.EvalSymlinks(workspacePath); err == nil {
+			canonical = resolved
+		}
+		i.canonicalWorkspace[workspacePath] = canonical
+	}
+	if canonical == workspacePath {
+		return path
+	}
+	if rel, err := filepath.Rel(canonical, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.Join(workspacePath, rel)
+	}
+	return path
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestIBazelPackagePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_package_path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace := filepath.Join(dir, "workspace")
+	other := filepath.Join(dir, "other")
+	for _, d := range []string{filepath.Join(workspace, "a"), filepath.Join(other, "b")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, "BUILD"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.packagePath = strings.Join([]string{"%workspace%", other}, string(filepath.ListSeparator))
+	assertEqual(t, []string{workspace, other}, i.packageRoots(workspace), "Package roots")
+
+	for label, want := range map[string]string{
+		"//a:a.go": filepath.Join(workspace, "a", "a.go"),
+		"//b:b.go": filepath.Join(other, "b", "b.go"),
+		"//c:c.go": filepath.Join(workspace, "c", "c.go"),
+	} {
+		path, _ := i.sourcePath(workspace, label)
+		assertEqual(t, want, path, label)
+	}
+}
+
+func TestIBazelUserPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Creating symlinks needs privileges on Windows")
+	}
+	dir, err := ioutil.TempDir("", "ibazel_user_path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := filepath.Join(dir, "canonical")
+	if err := os.Mkdir(canonical, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(canonical, link); err != nil {
+		t.Fatal(err)
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	assertEqual(t, filepath.Join(link, "a", "BUILD"), i.userPath(link, filepath.Join(canonical, "a", "BUILD")), "Path in the workspace")
+	assertEqual(t, filepath.Join(dir, "elsewhere"), i.userPath(link, filepath.Join(dir, "elsewhere")), "Path outside the workspace")
+	assertEqual(t, filepath.Join(canonical, "a"), i.userPath(canonical, filepath.Join(canonical, "a")), "Workspace without symlinks")
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// caseInsensitive is whether the file systems of the platform ignore case by
+// default, so that an editor and a query may spell the same file differently.
+var caseInsensitive = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// pathKey returns a spelling of path that is the same for every path naming
+// the same file: it is cleaned, its directory has its symlinks resolved and
+// it is lower case where the file system ignores case. The file itself doesn't
+// have to exist, so that the key of a removed file is still found. dirs caches
+// the resolved directories and may be nil. A directory that can't be resolved,
+// e.g. because it doesn't exist yet, isn't cached.
+func pathKey(path string, dirs map[string]string) string {
+	dir, base := filepath.Split(filepath.Clean(path))
+	resolved, ok := dirs[dir]
+	if !ok {
+		resolved = dir
+		if r, err := filepath.EvalSymlinks(dir); err == nil {
+			resolved = r
+			if dirs != nil {
+				dirs[dir] = resolved
+			}
+		}
+	}
+	key := filepath.Join(resolved, base)
+	if caseInsensitive {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// setWatched replaces the files watched by watcher. The resolved directories
+// are forgotten, since a symlink may have changed along with the files.
+func (i *IBazel) setWatched(watcher fSNotifyWatcher, files map[string]struct{}) {
+	i.resolvedDirs = map[string]string{}
+	keys := make(map[string]string, len(files))
+	for file := range files {
+		keys[pathKey(file, i.resolvedDirs)] = file
+	}
+	if i.watchedKeys == nil {
+		i.watchedKeys = map[fSNotifyWatcher]map[string]string{}
+	}
+	i.filesWatched[watcher] = files
+	i.watchedKeys[watcher] = keys
+}
+
+// addWatched adds a file to the ones watched by watcher.
+func (i *IBazel) addWatched(watcher fSNotifyWatcher, file string) {
+	if i.filesWatched[watcher] == nil {
+		i.filesWatched[watcher] = map[string]struct{}{}
+	}
+	if i.watchedKeys == nil {
+		i.watchedKeys = map[fSNotifyWatcher]map[string]string{}
+	}
+	if i.watchedKeys[watcher] == nil {
+		i.watchedKeys[watcher] = map[string]string{}
+	}
+	i.filesWatched[watcher][file] = struct{}{}
+	i.watchedKeys[watcher][pathKey(file, i.resolvedDirs)] = file
+}
+
+// watchedEvent renames the file of an event to the spelling the query used
+// for it, if the watcher reported it differently, so that it is recognized as
+// watched. The events of the different editors and platforms are normalized,
+// e.g. atomic saves are reported as writes, see normalizeEvent.
+func (i *IBazel) watchedEvent(watcher fSNotifyWatcher, e fsnotify.Event) fsnotify.Event {
+	i.recordFileEvent(watcher, e)
+	if _, ok := i.filesWatched[watcher][e.Name]; !ok {
+		if file, ok := i.watchedKeys[watcher][pathKey(e.Name, i.resolvedDirs)]; ok {
+			e.Name = file
+		}
+	}
+	return i.normalizedEvent(watcher, e)
+}
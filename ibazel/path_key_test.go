@@ -0,0 +1,74 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestIBazelWatchedEvent(t *testing.T) {
+	oldCaseInsensitive := caseInsensitive
+	defer func() { caseInsensitive = oldCaseInsensitive }()
+	caseInsensitive = true
+
+	dir, err := ioutil.TempDir("", "ibazel_path_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	realDir := filepath.Join(dir, "real")
+	if err := os.Mkdir(realDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	symlinks := runtime.GOOS != "windows"
+	if symlinks {
+		if err := os.Symlink(realDir, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	w := &fakeFSNotifyWatcher{}
+	watched := filepath.Join(realDir, "Main.go")
+	i.setWatched(w, map[string]struct{}{watched: struct{}{}})
+
+	names := []string{
+		watched,
+		filepath.Join(realDir, "main.go"),
+		filepath.Join(realDir, ".", "MAIN.GO"),
+	}
+	if symlinks {
+		names = append(names, filepath.Join(link, "main.go"))
+	}
+	for _, name := range names {
+		e := i.watchedEvent(w, fsnotify.Event{Op: fsnotify.Write, Name: name})
+		assertEqual(t, watched, e.Name, name)
+	}
+	if symlinks {
+		if _, ok := i.resolvedDirs[link+string(filepath.Separator)]; !ok {
+			t.Errorf("The resolution of %q isn't cached", link)
+		}
+	}
+	e := i.watchedEvent(w, fsnotify.Event{Op: fsnotify.Write, Name: filepath.Join(realDir, "other.go")})
+	assertEqual(t, filepath.Join(realDir, "other.go"), e.Name, "A file that isn't watched")
+}
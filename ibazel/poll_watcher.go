@@ -0,0 +1,332 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// racyWindow is how recently a file must have been modified for the
+// pollWatcher to also hash its content. Network filesystems often keep
+// modification times with a granularity of a second or worse, so a file that
+// is written twice in quick succession can keep both its size and its
+// modification time.
+const racyWindow = 2 * time.Second
+
+// pollWatcher is a fSNotifyWatcher that detects changes by periodically
+// stat-ing everything it watches, and hashing the files modified in the last
+// racyWindow. It is much more expensive than fsnotify but
+// works on filesystems that don't deliver change notifications, like network
+// mounts and the shared folders of most container and VM runtimes.
+type pollWatcher struct {
+	interval time.Duration
+
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	lock sync.Mutex // guards watches
+	// watches maps each watched path to a snapshot of the entries inside of it.
+	// Watched files are represented as a snapshot containing only themselves.
+	watches map[string]map[string]os.FileInfo
+	// hashes has the content hash of the files modified in the last
+	// racyWindow.
+	hashes map[string][sha1.Size]byte
+}
+
+var _ fSNotifyWatcher = &pollWatcher{}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		watches:  map[string]map[string]os.FileInfo{},
+		hashes:   map[string][sha1.Size]byte{},
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+func (w *pollWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	w.wg.Wait()
+	close(w.events)
+	close(w.errors)
+	return nil
+}
+
+func (w *pollWatcher) Add(name string) error {
+	name = filepath.Clean(name)
+	snapshot, err := snapshot(name)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.watches[name] = snapshot
+	return nil
+}
+
+func (w *pollWatcher) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, ok := w.watches[name]; !ok {
+		return fmt.Errorf("can't remove non-existent poll watch for: %s", name)
+	}
+	delete(w.watches, name)
+	return nil
+}
+
+func (w *pollWatcher) Events() chan fsnotify.Event { return w.events }
+func (w *pollWatcher) Errors() chan error          { return w.errors }
+func (w *pollWatcher) Watcher() *fsnotify.Watcher  { return nil }
+
+func (w *pollWatcher) loop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		// Don't hold the lock while sending events, the consumer may want to
+		// Add or Remove watches in response to them.
+		for _, e := range w.poll() {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// poll rescans every watched path and returns the events that describe the
+// differences from the previous scan.
+func (w *pollWatcher) poll() []fsnotify.Event {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var events []fsnotify.Event
+	now := time.Now()
+	for name, old := range w.watches {
+		current, err := snapshot(name)
+		if err != nil {
+			// The watched path itself is gone, report everything inside of it as
+			// removed and stop watching it like fsnotify does.
+			current = map[string]os.FileInfo{}
+			delete(w.watches, name)
+		} else {
+			w.watches[name] = current
+		}
+		events = append(events, diffSnapshots(old, current)...)
+		events = append(events, w.hashChanges(old, current, now)...)
+	}
+	return events
+}
+
+// hashChanges returns Write events for the files whose content changed
+// although their size and modification time stayed the same, and remembers
+// the hash of the files that were modified in the last racyWindow.
+func (w *pollWatcher) hashChanges(old, current map[string]os.FileInfo, now time.Time) []fsnotify.Event {
+	var events []fsnotify.Event
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			delete(w.hashes, name)
+		}
+	}
+	for name, info := range current {
+		if !info.Mode().IsRegular() || now.Sub(info.ModTime()) > racyWindow {
+			delete(w.hashes, name)
+			continue
+		}
+		sum, err := hashFile(name)
+		if err != nil {
+			delete(w.hashes, name)
+			continue
+		}
+		prevSum, hashed := w.hashes[name]
+		w.hashes[name] = sum
+		prev, ok := old[name]
+		if ok && hashed && prevSum != sum && prev.ModTime().Equal(info.ModTime()) && prev.Size() == info.Size() {
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+		}
+	}
+	return events
+}
+
+func hashFile(name string) ([sha1.Size]byte, error) {
+	var sum [sha1.Size]byte
+	f, err := os.Open(name)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+func snapshot(name string) (map[string]os.FileInfo, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return map[string]os.FileInfo{name: info}, nil
+	}
+
+	entries, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	s := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		s[filepath.Join(name, entry.Name())] = entry
+	}
+	return s, nil
+}
+
+func diffSnapshots(old, current map[string]os.FileInfo) []fsnotify.Event {
+	var events []fsnotify.Event
+	for name, info := range current {
+		prev, ok := old[name]
+		if !ok {
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Create})
+		} else if !prev.ModTime().Equal(info.ModTime()) || prev.Size() != info.Size() {
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+		}
+	}
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+		}
+	}
+	return events
+}
+
+// hybridWatcher merges the events of several watchers, for filesystems where
+// fsnotify only sees part of the changes (e.g. edits made inside a container
+// but not edits made on the host).
+type hybridWatcher struct {
+	watchers []fSNotifyWatcher
+
+	events chan fsnotify.Event
+	errors chan error
+	wg     sync.WaitGroup
+}
+
+var _ fSNotifyWatcher = &hybridWatcher{}
+
+func newHybridWatcher(watchers ...fSNotifyWatcher) *hybridWatcher {
+	w := &hybridWatcher{
+		watchers: watchers,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+	}
+	for _, watcher := range watchers {
+		w.wg.Add(1)
+		go w.forward(watcher)
+	}
+	go func() {
+		w.wg.Wait()
+		close(w.events)
+		close(w.errors)
+	}()
+	return w
+}
+
+func (w *hybridWatcher) forward(watcher fSNotifyWatcher) {
+	defer w.wg.Done()
+	events, errors := watcher.Events(), watcher.Errors()
+	for events != nil || errors != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			w.events <- e
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			w.errors <- err
+		}
+	}
+}
+
+func (w *hybridWatcher) Close() error {
+	var err error
+	for _, watcher := range w.watchers {
+		if e := watcher.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (w *hybridWatcher) Add(name string) error {
+	for _, watcher := range w.watchers {
+		if err := watcher.Add(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *hybridWatcher) Remove(name string) error {
+	var err error
+	for _, watcher := range w.watchers {
+		if e := watcher.Remove(name); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (w *hybridWatcher) Events() chan fsnotify.Event { return w.events }
+func (w *hybridWatcher) Errors() chan error          { return w.errors }
+func (w *hybridWatcher) Watcher() *fsnotify.Watcher  { return nil }
@@ -0,0 +1,160 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestChooseWatchBackend(t *testing.T) {
+	for _, c := range []struct {
+		fstype  string
+		backend string
+	}{
+		{"", fsnotifyBackend},
+		{"ext4", fsnotifyBackend},
+		{"apfs", fsnotifyBackend},
+		{"9p", pollBackend},
+		{"fuse.grpcfuse", pollBackend},
+		{"nfs4", pollBackend},
+		{"overlay", hybridBackend},
+	} {
+		backend, reason := chooseWatchBackend(c.fstype)
+		if backend != c.backend {
+			t.Errorf("chooseWatchBackend(%q) = %q, want %q", c.fstype, backend, c.backend)
+		}
+		if backend != fsnotifyBackend && reason == "" {
+			t.Errorf("chooseWatchBackend(%q) didn't explain why %s was chosen", c.fstype, backend)
+		}
+	}
+}
+
+func expectEvent(t *testing.T, w fSNotifyWatcher, want fsnotify.Event) {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		if e != want {
+			t.Errorf("Got event %v, want %v", e, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for event %v", want)
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_poll_watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "foo.txt")
+
+	w := newPollWatcher(10 * time.Millisecond)
+	defer w.Close()
+
+	if err := w.Add(dir + string(filepath.Separator)); err != nil {
+		t.Fatalf("Add(%q): %v", dir, err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, fsnotify.Event{Name: file, Op: fsnotify.Create})
+
+	if err := ioutil.WriteFile(file, []byte("ab"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, fsnotify.Event{Name: file, Op: fsnotify.Write})
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, fsnotify.Event{Name: file, Op: fsnotify.Remove})
+
+	if err := w.Remove(dir); err != nil {
+		t.Errorf("Remove(%q): %v", dir, err)
+	}
+	if err := w.Remove(dir); err == nil {
+		t.Errorf("Removing %q twice should have failed", dir)
+	}
+}
+
+func TestPollWatcher_Close(t *testing.T) {
+	w := newPollWatcher(time.Millisecond)
+	w.Close()
+
+	// If the channels weren't closed this will block and the test will timeout.
+	<-w.Events()
+	<-w.Errors()
+}
+
+func TestPollWatcher_sameSizeAndTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_poll_watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "foo.txt")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Like a filesystem that only keeps whole seconds.
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Polled by hand.
+	w := newPollWatcher(time.Hour)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	recent := time.Now().Truncate(time.Second)
+	write("a", recent)
+	assertEqual(t, []fsnotify.Event{{Name: file, Op: fsnotify.Create}}, w.poll(), "Events after creating the file")
+	write("b", recent)
+	assertEqual(t, []fsnotify.Event{{Name: file, Op: fsnotify.Write}}, w.poll(), "Events after changing the file")
+	var none []fsnotify.Event
+	assertEqual(t, none, w.poll(), "Events without changes")
+
+	// Files modified a while ago aren't hashed.
+	old := recent.Add(-time.Hour)
+	write("c", old)
+	w.poll()
+	write("d", old)
+	assertEqual(t, none, w.poll(), "Events after changing an old file")
+}
+
+func TestWatchBackendFlag(t *testing.T) {
+	defer setFlag(t, "watch_backend", "poll")()
+	backend, _ := watchBackend("")
+	assertEqual(t, pollBackend, backend, "Backend")
+	assertEqual(t, defaultPollInterval, pollInterval(pollBackend), "Poll interval")
+	assertEqual(t, defaultHybridPollInterval, pollInterval(hybridBackend), "Hybrid poll interval")
+
+	defer setFlag(t, "poll_interval", "5s")()
+	assertEqual(t, 5*time.Second, pollInterval(pollBackend), "Poll interval with --poll_interval")
+}
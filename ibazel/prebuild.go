@@ -0,0 +1,65 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bazel_output"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var prebuildTargets = flag.String("prebuild", "", "Comma separated targets to build once when iBazel starts, before the first iteration, e.g. //foo/..., to warm up Bazel's caches and analysis graph")
+
+// prebuild builds the --prebuild targets once, with the flags of the first
+// Bazel command of the session so that Bazel keeps their analysis. A failure
+// only gets logged, the targets aren't watched.
+func (i *IBazel) prebuild(command string) {
+	var targets []string
+	for _, target := range strings.Split(*prebuildTargets, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	log.Logf("Warming up: building %s", strings.Join(targets, " "))
+	start := time.Now()
+	b := i.newBazel(prebuildCommand(command))
+	b.WriteToStderr(bazel_output.Live())
+	b.WriteToStdout(bazel_output.Live())
+	outputBuffer, err := b.Build(targets...)
+	if err != nil {
+		bazel_output.Print("build", targets, false, outputBuffer)
+		log.Errorf("The warm-up build failed, continuing anyway: %v", err)
+		return
+	}
+	log.Logf("Warmed up in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// prebuildCommand returns the Bazel command whose flags the warm-up build of
+// the iBazel command gets: the first verb of `ibazel test+run`, and run for
+// `ibazel mrun`.
+func prebuildCommand(command string) string {
+	command = strings.Split(command, "+")[0]
+	if command == "mrun" {
+		return "run"
+	}
+	return command
+}
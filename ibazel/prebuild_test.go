@@ -0,0 +1,49 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestIBazelPrebuild(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	built := mockBazel
+	i.prebuild("build")
+	if mockBazel != built {
+		t.Errorf("Bazel was run without --prebuild")
+	}
+
+	defer setFlag(t, "prebuild", "//foo/..., //bar:baz")()
+	i.prebuild("test")
+	mockBazel.AssertActions(t, [][]string{
+		[]string{"WriteToStderr"},
+		[]string{"WriteToStdout"},
+		[]string{"Build", "//foo/...", "//bar:baz"},
+	})
+}
+
+func TestPrebuildCommand(t *testing.T) {
+	for command, want := range map[string]string{
+		"build":    "build",
+		"test":     "test",
+		"mrun":     "run",
+		"test+run": "test",
+	} {
+		assertEqual(t, want, prebuildCommand(command), command)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// presetFlag holds the numbered sets of targets given with --preset.
+type presetFlag map[int][]string
+
+var presets = presetFlag{}
+
+func init() {
+	flag.Var(&presets, "preset", "A numbered set of targets to switch to at runtime, e.g. `1=//server` or `2=//server,//worker`. Can be repeated")
+}
+
+func (p *presetFlag) String() string {
+	if p == nil {
+		return ""
+	}
+	numbers := []int{}
+	for n := range *p {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	presets := []string{}
+	for _, n := range numbers {
+		presets = append(presets, fmt.Sprintf("%d=%s", n, strings.Join((*p)[n], ",")))
+	}
+	return strings.Join(presets, " ")
+}
+
+func (p *presetFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("%q isn't of the form N=//target,//target", value)
+	}
+	number, list := parts[0], parts[1]
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 || n > 9 {
+		return fmt.Errorf("the preset number %q must be a digit", number)
+	}
+	targets := []string{}
+	for _, target := range strings.Split(list, ",") {
+		if target = strings.TrimSpace(target); target == "" {
+			continue
+		}
+		if _, ok := parseLabel(target); !ok {
+			return fmt.Errorf("%q isn't a valid target", target)
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("preset %d has no targets", n)
+	}
+	(*p)[n] = targets
+	return nil
+}
+
+// SwitchPreset replaces the targets with the ones of preset n. Under
+// `ibazel mrun` the targets that aren't part of the new preset are stopped
+// and the new ones are started after the next build, while the targets both
+// presets share keep running. Like AddTarget, it is safe to call from any
+// goroutine.
+func (i *IBazel) SwitchPreset(n int) error {
+	targets, ok := presets[n]
+	if !ok {
+		return fmt.Errorf("there is no preset %d", n)
+	}
+	return i.editTargets(targetEdit{replace: targets})
+}
+
+// stopRemovedTargets terminates the commands of `ibazel mrun` whose targets
+// are no longer being run.
+func (i *IBazel) stopRemovedTargets() {
+	kept := map[string]struct{}{}
+	for _, target := range i.targets {
+		kept[target] = struct{}{}
+	}
+	stopped := []string{}
+	for target := range i.cmds {
+		if _, ok := kept[target]; !ok {
+			stopped = append(stopped, target)
+		}
+	}
+	sort.Strings(stopped)
+	for _, target := range stopped {
+		log.Logf("Stopping %s", target)
+		if cmd := i.cmds[target]; cmd.IsSubprocessRunning() {
+			cmd.Terminate()
+		}
+		delete(i.cmds, target)
+		if file := i.logFiles[target]; file != nil {
+			file.Close()
+		}
+		delete(i.logFiles, target)
+		i.closeSockets(target)
+		i.closeProxies(target)
+	}
+}
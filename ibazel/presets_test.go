@@ -0,0 +1,75 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+)
+
+func TestPresetFlag(t *testing.T) {
+	p := presetFlag{}
+	for _, value := range []string{"1=//server", "2=//server, //worker:bin"} {
+		if err := p.Set(value); err != nil {
+			t.Errorf("Set(%q): %v", value, err)
+		}
+	}
+	assertEqual(t, "1=//server 2=//server,//worker:bin", p.String(), "Presets")
+
+	for _, value := range []string{"//server", "x=//server", "10=//server", "3=", "3=server"} {
+		if err := p.Set(value); err == nil {
+			t.Errorf("Set(%q) should have failed", value)
+		}
+	}
+}
+
+func TestIBazelSwitchPreset(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	oldPresets := presets
+	presets = presetFlag{2: []string{"//path/to:b", "//path/to:c"}}
+	defer func() { presets = oldPresets }()
+
+	a := &mockCommand{started: true}
+	b := &mockCommand{started: true}
+	i.cmds = map[string]command.Command{
+		"//path/to:a": a,
+		"//path/to:b": b,
+	}
+	i.logFiles = map[string]*os.File{}
+	i.targets = []string{"//path/to:a", "//path/to:b"}
+	i.state = WAIT
+
+	if err := i.SwitchPreset(2); err != nil {
+		t.Errorf("SwitchPreset: %v", err)
+	}
+	i.iterationMultiple("run", i.runMultiple, i.targets, nil, 0)
+	assertEqual(t, []string{"//path/to:b", "//path/to:c"}, i.targets, "Targets")
+	assertEqual(t, QUERY, i.state, "State")
+	a.assertTerminated(t)
+	if b.terminated {
+		t.Errorf("//path/to:b is in both presets and shouldn't have been stopped")
+	}
+	if _, ok := i.cmds["//path/to:a"]; ok {
+		t.Errorf("//path/to:a is still one of the commands")
+	}
+
+	if err := i.SwitchPreset(3); err == nil {
+		t.Errorf("Expected an error switching to a preset that doesn't exist")
+	}
+}
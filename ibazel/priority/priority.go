@@ -0,0 +1,137 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package priority sorts changed files into priority classes, so that e.g. a
+// change to documentation doesn't rebuild as eagerly as a change to code.
+package priority
+
+import (
+	"flag"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	low = flag.String(
+		"priority_low",
+		"",
+		"Comma separated list of file patterns, e.g. \"*.md,docs/\", whose changes are batched into a rebuild every --priority_low_interval")
+	none = flag.String(
+		"priority_none",
+		"",
+		"Comma separated list of file patterns whose changes are logged but never trigger a rebuild")
+	lowInterval = flag.Duration(
+		"priority_low_interval",
+		30*time.Second,
+		"How long changes to --priority_low files are collected before they trigger a rebuild")
+)
+
+// A Class is how urgently a change to a file is rebuilt.
+type Class int
+
+const (
+	// High changes are rebuilt right away. It is the class of every file
+	// that isn't matched by a pattern.
+	High Class = iota
+	// Low changes are batched and rebuilt every LowInterval, or together with
+	// the next High change.
+	Low
+	// None changes are logged and otherwise ignored.
+	None
+)
+
+func (c Class) String() string {
+	switch c {
+	case High:
+		return "high"
+	case Low:
+		return "low"
+	case None:
+		return "none"
+	}
+	return "unknown"
+}
+
+// LowInterval returns how long Low changes are batched for.
+func LowInterval() time.Duration {
+	return *lowInterval
+}
+
+// Classifier assigns the files of a workspace to their Class.
+type Classifier struct {
+	workspacePath string
+	patterns      []pattern
+}
+
+type pattern struct {
+	glob  string
+	class Class
+}
+
+// New creates a Classifier from the --priority_low and --priority_none
+// flags. A pattern without a slash, like "*.md", is matched against the file
+// name, one with a slash against the path relative to workspacePath, and one
+// ending with a slash, like "docs/", matches everything in that directory.
+// When a file matches both, None wins.
+func New(workspacePath string) *Classifier {
+	c := &Classifier{workspacePath: workspacePath}
+	c.add(*none, None)
+	c.add(*low, Low)
+	return c
+}
+
+func (c *Classifier) add(patterns string, class Class) {
+	for _, glob := range strings.Split(patterns, ",") {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			c.patterns = append(c.patterns, pattern{filepath.ToSlash(glob), class})
+		}
+	}
+}
+
+// Classify returns the Class of file, which is either absolute or relative
+// to the workspace.
+func (c *Classifier) Classify(file string) Class {
+	if c == nil || len(c.patterns) == 0 {
+		return High
+	}
+	rel := file
+	if filepath.IsAbs(file) {
+		if r, err := filepath.Rel(c.workspacePath, file); err == nil {
+			rel = r
+		}
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, p := range c.patterns {
+		if p.matches(rel) {
+			return p.class
+		}
+	}
+	return High
+}
+
+func (p pattern) matches(rel string) bool {
+	switch {
+	case strings.HasSuffix(p.glob, "/"):
+		return strings.HasPrefix(rel, p.glob)
+	case !strings.Contains(p.glob, "/"):
+		ok, _ := path.Match(p.glob, path.Base(rel))
+		return ok
+	default:
+		ok, _ := path.Match(p.glob, rel)
+		return ok
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package priority
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	oldLow, oldNone := *low, *none
+	defer func() { *low, *none = oldLow, oldNone }()
+	*low = "*.md, docs/"
+	*none = "docs/generated/*.html,*.swp"
+
+	ws := filepath.FromSlash("/ws")
+	c := New(ws)
+	for _, tc := range []struct {
+		path  string
+		class Class
+	}{
+		{"/ws/main.go", High},
+		{"/ws/README.md", Low},
+		{"/ws/a/b/README.md", Low},
+		{"/ws/docs/index.html", Low},
+		{"/ws/docs/generated/index.html", None},
+		{"/ws/docs/generated/nested/index.html", Low},
+		{"/ws/a/.main.go.swp", None},
+		{"/ws/docsite/index.html", High},
+		{"a/README.md", Low},
+	} {
+		if got := c.Classify(filepath.FromSlash(tc.path)); got != tc.class {
+			t.Errorf("Classify(%q) = %s, want %s", tc.path, got, tc.class)
+		}
+	}
+}
+
+func TestClassifyWithoutPatterns(t *testing.T) {
+	var nilClassifier *Classifier
+	if got := nilClassifier.Classify("/ws/README.md"); got != High {
+		t.Errorf("Classify on a nil Classifier = %s, want high", got)
+	}
+	if got := New("/ws").Classify("/ws/README.md"); got != High {
+		t.Errorf("Classify without patterns = %s, want high", got)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/change"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/priority"
+	"github.com/fsnotify/fsnotify"
+)
+
+// prioritize is called for every change to a watched source file while
+// waiting. It returns whether the change should be rebuilt right away. Low
+// priority changes are held back until the low priority timer fires, and
+// changes without priority are dropped. A high priority change takes the
+// held back ones with it.
+func (i *IBazel) prioritize(targets []string, e fsnotify.Event) bool {
+	switch i.priorities.Classify(e.Name) {
+	case priority.None:
+		log.Logf("Changed: %q, but it has no priority. Ignoring it.", i.displayPath(e.Name))
+		return false
+	case priority.Low:
+		if len(i.lowPriorityChanges) == 0 {
+			log.Logf("Changed: %q, which has a low priority. Rebuilding in %s...", i.displayPath(e.Name), priority.LowInterval())
+			i.lowPriorityTimer = time.After(priority.LowInterval())
+		}
+		i.lowPriorityChanges = append(i.lowPriorityChanges, e)
+		return false
+	}
+	i.flushLowPriority(targets)
+	return true
+}
+
+// flushLowPriority reports the held back low priority changes.
+func (i *IBazel) flushLowPriority(targets []string) {
+	for _, e := range i.lowPriorityChanges {
+		i.changeDetected(targets, change.Source, e)
+	}
+	i.lowPriorityChanges = nil
+	i.lowPriorityTimer = nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/priority"
+	"github.com/fsnotify/fsnotify"
+)
+
+// setFlag sets a flag of another package and returns a function restoring it.
+func setFlag(t *testing.T, name, value string) func() {
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() { flag.Set(name, old) }
+}
+
+func TestIBazelPriorityLanes(t *testing.T) {
+	defer setFlag(t, "priority_low", "*.md")()
+	defer setFlag(t, "priority_none", "*.swp")()
+	defer setFlag(t, "priority_low_interval", "1ms")()
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.priorities = priority.New("/path")
+	i.sourceEventHandler.SourceFileEvents = make(chan fsnotify.Event, 1)
+	i.filesWatched[i.sourceFileWatcher] = map[string]struct{}{
+		"/path/to/foo.go":    struct{}{},
+		"/path/to/README.md": struct{}{},
+		"/path/to/.foo.swp":  struct{}{},
+	}
+	step := func(e fsnotify.Event) {
+		i.state = WAIT
+		i.sourceEventHandler.SourceFileEvents <- e
+		i.iteration("build", i.build, []string{"//path/to:target"}, "//path/to:target")
+	}
+
+	step(fsnotify.Event{Op: fsnotify.Write, Name: "/path/to/.foo.swp"})
+	assertEqual(t, WAIT, i.state, "State after a change without priority")
+	if len(i.changes) != 0 || len(i.lowPriorityChanges) != 0 {
+		t.Errorf("A change without priority was recorded")
+	}
+
+	step(fsnotify.Event{Op: fsnotify.Write, Name: "/path/to/README.md"})
+	assertEqual(t, WAIT, i.state, "State after a low priority change")
+	assertEqual(t, 1, len(i.lowPriorityChanges), "Held back changes")
+
+	// The timer takes the held back change to a rebuild.
+	i.iteration("build", i.build, []string{"//path/to:target"}, "//path/to:target")
+	assertEqual(t, DEBOUNCE_RUN, i.state, "State after the low priority timer")
+	assertEqual(t, map[string]struct{}{"/path/to/README.md": struct{}{}}, i.changes, "Changes")
+	if i.lowPriorityTimer != nil {
+		t.Errorf("The low priority timer is still running")
+	}
+
+	// A high priority change takes the held back ones with it.
+	i.changes = nil
+	step(fsnotify.Event{Op: fsnotify.Write, Name: "/path/to/README.md"})
+	step(fsnotify.Event{Op: fsnotify.Write, Name: "/path/to/foo.go"})
+	assertEqual(t, DEBOUNCE_RUN, i.state, "State after a high priority change")
+	assertEqual(t, map[string]struct{}{
+		"/path/to/README.md": struct{}{},
+		"/path/to/foo.go":    struct{}{},
+	}, i.changes, "Changes")
+	assertEqual(t, 0, len(i.lowPriorityChanges), "Held back changes")
+}
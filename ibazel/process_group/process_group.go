@@ -34,6 +34,9 @@ import (
 type ProcessGroup interface {
 	RootProcess() *exec.Cmd
 	Start() error
+	// Terminate asks the processes to exit, with SIGTERM on Unix. It fails
+	// where that isn't supported, e.g. on Windows.
+	Terminate() error
 	Kill() error
 	Wait() error
 	Close() error
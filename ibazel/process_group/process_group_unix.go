@@ -41,6 +41,13 @@ func (pg *unixProcessGroup) Start() error {
 	return pg.root.Start()
 }
 
+func (pg *unixProcessGroup) Terminate() error {
+	// A group stopped with SIGSTOP, e.g. by --idle_suspend, only handles the
+	// SIGTERM once it continues.
+	syscall.Kill(-pg.root.Process.Pid, syscall.SIGCONT)
+	return syscall.Kill(-pg.root.Process.Pid, syscall.SIGTERM)
+}
+
 func (pg *unixProcessGroup) Kill() error {
 	return syscall.Kill(-pg.root.Process.Pid, syscall.SIGKILL)
 }
@@ -91,6 +91,12 @@ func (pg *winProcessGroup) Start() error {
 	return nil
 }
 
+// Terminate isn't supported, since the processes of a job can't be asked to
+// exit, only be terminated.
+func (pg *winProcessGroup) Terminate() error {
+	return errors.New("terminating a process group gracefully isn't supported on Windows")
+}
+
 func (pg *winProcessGroup) Kill() error {
 	log.Println("Kill()")
 	if pg.job == 0 {
@@ -50,6 +50,7 @@ type Profiler struct {
 	iterationStartTime       int64
 	iterationBuildStart      bool
 	iterationReloadTriggered bool
+	trigger                  string
 	changes                  []string
 	lock                     sync.Mutex // guards events
 }
@@ -58,6 +59,7 @@ type profileEvent struct {
 	// common
 	Type      string   `json:"type"`
 	Iteration string   `json:"iteration"`
+	Trigger   string   `json:"trigger,omitempty"`
 	Time      int64    `json:"time"`
 	Targets   []string `json:"targets,omitempty"`
 	Elapsed   int64    `json:"elapsed,omitempty"`
@@ -74,6 +76,13 @@ type profileEvent struct {
 	// build & reload event
 	Changes []string `json:"changes,omitempty"`
 
+	// query event
+	Duration int64 `json:"duration,omitempty"`
+	Files    int   `json:"files,omitempty"`
+
+	// shutdown event
+	Reason string `json:"reason,omitempty"`
+
 	// browser event
 	RemoteType    string `json:"remoteType,omitempty"`
 	RemoteTime    int64  `json:"remoteTime,omitempty"`
@@ -144,6 +153,28 @@ func (i *Profiler) BeforeCommand(targets []string, command string) {
 	}
 }
 
+// BeforeQuery implements the QueryListener interface of iBazel.
+func (i *Profiler) BeforeQuery(targets []string) {
+	if i.file == nil {
+		return
+	}
+	i.targets = targets
+	i.queryEvent("QUERY_START", 0, 0)
+}
+
+// AfterQuery implements the QueryListener interface of iBazel.
+func (i *Profiler) AfterQuery(targets []string, duration time.Duration, fileCount int, err error) {
+	if i.file == nil {
+		return
+	}
+	i.targets = targets
+	if err != nil {
+		i.queryEvent("QUERY_FAILED", duration, fileCount)
+	} else {
+		i.queryEvent("QUERY_DONE", duration, fileCount)
+	}
+}
+
 func (i *Profiler) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
 	if i.file == nil {
 		return
@@ -177,6 +208,21 @@ func (i *Profiler) Cleanup() {
 	i.closeServer()
 }
 
+func (i *Profiler) Shutdown(reason string) {
+	i.shutdownEvent(reason)
+
+	// The main loop may still report events, see Lifecycle.Shutdown.
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.file == nil {
+		return
+	}
+	i.file.Sync()
+	i.file.Close()
+	i.file = nil
+	i.closeServer()
+}
+
 func (i *Profiler) ReloadTriggered(targets []string) {
 	if i.file == nil {
 		return
@@ -261,6 +307,17 @@ func (i *Profiler) buildEvent(eventType string) {
 	i.lock.Unlock()
 }
 
+func (i *Profiler) queryEvent(eventType string, duration time.Duration, fileCount int) {
+	i.lock.Lock()
+	event := profileEvent{}
+	event.Type = eventType
+	event.Changes = i.changes
+	event.Duration = int64(duration / time.Millisecond)
+	event.Files = fileCount
+	i.processEvent(&event)
+	i.lock.Unlock()
+}
+
 func (i *Profiler) changeEvent(eventType string, change string) {
 	i.lock.Lock()
 	i.newIteration()
@@ -272,6 +329,13 @@ func (i *Profiler) changeEvent(eventType string, change string) {
 	i.lock.Unlock()
 }
 
+// IterationStarted implements the IterationListener interface of iBazel.
+func (i *Profiler) IterationStarted(id string, trigger string) {
+	i.lock.Lock()
+	i.trigger = trigger
+	i.lock.Unlock()
+}
+
 func (i *Profiler) reloadTriggeredEvent() {
 	i.lock.Lock()
 	i.iterationReloadTriggered = true
@@ -282,6 +346,15 @@ func (i *Profiler) reloadTriggeredEvent() {
 	i.lock.Unlock()
 }
 
+func (i *Profiler) shutdownEvent(reason string) {
+	i.lock.Lock()
+	event := profileEvent{}
+	event.Type = "IBAZEL_SHUTDOWN"
+	event.Reason = reason
+	i.processEvent(&event)
+	i.lock.Unlock()
+}
+
 func (i *Profiler) remoteEvent(remoteEvent *profilerRemoteEvent) {
 	i.lock.Lock()
 	if !i.iterationReloadTriggered {
@@ -302,6 +375,7 @@ func (i *Profiler) processEvent(event *profileEvent) {
 	if i.file != nil && event != nil {
 		// prepare the event
 		event.Iteration = i.iteration
+		event.Trigger = i.trigger
 		event.Time = makeTimestamp()
 		event.Targets = i.targets
 		event.Elapsed = event.Time - i.iterationStartTime
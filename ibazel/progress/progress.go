@@ -0,0 +1,178 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress replaces the progress messages Bazel prints while it works
+// with a single line that is redrawn in place: a spinner, the number of
+// actions done out of the ones known and what Bazel is doing. Every other
+// line, like warnings and errors, is printed as it comes.
+package progress
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var enabled = flag.Bool("progress", false, "Show Bazel's progress as a single line with the number of actions done, redrawn in place, instead of Bazel's own progress messages")
+
+// Enabled reports whether Bazel's stderr should go through a Writer.
+func Enabled() bool {
+	return *enabled
+}
+
+// BazelArgs returns the flags that make Bazel print its progress as lines
+// the Writer understands, rather than redrawing the screen itself.
+func BazelArgs() []string {
+	if !*enabled {
+		return nil
+	}
+	return []string{"--curses=no"}
+}
+
+// The width of the progress line, so that it never wraps on a terminal of
+// the default size.
+const width = 79
+
+var spinner = []string{"|", "/", "-", "\\"}
+
+// Matches the progress messages of the execution phase, e.g.
+// "[1,234 / 5,678] Compiling foo.cc; 3s linux-sandbox".
+var actionsRegex = regexp.MustCompile(`^\[([\d,]+) / ([\d,]+)\] (.*)$`)
+
+// Matches the progress messages of the other phases, e.g.
+// "Analyzing: 3 targets (12 packages loaded, 34 targets configured)".
+var phaseRegex = regexp.MustCompile(`^(Loading|Analyzing|Fetching|Computing main repo mapping|Computing main repository mapping):`)
+
+// Writer is written Bazel's stderr and writes it on to w.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	partial []byte
+	// The number of progress lines drawn, which picks the spinner frame, and
+	// whether one is on the screen.
+	frame int
+	drawn bool
+}
+
+// New returns a Writer writing to w, which should be a terminal.
+func New(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (p *Writer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, b...)
+	for {
+		end := bytes.IndexByte(p.partial, '\n')
+		if end < 0 {
+			break
+		}
+		p.line(string(p.partial[:end]))
+		p.partial = p.partial[end+1:]
+	}
+	return len(b), nil
+}
+
+// line draws a progress message or prints any other line above it.
+func (p *Writer) line(line string) {
+	plain := strings.TrimSpace(log.StripColor(line))
+	if status, ok := Status(plain); ok {
+		p.draw(status)
+		return
+	}
+	p.clear()
+	fmt.Fprintf(p.w, "%s\n", line)
+}
+
+// Draw draws line if it is a progress message and ignores it otherwise. It
+// shows the progress of a command whose output is held back.
+func (p *Writer) Draw(line string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	plain := strings.TrimSpace(log.StripColor(line))
+	if status, ok := Status(plain); ok {
+		p.draw(status)
+	}
+}
+
+// Clear prints the last line written, even if it doesn't end in a newline,
+// and removes the progress line from the screen, e.g. after a command or
+// before the held back output is printed.
+func (p *Writer) Clear() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partial) > 0 {
+		p.line(string(p.partial))
+		p.partial = nil
+	}
+	p.clear()
+}
+
+func (p *Writer) draw(status string) {
+	p.frame++
+	p.clear()
+	fmt.Fprintf(p.w, "%s %s", spinner[p.frame%len(spinner)], truncate(status, width-2))
+	p.drawn = true
+}
+
+// clear removes the progress line from the screen.
+func (p *Writer) clear() {
+	if p.drawn {
+		fmt.Fprint(p.w, "\r\x1b[K")
+		p.drawn = false
+	}
+}
+
+// Status returns the progress line for a progress message of Bazel, e.g.
+// "1234/5678 actions (21%) Compiling foo.cc; 3s linux-sandbox", and whether
+// line is one.
+func Status(line string) (string, bool) {
+	if m := actionsRegex.FindStringSubmatch(line); m != nil {
+		done, _ := strconv.Atoi(strings.Replace(m[1], ",", "", -1))
+		total, _ := strconv.Atoi(strings.Replace(m[2], ",", "", -1))
+		percent := 0
+		if total > 0 {
+			percent = done * 100 / total
+		}
+		return fmt.Sprintf("%d/%d actions (%d%%) %s", done, total, percent, m[3]), true
+	}
+	if phaseRegex.MatchString(line) {
+		return line, true
+	}
+	return "", false
+}
+
+// truncate shortens s to n characters, so that a multi-byte character is
+// never cut in half.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
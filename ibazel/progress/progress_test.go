@@ -0,0 +1,96 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	for _, c := range []struct {
+		line   string
+		status string
+		ok     bool
+	}{
+		{"[1,234 / 5,678] Compiling foo.cc; 3s linux-sandbox", "1234/5678 actions (21%) Compiling foo.cc; 3s linux-sandbox", true},
+		{"[0 / 0] checking cached actions", "0/0 actions (0%) checking cached actions", true},
+		{"Analyzing: 3 targets (12 packages loaded)", "Analyzing: 3 targets (12 packages loaded)", true},
+		{"ERROR: /ws/BUILD:3:1: Compiling foo.cc failed", "", false},
+		{"INFO: Build completed successfully, 3 total actions", "", false},
+	} {
+		status, ok := Status(c.line)
+		if status != c.status || ok != c.ok {
+			t.Errorf("Status(%q) = %q, %v, wanted %q, %v", c.line, status, ok, c.status, c.ok)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := New(&out)
+	w.Write([]byte("Loading: 0 packages loaded\n[1 / 4] Compiling a.cc\n"))
+	w.Write([]byte("WARNING: deprecated\n[2 / 4] Compiling "))
+	w.Write([]byte("b.cc; " + strings.Repeat("x", 100) + "\nINFO: Build completed successfully\n"))
+
+	want := "/ Loading: 0 packages loaded" +
+		"\r\x1b[K- 1/4 actions (25%) Compiling a.cc" +
+		"\r\x1b[KWARNING: deprecated\n" +
+		"\\ 2/4 actions (50%) Compiling b.cc; " + strings.Repeat("x", 40) + "..." +
+		"\r\x1b[KINFO: Build completed successfully\n"
+	if out.String() != want {
+		t.Errorf("Wanted %q, got %q", want, out.String())
+	}
+}
+
+func TestDraw(t *testing.T) {
+	var out bytes.Buffer
+	w := New(&out)
+	w.Draw("[1 / 2] Compiling a.cc")
+	w.Draw("INFO: Found 1 target...")
+	w.Clear()
+	w.Clear()
+
+	want := "/ 1/2 actions (50%) Compiling a.cc\r\x1b[K"
+	if out.String() != want {
+		t.Errorf("Wanted %q, got %q", want, out.String())
+	}
+	var nilWriter *Writer
+	nilWriter.Draw("[1 / 2] Compiling a.cc")
+	nilWriter.Clear()
+}
+
+func TestClear(t *testing.T) {
+	var out bytes.Buffer
+	w := New(&out)
+	w.Write([]byte("[1 / 2] Compiling a.cc\nINFO: Elapsed time: 1.2s"))
+	w.Clear()
+
+	want := "/ 1/2 actions (50%) Compiling a.cc\r\x1b[KINFO: Elapsed time: 1.2s\n"
+	if out.String() != want {
+		t.Errorf("Wanted %q, got %q", want, out.String())
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := strings.Repeat("é", 10)
+	if got, want := truncate(s, 6), "ééé..."; got != want {
+		t.Errorf("Wanted %q, got %q", want, got)
+	}
+	if got := truncate(s, 10); got != s {
+		t.Errorf("Wanted %q, got %q", s, got)
+	}
+}
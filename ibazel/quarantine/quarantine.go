@@ -0,0 +1,152 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quarantine keeps track of the tests that the user has asked iBazel
+// to stop caring about, e.g. because they are broken for reasons unrelated to
+// what the user is working on.
+package quarantine
+
+import (
+	"bufio"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var (
+	quarantined = flag.String(
+		"quarantine",
+		"",
+		"Comma separated list of test targets to quarantine, in addition to the ones listed in the workspace's "+fileName)
+	mode = flag.String(
+		"quarantine_mode",
+		Warn,
+		"How to treat quarantined tests: \"warn\" runs them but reports their failures as warnings, \"skip\" doesn't run them")
+)
+
+const fileName = ".ibazel_quarantine"
+
+const (
+	Warn = "warn"
+	Skip = "skip"
+)
+
+// Mode returns how quarantined tests should be treated.
+func Mode() string {
+	if *mode == Skip {
+		return Skip
+	}
+	return Warn
+}
+
+type Quarantine struct {
+	path string
+
+	lock    sync.Mutex // guards added, removed and targets
+	added   map[string]struct{}
+	removed map[string]struct{}
+	targets map[string]struct{}
+}
+
+// New creates a quarantine backed by the .ibazel_quarantine file in the root
+// of the workspace. The file contains one target per line, and lines starting
+// with # are ignored.
+func New(workspacePath string) *Quarantine {
+	q := &Quarantine{
+		path:    filepath.Join(workspacePath, fileName),
+		added:   map[string]struct{}{},
+		removed: map[string]struct{}{},
+	}
+	q.Reload()
+	return q
+}
+
+// Reload rereads the quarantine file so that edits made while iBazel is
+// running take effect on the next iteration.
+func (q *Quarantine) Reload() {
+	targets := map[string]struct{}{}
+	for _, target := range strings.Split(*quarantined, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets[target] = struct{}{}
+		}
+	}
+
+	f, err := os.Open(q.path)
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			targets[line] = struct{}{}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		log.Errorf("Error reading %s: %v", q.path, err)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for target := range q.added {
+		targets[target] = struct{}{}
+	}
+	for target := range q.removed {
+		delete(targets, target)
+	}
+	q.targets = targets
+}
+
+// Add quarantines target for the rest of the session.
+func (q *Quarantine) Add(target string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.removed, target)
+	q.added[target] = struct{}{}
+	q.targets[target] = struct{}{}
+}
+
+// Remove releases target from quarantine for the rest of the session.
+func (q *Quarantine) Remove(target string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.added, target)
+	q.removed[target] = struct{}{}
+	delete(q.targets, target)
+}
+
+// Contains reports whether target is quarantined.
+func (q *Quarantine) Contains(target string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	_, ok := q.targets[target]
+	return ok
+}
+
+// Targets returns the sorted list of quarantined targets.
+func (q *Quarantine) Targets() []string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	targets := make([]string, 0, len(q.targets))
+	for target := range q.targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quarantine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func init() {
+	log.FakeExit()
+}
+
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibazel_quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldQuarantined := *quarantined
+	*quarantined = "//flag:test, //other:test"
+	defer func() { *quarantined = oldQuarantined }()
+
+	file := filepath.Join(dir, fileName)
+	if err := ioutil.WriteFile(file, []byte("# Broken on master\n//file:test\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := New(dir)
+	want := []string{"//file:test", "//flag:test", "//other:test"}
+	if got := q.Targets(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Targets()\nGot:  %v\nWant: %v", got, want)
+	}
+
+	// Runtime edits survive reloading the file.
+	q.Add("//added:test")
+	q.Remove("//flag:test")
+	if err := ioutil.WriteFile(file, []byte("//edited:test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q.Reload()
+
+	want = []string{"//added:test", "//edited:test", "//other:test"}
+	if got := q.Targets(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Targets() after reload\nGot:  %v\nWant: %v", got, want)
+	}
+	if q.Contains("//flag:test") {
+		t.Errorf("//flag:test was removed from quarantine")
+	}
+	if !q.Contains("//added:test") {
+		t.Errorf("//added:test was added to quarantine")
+	}
+}
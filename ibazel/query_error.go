@@ -0,0 +1,113 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// Matches "ERROR: /ws/pkg/BUILD:3:5: message", which is how Bazel reports a
+// package it can't load.
+var buildFileErrorRegex = regexp.MustCompile(`^ERROR: (\S+):(\d+):(\d+): (.*)$`)
+
+// A buildFileError is an error at a location in a BUILD or .bzl file.
+type buildFileError struct {
+	path    string
+	line    int
+	column  int
+	message string
+}
+
+func (e buildFileError) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.path, e.line, e.column, e.message)
+}
+
+// buildFileErrors returns the errors in BUILD and .bzl files that made a
+// query fail with err.
+func buildFileErrors(err error) []buildFileError {
+	queryErr, ok := err.(*bazel.QueryError)
+	if !ok {
+		return nil
+	}
+
+	var errs []buildFileError
+	scanner := line_scanner.New(bytes.NewReader(queryErr.Stderr))
+	for scanner.Scan() {
+		line := log.StripColor(scanner.Text())
+		m := buildFileErrorRegex.FindStringSubmatch(line)
+		if m == nil || !isGraphFile(m[1]) {
+			continue
+		}
+		l, _ := strconv.Atoi(m[2])
+		c, _ := strconv.Atoi(m[3])
+		errs = append(errs, buildFileError{path: m[1], line: l, column: c, message: m[4]})
+	}
+	return errs
+}
+
+// queryFailed reports the BUILD file errors that made a query fail and
+// watches the broken files, so that the query is rerun once they are saved
+// again. The files watched before are kept.
+func (i *IBazel) queryFailed(targets []string, err error) {
+	errs := buildFileErrors(err)
+	if len(errs) == 0 {
+		return
+	}
+
+	workspacePath, _ := i.workspaceFinder.FindWorkspace()
+	for n := range errs {
+		errs[n].path = i.userPath(workspacePath, errs[n].path)
+	}
+	lines := []string{"The query for the files to watch failed:"}
+	for _, e := range errs {
+		if rel, err := filepath.Rel(workspacePath, e.path); err == nil && !strings.HasPrefix(rel, "..") {
+			e.path = rel
+		}
+		lines = append(lines, e.String())
+	}
+	lines = append(lines, "Waiting for the BUILD files to be fixed...")
+	log.Banner(lines...)
+
+	output := bytes.NewBuffer(err.(*bazel.QueryError).Stderr)
+	for _, l := range i.lifecycleListeners {
+		if ql, ok := l.(QueryErrorListener); ok {
+			i.callListener(l, "QueryFailed", func() { ql.QueryFailed(targets, output) })
+		}
+	}
+
+	for _, e := range errs {
+		if _, ok := i.filesWatched[i.buildFileWatcher][e.path]; ok {
+			continue
+		}
+		if _, err := os.Stat(e.path); err != nil {
+			continue
+		}
+		if err := i.buildFileWatcher.Add(filepath.Dir(e.path)); err != nil {
+			log.Errorf("Error watching file %q error: %v", e.path, err)
+			continue
+		}
+		i.addWatched(i.buildFileWatcher, e.path)
+	}
+}
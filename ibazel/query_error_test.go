@@ -0,0 +1,115 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+func TestBuildFileErrors(t *testing.T) {
+	err := &bazel.QueryError{
+		Err: errors.New("exit status 7"),
+		Stderr: []byte("Loading: 0 packages loaded\n" +
+			"\x1b[31m\x1b[1mERROR: \x1b[0m/ws/foo/BUILD.bazel:3:5: syntax error at 'load': expected expression\n" +
+			"ERROR: /ws/foo/defs.bzl:10:1: name 'x' is not defined\n" +
+			"ERROR: /ws/foo/a.go:1:1: not a BUILD file\n" +
+			"ERROR: error loading package 'foo': Package 'foo' contains errors\n"),
+	}
+	expected := []buildFileError{
+		{"/ws/foo/BUILD.bazel", 3, 5, "syntax error at 'load': expected expression"},
+		{"/ws/foo/defs.bzl", 10, 1, "name 'x' is not defined"},
+	}
+	if got := buildFileErrors(err); !reflect.DeepEqual(got, expected) {
+		t.Errorf("buildFileErrors:\nGot:  %v\nWant: %v", got, expected)
+	}
+
+	if got := buildFileErrors(errors.New("exit status 7")); got != nil {
+		t.Errorf("buildFileErrors of an error that isn't a *bazel.QueryError: %v", got)
+	}
+}
+
+func TestIBazelQueryError(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_query_error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	build := filepath.Join(workspace, "foo", "BUILD")
+	if err := os.MkdirAll(filepath.Dir(build), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(build, []byte("load(\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryError(fmt.Sprintf(buildQuery, "//foo:bar"), &bazel.QueryError{
+			Err:    errors.New("exit status 7"),
+			Stderr: []byte("ERROR: " + build + ":2:1: syntax error at 'newline'\n"),
+		})
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	i.outputDirs = []string{}
+	i.buildFileWatcher = &fakeFSNotifyWatcher{}
+	i.filesWatched[i.sourceFileWatcher] = map[string]struct{}{"/ws/foo/a.go": struct{}{}}
+	i.state = QUERY
+
+	i.iteration("build", i.build, []string{"//foo:bar"}, "//foo:bar")
+	assertEqual(t, WAIT, i.state, "State after a failed query")
+	assertEqual(t, map[string]struct{}{build: struct{}{}}, i.filesWatched[i.buildFileWatcher], "Watched BUILD files")
+	assertEqual(t, map[string]struct{}{"/ws/foo/a.go": struct{}{}}, i.filesWatched[i.sourceFileWatcher], "Watched source files")
+}
+
+func TestIBazelQueryTimeout(t *testing.T) {
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryError(fmt.Sprintf(buildQuery, "//foo:bar"), &bazel.QueryError{
+			Err: &bazel.TimeoutError{Command: "query", Timeout: time.Minute},
+		})
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.buildFileWatcher = &fakeFSNotifyWatcher{}
+	i.state = QUERY
+
+	i.iteration("build", i.build, []string{"//foo:bar"}, "//foo:bar")
+	assertEqual(t, WAIT, i.state, "State after a query that timed out")
+	select {
+	case sig := <-i.sigs:
+		t.Errorf("iBazel was asked to exit with %v", sig)
+	default:
+	}
+}
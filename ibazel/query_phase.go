@@ -0,0 +1,42 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+)
+
+// beforeQuery tells the QueryListeners that the queries for the files to
+// watch start.
+func (i *IBazel) beforeQuery(targets []string) {
+	i.queryStart = time.Now()
+	for _, l := range i.lifecycleListeners {
+		if ql, ok := l.(QueryListener); ok {
+			i.callListener(l, "BeforeQuery", func() { ql.BeforeQuery(targets) })
+		}
+	}
+}
+
+// afterQuery tells the QueryListeners how the queries that started with
+// beforeQuery went.
+func (i *IBazel) afterQuery(targets []string, err error) {
+	duration := time.Since(i.queryStart)
+	fileCount := len(i.filesWatched[i.buildFileWatcher]) + len(i.filesWatched[i.sourceFileWatcher])
+	for _, l := range i.lifecycleListeners {
+		if ql, ok := l.(QueryListener); ok {
+			i.callListener(l, "AfterQuery", func() { ql.AfterQuery(targets, duration, fileCount, err) })
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+)
+
+// queryRecorder is a listener that also records the queries.
+type queryRecorder struct {
+	phaseRecorder
+}
+
+func (r *queryRecorder) BeforeQuery(targets []string) {
+	*r.phases = append(*r.phases, "before query "+strings.Join(targets, " "))
+}
+
+func (r *queryRecorder) AfterQuery(targets []string, duration time.Duration, fileCount int, err error) {
+	*r.phases = append(*r.phases, fmt.Sprintf("after query %s: %d files, %v", strings.Join(targets, " "), fileCount, err))
+}
+
+func TestIBazelQueryListener(t *testing.T) {
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		b.AddQueryResponse(fmt.Sprintf(sourceQuery, "//path/to:target"), sourceFileResult("//path/to:a.go", "//path/to:b.go"))
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(filepath.FromSlash("/ws"))
+	i.outputDirs = []string{}
+	i.buildFileWatcher = &fakeFSNotifyWatcher{}
+	i.sourceFileWatcher = &fakeFSNotifyWatcher{}
+
+	var phases []string
+	i.lifecycleListeners = []Lifecycle{&queryRecorder{phaseRecorder{&phases}}}
+	i.state = QUERY
+
+	i.iteration("build", i.build, []string{"//path/to:target"}, "//path/to:target")
+	assertEqual(t, RUN, i.state, "State")
+	assertEqual(t, []string{
+		"before query //path/to:target",
+		"after query //path/to:target: 2 files, <nil>",
+	}, phases, "Phases")
+}
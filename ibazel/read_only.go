@@ -0,0 +1,83 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bazel_queue"
+	"github.com/bazelbuild/bazel-watcher/ibazel/file_index"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/mrun_log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+// readOnlyDirs are the directories iBazel writes to that turned out to be
+// read-only, e.g. because they are on a snapshot that is mounted read-only.
+type readOnlyDirs struct {
+	workspace bool
+	temp      bool
+	cache     bool
+}
+
+// dirWritable is a variable so that tests can make directories read-only.
+var dirWritable = temp_dir.Writable
+
+// checkWritable finds the read-only directories and turns off what would write
+// to them, with one warning instead of an error every time it fails.
+func (i *IBazel) checkWritable(workspacePath string) {
+	var dirs, disabled []string
+	if workspacePath != "" && !dirWritable(workspacePath) {
+		i.readOnly.workspace = true
+		dirs = append(dirs, "the workspace, "+workspacePath)
+		if runOutput := flag.Lookup("run_output"); runOutput != nil && runOutput.Value.String() == "true" {
+			runOutput.Value.Set("false")
+			disabled = append(disabled, "running the fixes in Bazel's output (--run_output)")
+		}
+	}
+	if !dirWritable(temp_dir.Dir()) {
+		i.readOnly.temp = true
+		dirs = append(dirs, "the temporary directory, "+temp_dir.Dir())
+		disabled = append(disabled, "the watch snapshots of `ibazel why-not`")
+		if bazel_queue.Enabled() {
+			disabled = append(disabled, "taking turns with other iBazels (--coordinate)")
+		}
+	}
+	if *mrunToFiles && !dirWritable(mrun_log.Dir()) {
+		*mrunToFiles = false
+		dirs = append(dirs, "the mrun log directory, "+mrun_log.Dir())
+		disabled = append(disabled, "the log files of mrun targets (--mrunToFiles)")
+	}
+	if file_index.Persisted() && !dirWritable(temp_dir.CacheDir()) {
+		i.readOnly.cache = true
+		dirs = append(dirs, "the cache directory, "+temp_dir.CacheDir())
+		disabled = append(disabled, "persisting the file index (--persist_file_index)")
+	}
+	if len(dirs) == 0 {
+		return
+	}
+
+	lines := []string{"These directories are read-only:"}
+	for _, dir := range dirs {
+		lines = append(lines, "  "+dir)
+	}
+	if len(disabled) > 0 {
+		lines = append(lines, "iBazel keeps watching, without:")
+		for _, feature := range disabled {
+			lines = append(lines, "  "+feature)
+		}
+	}
+	log.Banner(lines...)
+}
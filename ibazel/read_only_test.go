@@ -0,0 +1,61 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+func TestIBazelCheckWritable(t *testing.T) {
+	workspace := filepath.FromSlash("/snapshot/ws")
+	defer setFlag(t, "run_output", "true")()
+	defer setFlag(t, "mrunToFiles", "true")()
+	oldDirWritable := dirWritable
+	defer func() { dirWritable = oldDirWritable }()
+	dirWritable = func(dir string) bool {
+		return dir != workspace && dir != temp_dir.Dir() && !strings.HasPrefix(dir, temp_dir.Dir()+string(filepath.Separator))
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.checkWritable(workspace)
+
+	assertEqual(t, readOnlyDirs{workspace: true, temp: true}, i.readOnly, "Read-only directories")
+	assertEqual(t, "false", flag.Lookup("run_output").Value.String(), "--run_output")
+	assertEqual(t, false, *mrunToFiles, "--mrunToFiles")
+
+	// The snapshot would be written to the temporary directory.
+	i.snapshot = nil
+	i.saveSnapshot()
+}
+
+func TestIBazelCheckWritable_writable(t *testing.T) {
+	defer setFlag(t, "run_output", "true")()
+	oldDirWritable := dirWritable
+	defer func() { dirWritable = oldDirWritable }()
+	dirWritable = func(dir string) bool { return true }
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.checkWritable(filepath.FromSlash("/ws"))
+
+	assertEqual(t, readOnlyDirs{}, i.readOnly, "Read-only directories")
+	assertEqual(t, "true", flag.Lookup("run_output").Value.String(), "--run_output")
+}
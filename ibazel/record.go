@@ -0,0 +1,58 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/session"
+	"github.com/fsnotify/fsnotify"
+)
+
+var recordFile = flag.String("record", "", "Record the session to this tar file when iBazel exits, for a bug report that can be replayed with `ibazel replay`. Paths in the workspace and secrets are redacted")
+
+// startRecording returns the recorder for --record, nil if it isn't set.
+func startRecording(workspacePath string) *session.Recorder {
+	if *recordFile == "" {
+		return nil
+	}
+	flags := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "record" {
+			flags[f.Name] = f.Value.String()
+		}
+	})
+	return session.NewRecorder(*recordFile, workspacePath, Version, flag.Args(), flags)
+}
+
+// saveRecording writes the recording of the session, if there is one.
+func (i *IBazel) saveRecording() {
+	if path, err := i.recorder.Save(); err != nil {
+		log.Errorf("Error saving the recording of this session: %v", err)
+	} else if path != "" {
+		log.Logf("Recorded this session in %s", path)
+	}
+}
+
+// recordFileEvent records an event of one of the file watchers as the
+// watcher reported it.
+func (i *IBazel) recordFileEvent(watcher fSNotifyWatcher, e fsnotify.Event) {
+	name := "source"
+	if watcher == i.buildFileWatcher {
+		name = "graph"
+	}
+	i.recorder.File(name, e)
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/session"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+	"github.com/fsnotify/fsnotify"
+)
+
+// How long a replay waits for iBazel to get as far as the recording did
+// before it delivers the next file event anyway, the longest it pauses
+// between two events, and how long it waits for iBazel to settle at the end.
+var (
+	replayTimeout = 10 * time.Second
+	replayMaxGap  = time.Second
+	replaySettle  = 200 * time.Millisecond
+)
+
+type dirWorkspaceFinder string
+
+func (d dirWorkspaceFinder) FindWorkspace() (string, error) { return string(d), nil }
+
+// replayWatcher delivers the file events of a recording.
+type replayWatcher struct {
+	events chan fsnotify.Event
+	errors chan error
+}
+
+var _ fSNotifyWatcher = &replayWatcher{}
+
+func newReplayWatcher() *replayWatcher {
+	return &replayWatcher{events: make(chan fsnotify.Event), errors: make(chan error)}
+}
+
+func (w *replayWatcher) Close() error                { return nil }
+func (w *replayWatcher) Add(name string) error       { return nil }
+func (w *replayWatcher) Remove(name string) error    { return nil }
+func (w *replayWatcher) Events() chan fsnotify.Event { return w.events }
+func (w *replayWatcher) Errors() chan error          { return w.errors }
+func (w *replayWatcher) Watcher() *fsnotify.Watcher  { return nil }
+
+// replayCommand stands in for the commands of `ibazel run` and `ibazel mrun`,
+// and for --command, which aren't started during a replay.
+type replayCommand struct {
+	running bool
+}
+
+func newReplayCommand(startupArgs []string, bazelArgs []string, target string, args []string) command.Command {
+	return &replayCommand{}
+}
+
+func (c *replayCommand) Start(logFile *os.File) (*bytes.Buffer, error) {
+	c.running = true
+	return &bytes.Buffer{}, nil
+}
+func (c *replayCommand) Terminate()     { c.running = false }
+func (c *replayCommand) BeforeRebuild() {}
+func (c *replayCommand) AfterRebuild(logFile *os.File) *bytes.Buffer {
+	c.running = true
+	return &bytes.Buffer{}
+}
+func (c *replayCommand) IsSubprocessRunning() bool { return c.running }
+
+// replay implements `ibazel replay session.tar`: it runs the state machine
+// with the recorded flags and command line, answers its calls to Bazel with
+// the recorded results and feeds it the recorded file events. It prints the
+// states and commands the replay went through and fails at the first one that
+// differs from the recording.
+func replay(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errors.New("ibazel replay takes the path of a single recording")
+	}
+	rec, err := session.Open(args[0])
+	if err != nil {
+		return err
+	}
+	if len(rec.Args) < 2 {
+		return fmt.Errorf("%s doesn't have a command to replay", args[0])
+	}
+	if rec.Dropped > 0 {
+		log.Errorf("%s only has the latest entries of the session, %d older ones were dropped. The replay may differ from the recording.", args[0], rec.Dropped)
+	}
+	for name, value := range rec.Flags {
+		if err := flag.Set(name, value); err != nil {
+			log.Errorf("Ignoring the recorded flag --%s=%s: %v", name, value, err)
+		}
+	}
+
+	// The recorded paths are restored into an empty workspace, so that the
+	// replay can't touch the files of a real one.
+	workspace, err := temp_dir.TempDir("ibazel_replay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workspace)
+	player := rec.Player(workspace)
+	bazelNew = player.Bazel
+	mainWorkspaceFinder = dirWorkspaceFinder(workspace)
+	commandDefaultCommand = newReplayCommand
+	commandNotifyCommand = newReplayCommand
+	commandHTTPNotifyCommand = func(startupArgs []string, bazelArgs []string, target string, args []string, url string) command.Command {
+		return &replayCommand{}
+	}
+	commandSdNotifyCommand = newReplayCommand
+	commandShellCommand = func(string, string, []string) command.Command { return &replayCommand{} }
+
+	i, err := New()
+	if err != nil {
+		return err
+	}
+	i.SetDebounceDuration(debounce())
+	graph, source := newReplayWatcher(), newReplayWatcher()
+	i.watchdogMu.Lock()
+	i.buildFileWatcher.Close()
+	i.sourceFileWatcher.Close()
+	i.buildFileWatcher = graph
+	i.sourceFileWatcher = source
+	i.sourceEventHandler = NewSourceEventHandler(source)
+	i.forwarders = []*forwarder{i.sourceEventHandler.alive}
+	i.watchdogMu.Unlock()
+	replayed := session.NewRecorder("", workspace, Version, rec.Args, nil)
+	i.recorder = replayed
+
+	log.Logf("Replaying %s %v, recorded with iBazel %s", args[0], rec.Args, rec.IBazelVersion)
+	go handle(i, rec.Args[0], rec.Args[1:])
+	playEvents(rec, player, replayed, source, graph)
+	return compareTraces(w, session.Trace(rec.Entries), replayed.Trace())
+}
+
+// playEvents delivers the recorded file events. Before each event it waits
+// for the replay to get through as many states and commands as the recording
+// had, so that the event arrives in the same state, and then waits as long as
+// the recording did, up to replayMaxGap, so that debouncing works out the
+// same.
+func playEvents(rec *session.Recording, player *session.Player, replayed *session.Recorder, source, graph *replayWatcher) {
+	steps := 0
+	var last time.Duration
+	for _, e := range rec.Entries {
+		switch e.Kind {
+		case session.StateEntry, session.CommandEntry:
+			steps++
+		case session.FileEntry:
+			waitForSteps(replayed, steps)
+			gap := e.At - last
+			if gap > replayMaxGap {
+				gap = replayMaxGap
+			}
+			time.Sleep(gap)
+
+			watcher := source
+			if e.Watcher == "graph" {
+				watcher = graph
+			}
+			select {
+			case watcher.events <- fsnotify.Event{Name: player.Restore(e.Path), Op: fsnotify.Op(e.Op)}:
+			case <-time.After(replayTimeout):
+				log.Errorf("The replay didn't take the recorded event for %s", e.Path)
+			}
+		}
+		last = e.At
+	}
+	waitForSteps(replayed, steps)
+	time.Sleep(replaySettle)
+}
+
+// waitForSteps waits until the replay went through n states and commands.
+func waitForSteps(replayed *session.Recorder, n int) {
+	deadline := time.Now().Add(replayTimeout)
+	for len(replayed.Trace()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// compareTraces prints the steps the recording and the replay have in common,
+// and returns an error for the first one that differs.
+func compareTraces(w io.Writer, recorded, replayed []string) error {
+	step := func(trace []string, n int) string {
+		if n < len(trace) {
+			return fmt.Sprintf("%q", trace[n])
+		}
+		return "nothing more"
+	}
+	for n := 0; n < len(recorded) || n < len(replayed); n++ {
+		want, got := step(recorded, n), step(replayed, n)
+		if want != got {
+			return fmt.Errorf("the replay differs from the recording at step %d: the recording has %s, the replay %s", n+1, want, got)
+		}
+		fmt.Fprintf(w, "%3d %s\n", n+1, recorded[n])
+	}
+	fmt.Fprintf(w, "The replay matches the recording.\n")
+	return nil
+}
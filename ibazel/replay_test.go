@@ -0,0 +1,114 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/ibazel/session"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	oldBazelNew := bazelNew
+	oldWorkspaceFinder := mainWorkspaceFinder
+	oldDefaultCommand, oldNotifyCommand := commandDefaultCommand, commandNotifyCommand
+	oldSdNotifyCommand, oldShellCommand := commandSdNotifyCommand, commandShellCommand
+	oldHTTPNotifyCommand := commandHTTPNotifyCommand
+	oldTimeout := replayTimeout
+	defer func() {
+		bazelNew = oldBazelNew
+		mainWorkspaceFinder = oldWorkspaceFinder
+		commandDefaultCommand, commandNotifyCommand = oldDefaultCommand, oldNotifyCommand
+		commandSdNotifyCommand, commandShellCommand = oldSdNotifyCommand, oldShellCommand
+		commandHTTPNotifyCommand = oldHTTPNotifyCommand
+		replayTimeout = oldTimeout
+	}()
+	replayTimeout = 2 * time.Second
+
+	dir, err := ioutil.TempDir("", "ibazel_record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.tar")
+	workspace := filepath.Join(dir, "ws")
+
+	mock := &mock_bazel.MockBazel{}
+	mock.AddQueryResponse(fmt.Sprintf(buildQuery, "//a"), sourceFileResult("//a:BUILD"))
+	mock.AddQueryResponse(fmt.Sprintf(sourceQuery, "//a"), sourceFileResult("//a:a.go"))
+	bazelNew = func() bazel.Bazel { return mock }
+	mainWorkspaceFinder = dirWorkspaceFinder(workspace)
+
+	// Record `ibazel build //a` building, and building again after a.go
+	// changed.
+	i := newIBazel(t)
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	i.outputDirs = []string{}
+	i.recorder = session.NewRecorder(path, workspace, Version, []string{"build", "//a"}, map[string]string{})
+	i.buildFileWatcher = &fakeFSNotifyWatcher{EventChan: make(chan fsnotify.Event)}
+	i.sourceFileWatcher = &fakeFSNotifyWatcher{EventChan: make(chan fsnotify.Event)}
+	i.sourceEventHandler.SourceFileEvents = make(chan fsnotify.Event, 1)
+	defer i.Cleanup()
+
+	i.state = QUERY
+	step := func() {
+		i.recorder.State(string(i.state))
+		i.iteration("build", i.build, []string{"//a"}, "//a")
+	}
+	step()
+	step()
+	assertEqual(t, WAIT, i.state, "State")
+	i.sourceEventHandler.SourceFileEvents <- fsnotify.Event{Op: fsnotify.Write, Name: filepath.Join(workspace, "a", "a.go")}
+	step()
+	assertEqual(t, DEBOUNCE_RUN, i.state, "State")
+	step()
+	step()
+	assertEqual(t, WAIT, i.state, "State")
+	i.recorder.State(string(i.state))
+	i.saveRecording()
+
+	var out bytes.Buffer
+	if err := replay(&out, []string{path}); err != nil {
+		t.Fatalf("replay(): %v\n%s", err, out.String())
+	}
+	want := []string{"QUERY", "RUN", "build //a succeeded", "WAIT", "DEBOUNCE_RUN", "RUN", "build //a succeeded", "WAIT"}
+	for n, step := range want {
+		if line := fmt.Sprintf("%3d %s", n+1, step); !strings.Contains(out.String(), line) {
+			t.Errorf("The replay should have printed %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestCompareTraces(t *testing.T) {
+	var out bytes.Buffer
+	err := compareTraces(&out, []string{"QUERY", "RUN", "WAIT"}, []string{"QUERY", "WAIT"})
+	if err == nil || !strings.Contains(err.Error(), `step 2: the recording has "RUN", the replay "WAIT"`) {
+		t.Errorf("compareTraces() = %v", err)
+	}
+	err = compareTraces(&out, []string{"QUERY"}, []string{"QUERY", "RUN"})
+	if err == nil || !strings.Contains(err.Error(), `the recording has nothing more, the replay "RUN"`) {
+		t.Errorf("compareTraces() = %v", err)
+	}
+}
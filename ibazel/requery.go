@@ -0,0 +1,98 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var requeryInterval = flag.Duration("requery_interval", 0, "While waiting for changes, query for the files to watch again this often, e.g. 1h, to catch changes iBazel missed during long sessions. Running targets aren't restarted. 0 disables")
+
+// scheduleRequery starts waiting for the next --requery_interval requery.
+func (i *IBazel) scheduleRequery() {
+	if *requeryInterval <= 0 {
+		i.requeryTimer = nil
+		return
+	}
+	i.requeryTimer = time.After(*requeryInterval)
+}
+
+// requery queries for the files to watch again and watches what the queries
+// return now, e.g. files that were added while the watcher overflowed. Nothing
+// is built or restarted. A failing query keeps the files watched before. Only
+// a requery that found differences starts an iteration.
+func (i *IBazel) requery(command string, targets []string, multiple bool) {
+	defer i.scheduleRequery()
+
+	before := map[fSNotifyWatcher]map[string]struct{}{
+		i.buildFileWatcher:  i.filesWatched[i.buildFileWatcher],
+		i.sourceFileWatcher: i.filesWatched[i.sourceFileWatcher],
+	}
+
+	log.Debugf("Querying for files to watch again, see --requery_interval")
+	i.startSnapshot(command, targets)
+	var err error
+	if multiple {
+		err = i.watchManyFiles(buildQuery, targets, i.buildFileWatcher, &i.bldDirToWatch)
+		if err == nil {
+			err = i.watchManyFiles(sourceQuery, targets, i.sourceFileWatcher, &i.srcDirToWatch)
+		}
+	} else {
+		joinedTargets := strings.Join(targets, " ")
+		err = i.watchFiles(fmt.Sprintf(buildQuery, joinedTargets), targets, i.buildFileWatcher)
+		if err == nil {
+			err = i.watchFiles(fmt.Sprintf(sourceQuery, joinedTargets), targets, i.sourceFileWatcher)
+		}
+	}
+	if err != nil {
+		return
+	}
+	i.saveSnapshot()
+	i.saveFileIndex()
+
+	added, removed := 0, 0
+	for watcher, files := range before {
+		a, r := diffFiles(files, i.filesWatched[watcher])
+		added += a
+		removed += r
+	}
+	if added == 0 && removed == 0 {
+		log.Debugf("The files to watch didn't change")
+		return
+	}
+	i.startIteration(triggerRevalidation)
+	log.Logf("The files to watch changed since the last query: %d new, %d no longer watched", added, removed)
+}
+
+// diffFiles returns how many files after has that before hasn't, and the other
+// way around.
+func diffFiles(before, after map[string]struct{}) (added, removed int) {
+	for file := range after {
+		if _, ok := before[file]; !ok {
+			added++
+		}
+	}
+	for file := range before {
+		if _, ok := after[file]; !ok {
+			removed++
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestIBazelRequery(t *testing.T) {
+	defer setFlag(t, "requery_interval", "1ms")()
+	workspace := filepath.FromSlash("/ws")
+
+	queries := 0
+	oldBazelNew := bazelNew
+	defer func() { bazelNew = oldBazelNew }()
+	bazelNew = func() bazel.Bazel {
+		b := &mock_bazel.MockBazel{}
+		query := fmt.Sprintf(sourceQuery, "//path/to:target")
+		if queries == 0 {
+			b.AddQueryResponse(query, sourceFileResult("//path/to:a.go"))
+		} else {
+			// A file that was added while iBazel missed the events.
+			b.AddQueryResponse(query, sourceFileResult("//path/to:a.go", "//path/to:b.go"))
+		}
+		b.AddQueryResponse(fmt.Sprintf(buildQuery, "//path/to:target"), sourceFileResult("//path/to:BUILD"))
+		return b
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+	i.outputDirs = []string{}
+	i.buildFileWatcher = &fakeFSNotifyWatcher{}
+	i.sourceFileWatcher = &fakeFSNotifyWatcher{}
+	i.sourceEventHandler.SourceFileEvents = make(chan fsnotify.Event)
+
+	targets := []string{"//path/to:target"}
+	i.state = QUERY
+	i.iteration("build", i.build, targets, "//path/to:target")
+	assertEqual(t, RUN, i.state, "State after the query")
+	assertEqual(t, 1, len(i.filesWatched[i.sourceFileWatcher]), "Source files watched after the query")
+	if i.requeryTimer == nil {
+		t.Fatal("No requery was scheduled")
+	}
+
+	queries++
+	i.state = WAIT
+	i.iteration("build", i.build, targets, "//path/to:target")
+	assertEqual(t, WAIT, i.state, "State after the requery")
+	assertEqual(t, 2, len(i.filesWatched[i.sourceFileWatcher]), "Source files watched after the requery")
+	if _, ok := i.filesWatched[i.sourceFileWatcher][filepath.Join(workspace, "path", "to", "b.go")]; !ok {
+		t.Errorf("The new file isn't watched: %v", i.filesWatched[i.sourceFileWatcher])
+	}
+	if i.requeryTimer == nil {
+		t.Error("No requery was scheduled after the requery")
+	}
+	assertEqual(t, triggerRevalidation, i.IterationTrigger(), "Trigger of the iteration of a requery that found a new file")
+
+	// A requery that finds nothing new doesn't start an iteration.
+	id := i.IterationID()
+	i.iteration("build", i.build, targets, "//path/to:target")
+	assertEqual(t, id, i.IterationID(), "Iteration after a requery that found nothing new")
+}
+
+func TestDiffFiles(t *testing.T) {
+	before := map[string]struct{}{"a": {}, "b": {}, "c": {}}
+	after := map[string]struct{}{"b": {}, "c": {}, "d": {}, "e": {}}
+	added, removed := diffFiles(before, after)
+	assertEqual(t, 2, added, "Added")
+	assertEqual(t, 1, removed, "Removed")
+}
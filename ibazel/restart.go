@@ -0,0 +1,114 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"sort"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// RestartTarget restarts a target of `ibazel run` or `ibazel mrun` without
+// any file having changed, e.g. because it wedged or external state it
+// depends on was reset. An empty target restarts all of them. It is safe to
+// call from any goroutine and never blocks; the restart happens once the
+// current iteration is done, and requests made meanwhile are merged.
+func (i *IBazel) RestartTarget(target string) {
+	i.restartLock.Lock()
+	if i.pendingRestarts == nil {
+		i.pendingRestarts = map[string]struct{}{}
+	}
+	i.pendingRestarts[target] = struct{}{}
+	i.restartLock.Unlock()
+
+	select {
+	case i.restarts <- struct{}{}:
+	default:
+		// The main loop is already woken up.
+	}
+}
+
+// restartPending is called from the main loop to handle the RestartTarget
+// requests made since the last time.
+func (i *IBazel) restartPending() {
+	i.restartLock.Lock()
+	pending := i.pendingRestarts
+	i.pendingRestarts = nil
+	i.restartLock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	i.startIteration(triggerManual)
+	if _, ok := pending[""]; ok {
+		i.restart("")
+		return
+	}
+	targets := make([]string, 0, len(pending))
+	for target := range pending {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		i.restart(target)
+	}
+}
+
+// restart restarts target, or all of them if it is empty.
+func (i *IBazel) restart(target string) {
+	if i.cmd != nil {
+		// `ibazel run` only has one target.
+		i.restartCommand(target, i.cmd, nil)
+		return
+	}
+	if len(i.cmds) == 0 {
+		log.Errorf("Nothing is running yet")
+		return
+	}
+
+	if target != "" {
+		cmd, ok := i.cmds[target]
+		if !ok {
+			log.Errorf("Can't restart %s, it isn't one of the running targets", target)
+			return
+		}
+		i.restartCommand(target, cmd, i.logFiles[target])
+		return
+	}
+
+	targets := make([]string, 0, len(i.cmds))
+	for target := range i.cmds {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		i.restartCommand(target, i.cmds[target], i.logFiles[target])
+	}
+}
+
+func (i *IBazel) restartCommand(target string, cmd command.Command, logFile *os.File) {
+	if cmd.IsSubprocessRunning() {
+		cmd.Terminate()
+	}
+	if i.memoryLimitReached(target) {
+		return
+	}
+	log.Logf("Restarting %s", target)
+	if _, err := cmd.Start(logFile); err != nil {
+		log.Errorf("Error restarting %s: %v", target, err)
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rss measures how much memory processes keep resident.
+package rss
+
+import "errors"
+
+// ErrUnsupported is returned where the resident memory of processes can't be
+// measured.
+var ErrUnsupported = errors.New("measuring the memory of processes isn't supported on this platform")
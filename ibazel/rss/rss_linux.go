@@ -0,0 +1,77 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package rss
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var procDir = "/proc"
+
+// Group returns the combined resident memory, in bytes, of the processes in
+// the process group pgid.
+func Group(pgid int) (uint64, error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		// Processes can exit between listing and reading them.
+		stat, err := ioutil.ReadFile(filepath.Join(procDir, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		group, pages, ok := parseStat(string(stat))
+		if !ok || group != pgid {
+			continue
+		}
+		total += pages * uint64(os.Getpagesize())
+	}
+	return total, nil
+}
+
+// parseStat returns the process group and the resident pages from the
+// contents of /proc/<pid>/stat. The command name in parentheses can contain
+// spaces, so the fields are counted from the last parenthesis.
+func parseStat(stat string) (int, uint64, bool) {
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return 0, 0, false
+	}
+	// The fields after the name start with the state, the 3rd field.
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return 0, 0, false
+	}
+	group, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, false
+	}
+	pages, err := strconv.ParseUint(fields[21], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return group, pages, true
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package rss
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeStat(t *testing.T, dir, pid, stat string) {
+	if err := os.MkdirAll(filepath.Join(dir, pid), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, pid, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	oldProcDir := procDir
+	defer func() { procDir = oldProcDir }()
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	procDir = dir
+
+	writeStat(t, procDir, "100", "100 (server) S 1 100 100 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 1 1000 10 18446744073709551615")
+	writeStat(t, procDir, "101", "101 (worker (1)) S 100 100 100 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 1 1000 5 18446744073709551615")
+	writeStat(t, procDir, "200", "200 (other) S 1 200 200 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 1 1000 1000 18446744073709551615")
+	writeStat(t, procDir, "self", "not a process")
+
+	got, err := Group(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(15 * os.Getpagesize()); got != want {
+		t.Errorf("Group(100) = %d, want %d", got, want)
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	for _, stat := range []string{
+		"",
+		"1 (short) S 1 2",
+		"1 (bad) S 1 x 1 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 1 1000 10",
+	} {
+		if _, _, ok := parseStat(stat); ok {
+			t.Errorf("parseStat(%q) succeeded", stat)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var runner = flag.String("runner", "local", "Where `ibazel run` and `ibazel mrun` launch the targets that have no ibazel_runner tag: \"local\", \"docker:<container>\" to `docker exec` into a running container, \"ssh:<host>\" or \"user:<user>[:<group>]\" to run them as another user with sudo")
+var runUnder = flag.String("run_under", "", "A command line that `ibazel run` and `ibazel mrun` put in front of their targets, like Bazel's --run_under, e.g. \"strace -f\" or \"perf record -g\". It is split on spaces and kept across restarts")
+
+// The tag that picks where a target is launched, e.g.
+// ibazel_runner=docker:dev, see command.ParseRunner.
+const runnerTagPrefix = "ibazel_runner="
+
+// runnerSpec returns where the target with the given tags is launched.
+func runnerSpec(tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, runnerTagPrefix) {
+			return strings.TrimPrefix(tag, runnerTagPrefix)
+		}
+	}
+	return *runner
+}
+
+// setRunner makes cmd launch the target where its ibazel_runner tag or
+// --runner says, with the variables of targetEnv, behind --run_under if it is
+// set and with the sockets of its ibazel_listen tags, which are passed right
+// to the target, inside of --run_under. A target whose runner can't be parsed
+// is run locally.
+func (i *IBazel) setRunner(cmd command.Command, target string, tags []string) {
+	var r command.Runner
+	if spec := runnerSpec(tags); spec != "local" {
+		var err error
+		if r, err = command.ParseRunner(spec); err != nil {
+			log.Errorf("Running %s locally: %v", target, err)
+		} else {
+			log.Logf("Running %s with %s", target, spec)
+		}
+	}
+	if env := i.targetEnv(); len(env) > 0 {
+		r = command.Env(r, env)
+	}
+	if prefix := strings.Fields(*runUnder); len(prefix) > 0 {
+		log.Logf("Running %s under %s", target, *runUnder)
+		r = command.RunUnder(r, prefix)
+	}
+	if specs := listenTags(tags); len(specs) > 0 {
+		r = i.socketActivation(r, target, specs)
+	}
+	if r != nil {
+		command.SetRunner(cmd, r)
+	}
+}
+
+// targetEnv returns the variables that iBazel adds to the environment of the
+// run targets, instead of setting them in its own environment where every
+// other command would see them too.
+func (i *IBazel) targetEnv() []string {
+	var env []string
+	if i.hotswap != nil {
+		env = append(env, "IBAZEL_HOTSWAP_DIR="+i.hotswap.Dir())
+	}
+	for _, l := range i.lifecycleListeners {
+		if el, ok := l.(EnvListener); ok {
+			env = append(env, el.TargetEnv()...)
+		}
+	}
+	return env
+}
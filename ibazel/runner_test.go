@@ -0,0 +1,53 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRunnerSpec(t *testing.T) {
+	assertEqual(t, "local", runnerSpec(nil), "Without a tag")
+	assertEqual(t, "docker:dev", runnerSpec([]string{"manual", "ibazel_runner=docker:dev"}), "With a tag")
+
+	defer setFlag(t, "runner", "ssh:host")()
+	assertEqual(t, "ssh:host", runnerSpec(nil), "With --runner")
+	assertEqual(t, "local", runnerSpec([]string{"ibazel_runner=local"}), "A tag overrides --runner")
+}
+
+func TestIBazelSetupHotswap(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	assertEqual(t, []string(nil), i.targetEnv(), "Environment without hot reloading")
+
+	i.setupHotswap("//path/to:target")
+	if i.hotswap == nil {
+		t.Fatal("Hot swapping wasn't set up")
+	}
+	first := i.hotswap.Dir()
+	assertEqual(t, []string{"IBAZEL_HOTSWAP_DIR=" + first}, i.targetEnv(), "Environment of the target")
+	assertEqual(t, "", os.Getenv("IBAZEL_HOTSWAP_DIR"), "Environment of iBazel")
+
+	// Setting the target up again replaces the directory.
+	i.setupHotswap("//path/to:target")
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("The first hot swap directory %s wasn't removed: %v", first, err)
+	}
+	if i.hotswap.Dir() == first {
+		t.Errorf("The hot swap directory wasn't replaced")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sd_notify implements the receiving side of the systemd notify
+// protocol (see sd_notify(3)). A subprocess that is started with
+// NOTIFY_SOCKET set can send newline separated KEY=VALUE datagrams to report
+// its state, the most interesting of which is READY=1.
+package sd_notify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+// Socket is a listening notify socket for a single subprocess.
+type Socket struct {
+	target string
+	dir    string
+	conn   *net.UnixConn
+	ready  chan struct{}
+}
+
+// Listen creates a new notify socket in a temporary directory. The returned
+// Socket must be closed by the caller.
+func Listen(target string) (*Socket, error) {
+	dir, err := temp_dir.TempDir("sd_notify")
+	if err != nil {
+		return nil, err
+	}
+
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Socket{
+		target: target,
+		dir:    dir,
+		conn:   conn,
+		ready:  make(chan struct{}, 1),
+	}
+	go s.listen()
+	return s, nil
+}
+
+// Path is the filesystem path of the socket.
+func (s *Socket) Path() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Env returns the environment variable that should be passed to the
+// subprocess so it can find the socket.
+func (s *Socket) Env() string {
+	return "NOTIFY_SOCKET=" + s.Path()
+}
+
+// WaitReady blocks until the subprocess reports READY=1 or the timeout
+// elapses. It returns true if the subprocess became ready.
+func (s *Socket) WaitReady(timeout time.Duration) bool {
+	select {
+	case <-s.ready:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Close stops listening and removes the socket from disk.
+func (s *Socket) Close() error {
+	err := s.conn.Close()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+func (s *Socket) listen() {
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := s.conn.ReadFromUnix(buf)
+		if err != nil {
+			// The socket has been closed.
+			return
+		}
+		s.handle(parse(buf[:n]))
+	}
+}
+
+func (s *Socket) handle(msg map[string]string) {
+	if status, ok := msg["STATUS"]; ok {
+		log.Logf("%s status: %s", s.target, status)
+	}
+	if msg["RELOADING"] == "1" {
+		log.Logf("%s is reloading", s.target)
+	}
+	if msg["STOPPING"] == "1" {
+		log.Logf("%s is stopping", s.target)
+	}
+	if msg["READY"] == "1" {
+		log.Logf("%s is ready", s.target)
+		// Don't block if nobody is waiting, a single pending notification is
+		// enough to wake the next WaitReady.
+		select {
+		case s.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// parse splits a notify datagram into its KEY=VALUE assignments.
+func parse(datagram []byte) map[string]string {
+	msg := map[string]string{}
+	for _, line := range strings.Split(string(datagram), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		msg[kv[0]] = kv[1]
+	}
+	return msg
+}
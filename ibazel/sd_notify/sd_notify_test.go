@@ -0,0 +1,79 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sd_notify
+
+import (
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func init() {
+	log.FakeExit()
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"READY=1", map[string]string{"READY": "1"}},
+		{"READY=1\nSTATUS=Listening on :8080", map[string]string{"READY": "1", "STATUS": "Listening on :8080"}},
+		{"STATUS=a=b\ngarbage\n", map[string]string{"STATUS": "a=b"}},
+	} {
+		got := parse([]byte(c.in))
+		if !reflect.DeepEqual(c.want, got) {
+			t.Errorf("parse(%q)\nGot:  %v\nWant: %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSocket_WaitReady(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on windows")
+	}
+
+	s, err := Listen("//path/to:target")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer s.Close()
+
+	if s.WaitReady(10 * time.Millisecond) {
+		t.Errorf("Socket reported ready before any message was sent")
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s.Path(), Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("DialUnix: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("STATUS=starting")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("READY=1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.WaitReady(5 * time.Second) {
+		t.Errorf("Socket never reported ready")
+	}
+}
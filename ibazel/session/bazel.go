@@ -0,0 +1,288 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/analysis"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+	"github.com/golang/protobuf/proto"
+)
+
+// Bazel records every call to b. command is the Bazel command b was created
+// for, e.g. "build" or "query".
+func (r *Recorder) Bazel(command string, b bazel.Bazel) bazel.Bazel {
+	if r == nil {
+		return b
+	}
+	return &recordingBazel{Bazel: b, r: r}
+}
+
+type recordingBazel struct {
+	bazel.Bazel
+	r *Recorder
+}
+
+func (b *recordingBazel) record(call string, args []string, result string, output *bytes.Buffer, err error) {
+	e := Entry{
+		Kind:   BazelEntry,
+		Call:   call,
+		Args:   b.r.redact.redactAll(args),
+		Result: b.r.redact.redact(result),
+	}
+	if output != nil {
+		e.Output = b.r.redact.redact(output.String())
+	}
+	if err != nil {
+		e.Err = b.r.redact.redact(err.Error())
+		if queryErr, ok := err.(*bazel.QueryError); ok {
+			e.QueryErr = true
+			e.Output = b.r.redact.redact(string(queryErr.Stderr))
+		}
+	}
+	b.r.add(e)
+}
+
+func (b *recordingBazel) Info() (map[string]string, error) {
+	res, err := b.Bazel.Info()
+	var lines []string
+	for key, value := range res {
+		lines = append(lines, key+": "+value)
+	}
+	b.record("info", nil, strings.Join(lines, "\n"), nil, err)
+	return res, err
+}
+
+func (b *recordingBazel) Query(args ...string) (*blaze_query.QueryResult, error) {
+	res, err := b.Bazel.Query(args...)
+	var result string
+	if res != nil {
+		result = proto.MarshalTextString(res)
+	}
+	b.record("query", args, result, nil, err)
+	return res, err
+}
+
+func (b *recordingBazel) CQuery(args ...string) (*analysis.CqueryResult, error) {
+	res, err := b.Bazel.CQuery(args...)
+	var result string
+	if res != nil {
+		result = proto.MarshalTextString(res)
+	}
+	b.record("cquery", args, result, nil, err)
+	return res, err
+}
+
+func (b *recordingBazel) CQueryFiles(args ...string) ([]string, error) {
+	files, err := b.Bazel.CQueryFiles(args...)
+	b.record("cquery-files", args, strings.Join(files, "\n"), nil, err)
+	return files, err
+}
+
+func (b *recordingBazel) Build(args ...string) (*bytes.Buffer, error) {
+	output, err := b.Bazel.Build(args...)
+	b.record("build", args, "", output, err)
+	return output, err
+}
+
+func (b *recordingBazel) Test(args ...string) (*bytes.Buffer, error) {
+	output, err := b.Bazel.Test(args...)
+	b.record("test", args, "", output, err)
+	return output, err
+}
+
+func (b *recordingBazel) MobileInstall(args ...string) (*bytes.Buffer, error) {
+	output, err := b.Bazel.MobileInstall(args...)
+	b.record("mobile-install", args, "", output, err)
+	return output, err
+}
+
+// Player answers the calls to Bazel of a replay with what the recording says
+// Bazel returned. The calls are matched by their kind and, for queries, by
+// the query, in the order they were recorded. A query that was run more often
+// during the replay than during the recording gets the last recorded result
+// again.
+type Player struct {
+	workspace string
+	redact    *redactor
+
+	mu      sync.Mutex
+	pending map[string][]Entry
+	last    map[string]Entry
+}
+
+// Player returns a Player for a replay in workspace.
+func (rec *Recording) Player(workspace string) *Player {
+	p := &Player{
+		workspace: workspace,
+		redact:    newRedactor(workspace),
+		pending:   map[string][]Entry{},
+		last:      map[string]Entry{},
+	}
+	for _, e := range rec.Entries {
+		if e.Kind == BazelEntry {
+			key := callKey(e.Call, e.Args)
+			p.pending[key] = append(p.pending[key], e)
+		}
+	}
+	return p
+}
+
+// Restore returns a recorded path as a path in the workspace of the replay.
+func (p *Player) Restore(path string) string {
+	return restore(path, p.workspace)
+}
+
+// Bazel returns a Bazel that answers with the recorded results. Its signature
+// matches bazel.New.
+func (p *Player) Bazel() bazel.Bazel {
+	return &replayBazel{p: p}
+}
+
+// isQuery reports whether the results of call depend on the query it is
+// given.
+func isQuery(call string) bool {
+	return call == "query" || call == "cquery" || call == "cquery-files"
+}
+
+func callKey(call string, args []string) string {
+	if !isQuery(call) {
+		return call
+	}
+	return call + " " + strings.Join(args, " ")
+}
+
+// next returns the recorded result of the next call.
+func (p *Player) next(call string, args []string) (Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := callKey(call, p.redact.redactAll(args))
+	if pending := p.pending[key]; len(pending) > 0 {
+		p.pending[key] = pending[1:]
+		p.last[key] = pending[0]
+		return pending[0], nil
+	}
+	if e, ok := p.last[key]; ok && isQuery(call) {
+		return e, nil
+	}
+	return Entry{}, fmt.Errorf("the recording has no more results for %s", key)
+}
+
+func (p *Player) err(e Entry) error {
+	if e.Err == "" {
+		return nil
+	}
+	err := errors.New(p.Restore(e.Err))
+	if e.QueryErr {
+		return &bazel.QueryError{Err: err, Stderr: []byte(p.Restore(e.Output))}
+	}
+	return err
+}
+
+func (p *Player) output(e Entry) *bytes.Buffer {
+	return bytes.NewBufferString(p.Restore(e.Output))
+}
+
+type replayBazel struct {
+	p *Player
+}
+
+func (b *replayBazel) SetArguments([]string)   {}
+func (b *replayBazel) SetStartupArgs([]string) {}
+func (b *replayBazel) WriteToStderr(v bool)    {}
+func (b *replayBazel) WriteToStdout(v bool)    {}
+func (b *replayBazel) Wait() error             { return nil }
+func (b *replayBazel) Cancel()                 {}
+
+func (b *replayBazel) Info() (map[string]string, error) {
+	e, err := b.p.next("info", nil)
+	if err != nil {
+		return map[string]string{}, nil
+	}
+	res := map[string]string{}
+	for _, line := range strings.Split(b.p.Restore(e.Result), "\n") {
+		if kv := strings.SplitN(line, ": ", 2); len(kv) == 2 {
+			res[kv[0]] = kv[1]
+		}
+	}
+	return res, b.p.err(e)
+}
+
+func (b *replayBazel) Query(args ...string) (*blaze_query.QueryResult, error) {
+	e, err := b.p.next("query", args)
+	if err != nil {
+		return nil, err
+	}
+	res := &blaze_query.QueryResult{}
+	if err := proto.UnmarshalText(b.p.Restore(e.Result), res); err != nil {
+		return nil, err
+	}
+	return res, b.p.err(e)
+}
+
+func (b *replayBazel) CQuery(args ...string) (*analysis.CqueryResult, error) {
+	e, err := b.p.next("cquery", args)
+	if err != nil {
+		return nil, err
+	}
+	res := &analysis.CqueryResult{}
+	if err := proto.UnmarshalText(b.p.Restore(e.Result), res); err != nil {
+		return nil, err
+	}
+	return res, b.p.err(e)
+}
+
+func (b *replayBazel) CQueryFiles(args ...string) ([]string, error) {
+	e, err := b.p.next("cquery-files", args)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if result := b.p.Restore(e.Result); result != "" {
+		files = strings.Split(result, "\n")
+	}
+	return files, b.p.err(e)
+}
+
+func (b *replayBazel) command(call string, args []string) (*bytes.Buffer, error) {
+	e, err := b.p.next(call, args)
+	if err != nil {
+		return &bytes.Buffer{}, err
+	}
+	return b.p.output(e), b.p.err(e)
+}
+
+func (b *replayBazel) Build(args ...string) (*bytes.Buffer, error) {
+	return b.command("build", args)
+}
+
+func (b *replayBazel) Test(args ...string) (*bytes.Buffer, error) {
+	return b.command("test", args)
+}
+
+func (b *replayBazel) MobileInstall(args ...string) (*bytes.Buffer, error) {
+	return b.command("mobile-install", args)
+}
+
+func (b *replayBazel) Run(args ...string) (*exec.Cmd, *bytes.Buffer, error) {
+	return nil, &bytes.Buffer{}, errors.New("bazel run can't be replayed")
+}
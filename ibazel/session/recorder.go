@@ -0,0 +1,145 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Recorder records a session. Its methods do nothing on a nil *Recorder, so
+// that callers don't have to check whether recording is on.
+type Recorder struct {
+	path   string
+	redact *redactor
+	start  time.Time
+
+	mu    sync.Mutex
+	rec   Recording
+	next  int
+	state string
+	saved bool
+}
+
+// maxEntries is how many entries a Recorder keeps. Once there are more, the
+// oldest ones are overwritten, so that a session that runs for days doesn't
+// use ever more memory.
+var maxEntries = 100000
+
+// NewRecorder starts recording the session of the iBazel version run with
+// args and flags in workspace. Save writes the recording to path, if it
+// isn't empty.
+func NewRecorder(path, workspace, version string, args []string, flags map[string]string) *Recorder {
+	r := &Recorder{
+		path:   path,
+		redact: newRedactor(workspace),
+		start:  time.Now(),
+	}
+	redactedFlags := map[string]string{}
+	for name, value := range flags {
+		redactedFlags[name] = r.redact.redact(value)
+	}
+	r.rec = Recording{
+		Version:       Version,
+		IBazelVersion: version,
+		Args:          r.redact.redactAll(args),
+		Flags:         redactedFlags,
+	}
+	return r
+}
+
+func (r *Recorder) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.At = time.Since(r.start)
+	if len(r.rec.Entries) < maxEntries {
+		r.rec.Entries = append(r.rec.Entries, e)
+		return
+	}
+	r.rec.Entries[r.next] = e
+	r.next = (r.next + 1) % maxEntries
+	r.rec.Dropped++
+}
+
+// entries returns the entries kept, oldest first. r.mu must be held.
+func (r *Recorder) entries() []Entry {
+	if r.next == 0 {
+		return r.rec.Entries
+	}
+	entries := make([]Entry, 0, len(r.rec.Entries))
+	entries = append(entries, r.rec.Entries[r.next:]...)
+	return append(entries, r.rec.Entries[:r.next]...)
+}
+
+// State records that the main loop is in state. Staying in a state, e.g.
+// WAIT after an event that doesn't matter, is only recorded once.
+func (r *Recorder) State(state string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	same := state == r.state
+	r.state = state
+	r.mu.Unlock()
+	if !same {
+		r.add(Entry{Kind: StateEntry, State: state})
+	}
+}
+
+// File records an event of the file watcher, "source" or "graph".
+func (r *Recorder) File(watcher string, e fsnotify.Event) {
+	if r == nil {
+		return
+	}
+	r.add(Entry{Kind: FileEntry, Watcher: watcher, Op: uint32(e.Op), Path: r.redact.redact(e.Name)})
+}
+
+// Command records that a Bazel command ran.
+func (r *Recorder) Command(command string, targets []string, success bool) {
+	if r == nil {
+		return
+	}
+	r.add(Entry{Kind: CommandEntry, Command: command, Targets: targets, Success: success})
+}
+
+// Trace returns the states and commands recorded so far, see Trace.
+func (r *Recorder) Trace() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Trace(r.entries())
+}
+
+// Save writes the recording and returns its path. Only the first call writes
+// it, since it is called on every way iBazel can exit, the others return an
+// empty path.
+func (r *Recorder) Save() (string, error) {
+	if r == nil || r.path == "" {
+		return "", nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.saved {
+		return "", nil
+	}
+	r.saved = true
+	rec := r.rec
+	rec.Entries = r.entries()
+	return r.path, rec.Save(r.path)
+}
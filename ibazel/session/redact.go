@@ -0,0 +1,70 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Placeholders for the parts of paths that are specific to a machine.
+const (
+	workspacePlaceholder = "$WORKSPACE"
+	homePlaceholder      = "$HOME"
+)
+
+// secretRegex matches the values of flags, headers and environment variables
+// that look like credentials, e.g. --remote_header=Authorization=Bearer xyz.
+var secretRegex = regexp.MustCompile(`(?i)((?:authorization|password|passwd|secret|token|api[-_]?key)\w*[=:]\s*(?:bearer\s+|basic\s+)?)[^\s"',]+`)
+
+// redactor replaces the paths of the workspace and the home directory with
+// placeholders, and hides secrets.
+type redactor struct {
+	paths *strings.Replacer
+}
+
+func newRedactor(workspace string) *redactor {
+	var oldnew []string
+	// The workspace is usually in the home directory, so it goes first.
+	if workspace != "" {
+		oldnew = append(oldnew, workspace, workspacePlaceholder)
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "" && home != "/" {
+		oldnew = append(oldnew, home, homePlaceholder)
+	}
+	return &redactor{paths: strings.NewReplacer(oldnew...)}
+}
+
+func (r *redactor) redact(s string) string {
+	return secretRegex.ReplaceAllString(r.paths.Replace(s), "${1}<redacted>")
+}
+
+func (r *redactor) redactAll(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	redacted := make([]string, len(ss))
+	for n, s := range ss {
+		redacted[n] = r.redact(s)
+	}
+	return redacted
+}
+
+// restore puts workspace where the recorded workspace was. Paths in the home
+// directory are left alone, they don't exist on another machine anyway.
+func restore(s, workspace string) string {
+	return strings.Replace(s, workspacePlaceholder, workspace, -1)
+}
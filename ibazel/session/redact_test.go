@@ -0,0 +1,37 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	r := newRedactor("/home/me/src/ws")
+	for _, c := range []struct {
+		in   string
+		want string
+	}{
+		{"/home/me/src/ws/a/a.go", "$WORKSPACE/a/a.go"},
+		{"--remote_header=Authorization=Bearer abc123", "--remote_header=Authorization=Bearer <redacted>"},
+		{"GITHUB_TOKEN=ghp_xyz rest", "GITHUB_TOKEN=<redacted> rest"},
+		{"password: hunter2", "password: <redacted>"},
+		{"--remote_header=x-api-key=abc123", "--remote_header=x-api-key=<redacted>"},
+		{"API_KEY=abc123", "API_KEY=<redacted>"},
+		{"INFO: Build completed successfully", "INFO: Build completed successfully"},
+	} {
+		if got := r.redact(c.in); got != c.want {
+			t.Errorf("redact(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package session records what iBazel sees and does, so that a session that
+// misbehaved can be replayed against the state machine, see `ibazel replay`.
+//
+// A recording is a tar file with two files in it. session.json has the
+// command line and the flags, events.jsonl has one Entry per line: the states
+// the main loop went through, the events of the file watchers, the Bazel
+// commands that ran and what every call to Bazel returned. Paths in the
+// workspace and the home directory, and anything that looks like a secret,
+// are redacted.
+package session
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// The kinds of entries.
+const (
+	// The main loop entered State.
+	StateEntry = "state"
+	// Watcher ("source" or "graph") got an event with Op for Path.
+	FileEntry = "file"
+	// A Bazel command, e.g. "build", ran for Targets, see Success.
+	CommandEntry = "command"
+	// Bazel was called, e.g. "query", with Args and returned Result, Output
+	// and Err.
+	BazelEntry = "bazel"
+)
+
+// Version is the version of the recording format.
+const Version = 1
+
+// Entry is something that happened during a session.
+type Entry struct {
+	// When it happened, since the session started.
+	At   time.Duration `json:"at"`
+	Kind string        `json:"kind"`
+
+	State string `json:"state,omitempty"`
+
+	Watcher string `json:"watcher,omitempty"`
+	Op      uint32 `json:"op,omitempty"`
+	Path    string `json:"path,omitempty"`
+
+	Command string   `json:"command,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	Success bool     `json:"success,omitempty"`
+
+	Call   string   `json:"call,omitempty"`
+	Args   []string `json:"args,omitempty"`
+	Result string   `json:"result,omitempty"`
+	Output string   `json:"output,omitempty"`
+	Err    string   `json:"err,omitempty"`
+	// Whether Err is a *bazel.QueryError, whose Stderr is Output.
+	QueryErr bool `json:"query_err,omitempty"`
+}
+
+// Recording is a recorded session.
+type Recording struct {
+	Version int `json:"version"`
+	// The iBazel that recorded the session.
+	IBazelVersion string `json:"ibazel_version"`
+	// The command and its arguments, e.g. ["build", "//server"].
+	Args []string `json:"args"`
+	// The flags that were set on the command line.
+	Flags map[string]string `json:"flags"`
+	// How many of the oldest entries were dropped to bound the recording.
+	Dropped int `json:"dropped,omitempty"`
+
+	Entries []Entry `json:"-"`
+}
+
+// Trace returns the states and commands of the entries, one line each. A
+// replay matches the recording if it has the same trace.
+func Trace(entries []Entry) []string {
+	var trace []string
+	for _, e := range entries {
+		switch e.Kind {
+		case StateEntry:
+			trace = append(trace, e.State)
+		case CommandEntry:
+			result := "failed"
+			if e.Success {
+				result = "succeeded"
+			}
+			trace = append(trace, fmt.Sprintf("%s %s %s", e.Command, strings.Join(e.Targets, " "), result))
+		}
+	}
+	return trace
+}
+
+// Open reads a recording.
+func Open(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rec *Recording
+	var entries []Entry
+	r := tar.NewReader(f)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		switch h.Name {
+		case "session.json":
+			rec = &Recording{}
+			if err := json.NewDecoder(r).Decode(rec); err != nil {
+				return nil, fmt.Errorf("reading %s: %v", h.Name, err)
+			}
+		case "events.jsonl":
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(nil, 64<<20)
+			for scanner.Scan() {
+				var e Entry
+				if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+					return nil, fmt.Errorf("reading %s: %v", h.Name, err)
+				}
+				entries = append(entries, e)
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, fmt.Errorf("reading %s: %v", h.Name, err)
+			}
+		}
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("%s isn't a recording of an iBazel session", path)
+	}
+	if rec.Version != Version {
+		return nil, fmt.Errorf("%s was recorded in version %d of the format, this iBazel reads version %d", path, rec.Version, Version)
+	}
+	rec.Entries = entries
+	return rec, nil
+}
+
+// Save writes the recording to path.
+func (rec *Recording) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := tar.NewWriter(f)
+
+	meta, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	var events strings.Builder
+	for _, e := range rec.Entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		events.Write(line)
+		events.WriteByte('\n')
+	}
+
+	now := time.Now()
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"session.json", meta},
+		{"events.jsonl", []byte(events.String())},
+	} {
+		h := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data)), ModTime: now}
+		if err := w.WriteHeader(h); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := w.Write(file.data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
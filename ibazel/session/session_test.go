@@ -0,0 +1,160 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/bazel"
+	mock_bazel "github.com/bazelbuild/bazel-watcher/bazel/testing"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.tar")
+
+	query := &blaze_query.QueryResult{
+		Target: []*blaze_query.Target{{
+			Type: blaze_query.Target_SOURCE_FILE.Enum(),
+			SourceFile: &blaze_query.SourceFile{
+				Name:     proto.String("//a:a.go"),
+				Location: proto.String("/recorded/ws/a/BUILD:1:1"),
+			},
+		}},
+	}
+	mock := &mock_bazel.MockBazel{}
+	mock.AddQueryResponse("deps(//a)", query)
+	mock.AddQueryError("deps(//b)", &bazel.QueryError{Err: errors.New("exit status 7"), Stderr: []byte("ERROR: /recorded/ws/b/BUILD:3:1: oops")})
+
+	r := NewRecorder(path, "/recorded/ws", "v1", []string{"build", "//a"}, map[string]string{"debounce": "1s"})
+	r.State("QUERY")
+	b := r.Bazel("query", mock)
+	b.Query("deps(//a)")
+	b.Query("deps(//b)")
+	r.State("RUN")
+	r.Bazel("build", mock).Build("//a")
+	r.Command("build", []string{"//a"}, true)
+	r.State("WAIT")
+	r.State("WAIT")
+	r.File("source", fsnotify.Event{Name: "/recorded/ws/a/a.go", Op: fsnotify.Write})
+	if saved, err := r.Save(); err != nil || saved != path {
+		t.Fatalf("Save() = %q, %v", saved, err)
+	}
+	if saved, _ := r.Save(); saved != "" {
+		t.Errorf("The recording was saved twice")
+	}
+
+	rec, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"build", "//a"}, rec.Args) {
+		t.Errorf("Args = %v", rec.Args)
+	}
+	if rec.Flags["debounce"] != "1s" {
+		t.Errorf("Flags = %v", rec.Flags)
+	}
+	wantTrace := []string{"QUERY", "RUN", "build //a succeeded", "WAIT"}
+	if got := Trace(rec.Entries); !reflect.DeepEqual(wantTrace, got) {
+		t.Errorf("Trace() = %v, want %v", got, wantTrace)
+	}
+	last := rec.Entries[len(rec.Entries)-1]
+	if last.Kind != FileEntry || last.Path != "$WORKSPACE/a/a.go" || fsnotify.Op(last.Op) != fsnotify.Write {
+		t.Errorf("Last entry = %+v, want a redacted write to a/a.go", last)
+	}
+
+	p := rec.Player("/replay/ws")
+	if got := p.Restore(last.Path); got != "/replay/ws/a/a.go" {
+		t.Errorf("Restore() = %q", got)
+	}
+	replay := p.Bazel()
+	for n := 0; n < 2; n++ {
+		res, err := replay.Query("deps(//a)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Target[0].SourceFile.GetLocation(); got != "/replay/ws/a/BUILD:1:1" {
+			t.Errorf("Location = %q", got)
+		}
+	}
+	_, err = replay.Query("deps(//b)")
+	queryErr, ok := err.(*bazel.QueryError)
+	if !ok || string(queryErr.Stderr) != "ERROR: /replay/ws/b/BUILD:3:1: oops" {
+		t.Errorf("Query(deps(//b)) = %v, want the recorded query error", err)
+	}
+	if _, err := replay.Build("//a"); err != nil {
+		t.Errorf("Build(): %v", err)
+	}
+	if _, err := replay.Build("//a"); err == nil {
+		t.Errorf("Only one build was recorded")
+	}
+}
+
+func TestRecorderKeepsLatestEntries(t *testing.T) {
+	oldMaxEntries := maxEntries
+	maxEntries = 3
+	defer func() { maxEntries = oldMaxEntries }()
+
+	r := NewRecorder("", "/recorded/ws", "v1", []string{"build", "//a"}, nil)
+	for _, state := range []string{"QUERY", "RUN", "WAIT", "QUERY", "RUN"} {
+		r.State(state)
+	}
+	want := []string{"WAIT", "QUERY", "RUN"}
+	if got := r.Trace(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Trace() = %v, want %v", got, want)
+	}
+	if r.rec.Dropped != 2 {
+		t.Errorf("Dropped = %d, want 2", r.rec.Dropped)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.State("QUERY")
+	r.File("source", fsnotify.Event{})
+	r.Command("build", nil, true)
+	mock := &mock_bazel.MockBazel{}
+	if b := r.Bazel("build", mock); b != mock {
+		t.Errorf("A nil Recorder shouldn't wrap Bazel")
+	}
+	if saved, err := r.Save(); saved != "" || err != nil {
+		t.Errorf("Save() = %q, %v", saved, err)
+	}
+}
+
+func TestOpenNotARecording(t *testing.T) {
+	f, err := ioutil.TempFile("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	if _, err := Open(f.Name()); err == nil {
+		t.Errorf("Open() of an empty file should fail")
+	}
+}
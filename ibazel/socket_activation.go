@@ -0,0 +1,89 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/socket_activation"
+)
+
+// The tag that makes iBazel hold a listening socket for a target and pass it
+// to every start of it, e.g. ibazel_listen=:8080 or ibazel_listen=http=:8080,
+// see socket_activation.Listen. It can be repeated.
+const listenTagPrefix = "ibazel_listen="
+
+// listenTags returns the sockets the ibazel_listen tags ask for.
+func listenTags(tags []string) []string {
+	var specs []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, listenTagPrefix) {
+			specs = append(specs, strings.TrimPrefix(tag, listenTagPrefix))
+		}
+	}
+	return specs
+}
+
+// socketActivation returns a runner that passes the sockets of the
+// ibazel_listen tags to target, opening them the first time. r is returned
+// as it is if they can't be passed.
+func (i *IBazel) socketActivation(r command.Runner, target string, specs []string) command.Runner {
+	if !command.IsLocal(r) {
+		log.Errorf("Not passing sockets to %s, it isn't run locally", target)
+		return r
+	}
+	if runtime.GOOS == "windows" {
+		log.Errorf("Not passing sockets to %s, socket activation isn't supported on Windows", target)
+		return r
+	}
+
+	sockets, ok := i.sockets[target]
+	if !ok {
+		var err error
+		if sockets, err = socket_activation.Listen(specs); err != nil {
+			log.Errorf("Not passing sockets to %s: %v", target, err)
+			return r
+		}
+		if i.sockets == nil {
+			i.sockets = map[string]socket_activation.Sockets{}
+		}
+		i.sockets[target] = sockets
+	}
+	log.Logf("Passing %s to %s", sockets.Describe(), target)
+	return command.SocketActivation(r, sockets.Files(), sockets.Env())
+}
+
+// closeSockets closes the sockets held for target, or for every target if it
+// is empty.
+func (i *IBazel) closeSockets(target string) {
+	targets := []string{target}
+	if target == "" {
+		targets = make([]string, 0, len(i.sockets))
+		for t := range i.sockets {
+			targets = append(targets, t)
+		}
+		sort.Strings(targets)
+	}
+	for _, t := range targets {
+		if sockets, ok := i.sockets[t]; ok {
+			sockets.Close()
+			delete(i.sockets, t)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package socket_activation opens the listening sockets of a target once and
+// keeps them open across its restarts, so that they can be passed to every
+// start of the target like systemd's socket activation does (see
+// sd_listen_fds(3)). Connections made while the target restarts wait in the
+// backlog of the socket instead of being refused, and the target never races
+// its previous self to bind the port.
+package socket_activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// The first file descriptor passed to the target, after stdin, stdout and
+// stderr.
+const firstFD = 3
+
+// Socket is a listening socket and the name it is passed with.
+type Socket struct {
+	Name string
+	// The address it listens on, e.g. ":8080" or "unix:/tmp/app.sock".
+	Addr string
+
+	listener net.Listener
+	file     *os.File
+}
+
+// Sockets are the listening sockets of a target.
+type Sockets []*Socket
+
+// fileListener is implemented by the TCP and Unix listeners.
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// Listen opens a socket for every spec, "[<name>=]<address>" where the
+// address is "[host]:port" for TCP or "unix:<path>" for a Unix socket. The
+// name defaults to "listen". The sockets must be closed by the caller.
+func Listen(specs []string) (Sockets, error) {
+	var sockets Sockets
+	for _, spec := range specs {
+		s, err := listen(spec)
+		if err != nil {
+			sockets.Close()
+			return nil, err
+		}
+		sockets = append(sockets, s)
+	}
+	return sockets, nil
+}
+
+func listen(spec string) (*Socket, error) {
+	name, addr := "listen", spec
+	if eq := strings.Index(spec, "="); eq >= 0 {
+		name, addr = spec[:eq], spec[eq+1:]
+	}
+	if name == "" || strings.Contains(name, ":") {
+		return nil, fmt.Errorf("%q isn't a valid socket name, it can't be empty or contain a colon", name)
+	}
+
+	network, address := "tcp", addr
+	if strings.HasPrefix(addr, "unix:") {
+		network, address = "unix", strings.TrimPrefix(addr, "unix:")
+		// Left behind by an iBazel that was killed.
+		os.Remove(address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	file, err := l.(fileListener).File()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &Socket{Name: name, Addr: addr, listener: l, file: file}, nil
+}
+
+// Files returns the files of the sockets, in the order they have to be passed
+// to the target from file descriptor 3 on.
+func (s Sockets) Files() []*os.File {
+	files := make([]*os.File, len(s))
+	for n, socket := range s {
+		files[n] = socket.file
+	}
+	return files
+}
+
+// Env returns the environment variables that tell the target about the
+// sockets, except for LISTEN_PID, which has to be the PID of the target
+// itself.
+func (s Sockets) Env() []string {
+	names := make([]string, len(s))
+	for n, socket := range s {
+		names[n] = socket.Name
+	}
+	return []string{
+		fmt.Sprintf("LISTEN_FDS=%d", len(s)),
+		"LISTEN_FDNAMES=" + strings.Join(names, ":"),
+	}
+}
+
+// Describe returns the sockets and the file descriptors they are passed as,
+// e.g. "http on :8080 (fd 3)".
+func (s Sockets) Describe() string {
+	descriptions := make([]string, len(s))
+	for n, socket := range s {
+		descriptions[n] = fmt.Sprintf("%s on %s (fd %d)", socket.Name, socket.Addr, firstFD+n)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// Close closes the sockets, after which the target can't be started with
+// them anymore.
+func (s Sockets) Close() {
+	for _, socket := range s {
+		socket.file.Close()
+		socket.listener.Close()
+	}
+}
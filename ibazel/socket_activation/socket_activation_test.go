@@ -0,0 +1,76 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket_activation
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket_activation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+
+	sockets, err := Listen([]string{"127.0.0.1:0", "admin=unix:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sockets.Close()
+
+	if len(sockets.Files()) != 2 {
+		t.Fatalf("Expected 2 files, got %v", sockets.Files())
+	}
+	if want := []string{"LISTEN_FDS=2", "LISTEN_FDNAMES=listen:admin"}; !reflect.DeepEqual(want, sockets.Env()) {
+		t.Errorf("Env() = %v, want %v", sockets.Env(), want)
+	}
+	if want := "admin on unix:" + path + " (fd 4)"; !strings.HasSuffix(sockets.Describe(), want) {
+		t.Errorf("Describe() = %q, want it to end in %q", sockets.Describe(), want)
+	}
+
+	// The socket accepts connections before anything serves it, e.g. while
+	// the target restarts.
+	conn, err := net.Dial("tcp", sockets[0].listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	// And the file passed to the target is the same socket.
+	l, err := net.FileListener(sockets.Files()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().String() != sockets[0].listener.Addr().String() {
+		t.Errorf("The file listens on %s, not on %s", l.Addr(), sockets[0].listener.Addr())
+	}
+}
+
+func TestListenErrors(t *testing.T) {
+	for _, spec := range []string{"a:b=:0", "=:0", "not an address"} {
+		if sockets, err := Listen([]string{spec}); err == nil {
+			sockets.Close()
+			t.Errorf("Listen(%q) didn't fail", spec)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/command"
+)
+
+func TestListenTags(t *testing.T) {
+	assertEqual(t, []string(nil), listenTags([]string{"manual"}), "Without a tag")
+	assertEqual(t, []string{":8080", "admin=unix:/tmp/admin.sock"}, listenTags([]string{"ibazel_listen=:8080", "manual", "ibazel_listen=admin=unix:/tmp/admin.sock"}), "With tags")
+}
+
+func TestIBazelSocketActivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Socket activation isn't supported on Windows")
+	}
+	i := newIBazel(t)
+	defer i.Cleanup()
+
+	if r := i.socketActivation(nil, "//app:server", []string{"127.0.0.1:0"}); r == nil {
+		t.Fatal("Expected a runner")
+	}
+	sockets := i.sockets["//app:server"]
+	if len(sockets) != 1 {
+		t.Fatalf("Expected a socket, got %v", sockets)
+	}
+	// Setting the target up again keeps its socket.
+	i.socketActivation(nil, "//app:server", []string{"127.0.0.1:0"})
+	if i.sockets["//app:server"][0] != sockets[0] {
+		t.Errorf("The socket was opened again")
+	}
+
+	docker, err := command.ParseRunner("docker:dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := i.socketActivation(docker, "//app:remote", []string{"127.0.0.1:0"}); r != docker {
+		t.Errorf("Sockets can't be passed to remote targets")
+	}
+	if _, ok := i.sockets["//app:remote"]; ok {
+		t.Errorf("Opened a socket for a remote target")
+	}
+
+	i.closeSockets("//app:server")
+	if len(i.sockets) != 0 {
+		t.Errorf("Sockets left open: %v", i.sockets)
+	}
+}
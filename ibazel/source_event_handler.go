@@ -20,14 +20,27 @@ import (
 
 type SourceEventHandler struct {
 	SourceFileEvents  chan fsnotify.Event
-	SourceFileWatcher *fsnotify.Watcher
+	SourceFileWatcher fSNotifyWatcher
+
+	// Whether Listen keeps forwarding events, see --watchdog_timeout.
+	alive *forwarder
 }
 
 func (s *SourceEventHandler) Listen() {
+	defer s.alive.recover()
 	for {
 		select {
-		case event := <-s.SourceFileWatcher.Events:
-			s.SourceFileEvents <- event
+		case event, ok := <-s.SourceFileWatcher.Events():
+			if !ok {
+				return
+			}
+			s.alive.sending()
+			select {
+			case s.SourceFileEvents <- event:
+			case <-s.alive.stopped():
+				return
+			}
+			s.alive.sent()
 
 			switch event.Op {
 			case fsnotify.Remove, fsnotify.Rename:
@@ -37,10 +50,11 @@ func (s *SourceEventHandler) Listen() {
 	}
 }
 
-func NewSourceEventHandler(sourceFileWatcher *fsnotify.Watcher) *SourceEventHandler {
+func NewSourceEventHandler(sourceFileWatcher fSNotifyWatcher) *SourceEventHandler {
 	handler := &SourceEventHandler{
-		make(chan fsnotify.Event),
-		sourceFileWatcher,
+		SourceFileEvents:  make(chan fsnotify.Event),
+		SourceFileWatcher: sourceFileWatcher,
+		alive:             newForwarder("source file event handler"),
 	}
 	go handler.Listen()
 	return handler
@@ -0,0 +1,138 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package status_line prints one machine-parsable line to stderr whenever
+// iBazel starts, runs a command, finishes it or stops, for process supervisors
+// and scripts that run iBazel with --no_tty. The lines are in the logfmt
+// format, e.g.
+//
+//	ibazel status=failed iteration=3f9c27a1 trigger=file_change command=build targets="//a //b" duration_ms=1520
+package status_line
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/terminal"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var stderr io.Writer = os.Stderr
+
+var timeNow = time.Now
+
+type StatusLine struct {
+	iteration string
+	trigger   string
+	start     time.Time
+	// Whether the stopped line was printed already.
+	stopped bool
+}
+
+func New() *StatusLine {
+	return &StatusLine{}
+}
+
+func (s *StatusLine) Initialize(info *map[string]string) {
+	s.print("starting")
+}
+
+func (s *StatusLine) TargetDecider(rule *blaze_query.Rule) {}
+
+func (s *StatusLine) ChangeDetected(targets []string, changeType string, change string) {}
+
+// IterationStarted implements the IterationListener interface of iBazel.
+func (s *StatusLine) IterationStarted(id string, trigger string) {
+	s.iteration = id
+	s.trigger = trigger
+}
+
+func (s *StatusLine) BeforeCommand(targets []string, command string) {
+	s.start = timeNow()
+	s.print("running", "iteration", s.iteration, "trigger", s.trigger, "command", command, "targets", strings.Join(targets, " "))
+}
+
+func (s *StatusLine) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	status := "succeeded"
+	if !success {
+		status = "failed"
+	}
+	duration := int64(timeNow().Sub(s.start) / time.Millisecond)
+	s.print(status, "iteration", s.iteration, "trigger", s.trigger, "command", command, "targets", strings.Join(targets, " "), "duration_ms", strconv.FormatInt(duration, 10))
+}
+
+// QueryFailed implements the QueryErrorListener interface of iBazel.
+func (s *StatusLine) QueryFailed(targets []string, output *bytes.Buffer) {
+	s.print("failed", "iteration", s.iteration, "trigger", s.trigger, "command", "query", "targets", strings.Join(targets, " "))
+}
+
+// HealthChanged implements the HealthListener interface of iBazel.
+func (s *StatusLine) HealthChanged(target string, healthy bool, reason string) {
+	status := "healthy"
+	if !healthy {
+		status = "unhealthy"
+	}
+	s.print(status, "target", target, "reason", reason)
+}
+
+// WatchersRestarted implements the WatchdogListener interface of iBazel.
+func (s *StatusLine) WatchersRestarted(reason string) {
+	s.print("watchers_restarted", "reason", reason)
+}
+
+// Cleanup prints the stopped line, unless Shutdown printed it with the reason
+// already.
+func (s *StatusLine) Cleanup() {
+	s.stop()
+}
+
+func (s *StatusLine) Shutdown(reason string) {
+	s.stop("reason", reason)
+}
+
+func (s *StatusLine) stop(kv ...string) {
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	s.print("stopped", kv...)
+}
+
+// print writes a status line with the key value pairs in kv.
+func (s *StatusLine) print(status string, kv ...string) {
+	if !terminal.NoTTY() {
+		return
+	}
+	line := "ibazel status=" + status
+	for n := 0; n+1 < len(kv); n += 2 {
+		if kv[n+1] == "" {
+			continue
+		}
+		line += " " + kv[n] + "=" + quote(kv[n+1])
+	}
+	fmt.Fprintln(stderr, line)
+}
+
+// quote quotes value if logfmt requires it.
+func quote(value string) string {
+	if strings.ContainsAny(value, " \"=\\\t\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status_line
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestStatusLine(t *testing.T) {
+	flag.Set("no_tty", "true")
+	defer flag.Set("no_tty", "false")
+
+	out := &bytes.Buffer{}
+	stderr = out
+	now := time.Unix(100, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	s := New()
+	s.Initialize(nil)
+	s.IterationStarted("0a1b2c3d", "startup")
+	s.BeforeCommand([]string{"//foo:bar", "//foo:baz"}, "build")
+	now = now.Add(1500 * time.Millisecond)
+	s.AfterCommand([]string{"//foo:bar", "//foo:baz"}, "build", false, nil)
+	s.IterationStarted("4e5f6a7b", "graph_change")
+	s.QueryFailed([]string{"//foo:bar"}, nil)
+	s.HealthChanged("//foo:server", false, "connection refused")
+	s.HealthChanged("//foo:server", true, "")
+	s.WatchersRestarted("the source file event handler panicked: oops")
+	s.Shutdown("SIGTERM")
+	s.Cleanup()
+
+	want := "ibazel status=starting\n" +
+		"ibazel status=running iteration=0a1b2c3d trigger=startup command=build targets=\"//foo:bar //foo:baz\"\n" +
+		"ibazel status=failed iteration=0a1b2c3d trigger=startup command=build targets=\"//foo:bar //foo:baz\" duration_ms=1500\n" +
+		"ibazel status=failed iteration=4e5f6a7b trigger=graph_change command=query targets=//foo:bar\n" +
+		"ibazel status=unhealthy target=//foo:server reason=\"connection refused\"\n" +
+		"ibazel status=healthy target=//foo:server\n" +
+		"ibazel status=watchers_restarted reason=\"the source file event handler panicked: oops\"\n" +
+		"ibazel status=stopped reason=SIGTERM\n"
+	if got := out.String(); got != want {
+		t.Errorf("Got:\n%s\nWant:\n%s", got, want)
+	}
+}
+
+func TestStatusLine_disabled(t *testing.T) {
+	out := &bytes.Buffer{}
+	stderr = out
+
+	s := New()
+	s.Initialize(nil)
+	s.BeforeCommand([]string{"//foo:bar"}, "build")
+	if out.Len() != 0 {
+		t.Errorf("Wrote %q without --no_tty", out.String())
+	}
+}
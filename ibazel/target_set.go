@@ -0,0 +1,140 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/control"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// A targetEdit asks the main loop to change the targets it builds or tests.
+type targetEdit struct {
+	add    []string
+	remove []string
+	// Whether the package of the latest file given to FocusFile replaces all
+	// targets.
+	focus bool
+	// Targets that replace all targets, see SwitchPreset.
+	replace []string
+}
+
+// How many target edits can wait for the main loop, e.g. while it builds.
+const targetEditBuffer = 16
+
+// AddTarget adds targets to a running `ibazel build`, `ibazel test` or
+// `ibazel mrun`, which then requeries what to watch and rebuilds. Targets
+// that are already being built are ignored. It is safe to call from any
+// goroutine and returns right away: the main loop takes the new targets after
+// the current iteration, and logs why if it can't.
+func (i *IBazel) AddTarget(targets ...string) error {
+	return i.editTargets(targetEdit{add: targets})
+}
+
+// RemoveTarget is the counterpart of AddTarget. The last target can't be
+// removed.
+func (i *IBazel) RemoveTarget(targets ...string) error {
+	return i.editTargets(targetEdit{remove: targets})
+}
+
+func (i *IBazel) editTargets(edit targetEdit) error {
+	all := make([]string, 0, len(edit.add)+len(edit.remove)+len(edit.replace))
+	all = append(append(append(all, edit.add...), edit.remove...), edit.replace...)
+	for _, target := range all {
+		if _, ok := parseLabel(target); !ok {
+			return fmt.Errorf("%q isn't a valid target", target)
+		}
+	}
+	select {
+	case i.targetEdits <- edit:
+		return nil
+	default:
+		return control.ErrBusy
+	}
+}
+
+// applyTargetEdit is called from the main loop to handle an AddTarget or
+// RemoveTarget request. It returns whether the targets changed.
+func (i *IBazel) applyTargetEdit(command string, edit targetEdit) bool {
+	if command != "build" && command != "test" && command != "mrun" {
+		log.Errorf("The targets of `ibazel %s` can't be changed, only the ones of `ibazel build`, `ibazel test` and `ibazel mrun`", command)
+		return false
+	}
+	if edit.replace != nil {
+		edit.remove = i.targets
+		edit.add = edit.replace
+	}
+	if edit.focus {
+		path := i.takeFocus()
+		target, err := i.focusedPackage(path)
+		if err != nil {
+			log.Errorf("Error following %s: %v", path, err)
+			return false
+		}
+		edit.remove = i.targets
+		edit.add = []string{target}
+	}
+	targets, err := editedTargets(i.targets, edit)
+	if err != nil {
+		log.Errorf("Error changing the targets: %v", err)
+		return false
+	}
+	if strings.Join(targets, " ") == strings.Join(i.targets, " ") {
+		return false
+	}
+	i.targets = targets
+	i.setTargetRepos(targets)
+	log.Logf("Now watching %s", strings.Join(targets, " "))
+	return true
+}
+
+func editedTargets(targets []string, edit targetEdit) ([]string, error) {
+	current := map[string]struct{}{}
+	for _, target := range targets {
+		current[target] = struct{}{}
+	}
+	for _, target := range edit.remove {
+		if _, ok := current[target]; !ok {
+			return nil, fmt.Errorf("%s isn't one of the targets", target)
+		}
+	}
+
+	removed := map[string]struct{}{}
+	for _, target := range edit.remove {
+		removed[target] = struct{}{}
+	}
+	edited := []string{}
+	kept := map[string]struct{}{}
+	for _, target := range targets {
+		if _, ok := removed[target]; !ok {
+			edited = append(edited, target)
+			kept[target] = struct{}{}
+		}
+	}
+	for _, target := range edit.add {
+		if _, ok := kept[target]; ok {
+			continue
+		}
+		kept[target] = struct{}{}
+		edited = append(edited, target)
+	}
+	if len(edited) == 0 {
+		return nil, errors.New("can't remove the last target")
+	}
+	return edited, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package temp_dir decides where iBazel keeps its temporary files and caches.
+// Everything goes into a directory that belongs to the current user, so that
+// several people running iBazel on the same machine don't trip over each
+// other's files.
+package temp_dir
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Dir returns the directory for iBazel's temporary files, creating it if
+// needed. It is inside os.TempDir(), which honors $TMPDIR on Unix and
+// %TMP%/%TEMP% on Windows.
+func Dir() string {
+	return ensure(filepath.Join(os.TempDir(), "ibazel-"+username()))
+}
+
+// CacheDir returns the directory for files that are worth keeping between
+// runs of iBazel, creating it if needed. It honors $XDG_CACHE_HOME and the
+// platform's conventions, and falls back to Dir.
+func CacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return Dir()
+	}
+	return ensure(filepath.Join(dir, "ibazel"))
+}
+
+// Path returns a path inside of Dir.
+func Path(elem ...string) string {
+	return filepath.Join(append([]string{Dir()}, elem...)...)
+}
+
+// TempFile creates a new temporary file inside of Dir, see ioutil.TempFile.
+func TempFile(pattern string) (*os.File, error) {
+	return ioutil.TempFile(Dir(), pattern)
+}
+
+// TempDir creates a new temporary directory inside of Dir, see
+// ioutil.TempDir.
+func TempDir(prefix string) (string, error) {
+	return ioutil.TempDir(Dir(), prefix)
+}
+
+// Writable returns whether files can be created in dir, or in the closest of
+// its parents that exists if it doesn't exist yet. It is false on file
+// systems mounted read-only, e.g. snapshots.
+func Writable(dir string) bool {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+	return canWrite(dir)
+}
+
+func ensure(dir string) string {
+	// If this fails, whoever uses the directory reports the problem.
+	os.MkdirAll(dir, 0700)
+	return dir
+}
+
+func username() string {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if name == "" {
+		name = os.Getenv("USER")
+	}
+	if name == "" {
+		name = os.Getenv("USERNAME")
+	}
+	if name == "" {
+		return "unknown"
+	}
+	// Windows user names look like DOMAIN\user.
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\\', '/', ':':
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package temp_dir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "temp_dir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if runtime.GOOS == "windows" {
+		defer os.Setenv("TMP", os.Getenv("TMP"))
+		os.Setenv("TMP", tmp)
+	} else {
+		defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+		os.Setenv("TMPDIR", tmp)
+	}
+
+	dir := Dir()
+	if filepath.Dir(dir) != tmp || !strings.HasPrefix(filepath.Base(dir), "ibazel-") {
+		t.Errorf("Dir() = %q, want a per-user directory inside %q", dir, tmp)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("Dir() wasn't created: %v", err)
+	}
+
+	f, err := TempFile("foo_*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if filepath.Dir(f.Name()) != dir {
+		t.Errorf("TempFile created %q outside of %q", f.Name(), dir)
+	}
+
+	if path := Path("running", "a.log"); path != filepath.Join(dir, "running", "a.log") {
+		t.Errorf("Path() = %q", path)
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG_CACHE_HOME is only used on Linux")
+	}
+	tmp, err := ioutil.TempDir("", "temp_dir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", tmp)
+
+	if dir := CacheDir(); dir != filepath.Join(tmp, "ibazel") {
+		t.Errorf("CacheDir() = %q, want %q", dir, filepath.Join(tmp, "ibazel"))
+	}
+}
+
+func TestWritable(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "temp_dir_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if !Writable(tmp) {
+		t.Errorf("Writable(%q) = false", tmp)
+	}
+	missing := filepath.Join(tmp, "a", "b")
+	if !Writable(missing) {
+		t.Errorf("Writable(%q) = false", missing)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "a")); !os.IsNotExist(err) {
+		t.Errorf("Writable created the missing directory")
+	}
+	if entries, _ := ioutil.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("Writable left %d files behind", len(entries))
+	}
+
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("Permissions don't make a directory read-only")
+	}
+	readOnly := filepath.Join(tmp, "read_only")
+	if err := os.Mkdir(readOnly, 0500); err != nil {
+		t.Fatal(err)
+	}
+	if Writable(readOnly) {
+		t.Errorf("Writable(%q) = true", readOnly)
+	}
+	if Writable(filepath.Join(readOnly, "missing")) {
+		t.Errorf("Writable(%q) = true", filepath.Join(readOnly, "missing"))
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package temp_dir
+
+import "golang.org/x/sys/unix"
+
+// canWrite asks the kernel whether files can be created in the existing
+// directory dir, without creating one, which the file watchers, IDEs and git
+// would all see.
+func canWrite(dir string) bool {
+	return unix.Access(dir, unix.W_OK) == nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package terminal decides what iBazel may do on the terminal its output goes
+// to: whether the output may be colored and whether lines may be redrawn in
+// place, and whether it may ask the user anything. Everything iBazel colors,
+// redraws or asks goes through this package, so that --color, --no_tty,
+// NO_COLOR, FORCE_COLOR and TERM=dumb apply to all of it.
+package terminal
+
+import (
+	"flag"
+	"io"
+	"os"
+)
+
+var color = flag.String("color", "auto", "Whether to color the output of iBazel and Bazel: always, auto or never. auto colors it on terminals and honors NO_COLOR, FORCE_COLOR and TERM=dumb")
+
+var noTTY = flag.Bool("no_tty", false, "Run without a terminal, e.g. under systemd, supervisord or a devcontainer startup script: never prompt or read keys, print machine-parsable status lines and exit cleanly on SIGTERM")
+
+var getenv = os.Getenv
+
+// isTerminal is a variable so that tests don't depend on how they are run.
+var isTerminal = func(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Color returns whether output written to w may be colored.
+func Color(w io.Writer) bool {
+	// Bazel's own --color takes yes and no.
+	switch *color {
+	case "always", "yes":
+		return true
+	case "never", "no":
+		return false
+	}
+	// See https://no-color.org and https://force-color.org.
+	if getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := getenv("FORCE_COLOR"); force != "" && force != "0" && force != "false" {
+		return true
+	}
+	return Interactive(w)
+}
+
+// Interactive returns whether w is a terminal that understands escape
+// sequences, so that a line can be redrawn in place. Pipes, files and CI logs
+// aren't.
+func Interactive(w io.Writer) bool {
+	if *noTTY || getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// NoTTY returns whether --no_tty was given: nobody is at the terminal to
+// answer prompts or press keys, and a process supervisor reads the output.
+func NoTTY() bool {
+	return *noTTY
+}
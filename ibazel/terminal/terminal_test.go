@@ -0,0 +1,75 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terminal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestColor(t *testing.T) {
+	defer func(old string) { *color = old }(*color)
+	oldIsTerminal := isTerminal
+	defer func() { isTerminal = oldIsTerminal }()
+	isTerminal = func(w io.Writer) bool { return w == nil }
+	oldGetenv := getenv
+	defer func() { getenv = oldGetenv }()
+
+	tests := []struct {
+		color    string
+		env      map[string]string
+		terminal bool
+		want     bool
+	}{
+		{"auto", nil, true, true},
+		{"auto", nil, false, false},
+		{"auto", map[string]string{"NO_COLOR": "1"}, true, false},
+		{"auto", map[string]string{"FORCE_COLOR": "1"}, false, true},
+		{"auto", map[string]string{"FORCE_COLOR": "0"}, false, false},
+		{"auto", map[string]string{"TERM": "dumb"}, true, false},
+		{"always", map[string]string{"NO_COLOR": "1"}, false, true},
+		{"never", map[string]string{"FORCE_COLOR": "1"}, true, false},
+		{"yes", nil, false, true},
+		{"no", nil, true, false},
+	}
+	for _, test := range tests {
+		*color = test.color
+		env := test.env
+		getenv = func(key string) string { return env[key] }
+		var w io.Writer = &bytes.Buffer{}
+		if test.terminal {
+			w = nil
+		}
+		if got := Color(w); got != test.want {
+			t.Errorf("Color with --color=%s, %v on a terminal: %t: got %t, want %t", test.color, test.env, test.terminal, got, test.want)
+		}
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	if Interactive(&bytes.Buffer{}) {
+		t.Errorf("A buffer is interactive")
+	}
+
+	oldIsTerminal := isTerminal
+	defer func() { isTerminal = oldIsTerminal }()
+	isTerminal = func(w io.Writer) bool { return true }
+	defer func() { *noTTY = false }()
+	*noTTY = true
+	if Interactive(nil) {
+		t.Errorf("A terminal is interactive with --no_tty")
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package test_history remembers the result of every test across iterations
+// of `ibazel test` and tells apart tests that broke because of a change from
+// tests that are flaky.
+package test_history
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/third_party/bazel/master/src/main/protobuf/blaze_query"
+)
+
+var trackFlakyTests = flag.Bool("track_flaky_tests", true, "Report tests that change result without a related source change as flaky")
+
+// Matches a line of the test summary that Bazel prints at the end of a test
+// command, e.g. "//foo:bar_test   (cached) PASSED in 0.1s".
+var summaryLineRegex = regexp.MustCompile(`^(@?[\w\-.]*//\S*)\s+(\(cached\)\s+)?(PASSED|FAILED TO BUILD|FAILED|FLAKY|TIMEOUT|NO STATUS|INCOMPLETE|SKIPPED)\b`)
+
+// Status is the result of a single test in an iteration.
+type Status string
+
+const (
+	Passed  Status = "PASSED"
+	Failed  Status = "FAILED"
+	Flaky   Status = "FLAKY"
+	Timeout Status = "TIMEOUT"
+)
+
+// Result is a single test's line from Bazel's test summary.
+type Result struct {
+	Target string
+	Status Status
+	Cached bool
+}
+
+func (r Result) passed() bool {
+	return r.Status == Passed
+}
+
+// SourceFiles returns the set of source files that a test depends on.
+type SourceFiles func(target string) (map[string]struct{}, error)
+
+type TestHistory struct {
+	sourceFiles SourceFiles
+
+	// Files changed since the last test command.
+	changes []string
+	// The last known result of every test.
+	last map[string]Result
+	// Number of unexplained state flips per flaky test.
+	flaky map[string]int
+	// The results of the last test command from its build events, if it had
+	// them.
+	events []Result
+}
+
+func New(sourceFiles SourceFiles) *TestHistory {
+	return &TestHistory{
+		sourceFiles: sourceFiles,
+		last:        map[string]Result{},
+		flaky:       map[string]int{},
+	}
+}
+
+func (h *TestHistory) Initialize(info *map[string]string) {}
+
+func (h *TestHistory) TargetDecider(rule *blaze_query.Rule) {}
+
+func (h *TestHistory) ChangeDetected(targets []string, changeType string, change string) {
+	h.changes = append(h.changes, change)
+}
+
+func (h *TestHistory) BeforeCommand(targets []string, command string) {}
+
+// BuildEventsReceived implements the BuildEventListener interface of iBazel.
+func (h *TestHistory) BuildEventsReceived(targets []string, command string, result *bep.Result) {
+	if command == "test" {
+		h.events = EventResults(result)
+	}
+}
+
+func (h *TestHistory) AfterCommand(targets []string, command string, success bool, output *bytes.Buffer) {
+	events := h.events
+	h.events = nil
+	if !*trackFlakyTests || command != "test" {
+		return
+	}
+
+	results := events
+	if results == nil {
+		if output == nil {
+			return
+		}
+		results = ParseResults(output)
+	}
+	h.record(results)
+	h.changes = nil
+}
+
+func (h *TestHistory) Cleanup() {}
+
+// record compares results to the previous iteration and reports regressions
+// and flaky tests.
+func (h *TestHistory) record(results []Result) {
+	var regressions, newlyFlaky []string
+	for _, r := range results {
+		prev, seen := h.last[r.Target]
+		h.last[r.Target] = r
+
+		if r.Status == Flaky {
+			// Bazel had to retry the test to get it to pass.
+			h.flaky[r.Target]++
+			newlyFlaky = append(newlyFlaky, r.Target)
+			continue
+		}
+		if !seen || r.Cached || prev.passed() == r.passed() {
+			continue
+		}
+
+		if h.relatedChange(r.Target) {
+			if !r.passed() {
+				regressions = append(regressions, r.Target)
+			}
+			continue
+		}
+		h.flaky[r.Target]++
+		newlyFlaky = append(newlyFlaky, r.Target)
+	}
+
+	if len(regressions) > 0 {
+		log.Errorf("Regressions: %s", strings.Join(regressions, " "))
+	}
+	if len(newlyFlaky) > 0 {
+		log.Logf("Changed result without a related change (flaky?): %s", strings.Join(newlyFlaky, " "))
+	}
+	if len(h.flaky) > 0 {
+		log.Logf("Flaky tests this session: %s", h.summary())
+	}
+}
+
+// relatedChange reports whether any of the files changed this iteration is a
+// dependency of target. When that can't be determined the change is assumed
+// to be related so that real regressions are never hidden.
+func (h *TestHistory) relatedChange(target string) bool {
+	if len(h.changes) == 0 {
+		return false
+	}
+	if h.sourceFiles == nil {
+		return true
+	}
+
+	files, err := h.sourceFiles(target)
+	if err != nil {
+		log.Errorf("Error finding the sources of %s: %v", target, err)
+		return true
+	}
+	for _, change := range h.changes {
+		if _, ok := files[change]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *TestHistory) summary() string {
+	targets := make([]string, 0, len(h.flaky))
+	for target := range h.flaky {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	parts := make([]string, 0, len(targets))
+	for _, target := range targets {
+		n := h.flaky[target]
+		if n == 1 {
+			parts = append(parts, target)
+		} else {
+			parts = append(parts, fmt.Sprintf("%s (x%d)", target, n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseResults extracts the per-test results from the output of a Bazel test
+// command.
+func ParseResults(output *bytes.Buffer) []Result {
+	var results []Result
+	for _, r := range ParseSummary(output) {
+		switch r.Status {
+		case Passed, Failed, Flaky, Timeout:
+			results = append(results, r)
+		}
+		// Anything else isn't a test result (e.g. the test didn't build).
+	}
+	return results
+}
+
+// EventResults returns the per-test results from the build events of a Bazel
+// test command, like ParseResults does from its output. It returns nil if
+// there are no build events.
+func EventResults(result *bep.Result) []Result {
+	if result == nil {
+		return nil
+	}
+	results := []Result{}
+	for _, t := range result.Targets {
+		switch Status(t.TestStatus) {
+		case Passed, Failed, Flaky, Timeout:
+			results = append(results, Result{
+				Target: t.Label,
+				Status: Status(t.TestStatus),
+				Cached: t.TestCached,
+			})
+		}
+	}
+	return results
+}
+
+// ParseSummary extracts every line of the test summary from the output of a
+// Bazel test command, including the tests that never ran, e.g. with the
+// status "FAILED TO BUILD" or "NO STATUS".
+func ParseSummary(output *bytes.Buffer) []Result {
+	var results []Result
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		line := log.StripColor(scanner.Text())
+		matches := summaryLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			continue
+		}
+		results = append(results, Result{
+			Target: matches[1],
+			Status: Status(matches[3]),
+			Cached: matches[2] != "",
+		})
+	}
+	return results
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test_history
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func init() {
+	log.FakeExit()
+}
+
+func TestParseResults(t *testing.T) {
+	output := bytes.NewBufferString(`INFO: Build completed, 1 test FAILED, 6 total actions
+//foo:a_test                                                             PASSED in 0.4s
+//foo:b_test                                                    (cached) PASSED in 0.1s
+` + "\x1b[31m\x1b[1m//foo:c_test\x1b[0m                                                             FAILED in 0.3s" + `
+  /home/user/.cache/bazel/execroot/__main__/bazel-out/k8-fastbuild/testlogs/foo/c_test/test.log
+//foo:d_test                                                              FLAKY, failed in 1 out of 2 in 0.5s
+@other//bar:e_test                                                      TIMEOUT in 60.0s
+//foo:f_test                                                     FAILED TO BUILD
+//foo:g_test                                                          NO STATUS
+
+Executed 4 out of 5 tests: 1 test passes, 1 fails locally and 1 was flaky.
+`)
+
+	want := []Result{
+		{Target: "//foo:a_test", Status: Passed},
+		{Target: "//foo:b_test", Status: Passed, Cached: true},
+		{Target: "//foo:c_test", Status: Failed},
+		{Target: "//foo:d_test", Status: Flaky},
+		{Target: "@other//bar:e_test", Status: Timeout},
+	}
+	got := ParseResults(output)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("ParseResults()\nGot:  %v\nWant: %v", got, want)
+	}
+}
+
+func TestParseSummary(t *testing.T) {
+	output := bytes.NewBufferString(`//foo:a_test                                                             PASSED in 0.4s
+//foo:f_test                                                     FAILED TO BUILD
+//foo:g_test                                                          NO STATUS
+`)
+
+	want := []Result{
+		{Target: "//foo:a_test", Status: Passed},
+		{Target: "//foo:f_test", Status: "FAILED TO BUILD"},
+		{Target: "//foo:g_test", Status: "NO STATUS"},
+	}
+	got := ParseSummary(output)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("ParseSummary()\nGot:  %v\nWant: %v", got, want)
+	}
+}
+
+func TestEventResults(t *testing.T) {
+	results := EventResults(&bep.Result{Targets: []bep.Target{
+		{Label: "//foo:bin", Success: true},
+		{Label: "//foo:broken_test", Success: false, TestStatus: "FAILED_TO_BUILD"},
+		{Label: "//foo:cached_test", Success: true, TestStatus: "PASSED", TestCached: true},
+		{Label: "//foo:flaky_test", Success: true, TestStatus: "FLAKY"},
+	}})
+	want := []Result{
+		{Target: "//foo:cached_test", Status: Passed, Cached: true},
+		{Target: "//foo:flaky_test", Status: Flaky},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("EventResults() = %+v, want %+v", results, want)
+	}
+	if results := EventResults(nil); results != nil {
+		t.Errorf("EventResults(nil) = %+v, want nil", results)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	deps := map[string]map[string]struct{}{
+		"//foo:a_test": {"/ws/foo/a.go": {}},
+		"//foo:b_test": {"/ws/foo/b.go": {}},
+	}
+	h := New(func(target string) (map[string]struct{}, error) {
+		if files, ok := deps[target]; ok {
+			return files, nil
+		}
+		return nil, errors.New("unknown target")
+	})
+
+	h.record([]Result{
+		{Target: "//foo:a_test", Status: Passed},
+		{Target: "//foo:b_test", Status: Passed},
+	})
+
+	// a_test broke because of a change to one of its sources, b_test broke on
+	// its own.
+	h.ChangeDetected(nil, "source", "/ws/foo/a.go")
+	h.record([]Result{
+		{Target: "//foo:a_test", Status: Failed},
+		{Target: "//foo:b_test", Status: Failed},
+	})
+	if _, ok := h.flaky["//foo:a_test"]; ok {
+		t.Errorf("//foo:a_test broke because of a related change and shouldn't be flaky")
+	}
+	if h.flaky["//foo:b_test"] != 1 {
+		t.Errorf("//foo:b_test should have been flagged as flaky once, was %d", h.flaky["//foo:b_test"])
+	}
+
+	// b_test fixes itself, which is also flaky.
+	h.changes = nil
+	h.ChangeDetected(nil, "source", "/ws/foo/a.go")
+	h.record([]Result{
+		{Target: "//foo:a_test", Status: Passed},
+		{Target: "//foo:b_test", Status: Passed},
+	})
+	if h.flaky["//foo:b_test"] != 2 {
+		t.Errorf("//foo:b_test should have been flagged as flaky twice, was %d", h.flaky["//foo:b_test"])
+	}
+	if got, want := h.summary(), "//foo:b_test (x2)"; got != want {
+		t.Errorf("summary() = %q, want %q", got, want)
+	}
+}
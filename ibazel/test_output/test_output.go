@@ -0,0 +1,128 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package test_output decides how the output of `bazel test` is shown. Unless
+// the output is streamed, all of it is held back and printed condensed once
+// the tests are done: the logs of failing tests and Bazel's messages stay,
+// while Bazel's progress is dropped and the lines of passing tests are
+// collapsed into one.
+package test_output
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/line_scanner"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/progress"
+	"github.com/bazelbuild/bazel-watcher/ibazel/test_history"
+)
+
+var mode = flag.String(
+	"test_output_mode",
+	"",
+	"How the output of tests is shown: \"streamed\" prints it as the tests run, \"errors\" prints the logs of failing tests and \"summary\" one line per failing test. Both collapse passing tests into one line. Empty leaves it to Bazel's --test_output")
+
+const (
+	Streamed = "streamed"
+	Errors   = "errors"
+	Summary  = "summary"
+)
+
+// Written to at the time of the call, so that redirections of os.Stdout are
+// honored.
+var stdout = func() io.Writer { return os.Stdout }
+
+// Matches a line of the test summary, e.g.
+// "//foo:bar_test   (cached) PASSED in 0.1s".
+var summaryLineRegex = regexp.MustCompile(`^@?[\w\-.]*//\S*\s+(\(cached\)\s+)?(PASSED|FAILED TO BUILD|FAILED|FLAKY|TIMEOUT|NO STATUS|INCOMPLETE|SKIPPED)\b`)
+
+// BazelArgs returns the flags that make `bazel test` produce the output for
+// the mode.
+func BazelArgs() []string {
+	switch *mode {
+	case Streamed, Errors, Summary:
+		return []string{"--test_output=" + *mode}
+	}
+	return nil
+}
+
+// Live reports whether the output of `bazel test` is printed as it comes.
+// Otherwise it has to be printed with Print once the tests are done.
+func Live() bool {
+	return *mode != Errors && *mode != Summary
+}
+
+// Print prints the held back output of `bazel test`, both its stdout and its
+// stderr. The failing tests are taken from the build events of the command,
+// when it has them, and else from the test summary in its output.
+func Print(output *bytes.Buffer, events *bep.Result) {
+	if Live() || output == nil {
+		return
+	}
+	w := stdout()
+
+	passed := 0
+	inTestLog := false
+	scanner := line_scanner.New(bytes.NewReader(output.Bytes()))
+	for scanner.Scan() {
+		// Printed as is, in whatever encoding the test wrote it.
+		line := string(scanner.Bytes())
+		clean := strings.TrimSpace(log.StripColor(line))
+		switch {
+		case strings.HasPrefix(clean, "==================== Test output for "):
+			inTestLog = true
+		case inTestLog && strings.HasPrefix(clean, "================================================================================"):
+			inTestLog = false
+			fmt.Fprintln(w, line)
+			continue
+		}
+		if inTestLog {
+			fmt.Fprintln(w, line)
+			continue
+		}
+
+		if m := summaryLineRegex.FindStringSubmatch(clean); m != nil && m[2] == "PASSED" {
+			passed++
+		} else if _, ok := progress.Status(clean); !ok && clean != "" {
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	if passed == 1 {
+		fmt.Fprintln(w, "1 test passed")
+	} else if passed > 1 {
+		fmt.Fprintf(w, "%d tests passed\n", passed)
+	}
+
+	results := test_history.EventResults(events)
+	if results == nil {
+		results = test_history.ParseResults(output)
+	}
+	var failed []string
+	for _, r := range results {
+		if r.Status != test_history.Passed {
+			failed = append(failed, fmt.Sprintf("%s %s", r.Status, r.Target))
+		}
+	}
+	if len(failed) > 0 {
+		log.Banner(append([]string{"Failing tests:"}, failed...)...)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test_output
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/bep"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+const testOutput = `==================== Test output for //foo:b_test:
+--- FAIL: TestB (0.00s)
+    b_test.go:12: got 1, want 2
+================================================================================
+//foo:a_test                                                    (cached) PASSED in 0.1s
+//foo:c_test                                                             PASSED in 0.3s
+//foo:b_test                                                             FAILED in 0.2s
+  /home/me/.cache/bazel/execroot/ws/bazel-out/k8-fastbuild/testlogs/foo/b_test/test.log
+
+Executed 2 out of 3 tests: 2 tests pass and 1 fails locally.
+Analyzing: 3 targets (0 packages loaded, 0 targets configured)
+INFO: Analyzed 3 targets (0 packages loaded, 0 targets configured).
+INFO: Found 3 test targets...
+[12 / 15] Testing //foo:b_test; 0s linux-sandbox
+INFO: Elapsed time: 1.2s, Critical Path: 1.0s
+`
+
+func TestPrint(t *testing.T) {
+	defer func(old string) { *mode = old }(*mode)
+	*mode = Errors
+
+	out := &bytes.Buffer{}
+	defer func(old func() io.Writer) { stdout = old }(stdout)
+	stdout = func() io.Writer { return out }
+	logs := &bytes.Buffer{}
+	log.SetWriter(logs)
+	defer log.SetWriter(os.Stderr)
+
+	Print(bytes.NewBufferString(testOutput), nil)
+
+	expected := `==================== Test output for //foo:b_test:
+--- FAIL: TestB (0.00s)
+    b_test.go:12: got 1, want 2
+================================================================================
+//foo:b_test                                                             FAILED in 0.2s
+  /home/me/.cache/bazel/execroot/ws/bazel-out/k8-fastbuild/testlogs/foo/b_test/test.log
+Executed 2 out of 3 tests: 2 tests pass and 1 fails locally.
+INFO: Analyzed 3 targets (0 packages loaded, 0 targets configured).
+INFO: Found 3 test targets...
+INFO: Elapsed time: 1.2s, Critical Path: 1.0s
+2 tests passed
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output.\nGot:\n%s\nWant:\n%s", out.String(), expected)
+	}
+	if !strings.Contains(logs.String(), "FAILED //foo:b_test") {
+		t.Errorf("The failing test wasn't shown in a banner: %q", logs.String())
+	}
+
+	// The build events win over the test summary.
+	logs.Reset()
+	Print(bytes.NewBufferString(testOutput), &bep.Result{Targets: []bep.Target{
+		{Label: "//foo:b_test", Success: true, TestStatus: "TIMEOUT"},
+	}})
+	if !strings.Contains(logs.String(), "TIMEOUT //foo:b_test") {
+		t.Errorf("The failing test of the build events wasn't shown in a banner: %q", logs.String())
+	}
+}
+
+func TestLiveAndBazelArgs(t *testing.T) {
+	defer func(old string) { *mode = old }(*mode)
+
+	for _, c := range []struct {
+		mode string
+		live bool
+		args []string
+	}{
+		{"", true, nil},
+		{Streamed, true, []string{"--test_output=streamed"}},
+		{Errors, false, []string{"--test_output=errors"}},
+		{Summary, false, []string{"--test_output=summary"}},
+	} {
+		*mode = c.mode
+		if Live() != c.live {
+			t.Errorf("Live() with %q = %v, want %v", c.mode, Live(), c.live)
+		}
+		if !reflect.DeepEqual(BazelArgs(), c.args) {
+			t.Errorf("BazelArgs() with %q = %v, want %v", c.mode, BazelArgs(), c.args)
+		}
+	}
+}
+
+func TestPrintWhenLive(t *testing.T) {
+	out := &bytes.Buffer{}
+	stdout = func() io.Writer { return out }
+
+	Print(bytes.NewBufferString(testOutput), nil)
+	if out.Len() != 0 {
+		t.Errorf("Printed %q although the output was streamed", out.String())
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/experiments"
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+const (
+	autoBackend     = "auto"
+	fsnotifyBackend = "fsnotify"
+	pollBackend     = "poll"
+	hybridBackend   = "hybrid"
+	watchmanBackend = "watchman"
+)
+
+const (
+	defaultPollInterval       = 500 * time.Millisecond
+	defaultHybridPollInterval = 2 * time.Second
+)
+
+var (
+	watchBackendFlag = flag.String("watch_backend", autoBackend, "How to watch for changes: fsnotify, poll for filesystems that don't deliver change notifications like NFS, SMB and Docker bind mounts, hybrid for both, watchman to subscribe to a running Watchman daemon, or auto to try them in the workspace and pick the fastest one that can be trusted on its filesystem")
+	pollIntervalFlag = flag.Duration("poll_interval", 0, "How often the poll and hybrid --watch_backend look for changes. 0 means 500ms for poll and 2s for hybrid")
+
+	watchmanExperiment = experiments.Register("watchman", "Subscribe to Watchman instead of using fsnotify when --watch_backend=auto")
+)
+
+// pollInterval returns how often the backend polls for changes.
+func pollInterval(backend string) time.Duration {
+	if *pollIntervalFlag > 0 {
+		return *pollIntervalFlag
+	}
+	if backend == hybridBackend {
+		return defaultHybridPollInterval
+	}
+	return defaultPollInterval
+}
+
+// Allows tests to pretend to be running on a different filesystem.
+var filesystemType = detectFilesystemType
+
+// chooseWatchBackend picks the watcher implementation that is able to see
+// changes on the given filesystem type, and explains why.
+func chooseWatchBackend(fstype string) (backend string, reason string) {
+	switch fstype {
+	case "9p", "virtiofs", "fuse.grpcfuse", "grpcfuse", "osxfs", "fakeowner", "vboxsf", "prl_fs", "vmhgfs", "fuse.vmhgfs-fuse":
+		return pollBackend, fmt.Sprintf("the workspace is on a %s filesystem shared from the host, which doesn't deliver change notifications", fstype)
+	case "nfs", "nfs4", "cifs", "smb", "smb2", "smbfs", "sshfs", "fuse.sshfs":
+		return pollBackend, fmt.Sprintf("the workspace is on a %s network filesystem, which doesn't deliver change notifications", fstype)
+	case "overlay", "aufs":
+		return hybridBackend, fmt.Sprintf("the workspace is on a %s filesystem, changes made outside of the container may not be delivered", fstype)
+	default:
+		return fsnotifyBackend, ""
+	}
+}
+
+// watchBackend returns the watcher implementation of --watch_backend and why
+// it was picked. With auto, it detects the filesystem that the workspace lives
+// on, picks the implementation that can be trusted on it, only probes the
+// others if fsnotify can't watch the workspace, see probeWatchBackends, and
+// reports which one will be used.
+func watchBackend(workspacePath string) (backend string, reason string) {
+	switch *watchBackendFlag {
+	case fsnotifyBackend, pollBackend, hybridBackend, watchmanBackend:
+		return *watchBackendFlag, "it was picked with --watch_backend"
+	case autoBackend:
+	default:
+		log.Errorf("Unknown --watch_backend %q, picking one for the workspace", *watchBackendFlag)
+	}
+
+	fstype, err := filesystemType(workspacePath)
+	if err != nil {
+		fstype = ""
+	}
+
+	backend, reason = chooseWatchBackend(fstype)
+	if backend == pollBackend {
+		// Changes made elsewhere never arrive, however fast the ones made
+		// here do.
+		log.Logf("Polling for changes every %v because %s", pollInterval(backend), reason)
+		return backend, reason
+	}
+
+	if watchmanExperiment.Enabled() && watchmanAvailable() {
+		// newWatcher falls back to fsnotify if subscribing fails.
+		backend, reason = watchmanBackend, "the watchman experiment is enabled"
+	} else if workspacePath != "" {
+		if err := fsnotifyWorks(workspacePath); err != nil {
+			probes := probeWatchBackends(workspacePath)
+			backend = bestWatchProbe(probes).backend
+			reason = fmt.Sprintf("fsnotify can't watch the workspace: %v; %s", err, describeWatchProbes(probes))
+		}
+	}
+
+	switch backend {
+	case pollBackend:
+		log.Logf("Polling for changes every %v because %s", pollInterval(backend), reason)
+	case hybridBackend:
+		log.Logf("Using fsnotify and polling for changes every %v because %s", pollInterval(backend), reason)
+	default:
+		if reason != "" {
+			log.Logf("Watching for changes with %s because %s", backend, reason)
+		}
+	}
+	return backend, reason
+}
+
+func newWatcher(backend string, workspacePath string) (fSNotifyWatcher, error) {
+	switch backend {
+	case watchmanBackend:
+		w, err := newWatchmanWatcher(workspacePath)
+		if err != nil {
+			log.Errorf("Error subscribing to Watchman, using fsnotify instead: %v", err)
+			return wrapWatcher(fsnotify.NewWatcher())
+		}
+		return w, nil
+	case pollBackend:
+		return newPollWatcher(pollInterval(backend)), nil
+	case hybridBackend:
+		w, err := wrapWatcher(fsnotify.NewWatcher())
+		if err != nil {
+			return nil, err
+		}
+		return newHybridWatcher(w, newPollWatcher(pollInterval(backend))), nil
+	default:
+		return wrapWatcher(fsnotify.NewWatcher())
+	}
+}
+
+func isUnder(path, dir string) bool {
+	if dir == "/" || path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
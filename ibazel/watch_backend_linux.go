@@ -0,0 +1,84 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// detectFilesystemType returns the type of the filesystem that path is
+// mounted on, as reported by /proc/self/mounts.
+func detectFilesystemType(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fstype := ""
+	longest := -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mountPoint := unescapeMountPoint(fields[1])
+		if !isUnder(path, mountPoint) {
+			continue
+		}
+		// Later mounts shadow earlier ones on the same mount point, so use >=.
+		if len(mountPoint) >= longest {
+			longest = len(mountPoint)
+			fstype = fields[2]
+		}
+	}
+	return fstype, scanner.Err()
+}
+
+// unescapeMountPoint undoes the octal escaping that the kernel applies to
+// whitespace and backslashes in /proc/self/mounts.
+func unescapeMountPoint(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
+
+// inotifyWatchLimit returns how many inotify watches a user may have, or 0 if
+// it is unknown.
+func inotifyWatchLimit() int {
+	b, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return limit
+}
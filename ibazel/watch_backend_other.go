@@ -0,0 +1,28 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package main
+
+// detectFilesystemType is only implemented on Linux, which is where ibazel
+// runs inside of containers and VMs.
+func detectFilesystemType(path string) (string, error) {
+	return "", nil
+}
+
+// inotifyWatchLimit is only known on Linux, the only platform with inotify.
+func inotifyWatchLimit() int {
+	return 0
+}
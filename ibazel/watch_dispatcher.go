@@ -0,0 +1,258 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDispatcher shares a single fSNotifyWatcher between the build file and
+// source file watchers. BUILD files and sources usually live in the same
+// directories, so keeping one OS watch per directory instead of one per
+// watcher roughly halves the number of watches iBazel needs.
+//
+// Every event is delivered to each view that watches the file or its
+// directory, which is often both of them. The consumers already ignore changes
+// to files they aren't interested in. Only when neither view watches the path
+// does the name of the file pick one, see isGraphFile.
+type watchDispatcher struct {
+	w fSNotifyWatcher
+
+	lock sync.Mutex // guards refs
+	// refs counts how many views are watching each path.
+	refs map[string]int
+
+	graph  *watchView
+	source *watchView
+	// Whether loop keeps forwarding events, see --watchdog_timeout.
+	alive *forwarder
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newWatchDispatcher(w fSNotifyWatcher) *watchDispatcher {
+	d := &watchDispatcher{
+		w:     w,
+		refs:  map[string]int{},
+		alive: newForwarder("file watcher dispatcher"),
+	}
+	d.graph = newWatchView(d)
+	d.source = newWatchView(d)
+	go d.loop()
+	return d
+}
+
+// Graph returns the watcher that receives changes to BUILD, WORKSPACE and
+// .bzl files.
+func (d *watchDispatcher) Graph() fSNotifyWatcher { return d.graph }
+
+// Source returns the watcher that receives changes to every other file.
+func (d *watchDispatcher) Source() fSNotifyWatcher { return d.source }
+
+func (d *watchDispatcher) loop() {
+	defer d.alive.recover()
+	events, errors := d.w.Events(), d.w.Errors()
+	for events != nil || errors != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			d.alive.sending()
+			for _, v := range d.views(e.Name) {
+				select {
+				case v.events <- e:
+				case <-d.alive.stopped():
+					return
+				}
+			}
+			d.alive.sent()
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			// Nothing is required to read errors, don't let them block events.
+			for _, v := range []*watchView{d.graph, d.source} {
+				select {
+				case v.errors <- err:
+				default:
+				}
+			}
+		}
+	}
+	close(d.graph.events)
+	close(d.graph.errors)
+	close(d.source.events)
+	close(d.source.errors)
+}
+
+func (d *watchDispatcher) add(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.refs[name] == 0 {
+		if err := d.w.Add(name); err != nil {
+			return err
+		}
+	}
+	d.refs[name]++
+	return nil
+}
+
+func (d *watchDispatcher) remove(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.refs[name]--
+	if d.refs[name] > 0 {
+		return nil
+	}
+	delete(d.refs, name)
+	return d.w.Remove(name)
+}
+
+// rewatch watches name again on the shared watcher, however many views watch
+// it, so that fsnotify drops the watches it keeps of files that were replaced.
+func (d *watchDispatcher) rewatch(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.w.Remove(name)
+	return d.w.Add(name)
+}
+
+func (d *watchDispatcher) close() error {
+	d.closeOnce.Do(func() {
+		d.closeErr = d.w.Close()
+	})
+	return d.closeErr
+}
+
+// views returns the views to deliver an event about name to.
+func (d *watchDispatcher) views(name string) []*watchView {
+	views := []*watchView{}
+	for _, v := range []*watchView{d.graph, d.source} {
+		if v.watching(name) {
+			views = append(views, v)
+		}
+	}
+	if len(views) > 0 {
+		return views
+	}
+	if isGraphFile(name) {
+		return []*watchView{d.graph}
+	}
+	return []*watchView{d.source}
+}
+
+// isGraphFile returns whether changes to the file can change the build graph.
+func isGraphFile(name string) bool {
+	switch filepath.Base(name) {
+	case "BUILD", "BUILD.bazel":
+		return true
+	}
+	for _, f := range workspaceFiles {
+		if filepath.Base(name) == f {
+			return true
+		}
+	}
+	return strings.HasSuffix(name, ".bzl")
+}
+
+// watchViewBuffer is how many events a view holds before delivering to it
+// blocks. Most events go to both views, so a consumer that is busy for a moment
+// must not hold up the other one.
+const watchViewBuffer = 256
+
+// watchView is one of the two watchers handed out by a watchDispatcher.
+type watchView struct {
+	d *watchDispatcher
+
+	events chan fsnotify.Event
+	errors chan error
+
+	lock    sync.Mutex // guards watches
+	watches map[string]struct{}
+}
+
+var _ fSNotifyWatcher = &watchView{}
+
+func newWatchView(d *watchDispatcher) *watchView {
+	return &watchView{
+		d:       d,
+		events:  make(chan fsnotify.Event, watchViewBuffer),
+		errors:  make(chan error, 1),
+		watches: map[string]struct{}{},
+	}
+}
+
+func (v *watchView) Add(name string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if _, ok := v.watches[name]; ok {
+		return nil
+	}
+	if err := v.d.add(name); err != nil {
+		return err
+	}
+	v.watches[name] = struct{}{}
+	return nil
+}
+
+// watching returns whether the view watches name or its directory.
+func (v *watchView) watching(name string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	dir, _ := filepath.Split(name)
+	for _, path := range []string{name, dir, filepath.Clean(dir)} {
+		if _, ok := v.watches[path]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *watchView) Remove(name string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if _, ok := v.watches[name]; !ok {
+		return fmt.Errorf("can't remove non-existent watch for: %s", name)
+	}
+	delete(v.watches, name)
+	return v.d.remove(name)
+}
+
+// Rewatch watches name again, see rewatcher. It watches name if the view
+// doesn't yet.
+func (v *watchView) Rewatch(name string) error {
+	v.lock.Lock()
+	_, ok := v.watches[name]
+	v.lock.Unlock()
+	if !ok {
+		return v.Add(name)
+	}
+	return v.d.rewatch(name)
+}
+
+// Close closes the shared watcher, which closes both views.
+func (v *watchView) Close() error                { return v.d.close() }
+func (v *watchView) Events() chan fsnotify.Event { return v.events }
+func (v *watchView) Errors() chan error          { return v.errors }
+func (v *watchView) Watcher() *fsnotify.Watcher  { return v.d.w.Watcher() }
@@ -0,0 +1,134 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type countingWatcher struct {
+	fakeFSNotifyWatcher
+	watches map[string]int
+	// Called with every removed path, if set.
+	onRemove func(name string)
+}
+
+func (w *countingWatcher) Add(name string) error {
+	w.watches[name]++
+	return nil
+}
+
+func (w *countingWatcher) Remove(name string) error {
+	if w.onRemove != nil {
+		w.onRemove(name)
+	}
+	w.watches[name]--
+	if w.watches[name] == 0 {
+		delete(w.watches, name)
+	}
+	return nil
+}
+
+func (w *countingWatcher) Close() error {
+	close(w.EventChan)
+	close(w.ErrorChan)
+	return nil
+}
+
+func TestWatchDispatcher(t *testing.T) {
+	w := &countingWatcher{
+		fakeFSNotifyWatcher: fakeFSNotifyWatcher{
+			EventChan: make(chan fsnotify.Event),
+			ErrorChan: make(chan error),
+		},
+		watches: map[string]int{},
+	}
+	d := newWatchDispatcher(w)
+	graph, source := d.Graph(), d.Source()
+
+	graph.Add("/ws/a/")
+	source.Add("/ws/a/")
+	source.Add("/ws/b/")
+	graph.Add("/ws/third_party/")
+	if expected := map[string]int{"/ws/a/": 1, "/ws/b/": 1, "/ws/third_party/": 1}; !reflect.DeepEqual(w.watches, expected) {
+		t.Errorf("Unexpected watches.\nGot:  %v\nWant: %v", w.watches, expected)
+	}
+
+	for _, c := range []struct {
+		name     string
+		watchers []fSNotifyWatcher
+	}{
+		// Both views watch the directory.
+		{"/ws/a/BUILD", []fSNotifyWatcher{graph, source}},
+		{"/ws/a/main.go", []fSNotifyWatcher{graph, source}},
+		// Only one view watches the directory, whatever the name of the file.
+		{"/ws/b/defs.bzl", []fSNotifyWatcher{source}},
+		{"/ws/third_party/foo.BUILD", []fSNotifyWatcher{graph}},
+		// Neither does, the name decides.
+		{"/ws/c/BUILD.bazel", []fSNotifyWatcher{graph}},
+		{"/ws/c/main.go", []fSNotifyWatcher{source}},
+	} {
+		e := fsnotify.Event{Name: c.name, Op: fsnotify.Write}
+		w.EventChan <- e
+		for _, watcher := range c.watchers {
+			expectEvent(t, watcher, e)
+		}
+	}
+	// A directory both views watch is watched again on the shared watcher.
+	removed := 0
+	w.onRemove = func(name string) {
+		if name == "/ws/a/" {
+			removed++
+		}
+	}
+	if err := d.source.Rewatch("/ws/a/"); err != nil {
+		t.Errorf("Rewatch: %v", err)
+	}
+	w.onRemove = nil
+	assertEqual(t, 1, removed, "Removes of the rewatched directory")
+	assertEqual(t, 1, w.watches["/ws/a/"], "Watches of the rewatched directory")
+
+	graph.Remove("/ws/third_party/")
+
+	// The source view keeps getting events while nobody reads the graph view.
+	first := fsnotify.Event{Name: "/ws/a/main.go", Op: fsnotify.Write}
+	second := fsnotify.Event{Name: "/ws/a/main.go", Op: fsnotify.Chmod}
+	w.EventChan <- first
+	w.EventChan <- second
+	expectEvent(t, source, first)
+	expectEvent(t, source, second)
+	expectEvent(t, graph, first)
+	expectEvent(t, graph, second)
+
+	source.Remove("/ws/a/")
+	if expected := map[string]int{"/ws/a/": 1, "/ws/b/": 1}; !reflect.DeepEqual(w.watches, expected) {
+		t.Errorf("Directory still watched by the graph view was removed: %v", w.watches)
+	}
+	graph.Remove("/ws/a/")
+	if expected := map[string]int{"/ws/b/": 1}; !reflect.DeepEqual(w.watches, expected) {
+		t.Errorf("Unexpected watches.\nGot:  %v\nWant: %v", w.watches, expected)
+	}
+	if err := graph.Remove("/ws/b/"); err == nil {
+		t.Errorf("Removing a directory only watched by the other view should fail")
+	}
+
+	graph.Close()
+	source.Close()
+	<-graph.Events()
+	<-source.Events()
+}
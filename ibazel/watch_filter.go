@@ -0,0 +1,175 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// packagePattern is a directory given like a package, e.g. //pkg, or with its
+// subdirectories, e.g. //pkg/... or //....
+type packagePattern struct {
+	// The directory relative to the workspace, "" for its root.
+	dir string
+	// Whether the pattern also matches the subdirectories of dir.
+	recursive bool
+}
+
+// parsePackagePattern parses //pkg, //pkg/... or //....
+func parsePackagePattern(s string) (packagePattern, bool) {
+	if !strings.HasPrefix(s, "//") {
+		return packagePattern{}, false
+	}
+	p := packagePattern{dir: strings.TrimPrefix(s, "//")}
+	if p.dir == "..." || strings.HasSuffix(p.dir, "/...") {
+		p.recursive = true
+		p.dir = strings.TrimSuffix(strings.TrimSuffix(p.dir, "..."), "/")
+	}
+	return p, true
+}
+
+func (p packagePattern) String() string {
+	if p.recursive {
+		return strings.TrimSuffix("//"+p.dir, "/") + "/..."
+	}
+	return "//" + p.dir
+}
+
+// matches returns whether the pattern matches dir, a directory relative to the
+// workspace.
+func (p packagePattern) matches(dir string) bool {
+	if dir == p.dir {
+		return true
+	}
+	return p.recursive && (p.dir == "" || strings.HasPrefix(dir, p.dir+"/"))
+}
+
+// workspaceRel returns path relative to the workspace, "" for the workspace
+// itself, and false if it is outside of it.
+func workspaceRel(workspacePath string, path string) (string, bool) {
+	rel, err := filepath.Rel(workspacePath, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// watchFilter limits the source files under a directory that trigger a
+// rebuild to the ones whose names match one of the patterns.
+type watchFilter struct {
+	packagePattern
+	patterns []string
+}
+
+// watchFilterFlag holds the filters given with --watch_filter.
+type watchFilterFlag []watchFilter
+
+var watchFilters = watchFilterFlag{}
+
+func init() {
+	flag.Var(&watchFilters, "watch_filter", "Only rebuild on changes to the files of a directory matching the patterns, e.g. `//pkg/...=*.go,*.proto`. Can be repeated, the most specific directory wins")
+}
+
+func (f *watchFilterFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	filters := []string{}
+	for _, filter := range *f {
+		filters = append(filters, fmt.Sprintf("%s=%s", filter.packagePattern, strings.Join(filter.patterns, ",")))
+	}
+	return strings.Join(filters, " ")
+}
+
+func (f *watchFilterFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("%q isn't of the form //pkg/...=*.go,*.proto", value)
+	}
+	pkg, ok := parsePackagePattern(parts[0])
+	if !ok {
+		return fmt.Errorf("%q isn't of the form //pkg/...=*.go,*.proto", value)
+	}
+	filter := watchFilter{packagePattern: pkg}
+	for _, pattern := range strings.Split(parts[1], ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%q isn't a valid pattern: %v", pattern, err)
+		}
+		filter.patterns = append(filter.patterns, pattern)
+	}
+	if len(filter.patterns) == 0 {
+		return fmt.Errorf("the filter for %s has no patterns", parts[0])
+	}
+	*f = append(*f, filter)
+	return nil
+}
+
+// accepts returns whether the file with the given name matches one of the
+// patterns of the filter.
+func (w watchFilter) accepts(name string) bool {
+	for _, pattern := range w.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// passesWatchFilter returns whether a change to the file at path may trigger a
+// rebuild under the --watch_filter of its directory. Directories that mix
+// sources with generated files, e.g. coverage output or editor caches, then
+// only trigger on the files that feed the build. When several filters apply,
+// the one of the deepest directory wins, and files outside the workspace or
+// without a filter always pass. Files matching an --ignore pattern, or ignored
+// by the ignore files of the workspace, never pass.
+func (i *IBazel) passesWatchFilter(path string) bool {
+	if len(watchFilters) == 0 && len(ignorePatterns) == 0 && i.ignores == nil {
+		return true
+	}
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil {
+		return true
+	}
+	dir, ok := workspaceRel(workspacePath, filepath.Dir(path))
+	if !ok {
+		return true
+	}
+	name := filepath.Base(path)
+	rel := strings.TrimPrefix(dir+"/"+name, "/")
+	if ignorePatterns.ignores(rel) {
+		return false
+	}
+	if _, ok := i.ignores.Ignores(rel); ok {
+		return false
+	}
+
+	var filter *watchFilter
+	for n := range watchFilters {
+		f := &watchFilters[n]
+		if f.matches(dir) && (filter == nil || len(f.dir) > len(filter.dir) || (f.dir == filter.dir && !f.recursive)) {
+			filter = f
+		}
+	}
+	return filter == nil || filter.accepts(name)
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchFilterFlag(t *testing.T) {
+	f := watchFilterFlag{}
+	for _, value := range []string{"//pkg/...=*.go, *.proto", "//pkg/gen=*.pb.go", "//...=*"} {
+		if err := f.Set(value); err != nil {
+			t.Errorf("Set(%q): %v", value, err)
+		}
+	}
+	assertEqual(t, "//pkg/...=*.go,*.proto //pkg/gen=*.pb.go //...=*", f.String(), "Filters")
+
+	for _, value := range []string{"*.go", "pkg=*.go", "//pkg=", "//pkg=[", "//pkg=,"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) should have failed", value)
+		}
+	}
+}
+
+func TestIBazelPassesWatchFilter(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	workspace := "/ws"
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+
+	oldWatchFilters := watchFilters
+	watchFilters = watchFilterFlag{}
+	defer func() { watchFilters = oldWatchFilters }()
+	assertEqual(t, true, i.passesWatchFilter(filepath.Join(workspace, "pkg", "cover.out")), "Without filters")
+
+	for _, value := range []string{"//pkg/...=*.go,*.proto", "//pkg/gen=*.pb.go"} {
+		if err := watchFilters.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		path string
+		want bool
+	}{
+		{"pkg/main.go", true},
+		{"pkg/api/api.proto", true},
+		{"pkg/cover.out", false},
+		{"pkg/api/.cache", false},
+		{"pkg/gen/api.pb.go", true},
+		{"pkg/gen/util.go", false},
+		{"pkgs/cover.out", true},
+		{"cover.out", true},
+	} {
+		assertEqual(t, c.want, i.passesWatchFilter(filepath.Join(workspace, c.path)), c.path)
+	}
+	assertEqual(t, true, i.passesWatchFilter("/elsewhere/pkg/cover.out"), "A file outside the workspace")
+}
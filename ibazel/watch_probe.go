@@ -0,0 +1,195 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+// How long a watch backend may take to report the test change of a probe.
+var watchProbeTimeout = 2 * time.Second
+
+// watchProbe is how a watch backend did in the workspace.
+type watchProbe struct {
+	backend string
+	// How long it took to report a change.
+	latency time.Duration
+	// Why it can't be used, e.g. because it didn't report the change.
+	problem string
+}
+
+// watchmanAvailable reports whether there is a Watchman to subscribe to.
+var watchmanAvailable = func() bool {
+	if os.Getenv("WATCHMAN_SOCK") != "" {
+		return true
+	}
+	_, err := exec.LookPath("watchman")
+	return err == nil
+}
+
+// fsnotifyWorks returns why fsnotify can't watch the workspace, e.g. because
+// the inotify instances ran out, without creating anything in it.
+var fsnotifyWorks = func(workspacePath string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Add(workspacePath)
+}
+
+// probeWatchBackends tries the backends that can take the place of fsnotify
+// once it failed, from the most to the least preferred: Watchman, in a
+// temporary directory of the workspace if it can be written to, and polling,
+// which always works and isn't probed.
+func probeWatchBackends(workspacePath string) []watchProbe {
+	var probes []watchProbe
+	if watchmanAvailable() && workspacePath != "" && temp_dir.Writable(workspacePath) {
+		p := watchProbe{backend: watchmanBackend}
+		var err error
+		if p.latency, err = probeWatchBackend(watchmanBackend, workspacePath); err != nil {
+			p.problem = err.Error()
+		}
+		probes = append(probes, p)
+	}
+	return append(probes, watchProbe{backend: pollBackend, latency: pollInterval(pollBackend)})
+}
+
+// bestWatchProbe returns the most preferred backend that can be used.
+func bestWatchProbe(probes []watchProbe) watchProbe {
+	for _, p := range probes {
+		if p.problem == "" {
+			return p
+		}
+	}
+	return watchProbe{backend: pollBackend, latency: pollInterval(pollBackend)}
+}
+
+// Allows tests to leave the workspace they run in alone.
+var probeWatchBackend = watchBackendLatency
+
+// watchBackendLatency watches a new directory in the workspace with backend,
+// writes a file into it and returns how long the backend took to report it.
+func watchBackendLatency(backend, workspacePath string) (time.Duration, error) {
+	dir, err := ioutil.TempDir(workspacePath, ".ibazel-probe-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	var w fSNotifyWatcher
+	if backend == watchmanBackend {
+		// newWatcher falls back to fsnotify.
+		w, err = newWatchmanWatcher(workspacePath)
+	} else {
+		w, err = newWatcher(backend, workspacePath)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		return 0, err
+	}
+
+	file := filepath.Join(dir, "probe")
+	start := time.Now()
+	if err := ioutil.WriteFile(file, []byte("probe"), 0644); err != nil {
+		return 0, err
+	}
+	timeout := time.After(watchProbeTimeout)
+	for {
+		select {
+		case e, ok := <-w.Events():
+			if !ok {
+				return 0, errors.New("stopped before reporting a test change")
+			}
+			if e.Name == file {
+				return time.Since(start), nil
+			}
+		case err, ok := <-w.Errors():
+			if ok {
+				return 0, err
+			}
+		case <-timeout:
+			return 0, fmt.Errorf("didn't report a test change within %v", watchProbeTimeout)
+		}
+	}
+}
+
+// describeWatchProbes explains how the backends did, e.g. "fsnotify saw a
+// test change after 1ms, poll looks for changes every 500ms".
+func describeWatchProbes(probes []watchProbe) string {
+	var parts []string
+	for _, p := range probes {
+		var part string
+		switch {
+		case p.problem != "":
+			part = fmt.Sprintf("%s %s", p.backend, p.problem)
+		case p.backend == pollBackend:
+			part = fmt.Sprintf("%s looks for changes every %v", p.backend, p.latency)
+		case p.latency < time.Millisecond:
+			part = fmt.Sprintf("%s saw a test change in under 1ms", p.backend)
+		default:
+			part = fmt.Sprintf("%s saw a test change after %v", p.backend, p.latency.Round(time.Millisecond))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Allows tests to pretend to have another inotify limit.
+var watchLimit = inotifyWatchLimit
+
+// checkWatchLimit warns once, after the first query, when the directories
+// watched with inotify reach fs.inotify.max_user_watches, since changes in
+// the ones past the limit are missed. Only the directories of the files the
+// targets depend on count, not the whole workspace. The backend is left
+// alone: polling a workspace that big would be slower still.
+func (i *IBazel) checkWatchLimit() {
+	if i.watchLimitChecked {
+		return
+	}
+	i.watchLimitChecked = true
+	if i.watchBackend != fsnotifyBackend && i.watchBackend != hybridBackend {
+		return
+	}
+	limit := watchLimit()
+	if limit <= 0 {
+		return
+	}
+	dirs := map[string]struct{}{}
+	for _, files := range i.filesWatched {
+		for file := range files {
+			dir, _ := filepath.Split(file)
+			dirs[dir] = struct{}{}
+		}
+	}
+	if len(dirs) >= limit {
+		log.Errorf("iBazel watches %d directories, which uses up the %d inotify watches fs.inotify.max_user_watches allows, so changes to some of them will be missed. Raise the limit, or use --watch_backend=watchman.", len(dirs), limit)
+	}
+}
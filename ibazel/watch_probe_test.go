@@ -0,0 +1,134 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+func TestWatchBackendLatency(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	if _, err := watchBackendLatency(fsnotifyBackend, workspace); err != nil {
+		t.Errorf("fsnotify didn't see the test change: %v", err)
+	}
+	files, _ := ioutil.ReadDir(workspace)
+	assertEqual(t, 0, len(files), "Files left in the workspace")
+}
+
+func TestWatchBackend_auto(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+
+	defer func(old func(string) (string, error)) { filesystemType = old }(filesystemType)
+	defer func(old func() bool) { watchmanAvailable = old }(watchmanAvailable)
+	defer func(old func(string) error) { fsnotifyWorks = old }(fsnotifyWorks)
+	defer func(old func(string, string) (time.Duration, error)) { probeWatchBackend = old }(probeWatchBackend)
+	watchmanAvailable = func() bool { return true }
+	var fsnotifyErr error
+	fsnotifyWorks = func(string) error { return fsnotifyErr }
+	var probed []string
+	watchmanWorks := false
+	probeWatchBackend = func(backend, workspacePath string) (time.Duration, error) {
+		assertEqual(t, workspace, workspacePath, "Probed workspace")
+		probed = append(probed, backend)
+		if backend == watchmanBackend && watchmanWorks {
+			return 30 * time.Millisecond, nil
+		}
+		return 0, errors.New("didn't report a test change within 2s")
+	}
+
+	tooManyInstances := errors.New("too many open files")
+	for _, tc := range []struct {
+		fstype        string
+		fsnotifyErr   error
+		watchmanWorks bool
+		want          string
+		wantProbed    []string
+	}{
+		// fsnotify is used as long as it can watch the workspace, and
+		// nothing is probed.
+		{"ext4", nil, true, fsnotifyBackend, nil},
+		{"ext4", tooManyInstances, true, watchmanBackend, []string{watchmanBackend}},
+		{"ext4", tooManyInstances, false, pollBackend, []string{watchmanBackend}},
+		{"overlay", nil, false, hybridBackend, nil},
+		{"overlay", tooManyInstances, false, pollBackend, []string{watchmanBackend}},
+		// Changes made on the other side of a network filesystem are never
+		// seen, so the backends aren't even probed.
+		{"nfs", tooManyInstances, true, pollBackend, nil},
+	} {
+		fstype := tc.fstype
+		filesystemType = func(string) (string, error) { return fstype, nil }
+		fsnotifyErr, watchmanWorks, probed = tc.fsnotifyErr, tc.watchmanWorks, nil
+		backend, reason := watchBackend(workspace)
+		if backend != tc.want {
+			t.Errorf("On %s with fsnotify failing with %v, picked %s because %s, want %s", tc.fstype, tc.fsnotifyErr, backend, reason, tc.want)
+		}
+		assertEqual(t, tc.wantProbed, probed, fmt.Sprintf("Backends probed on %s with fsnotify failing with %v", tc.fstype, tc.fsnotifyErr))
+	}
+}
+
+func TestBestWatchProbe(t *testing.T) {
+	probes := []watchProbe{
+		{backend: watchmanBackend, problem: "isn't running"},
+		{backend: pollBackend, latency: 500 * time.Millisecond},
+	}
+	assertEqual(t, pollBackend, bestWatchProbe(probes).backend, "Best backend")
+	assertEqual(t, "watchman isn't running, poll looks for changes every 500ms", describeWatchProbes(probes), "Description")
+
+	probes[0] = watchProbe{backend: watchmanBackend, latency: 600 * time.Microsecond}
+	assertEqual(t, watchmanBackend, bestWatchProbe(probes).backend, "Best backend")
+	assertEqual(t, "watchman saw a test change in under 1ms, poll looks for changes every 500ms", describeWatchProbes(probes), "Description")
+}
+
+func TestIBazelCheckWatchLimit(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	defer func(old func() int) { watchLimit = old }(watchLimit)
+	watchLimit = func() int { return 2 }
+
+	out := &bytes.Buffer{}
+	log.SetWriter(out)
+	defer log.SetWriter(os.Stderr)
+
+	i.watchBackend = fsnotifyBackend
+	i.filesWatched = map[fSNotifyWatcher]map[string]struct{}{
+		i.sourceFileWatcher: {"/a/x.go": {}, "/a/y.go": {}, "/b/z.go": {}},
+	}
+	i.checkWatchLimit()
+	if !strings.Contains(out.String(), "watches 2 directories") {
+		t.Errorf("Logged %q, want a warning about the inotify limit", out)
+	}
+
+	out.Reset()
+	i.checkWatchLimit()
+	assertEqual(t, "", out.String(), "Logged after the first query")
+}
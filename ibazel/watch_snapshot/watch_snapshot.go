@@ -0,0 +1,232 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watch_snapshot records which files iBazel watches and why, so that
+// `ibazel why-not <file>` can explain a change that didn't trigger a rebuild.
+// A running iBazel saves a snapshot after every query.
+package watch_snapshot
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/temp_dir"
+)
+
+// File is a file returned by one of iBazel's queries.
+type File struct {
+	// "BUILD file", "source file" or "source directory", when there are too
+	// many source files to watch each.
+	Kind    string   `json:"kind"`
+	Queries []string `json:"queries"`
+	Targets []string `json:"targets"`
+	// Why the file isn't watched although a query returned it.
+	Error string `json:"error,omitempty"`
+}
+
+// Filtered is a file that a query returned but iBazel decided not to watch.
+type Filtered struct {
+	Label  string `json:"label"`
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason"`
+	Query  string `json:"query"`
+}
+
+type Snapshot struct {
+	Workspace string           `json:"workspace"`
+	Command   string           `json:"command"`
+	Targets   []string         `json:"targets"`
+	Time      time.Time        `json:"time"`
+	PID       int              `json:"pid"`
+	Files     map[string]*File `json:"files"`
+	Filtered  []Filtered       `json:"filtered"`
+}
+
+func New(workspace string, command string, targets []string) *Snapshot {
+	return &Snapshot{
+		Workspace: workspace,
+		Command:   command,
+		Targets:   targets,
+		Time:      time.Now(),
+		PID:       os.Getpid(),
+		Files:     map[string]*File{},
+	}
+}
+
+// Watch records that path was returned by query for targets. Like the other
+// recording methods, it does nothing on a nil Snapshot.
+func (s *Snapshot) Watch(path, kind, query string, targets []string) {
+	if s == nil {
+		return
+	}
+	f, ok := s.Files[path]
+	if !ok {
+		f = &File{Kind: kind}
+		s.Files[path] = f
+	}
+	if !contains(f.Queries, query) {
+		f.Queries = append(f.Queries, query)
+	}
+	for _, target := range targets {
+		if !contains(f.Targets, target) {
+			f.Targets = append(f.Targets, target)
+		}
+	}
+}
+
+// WatchFailed records that path couldn't be watched.
+func (s *Snapshot) WatchFailed(path, kind, query string, targets []string, err error) {
+	if s == nil {
+		return
+	}
+	s.Watch(path, kind, query, targets)
+	s.Files[path].Error = err.Error()
+}
+
+// Filter records that query returned label but iBazel doesn't watch it.
+func (s *Snapshot) Filter(label, path, reason, query string) {
+	if s == nil {
+		return
+	}
+	s.Filtered = append(s.Filtered, Filtered{Label: label, Path: path, Reason: reason, Query: query})
+}
+
+// dirFor returns the directory the snapshots of workspace are saved in.
+func dirFor(workspace string) string {
+	sum := sha1.Sum([]byte(workspace))
+	return temp_dir.Path("snapshots", hex.EncodeToString(sum[:8]))
+}
+
+// PathFor returns where the latest snapshot of `ibazel <command> <targets>` in
+// workspace is saved. Every command and set of targets has its own, so that
+// iBazels running side by side in a workspace don't overwrite each other's.
+func PathFor(workspace, command string, targets []string) string {
+	sum := sha1.Sum([]byte(command + " " + strings.Join(targets, " ")))
+	return filepath.Join(dirFor(workspace), hex.EncodeToString(sum[:8])+".json")
+}
+
+// Find returns the paths of the snapshots saved in workspace.
+func Find(workspace string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dirFor(workspace), "*.json"))
+}
+
+// Save writes the snapshot to PathFor its workspace, command and targets.
+func (s *Snapshot) Save() error {
+	path := PathFor(s.Workspace, s.Command, s.Targets)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that readers never see half of it.
+	if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Load reads a snapshot saved by Save, or by hand.
+func Load(path string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot: %v", path, err)
+	}
+	return s, nil
+}
+
+// WhyNot explains whether a change to path makes iBazel rebuild, and if not
+// why.
+func (s *Snapshot) WhyNot(path string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Snapshot of `ibazel %s %s` (pid %d) taken at %s.\n",
+		s.Command, strings.Join(s.Targets, " "), s.PID, s.Time.Format(time.Stamp))
+
+	if f, ok := s.Files[path]; ok {
+		targets := append([]string{}, f.Targets...)
+		sort.Strings(targets)
+		if f.Error != "" {
+			fmt.Fprintf(&b, "%s is a %s of %s but it couldn't be watched: %s\n", path, f.Kind, strings.Join(targets, " "), f.Error)
+		} else {
+			fmt.Fprintf(&b, "%s is watched as a %s of %s.\n", path, f.Kind, strings.Join(targets, " "))
+			if f.Kind == "BUILD file" {
+				fmt.Fprintf(&b, "Changing it requeries the build graph and reruns the command.\n")
+			} else {
+				fmt.Fprintf(&b, "Changing it reruns the command.\n")
+			}
+		}
+		for _, query := range f.Queries {
+			fmt.Fprintf(&b, "  returned by: bazel query \"%s\"\n", query)
+		}
+		return b.String()
+	}
+
+	dir, _ := filepath.Split(path)
+	if f, ok := s.Files[dir]; ok && f.Kind == "source directory" && f.Error == "" {
+		targets := append([]string{}, f.Targets...)
+		sort.Strings(targets)
+		fmt.Fprintf(&b, "%s is in %s, which is watched instead of each source file of %s because they are too many.\n", path, dir, strings.Join(targets, " "))
+		fmt.Fprintf(&b, "Changing it reruns the command for the targets that depend on it.\n")
+		for _, query := range f.Queries {
+			fmt.Fprintf(&b, "  returned by: bazel query \"%s\"\n", query)
+		}
+		return b.String()
+	}
+
+	for _, f := range s.Filtered {
+		if f.Path == path {
+			fmt.Fprintf(&b, "%s (%s) is not watched because %s.\n", path, f.Label, f.Reason)
+			fmt.Fprintf(&b, "  returned by: bazel query \"%s\"\n", f.Query)
+			return b.String()
+		}
+	}
+
+	rel, err := filepath.Rel(s.Workspace, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		fmt.Fprintf(&b, "%s is outside of the workspace %s. Files of external repositories (@repo//...) are not watched.\n", path, s.Workspace)
+		for _, f := range s.Filtered {
+			if strings.HasPrefix(f.Label, "@") || strings.HasPrefix(f.Label, "//external") {
+				fmt.Fprintf(&b, "  not watched: %s\n", f.Label)
+			}
+		}
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "%s is not watched: none of iBazel's queries returned it.\n", path)
+	fmt.Fprintf(&b, "It isn't a dependency of %s, or no rule in its package's BUILD file includes it (e.g. a glob that doesn't match it).\n", strings.Join(s.Targets, " "))
+	fmt.Fprintf(&b, "Check with: bazel query \"rdeps(set(%s), %s)\"\n", strings.Join(s.Targets, " "), filepath.ToSlash(rel))
+	return b.String()
+}
+
+func contains(l []string, e string) bool {
+	for _, i := range l {
+		if i == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch_snapshot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "watch_snapshot_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	s := New("/ws", "build", []string{"//app:server"})
+	s.Watch("/ws/app/main.go", "source file", "q", []string{"//app:server"})
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(PathFor("/ws", "build", []string{"//app:server"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Files, s.Files) || loaded.Command != "build" {
+		t.Errorf("Loaded snapshot differs.\nGot:  %#v\nWant: %#v", loaded, s)
+	}
+
+	// Another iBazel in the same workspace keeps its own snapshot.
+	if err := New("/ws", "test", []string{"//app:server_test"}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := Find("/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Errorf("Find() = %v, want the snapshots of both commands", paths)
+	}
+}
+
+func TestWhyNot(t *testing.T) {
+	s := New("/ws", "build", []string{"//app:server"})
+	s.Watch("/ws/app/BUILD", "BUILD file", "buildfiles(deps(set(//app:server)))", []string{"//app:server"})
+	s.Watch("/ws/app/main.go", "source file", "kind('source file', deps(set(//app:server)))", []string{"//app:server"})
+	s.WatchFailed("/ws/lib/lib.go", "source file", "kind('source file', deps(set(//app:server)))", []string{"//app:server"}, errors.New("too many open files"))
+	s.Watch("/ws/huge/", "source directory", "kind('source file', deps(set(//app:server)))", []string{"//app:server"})
+	s.Filter("@maven//:guava.jar", "", "it is in an external repository", "kind('source file', deps(set(//app:server)))")
+	s.Filter("//app:gen", "/ws/bazel-bin/app/gen", "it is a build output", "kind('source file', deps(set(//app:server)))")
+
+	for path, expected := range map[string]string{
+		"/ws/app/main.go":       "is watched as a source file of //app:server.\nChanging it reruns the command.",
+		"/ws/app/BUILD":         "Changing it requeries the build graph",
+		"/ws/lib/lib.go":        "couldn't be watched: too many open files",
+		"/ws/huge/new.go":       "is in /ws/huge/, which is watched instead of each source file of //app:server",
+		"/ws/bazel-bin/app/gen": "(//app:gen) is not watched because it is a build output",
+		"/elsewhere/guava.jar":  "is outside of the workspace /ws",
+		"/ws/app/README.md":     `bazel query "rdeps(set(//app:server), app/README.md)"`,
+	} {
+		if why := s.WhyNot(path); !strings.Contains(why, expected) {
+			t.Errorf("WhyNot(%q) = %q, expected it to contain %q", path, why, expected)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+var watchdogTimeout = flag.Duration("watchdog_timeout", 30*time.Second, "Restart the file watchers when the goroutines forwarding file changes are stuck for this long while iBazel waits for changes, or when one of them panicked. 0 disables")
+
+// forwarder tracks whether a goroutine that forwards file changes to the main
+// loop, like the watchDispatcher, keeps forwarding them.
+type forwarder struct {
+	name string
+	// Closed when the goroutine is replaced, so that it stops sending a
+	// change nobody receives anymore.
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu sync.Mutex // guards the fields below
+	// When the goroutine started sending the change it is sending, zero while
+	// it isn't sending one.
+	sendingSince time.Time
+	// What the goroutine panicked with, if it did.
+	panicked interface{}
+}
+
+func newForwarder(name string) *forwarder {
+	return &forwarder{name: name, done: make(chan struct{})}
+}
+
+// sending is called before the goroutine sends a change.
+func (f *forwarder) sending() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sendingSince = time.Now()
+}
+
+// sent is called once the change was received.
+func (f *forwarder) sent() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sendingSince = time.Time{}
+}
+
+// stop tells the goroutine to stop forwarding changes.
+func (f *forwarder) stop() {
+	f.stopOnce.Do(func() { close(f.done) })
+}
+
+// stopped is closed once stop was called. The goroutine sends every change
+// in a select that returns when it is.
+func (f *forwarder) stopped() <-chan struct{} {
+	return f.done
+}
+
+// recover is deferred by the goroutine, so that a panic stops it instead of
+// iBazel, and the watchdog notices.
+func (f *forwarder) recover() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Errorf("The %s panicked: %v\n%s", f.name, r, debug.Stack())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.panicked = r
+}
+
+// stalled returns why the goroutine stopped forwarding changes, or "" if it
+// didn't. The main loop has been waiting for changes since waitingSince, and
+// would have received any change sent since then. It is zero while the main
+// loop runs a command, when the goroutine can't be stuck.
+func (f *forwarder) stalled(waitingSince time.Time, timeout time.Duration) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.panicked != nil {
+		return fmt.Sprintf("the %s panicked: %v", f.name, f.panicked)
+	}
+	if f.sendingSince.IsZero() || waitingSince.IsZero() {
+		return ""
+	}
+	since := f.sendingSince
+	if waitingSince.After(since) {
+		since = waitingSince
+	}
+	if stuck := time.Since(since); stuck > timeout {
+		return fmt.Sprintf("the %s has been stuck sending a change for %s", f.name, stuck.Round(time.Second))
+	}
+	return ""
+}
+
+// watchdogState records whether the main loop is waiting for changes, which
+// is when the forwarders can't be stuck.
+func (i *IBazel) watchdogState(state State) {
+	i.watchdogMu.Lock()
+	defer i.watchdogMu.Unlock()
+	if state != WAIT {
+		i.waitingSince = time.Time{}
+	} else if i.waitingSince.IsZero() {
+		i.waitingSince = time.Now()
+	}
+}
+
+// watchdog is what the watchdog goroutine knows about the alarm it raised.
+type watchdog struct {
+	// When the alarm the main loop hasn't taken yet was raised, and whether
+	// the main loop was reported as stuck since.
+	raised   time.Time
+	reported bool
+}
+
+// startWatchdog checks the forwarders every half --watchdog_timeout until
+// Cleanup. The checks run in their own goroutine, so that they also notice
+// when the main loop itself is stuck.
+func (i *IBazel) startWatchdog() {
+	i.watchdogAlarms = make(chan string, 1)
+	if *watchdogTimeout <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	i.watchdogStop = stop
+	go func() {
+		ticker := time.NewTicker(*watchdogTimeout / 2)
+		defer ticker.Stop()
+		w := &watchdog{}
+		for {
+			select {
+			case <-ticker.C:
+				i.checkWatchdog(w, time.Now())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopWatchdog stops the watchdog goroutine.
+func (i *IBazel) stopWatchdog() {
+	i.watchdogMu.Lock()
+	defer i.watchdogMu.Unlock()
+	if i.watchdogStop != nil {
+		close(i.watchdogStop)
+		i.watchdogStop = nil
+	}
+}
+
+// checkWatchdog hands the reason to restart the file watchers to the main
+// loop if one of the forwarders stopped forwarding changes, see
+// restartWatchers, and reports the main loop as stuck if it doesn't take it
+// within --watchdog_timeout while it is waiting for changes.
+func (i *IBazel) checkWatchdog(w *watchdog, now time.Time) {
+	i.watchdogMu.Lock()
+	reason := ""
+	for _, f := range i.forwarders {
+		if reason = f.stalled(i.waitingSince, *watchdogTimeout); reason != "" {
+			break
+		}
+	}
+	waitingSince := i.waitingSince
+	i.watchdogMu.Unlock()
+
+	// Only this goroutine sends alarms, so an alarm that is still there is
+	// the one it raised.
+	if len(i.watchdogAlarms) == 0 {
+		*w = watchdog{}
+		if reason != "" {
+			i.watchdogAlarms <- reason
+			w.raised = now
+		}
+		return
+	}
+	if waitingSince.IsZero() || w.reported {
+		// The main loop takes the alarm once the command it runs is done.
+		return
+	}
+	since := w.raised
+	if waitingSince.After(since) {
+		since = waitingSince
+	}
+	if stuck := now.Sub(since); stuck > *watchdogTimeout {
+		w.reported = true
+		log.Errorf("Watchdog: the main loop has been stuck for %s, file changes aren't picked up until it is done", stuck.Round(time.Second))
+		buf := make([]byte, 1<<20)
+		log.Debugf("The goroutines of iBazel:\n%s", buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// restartWatchers restarts the file watchers, for the reason the watchdog
+// gave, instead of silently no longer rebuilding. The files to watch are
+// queried again for the new watchers.
+func (i *IBazel) restartWatchers(reason string) {
+	log.Errorf("Watchdog: %s. Restarting the file watchers...", reason)
+	i.recordEvent("watchdog: %s", reason)
+
+	i.watchdogMu.Lock()
+	for _, f := range i.forwarders {
+		f.stop()
+	}
+	i.buildFileWatcher.Close()
+	i.sourceFileWatcher.Close()
+	err := i.setup()
+	i.watchdogMu.Unlock()
+	if err != nil {
+		log.Errorf("Error restarting the file watchers: %v", err)
+		return
+	}
+
+	i.filesWatched = map[fSNotifyWatcher]map[string]struct{}{}
+	i.watchedKeys = map[fSNotifyWatcher]map[string]string{}
+	for _, l := range i.lifecycleListeners {
+		if wl, ok := l.(WatchdogListener); ok {
+			i.callListener(l, "WatchersRestarted", func() { wl.WatchersRestarted(reason) })
+		}
+	}
+	i.startIteration(triggerCrashRestart)
+	i.state = QUERY
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// watchdogRecorder is a listener that records why the watchers were
+// restarted.
+type watchdogRecorder struct {
+	phaseRecorder
+	reasons []string
+}
+
+func (r *watchdogRecorder) WatchersRestarted(reason string) {
+	r.reasons = append(r.reasons, reason)
+}
+
+func TestForwarderStalled(t *testing.T) {
+	f := newForwarder("forwarder")
+	assertEqual(t, "", f.stalled(time.Time{}, time.Second), "An idle forwarder")
+
+	f.sending()
+	f.sendingSince = time.Now().Add(-time.Minute)
+	assertEqual(t, "", f.stalled(time.Now(), time.Second), "A forwarder sending since before the main loop waited")
+	if reason := f.stalled(time.Now().Add(-time.Minute), time.Second); !strings.Contains(reason, "stuck sending a change") {
+		t.Errorf("Wanted a stuck forwarder, got %q", reason)
+	}
+	f.sent()
+	assertEqual(t, "", f.stalled(time.Now().Add(-time.Minute), time.Second), "A forwarder that sent its change")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer f.recover()
+		panic("oops")
+	}()
+	<-done
+	assertEqual(t, "the forwarder panicked: oops", f.stalled(time.Now(), time.Second), "A forwarder that panicked")
+}
+
+func TestIBazelCheckWatchdog(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.stopWatchdog()
+
+	recorder := &watchdogRecorder{}
+	i.lifecycleListeners = []Lifecycle{recorder}
+	sourceFileWatcher := i.sourceFileWatcher
+	oldForwarders := i.forwarders
+	i.filesWatched[sourceFileWatcher] = map[string]struct{}{"/ws/main.go": struct{}{}}
+	i.state = WAIT
+	i.watchdogState(i.state)
+
+	w := &watchdog{}
+	i.checkWatchdog(w, time.Now())
+	assertEqual(t, 0, len(i.watchdogAlarms), "Alarms with healthy forwarders")
+
+	f := newForwarder("forwarder")
+	f.panicked = "oops"
+	i.forwarders = append(i.forwarders, f)
+	i.checkWatchdog(w, time.Now())
+	i.restartWatchers(<-i.watchdogAlarms)
+	assertEqual(t, QUERY, i.state, "State after the watchers were restarted")
+	assertEqual(t, []string{"the forwarder panicked: oops"}, recorder.reasons, "Reasons")
+	if i.sourceFileWatcher == sourceFileWatcher {
+		t.Errorf("The source file watcher wasn't replaced")
+	}
+	assertEqual(t, 0, len(i.filesWatched), "Files watched by the old watchers")
+	assertEqual(t, 2, len(i.forwarders), "Forwarders of the new watchers")
+	for _, f := range oldForwarders {
+		select {
+		case <-f.stopped():
+		default:
+			t.Errorf("The %s of the old watchers wasn't stopped", f.name)
+		}
+	}
+}
+
+func TestIBazelCheckWatchdog_stuckMainLoop(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.stopWatchdog()
+
+	i.state = WAIT
+	i.watchdogState(i.state)
+	f := newForwarder("forwarder")
+	f.panicked = "oops"
+	i.forwarders = []*forwarder{f}
+
+	now := time.Now()
+	w := &watchdog{}
+	i.checkWatchdog(w, now)
+	i.checkWatchdog(w, now.Add(*watchdogTimeout/2))
+	if w.reported {
+		t.Errorf("The main loop was reported as stuck before --watchdog_timeout")
+	}
+	i.checkWatchdog(w, now.Add(2**watchdogTimeout))
+	if !w.reported {
+		t.Errorf("The main loop that doesn't take the alarm wasn't reported as stuck")
+	}
+	assertEqual(t, 1, len(i.watchdogAlarms), "Alarms")
+
+	<-i.watchdogAlarms
+	i.checkWatchdog(w, now.Add(3**watchdogTimeout))
+	if w.reported {
+		t.Errorf("The main loop is still reported as stuck after it took the alarm")
+	}
+}
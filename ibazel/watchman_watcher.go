@@ -0,0 +1,359 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+)
+
+// The name of the subscription iBazel makes.
+const watchmanSubscription = "ibazel"
+
+// watchmanSockname finds the socket of the Watchman daemon, starting it if it
+// isn't running yet.
+var watchmanSockname = func() (string, error) {
+	if sock := os.Getenv("WATCHMAN_SOCK"); sock != "" {
+		return sock, nil
+	}
+	out, err := exec.Command("watchman", "--output-encoding=json", "--no-pretty", "get-sockname").Output()
+	if err != nil {
+		return "", fmt.Errorf("running watchman get-sockname: %v", err)
+	}
+	var res struct {
+		Sockname string `json:"sockname"`
+		Error    string `json:"error"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return "", err
+	}
+	if res.Error != "" {
+		return "", errors.New(res.Error)
+	}
+	return res.Sockname, nil
+}
+
+// watchmanFile is a file in a subscription PDU of Watchman.
+type watchmanFile struct {
+	Name   string `json:"name"`
+	Exists bool   `json:"exists"`
+	New    bool   `json:"new"`
+}
+
+// watchmanPDU holds the fields of the responses and the subscription PDUs of
+// Watchman that iBazel looks at.
+type watchmanPDU struct {
+	Error string `json:"error"`
+	Log   string `json:"log"`
+
+	// The response to watch-project.
+	Watch        string `json:"watch"`
+	RelativePath string `json:"relative_path"`
+
+	Subscription    string         `json:"subscription"`
+	Files           []watchmanFile `json:"files"`
+	IsFreshInstance bool           `json:"is_fresh_instance"`
+}
+
+// watchmanWatcher is a fSNotifyWatcher that subscribes to the changes in the
+// workspace of a running Watchman daemon, see --watch_backend. Watchman
+// watches the whole workspace with a single subscription and recovers from
+// overflowing kernel queues by itself, so Add and Remove only decide which of
+// its changes are forwarded, like the ones of fsnotify: the ones of watched
+// files and of the files right inside of watched directories. Paths outside of
+// the workspace, e.g. the ones of --override_repository, are watched with
+// fsnotify instead.
+type watchmanWatcher struct {
+	workspacePath string
+
+	conn    net.Conn
+	decoder *json.Decoder
+
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	lock    sync.Mutex // guards watches and outside
+	watches map[string]struct{}
+	// Watches the paths outside of the workspace, created by the first Add
+	// of one.
+	outside *fsnotify.Watcher
+}
+
+var _ fSNotifyWatcher = &watchmanWatcher{}
+
+func newWatchmanWatcher(workspacePath string) (*watchmanWatcher, error) {
+	if workspacePath == "" {
+		return nil, errors.New("no workspace to watch")
+	}
+	sock, err := watchmanSockname()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	w := &watchmanWatcher{
+		workspacePath: workspacePath,
+		conn:          conn,
+		decoder:       json.NewDecoder(conn),
+		events:        make(chan fsnotify.Event),
+		errors:        make(chan error),
+		done:          make(chan struct{}),
+		watches:       map[string]struct{}{},
+	}
+
+	watch, err := w.call("watch-project", workspacePath)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	query := map[string]interface{}{
+		"fields": []string{"name", "exists", "new"},
+		// The files that exist when subscribing aren't changes.
+		"empty_on_fresh_instance": true,
+	}
+	if watch.RelativePath != "" {
+		query["relative_root"] = watch.RelativePath
+	}
+	if _, err := w.call("subscribe", watch.Watch, watchmanSubscription, query); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.loop()
+	return w, nil
+}
+
+// call sends a command to Watchman and returns its response.
+func (w *watchmanWatcher) call(command ...interface{}) (*watchmanPDU, error) {
+	if err := json.NewEncoder(w.conn).Encode(command); err != nil {
+		return nil, err
+	}
+	for {
+		var pdu watchmanPDU
+		if err := w.decoder.Decode(&pdu); err != nil {
+			return nil, err
+		}
+		if pdu.Log != "" || pdu.Subscription != "" {
+			// Unilateral PDUs can come before the response.
+			continue
+		}
+		if pdu.Error != "" {
+			return nil, fmt.Errorf("watchman %v: %s", command[0], pdu.Error)
+		}
+		return &pdu, nil
+	}
+}
+
+func (w *watchmanWatcher) loop() {
+	defer w.wg.Done()
+
+	for {
+		var pdu watchmanPDU
+		if err := w.decoder.Decode(&pdu); err != nil {
+			select {
+			case <-w.done:
+			case w.errors <- fmt.Errorf("connection to Watchman lost: %v", err):
+			}
+			return
+		}
+		if pdu.Error != "" {
+			select {
+			case <-w.done:
+				return
+			case w.errors <- fmt.Errorf("watchman: %s", pdu.Error):
+			}
+			continue
+		}
+		if pdu.Subscription != watchmanSubscription {
+			continue
+		}
+
+		for _, e := range w.translate(&pdu) {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// translate returns the events for the changes in a subscription PDU.
+func (w *watchmanWatcher) translate(pdu *watchmanPDU) []fsnotify.Event {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var events []fsnotify.Event
+	if pdu.IsFreshInstance {
+		// Watchman recrawled the workspace, e.g. because the kernel dropped
+		// events, and can't tell what changed. Assume that everything did.
+		log.Logf("Watchman recrawled the workspace, looking at all watched files again")
+		for name := range w.watches {
+			events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+		}
+		return events
+	}
+
+	for _, f := range pdu.Files {
+		name := filepath.Join(w.workspacePath, filepath.FromSlash(f.Name))
+		if !w.watched(name) {
+			continue
+		}
+		op := fsnotify.Write
+		switch {
+		case !f.Exists:
+			op = fsnotify.Remove
+		case f.New:
+			op = fsnotify.Create
+		}
+		events = append(events, fsnotify.Event{Name: name, Op: op})
+	}
+	return events
+}
+
+// watched returns whether changes to name are forwarded. w.lock must be held.
+func (w *watchmanWatcher) watched(name string) bool {
+	if _, ok := w.watches[name]; ok {
+		return true
+	}
+	_, ok := w.watches[filepath.Dir(name)]
+	return ok
+}
+
+func (w *watchmanWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	err := w.conn.Close()
+	w.lock.Lock()
+	if w.outside != nil {
+		w.outside.Close()
+	}
+	w.lock.Unlock()
+	w.wg.Wait()
+	close(w.events)
+	close(w.errors)
+	return err
+}
+
+func (w *watchmanWatcher) Add(name string) error {
+	name = filepath.Clean(name)
+	if _, err := os.Stat(name); err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.inWorkspace(name) {
+		return w.addOutside(name)
+	}
+	w.watches[name] = struct{}{}
+	return nil
+}
+
+// inWorkspace returns whether Watchman reports the changes of name.
+func (w *watchmanWatcher) inWorkspace(name string) bool {
+	rel, err := filepath.Rel(w.workspacePath, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// addOutside watches a path outside of the workspace with fsnotify, whose
+// events and errors are forwarded along with the ones of Watchman. w.lock
+// must be held.
+func (w *watchmanWatcher) addOutside(name string) error {
+	if w.outside == nil {
+		outside, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		log.Debugf("Watching the paths outside of %s with fsnotify", w.workspacePath)
+		w.outside = outside
+		w.wg.Add(1)
+		go w.forward(outside)
+	}
+	return w.outside.Add(name)
+}
+
+// forward forwards the events and errors of the fsnotify watcher of the paths
+// outside of the workspace until it is closed.
+func (w *watchmanWatcher) forward(outside *fsnotify.Watcher) {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case e, ok := <-outside.Events:
+			if !ok {
+				return
+			}
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		case err, ok := <-outside.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case w.errors <- err:
+			case <-w.done:
+				return
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *watchmanWatcher) Remove(name string) error {
+	name = filepath.Clean(name)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.inWorkspace(name) {
+		if w.outside == nil {
+			return fmt.Errorf("can't remove non-existent watchman watch for: %s", name)
+		}
+		return w.outside.Remove(name)
+	}
+	if _, ok := w.watches[name]; !ok {
+		return fmt.Errorf("can't remove non-existent watchman watch for: %s", name)
+	}
+	delete(w.watches, name)
+	return nil
+}
+
+func (w *watchmanWatcher) Events() chan fsnotify.Event { return w.events }
+func (w *watchmanWatcher) Errors() chan error          { return w.errors }
+func (w *watchmanWatcher) Watcher() *fsnotify.Watcher  { return nil }
@@ -0,0 +1,176 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeWatchman answers the commands of a watchmanWatcher like Watchman does
+// for a workspace in the "ws" directory of the watched root, and sends the
+// PDUs given to it to the subscription.
+func fakeWatchman(t *testing.T, root string) (string, chan<- interface{}, func()) {
+	dir, err := ioutil.TempDir("", "watchman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdus := make(chan interface{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+		for _, response := range []interface{}{
+			map[string]string{"watch": root, "relative_path": "ws"},
+			map[string]string{"subscribe": watchmanSubscription},
+		} {
+			var command []interface{}
+			if err := decoder.Decode(&command); err != nil {
+				return
+			}
+			if command[0] == "subscribe" {
+				if query := command[3].(map[string]interface{}); query["relative_root"] != "ws" {
+					t.Errorf("Subscribed with %v, want the relative_root ws", query)
+				}
+			}
+			// Unilateral PDUs can come before responses.
+			encoder.Encode(map[string]string{"log": "hello"})
+			encoder.Encode(response)
+		}
+		for pdu := range pdus {
+			encoder.Encode(pdu)
+		}
+	}()
+
+	return sock, pdus, func() {
+		close(pdus)
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWatchmanWatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "watchman_root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	workspace := filepath.Join(root, "ws")
+	if err := os.MkdirAll(filepath.Join(workspace, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	build := filepath.Join(workspace, "BUILD")
+	if err := ioutil.WriteFile(build, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sock, pdus, stop := fakeWatchman(t, root)
+	defer stop()
+	oldWatchmanSockname := watchmanSockname
+	watchmanSockname = func() (string, error) { return sock, nil }
+	defer func() { watchmanSockname = oldWatchmanSockname }()
+
+	w, err := newWatchmanWatcher(workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Add(build); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(filepath.Join(workspace, "pkg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(filepath.Join(workspace, "missing")); err == nil {
+		t.Error("Adding a missing directory should have failed")
+	}
+
+	pdus <- map[string]interface{}{
+		"subscription": watchmanSubscription,
+		"files": []map[string]interface{}{
+			{"name": "other/a.go", "exists": true},
+			{"name": "pkg/a.go", "exists": true},
+			{"name": "pkg/b.go", "exists": true, "new": true},
+			{"name": "pkg/sub/c.go", "exists": true},
+			{"name": "BUILD", "exists": false},
+		},
+	}
+	expectEvent(t, w, fsnotify.Event{Name: filepath.Join(workspace, "pkg", "a.go"), Op: fsnotify.Write})
+	expectEvent(t, w, fsnotify.Event{Name: filepath.Join(workspace, "pkg", "b.go"), Op: fsnotify.Create})
+	expectEvent(t, w, fsnotify.Event{Name: build, Op: fsnotify.Remove})
+
+	if err := w.Remove(filepath.Join(workspace, "pkg")); err != nil {
+		t.Fatal(err)
+	}
+	pdus <- map[string]interface{}{
+		"subscription":      watchmanSubscription,
+		"is_fresh_instance": true,
+	}
+	expectEvent(t, w, fsnotify.Event{Name: build, Op: fsnotify.Write})
+
+	got := w.translate(&watchmanPDU{Subscription: watchmanSubscription, Files: []watchmanFile{{Name: "pkg/a.go", Exists: true}}})
+	if !reflect.DeepEqual(got, []fsnotify.Event(nil)) {
+		t.Errorf("Got events %v for a directory that isn't watched anymore", got)
+	}
+	// Watchman only reports the changes in the workspace, the ones outside of
+	// it come from fsnotify.
+	external := filepath.Join(root, "external")
+	if err := os.Mkdir(external, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(external); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(external, "lib.go")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, fsnotify.Event{Name: file, Op: fsnotify.Create})
+	if err := w.Remove(external); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewWatcher_watchmanFallback(t *testing.T) {
+	oldWatchmanSockname := watchmanSockname
+	watchmanSockname = func() (string, error) { return "/nonexistent/watchman.sock", nil }
+	defer func() { watchmanSockname = oldWatchmanSockname }()
+
+	w, err := newWatcher(watchmanBackend, "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, ok := w.(*realFSNotifyWatcher); !ok {
+		t.Errorf("newWatcher() = %T, want fsnotify when Watchman isn't running", w)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/log"
+	"github.com/bazelbuild/bazel-watcher/ibazel/watch_snapshot"
+	"github.com/bazelbuild/bazel-watcher/ibazel/workspace_finder"
+)
+
+var snapshotFile = flag.String("snapshot", "", "The watch snapshot `ibazel why-not` reads, instead of the latest one of the workspace")
+
+// startSnapshot starts recording what the query that is about to run decides
+// to watch. The snapshot replaces the previous one once the query succeeded,
+// see saveSnapshot, so that a failed query doesn't leave it empty.
+func (i *IBazel) startSnapshot(command string, targets []string) {
+	workspacePath, _ := i.workspaceFinder.FindWorkspace()
+	i.querySnapshot = watch_snapshot.New(workspacePath, command, targets)
+}
+
+// saveSnapshot replaces the snapshot with the one of the query that just
+// succeeded and saves it for `ibazel why-not`.
+func (i *IBazel) saveSnapshot() {
+	if i.querySnapshot != nil {
+		i.snapshot = i.querySnapshot
+		i.querySnapshot = nil
+	}
+	if i.readOnly.temp || i.snapshot == nil {
+		return
+	}
+	if err := i.snapshot.Save(); err != nil {
+		log.Errorf("Error saving the watch snapshot: %v", err)
+	}
+}
+
+// whyNot implements `ibazel why-not <file>...`: it explains, from the watch
+// snapshots saved by the iBazels running in this workspace, whether changing
+// each file triggers a rebuild.
+func whyNot(w io.Writer, workspaceFinder workspace_finder.WorkspaceFinder, files []string) error {
+	paths := []string{*snapshotFile}
+	if *snapshotFile == "" {
+		workspacePath, err := workspaceFinder.FindWorkspace()
+		if err != nil {
+			return err
+		}
+		if paths, err = watch_snapshot.Find(workspacePath); err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no watch snapshot of %s, is iBazel running in this workspace?", workspacePath)
+		}
+	}
+
+	var snapshots []*watch_snapshot.Snapshot
+	for _, path := range paths {
+		snapshot, err := watch_snapshot.Load(path)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no watch snapshot at %s, is iBazel running in this workspace?", path)
+		} else if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	// The latest first.
+	sort.Slice(snapshots, func(a, b int) bool {
+		return snapshots[a].Time.After(snapshots[b].Time)
+	})
+
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			fmt.Fprintln(w, snapshot.WhyNot(abs))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/watch_snapshot"
+)
+
+func TestWhyNot(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ibazel_why_not")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	workspace := filepath.Join(tmp, "ws")
+	snapshot := watch_snapshot.New(workspace, "build", []string{"//app:server"})
+	snapshot.Watch(filepath.Join(workspace, "app", "main.go"), "source file", "q", []string{"//app:server"})
+	if err := snapshot.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := whyNot(out, dirWorkspaceFinder(workspace), []string{filepath.Join(workspace, "app", "main.go")}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "is watched as a source file of //app:server") {
+		t.Errorf("Unexpected explanation: %s", out.String())
+	}
+
+	if err := whyNot(out, dirWorkspaceFinder(filepath.Join(tmp, "other")), []string{"main.go"}); err == nil || !strings.Contains(err.Error(), "is iBazel running") {
+		t.Errorf("Expected an error without a snapshot, got %v", err)
+	}
+}
+
+func TestIBazelSnapshotKeptOnFailedQuery(t *testing.T) {
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.readOnly.temp = true
+
+	i.startSnapshot("build", []string{"//app:server"})
+	i.querySnapshot.Watch("/ws/app/main.go", "source file", "q", []string{"//app:server"})
+	i.saveSnapshot()
+	saved := i.snapshot
+
+	// A query that fails never gets to saveSnapshot.
+	i.startSnapshot("build", []string{"//app:server"})
+	assertEqual(t, saved, i.snapshot, "Snapshot while querying")
+
+	i.startSnapshot("build", []string{"//app:server"})
+	i.saveSnapshot()
+	if i.snapshot == saved {
+		t.Errorf("The snapshot of a successful query didn't replace the previous one")
+	}
+}
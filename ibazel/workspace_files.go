@@ -0,0 +1,49 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/bazel-watcher/ibazel/ignore"
+)
+
+// workspaceFiles are the files at the root of the workspace that change the
+// build graph, but that the query for BUILD files doesn't return, e.g. the
+// MODULE.bazel of bzlmod and its lock file. The ignore files change which
+// files are watched.
+var workspaceFiles = []string{"MODULE.bazel", "MODULE.bazel.lock", "REPO.bazel", "WORKSPACE", "WORKSPACE.bazel", "WORKSPACE.bzlmod", ignore.BazelIgnore, ignore.IBazelIgnore}
+
+// withWorkspaceFiles adds the workspaceFiles that exist to the files to watch
+// for changes to the build graph. A file that doesn't exist yet is watched
+// from the next query on, so that MODULE.bazel.lock, which the first build
+// creates, doesn't requery and rebuild right away.
+func (i *IBazel) withWorkspaceFiles(toWatch []string) []string {
+	if i.workspaceFinder == nil {
+		return toWatch
+	}
+	workspacePath, err := i.workspaceFinder.FindWorkspace()
+	if err != nil || workspacePath == "" {
+		return toWatch
+	}
+	for _, name := range workspaceFiles {
+		path := filepath.Join(workspacePath, name)
+		if _, err := os.Stat(path); err == nil {
+			toWatch = append(toWatch, path)
+		}
+	}
+	return toWatch
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIBazelWithWorkspaceFiles(t *testing.T) {
+	workspace, err := ioutil.TempDir("", "ibazel_workspace_files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspace)
+	for _, name := range []string{"MODULE.bazel", "REPO.bazel", ".ibazelignore"} {
+		if err := ioutil.WriteFile(filepath.Join(workspace, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i := newIBazel(t)
+	defer i.Cleanup()
+	i.workspaceFinder = dirWorkspaceFinder(workspace)
+
+	build := filepath.Join(workspace, "BUILD")
+	assertEqual(t, []string{
+		build,
+		filepath.Join(workspace, "MODULE.bazel"),
+		filepath.Join(workspace, "REPO.bazel"),
+		filepath.Join(workspace, ".ibazelignore"),
+	}, i.withWorkspaceFiles([]string{build}), "Files to watch")
+
+	// The first build creates the lock file.
+	if err := ioutil.WriteFile(filepath.Join(workspace, "MODULE.bazel.lock"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, []string{
+		build,
+		filepath.Join(workspace, "MODULE.bazel"),
+		filepath.Join(workspace, "MODULE.bazel.lock"),
+		filepath.Join(workspace, "REPO.bazel"),
+		filepath.Join(workspace, ".ibazelignore"),
+	}, i.withWorkspaceFiles([]string{build}), "Files to watch after the first build")
+
+	for _, name := range []string{"MODULE.bazel.lock", "REPO.bazel", "BUILD.bazel", "defs.bzl"} {
+		if !isGraphFile(filepath.Join(workspace, name)) {
+			t.Errorf("%s doesn't change the build graph", name)
+		}
+	}
+}
@@ -24,6 +24,10 @@ type WorkspaceFinder interface {
 	FindWorkspace() (string, error)
 }
 
+// Markers are the files that mark the root of a workspace. A bzlmod-only
+// workspace may have just a MODULE.bazel, or a REPO.bazel, and no WORKSPACE.
+var Markers = []string{"MODULE.bazel", "REPO.bazel", "WORKSPACE.bazel", "WORKSPACE"}
+
 type MainWorkspaceFinder struct{}
 
 func (m *MainWorkspaceFinder) FindWorkspace() (string, error) {
@@ -31,7 +35,12 @@ func (m *MainWorkspaceFinder) FindWorkspace() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return findWorkspace(path)
+}
 
+// findWorkspace returns the closest directory containing path that is the
+// root of a workspace.
+func findWorkspace(path string) (string, error) {
 	volume := filepath.VolumeName(path)
 
 	for {
@@ -41,12 +50,10 @@ func (m *MainWorkspaceFinder) FindWorkspace() (string, error) {
 		}
 
 		// Check if we're at the workspace path
-		if _, err := os.Stat(filepath.Join(path, "WORKSPACE")); !os.IsNotExist(err) {
-			return path, nil
-		}
-
-		if _, err := os.Stat(filepath.Join(path, "WORKSPACE.bazel")); !os.IsNotExist(err) {
-			return path, nil
+		for _, marker := range Markers {
+			if info, err := os.Stat(filepath.Join(path, marker)); err == nil && !info.IsDir() {
+				return path, nil
+			}
 		}
 
 		// If we've reached the root, then we know the cwd isn't within a workspace
@@ -0,0 +1,51 @@
+// Copyright 2020 The Bazel Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace_finder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindWorkspace(t *testing.T) {
+	for _, marker := range Markers {
+		dir, err := ioutil.TempDir("", "ibazel_workspace_finder")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		// Resolve the symlinks of the temporary directory, as on macOS.
+		dir, err = filepath.EvalSymlinks(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkg := filepath.Join(dir, "path", "to", "pkg")
+		if err := os.MkdirAll(pkg, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, marker), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := findWorkspace(pkg)
+		if err != nil {
+			t.Errorf("findWorkspace with a %s: %v", marker, err)
+		} else if got != dir {
+			t.Errorf("findWorkspace with a %s = %q, wanted %q", marker, got, dir)
+		}
+	}
+}